@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -29,10 +31,16 @@ func main() {
 		masterKey = flag.String("master-key", "", "master key for authentication")
 		username  = flag.String("username", "", "username for user authentication")
 		password  = flag.String("password", "", "password for user authentication")
-		command   = flag.String("cmd", "", "command to execute (login, get, post, put, delete)")
+		command   = flag.String("cmd", "", "command to execute (login, get, post, put, delete, export, import)")
 		resource  = flag.String("resource", "", "resource/collection name")
 		id        = flag.String("id", "", "resource ID (for get, put, delete)")
 		data      = flag.String("data", "", "JSON data (for post, put)")
+		format    = flag.String("format", "ndjson", "file format: ndjson, csv or extjson (for export, import)")
+		filter    = flag.String("filter", "", "JSON query selecting the documents (for export)")
+		file      = flag.String("file", "", "file to write (export) or read (import); defaults to stdout/stdin")
+		runEvents = flag.Bool("events", false, "run the collection's events for each document (for import)")
+		upsert    = flag.Bool("upsert", false, "update documents whose id exists instead of failing them (for import)")
+		ordered   = flag.Bool("ordered", false, "stop at the first document that fails (for import)")
 	)
 	flag.Parse()
 
@@ -110,12 +118,42 @@ func main() {
 			os.Exit(1)
 		}
 
+	case "export":
+		if *resource == "" {
+			fmt.Fprintf(os.Stderr, "Error: resource required\n")
+			os.Exit(1)
+		}
+		cli.loadToken()
+		if err := cli.export(*resource, *format, *filter, *file); err != nil {
+			fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "import":
+		if *resource == "" {
+			fmt.Fprintf(os.Stderr, "Error: resource required\n")
+			os.Exit(1)
+		}
+		cli.loadToken()
+		params := url.Values{}
+		params.Set("format", *format)
+		params.Set("events", strconv.FormatBool(*runEvents))
+		params.Set("upsert", strconv.FormatBool(*upsert))
+		params.Set("ordered", strconv.FormatBool(*ordered))
+		if err := cli.importFile(*resource, params, *file); err != nil {
+			fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+			os.Exit(1)
+		}
+
 	default:
 		fmt.Fprintf(os.Stderr, "Usage: deployd-cli -cmd=<command> [options]\n")
-		fmt.Fprintf(os.Stderr, "Commands: login, get, post, put, delete\n")
+		fmt.Fprintf(os.Stderr, "Commands: login, get, post, put, delete, export, import\n")
 		fmt.Fprintf(os.Stderr, "\nAuthentication:\n")
 		fmt.Fprintf(os.Stderr, "  Login with master key: -cmd=login -master-key=<key>\n")
 		fmt.Fprintf(os.Stderr, "  Login with user/pass:  -cmd=login -username=<user> -password=<pass>\n")
+		fmt.Fprintf(os.Stderr, "\nExport and import require a master key login:\n")
+		fmt.Fprintf(os.Stderr, "  -cmd=export -resource=<name> [-format=csv] [-filter='{...}'] [-file=<out>]\n")
+		fmt.Fprintf(os.Stderr, "  -cmd=import -resource=<name> [-format=csv] [-file=<in>] [-events] [-upsert] [-ordered]\n")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
@@ -213,3 +251,82 @@ func (c *CLI) request(method, path string, body io.Reader) error {
 	fmt.Println(string(output))
 	return nil
 }
+
+// export downloads a collection through the admin API to a file, or to
+// stdout if file is empty
+func (c *CLI) export(resource, format, filter, file string) error {
+	params := url.Values{}
+	params.Set("format", format)
+	if filter != "" {
+		params.Set("filter", filter)
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/_admin/collections/%s/export?%s", c.baseURL, url.PathEscape(resource), params.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	out := os.Stdout
+	if file != "" {
+		out, err = os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+// importFile uploads a file, or stdin if file is empty, to a collection
+// through the admin API and prints the result
+func (c *CLI) importFile(resource string, params url.Values, file string) error {
+	in := os.Stdin
+	if file != "" {
+		var err error
+		in, err = os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/_admin/collections/%s/import?%s", c.baseURL, url.PathEscape(resource), params.Encode()), in)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	var result interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		fmt.Println(string(body))
+	} else {
+		output, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(output))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
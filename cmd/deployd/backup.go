@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hjanuschka/go-deployd/internal/backup"
+	"github.com/hjanuschka/go-deployd/internal/database"
+)
+
+// runArchiveCommand runs `deployd backup` or `deployd restore` and returns the
+// exit code
+func runArchiveCommand(command string, args []string) int {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	var (
+		dbType = flags.String("db-type", "mongodb", "database type (mongodb, sqlite, mysql, postgres)")
+		dbHost = flags.String("db-host", "localhost", "database host")
+		dbPort = flags.Int("db-port", 0, "database port (0 = use default for db-type)")
+		dbName = flags.String("db-name", "deployd", "database name")
+		dbUser = flags.String("db-user", "", "database username")
+		dbPass = flags.String("db-pass", "", "database password")
+		dbSSL  = flags.Bool("db-ssl", false, "enable SSL for database connection")
+		config = flags.String("config", "", "resources directory (default ./resources)")
+		file   = flags.String("file", "", "archive to write (backup) or read (restore)")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: deployd %s -file=<archive.tar.gz> [database options]\n", command)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *file == "" {
+		flags.Usage()
+		return 2
+	}
+
+	db, err := openDatabase(*dbType, &database.Config{
+		Host:     *dbHost,
+		Port:     defaultDatabasePort(*dbType, *dbPort),
+		Name:     *dbName,
+		Username: *dbUser,
+		Password: *dbPass,
+		SSL:      *dbSSL,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	opts := backup.Options{ResourcesDir: *config}
+	ctx := context.Background()
+
+	var manifest *backup.Manifest
+	if command == "backup" {
+		manifest, err = writeBackup(ctx, *file, db, opts)
+	} else {
+		manifest, err = readBackup(ctx, *file, db, opts)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", command, err)
+		return 1
+	}
+
+	var total int64
+	for _, count := range manifest.Collections {
+		total += count
+	}
+	if command == "backup" {
+		fmt.Printf("Backed up %d collections (%d documents) to %s\n", len(manifest.Collections), total, *file)
+	} else {
+		fmt.Printf("Restored %d collections (%d documents) from a %s backup of %s\n",
+			len(manifest.Collections), total, manifest.DatabaseType, manifest.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+	}
+	return 0
+}
+
+func writeBackup(ctx context.Context, file string, db database.DatabaseInterface, opts backup.Options) (*backup.Manifest, error) {
+	out, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := backup.Create(ctx, out, db, opts)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func readBackup(ctx context.Context, file string, db database.DatabaseInterface, opts backup.Options) (*backup.Manifest, error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return backup.Restore(ctx, in, db, opts)
+}
+
+func openDatabase(dbType string, config *database.Config) (database.DatabaseInterface, error) {
+	switch database.DatabaseType(dbType) {
+	case database.DatabaseTypeMongoDB, database.DatabaseTypeSQLite, database.DatabaseTypeMySQL, database.DatabaseTypePostgres:
+		return database.NewDatabase(database.DatabaseType(dbType), config)
+	}
+	return nil, fmt.Errorf("unknown database type %q", dbType)
+}
+
+// defaultDatabasePort returns port, or the default port of dbType if it is 0
+func defaultDatabasePort(dbType string, port int) int {
+	if port != 0 {
+		return port
+	}
+	switch dbType {
+	case "mongodb":
+		return 27017
+	case "mysql":
+		return 3306
+	case "postgres":
+		return 5432
+	}
+	return 0
+}
@@ -20,6 +20,11 @@ import (
 )
 
 func main() {
+	// Subcommands; without one, the server is started
+	if len(os.Args) > 1 && (os.Args[1] == "backup" || os.Args[1] == "restore") {
+		os.Exit(runArchiveCommand(os.Args[1], os.Args[2:]))
+	}
+
 	var (
 		port   = flag.Int("port", 2403, "server port")
 		dbType = flag.String("db-type", "mongodb", "database type (mongodb, sqlite, mysql, postgres)")
@@ -35,18 +40,7 @@ func main() {
 	flag.Parse()
 
 	// Set default ports based on database type
-	if *dbPort == 0 {
-		switch *dbType {
-		case "mongodb":
-			*dbPort = 27017
-		case "mysql":
-			*dbPort = 3306
-		case "postgres":
-			*dbPort = 5432
-		case "sqlite":
-			*dbPort = 0 // SQLite doesn't use ports
-		}
-	}
+	*dbPort = defaultDatabasePort(*dbType, *dbPort)
 
 	// Initialize logging early for startup messages
 	// Use environment variable for log level, with dev mode override
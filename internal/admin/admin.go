@@ -1,6 +1,7 @@
 package admin
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/fs"
@@ -48,10 +49,19 @@ type ServerInfo struct {
 }
 
 type CollectionInfo struct {
-	Name          string                 `json:"name"`
-	DocumentCount int64                  `json:"documentCount"`
-	Properties    map[string]interface{} `json:"properties"`
-	LastModified  time.Time              `json:"lastModified"`
+	Name          string                          `json:"name"`
+	DocumentCount int64                           `json:"documentCount"`
+	Properties    map[string]interface{}          `json:"properties"`
+	Permissions   map[string]resources.Permission `json:"permissions,omitempty"`
+	LastModified  time.Time                       `json:"lastModified"`
+	// UniqueConflicts lists values held by more than one document, per unique property
+	UniqueConflicts map[string][]interface{} `json:"uniqueConflicts,omitempty"`
+}
+
+// uniqueIndexedCollection is implemented by collections that enforce unique properties
+type uniqueIndexedCollection interface {
+	UniqueConflicts(ctx context.Context) (map[string][]interface{}, error)
+	DropUniqueIndex(ctx context.Context, field string) error
 }
 
 func NewAdminHandler(db database.DatabaseInterface, router *router.Router, adminConfig *Config) *AdminHandler {
@@ -106,12 +116,33 @@ func (h *AdminHandler) RegisterRoutes(r *mux.Router) {
 	admin.HandleFunc("/collections/{name}", h.AuthHandler.RequireMasterKey(h.createCollection)).Methods("POST")
 	admin.HandleFunc("/collections/{name}", h.AuthHandler.RequireMasterKey(h.updateCollection)).Methods("PUT")
 	admin.HandleFunc("/collections/{name}", h.AuthHandler.RequireMasterKey(h.deleteCollection)).Methods("DELETE")
+	admin.HandleFunc("/collections/{name}/permissions", h.AuthHandler.RequireMasterKey(h.getPermissions)).Methods("GET")
+	admin.HandleFunc("/collections/{name}/permissions", h.AuthHandler.RequireMasterKey(h.updatePermissions)).Methods("PUT")
+	admin.HandleFunc("/collections/{name}/export", h.AuthHandler.RequireMasterKey(h.exportCollection)).Methods("GET")
+	admin.HandleFunc("/collections/{name}/import", h.AuthHandler.RequireMasterKey(h.importCollection)).Methods("POST")
+	admin.HandleFunc("/collections/{name}/webhooks/deliveries", h.AuthHandler.RequireMasterKey(h.getWebhookDeliveries)).Methods("GET")
+	admin.HandleFunc("/collections/{name}/webhooks/deliveries/{id}", h.AuthHandler.RequireMasterKey(h.getWebhookDelivery)).Methods("GET")
+	admin.HandleFunc("/collections/{name}/webhooks/deliveries/{id}/redeliver", h.AuthHandler.RequireMasterKey(h.redeliverWebhook)).Methods("POST")
 
 	// Protected event management endpoints (master key required)
 	admin.HandleFunc("/collections/{name}/events", h.AuthHandler.RequireMasterKey(h.getEvents)).Methods("GET")
 	admin.HandleFunc("/collections/{name}/events/{event}", h.AuthHandler.RequireMasterKey(h.updateEvent)).Methods("PUT")
 	admin.HandleFunc("/collections/{name}/events/{event}/test", h.AuthHandler.RequireMasterKey(h.testEvent)).Methods("POST")
 
+	// Cron jobs (master key required)
+	admin.HandleFunc("/cron", h.AuthHandler.RequireMasterKey(h.getCronJobs)).Methods("GET")
+	admin.HandleFunc("/cron/{name}", h.AuthHandler.RequireMasterKey(h.getCronJob)).Methods("GET")
+	admin.HandleFunc("/cron/{name}/runs", h.AuthHandler.RequireMasterKey(h.getCronRuns)).Methods("GET")
+	admin.HandleFunc("/cron/{name}/run", h.AuthHandler.RequireMasterKey(h.runCronJob)).Methods("POST")
+
+	// Job queues (master key required)
+	admin.HandleFunc("/queues", h.AuthHandler.RequireMasterKey(h.getQueues)).Methods("GET")
+	admin.HandleFunc("/queues/{name}", h.AuthHandler.RequireMasterKey(h.getQueue)).Methods("GET")
+	admin.HandleFunc("/queues/{name}/jobs", h.AuthHandler.RequireMasterKey(h.getQueueJobs)).Methods("GET")
+	admin.HandleFunc("/queues/{name}/jobs/{id}", h.AuthHandler.RequireMasterKey(h.getQueueJob)).Methods("GET")
+	admin.HandleFunc("/queues/{name}/jobs/{id}/requeue", h.AuthHandler.RequireMasterKey(h.requeueJob)).Methods("POST")
+	admin.HandleFunc("/queues/{name}/requeue", h.AuthHandler.RequireMasterKey(h.requeueFailedJobs)).Methods("POST")
+
 	// Security settings management (master key required)
 	admin.HandleFunc("/settings/security", h.AuthHandler.RequireMasterKey(h.getSecuritySettings)).Methods("GET")
 	admin.HandleFunc("/settings/security", h.AuthHandler.RequireMasterKey(h.updateSecuritySettings)).Methods("PUT")
@@ -175,6 +206,11 @@ func (h *AdminHandler) getCollections(w http.ResponseWriter, r *http.Request) {
 					if err := json.Unmarshal(data, &config); err != nil {
 						return nil
 					}
+					// Cron jobs and queues are listed under /cron and /queues; endpoints
+					// have no documents
+					if resourceType, err := resources.ReadResourceType(path); err != nil || resourceType != resources.ResourceTypeCollection {
+						return nil
+					}
 
 					// Get document count from database
 					collectionName := filepath.Base(path)
@@ -191,6 +227,7 @@ func (h *AdminHandler) getCollections(w http.ResponseWriter, r *http.Request) {
 						Name:          collectionName,
 						DocumentCount: count,
 						Properties:    props,
+						Permissions:   config.Permissions,
 						LastModified:  stat.ModTime(),
 					})
 				}
@@ -246,15 +283,45 @@ func (h *AdminHandler) getCollection(w http.ResponseWriter, r *http.Request) {
 	props := h.buildPropertiesMap(config.Properties)
 
 	collection := CollectionInfo{
-		Name:          name,
-		DocumentCount: count,
-		Properties:    props,
-		LastModified:  stat.ModTime(),
+		Name:            name,
+		DocumentCount:   count,
+		Properties:      props,
+		Permissions:     config.Permissions,
+		LastModified:    stat.ModTime(),
+		UniqueConflicts: h.uniqueConflicts(r.Context(), h.findResource(name)),
 	}
 
 	json.NewEncoder(w).Encode(collection)
 }
 
+// findResource returns the loaded resource with the given name, or nil
+func (h *AdminHandler) findResource(name string) resources.Resource {
+	for _, resource := range h.router.GetResources() {
+		if resource.GetName() == name {
+			return resource
+		}
+	}
+	return nil
+}
+
+// uniqueConflicts reports existing duplicates of a collection's unique
+// properties, which keep their unique index from being created
+func (h *AdminHandler) uniqueConflicts(ctx context.Context, resource resources.Resource) map[string][]interface{} {
+	collection, ok := resource.(uniqueIndexedCollection)
+	if !ok {
+		return nil
+	}
+	conflicts, err := collection.UniqueConflicts(ctx)
+	if err != nil {
+		logging.GetLogger().WithComponent("admin").Warn("Failed to check unique properties", logging.Fields{
+			"collection": resource.GetName(),
+			"error":      err.Error(),
+		})
+		return nil
+	}
+	return conflicts
+}
+
 func getString(m map[string]interface{}, key string) string {
 	if val, exists := m[key]; exists {
 		if str, ok := val.(string); ok {
@@ -264,6 +331,94 @@ func getString(m map[string]interface{}, key string) string {
 	return ""
 }
 
+// parseProperties converts properties posted by the dashboard to their config form
+func parseProperties(properties map[string]interface{}) (map[string]resources.Property, error) {
+	configProps := make(map[string]resources.Property)
+	for propName, propData := range properties {
+		if propMap, ok := propData.(map[string]interface{}); ok {
+			prop := resources.Property{
+				Type: getString(propMap, "type"),
+			}
+			if required, exists := propMap["required"]; exists {
+				if reqBool, ok := required.(bool); ok {
+					prop.Required = reqBool
+				}
+			}
+			if defaultVal, exists := propMap["default"]; exists {
+				prop.Default = defaultVal
+			}
+			if order, exists := propMap["order"]; exists {
+				if orderInt, ok := order.(float64); ok {
+					prop.Order = int(orderInt)
+				}
+			}
+			if unique, exists := propMap["unique"]; exists {
+				if uniqueBool, ok := unique.(bool); ok {
+					prop.Unique = uniqueBool
+				}
+			}
+			if system, exists := propMap["system"]; exists {
+				if systemBool, ok := system.(bool); ok {
+					prop.System = systemBool
+				}
+			}
+			if err := parsePropertyRules(propMap, &prop); err != nil {
+				return nil, fmt.Errorf("%s: %w", propName, err)
+			}
+			configProps[propName] = prop
+		}
+	}
+	if err := resources.ValidateProperties(configProps); err != nil {
+		return nil, err
+	}
+	return configProps, nil
+}
+
+// propertyRuleKeys are the access, validation and reference keys of a
+// property, which are copied between the dashboard and config.json as-is
+var propertyRuleKeys = []string{
+	"readable", "writable",
+	"minLength", "maxLength", "min", "max", "pattern", "enum", "format", "items", "properties",
+	"collection", "as",
+}
+
+// parsePropertyRules decodes the access and validation rules in propMap into prop
+func parsePropertyRules(propMap map[string]interface{}, prop *resources.Property) error {
+	rules := make(map[string]interface{})
+	for _, key := range propertyRuleKeys {
+		if value, exists := propMap[key]; exists && value != nil {
+			rules[key] = value
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, prop)
+}
+
+// propertyRules returns the access and validation rules of prop in map form
+func propertyRules(prop resources.Property) map[string]interface{} {
+	rules := make(map[string]interface{})
+	data, err := json.Marshal(prop)
+	if err != nil {
+		return rules
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return rules
+	}
+	for _, key := range propertyRuleKeys {
+		if value, exists := all[key]; exists {
+			rules[key] = value
+		}
+	}
+	return rules
+}
+
 // buildPropertiesMap converts collection properties to interface map
 func (h *AdminHandler) buildPropertiesMap(configProperties map[string]resources.Property) map[string]interface{} {
 	props := make(map[string]interface{})
@@ -285,6 +440,9 @@ func (h *AdminHandler) buildPropertiesMap(configProperties map[string]resources.
 		if prop.Unique {
 			propMap["unique"] = true
 		}
+		for key, value := range propertyRules(prop) {
+			propMap[key] = value
+		}
 		if prop.System {
 			propMap["system"] = true
 			// Only set readonly for specific system fields that should never be edited
@@ -318,37 +476,10 @@ func (h *AdminHandler) createCollection(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Convert properties to proper format
-	configProps := make(map[string]resources.Property)
-	for propName, propData := range properties {
-		if propMap, ok := propData.(map[string]interface{}); ok {
-			prop := resources.Property{
-				Type: getString(propMap, "type"),
-			}
-			if required, exists := propMap["required"]; exists {
-				if reqBool, ok := required.(bool); ok {
-					prop.Required = reqBool
-				}
-			}
-			if defaultVal, exists := propMap["default"]; exists {
-				prop.Default = defaultVal
-			}
-			if order, exists := propMap["order"]; exists {
-				if orderInt, ok := order.(float64); ok {
-					prop.Order = int(orderInt)
-				}
-			}
-			if unique, exists := propMap["unique"]; exists {
-				if uniqueBool, ok := unique.(bool); ok {
-					prop.Unique = uniqueBool
-				}
-			}
-			if system, exists := propMap["system"]; exists {
-				if systemBool, ok := system.(bool); ok {
-					prop.System = systemBool
-				}
-			}
-			configProps[propName] = prop
-		}
+	configProps, err := parseProperties(properties)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid properties: %v", err), http.StatusBadRequest)
+		return
 	}
 
 	// Create config structure
@@ -414,43 +545,19 @@ func (h *AdminHandler) updateCollection(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Convert properties to proper format
-	configProps := make(map[string]resources.Property)
-	for propName, propData := range properties {
-		if propMap, ok := propData.(map[string]interface{}); ok {
-			prop := resources.Property{
-				Type: getString(propMap, "type"),
-			}
-			if required, exists := propMap["required"]; exists {
-				if reqBool, ok := required.(bool); ok {
-					prop.Required = reqBool
-				}
-			}
-			if defaultVal, exists := propMap["default"]; exists {
-				prop.Default = defaultVal
-			}
-			if order, exists := propMap["order"]; exists {
-				if orderInt, ok := order.(float64); ok {
-					prop.Order = int(orderInt)
-				}
-			}
-			if unique, exists := propMap["unique"]; exists {
-				if uniqueBool, ok := unique.(bool); ok {
-					prop.Unique = uniqueBool
-				}
-			}
-			if system, exists := propMap["system"]; exists {
-				if systemBool, ok := system.(bool); ok {
-					prop.System = systemBool
-				}
-			}
-			configProps[propName] = prop
-		}
+	configProps, err := parseProperties(properties)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid properties: %v", err), http.StatusBadRequest)
+		return
 	}
 
-	// Create config structure
-	config := resources.CollectionConfig{
-		Properties: configProps,
+	// Keep the rest of the existing config (permissions, event runtimes, ...)
+	var config resources.CollectionConfig
+	if data, err := os.ReadFile(configFile); err == nil {
+		json.Unmarshal(data, &config)
 	}
+	previousProps := config.Properties
+	config.Properties = configProps
 
 	// Write updated config.json
 	configData, err := json.MarshalIndent(config, "", "  ")
@@ -473,6 +580,21 @@ func (h *AdminHandler) updateCollection(w http.ResponseWriter, r *http.Request)
 
 	h.router.UpdateResource(name, collection)
 
+	// Properties that are no longer unique must not keep their index
+	if indexed, ok := collection.(uniqueIndexedCollection); ok {
+		for propName, prop := range previousProps {
+			if prop.Unique && !configProps[propName].Unique {
+				if err := indexed.DropUniqueIndex(r.Context(), propName); err != nil {
+					logging.GetLogger().WithComponent("admin").Warn("Failed to drop unique index", logging.Fields{
+						"collection": name,
+						"field":      propName,
+						"error":      err.Error(),
+					})
+				}
+			}
+		}
+	}
+
 	// Get document count from database
 	store := h.db.CreateStore(name)
 	count, _ := store.Count(r.Context(), database.NewQueryBuilder())
@@ -480,10 +602,12 @@ func (h *AdminHandler) updateCollection(w http.ResponseWriter, r *http.Request)
 	// Convert the updated properties to include hardcoded timestamp fields
 	updatedProps := h.buildPropertiesMap(configProps)
 	response := CollectionInfo{
-		Name:          name,
-		DocumentCount: count,
-		Properties:    updatedProps,
-		LastModified:  time.Now(),
+		Name:            name,
+		DocumentCount:   count,
+		Properties:      updatedProps,
+		Permissions:     config.Permissions,
+		LastModified:    time.Now(),
+		UniqueConflicts: h.uniqueConflicts(r.Context(), collection),
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -514,6 +638,100 @@ func (h *AdminHandler) deleteCollection(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(response)
 }
 
+// getPermissions returns the per-method permissions of a collection
+func (h *AdminHandler) getPermissions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	w.Header().Set("Content-Type", "application/json")
+
+	configFile := filepath.Join(h.resourcesDir, name, "config.json")
+	data, err := os.ReadFile(configFile)
+	if os.IsNotExist(err) {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read config: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var config resources.CollectionConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse config: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	permissions := config.Permissions
+	if permissions == nil {
+		permissions = map[string]resources.Permission{}
+	}
+
+	json.NewEncoder(w).Encode(permissions)
+}
+
+// updatePermissions replaces the per-method permissions of a collection
+func (h *AdminHandler) updatePermissions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var permissions map[string]resources.Permission
+	if err := json.NewDecoder(r.Body).Decode(&permissions); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid permissions: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := resources.ValidatePermissions(permissions); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid permissions: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	collectionDir := filepath.Join(h.resourcesDir, name)
+	configFile := filepath.Join(collectionDir, "config.json")
+	data, err := os.ReadFile(configFile)
+	if os.IsNotExist(err) {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read config: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var config resources.CollectionConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse config: %v", err), http.StatusInternalServerError)
+		return
+	}
+	config.Permissions = permissions
+
+	configData, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to marshal config: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := os.WriteFile(configFile, configData, 0644); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to write config: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Reload collection in router so the new permissions apply immediately
+	collection, err := resources.LoadCollectionFromConfig(name, collectionDir, h.db)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to reload collection: %v", err), http.StatusInternalServerError)
+		return
+	}
+	h.router.UpdateResource(name, collection)
+
+	logging.GetLogger().WithComponent("admin").Info("Collection permissions updated", logging.Fields{
+		"collection":  name,
+		"permissions": permissions,
+	})
+
+	json.NewEncoder(w).Encode(permissions)
+}
+
 // Event management methods
 func (h *AdminHandler) getEvents(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -118,7 +118,7 @@ func (ah *AuthHandler) HandleSystemLogin(w http.ResponseWriter, r *http.Request)
 	isRoot := (role == "admin")
 
 	// Generate JWT token for the user
-	token, err := ah.jwtManager.GenerateToken(userID, username, isRoot)
+	token, err := ah.jwtManager.GenerateToken(userID, username, isRoot, auth.UserRoles(userData)...)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -146,6 +146,7 @@ func (ah *AuthHandler) HandleSystemLogin(w http.ResponseWriter, r *http.Request)
 			"username": username,
 			"email":    userData["email"],
 			"role":     role,
+			"roles":    auth.UserRoles(userData),
 		},
 	})
 }
@@ -0,0 +1,109 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/hjanuschka/go-deployd/internal/resources"
+)
+
+// cronJob returns the cron job of a request, or writes the error and returns
+// nil if there is none
+func (h *AdminHandler) cronJob(w http.ResponseWriter, r *http.Request) *resources.CronJob {
+	job := h.router.GetCronJob(mux.Vars(r)["name"])
+	if job == nil {
+		http.Error(w, "Cron job not found", http.StatusNotFound)
+	}
+	return job
+}
+
+// getCronJobs lists the cron jobs with their state, by name
+func (h *AdminHandler) getCronJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := make([]*resources.CronJobInfo, 0)
+	for _, job := range h.router.GetCronJobs() {
+		info, err := job.Info(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jobs = append(jobs, info)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// getCronJob returns a cron job with its state
+func (h *AdminHandler) getCronJob(w http.ResponseWriter, r *http.Request) {
+	job := h.cronJob(w, r)
+	if job == nil {
+		return
+	}
+	info, err := job.Info(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// getCronRuns lists the most recent runs of a cron job, optionally only
+// those with a status (running, succeeded, failed or skipped)
+func (h *AdminHandler) getCronRuns(w http.ResponseWriter, r *http.Request) {
+	job := h.cronJob(w, r)
+	if job == nil {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", resources.CronRunRunning, resources.CronRunSucceeded, resources.CronRunFailed, resources.CronRunSkipped:
+	default:
+		http.Error(w, "status must be running, succeeded, failed or skipped", http.StatusBadRequest)
+		return
+	}
+	var limit int64
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.ParseInt(raw, 10, 64); err != nil || limit < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	runs, err := job.Runs(r.Context(), status, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+// runCronJob starts a run of a cron job now, also when it is disabled. It
+// responds with the run, which goes on in the background.
+func (h *AdminHandler) runCronJob(w http.ResponseWriter, r *http.Request) {
+	job := h.cronJob(w, r)
+	if job == nil {
+		return
+	}
+
+	run, err := job.RunNow()
+	if errors.Is(err, resources.ErrCronRunning) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
+}
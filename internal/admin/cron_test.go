@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hjanuschka/go-deployd/internal/resources"
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCronJobAdmin(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	dir := filepath.Join(configDir, "rollup")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"),
+		[]byte(`{"type": "cron", "schedule": "0 4 * * *", "timezone": "UTC", "disabled": true}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "run.js"),
+		[]byte(`var end = Date.now() + 200; while (Date.now() < end) {}`), 0644))
+
+	rt := router.New(db, true, configDir)
+	defer rt.Close()
+	h := &AdminHandler{db: db, router: rt, resourcesDir: configDir, config: &Config{Development: true}}
+	r := mux.NewRouter()
+	r.HandleFunc("/_admin/collections", h.getCollections).Methods("GET")
+	r.HandleFunc("/_admin/cron", h.getCronJobs).Methods("GET")
+	r.HandleFunc("/_admin/cron/{name}", h.getCronJob).Methods("GET")
+	r.HandleFunc("/_admin/cron/{name}/runs", h.getCronRuns).Methods("GET")
+	r.HandleFunc("/_admin/cron/{name}/run", h.runCronJob).Methods("POST")
+	call := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	rr := call("GET", "/_admin/cron")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var jobs []resources.CronJobInfo
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &jobs))
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "rollup", jobs[0].Name)
+	assert.Equal(t, "0 4 * * *", jobs[0].Schedule)
+	assert.Equal(t, "UTC", jobs[0].Timezone)
+	assert.Equal(t, "js", jobs[0].Runtime)
+	assert.True(t, jobs[0].Disabled)
+	assert.Nil(t, jobs[0].LastRun)
+
+	rr = call("GET", "/_admin/collections")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `"users"`)
+	assert.NotContains(t, rr.Body.String(), "rollup", "cron jobs are not collections")
+
+	t.Run("runs now", func(t *testing.T) {
+		rr := call("POST", "/_admin/cron/rollup/run")
+		require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+		var run resources.CronRun
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &run))
+		assert.Equal(t, resources.CronTriggerManual, run.Trigger)
+		assert.Equal(t, resources.CronRunRunning, run.Status)
+
+		assert.Equal(t, http.StatusConflict, call("POST", "/_admin/cron/rollup/run").Code)
+
+		require.Eventually(t, func() bool {
+			rr := call("GET", "/_admin/cron/rollup/runs?status=succeeded")
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+			var runs []resources.CronRun
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &runs))
+			return len(runs) == 1 && runs[0].ID == run.ID
+		}, 5*time.Second, 20*time.Millisecond)
+
+		rr = call("GET", "/_admin/cron/rollup")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var info resources.CronJobInfo
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &info))
+		require.NotNil(t, info.LastRun)
+		assert.Equal(t, run.ID, info.LastRun.ID)
+		assert.False(t, info.Running)
+	})
+
+	t.Run("rejects bad requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, call("GET", "/_admin/cron/rollup/runs?status=lost").Code)
+		assert.Equal(t, http.StatusBadRequest, call("GET", "/_admin/cron/rollup/runs?limit=x").Code)
+		assert.Equal(t, http.StatusNotFound, call("GET", "/_admin/cron/missing").Code)
+		assert.Equal(t, http.StatusNotFound, call("POST", "/_admin/cron/missing/run").Code)
+	})
+}
@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/hjanuschka/go-deployd/internal/resources"
+)
+
+// queue returns the queue of a request, or writes the error and returns nil
+// if there is none
+func (h *AdminHandler) queue(w http.ResponseWriter, r *http.Request) *resources.Queue {
+	queue := h.router.GetQueue(mux.Vars(r)["name"])
+	if queue == nil {
+		http.Error(w, "Queue not found", http.StatusNotFound)
+	}
+	return queue
+}
+
+// getQueues lists the queues with their job counts, by name
+func (h *AdminHandler) getQueues(w http.ResponseWriter, r *http.Request) {
+	queues := make([]*resources.QueueInfo, 0)
+	for _, queue := range h.router.GetQueues() {
+		info, err := queue.Info(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		queues = append(queues, info)
+	}
+	sort.Slice(queues, func(i, j int) bool { return queues[i].Name < queues[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queues)
+}
+
+// getQueue returns a queue with its job counts
+func (h *AdminHandler) getQueue(w http.ResponseWriter, r *http.Request) {
+	queue := h.queue(w, r)
+	if queue == nil {
+		return
+	}
+	info, err := queue.Info(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// getQueueJobs lists the most recently enqueued jobs of a queue, optionally
+// only those with a status (pending, running, succeeded or failed)
+func (h *AdminHandler) getQueueJobs(w http.ResponseWriter, r *http.Request) {
+	queue := h.queue(w, r)
+	if queue == nil {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", resources.JobPending, resources.JobRunning, resources.JobSucceeded, resources.JobFailed:
+	default:
+		http.Error(w, "status must be pending, running, succeeded or failed", http.StatusBadRequest)
+		return
+	}
+	var limit int64
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.ParseInt(raw, 10, 64); err != nil || limit < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	jobs, err := queue.Jobs(r.Context(), status, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// getQueueJob returns one job of a queue
+func (h *AdminHandler) getQueueJob(w http.ResponseWriter, r *http.Request) {
+	queue := h.queue(w, r)
+	if queue == nil {
+		return
+	}
+	job, err := queue.Job(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// requeueJob runs a failed job again, e.g. once the bug that failed it is
+// fixed. It responds with the job.
+func (h *AdminHandler) requeueJob(w http.ResponseWriter, r *http.Request) {
+	queue := h.queue(w, r)
+	if queue == nil {
+		return
+	}
+
+	job, err := queue.Requeue(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, resources.ErrJobNotFailed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// requeueFailedJobs runs all failed jobs of a queue again and responds with
+// how many there were
+func (h *AdminHandler) requeueFailedJobs(w http.ResponseWriter, r *http.Request) {
+	queue := h.queue(w, r)
+	if queue == nil {
+		return
+	}
+
+	requeued, err := queue.RequeueFailed(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"requeued": requeued})
+}
@@ -0,0 +1,126 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hjanuschka/go-deployd/internal/events"
+	"github.com/hjanuschka/go-deployd/internal/resources"
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueAdmin(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	dir := filepath.Join(configDir, "mailer")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"),
+		[]byte(`{"type": "queue", "maxAttempts": 1}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "run.js"),
+		[]byte(`if (context.data.payload.to === 'nobody') { throw new Error('no such mailbox'); }`), 0644))
+
+	rt := router.New(db, true, configDir)
+	defer rt.Close()
+	rt.StartQueues()
+	h := &AdminHandler{db: db, router: rt, resourcesDir: configDir, config: &Config{Development: true}}
+	r := mux.NewRouter()
+	r.HandleFunc("/_admin/collections", h.getCollections).Methods("GET")
+	r.HandleFunc("/_admin/queues", h.getQueues).Methods("GET")
+	r.HandleFunc("/_admin/queues/{name}", h.getQueue).Methods("GET")
+	r.HandleFunc("/_admin/queues/{name}/jobs", h.getQueueJobs).Methods("GET")
+	r.HandleFunc("/_admin/queues/{name}/jobs/{id}", h.getQueueJob).Methods("GET")
+	r.HandleFunc("/_admin/queues/{name}/jobs/{id}/requeue", h.requeueJob).Methods("POST")
+	r.HandleFunc("/_admin/queues/{name}/requeue", h.requeueFailedJobs).Methods("POST")
+	call := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	queue := rt.GetQueue("mailer")
+	require.NotNil(t, queue)
+	sent, err := queue.Enqueue(context.Background(), map[string]interface{}{"to": "alice"}, events.EnqueueOptions{})
+	require.NoError(t, err)
+	bounced, err := queue.Enqueue(context.Background(), map[string]interface{}{"to": "nobody"}, events.EnqueueOptions{})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		rr := call("GET", "/_admin/queues")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var queues []resources.QueueInfo
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &queues))
+		require.Len(t, queues, 1)
+		return queues[0].Jobs[resources.JobSucceeded] == 1 && queues[0].Jobs[resources.JobFailed] == 1
+	}, 5*time.Second, 20*time.Millisecond)
+
+	rr := call("GET", "/_admin/queues/mailer")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var info resources.QueueInfo
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &info))
+	assert.Equal(t, "mailer", info.Name)
+	assert.Equal(t, "js", info.Runtime)
+	assert.Equal(t, 1, info.Concurrency)
+	assert.Equal(t, 1, info.MaxAttempts)
+
+	rr = call("GET", "/_admin/collections")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.NotContains(t, rr.Body.String(), "mailer", "queues are not collections")
+
+	t.Run("lists jobs", func(t *testing.T) {
+		rr := call("GET", "/_admin/queues/mailer/jobs?status=failed")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var jobs []resources.Job
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &jobs))
+		require.Len(t, jobs, 1)
+		assert.Equal(t, bounced.ID, jobs[0].ID)
+		assert.Contains(t, jobs[0].Error, "no such mailbox")
+
+		rr = call("GET", "/_admin/queues/mailer/jobs/"+sent.ID)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var job resources.Job
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+		assert.Equal(t, resources.JobSucceeded, job.Status)
+		assert.Equal(t, "alice", job.Payload["to"])
+	})
+
+	t.Run("requeues failed jobs", func(t *testing.T) {
+		assert.Equal(t, http.StatusConflict, call("POST", "/_admin/queues/mailer/jobs/"+sent.ID+"/requeue").Code)
+
+		rr := call("POST", "/_admin/queues/mailer/jobs/"+bounced.ID+"/requeue")
+		require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+		var job resources.Job
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+		assert.Equal(t, resources.JobPending, job.Status)
+		assert.Equal(t, 0, job.Attempts)
+
+		require.Eventually(t, func() bool {
+			job, err := queue.Job(context.Background(), bounced.ID)
+			require.NoError(t, err)
+			return job.Status == resources.JobFailed
+		}, 5*time.Second, 20*time.Millisecond)
+
+		rr = call("POST", "/_admin/queues/mailer/requeue")
+		require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+		assert.JSONEq(t, `{"requeued": 1}`, rr.Body.String())
+	})
+
+	t.Run("rejects bad requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, call("GET", "/_admin/queues/mailer/jobs?status=lost").Code)
+		assert.Equal(t, http.StatusBadRequest, call("GET", "/_admin/queues/mailer/jobs?limit=0").Code)
+		assert.Equal(t, http.StatusNotFound, call("GET", "/_admin/queues/missing").Code)
+		assert.Equal(t, http.StatusNotFound, call("GET", "/_admin/queues/mailer/jobs/missing").Code)
+		assert.Equal(t, http.StatusNotFound, call("POST", "/_admin/queues/mailer/jobs/missing/requeue").Code)
+	})
+}
@@ -0,0 +1,205 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/logging"
+	"github.com/hjanuschka/go-deployd/internal/resources"
+)
+
+const (
+	// importBatch is the number of documents written per transaction
+	importBatch = 1000
+	// maxImportFailures caps the failures listed in an import response
+	maxImportFailures = 1000
+)
+
+// exportCollection streams the documents of a collection, optionally filtered
+// by a query, as NDJSON, CSV or MongoDB Extended JSON
+func (h *AdminHandler) exportCollection(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var collection *resources.Collection
+	switch resource := h.findResource(name).(type) {
+	case *resources.Collection:
+		collection = resource
+	case *resources.UserCollection:
+		collection = resource.Collection
+	}
+	if collection == nil || collection.GetConfig().NoStore {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = resources.FormatNDJSON
+	}
+	var filter map[string]interface{}
+	if raw := r.URL.Query().Get("filter"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &filter); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid filter: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	encoder, err := resources.NewDocumentEncoder(w, format, collection.GetConfig().Properties)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", resources.FormatContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", name, resources.FormatExtension(format)))
+
+	count := 0
+	err = collection.Export(r.Context(), filter, func(doc map[string]interface{}) error {
+		count++
+		return encoder.Encode(doc)
+	})
+	if err == nil {
+		err = encoder.Close()
+	}
+	if err != nil {
+		logging.GetLogger().WithComponent("admin").Error("Collection export failed", logging.Fields{
+			"collection": name,
+			"exported":   count,
+			"error":      err.Error(),
+		})
+		// Once documents are written the status can't change; the
+		// truncated output is all the client gets
+		if count == 0 {
+			http.Error(w, fmt.Sprintf("Export failed: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	logging.GetLogger().WithComponent("admin").Info("Collection exported", logging.Fields{
+		"collection": name,
+		"format":     format,
+		"documents":  count,
+	})
+}
+
+// importCollection writes the documents of the request body to a collection,
+// keeping their ids and timestamps. Query parameters: format, events (run
+// the validate, post and put events), upsert (update documents whose id
+// exists) and ordered (stop at the first failure).
+func (h *AdminHandler) importCollection(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	w.Header().Set("Content-Type", "application/json")
+
+	switch h.findResource(name).(type) {
+	case *resources.UserCollection:
+		// Users are created through registration, which hashes passwords
+		http.Error(w, "Import is not supported for users", http.StatusMethodNotAllowed)
+		return
+	}
+	collection := h.router.GetCollection(name)
+	if collection == nil || collection.GetConfig().NoStore {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = resources.FormatNDJSON
+	}
+	opts := resources.ImportOptions{
+		RunEvents: queryBool(query.Get("events")),
+		Upsert:    queryBool(query.Get("upsert")),
+		Ordered:   queryBool(query.Get("ordered")),
+	}
+
+	decoder, err := resources.NewDocumentDecoder(r.Body, format, collection.GetConfig().Properties)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Events run on behalf of the master key
+	req, err := http.NewRequestWithContext(r.Context(), "POST", collection.GetPath(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ctx := appcontext.New(req, httptest.NewRecorder(), collection, &appcontext.AuthData{
+		IsRoot:          true,
+		IsAuthenticated: true,
+	}, h.config.Development)
+
+	total := &resources.ImportResult{}
+	read := 0
+	var readErr error
+	for readErr == nil {
+		var docs []map[string]interface{}
+		for len(docs) < importBatch {
+			doc, err := decoder.Decode()
+			if err != nil {
+				if err != io.EOF {
+					readErr = err
+				}
+				break
+			}
+			docs = append(docs, doc)
+		}
+		if len(docs) == 0 {
+			break
+		}
+
+		result, err := collection.Import(ctx, docs, opts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Import failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		total.Inserted += result.Inserted
+		total.Updated += result.Updated
+		total.Failed += result.Failed
+		for _, failure := range result.Failures {
+			if len(total.Failures) < maxImportFailures {
+				failure.Index += read
+				total.Failures = append(total.Failures, failure)
+			}
+		}
+		read += len(docs)
+
+		if (opts.Ordered && result.Failed > 0) || len(docs) < importBatch {
+			break
+		}
+	}
+
+	logging.GetLogger().WithComponent("admin").Info("Collection imported", logging.Fields{
+		"collection": name,
+		"format":     format,
+		"inserted":   total.Inserted,
+		"updated":    total.Updated,
+		"failed":     total.Failed,
+	})
+
+	// Documents read before a malformed one are already written
+	if readErr != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":    fmt.Sprintf("Invalid %s input: %v", format, readErr),
+			"inserted": total.Inserted,
+			"updated":  total.Updated,
+			"failed":   total.Failed,
+			"failures": total.Failures,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(total)
+}
+
+func queryBool(value string) bool {
+	b, _ := strconv.ParseBool(value)
+	return b
+}
@@ -0,0 +1,217 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectionImportExport(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	products := `{
+		"properties": {
+			"name":     {"type": "string", "required": true, "order": 1},
+			"price":    {"type": "number", "order": 2},
+			"tags":     {"type": "array", "order": 3},
+			"sold":     {"type": "boolean", "order": 4},
+			"released": {"type": "date", "order": 5}
+		},
+		"eventConfig": {"post": {"runtime": "js"}}
+	}`
+	reject := `function Run(context) {
+		if (context.data.name === 'reject') {
+			context.cancel('rejected by event', 422);
+		}
+	}`
+	for _, name := range []string{"products", "ndjson_copy", "csv_copy", "extjson_copy"} {
+		dir := filepath.Join(configDir, name)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(products), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "post.js"), []byte(reject), 0644))
+	}
+
+	h := &AdminHandler{db: db, router: router.New(db, true, configDir), config: &Config{Development: true}}
+	r := mux.NewRouter()
+	r.HandleFunc("/_admin/collections/{name}/export", h.exportCollection).Methods("GET")
+	r.HandleFunc("/_admin/collections/{name}/import", h.importCollection).Methods("POST")
+
+	type importResponse struct {
+		Inserted int `json:"inserted"`
+		Updated  int `json:"updated"`
+		Failed   int `json:"failed"`
+		Failures []struct {
+			Index  int               `json:"index"`
+			ID     string            `json:"id"`
+			Status int               `json:"status"`
+			Errors map[string]string `json:"errors"`
+		} `json:"failures"`
+	}
+	importDocs := func(name, params, body string) importResponse {
+		req := httptest.NewRequest("POST", "/_admin/collections/"+name+"/import?"+params, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var resp importResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+	export := func(name, params string) string {
+		req := httptest.NewRequest("GET", "/_admin/collections/"+name+"/export?"+params, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		return rr.Body.String()
+	}
+	exportNDJSON := func(name, params string) []map[string]interface{} {
+		var docs []map[string]interface{}
+		scanner := bufio.NewScanner(strings.NewReader(export(name, params)))
+		for scanner.Scan() {
+			var doc map[string]interface{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &doc))
+			docs = append(docs, doc)
+		}
+		return docs
+	}
+
+	t.Run("imports NDJSON keeping ids and timestamps", func(t *testing.T) {
+		resp := importDocs("products", "", strings.Join([]string{
+			`{"id": "p1", "name": "Lamp", "price": 20, "tags": ["home"], "createdAt": "2020-01-02T03:04:05Z", "updatedAt": "2021-01-02T03:04:05Z"}`,
+			`{"id": "p2", "name": "reject", "price": 5}`,
+			``,
+			`{"id": "p3", "price": 1}`,
+			`{"name": "Chair", "sold": true, "released": "2022-05-01T00:00:00Z"}`,
+		}, "\n"))
+
+		assert.Equal(t, 3, resp.Inserted)
+		assert.Equal(t, 1, resp.Failed)
+		require.Len(t, resp.Failures, 1)
+		assert.Equal(t, 2, resp.Failures[0].Index)
+		assert.Equal(t, "p3", resp.Failures[0].ID)
+		assert.Equal(t, map[string]string{"name": "is required"}, resp.Failures[0].Errors)
+
+		docs := exportNDJSON("products", "")
+		require.Len(t, docs, 3)
+		byName := map[string]map[string]interface{}{}
+		for _, doc := range docs {
+			byName[doc["name"].(string)] = doc
+		}
+		assert.Equal(t, "p1", byName["Lamp"]["id"])
+		assert.Equal(t, "2020-01-02T03:04:05Z", byName["Lamp"]["createdAt"])
+		assert.Equal(t, "2021-01-02T03:04:05Z", byName["Lamp"]["updatedAt"])
+		assert.Equal(t, "p2", byName["reject"]["id"], "events don't run unless asked for")
+		assert.NotEmpty(t, byName["Chair"]["id"])
+	})
+
+	t.Run("fails or upserts existing ids", func(t *testing.T) {
+		resp := importDocs("products", "", `{"id": "p1", "name": "Lamp v2", "price": 25}`)
+		assert.Equal(t, 0, resp.Inserted)
+		require.Len(t, resp.Failures, 1)
+		assert.Equal(t, http.StatusConflict, resp.Failures[0].Status)
+
+		resp = importDocs("products", "upsert=true", `{"id": "p1", "name": "Lamp v2", "price": 25}
+{"id": "p4", "name": "Desk", "price": 100}`)
+		assert.Equal(t, 1, resp.Inserted)
+		assert.Equal(t, 1, resp.Updated)
+		assert.Equal(t, 0, resp.Failed)
+
+		docs := exportNDJSON("products", "filter="+url.QueryEscape(`{"id": "p1"}`))
+		require.Len(t, docs, 1)
+		assert.Equal(t, "Lamp v2", docs[0]["name"])
+		assert.Equal(t, float64(25), docs[0]["price"])
+		assert.Equal(t, []interface{}{"home"}, docs[0]["tags"], "fields missing from the upsert are kept")
+		assert.Equal(t, "2020-01-02T03:04:05Z", docs[0]["createdAt"])
+	})
+
+	t.Run("runs events when asked to", func(t *testing.T) {
+		resp := importDocs("products", "events=true&ordered=true", `{"name": "reject"}
+{"name": "Never imported"}`)
+		assert.Equal(t, 0, resp.Inserted)
+		require.Len(t, resp.Failures, 1)
+		assert.Equal(t, 422, resp.Failures[0].Status)
+	})
+
+	t.Run("exports CSV with the property columns", func(t *testing.T) {
+		records, err := csv.NewReader(strings.NewReader(export("products", "format=csv&filter="+url.QueryEscape(`{"price": {"$gte": 20}}`)))).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		assert.Equal(t, []string{"id", "name", "price", "tags", "sold", "released", "createdAt", "updatedAt"}, records[0])
+		assert.Equal(t, []string{"p1", "Lamp v2", "25", `["home"]`, "", ""}, records[1][:6])
+		assert.Equal(t, []string{"p4", "Desk", "100", "", "", ""}, records[2][:6])
+	})
+
+	t.Run("round-trips every format", func(t *testing.T) {
+		original := exportNDJSON("products", "")
+		for _, format := range []string{"ndjson", "csv", "extjson"} {
+			data := export("products", "format="+format)
+			resp := importDocs(format+"_copy", "format="+format, data)
+			assert.Equal(t, len(original), resp.Inserted, format)
+
+			copies := exportNDJSON(format+"_copy", "")
+			require.Len(t, copies, len(original), format)
+			for i := range original {
+				expected := original[i]
+				if format == "extjson" {
+					expected = millisecondDates(t, expected)
+				}
+				assert.Equal(t, expected, copies[i], format)
+			}
+		}
+	})
+
+	t.Run("exports dates as extended JSON", func(t *testing.T) {
+		data := export("products", "format=extjson&filter="+url.QueryEscape(`{"id": "p1"}`))
+		assert.True(t, strings.HasPrefix(data, `{"_id":"p1",`), data)
+		assert.Contains(t, data, `"createdAt":{"$date":"2020-01-02T03:04:05Z"}`)
+	})
+
+	t.Run("rejects bad requests", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/_admin/collections/products/export?format=xml", nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		req = httptest.NewRequest("POST", "/_admin/collections/products/import", strings.NewReader(`{"name": "Good"}
+not json`))
+		rr = httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"inserted":1`)
+
+		req = httptest.NewRequest("POST", "/_admin/collections/missing/import", strings.NewReader(`{}`))
+		rr = httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+// millisecondDates truncates the timestamps of doc to the precision of BSON
+// dates
+func millisecondDates(t *testing.T, doc map[string]interface{}) map[string]interface{} {
+	truncated := make(map[string]interface{}, len(doc))
+	for key, value := range doc {
+		truncated[key] = value
+	}
+	for _, field := range []string{"createdAt", "updatedAt"} {
+		parsed, err := time.Parse(time.RFC3339Nano, doc[field].(string))
+		require.NoError(t, err)
+		truncated[field] = parsed.Truncate(time.Millisecond).Format(time.RFC3339Nano)
+	}
+	return truncated
+}
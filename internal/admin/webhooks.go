@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/hjanuschka/go-deployd/internal/resources"
+)
+
+// webhookCollection returns the collection of a webhook request, or writes
+// the error and returns nil if it has no webhooks
+func (h *AdminHandler) webhookCollection(w http.ResponseWriter, r *http.Request) *resources.Collection {
+	name := mux.Vars(r)["name"]
+	var collection *resources.Collection
+	switch resource := h.findResource(name).(type) {
+	case *resources.Collection:
+		collection = resource
+	case *resources.UserCollection:
+		collection = resource.Collection
+	}
+	if collection == nil {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return nil
+	}
+	if len(collection.GetConfig().Webhooks) == 0 {
+		http.Error(w, fmt.Sprintf("%s has no webhooks", name), http.StatusNotFound)
+		return nil
+	}
+	return collection
+}
+
+// getWebhookDeliveries lists the most recent webhook deliveries of a
+// collection, optionally only those with a status (pending, delivered or
+// dead)
+func (h *AdminHandler) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	collection := h.webhookCollection(w, r)
+	if collection == nil {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", resources.DeliveryPending, resources.DeliveryDelivered, resources.DeliveryDead:
+	default:
+		http.Error(w, "status must be pending, delivered or dead", http.StatusBadRequest)
+		return
+	}
+	var limit int64
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.ParseInt(raw, 10, 64); err != nil || limit < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	deliveries, err := collection.WebhookDeliveries(r.Context(), status, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// getWebhookDelivery returns one webhook delivery with its attempts
+func (h *AdminHandler) getWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	collection := h.webhookCollection(w, r)
+	if collection == nil {
+		return
+	}
+
+	delivery, err := collection.WebhookDelivery(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if delivery == nil {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// redeliverWebhook queues a webhook delivery again, e.g. a dead letter once
+// the receiver is fixed. It responds with the new delivery.
+func (h *AdminHandler) redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	collection := h.webhookCollection(w, r)
+	if collection == nil {
+		return
+	}
+
+	delivery, err := collection.RedeliverWebhook(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, resources.ErrWebhookRemoved) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if delivery == nil {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
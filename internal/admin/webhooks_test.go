@@ -0,0 +1,100 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hjanuschka/go-deployd/internal/resources"
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookDeliveries(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	configDir := t.TempDir()
+	for name, config := range map[string]string{
+		"orders": fmt.Sprintf(`{"properties": {"total": {"type": "number"}},
+			"webhooks": [{"url": %q, "maxAttempts": 1}]}`, receiver.URL),
+		"notes": `{"properties": {"text": {"type": "string"}}}`,
+	} {
+		dir := filepath.Join(configDir, name)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0644))
+	}
+
+	rt := router.New(db, true, configDir)
+	defer rt.Close()
+	h := &AdminHandler{db: db, router: rt, config: &Config{Development: true}}
+	r := mux.NewRouter()
+	r.HandleFunc("/_admin/collections/{name}/webhooks/deliveries", h.getWebhookDeliveries).Methods("GET")
+	r.HandleFunc("/_admin/collections/{name}/webhooks/deliveries/{id}", h.getWebhookDelivery).Methods("GET")
+	r.HandleFunc("/_admin/collections/{name}/webhooks/deliveries/{id}/redeliver", h.redeliverWebhook).Methods("POST")
+	call := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(`{"total": 10}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	rt.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var dead []resources.WebhookDelivery
+	require.Eventually(t, func() bool {
+		rr := call("GET", "/_admin/collections/orders/webhooks/deliveries?status=dead")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &dead))
+		return len(dead) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "created", dead[0].Event)
+	require.Len(t, dead[0].Attempts, 1)
+	assert.Equal(t, http.StatusServiceUnavailable, dead[0].Attempts[0].Status)
+
+	t.Run("returns a delivery", func(t *testing.T) {
+		rr := call("GET", "/_admin/collections/orders/webhooks/deliveries/"+dead[0].ID)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var delivery resources.WebhookDelivery
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &delivery))
+		assert.Equal(t, dead[0].ID, delivery.ID)
+
+		assert.Equal(t, http.StatusNotFound, call("GET", "/_admin/collections/orders/webhooks/deliveries/missing").Code)
+	})
+
+	t.Run("redelivers", func(t *testing.T) {
+		rr := call("POST", "/_admin/collections/orders/webhooks/deliveries/"+dead[0].ID+"/redeliver")
+		require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+		var delivery resources.WebhookDelivery
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &delivery))
+		assert.Equal(t, dead[0].ID, delivery.RedeliveryOf)
+		assert.Equal(t, dead[0].Payload["data"], delivery.Payload["data"])
+
+		assert.Equal(t, http.StatusNotFound, call("POST", "/_admin/collections/orders/webhooks/deliveries/missing/redeliver").Code)
+	})
+
+	t.Run("rejects bad requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, call("GET", "/_admin/collections/orders/webhooks/deliveries?status=lost").Code)
+		assert.Equal(t, http.StatusBadRequest, call("GET", "/_admin/collections/orders/webhooks/deliveries?limit=0").Code)
+		assert.Equal(t, http.StatusNotFound, call("GET", "/_admin/collections/notes/webhooks/deliveries").Code,
+			"collections without webhooks have no deliveries")
+		assert.Equal(t, http.StatusNotFound, call("GET", "/_admin/collections/missing/webhooks/deliveries").Code)
+	})
+}
@@ -17,9 +17,10 @@ var (
 )
 
 type JWTClaims struct {
-	UserID   string `json:"user_id,omitempty"`
-	Username string `json:"username,omitempty"`
-	IsRoot   bool   `json:"is_root"`
+	UserID   string   `json:"user_id,omitempty"`
+	Username string   `json:"username,omitempty"`
+	IsRoot   bool     `json:"is_root"`
+	Roles    []string `json:"roles,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -37,12 +38,13 @@ func NewJWTManager(secretKey string, tokenDuration time.Duration) *JWTManager {
 	}
 }
 
-// GenerateToken creates a new JWT token
-func (m *JWTManager) GenerateToken(userID, username string, isRoot bool) (string, error) {
+// GenerateToken creates a new JWT token carrying the user's roles
+func (m *JWTManager) GenerateToken(userID, username string, isRoot bool, roles ...string) (string, error) {
 	claims := &JWTClaims{
 		UserID:   userID,
 		Username: username,
 		IsRoot:   isRoot,
+		Roles:    roles,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer:    m.issuer,
 			Subject:   userID,
@@ -100,3 +102,34 @@ func generateTokenID() string {
 	rand.Read(b)
 	return base64.URLEncoding.EncodeToString(b)
 }
+
+// UserRoles returns the roles of a user document: the entries of its "roles"
+// array plus its legacy single "role" field
+func UserRoles(user map[string]interface{}) []string {
+	roles := []string{}
+	seen := make(map[string]bool)
+	add := func(role string) {
+		if role != "" && !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+
+	if role, ok := user["role"].(string); ok {
+		add(role)
+	}
+	switch list := user["roles"].(type) {
+	case []interface{}:
+		for _, role := range list {
+			if r, ok := role.(string); ok {
+				add(r)
+			}
+		}
+	case []string:
+		for _, role := range list {
+			add(role)
+		}
+	}
+
+	return roles
+}
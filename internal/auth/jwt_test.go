@@ -298,3 +298,59 @@ func generateExpiredToken(t *testing.T, secretKey string) string {
 	}
 	return tokenString
 }
+
+func TestJWTManager_Roles(t *testing.T) {
+	manager := NewJWTManager("test-secret-key-for-jwt-testing", time.Hour)
+
+	token, err := manager.GenerateToken("user123", "editor", false, "editor", "billing")
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+
+	claims, err := manager.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+
+	if len(claims.Roles) != 2 || claims.Roles[0] != "editor" || claims.Roles[1] != "billing" {
+		t.Errorf("Expected roles [editor billing], got %v", claims.Roles)
+	}
+}
+
+func TestUserRoles(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     map[string]interface{}
+		expected []string
+	}{
+		{
+			name:     "No roles",
+			user:     map[string]interface{}{"username": "john"},
+			expected: []string{},
+		},
+		{
+			name:     "Legacy role field",
+			user:     map[string]interface{}{"role": "user"},
+			expected: []string{"user"},
+		},
+		{
+			name:     "Roles array merged with role",
+			user:     map[string]interface{}{"role": "user", "roles": []interface{}{"editor", "user", 42}},
+			expected: []string{"user", "editor"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roles := UserRoles(tt.user)
+			if len(roles) != len(tt.expected) {
+				t.Fatalf("UserRoles() = %v, want %v", roles, tt.expected)
+			}
+			for i := range roles {
+				if roles[i] != tt.expected[i] {
+					t.Errorf("UserRoles() = %v, want %v", roles, tt.expected)
+				}
+			}
+		})
+	}
+}
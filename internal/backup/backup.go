@@ -0,0 +1,242 @@
+// Package backup writes and restores archives of a whole deployd app: the
+// documents of every collection, the resources directory with collection
+// configs and event scripts, and the server configuration in .deployd.
+//
+// An archive is a gzip-compressed tar file holding
+//
+//	manifest.json            format version, source database and document counts
+//	.deployd/<file>.json     server configuration, including security.json
+//	resources/<name>/...     collection configs and event scripts
+//	data/<name>.ndjson       the documents of each collection, one per line
+//
+// Documents are restored as they were saved, with their ids and timestamps,
+// into any database type.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/config"
+	"github.com/hjanuschka/go-deployd/internal/database"
+	"github.com/hjanuschka/go-deployd/internal/resources"
+	"github.com/hjanuschka/go-deployd/internal/router"
+)
+
+// FormatVersion is the version of the archive layout written by Create
+const FormatVersion = 1
+
+// restoreBatch is the number of documents inserted per InsertMany call
+const restoreBatch = 500
+
+// Manifest describes an archive
+type Manifest struct {
+	Version      int                   `json:"version"`
+	CreatedAt    time.Time             `json:"createdAt"`
+	DatabaseType database.DatabaseType `json:"databaseType"`
+	// Collections maps each collection with a store to its document count
+	Collections map[string]int64 `json:"collections"`
+}
+
+// Options locates the app directories
+type Options struct {
+	// ResourcesDir holds the collections; defaults to ./resources
+	ResourcesDir string
+	// ConfigDir holds the server configuration; defaults to .deployd
+	ConfigDir string
+}
+
+func (o Options) withDefaults() Options {
+	if o.ResourcesDir == "" {
+		o.ResourcesDir = "./resources"
+	}
+	if o.ConfigDir == "" {
+		o.ConfigDir = config.GetConfigDir()
+	}
+	return o
+}
+
+// storedCollections returns the collections of the app in db that keep
+// documents, by name
+func storedCollections(db database.DatabaseInterface, resourcesDir string) map[string]*resources.Collection {
+	collections := make(map[string]*resources.Collection)
+	for _, resource := range router.New(db, false, resourcesDir).GetResources() {
+		var collection *resources.Collection
+		switch r := resource.(type) {
+		case *resources.Collection:
+			collection = r
+		case *resources.UserCollection:
+			collection = r.Collection
+		}
+		if collection != nil && !collection.GetConfig().NoStore {
+			collections[collection.GetName()] = collection
+		}
+	}
+	return collections
+}
+
+// Create writes an archive of the app in db to w. Collections are read one
+// after the other, so the server should be stopped (or idle) for the
+// archive to be consistent.
+func Create(ctx context.Context, w io.Writer, db database.DatabaseInterface, opts Options) (*Manifest, error) {
+	opts = opts.withDefaults()
+	if info, err := os.Stat(opts.ResourcesDir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("resources directory %s not found", opts.ResourcesDir)
+	}
+
+	manifest := &Manifest{
+		Version:      FormatVersion,
+		CreatedAt:    time.Now().UTC(),
+		DatabaseType: db.GetType(),
+		Collections:  make(map[string]int64),
+	}
+
+	// Documents are spooled to temporary files first: tar needs the size of
+	// an entry before its content, and the manifest comes first
+	spool, err := os.MkdirTemp("", "deployd-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(spool)
+
+	collections := storedCollections(db, opts.ResourcesDir)
+	names := make([]string, 0, len(collections))
+	for name, collection := range collections {
+		count, err := exportCollection(ctx, collection, filepath.Join(spool, name+".ndjson"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up %s: %w", name, err)
+		}
+		manifest.Collections[name] = count
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeEntry(tw, "manifest.json", 0644, int64(len(data)), strings.NewReader(string(data))); err != nil {
+		return nil, err
+	}
+	if err := addConfigFiles(tw, opts.ConfigDir); err != nil {
+		return nil, err
+	}
+	if err := addDir(tw, opts.ResourcesDir, "resources"); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := addFile(tw, filepath.Join(spool, name+".ndjson"), "data/"+name+".ndjson"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// exportCollection writes the documents of a collection to an NDJSON file
+func exportCollection(ctx context.Context, collection *resources.Collection, path string) (int64, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	encoder, err := resources.NewDocumentEncoder(file, resources.FormatNDJSON, collection.GetConfig().Properties)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = collection.Export(ctx, nil, func(doc map[string]interface{}) error {
+		count++
+		return encoder.Encode(doc)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := encoder.Close(); err != nil {
+		return 0, err
+	}
+	return count, file.Close()
+}
+
+// addConfigFiles adds the JSON files of the server configuration directory
+func addConfigFiles(tw *tar.Writer, configDir string) error {
+	entries, err := os.ReadDir(configDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && filepath.Ext(entry.Name()) == ".json" {
+			if err := addFile(tw, filepath.Join(configDir, entry.Name()), ".deployd/"+entry.Name()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addDir adds the files under dir, except compiled Go event plugins, which
+// are rebuilt from their sources
+func addDir(tw *tar.Writer, dir, prefix string) error {
+	return filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || filepath.Ext(path) == ".so" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return addFile(tw, path, prefix+"/"+filepath.ToSlash(rel))
+	})
+}
+
+func addFile(tw *tar.Writer, path, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return writeEntry(tw, name, int64(info.Mode().Perm()), info.Size(), file)
+}
+
+func writeEntry(tw *tar.Writer, name string, mode, size int64, content io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    mode,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, content, size)
+	return err
+}
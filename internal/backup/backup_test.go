@@ -0,0 +1,168 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/database"
+	"github.com/hjanuschka/go-deployd/internal/resources"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+// exportAll returns the documents of every stored collection of an app
+func exportAll(t *testing.T, db database.DatabaseInterface, resourcesDir string) map[string][]map[string]interface{} {
+	all := make(map[string][]map[string]interface{})
+	for name, collection := range storedCollections(db, resourcesDir) {
+		var docs []map[string]interface{}
+		require.NoError(t, collection.Export(context.Background(), nil, func(doc map[string]interface{}) error {
+			docs = append(docs, doc)
+			return nil
+		}))
+		all[name] = docs
+	}
+	return all
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	source := Options{ResourcesDir: filepath.Join(t.TempDir(), "resources"), ConfigDir: t.TempDir()}
+	writeFile(t, filepath.Join(source.ResourcesDir, "products", "config.json"),
+		`{"properties": {"name": {"type": "string", "required": true}, "price": {"type": "number"}, "sku": {"type": "string", "unique": true}}}`)
+	writeFile(t, filepath.Join(source.ResourcesDir, "products", "post.js"), `function Run(context) { context.data.name += '!'; }`)
+	writeFile(t, filepath.Join(source.ResourcesDir, "hooks", "config.json"), `{"noStore": true}`)
+	writeFile(t, filepath.Join(source.ConfigDir, "security.json"), `{"masterKey": "mk_test"}`)
+
+	sourceDB := testutil.CreateTestDB(t)
+	defer sourceDB.Close()
+	products := sourceDB.CreateStore("products")
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, name := range []string{"Lamp", "Desk", "Chair"} {
+		_, err := products.Insert(ctx, map[string]interface{}{
+			"id": "p" + string(rune('1'+i)), "name": name, "price": float64(10 * (i + 1)),
+			"createdAt": created, "updatedAt": created.Add(time.Hour),
+		})
+		require.NoError(t, err)
+	}
+	_, err := sourceDB.CreateStore("users").Insert(ctx, map[string]interface{}{
+		"username": "alice", "email": "alice@example.com", "password": "$2a$10$hash", "role": "admin",
+	})
+	require.NoError(t, err)
+
+	var archive bytes.Buffer
+	manifest, err := Create(ctx, &archive, sourceDB, source)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"products": 3, "users": 1}, manifest.Collections)
+	assert.Equal(t, database.DatabaseTypeSQLite, manifest.DatabaseType)
+
+	target := Options{ResourcesDir: filepath.Join(t.TempDir(), "resources"), ConfigDir: filepath.Join(t.TempDir(), ".deployd")}
+	targetDB, err := database.NewDatabase(database.DatabaseTypeSQLite, &database.Config{Name: filepath.Join(t.TempDir(), "restored.db")})
+	require.NoError(t, err)
+	defer targetDB.Close()
+
+	restored, err := Restore(ctx, bytes.NewReader(archive.Bytes()), targetDB, target)
+	require.NoError(t, err)
+	assert.Equal(t, manifest.Collections, restored.Collections)
+
+	t.Run("restores configs and event scripts", func(t *testing.T) {
+		for _, file := range []string{"products/config.json", "products/post.js", "hooks/config.json", "users/config.json"} {
+			want, err := os.ReadFile(filepath.Join(source.ResourcesDir, file))
+			require.NoError(t, err)
+			got, err := os.ReadFile(filepath.Join(target.ResourcesDir, file))
+			require.NoError(t, err, file)
+			assert.Equal(t, string(want), string(got), file)
+		}
+
+		info, err := os.Stat(filepath.Join(target.ConfigDir, "security.json"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		data, err := os.ReadFile(filepath.Join(target.ConfigDir, "security.json"))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"masterKey": "mk_test"}`, string(data))
+	})
+
+	t.Run("restores documents as they were", func(t *testing.T) {
+		assert.Equal(t, exportAll(t, sourceDB, source.ResourcesDir), exportAll(t, targetDB, target.ResourcesDir))
+
+		doc, err := targetDB.CreateStore("products").FindOne(ctx, database.NewQueryBuilder().Where("id", "$eq", "p1"))
+		require.NoError(t, err)
+		assert.Equal(t, "Lamp", doc["name"], "events don't run")
+		assert.Equal(t, "2020-01-02T03:04:05Z", doc["createdAt"])
+	})
+
+	t.Run("restored collections keep their unique indexes", func(t *testing.T) {
+		collection := storedCollections(targetDB, target.ResourcesDir)["products"]
+		require.NotNil(t, collection)
+		_, err := targetDB.CreateStore("products").Insert(ctx, map[string]interface{}{"name": "Copy", "sku": "s-1"})
+		require.NoError(t, err)
+		_, err = targetDB.CreateStore("products").Insert(ctx, map[string]interface{}{"name": "Copy 2", "sku": "s-1"})
+		_, isDuplicate := database.IsDuplicateKey(err, "products")
+		assert.True(t, isDuplicate)
+	})
+
+	t.Run("refuses to restore over documents", func(t *testing.T) {
+		other := Options{ResourcesDir: filepath.Join(t.TempDir(), "resources"), ConfigDir: filepath.Join(t.TempDir(), ".deployd")}
+		_, err := Restore(ctx, bytes.NewReader(archive.Bytes()), targetDB, other)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "already holds")
+		assert.NoDirExists(t, other.ResourcesDir, "no file is written")
+		assert.NoDirExists(t, other.ConfigDir, "no file is written")
+	})
+
+	t.Run("writes no files when documents fail to restore", func(t *testing.T) {
+		failing, err := database.NewDatabase(database.DatabaseTypeSQLite, &database.Config{Name: filepath.Join(t.TempDir(), "failing.db")})
+		require.NoError(t, err)
+		defer failing.Close()
+
+		other := Options{ResourcesDir: filepath.Join(t.TempDir(), "resources"), ConfigDir: filepath.Join(t.TempDir(), ".deployd")}
+		_, err = Restore(ctx, bytes.NewReader(archive.Bytes()), failingInserts{failing}, other)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to restore")
+		assert.NoDirExists(t, other.ResourcesDir)
+		assert.NoDirExists(t, other.ConfigDir)
+
+		entries, err := os.ReadDir(filepath.Dir(other.ResourcesDir))
+		require.NoError(t, err)
+		assert.Empty(t, entries, "the staging directory is removed")
+	})
+
+	t.Run("rejects other files", func(t *testing.T) {
+		_, err := Restore(ctx, bytes.NewReader([]byte("not an archive")), targetDB, target)
+		assert.Error(t, err)
+	})
+}
+
+// failingInserts is a database whose stores fail to insert documents
+type failingInserts struct {
+	database.DatabaseInterface
+}
+
+func (db failingInserts) CreateStore(namespace string) database.StoreInterface {
+	return failingStore{db.DatabaseInterface.CreateStore(namespace)}
+}
+
+type failingStore struct {
+	database.StoreInterface
+}
+
+func (failingStore) InsertMany(context.Context, []map[string]interface{}) ([]map[string]interface{}, error) {
+	return nil, errors.New("disk full")
+}
+
+func TestRestoreDates(t *testing.T) {
+	properties := map[string]resources.Property{"due": {Type: "date"}, "note": {Type: "string"}}
+	doc := restoreDates(map[string]interface{}{"due": "2024-06-01T10:00:00.5Z", "note": "2024-06-01T10:00:00Z"}, properties)
+	assert.Equal(t, time.Date(2024, 6, 1, 10, 0, 0, 5e8, time.UTC), doc["due"])
+	assert.Equal(t, "2024-06-01T10:00:00Z", doc["note"])
+}
@@ -0,0 +1,268 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/database"
+	"github.com/hjanuschka/go-deployd/internal/resources"
+)
+
+// Restore rebuilds the app in an archive read from r: it loads the
+// collections into db, inserts their documents as they were saved, without
+// validation or events, and then writes the configuration and resources
+// files. Collections are restored one transaction each, when db supports
+// them. Every collection of the archive must be empty in db.
+//
+// The archive is extracted to a staging directory next to the resources
+// directory first, and all of it is checked before anything is written, so
+// an invalid archive or a database that already holds documents leaves the
+// app as it was. The files are only moved into place once every collection
+// has been restored.
+func Restore(ctx context.Context, r io.Reader, db database.DatabaseInterface, opts Options) (*Manifest, error) {
+	opts = opts.withDefaults()
+
+	if err := os.MkdirAll(filepath.Dir(filepath.Clean(opts.ResourcesDir)), 0755); err != nil {
+		return nil, err
+	}
+	stagingDir, err := os.MkdirTemp(filepath.Dir(filepath.Clean(opts.ResourcesDir)), ".restore-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(stagingDir)
+
+	staged, err := extractArchive(r, stagingDir)
+	if err != nil {
+		return nil, err
+	}
+
+	collections := storedCollections(db, filepath.Join(stagingDir, "resources"))
+	for _, name := range staged.collections {
+		if collections[name] == nil {
+			return nil, fmt.Errorf("archive holds documents of %s, which has no config", name)
+		}
+	}
+	if err := checkEmpty(ctx, db, collections, staged.manifest); err != nil {
+		return nil, err
+	}
+
+	for _, name := range staged.collections {
+		if err := restoreCollectionFile(ctx, db, collections[name], filepath.Join(stagingDir, "data", name+".ndjson")); err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", name, err)
+		}
+	}
+
+	targets := map[string]string{"resources": opts.ResourcesDir, "config": opts.ConfigDir}
+	for _, file := range staged.files {
+		dir, rel, _ := strings.Cut(file, "/")
+		if err := moveFile(filepath.Join(stagingDir, filepath.FromSlash(file)), filepath.Join(targets[dir], filepath.FromSlash(rel))); err != nil {
+			return nil, err
+		}
+	}
+	return staged.manifest, nil
+}
+
+// stagedArchive is an archive extracted to a staging directory
+type stagedArchive struct {
+	manifest *Manifest
+	// files are the paths of the config and resources files, relative to
+	// the staging directory, under config/ and resources/
+	files []string
+	// collections are the names of the collections with documents, whose
+	// NDJSON files are in data/
+	collections []string
+}
+
+// extractArchive checks the manifest of the archive read from r and
+// extracts its files to dir
+func extractArchive(r io.Reader, dir string) (*stagedArchive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	staged := &stagedArchive{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("invalid archive entry %s", header.Name)
+		}
+
+		if staged.manifest == nil {
+			if name != "manifest.json" {
+				return nil, fmt.Errorf("not a backup archive: missing manifest.json")
+			}
+			staged.manifest = &Manifest{}
+			if err := json.NewDecoder(tr).Decode(staged.manifest); err != nil {
+				return nil, fmt.Errorf("invalid manifest: %w", err)
+			}
+			if staged.manifest.Version > FormatVersion {
+				return nil, fmt.Errorf("archive format %d is newer than the supported %d", staged.manifest.Version, FormatVersion)
+			}
+			continue
+		}
+
+		var file string
+		mode := os.FileMode(header.Mode).Perm()
+		switch {
+		case strings.HasPrefix(name, ".deployd/"):
+			// Server configuration holds secrets
+			file, mode = "config/"+strings.TrimPrefix(name, ".deployd/"), 0600
+			staged.files = append(staged.files, file)
+		case strings.HasPrefix(name, "resources/"):
+			file = name
+			staged.files = append(staged.files, file)
+		case strings.HasPrefix(name, "data/") && strings.HasSuffix(name, ".ndjson"):
+			file = name
+			staged.collections = append(staged.collections, strings.TrimSuffix(strings.TrimPrefix(name, "data/"), ".ndjson"))
+		default:
+			continue
+		}
+		if err := extractFile(tr, filepath.Join(dir, filepath.FromSlash(file)), mode); err != nil {
+			return nil, err
+		}
+	}
+	if staged.manifest == nil {
+		return nil, fmt.Errorf("not a backup archive: missing manifest.json")
+	}
+	return staged, nil
+}
+
+// checkEmpty fails if a collection to restore already holds documents
+func checkEmpty(ctx context.Context, db database.DatabaseInterface, collections map[string]*resources.Collection, manifest *Manifest) error {
+	for name := range manifest.Collections {
+		if collections[name] == nil {
+			continue
+		}
+		count, err := db.CreateStore(name).Count(ctx, database.NewQueryBuilder())
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("collection %s already holds %d documents; restore into an empty database", name, count)
+		}
+	}
+	return nil
+}
+
+func extractFile(r io.Reader, target string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// moveFile moves a staged file to target, copying it when the two are on
+// different file systems
+func moveFile(staged, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, target); err == nil {
+		return nil
+	}
+	info, err := os.Stat(staged)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(staged)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return extractFile(file, target, info.Mode().Perm())
+}
+
+// restoreCollectionFile inserts the documents of a staged NDJSON file
+func restoreCollectionFile(ctx context.Context, db database.DatabaseInterface, collection *resources.Collection, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return restoreCollection(ctx, db, collection, file)
+}
+
+// restoreCollection inserts the NDJSON documents read from r
+func restoreCollection(ctx context.Context, db database.DatabaseInterface, collection *resources.Collection, r io.Reader) (err error) {
+	properties := collection.GetConfig().Properties
+	decoder, err := resources.NewDocumentDecoder(r, resources.FormatNDJSON, properties)
+	if err != nil {
+		return err
+	}
+
+	if tx, ok := db.(database.Transactional); ok {
+		if ctx, err = tx.Begin(ctx); err != nil {
+			return err
+		}
+		defer func() {
+			if err == nil {
+				err = tx.Commit(ctx)
+			}
+			tx.Rollback(ctx)
+		}()
+	}
+
+	store := db.CreateStore(collection.GetName())
+	batch := make([]map[string]interface{}, 0, restoreBatch)
+	for {
+		doc, err := decoder.Decode()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if doc != nil {
+			batch = append(batch, restoreDates(doc, properties))
+		}
+		if len(batch) > 0 && (len(batch) == restoreBatch || err == io.EOF) {
+			if _, err := store.InsertMany(ctx, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// restoreDates turns the date properties of a document, saved as strings,
+// back into times, so databases with a date type store them as dates
+func restoreDates(doc map[string]interface{}, properties map[string]resources.Property) map[string]interface{} {
+	for name, prop := range properties {
+		if text, ok := doc[name].(string); ok && prop.Type == "date" {
+			if t, err := time.Parse(time.RFC3339Nano, text); err == nil {
+				doc[name] = t
+			}
+		}
+	}
+	return doc
+}
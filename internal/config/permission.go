@@ -0,0 +1,74 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+)
+
+// Permission levels
+const (
+	PermissionPublic        = "public"
+	PermissionAuthenticated = "authenticated"
+	PermissionRoot          = "root"
+)
+
+// Permission says who may do something, such as use an HTTP method on a
+// collection or join a realtime room. In JSON it is either a level
+// ("public", "authenticated", "root") or a list of roles, any of which
+// grants access.
+type Permission struct {
+	Level string
+	Roles []string
+}
+
+func (p Permission) MarshalJSON() ([]byte, error) {
+	if p.Roles != nil {
+		return json.Marshal(p.Roles)
+	}
+	return json.Marshal(p.Level)
+}
+
+func (p *Permission) UnmarshalJSON(data []byte) error {
+	var level string
+	if err := json.Unmarshal(data, &level); err == nil {
+		switch level {
+		case PermissionPublic, PermissionAuthenticated, PermissionRoot:
+			*p = Permission{Level: level}
+			return nil
+		}
+		return fmt.Errorf("unknown permission level %q", level)
+	}
+
+	var roles []string
+	if err := json.Unmarshal(data, &roles); err != nil {
+		return fmt.Errorf("permission must be a level or a list of roles")
+	}
+	*p = Permission{Roles: roles}
+	return nil
+}
+
+// Allows reports whether the caller in ctx satisfies the permission
+func (p Permission) Allows(ctx *appcontext.Context) bool {
+	if ctx.IsRoot {
+		return true
+	}
+	if p.Roles != nil {
+		for _, role := range p.Roles {
+			if ctx.HasRole(role) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch p.Level {
+	case PermissionAuthenticated:
+		return ctx.IsAuthenticated
+	case PermissionRoot:
+		return false
+	default:
+		return true
+	}
+}
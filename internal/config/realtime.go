@@ -41,6 +41,7 @@ type RabbitConfig struct {
 	Password string `json:"password"` // RabbitMQ password
 	VHost    string `json:"vhost"`    // RabbitMQ virtual host
 	Exchange string `json:"exchange"` // Exchange name for deployd messages
+	TLS      bool   `json:"tls"`      // Connect with TLS (amqps)
 }
 
 // NATSConfig holds NATS configuration
@@ -50,6 +51,7 @@ type NATSConfig struct {
 	Username string `json:"username"` // NATS username (optional)
 	Password string `json:"password"` // NATS password (optional)
 	Subject  string `json:"subject"`  // Subject prefix for deployd messages
+	TLS      bool   `json:"tls"`      // Connect with TLS
 }
 
 // LimitsConfig holds connection and rate limiting configuration
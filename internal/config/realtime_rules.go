@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RealtimeRules control what WebSocket and SSE clients may do. For each
+// action the first rule whose pattern matches decides, and rooms and events
+// that no rule matches are open to every client. Patterns match names
+// exactly, except that "*" matches any run of characters.
+//
+// The rules of security.json apply to every room and event. Those of a
+// collection config only apply to the collection's rooms (see
+// RoomCollection) and to the events emitted to them.
+type RealtimeRules struct {
+	Rooms      []RoomRule  `json:"rooms,omitempty"`      // Who may join and emit to rooms
+	Events     []EventRule `json:"events,omitempty"`     // Who may emit events
+	ServerOnly []string    `json:"serverOnly,omitempty"` // Event patterns no client may emit
+}
+
+// RoomRule controls access to the rooms matching Pattern
+type RoomRule struct {
+	Pattern string      `json:"pattern"`        // Room name pattern, e.g. "chat:*"
+	Join    *Permission `json:"join,omitempty"` // Who may join, unrestricted if not set
+	Emit    *Permission `json:"emit,omitempty"` // Who may emit to the room, unrestricted if not set
+}
+
+// EventRule controls who may emit the client events matching Pattern
+type EventRule struct {
+	Pattern string      `json:"pattern"` // Event name pattern, e.g. "typing"
+	Emit    *Permission `json:"emit"`    // Who may emit the event
+}
+
+// RoomCollection returns the collection a room belongs to: <name> for the
+// room collection:<name>, which delivers the collection's changes, and for
+// rooms named <name>:<anything>. Other rooms belong to no collection.
+func RoomCollection(room string) string {
+	if name, ok := strings.CutPrefix(room, "collection:"); ok {
+		return name
+	}
+	if name, _, ok := strings.Cut(room, ":"); ok {
+		return name
+	}
+	return ""
+}
+
+// Validate checks that every rule has a pattern and that event rules say who
+// may emit
+func (r *RealtimeRules) Validate() error {
+	for i, rule := range r.Rooms {
+		if rule.Pattern == "" {
+			return fmt.Errorf("room rule %d has no pattern", i)
+		}
+	}
+	for i, rule := range r.Events {
+		if rule.Pattern == "" {
+			return fmt.Errorf("event rule %d has no pattern", i)
+		}
+		if rule.Emit == nil {
+			return fmt.Errorf("event rule %q does not say who may emit", rule.Pattern)
+		}
+	}
+	for _, pattern := range r.ServerOnly {
+		if pattern == "" {
+			return fmt.Errorf("empty server-only event pattern")
+		}
+	}
+	return nil
+}
+
+// ValidateCollection checks the rules of a collection config: they must be
+// valid and only name rooms of the collection, which are the only ones they
+// apply to
+func (r *RealtimeRules) ValidateCollection(collection string) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	for _, rule := range r.Rooms {
+		if RoomCollection(rule.Pattern) != collection {
+			return fmt.Errorf("room pattern %q is not a room of collection %s: use collection:%s or %s:<room>", rule.Pattern, collection, collection, collection)
+		}
+	}
+	return nil
+}
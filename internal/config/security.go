@@ -13,12 +13,13 @@ import (
 
 // SecurityConfig holds security-related configuration
 type SecurityConfig struct {
-	MasterKey           string      `json:"masterKey"`
-	AllowRegistration   bool        `json:"allowRegistration"`   // allow public user registration
-	JWTSecret           string      `json:"jwtSecret"`           // JWT signing secret
-	JWTExpiration       string      `json:"jwtExpiration"`       // JWT expiration duration (e.g., "24h", "1d")
-	RequireVerification bool        `json:"requireVerification"` // require email verification for new users
-	Email               EmailConfig `json:"email"`               // email configuration for verification
+	MasterKey           string        `json:"masterKey"`
+	AllowRegistration   bool          `json:"allowRegistration"`   // allow public user registration
+	JWTSecret           string        `json:"jwtSecret"`           // JWT signing secret
+	JWTExpiration       string        `json:"jwtExpiration"`       // JWT expiration duration (e.g., "24h", "1d")
+	RequireVerification bool          `json:"requireVerification"` // require email verification for new users
+	Email               EmailConfig   `json:"email"`               // email configuration for verification
+	Realtime            RealtimeRules `json:"realtime"`            // who may join rooms and emit events over WebSocket and SSE
 }
 
 // EmailConfig holds email service configuration
@@ -114,6 +115,9 @@ func LoadSecurityConfig(configDir string) (*SecurityConfig, error) {
 	if err := json.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse security config: %w", err)
 	}
+	if err := config.Realtime.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid realtime rules: %w", err)
+	}
 
 	// Generate master key if it's missing
 	if config.MasterKey == "" {
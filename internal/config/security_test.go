@@ -244,6 +244,48 @@ func TestLoadSecurityConfig(t *testing.T) {
 		assert.Nil(t, cfg)
 		assert.Contains(t, err.Error(), "failed to parse security config")
 	})
+
+	t.Run("Load config with realtime rules", func(t *testing.T) {
+		tempDir := t.TempDir()
+		configFile := filepath.Join(tempDir, "security.json")
+		err := os.WriteFile(configFile, []byte(`{
+			"masterKey": "mk",
+			"jwtSecret": "secret",
+			"jwtExpiration": "24h",
+			"realtime": {
+				"rooms": [{"pattern": "admin:*", "join": "root", "emit": ["admin"]}],
+				"events": [{"pattern": "typing", "emit": "authenticated"}],
+				"serverOnly": ["system:*"]
+			}
+		}`), 0600)
+		require.NoError(t, err)
+
+		cfg, err := config.LoadSecurityConfig(tempDir)
+		require.NoError(t, err)
+		require.Len(t, cfg.Realtime.Rooms, 1)
+		assert.Equal(t, "admin:*", cfg.Realtime.Rooms[0].Pattern)
+		assert.Equal(t, config.PermissionRoot, cfg.Realtime.Rooms[0].Join.Level)
+		assert.Equal(t, []string{"admin"}, cfg.Realtime.Rooms[0].Emit.Roles)
+		assert.Equal(t, config.PermissionAuthenticated, cfg.Realtime.Events[0].Emit.Level)
+		assert.Equal(t, []string{"system:*"}, cfg.Realtime.ServerOnly)
+	})
+
+	t.Run("Load config with invalid realtime rules", func(t *testing.T) {
+		tempDir := t.TempDir()
+		configFile := filepath.Join(tempDir, "security.json")
+
+		err := os.WriteFile(configFile, []byte(`{"realtime": {"rooms": [{"pattern": "chat", "join": "everyone"}]}}`), 0600)
+		require.NoError(t, err)
+		_, err = config.LoadSecurityConfig(tempDir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `unknown permission level "everyone"`)
+
+		err = os.WriteFile(configFile, []byte(`{"realtime": {"events": [{"pattern": "typing"}]}}`), 0600)
+		require.NoError(t, err)
+		_, err = config.LoadSecurityConfig(tempDir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid realtime rules")
+	})
 }
 
 func TestEmailConfigStruct(t *testing.T) {
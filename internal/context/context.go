@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -23,7 +24,10 @@ type Context struct {
 	Username        string
 	IsRoot          bool
 	IsAuthenticated bool
-	ctx             context.Context
+	Roles           []string
+	// Internal is set for requests made by event scripts through dpd
+	Internal bool
+	ctx      context.Context
 }
 
 type Resource interface {
@@ -41,6 +45,7 @@ type AuthData struct {
 	Username        string
 	IsRoot          bool
 	IsAuthenticated bool
+	Roles           []string
 }
 
 func New(req *http.Request, res http.ResponseWriter, resource Resource, auth *AuthData, development bool) *Context {
@@ -59,6 +64,7 @@ func New(req *http.Request, res http.ResponseWriter, resource Resource, auth *Au
 		ctx.Username = auth.Username
 		ctx.IsRoot = auth.IsRoot
 		ctx.IsAuthenticated = auth.IsAuthenticated
+		ctx.Roles = auth.Roles
 	}
 
 	ctx.parseURL()
@@ -176,6 +182,36 @@ func (c *Context) WriteError(statusCode int, message string) error {
 	})
 }
 
+// WriteValidationErrors responds with 400 and a map of field names to error
+// messages, the format used for both schema and event validation failures
+func (c *Context) WriteValidationErrors(errors map[string]string) error {
+	return c.WriteFieldErrors(http.StatusBadRequest, errors)
+}
+
+// WriteFieldErrors responds with the given status and a map of field names to
+// error messages
+func (c *Context) WriteFieldErrors(statusCode int, errors map[string]string) error {
+	fields := make([]string, 0, len(errors))
+	for field := range errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		parts = append(parts, field+": "+errors[field])
+	}
+
+	c.Response.Header().Set("Content-Type", "application/json")
+	c.Response.WriteHeader(statusCode)
+	return json.NewEncoder(c.Response).Encode(map[string]interface{}{
+		"error":   true,
+		"message": "validation errors: " + strings.Join(parts, ", "),
+		"status":  statusCode,
+		"errors":  errors,
+	})
+}
+
 func (c *Context) GetID() string {
 	// Try to get ID from URL path
 	if c.URL != "/" {
@@ -202,10 +238,26 @@ func (c *Context) GetID() string {
 	return ""
 }
 
+// HasRole reports whether the authenticated user has the given role
+func (c *Context) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Context) Context() context.Context {
 	return c.ctx
 }
 
+// SetContext replaces the request's context.Context, e.g. with one carrying
+// a database transaction
+func (c *Context) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
 func (c *Context) Done(err error, result interface{}) {
 	if err != nil {
 		c.WriteError(500, err.Error())
@@ -95,7 +95,7 @@ func (s *ColumnStore) Insert(ctx context.Context, document interface{}) (interfa
 	}
 
 	// Execute insert
-	_, err = s.db.ExecContext(ctx, sql, args...)
+	_, err = sqlConn(ctx, s.db).ExecContext(ctx, s.bind(sql), args...)
 	if err != nil {
 		err = fmt.Errorf("failed to insert document: %w", err)
 		metrics.RecordDatabaseOperation("insert", time.Since(start), err)
@@ -105,6 +105,31 @@ func (s *ColumnStore) Insert(ctx context.Context, document interface{}) (interfa
 	return doc, nil
 }
 
+// InsertMany inserts documents in one transaction, so either all of them are
+// inserted or none is. Documents can fill different columns, so each is
+// inserted with its own statement.
+func (s *ColumnStore) InsertMany(ctx context.Context, documents []map[string]interface{}) ([]map[string]interface{}, error) {
+	insertAll := func(ctx context.Context) error {
+		for _, doc := range documents {
+			if _, err := s.Insert(ctx, doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if db, ok := s.database.(Transactional); ok {
+		err = inTx(ctx, db, insertAll)
+	} else {
+		err = insertAll(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return documents, nil
+}
+
 // separateData separates document data into column values and JSON overflow
 func (s *ColumnStore) separateData(doc map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
 	if !s.schema.UseColumns {
@@ -260,7 +285,7 @@ func (s *ColumnStore) Find(ctx context.Context, query QueryBuilder, opts QueryOp
 		return nil, fmt.Errorf("failed to build select SQL: %w", err)
 	}
 
-	rows, err := s.db.QueryContext(ctx, sql, args...)
+	rows, err := sqlConn(ctx, s.db).QueryContext(ctx, sql, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query documents: %w", err)
 	}
@@ -283,7 +308,10 @@ func (s *ColumnStore) buildSelectSQL(query QueryBuilder, opts QueryOptions) (str
 	var args []interface{}
 
 	// Build WHERE clause using column-aware query builder
-	whereClause, whereArgs := s.buildWhereClause(query)
+	whereClause, whereArgs, err := s.buildWhereClause(query)
+	if err != nil {
+		return "", nil, err
+	}
 	if whereClause != "" {
 		sql += " WHERE " + whereClause
 		args = append(args, whereArgs...)
@@ -291,7 +319,7 @@ func (s *ColumnStore) buildSelectSQL(query QueryBuilder, opts QueryOptions) (str
 
 	// Add ORDER BY with column-aware sorting
 	if len(opts.Sort) > 0 {
-		orderClause := s.buildOrderClause(opts.Sort)
+		orderClause := s.buildOrderClause(opts)
 		sql += " ORDER BY " + orderClause
 	}
 
@@ -369,15 +397,21 @@ func (s *ColumnStore) hasColumn(fieldName string) bool {
 }
 
 // buildWhereClause builds a column-aware WHERE clause
-func (s *ColumnStore) buildWhereClause(query QueryBuilder) (string, []interface{}) {
+func (s *ColumnStore) buildWhereClause(query QueryBuilder) (string, []interface{}, error) {
+	if s.isPostgres() {
+		// PostgreSQL needs positional placeholders and jsonb accessors
+		return newPostgresWhereBuilder(s.hasColumn).Build(query.ToMap())
+	}
+
 	if sqlQuery, ok := query.(*SQLQueryBuilder); ok {
-		return sqlQuery.ToSQL()
+		whereClause, args := sqlQuery.ToSQL()
+		return whereClause, args, nil
 	}
 
 	// Convert from map-based query with column awareness
 	queryMap := query.ToMap()
 	if len(queryMap) == 0 {
-		return "", nil
+		return "", nil, nil
 	}
 
 	sqlBuilder := NewSQLQueryBuilder()
@@ -390,7 +424,8 @@ func (s *ColumnStore) buildWhereClause(query QueryBuilder) (string, []interface{
 	fmt.Printf("DEBUG: ColumnStore UseColumns: %v\n", s.schema.UseColumns)
 	
 	s.convertMapToColumnSQL(queryMap, sqlBuilder)
-	return sqlBuilder.ToSQL()
+	whereClause, args := sqlBuilder.ToSQL()
+	return whereClause, args, nil
 }
 
 // convertMapToColumnSQL converts a MongoDB-style query to column-aware SQL
@@ -460,12 +495,16 @@ func (s *ColumnStore) mongoOpToSQL(op string) string {
 }
 
 // buildOrderClause builds column-aware ORDER BY clause
-func (s *ColumnStore) buildOrderClause(sort map[string]int) string {
+func (s *ColumnStore) buildOrderClause(opts QueryOptions) string {
+	if s.isPostgres() {
+		return postgresOrderClause(opts, s.hasColumn)
+	}
+
 	var orderParts []string
 
-	for field, direction := range sort {
+	for _, field := range opts.SortFields() {
 		dir := "ASC"
-		if direction == -1 {
+		if opts.Sort[field] == -1 {
 			dir = "DESC"
 		}
 
@@ -515,10 +554,17 @@ func (s *ColumnStore) scanRows(rows *sql.Rows, fields map[string]int) ([]map[str
 			value := values[i]
 
 			if columnName == "data" && value != nil {
-				// Merge JSON data
-				if jsonStr, ok := value.(string); ok {
+				// Merge JSON data (drivers return JSON columns as string or []byte)
+				var jsonBytes []byte
+				switch v := value.(type) {
+				case string:
+					jsonBytes = []byte(v)
+				case []byte:
+					jsonBytes = v
+				}
+				if jsonBytes != nil {
 					var jsonData map[string]interface{}
-					if err := json.Unmarshal([]byte(jsonStr), &jsonData); err == nil {
+					if err := json.Unmarshal(jsonBytes, &jsonData); err == nil {
 						for k, v := range jsonData {
 							doc[k] = v
 						}
@@ -599,6 +645,31 @@ func (s *ColumnStore) quoteIdentifier(name string) string {
 	return s.schemaManager.quoteIdentifier(name)
 }
 
+// isPostgres reports whether the store is backed by PostgreSQL
+func (s *ColumnStore) isPostgres() bool {
+	return s.schemaManager.dbType == DatabaseTypePostgres
+}
+
+// dialect returns the QueryTranslator dialect of the underlying database
+func (s *ColumnStore) dialect() string {
+	switch s.schemaManager.dbType {
+	case DatabaseTypeMySQL:
+		return "mysql"
+	case DatabaseTypePostgres:
+		return "postgres"
+	default:
+		return "sqlite"
+	}
+}
+
+// bind rewrites ? placeholders for databases that use positional parameters
+func (s *ColumnStore) bind(query string) string {
+	if s.isPostgres() {
+		return rebindPostgres(query)
+	}
+	return query
+}
+
 // Implement remaining StoreInterface methods by delegating to appropriate logic...
 
 func (s *ColumnStore) FindOne(ctx context.Context, query QueryBuilder) (map[string]interface{}, error) {
@@ -644,16 +715,22 @@ func (s *ColumnStore) performUpdate(ctx context.Context, query QueryBuilder, upd
 	modifiedCount := int64(0)
 
 	for _, doc := range existingDocs {
-		if err := s.updateSingleDocument(ctx, doc, updateMap); err != nil {
+		// The row is only written if it still matches the query, so
+		// concurrent updates conditioned on a document's state (such as
+		// claiming a job) cannot both apply
+		written, err := s.updateSingleDocument(ctx, doc, updateMap, query)
+		if err != nil {
 			return nil, fmt.Errorf("failed to update document: %w", err)
 		}
-		modifiedCount++
+		if written {
+			modifiedCount++
+		}
 	}
 
 	return &SQLiteUpdateResult{modifiedCount: modifiedCount}, nil
 }
 
-func (s *ColumnStore) updateSingleDocument(ctx context.Context, doc map[string]interface{}, updateMap map[string]interface{}) error {
+func (s *ColumnStore) updateSingleDocument(ctx context.Context, doc map[string]interface{}, updateMap map[string]interface{}, condition QueryBuilder) (bool, error) {
 	originalDoc := make(map[string]interface{})
 	for k, v := range doc {
 		originalDoc[k] = v
@@ -667,22 +744,60 @@ func (s *ColumnStore) updateSingleDocument(ctx context.Context, doc map[string]i
 
 	// Check if document actually changed
 	if s.documentsEqual(originalDoc, doc) {
-		return nil // No changes
+		return false, nil // No changes
 	}
 
 	// Separate data and build UPDATE SQL
 	columnValues, jsonData, err := s.separateData(doc)
 	if err != nil {
-		return fmt.Errorf("failed to separate data: %w", err)
+		return false, fmt.Errorf("failed to separate data: %w", err)
 	}
 
 	sql, args, err := s.buildUpdateSQL(columnValues, jsonData, doc["id"])
 	if err != nil {
-		return fmt.Errorf("failed to build update SQL: %w", err)
+		return false, fmt.Errorf("failed to build update SQL: %w", err)
 	}
 
-	_, err = s.db.ExecContext(ctx, sql, args...)
-	return err
+	sql, args, err = s.conditionalUpdateSQL(sql, args, condition)
+	if err != nil {
+		return false, fmt.Errorf("failed to build update condition: %w", err)
+	}
+
+	result, err := sqlConn(ctx, s.db).ExecContext(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// conditionalUpdateSQL binds an UPDATE statement built by buildUpdateSQL and
+// restricts it to rows that still match condition
+func (s *ColumnStore) conditionalUpdateSQL(sql string, args []interface{}, condition QueryBuilder) (string, []interface{}, error) {
+	if s.isPostgres() {
+		// Number the condition's placeholders after the statement's own
+		builder := newPostgresWhereBuilder(s.hasColumn)
+		builder.args = append(builder.args, args...)
+		whereClause, allArgs, err := builder.Build(condition.ToMap())
+		if err != nil {
+			return "", nil, err
+		}
+		sql = rebindPostgres(sql)
+		if whereClause != "" {
+			sql += " AND (" + whereClause + ")"
+		}
+		return sql, allArgs, nil
+	}
+
+	whereClause, whereArgs, err := s.buildWhereClause(condition)
+	if err != nil {
+		return "", nil, err
+	}
+	if whereClause != "" {
+		sql += " AND (" + whereClause + ")"
+		args = append(args, whereArgs...)
+	}
+	return sql, args, nil
 }
 
 func (s *ColumnStore) buildUpdateSQL(columnValues map[string]interface{}, jsonData map[string]interface{}, id interface{}) (string, []interface{}, error) {
@@ -824,15 +939,45 @@ func (s *ColumnStore) documentsEqual(a, b map[string]interface{}) bool {
 	return string(aJSON) == string(bJSON)
 }
 
-// Additional StoreInterface methods to implement...
+// Remove deletes every row matching the query
 func (s *ColumnStore) Remove(ctx context.Context, query QueryBuilder) (DeleteResult, error) {
-	// Implementation similar to SQLiteStore.Remove
-	return &SQLiteDeleteResult{deletedCount: 0}, fmt.Errorf("Remove not yet implemented for ColumnStore")
+	deleteSQL := fmt.Sprintf("DELETE FROM %s", s.quoteIdentifier(s.tableName))
+
+	whereClause, args, err := s.buildWhereClause(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build where clause: %w", err)
+	}
+	if whereClause != "" {
+		deleteSQL += " WHERE " + whereClause
+	}
+
+	result, err := sqlConn(ctx, s.db).ExecContext(ctx, deleteSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete documents: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return &SQLiteDeleteResult{deletedCount: rowsAffected}, nil
 }
 
+// Count counts rows matching the query
 func (s *ColumnStore) Count(ctx context.Context, query QueryBuilder) (int64, error) {
-	// Implementation similar to SQLiteStore.Count
-	return 0, fmt.Errorf("Count not yet implemented for ColumnStore")
+	countSQL := fmt.Sprintf("SELECT COUNT(*) FROM %s", s.quoteIdentifier(s.tableName))
+
+	whereClause, args, err := s.buildWhereClause(query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build where clause: %w", err)
+	}
+	if whereClause != "" {
+		countSQL += " WHERE " + whereClause
+	}
+
+	var count int64
+	if err := sqlConn(ctx, s.db).QueryRowContext(ctx, countSQL, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count documents: %w", err)
+	}
+
+	return count, nil
 }
 
 // Additional MongoDB-style operations...
@@ -918,9 +1063,33 @@ func (s *ColumnStore) Upsert(ctx context.Context, query QueryBuilder, update Upd
 }
 
 func (s *ColumnStore) Aggregate(ctx context.Context, pipeline []map[string]interface{}) ([]map[string]interface{}, error) {
-	// Basic aggregation support
-	query := NewQueryBuilder()
-	return s.Find(ctx, query, QueryOptions{})
+	// Push the leading stages into SQL and evaluate whatever is left in memory
+	plan, err := NewQueryTranslator(s.dialect()).TranslatePipeline(PipelineSource{
+		Table:     s.tableName,
+		Select:    "*",
+		HasColumn: s.hasColumn,
+	}, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to translate aggregation pipeline: %w", err)
+	}
+
+	rows, err := sqlConn(ctx, s.db).QueryContext(ctx, plan.SQL, plan.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run aggregation: %w", err)
+	}
+	defer rows.Close()
+
+	var docs []map[string]interface{}
+	if plan.Grouped {
+		docs, err = plan.ScanGroups(rows)
+	} else {
+		docs, err = s.scanRows(rows, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return evaluatePipeline(docs, plan.Remaining)
 }
 
 // Enhanced MongoDB-style query methods
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteStore_ConcurrentClaims(t *testing.T) {
+	// The workers need connections of their own, which would each see a
+	// different in-memory database
+	db, err := NewDatabase(DatabaseTypeSQLite, &Config{Name: filepath.Join(t.TempDir(), "claims.db")})
+	require.NoError(t, err)
+	defer cleanupTestDB(db)
+
+	testConcurrentClaims(t, db.CreateStore("claims"))
+}
+
+func TestColumnStore_ConcurrentClaims(t *testing.T) {
+	configDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(configDir, "claims"), 0755))
+	config := `{"properties": {"status": {"type": "string"}, "worker": {"type": "number"}}, "options": {"useColumns": true}}`
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "claims", "config.json"), []byte(config), 0644))
+
+	sqlDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "claims.db")+"?_journal_mode=WAL")
+	require.NoError(t, err)
+	defer sqlDB.Close()
+
+	store, err := NewColumnStore("claims", sqlDB, nil, NewSchemaManager(sqlDB, DatabaseTypeSQLite, configDir))
+	require.NoError(t, err)
+	testConcurrentClaims(t, store)
+}
+
+func TestPostgresColumnStore_ConcurrentClaims(t *testing.T) {
+	db := createTestPostgresDB(t)
+	defer cleanupTestDB(db)
+
+	configDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(configDir, "claims"), 0755))
+	config := `{"properties": {"status": {"type": "string"}, "worker": {"type": "number"}}, "options": {"useColumns": true}}`
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "claims", "config.json"), []byte(config), 0644))
+
+	sqlDB := db.(*PostgresDatabase).db
+	store, err := NewColumnStore("claims", sqlDB, db, NewSchemaManager(sqlDB, DatabaseTypePostgres, configDir))
+	require.NoError(t, err)
+	testConcurrentClaims(t, store)
+}
+
+// testConcurrentClaims races workers to claim every job of store with an
+// update conditioned on its status, the way job queues, webhook deliveries
+// and cron locks do, and checks that each job is claimed exactly once
+func testConcurrentClaims(t *testing.T, store StoreInterface) {
+	ctx := context.Background()
+	const jobs, workers = 50, 16
+
+	documents := make([]map[string]interface{}, jobs)
+	for i := range documents {
+		documents[i] = map[string]interface{}{"status": "pending"}
+	}
+	inserted, err := store.InsertMany(ctx, documents)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	claims := map[string][]int{}
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			<-start
+			for _, doc := range inserted {
+				id := doc["id"].(string)
+				result, err := store.UpdateOne(ctx,
+					NewQueryBuilder().Where("id", "$eq", id).Where("status", "$eq", "pending"),
+					NewUpdateBuilder().Set("status", "claimed").Set("worker", worker))
+				if !assert.NoError(t, err) {
+					return
+				}
+				if result.ModifiedCount() > 0 {
+					mu.Lock()
+					claims[id] = append(claims[id], worker)
+					mu.Unlock()
+				}
+			}
+		}(worker)
+	}
+	close(start)
+	wg.Wait()
+
+	for _, doc := range inserted {
+		id := doc["id"].(string)
+		if !assert.Len(t, claims[id], 1, "job %s", id) {
+			continue
+		}
+		stored, err := store.FindOne(ctx, NewQueryBuilder().Where("id", "$eq", id))
+		require.NoError(t, err)
+		assert.Equal(t, "claimed", stored["status"])
+		assert.EqualValues(t, claims[id][0], stored["worker"])
+	}
+}
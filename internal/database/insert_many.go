@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// insertManyBatchSize is the number of rows per multi-row INSERT statement.
+// Four parameters per row keep a statement below SQLite's default limit of
+// 999 bound parameters.
+const insertManyBatchSize = 200
+
+// jsonRow is a document prepared for a JSON store's (id, data, created_at,
+// updated_at) table
+type jsonRow struct {
+	id   interface{}
+	data string
+	now  time.Time
+}
+
+// prepareJSONRow sets the id and timestamps of a document about to be
+// inserted, like Insert does, and encodes it
+func prepareJSONRow(doc map[string]interface{}, newID func() string) (jsonRow, error) {
+	if _, exists := doc["id"]; !exists {
+		doc["id"] = newID()
+	}
+	now := time.Now()
+	if _, exists := doc["createdAt"]; !exists {
+		doc["createdAt"] = now
+	}
+	if _, exists := doc["updatedAt"]; !exists {
+		doc["updatedAt"] = now
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return jsonRow{}, fmt.Errorf("failed to marshal document: %w", err)
+	}
+	return jsonRow{id: doc["id"], data: string(data), now: now}, nil
+}
+
+// insertJSONRows inserts rows with multi-row INSERT statements. placeholder
+// returns the bind parameter for the nth (1-based) argument of a statement.
+func insertJSONRows(ctx context.Context, conn sqlExecutor, quotedTable string, rows []jsonRow, placeholder func(n int) string) error {
+	for start := 0; start < len(rows); start += insertManyBatchSize {
+		end := start + insertManyBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		values := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*4)
+		for _, row := range rows[start:end] {
+			n := len(args)
+			values = append(values, fmt.Sprintf("(%s, %s, %s, %s)",
+				placeholder(n+1), placeholder(n+2), placeholder(n+3), placeholder(n+4)))
+			args = append(args, row.id, row.data, row.now, row.now)
+		}
+
+		insertSQL := fmt.Sprintf("INSERT INTO %s (id, data, created_at, updated_at) VALUES %s",
+			quotedTable, strings.Join(values, ", "))
+		if _, err := conn.ExecContext(ctx, insertSQL, args...); err != nil {
+			return fmt.Errorf("failed to insert documents: %w", err)
+		}
+	}
+	return nil
+}
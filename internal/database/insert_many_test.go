@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteStore_InsertMany(t *testing.T) {
+	testStoreInsertMany(t, func(t *testing.T) DatabaseInterface {
+		db, err := NewDatabase(DatabaseTypeSQLite, &Config{Name: filepath.Join(t.TempDir(), "bulk.db")})
+		require.NoError(t, err)
+		return db
+	})
+}
+
+func TestPostgresStore_InsertMany(t *testing.T) {
+	testStoreInsertMany(t, createTestPostgresDB)
+}
+
+func testStoreInsertMany(t *testing.T, newDB func(t *testing.T) DatabaseInterface) {
+	db := newDB(t)
+	defer cleanupTestDB(db)
+	require.NoError(t, db.Drop())
+
+	store := db.CreateStore("bulk_items")
+	ctx := context.Background()
+
+	// More documents than fit in one INSERT statement
+	docs := make([]map[string]interface{}, insertManyBatchSize*2+50)
+	for i := range docs {
+		docs[i] = map[string]interface{}{"sku": fmt.Sprintf("sku-%d", i), "n": i}
+	}
+	inserted, err := store.InsertMany(ctx, docs)
+	require.NoError(t, err)
+	require.Len(t, inserted, len(docs))
+	assert.NotEmpty(t, inserted[0]["id"])
+	assert.NotNil(t, inserted[0]["createdAt"])
+
+	count, err := store.Count(ctx, NewQueryBuilder())
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(docs)), count)
+
+	found, err := store.FindOne(ctx, NewQueryBuilder().Where("id", "$eq", inserted[300]["id"]))
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, "sku-300", found["sku"])
+
+	t.Run("inserts nothing when a document fails", func(t *testing.T) {
+		indexer, ok := store.(UniqueIndexer)
+		require.True(t, ok)
+		require.NoError(t, indexer.EnsureUniqueIndex(ctx, "sku"))
+
+		_, err := store.InsertMany(ctx, []map[string]interface{}{
+			{"sku": "new-1"},
+			{"sku": "sku-7"},
+			{"sku": "new-2"},
+		})
+		require.Error(t, err)
+		dupErr, ok := IsDuplicateKey(err, "bulk_items")
+		require.True(t, ok, err.Error())
+		assert.Equal(t, "sku", dupErr.Field)
+
+		count, err := store.Count(ctx, NewQueryBuilder())
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(docs)), count)
+	})
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"sort"
 )
 
 // DatabaseType represents the type of database backend
@@ -36,8 +37,15 @@ type DatabaseInterface interface {
 type StoreInterface interface {
 	CreateUniqueIdentifier() string
 	Insert(ctx context.Context, document interface{}) (interface{}, error)
+	// InsertMany inserts documents in one batch and returns them with their ids
+	// and timestamps set. Either all documents are inserted or none is.
+	InsertMany(ctx context.Context, documents []map[string]interface{}) ([]map[string]interface{}, error)
 	Find(ctx context.Context, query QueryBuilder, opts QueryOptions) ([]map[string]interface{}, error)
 	FindOne(ctx context.Context, query QueryBuilder) (map[string]interface{}, error)
+	// Update and UpdateOne only write a document if it still matches query
+	// at the time of the write, and only count it as modified then. Two
+	// concurrent updates conditioned on the same state (such as claiming a
+	// job by its status) therefore cannot both modify a document.
 	Update(ctx context.Context, query QueryBuilder, update UpdateBuilder) (UpdateResult, error)
 	UpdateOne(ctx context.Context, query QueryBuilder, update UpdateBuilder) (UpdateResult, error)
 	Remove(ctx context.Context, query QueryBuilder) (DeleteResult, error)
@@ -76,10 +84,34 @@ type QueryBuilder interface {
 
 // QueryOptions represents query options like sorting, limiting, etc.
 type QueryOptions struct {
-	Sort   map[string]int // field -> direction (1 or -1)
-	Limit  *int64
-	Skip   *int64
-	Fields map[string]int // field -> include (1) or exclude (0)
+	Sort      map[string]int // field -> direction (1 or -1)
+	SortOrder []string       // Priority of the Sort fields; see SortFields
+	Limit     *int64
+	Skip      *int64
+	Fields    map[string]int // field -> include (1) or exclude (0)
+}
+
+// SortFields returns the Sort fields in the order they are sorted by: those
+// listed in SortOrder first, then the rest by name, so that every store
+// orders multi-field sorts the same way
+func (o QueryOptions) SortFields() []string {
+	fields := make([]string, 0, len(o.Sort))
+	listed := make(map[string]bool, len(o.SortOrder))
+	for _, field := range o.SortOrder {
+		if _, exists := o.Sort[field]; exists && !listed[field] {
+			fields = append(fields, field)
+			listed[field] = true
+		}
+	}
+
+	var rest []string
+	for field := range o.Sort {
+		if !listed[field] {
+			rest = append(rest, field)
+		}
+	}
+	sort.Strings(rest)
+	return append(fields, rest...)
 }
 
 // UpdateBuilder provides a database-agnostic update building interface
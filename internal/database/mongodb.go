@@ -2,6 +2,9 @@ package database
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,6 +15,9 @@ import (
 // MongoDatabase wraps the existing Database struct to implement DatabaseInterface
 type MongoDatabase struct {
 	*Database
+
+	txOnce      sync.Once
+	txSupported bool
 }
 
 // MongoStore wraps the existing Store struct to implement StoreInterface
@@ -63,6 +69,54 @@ func (d *MongoDatabase) GetType() DatabaseType {
 	return DatabaseTypeMongoDB
 }
 
+// supportsTransactions reports whether the deployment is a replica set or a
+// sharded cluster; standalone servers cannot run transactions
+func (d *MongoDatabase) supportsTransactions(ctx context.Context) bool {
+	d.txOnce.Do(func() {
+		var hello bson.M
+		if err := d.client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+			return
+		}
+		_, replicaSet := hello["setName"]
+		d.txSupported = replicaSet || hello["msg"] == "isdbgrid"
+	})
+	return d.txSupported
+}
+
+// Begin starts a transaction in a new session, or joins the one ctx carries.
+// On a standalone server writes are not transactional: they take effect
+// immediately and only the AfterCommit callbacks wait for Commit.
+func (d *MongoDatabase) Begin(ctx context.Context) (context.Context, error) {
+	if joined, err := joinTx(ctx, d); err != nil || joined != nil {
+		return joined, err
+	}
+
+	tx := &transaction{owner: d}
+	if d.supportsTransactions(ctx) {
+		session, err := d.client.StartSession()
+		if err != nil {
+			return nil, fmt.Errorf("failed to start session: %w", err)
+		}
+		if err := session.StartTransaction(); err != nil {
+			session.EndSession(ctx)
+			return nil, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		tx.session = session
+		ctx = mongo.NewSessionContext(ctx, session)
+	}
+	return context.WithValue(ctx, txKey{}, &txFrame{tx: tx}), nil
+}
+
+// Commit commits the transaction ctx carries
+func (d *MongoDatabase) Commit(ctx context.Context) error {
+	return commitTx(ctx, d)
+}
+
+// Rollback aborts the transaction ctx carries
+func (d *MongoDatabase) Rollback(ctx context.Context) error {
+	return rollbackTx(ctx, d)
+}
+
 // Adapter methods for MongoStore to implement StoreInterface
 
 func (s *MongoStore) Find(ctx context.Context, query QueryBuilder, opts QueryOptions) ([]map[string]interface{}, error) {
@@ -75,8 +129,13 @@ func (s *MongoStore) Find(ctx context.Context, query QueryBuilder, opts QueryOpt
 
 	if len(opts.Sort) > 0 {
 		sortBSON := bson.D{}
-		for field, direction := range opts.Sort {
-			sortBSON = append(sortBSON, bson.E{Key: field, Value: direction})
+		for _, field := range opts.SortFields() {
+			key := field
+			if key == "id" {
+				// Documents are stored with their id as _id
+				key = "_id"
+			}
+			sortBSON = append(sortBSON, bson.E{Key: key, Value: opts.Sort[field]})
 		}
 		findOpts.SetSort(sortBSON)
 	}
@@ -112,6 +171,39 @@ func (s *MongoStore) Find(ctx context.Context, query QueryBuilder, opts QueryOpt
 	return mapResults, nil
 }
 
+// InsertMany inserts documents with one ordered insertMany command. Outside a
+// transaction, the documents inserted before a failing one are removed again,
+// so either all of them are inserted or none is.
+func (s *MongoStore) InsertMany(ctx context.Context, documents []map[string]interface{}) ([]map[string]interface{}, error) {
+	bsonDocs := make([]interface{}, len(documents))
+	ids := make([]interface{}, len(documents))
+	for i, doc := range documents {
+		if _, exists := doc["id"]; !exists {
+			doc["id"] = s.CreateUniqueIdentifier()
+		}
+		// Documents are stored with their id as _id
+		bsonDoc := bson.M{"_id": doc["id"]}
+		for k, v := range doc {
+			if k != "id" {
+				bsonDoc[k] = v
+			}
+		}
+		bsonDocs[i] = bsonDoc
+		ids[i] = doc["id"]
+	}
+
+	if _, err := s.collection.InsertMany(ctx, bsonDocs); err != nil {
+		var bulkErr mongo.BulkWriteException
+		if mongo.SessionFromContext(ctx) == nil && errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 {
+			if inserted := ids[:bulkErr.WriteErrors[0].Index]; len(inserted) > 0 {
+				s.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": inserted}})
+			}
+		}
+		return nil, fmt.Errorf("failed to insert documents: %w", err)
+	}
+	return documents, nil
+}
+
 func (s *MongoStore) FindOne(ctx context.Context, query QueryBuilder) (map[string]interface{}, error) {
 	queryMap := query.ToMap()
 	bsonQuery := s.mapToBSON(queryMap)
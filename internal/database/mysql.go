@@ -165,6 +165,21 @@ func (d *MySQLDatabase) GetType() DatabaseType {
 	return DatabaseTypeMySQL
 }
 
+// Begin starts a transaction, or opens a savepoint in the one ctx carries
+func (d *MySQLDatabase) Begin(ctx context.Context) (context.Context, error) {
+	return beginSQLTx(ctx, d, d.db)
+}
+
+// Commit commits the transaction (or releases the savepoint) ctx carries
+func (d *MySQLDatabase) Commit(ctx context.Context) error {
+	return commitTx(ctx, d)
+}
+
+// Rollback rolls back the transaction (or savepoint) ctx carries
+func (d *MySQLDatabase) Rollback(ctx context.Context) error {
+	return rollbackTx(ctx, d)
+}
+
 // ensureTable creates the table if it doesn't exist
 func (s *MySQLStore) ensureTable() error {
 	quotedTable := s.quotedTableName()
@@ -217,7 +232,7 @@ func (s *MySQLStore) Insert(ctx context.Context, document interface{}) (interfac
 	}
 
 	insertSQL := fmt.Sprintf("INSERT INTO %s (id, data, created_at, updated_at) VALUES (?, ?, ?, ?)", s.quotedTableName())
-	_, err = s.db.ExecContext(ctx, insertSQL, doc["id"], string(jsonData), now, now)
+	_, err = sqlConn(ctx, s.db).ExecContext(ctx, insertSQL, doc["id"], string(jsonData), now, now)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert document: %w", err)
 	}
@@ -225,6 +240,27 @@ func (s *MySQLStore) Insert(ctx context.Context, document interface{}) (interfac
 	return doc, nil
 }
 
+// InsertMany inserts documents with multi-row INSERT statements in one
+// transaction, so either all of them are inserted or none is
+func (s *MySQLStore) InsertMany(ctx context.Context, documents []map[string]interface{}) ([]map[string]interface{}, error) {
+	rows := make([]jsonRow, 0, len(documents))
+	for _, doc := range documents {
+		row, err := prepareJSONRow(doc, s.CreateUniqueIdentifier)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+
+	err := inTx(ctx, s.database, func(ctx context.Context) error {
+		return insertJSONRows(ctx, sqlConn(ctx, s.db), s.quotedTableName(), rows, func(int) string { return "?" })
+	})
+	if err != nil {
+		return nil, err
+	}
+	return documents, nil
+}
+
 func (s *MySQLStore) Find(ctx context.Context, query QueryBuilder, opts QueryOptions) ([]map[string]interface{}, error) {
 	baseSQL := fmt.Sprintf("SELECT data FROM %s", s.quotedTableName())
 	var args []interface{}
@@ -239,9 +275,9 @@ func (s *MySQLStore) Find(ctx context.Context, query QueryBuilder, opts QueryOpt
 	// Add ORDER BY
 	if len(opts.Sort) > 0 {
 		var orderParts []string
-		for field, direction := range opts.Sort {
+		for _, field := range opts.SortFields() {
 			dir := "ASC"
-			if direction == -1 {
+			if opts.Sort[field] == -1 {
 				dir = "DESC"
 			}
 			orderParts = append(orderParts, fmt.Sprintf("JSON_EXTRACT(data, '$.%s') %s", field, dir))
@@ -257,7 +293,7 @@ func (s *MySQLStore) Find(ctx context.Context, query QueryBuilder, opts QueryOpt
 		baseSQL += fmt.Sprintf(" OFFSET %d", *opts.Skip)
 	}
 
-	rows, err := s.db.QueryContext(ctx, baseSQL, args...)
+	rows, err := sqlConn(ctx, s.db).QueryContext(ctx, baseSQL, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query documents: %w", err)
 	}
@@ -327,6 +363,14 @@ func (s *MySQLStore) performUpdate(ctx context.Context, query QueryBuilder, upda
 	updateMap := update.ToMap()
 	modifiedCount := int64(0)
 
+	// Each row is only written if it still matches the query, so concurrent
+	// updates conditioned on a document's state (such as claiming a job)
+	// cannot both apply
+	whereClause, whereArgs := s.buildWhereClause(query)
+	if whereClause != "" {
+		whereClause = " AND (" + whereClause + ")"
+	}
+
 	for _, doc := range existingDocs {
 		originalDoc := make(map[string]interface{})
 		for k, v := range doc {
@@ -347,13 +391,16 @@ func (s *MySQLStore) performUpdate(ctx context.Context, query QueryBuilder, upda
 				return nil, fmt.Errorf("failed to marshal updated document: %w", err)
 			}
 
-			updateSQL := fmt.Sprintf("UPDATE %s SET data = ?, updated_at = ? WHERE id = ?", s.quotedTableName())
-			_, err = s.db.ExecContext(ctx, updateSQL, string(jsonData), doc["updatedAt"], doc["id"])
+			updateSQL := fmt.Sprintf("UPDATE %s SET data = ?, updated_at = ? WHERE id = ?%s", s.quotedTableName(), whereClause)
+			args := append([]interface{}{string(jsonData), doc["updatedAt"], doc["id"]}, whereArgs...)
+			result, err := sqlConn(ctx, s.db).ExecContext(ctx, updateSQL, args...)
 			if err != nil {
 				return nil, fmt.Errorf("failed to update document: %w", err)
 			}
 
-			modifiedCount++
+			if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+				modifiedCount++
+			}
 		}
 	}
 
@@ -381,7 +428,7 @@ func (s *MySQLStore) Remove(ctx context.Context, query QueryBuilder) (DeleteResu
 		args = append(args, whereArgs...)
 	}
 
-	_, err = s.db.ExecContext(ctx, deleteSQL, args...)
+	_, err = sqlConn(ctx, s.db).ExecContext(ctx, deleteSQL, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to delete documents: %w", err)
 	}
@@ -400,7 +447,7 @@ func (s *MySQLStore) Count(ctx context.Context, query QueryBuilder) (int64, erro
 	}
 
 	var count int64
-	err := s.db.QueryRowContext(ctx, countSQL, args...).Scan(&count)
+	err := sqlConn(ctx, s.db).QueryRowContext(ctx, countSQL, args...).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count documents: %w", err)
 	}
@@ -493,10 +540,32 @@ func (s *MySQLStore) Upsert(ctx context.Context, query QueryBuilder, update Upda
 }
 
 func (s *MySQLStore) Aggregate(ctx context.Context, pipeline []map[string]interface{}) ([]map[string]interface{}, error) {
-	// Basic aggregation support - this is a simplified implementation
-	// For now, just return all documents
-	query := NewQueryBuilder()
-	return s.Find(ctx, query, QueryOptions{})
+	// Push the leading stages into SQL and evaluate whatever is left in memory
+	plan, err := NewQueryTranslator("mysql").TranslatePipeline(PipelineSource{
+		Table:  s.tableName,
+		Select: "data",
+	}, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to translate aggregation pipeline: %w", err)
+	}
+
+	rows, err := sqlConn(ctx, s.db).QueryContext(ctx, plan.SQL, plan.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run aggregation: %w", err)
+	}
+	defer rows.Close()
+
+	var docs []map[string]interface{}
+	if plan.Grouped {
+		docs, err = plan.ScanGroups(rows)
+	} else {
+		docs, err = scanDocumentRows(rows)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return evaluatePipeline(docs, plan.Remaining)
 }
 
 // Helper methods
@@ -739,7 +808,7 @@ func (s *MySQLStore) FindWithRawQuery(ctx context.Context, mongoQuery interface{
 	}
 
 	// Execute query
-	rows, err := s.db.QueryContext(ctx, query, args...)
+	rows, err := sqlConn(ctx, s.db).QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -830,7 +899,7 @@ func (s *MySQLStore) CountWithRawQuery(ctx context.Context, mongoQuery interface
 	}
 
 	var count int64
-	err = s.db.QueryRowContext(ctx, query, args...).Scan(&count)
+	err = sqlConn(ctx, s.db).QueryRowContext(ctx, query, args...).Scan(&count)
 	return count, err
 }
 
@@ -882,7 +951,7 @@ func (s *MySQLStore) UpdateWithRawQuery(ctx context.Context, mongoQuery interfac
 		updateArgs = append(updateArgs, args...)
 	}
 
-	result, err := s.db.ExecContext(ctx, query, updateArgs...)
+	result, err := sqlConn(ctx, s.db).ExecContext(ctx, query, updateArgs...)
 	if err != nil {
 		return nil, err
 	}
@@ -911,7 +980,7 @@ func (s *MySQLStore) RemoveWithRawQuery(ctx context.Context, mongoQuery interfac
 		query += " WHERE " + whereClause
 	}
 
-	result, err := s.db.ExecContext(ctx, query, args...)
+	result, err := sqlConn(ctx, s.db).ExecContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+// createTestMySQLDB connects to the MySQL server described by the
+// MYSQL_HOST, MYSQL_PORT, MYSQL_USER, MYSQL_PASS and MYSQL_DB environment
+// variables. Tests are skipped when MYSQL_HOST is not set.
+func createTestMySQLDB(t *testing.T) DatabaseInterface {
+	host := os.Getenv("MYSQL_HOST")
+	if host == "" {
+		t.Skip("MYSQL_HOST not set, skipping MySQL store tests")
+	}
+
+	port := 3306
+	if envPort := os.Getenv("MYSQL_PORT"); envPort != "" {
+		if p, err := strconv.Atoi(envPort); err == nil {
+			port = p
+		}
+	}
+
+	name := os.Getenv("MYSQL_DB")
+	if name == "" {
+		name = "deployd_test"
+	}
+
+	db, err := NewDatabase(DatabaseTypeMySQL, &Config{
+		Host:     host,
+		Port:     port,
+		Name:     name,
+		Username: os.Getenv("MYSQL_USER"),
+		Password: os.Getenv("MYSQL_PASS"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+
+	// Start every test from an empty database
+	if err := db.Drop(); err != nil {
+		t.Fatalf("Failed to reset test database: %v", err)
+	}
+
+	return db
+}
+
+func TestMySQLStore_ConcurrentClaims(t *testing.T) {
+	db := createTestMySQLDB(t)
+	defer cleanupTestDB(db)
+	testConcurrentClaims(t, db.CreateStore("claims"))
+}
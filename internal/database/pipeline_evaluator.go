@@ -0,0 +1,791 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// evaluatePipeline runs MongoDB-style aggregation stages against documents in memory.
+// SQL stores use it for every stage the QueryTranslator could not push into SQL.
+func evaluatePipeline(docs []map[string]interface{}, stages []map[string]interface{}) ([]map[string]interface{}, error) {
+	for _, stage := range stages {
+		name, spec, err := splitPipelineStage(stage)
+		if err != nil {
+			return nil, err
+		}
+
+		switch name {
+		case "$match":
+			query, ok := spec.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("$match must be an object")
+			}
+			var matched []map[string]interface{}
+			for _, doc := range docs {
+				ok, err := matchDocument(doc, query)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					matched = append(matched, doc)
+				}
+			}
+			docs = matched
+
+		case "$group":
+			groupSpec, ok := spec.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("$group must be an object")
+			}
+			docs, err = groupDocuments(docs, groupSpec)
+			if err != nil {
+				return nil, err
+			}
+
+		case "$sort":
+			keys, err := parsePipelineSort(spec)
+			if err != nil {
+				return nil, err
+			}
+			sortDocuments(docs, keys)
+
+		case "$skip":
+			n, ok := toInt64(spec)
+			if !ok || n < 0 {
+				return nil, fmt.Errorf("$skip must be a non-negative number")
+			}
+			if n >= int64(len(docs)) {
+				docs = nil
+			} else {
+				docs = docs[n:]
+			}
+
+		case "$limit":
+			n, ok := toInt64(spec)
+			if !ok || n <= 0 {
+				return nil, fmt.Errorf("$limit must be a positive number")
+			}
+			if n < int64(len(docs)) {
+				docs = docs[:n]
+			}
+
+		case "$project":
+			projection, ok := spec.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("$project must be an object")
+			}
+			docs, err = projectDocuments(docs, projection)
+			if err != nil {
+				return nil, err
+			}
+
+		case "$count":
+			field, ok := spec.(string)
+			if !ok || field == "" {
+				return nil, fmt.Errorf("$count must be a non-empty string")
+			}
+			if len(docs) == 0 {
+				docs = nil
+			} else {
+				docs = []map[string]interface{}{{field: float64(len(docs))}}
+			}
+
+		default:
+			return nil, fmt.Errorf("unsupported aggregation stage: %s", name)
+		}
+	}
+
+	if docs == nil {
+		docs = []map[string]interface{}{}
+	}
+	return docs, nil
+}
+
+// splitPipelineStage returns the operator and specification of a single-key stage
+func splitPipelineStage(stage map[string]interface{}) (string, interface{}, error) {
+	if len(stage) != 1 {
+		return "", nil, fmt.Errorf("aggregation stage must have exactly one operator, got %d", len(stage))
+	}
+	for name, spec := range stage {
+		return name, spec, nil
+	}
+	return "", nil, nil
+}
+
+// pipelineSortKey is one field of a $sort stage
+type pipelineSortKey struct {
+	field      string
+	descending bool
+}
+
+// parsePipelineSort reads a $sort specification. Decoded JSON objects lose their
+// key order, so multiple sort fields are applied in alphabetical order.
+func parsePipelineSort(spec interface{}) ([]pipelineSortKey, error) {
+	sortMap, ok := spec.(map[string]interface{})
+	if !ok {
+		if intMap, ok := spec.(map[string]int); ok {
+			sortMap = make(map[string]interface{}, len(intMap))
+			for k, v := range intMap {
+				sortMap[k] = v
+			}
+		} else {
+			return nil, fmt.Errorf("$sort must be an object")
+		}
+	}
+	if len(sortMap) == 0 {
+		return nil, fmt.Errorf("$sort must specify at least one field")
+	}
+
+	fields := make([]string, 0, len(sortMap))
+	for field := range sortMap {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	keys := make([]pipelineSortKey, 0, len(fields))
+	for _, field := range fields {
+		direction, ok := toInt64(sortMap[field])
+		if !ok || (direction != 1 && direction != -1) {
+			return nil, fmt.Errorf("$sort direction for %s must be 1 or -1", field)
+		}
+		keys = append(keys, pipelineSortKey{field: field, descending: direction == -1})
+	}
+	return keys, nil
+}
+
+func sortDocuments(docs []map[string]interface{}, keys []pipelineSortKey) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, key := range keys {
+			a, _ := lookupDocumentField(docs[i], key.field)
+			b, _ := lookupDocumentField(docs[j], key.field)
+			cmp := compareDocumentValues(a, b)
+			if cmp == 0 {
+				continue
+			}
+			if key.descending {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	})
+}
+
+// groupDocuments implements the $group stage
+func groupDocuments(docs []map[string]interface{}, spec map[string]interface{}) ([]map[string]interface{}, error) {
+	idExpr, hasID := spec["_id"]
+	if !hasID {
+		return nil, fmt.Errorf("$group requires an _id expression")
+	}
+
+	accumulators := make(map[string]pipelineAccumulator)
+	for field, raw := range spec {
+		if field == "_id" {
+			continue
+		}
+		acc, err := parsePipelineAccumulator(field, raw)
+		if err != nil {
+			return nil, err
+		}
+		accumulators[field] = acc
+	}
+
+	type group struct {
+		id     interface{}
+		states map[string]*accumulatorState
+	}
+	var groups []*group
+	index := make(map[string]*group)
+
+	for _, doc := range docs {
+		id := evaluateExpression(doc, idExpr)
+		key := groupKey(id)
+		g, exists := index[key]
+		if !exists {
+			g = &group{id: id, states: make(map[string]*accumulatorState)}
+			for field := range accumulators {
+				g.states[field] = &accumulatorState{}
+			}
+			index[key] = g
+			groups = append(groups, g)
+		}
+		for field, acc := range accumulators {
+			g.states[field].add(acc, evaluateExpression(doc, acc.expr))
+		}
+	}
+
+	results := make([]map[string]interface{}, 0, len(groups))
+	for _, g := range groups {
+		row := map[string]interface{}{"_id": g.id}
+		for field, acc := range accumulators {
+			row[field] = g.states[field].result(acc)
+		}
+		results = append(results, row)
+	}
+	return results, nil
+}
+
+// pipelineAccumulator is a parsed $group accumulator such as {"$sum": "$amount"}
+type pipelineAccumulator struct {
+	op   string
+	expr interface{}
+}
+
+func parsePipelineAccumulator(field string, raw interface{}) (pipelineAccumulator, error) {
+	accMap, ok := raw.(map[string]interface{})
+	if !ok || len(accMap) != 1 {
+		return pipelineAccumulator{}, fmt.Errorf("$group field %s must be a single accumulator object", field)
+	}
+	for op, expr := range accMap {
+		switch op {
+		case "$sum", "$avg", "$min", "$max", "$first", "$last", "$push", "$addToSet":
+			return pipelineAccumulator{op: op, expr: expr}, nil
+		case "$count":
+			return pipelineAccumulator{op: op, expr: 1}, nil
+		default:
+			return pipelineAccumulator{}, fmt.Errorf("unsupported accumulator %s for field %s", op, field)
+		}
+	}
+	return pipelineAccumulator{}, nil
+}
+
+// accumulatorState collects the values of one accumulator for one group
+type accumulatorState struct {
+	sum     float64
+	count   int64
+	value   interface{}
+	seen    bool
+	values  []interface{}
+	numeric int64
+}
+
+func (s *accumulatorState) add(acc pipelineAccumulator, value interface{}) {
+	switch acc.op {
+	case "$sum", "$avg":
+		if n, ok := toFloat(value); ok {
+			s.sum += n
+			s.numeric++
+		}
+	case "$count":
+		s.count++
+	case "$min":
+		if value != nil && (!s.seen || compareDocumentValues(value, s.value) < 0) {
+			s.value, s.seen = value, true
+		}
+	case "$max":
+		if value != nil && (!s.seen || compareDocumentValues(value, s.value) > 0) {
+			s.value, s.seen = value, true
+		}
+	case "$first":
+		if !s.seen {
+			s.value, s.seen = value, true
+		}
+	case "$last":
+		s.value, s.seen = value, true
+	case "$push":
+		s.values = append(s.values, value)
+	case "$addToSet":
+		for _, existing := range s.values {
+			if compareDocumentValues(existing, value) == 0 {
+				return
+			}
+		}
+		s.values = append(s.values, value)
+	}
+}
+
+func (s *accumulatorState) result(acc pipelineAccumulator) interface{} {
+	switch acc.op {
+	case "$sum":
+		return s.sum
+	case "$avg":
+		if s.numeric == 0 {
+			return nil
+		}
+		return s.sum / float64(s.numeric)
+	case "$count":
+		return float64(s.count)
+	case "$push", "$addToSet":
+		if s.values == nil {
+			return []interface{}{}
+		}
+		return s.values
+	default:
+		return normalizeNumber(s.value)
+	}
+}
+
+// groupKey builds a comparable key for a $group _id value
+func groupKey(value interface{}) string {
+	return fmt.Sprintf("%#v", normalizeGroupValue(value))
+}
+
+func normalizeGroupValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]interface{}, 0, len(keys)*2)
+		for _, k := range keys {
+			pairs = append(pairs, k, normalizeGroupValue(v[k]))
+		}
+		return pairs
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = normalizeGroupValue(item)
+		}
+		return items
+	default:
+		return normalizeNumber(v)
+	}
+}
+
+// normalizeNumber turns every numeric type into float64, the type JSON decoding produces
+func normalizeNumber(value interface{}) interface{} {
+	if n, ok := toFloat(value); ok {
+		return n
+	}
+	return value
+}
+
+// evaluateExpression resolves "$field" references, objects of expressions and literals
+func evaluateExpression(doc map[string]interface{}, expr interface{}) interface{} {
+	switch e := expr.(type) {
+	case string:
+		if strings.HasPrefix(e, "$") {
+			value, _ := lookupDocumentField(doc, strings.TrimPrefix(e, "$"))
+			return value
+		}
+		return e
+	case map[string]interface{}:
+		// Like MongoDB, fields referring to missing fields are left out
+		result := make(map[string]interface{}, len(e))
+		for k, v := range e {
+			if ref, ok := v.(string); ok && strings.HasPrefix(ref, "$") {
+				if _, found := lookupDocumentField(doc, strings.TrimPrefix(ref, "$")); !found {
+					continue
+				}
+			}
+			result[k] = evaluateExpression(doc, v)
+		}
+		return result
+	default:
+		return expr
+	}
+}
+
+// projectDocuments implements the $project stage with inclusion, exclusion and computed fields.
+// Documents from SQL stores keep their identifier in "id", which is treated like MongoDB's _id.
+func projectDocuments(docs []map[string]interface{}, projection map[string]interface{}) ([]map[string]interface{}, error) {
+	excludeID := false
+	include := false
+	exclude := false
+	for field, value := range projection {
+		flag, isFlag := projectionFlag(value)
+		if field == "_id" && isFlag && !flag {
+			excludeID = true
+			continue
+		}
+		if isFlag && !flag {
+			exclude = true
+		} else {
+			include = true
+		}
+	}
+	if include && exclude {
+		return nil, fmt.Errorf("$project cannot mix inclusion and exclusion")
+	}
+
+	results := make([]map[string]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		var row map[string]interface{}
+		if include {
+			row = make(map[string]interface{})
+			if id, ok := doc["_id"]; ok && !excludeID {
+				row["_id"] = id
+			}
+			if id, ok := doc["id"]; ok && !excludeID {
+				if _, mentioned := projection["id"]; !mentioned {
+					row["id"] = id
+				}
+			}
+			for field, value := range projection {
+				if field == "_id" && excludeID {
+					continue
+				}
+				if flag, isFlag := projectionFlag(value); isFlag {
+					if !flag {
+						continue
+					}
+					if v, ok := lookupDocumentField(doc, field); ok {
+						setDocumentField(row, field, v)
+					}
+					continue
+				}
+				setDocumentField(row, field, evaluateExpression(doc, value))
+			}
+		} else {
+			row = make(map[string]interface{}, len(doc))
+			for k, v := range doc {
+				row[k] = v
+			}
+			for field := range projection {
+				deleteDocumentField(row, field)
+			}
+			if _, hasMongoID := doc["_id"]; excludeID && !hasMongoID {
+				// Documents from SQL stores carry their identifier as "id"
+				delete(row, "id")
+			}
+		}
+		results = append(results, row)
+	}
+	return results, nil
+}
+
+// projectionFlag reports whether a projection value is a 0/1/true/false flag and its meaning
+func projectionFlag(value interface{}) (bool, bool) {
+	if b, ok := value.(bool); ok {
+		return b, true
+	}
+	if n, ok := toFloat(value); ok {
+		return n != 0, true
+	}
+	return false, false
+}
+
+// lookupDocumentField resolves a dotted path inside a document
+func lookupDocumentField(doc map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func setDocumentField(doc map[string]interface{}, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	current := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[part] = next
+		}
+		current = next
+	}
+	current[parts[len(parts)-1]] = value
+}
+
+func deleteDocumentField(doc map[string]interface{}, path string) {
+	parts := strings.Split(path, ".")
+	current := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			return
+		}
+		current = next
+	}
+	delete(current, parts[len(parts)-1])
+}
+
+// MatchDocument reports whether doc matches a MongoDB-style query, with the
+// operators of $match. Realtime subscriptions use it to filter changed
+// documents.
+func MatchDocument(doc map[string]interface{}, query map[string]interface{}) (bool, error) {
+	return matchDocument(doc, query)
+}
+
+// matchDocument evaluates a MongoDB query document against a single document
+func matchDocument(doc map[string]interface{}, query map[string]interface{}) (bool, error) {
+	for key, value := range query {
+		switch key {
+		case "$and", "$or", "$nor":
+			conditions, err := toConditionList(value)
+			if err != nil {
+				return false, err
+			}
+			matches := 0
+			for _, cond := range conditions {
+				ok, err := matchDocument(doc, cond)
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					matches++
+				}
+			}
+			switch key {
+			case "$and":
+				if matches != len(conditions) {
+					return false, nil
+				}
+			case "$or":
+				if matches == 0 {
+					return false, nil
+				}
+			case "$nor":
+				if matches > 0 {
+					return false, nil
+				}
+			}
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("unsupported query operator: %s", key)
+			}
+			fieldValue, exists := lookupDocumentField(doc, key)
+			ok, err := matchField(fieldValue, exists, value)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+func matchField(fieldValue interface{}, exists bool, condition interface{}) (bool, error) {
+	ops, ok := condition.(map[string]interface{})
+	if !ok || !isOperatorMap(ops) {
+		return valueMatches(fieldValue, condition), nil
+	}
+
+	for op, operand := range ops {
+		var ok bool
+		switch op {
+		case "$eq":
+			ok = valueMatches(fieldValue, operand)
+		case "$ne":
+			ok = !valueMatches(fieldValue, operand)
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = anyElement(fieldValue, func(v interface{}) bool {
+				if !comparableTypes(v, operand) {
+					return false
+				}
+				cmp := compareDocumentValues(v, operand)
+				switch op {
+				case "$gt":
+					return cmp > 0
+				case "$gte":
+					return cmp >= 0
+				case "$lt":
+					return cmp < 0
+				default:
+					return cmp <= 0
+				}
+			})
+		case "$in", "$nin":
+			values, isList := toInterfaceSlice(operand)
+			if !isList {
+				return false, fmt.Errorf("%s must be an array", op)
+			}
+			found := false
+			for _, candidate := range values {
+				if valueMatches(fieldValue, candidate) {
+					found = true
+					break
+				}
+			}
+			ok = found == (op == "$in")
+		case "$exists":
+			want, _ := projectionFlag(operand)
+			ok = exists == want
+		case "$regex":
+			pattern, isString := operand.(string)
+			if !isString {
+				return false, fmt.Errorf("$regex must be a string")
+			}
+			if options, hasOptions := ops["$options"].(string); hasOptions && options != "" {
+				pattern = "(?" + options + ")" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return false, fmt.Errorf("invalid $regex: %w", err)
+			}
+			ok = anyElement(fieldValue, func(v interface{}) bool {
+				s, isString := v.(string)
+				return isString && re.MatchString(s)
+			})
+		case "$options":
+			continue
+		case "$size":
+			n, isNumber := toInt64(operand)
+			if !isNumber {
+				return false, fmt.Errorf("$size must be a number")
+			}
+			list, isList := fieldValue.([]interface{})
+			ok = isList && int64(len(list)) == n
+		case "$all":
+			values, isList := toInterfaceSlice(operand)
+			if !isList {
+				return false, fmt.Errorf("$all must be an array")
+			}
+			ok = true
+			for _, candidate := range values {
+				if !valueMatches(fieldValue, candidate) {
+					ok = false
+					break
+				}
+			}
+		case "$not":
+			inner, err := matchField(fieldValue, exists, operand)
+			if err != nil {
+				return false, err
+			}
+			ok = !inner
+		default:
+			return false, fmt.Errorf("unsupported query operator: %s", op)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// valueMatches implements MongoDB equality, where arrays match any of their elements
+func valueMatches(fieldValue, expected interface{}) bool {
+	if compareDocumentValues(fieldValue, expected) == 0 && sameTypeRank(fieldValue, expected) {
+		return true
+	}
+	if list, ok := fieldValue.([]interface{}); ok {
+		for _, item := range list {
+			if compareDocumentValues(item, expected) == 0 && sameTypeRank(item, expected) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func anyElement(value interface{}, fn func(interface{}) bool) bool {
+	if list, ok := value.([]interface{}); ok {
+		for _, item := range list {
+			if fn(item) {
+				return true
+			}
+		}
+		return false
+	}
+	return fn(value)
+}
+
+// comparableTypes reports whether range operators may compare the two values;
+// like MongoDB, $gt and friends never match across types
+func comparableTypes(a, b interface{}) bool {
+	return a != nil && sameTypeRank(a, b)
+}
+
+func sameTypeRank(a, b interface{}) bool {
+	return valueTypeRank(a) == valueTypeRank(b)
+}
+
+// valueTypeRank follows MongoDB's comparison order of BSON types
+func valueTypeRank(value interface{}) int {
+	switch value.(type) {
+	case nil:
+		return 1
+	case string:
+		return 3
+	case map[string]interface{}:
+		return 4
+	case []interface{}:
+		return 5
+	case bool:
+		return 6
+	case time.Time:
+		return 7
+	}
+	if _, ok := toFloat(value); ok {
+		return 2
+	}
+	return 8
+}
+
+// compareDocumentValues orders two values the way MongoDB sorts them
+func compareDocumentValues(a, b interface{}) int {
+	rankA, rankB := valueTypeRank(a), valueTypeRank(b)
+	if rankA != rankB {
+		if rankA < rankB {
+			return -1
+		}
+		return 1
+	}
+
+	switch av := a.(type) {
+	case nil:
+		return 0
+	case string:
+		return strings.Compare(av, b.(string))
+	case bool:
+		bv := b.(bool)
+		if av == bv {
+			return 0
+		}
+		if !av {
+			return -1
+		}
+		return 1
+	case time.Time:
+		bv := b.(time.Time)
+		if av.Before(bv) {
+			return -1
+		}
+		if av.After(bv) {
+			return 1
+		}
+		return 0
+	case []interface{}:
+		bv := b.([]interface{})
+		for i := 0; i < len(av) && i < len(bv); i++ {
+			if cmp := compareDocumentValues(av[i], bv[i]); cmp != 0 {
+				return cmp
+			}
+		}
+		return compareInts(len(av), len(bv))
+	case map[string]interface{}:
+		if reflect.DeepEqual(normalizeGroupValue(av), normalizeGroupValue(b)) {
+			return 0
+		}
+		return strings.Compare(groupKey(av), groupKey(b))
+	}
+
+	if af, ok := toFloat(a); ok {
+		bf, _ := toFloat(b)
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,357 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteStore_Aggregate(t *testing.T) {
+	testStoreAggregate(t, createTestSQLiteDB)
+}
+
+func TestPostgresStore_Aggregate(t *testing.T) {
+	testStoreAggregate(t, createTestPostgresDB)
+}
+
+func testStoreAggregate(t *testing.T, newDB func(t *testing.T) DatabaseInterface) {
+	db := newDB(t)
+	defer cleanupTestDB(db)
+
+	store := db.CreateStore("sales")
+	ctx := context.Background()
+
+	// The SQLite test database is file backed, so start from an empty collection
+	_, err := store.Remove(ctx, NewQueryBuilder())
+	require.NoError(t, err)
+
+	sales := []map[string]interface{}{
+		{"region": "north", "product": "apple", "amount": 10, "tags": []interface{}{"fruit", "red"}, "paid": true},
+		{"region": "north", "product": "pear", "amount": 30, "tags": []interface{}{"fruit"}, "paid": false},
+		{"region": "south", "product": "apple", "amount": 5, "tags": []interface{}{"fruit", "red"}, "paid": true},
+		{"region": "south", "product": "bread", "amount": 20, "tags": []interface{}{"bakery"}, "paid": true},
+		{"region": "east", "product": "milk", "amount": "n/a", "paid": false},
+	}
+	for _, sale := range sales {
+		_, err := store.Insert(ctx, sale)
+		require.NoError(t, err)
+	}
+
+	allDocs, err := store.Find(ctx, NewQueryBuilder(), QueryOptions{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		pipeline []map[string]interface{}
+		expected []map[string]interface{}
+	}{
+		{
+			name: "group with accumulators",
+			pipeline: []map[string]interface{}{
+				{"$match": map[string]interface{}{"paid": true}},
+				{"$group": map[string]interface{}{
+					"_id":   "$region",
+					"total": map[string]interface{}{"$sum": "$amount"},
+					"avg":   map[string]interface{}{"$avg": "$amount"},
+					"low":   map[string]interface{}{"$min": "$amount"},
+					"high":  map[string]interface{}{"$max": "$amount"},
+					"sales": map[string]interface{}{"$count": map[string]interface{}{}},
+				}},
+				{"$sort": map[string]interface{}{"total": -1}},
+			},
+			expected: []map[string]interface{}{
+				{"_id": "south", "total": 25.0, "avg": 12.5, "low": 5.0, "high": 20.0, "sales": 2.0},
+				{"_id": "north", "total": 10.0, "avg": 10.0, "low": 10.0, "high": 10.0, "sales": 1.0},
+			},
+		},
+		{
+			name: "non-numeric values are skipped by $sum and $avg",
+			pipeline: []map[string]interface{}{
+				{"$match": map[string]interface{}{"region": "east"}},
+				{"$group": map[string]interface{}{
+					"_id":   "$region",
+					"total": map[string]interface{}{"$sum": "$amount"},
+					"avg":   map[string]interface{}{"$avg": "$amount"},
+				}},
+			},
+			expected: []map[string]interface{}{
+				{"_id": "east", "total": 0.0, "avg": nil},
+			},
+		},
+		{
+			name: "group everything",
+			pipeline: []map[string]interface{}{
+				{"$group": map[string]interface{}{"_id": nil, "count": map[string]interface{}{"$sum": 1}}},
+			},
+			expected: []map[string]interface{}{
+				{"_id": nil, "count": 5.0},
+			},
+		},
+		{
+			name: "group everything without matches",
+			pipeline: []map[string]interface{}{
+				{"$match": map[string]interface{}{"region": "west"}},
+				{"$group": map[string]interface{}{"_id": nil, "count": map[string]interface{}{"$sum": 1}}},
+			},
+			expected: []map[string]interface{}{},
+		},
+		{
+			name: "compound group key",
+			pipeline: []map[string]interface{}{
+				{"$match": map[string]interface{}{"tags": "fruit"}},
+				{"$group": map[string]interface{}{
+					"_id":   map[string]interface{}{"product": "$product", "paid": "$paid"},
+					"count": map[string]interface{}{"$sum": 1},
+				}},
+				{"$sort": map[string]interface{}{"_id.product": 1}},
+			},
+			expected: []map[string]interface{}{
+				{"_id": map[string]interface{}{"product": "apple", "paid": true}, "count": 2.0},
+				{"_id": map[string]interface{}{"product": "pear", "paid": false}, "count": 1.0},
+			},
+		},
+		{
+			name: "range match with sort, skip, limit and project",
+			pipeline: []map[string]interface{}{
+				{"$match": map[string]interface{}{"amount": map[string]interface{}{"$gte": 5}}},
+				{"$sort": map[string]interface{}{"amount": 1}},
+				{"$skip": 1},
+				{"$limit": 2},
+				{"$project": map[string]interface{}{"_id": 0, "product": 1, "value": "$amount"}},
+			},
+			expected: []map[string]interface{}{
+				{"product": "apple", "value": 10.0},
+				{"product": "bread", "value": 20.0},
+			},
+		},
+		{
+			name: "stages after an in-memory stage",
+			pipeline: []map[string]interface{}{
+				{"$match": map[string]interface{}{"product": map[string]interface{}{"$regex": "^AP", "$options": "i"}}},
+				{"$group": map[string]interface{}{"_id": "$product", "total": map[string]interface{}{"$sum": "$amount"}}},
+				{"$match": map[string]interface{}{"total": map[string]interface{}{"$gt": 10}}},
+			},
+			expected: []map[string]interface{}{
+				{"_id": "apple", "total": 15.0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := store.Aggregate(ctx, tt.pipeline)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, results)
+
+			// The in-memory evaluator must agree with the SQL translation
+			inMemory, err := evaluatePipeline(append([]map[string]interface{}(nil), allDocs...), tt.pipeline)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, inMemory)
+		})
+	}
+}
+
+func TestSQLiteStore_AggregateNullKeys(t *testing.T) {
+	testStoreAggregateNullKeys(t, createTestSQLiteDB)
+}
+
+func TestPostgresStore_AggregateNullKeys(t *testing.T) {
+	testStoreAggregateNullKeys(t, createTestPostgresDB)
+}
+
+func TestMySQLStore_AggregateNullKeys(t *testing.T) {
+	testStoreAggregateNullKeys(t, createTestMySQLDB)
+}
+
+// testStoreAggregateNullKeys checks that missing and null group keys land in
+// one group, as in MongoDB, while compound keys leave missing fields out
+func testStoreAggregateNullKeys(t *testing.T, newDB func(t *testing.T) DatabaseInterface) {
+	db := newDB(t)
+	defer cleanupTestDB(db)
+
+	store := db.CreateStore("contacts")
+	ctx := context.Background()
+	_, err := store.Remove(ctx, NewQueryBuilder())
+	require.NoError(t, err)
+
+	for _, contact := range []map[string]interface{}{
+		{"name": "ada", "city": "Vienna"},
+		{"name": "bob", "city": "Vienna"},
+		{"name": "cy", "city": nil},
+		{"name": "dee"},
+		{"name": "eve"},
+	} {
+		_, err := store.Insert(ctx, contact)
+		require.NoError(t, err)
+	}
+	allDocs, err := store.Find(ctx, NewQueryBuilder(), QueryOptions{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		pipeline []map[string]interface{}
+		expected []map[string]interface{}
+	}{
+		{
+			name: "missing and null keys form one group",
+			pipeline: []map[string]interface{}{
+				{"$group": map[string]interface{}{"_id": "$city", "count": map[string]interface{}{"$sum": 1}}},
+				{"$sort": map[string]interface{}{"count": 1}},
+			},
+			expected: []map[string]interface{}{
+				{"_id": "Vienna", "count": 2.0},
+				{"_id": nil, "count": 3.0},
+			},
+		},
+		{
+			name: "compound keys leave missing fields out",
+			pipeline: []map[string]interface{}{
+				{"$group": map[string]interface{}{
+					"_id":   map[string]interface{}{"city": "$city"},
+					"count": map[string]interface{}{"$sum": 1},
+				}},
+				{"$sort": map[string]interface{}{"count": 1}},
+			},
+			expected: []map[string]interface{}{
+				{"_id": map[string]interface{}{"city": nil}, "count": 1.0},
+				{"_id": map[string]interface{}{"city": "Vienna"}, "count": 2.0},
+				{"_id": map[string]interface{}{}, "count": 2.0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := store.Aggregate(ctx, tt.pipeline)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tt.expected, results)
+
+			inMemory, err := evaluatePipeline(append([]map[string]interface{}(nil), allDocs...), tt.pipeline)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tt.expected, inMemory)
+		})
+	}
+}
+
+func TestTranslatePipeline(t *testing.T) {
+	source := PipelineSource{Table: "orders", Select: "data"}
+
+	t.Run("pushes match, group, sort and limit into SQL", func(t *testing.T) {
+		plan, err := NewQueryTranslator("sqlite").TranslatePipeline(source, []map[string]interface{}{
+			{"$match": map[string]interface{}{"status": "paid"}},
+			{"$group": map[string]interface{}{"_id": "$customer", "total": map[string]interface{}{"$sum": "$amount"}}},
+			{"$sort": map[string]interface{}{"total": -1}},
+			{"$limit": 10},
+			{"$project": map[string]interface{}{"total": 1}},
+		})
+		require.NoError(t, err)
+		assert.True(t, plan.Grouped)
+		assert.Contains(t, plan.SQL, "GROUP BY COALESCE(data -> '$.customer', 'null')")
+		assert.Contains(t, plan.SQL, "ORDER BY a1 DESC LIMIT 10 OFFSET 0")
+		assert.Equal(t, []interface{}{"paid"}, plan.Args)
+		require.Len(t, plan.Remaining, 1)
+		assert.Contains(t, plan.Remaining[0], "$project")
+	})
+
+	t.Run("keeps untranslatable stages in memory", func(t *testing.T) {
+		plan, err := NewQueryTranslator("mysql").TranslatePipeline(source, []map[string]interface{}{
+			{"$match": map[string]interface{}{"name": map[string]interface{}{"$regex": "^a"}}},
+			{"$limit": 5},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "SELECT data FROM `orders`", plan.SQL)
+		assert.Len(t, plan.Remaining, 2)
+	})
+
+	t.Run("uses positional JSON arguments for postgres", func(t *testing.T) {
+		plan, err := NewQueryTranslator("postgres").TranslatePipeline(source, []map[string]interface{}{
+			{"$match": map[string]interface{}{"age": map[string]interface{}{"$gte": 18}, "city": "Vienna"}},
+			{"$skip": 20},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, `SELECT data FROM "orders" WHERE (jsonb_typeof(data->'age') = 'number' AND data->'age' >= $1::jsonb) AND data->'city' @> $2::jsonb OFFSET 20`, plan.SQL)
+		assert.Equal(t, []interface{}{"18", `"Vienna"`}, plan.Args)
+		assert.Empty(t, plan.Remaining)
+	})
+
+	t.Run("min and max on JSON fields stay in memory for mysql", func(t *testing.T) {
+		plan, err := NewQueryTranslator("mysql").TranslatePipeline(source, []map[string]interface{}{
+			{"$group": map[string]interface{}{"_id": nil, "low": map[string]interface{}{"$min": "$price"}}},
+		})
+		require.NoError(t, err)
+		assert.False(t, plan.Grouped)
+		assert.True(t, strings.HasPrefix(plan.SQL, "SELECT data FROM"))
+		assert.Len(t, plan.Remaining, 1)
+	})
+}
+
+func TestEvaluatePipelineErrors(t *testing.T) {
+	docs := []map[string]interface{}{{"a": 1.0}}
+
+	_, err := evaluatePipeline(docs, []map[string]interface{}{{"$lookup": map[string]interface{}{}}})
+	assert.Error(t, err)
+
+	_, err = evaluatePipeline(docs, []map[string]interface{}{{"$group": map[string]interface{}{"total": map[string]interface{}{"$sum": "$a"}}}})
+	assert.Error(t, err)
+
+	_, err = evaluatePipeline(docs, []map[string]interface{}{{"$project": map[string]interface{}{"a": 1, "b": 0}}})
+	assert.Error(t, err)
+}
+
+func TestColumnStore_Aggregate(t *testing.T) {
+	configDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(configDir, "orders"), 0755))
+	config := `{"properties": {"customer": {"type": "string"}, "amount": {"type": "number"}}, "options": {"useColumns": true}}`
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "orders", "config.json"), []byte(config), 0644))
+
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer sqlDB.Close()
+	sqlDB.SetMaxOpenConns(1)
+
+	store, err := NewColumnStore("orders", sqlDB, nil, NewSchemaManager(sqlDB, DatabaseTypeSQLite, configDir))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, order := range []map[string]interface{}{
+		{"customer": "ada", "amount": 12.5, "note": "first"},
+		{"customer": "ada", "amount": 7.5},
+		{"customer": "bob", "amount": 3.0, "note": "rush"},
+	} {
+		_, err := store.Insert(ctx, order)
+		require.NoError(t, err)
+	}
+
+	results, err := store.Aggregate(ctx, []map[string]interface{}{
+		{"$match": map[string]interface{}{"amount": map[string]interface{}{"$gt": 1}}},
+		{"$group": map[string]interface{}{
+			"_id":     "$customer",
+			"total":   map[string]interface{}{"$sum": "$amount"},
+			"largest": map[string]interface{}{"$max": "$amount"},
+		}},
+		{"$sort": map[string]interface{}{"_id": 1}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"_id": "ada", "total": 20.0, "largest": 12.5},
+		{"_id": "bob", "total": 3.0, "largest": 3.0},
+	}, results)
+
+	// Fields that only live in the JSON data column work next to real columns
+	results, err = store.Aggregate(ctx, []map[string]interface{}{
+		{"$match": map[string]interface{}{"note": map[string]interface{}{"$exists": true}}},
+		{"$project": map[string]interface{}{"_id": 0, "customer": 1, "note": 1}},
+		{"$sort": map[string]interface{}{"customer": -1}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"customer": "bob", "note": "rush"},
+		{"customer": "ada", "note": "first"},
+	}, results)
+}
@@ -0,0 +1,705 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PipelineSource describes where the documents of a collection are stored
+type PipelineSource struct {
+	Table     string                  // unquoted table name
+	Select    string                  // columns selected for document rows, e.g. "data"
+	HasColumn func(field string) bool // fields stored in their own column instead of the JSON data column
+}
+
+// PipelinePlan is the SQL translation of the leading stages of an aggregation pipeline.
+// Remaining holds the stages that still have to run in memory on the returned rows.
+type PipelinePlan struct {
+	SQL       string
+	Args      []interface{}
+	Grouped   bool
+	Columns   []PipelineColumn
+	Remaining []map[string]interface{}
+}
+
+// PipelineColumn describes one selected column of a grouped query
+type PipelineColumn struct {
+	Field string // output field such as "_id", "_id.region" or an accumulator name
+	Kind  string // "json", "number", "value" or "size"
+}
+
+// pipelineGroup is a $group stage that can run as SQL GROUP BY
+type pipelineGroup struct {
+	selects  []string
+	groupBy  []string
+	columns  []PipelineColumn
+	orderBys map[string]string
+}
+
+var plainJSONKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// TranslatePipeline converts as many leading pipeline stages as possible into one SQL query.
+// Stages are pushed down in SQL clause order ($match → $group → $sort → $skip/$limit);
+// the first stage that does not fit, and everything after it, is left for evaluatePipeline.
+func (qt *QueryTranslator) TranslatePipeline(source PipelineSource, pipeline []map[string]interface{}) (*PipelinePlan, error) {
+	plan := &PipelinePlan{}
+	var where []string
+	var group *pipelineGroup
+	var orderBy []string
+	offset, limit := int64(0), int64(-1)
+
+	i := 0
+	for ; i < len(pipeline); i++ {
+		name, spec, err := splitPipelineStage(pipeline[i])
+		if err != nil {
+			return nil, err
+		}
+		if name != "$match" {
+			break
+		}
+		query, ok := spec.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("$match must be an object")
+		}
+		condition, ok := qt.pipelineMatch(source, query, &plan.Args)
+		if !ok {
+			break
+		}
+		if condition != "" {
+			where = append(where, condition)
+		}
+	}
+
+	// stageAt reports whether the next unprocessed stage is the given operator
+	stageAt := func(stage string) bool {
+		if i >= len(pipeline) {
+			return false
+		}
+		name, _, _ := splitPipelineStage(pipeline[i])
+		return name == stage
+	}
+
+	if stageAt("$group") {
+		spec, _ := pipeline[i]["$group"].(map[string]interface{})
+		group = qt.pipelineGroup(source, spec)
+		if group == nil {
+			// Nothing after an in-memory $group can run in SQL
+			return qt.finishPipelinePlan(plan, source, where, nil, nil, 0, -1, pipeline[i:]), nil
+		}
+		i++
+	}
+
+	if stageAt("$sort") {
+		keys, err := parsePipelineSort(pipeline[i]["$sort"])
+		if err != nil {
+			return nil, err
+		}
+		if orderBy = qt.pipelineOrderBy(source, group, keys); orderBy == nil {
+			return qt.finishPipelinePlan(plan, source, where, group, nil, 0, -1, pipeline[i:]), nil
+		}
+		i++
+	}
+
+	for ; i < len(pipeline); i++ {
+		name, spec, _ := splitPipelineStage(pipeline[i])
+		n, ok := toInt64(spec)
+		if name == "$skip" && ok && n >= 0 {
+			offset += n
+			if limit >= 0 {
+				limit = max(limit-n, 0)
+			}
+		} else if name == "$limit" && ok && n > 0 {
+			if limit < 0 || n < limit {
+				limit = n
+			}
+		} else {
+			break
+		}
+	}
+
+	return qt.finishPipelinePlan(plan, source, where, group, orderBy, offset, limit, pipeline[i:]), nil
+}
+
+// finishPipelinePlan assembles the SQL statement from the translated clauses
+func (qt *QueryTranslator) finishPipelinePlan(plan *PipelinePlan, source PipelineSource, where []string, group *pipelineGroup, orderBy []string, offset, limit int64, remaining []map[string]interface{}) *PipelinePlan {
+	query := "SELECT "
+	if group != nil {
+		plan.Grouped = true
+		plan.Columns = group.columns
+		query += strings.Join(group.selects, ", ")
+	} else {
+		query += source.Select
+	}
+	query += " FROM " + qt.quoteIdentifier(source.Table)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	if group != nil && len(group.groupBy) > 0 {
+		query += " GROUP BY " + strings.Join(group.groupBy, ", ")
+	}
+	if len(orderBy) > 0 {
+		query += " ORDER BY " + strings.Join(orderBy, ", ")
+	}
+	query += qt.limitClause(offset, limit)
+
+	plan.SQL = query
+	plan.Remaining = remaining
+	return plan
+}
+
+// pipelineMatch translates a $match query. It returns false when the query uses
+// anything whose SQL meaning would differ from MongoDB's, so it runs in memory instead.
+func (qt *QueryTranslator) pipelineMatch(source PipelineSource, query map[string]interface{}, args *[]interface{}) (string, bool) {
+	var conditions []string
+	for _, field := range sortedKeys(query) {
+		value := query[field]
+		switch field {
+		case "$and", "$or":
+			list, err := toConditionList(value)
+			if err != nil || len(list) == 0 {
+				return "", false
+			}
+			var parts []string
+			for _, sub := range list {
+				part, ok := qt.pipelineMatch(source, sub, args)
+				if !ok {
+					return "", false
+				}
+				if part == "" {
+					part = "1=1"
+				}
+				parts = append(parts, "("+part+")")
+			}
+			joiner := " AND "
+			if field == "$or" {
+				joiner = " OR "
+			}
+			conditions = append(conditions, "("+strings.Join(parts, joiner)+")")
+			continue
+		}
+		if strings.HasPrefix(field, "$") {
+			return "", false
+		}
+
+		ops, isMap := value.(map[string]interface{})
+		if !isMap {
+			ops = map[string]interface{}{"$eq": value}
+		} else if !isOperatorMap(ops) {
+			return "", false
+		}
+
+		for _, op := range sortedKeys(ops) {
+			condition, ok := qt.pipelineCondition(source, field, op, ops[op], args)
+			if !ok {
+				return "", false
+			}
+			conditions = append(conditions, condition)
+		}
+	}
+	return strings.Join(conditions, " AND "), true
+}
+
+// pipelineCondition translates a single field operator of a $match stage
+func (qt *QueryTranslator) pipelineCondition(source PipelineSource, field, op string, value interface{}, args *[]interface{}) (string, bool) {
+	switch op {
+	case "$eq":
+		if !isPipelineScalar(value) {
+			return "", false
+		}
+		return qt.pipelineEquals(source, field, value, args), true
+
+	case "$in":
+		values, ok := toInterfaceSlice(value)
+		if !ok || len(values) == 0 {
+			return "", false
+		}
+		var parts []string
+		for _, v := range values {
+			if !isPipelineScalar(v) {
+				return "", false
+			}
+			parts = append(parts, qt.pipelineEquals(source, field, v, args))
+		}
+		return "(" + strings.Join(parts, " OR ") + ")", true
+
+	case "$gt", "$gte", "$lt", "$lte":
+		if !isPipelineScalar(value) {
+			return "", false
+		}
+		if _, isBool := value.(bool); isBool {
+			return "", false
+		}
+		sqlOp := map[string]string{"$gt": ">", "$gte": ">=", "$lt": "<", "$lte": "<="}[op]
+		if source.HasColumn != nil && source.HasColumn(field) {
+			return fmt.Sprintf("%s %s %s", qt.quoteIdentifier(field), sqlOp, qt.bindPipelineArg(args, value)), true
+		}
+		// Range operators never match across types, so guard the JSON type first
+		return fmt.Sprintf("(%s AND %s %s %s)",
+			qt.pipelineTypeGuard(field, value), qt.pipelineJSONValue(field), sqlOp, qt.bindPipelineJSON(args, value)), true
+
+	case "$exists":
+		exists, ok := value.(bool)
+		if !ok {
+			return "", false
+		}
+		var present string
+		switch {
+		case source.HasColumn != nil && source.HasColumn(field):
+			present = qt.quoteIdentifier(field) + " IS NOT NULL"
+		case qt.dialect == "mysql":
+			present = fmt.Sprintf("JSON_CONTAINS_PATH(data, 'one', %s)", qt.quoteLiteral(pipelineJSONPath(field)))
+		case qt.dialect == "postgres":
+			present = postgresJSONPath(field, false) + " IS NOT NULL"
+		default:
+			present = fmt.Sprintf("json_type(data, %s) IS NOT NULL", qt.quoteLiteral(pipelineJSONPath(field)))
+		}
+		if exists {
+			return present, true
+		}
+		return "NOT (" + present + ")", true
+	}
+
+	return "", false
+}
+
+// pipelineEquals builds MongoDB equality, which also matches arrays containing the value
+func (qt *QueryTranslator) pipelineEquals(source PipelineSource, field string, value interface{}, args *[]interface{}) string {
+	if source.HasColumn != nil && source.HasColumn(field) {
+		return fmt.Sprintf("%s = %s", qt.quoteIdentifier(field), qt.bindPipelineArg(args, value))
+	}
+
+	path := qt.quoteLiteral(pipelineJSONPath(field))
+	switch qt.dialect {
+	case "mysql":
+		return fmt.Sprintf("JSON_CONTAINS(JSON_EXTRACT(data, %s), %s)", path, qt.bindPipelineJSON(args, value))
+	case "postgres":
+		return fmt.Sprintf("%s @> %s", postgresJSONPath(field, false), qt.bindPipelineJSON(args, value))
+	default:
+		// json_each yields the value itself for scalars and every element for arrays
+		return fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(data, %s) WHERE json_each.type NOT IN ('array', 'object') AND json_each.value = %s)",
+			path, qt.bindPipelineArg(args, value))
+	}
+}
+
+// pipelineTypeGuard restricts a JSON field to the type of the value it is compared with
+func (qt *QueryTranslator) pipelineTypeGuard(field string, value interface{}) string {
+	_, isString := value.(string)
+	path := qt.quoteLiteral(pipelineJSONPath(field))
+	switch qt.dialect {
+	case "mysql":
+		if isString {
+			return fmt.Sprintf("JSON_TYPE(JSON_EXTRACT(data, %s)) = 'STRING'", path)
+		}
+		return fmt.Sprintf("JSON_TYPE(JSON_EXTRACT(data, %s)) IN ('INTEGER', 'UNSIGNED INTEGER', 'DOUBLE', 'DECIMAL')", path)
+	case "postgres":
+		if isString {
+			return fmt.Sprintf("jsonb_typeof(%s) = 'string'", postgresJSONPath(field, false))
+		}
+		return fmt.Sprintf("jsonb_typeof(%s) = 'number'", postgresJSONPath(field, false))
+	default:
+		if isString {
+			return fmt.Sprintf("json_type(data, %s) = 'text'", path)
+		}
+		return fmt.Sprintf("json_type(data, %s) IN ('integer', 'real')", path)
+	}
+}
+
+// pipelineJSONValue returns the expression used to compare and sort a JSON field
+func (qt *QueryTranslator) pipelineJSONValue(field string) string {
+	switch qt.dialect {
+	case "mysql":
+		return fmt.Sprintf("JSON_EXTRACT(data, %s)", qt.quoteLiteral(pipelineJSONPath(field)))
+	case "postgres":
+		return postgresJSONPath(field, false)
+	default:
+		return fmt.Sprintf("json_extract(data, %s)", qt.quoteLiteral(pipelineJSONPath(field)))
+	}
+}
+
+// pipelineValue returns the sortable expression for any field
+func (qt *QueryTranslator) pipelineValue(source PipelineSource, field string) string {
+	if source.HasColumn != nil && source.HasColumn(field) {
+		return qt.quoteIdentifier(field)
+	}
+	return qt.pipelineJSONValue(field)
+}
+
+// pipelineNumber returns an expression that is the numeric value of a field or NULL,
+// matching MongoDB accumulators that skip non-numeric values
+func (qt *QueryTranslator) pipelineNumber(source PipelineSource, field string) string {
+	if source.HasColumn != nil && source.HasColumn(field) {
+		return qt.quoteIdentifier(field)
+	}
+	switch qt.dialect {
+	case "mysql":
+		return fmt.Sprintf("CASE WHEN %s THEN %s + 0 END", qt.pipelineTypeGuard(field, 0), qt.pipelineJSONValue(field))
+	case "postgres":
+		return fmt.Sprintf("CASE WHEN %s THEN (%s)::numeric END", qt.pipelineTypeGuard(field, 0), postgresJSONPath(field, true))
+	default:
+		return fmt.Sprintf("CASE WHEN %s THEN %s END", qt.pipelineTypeGuard(field, 0), qt.pipelineJSONValue(field))
+	}
+}
+
+// pipelineKey returns a group key expression; JSON keys are returned JSON encoded
+// so strings, numbers, booleans and nulls survive the round trip. A missing
+// field is SQL NULL while an explicit null is JSON null, so missingAsNull
+// turns missing fields into JSON null to group both together.
+func (qt *QueryTranslator) pipelineKey(source PipelineSource, field string, missingAsNull bool) (string, string) {
+	if source.HasColumn != nil && source.HasColumn(field) {
+		return qt.quoteIdentifier(field), "value"
+	}
+	expr := qt.pipelineJSONValue(field)
+	if qt.dialect == "sqlite" {
+		// The -> operator returns JSON text, keeping booleans apart from numbers
+		expr = fmt.Sprintf("data -> %s", qt.quoteLiteral(pipelineJSONPath(field)))
+	}
+	if !missingAsNull {
+		return expr, "json"
+	}
+	switch qt.dialect {
+	case "mysql":
+		return fmt.Sprintf("COALESCE(%s, CAST('null' AS JSON))", expr), "json"
+	case "postgres":
+		return fmt.Sprintf("COALESCE(%s, 'null'::jsonb)", expr), "json"
+	default:
+		return fmt.Sprintf("COALESCE(%s, 'null')", expr), "json"
+	}
+}
+
+// pipelineGroup translates a $group stage, or returns nil when it has to run in memory
+func (qt *QueryTranslator) pipelineGroup(source PipelineSource, spec map[string]interface{}) *pipelineGroup {
+	idExpr, hasID := spec["_id"]
+	if spec == nil || !hasID {
+		return nil
+	}
+
+	group := &pipelineGroup{orderBys: make(map[string]string)}
+	// MongoDB groups a missing _id field with null, but leaves missing
+	// fields out of a compound _id, which keeps them apart from null
+	addKey := func(output, ref string, missingAsNull bool) bool {
+		field, ok := pipelineFieldRef(ref)
+		if !ok {
+			return false
+		}
+		expr, kind := qt.pipelineKey(source, field, missingAsNull)
+		alias := fmt.Sprintf("g%d", len(group.groupBy))
+		group.selects = append(group.selects, fmt.Sprintf("%s AS %s", expr, alias))
+		group.groupBy = append(group.groupBy, expr)
+		group.columns = append(group.columns, PipelineColumn{Field: output, Kind: kind})
+		group.orderBys[output] = expr
+		if qt.dialect == "sqlite" {
+			// JSON text doesn't sort like the values it holds
+			group.orderBys[output] = qt.pipelineValue(source, field)
+		}
+		return true
+	}
+
+	switch id := idExpr.(type) {
+	case nil:
+		// A single group over all documents; the size column drops it for empty input
+		group.selects = append(group.selects, "COUNT(*) AS n")
+		group.columns = append(group.columns, PipelineColumn{Kind: "size"})
+	case string:
+		if !addKey("_id", id, true) {
+			return nil
+		}
+	case map[string]interface{}:
+		if len(id) == 0 {
+			return nil
+		}
+		for _, key := range sortedKeys(id) {
+			ref, ok := id[key].(string)
+			if !ok || !addKey("_id."+key, ref, false) {
+				return nil
+			}
+		}
+	default:
+		return nil
+	}
+
+	for _, output := range sortedKeys(spec) {
+		if output == "_id" {
+			continue
+		}
+		accMap, ok := spec[output].(map[string]interface{})
+		if !ok || len(accMap) != 1 {
+			return nil
+		}
+		expr, kind, ok := qt.pipelineAccumulator(source, accMap)
+		if !ok {
+			return nil
+		}
+		alias := fmt.Sprintf("a%d", len(group.selects))
+		group.selects = append(group.selects, fmt.Sprintf("%s AS %s", expr, alias))
+		group.columns = append(group.columns, PipelineColumn{Field: output, Kind: kind})
+		group.orderBys[output] = alias
+	}
+
+	return group
+}
+
+// pipelineAccumulator translates one $group accumulator into an SQL aggregate
+func (qt *QueryTranslator) pipelineAccumulator(source PipelineSource, accMap map[string]interface{}) (string, string, bool) {
+	for op, operand := range accMap {
+		if op == "$count" {
+			return "COUNT(*)", "number", true
+		}
+
+		if n, isNumber := toFloat(operand); isNumber && op == "$sum" {
+			return fmt.Sprintf("COUNT(*) * %s", strconv.FormatFloat(n, 'f', -1, 64)), "number", true
+		}
+
+		ref, isString := operand.(string)
+		if !isString {
+			return "", "", false
+		}
+		field, ok := pipelineFieldRef(ref)
+		if !ok {
+			return "", "", false
+		}
+
+		switch op {
+		case "$sum":
+			return fmt.Sprintf("COALESCE(SUM(%s), 0)", qt.pipelineNumber(source, field)), "number", true
+		case "$avg":
+			return fmt.Sprintf("AVG(%s)", qt.pipelineNumber(source, field)), "number", true
+		case "$min", "$max":
+			fn := strings.ToUpper(op[1:])
+			if source.HasColumn != nil && source.HasColumn(field) {
+				return fmt.Sprintf("%s(%s)", fn, qt.quoteIdentifier(field)), "value", true
+			}
+			// Only SQLite orders mixed JSON values the way MongoDB does
+			if qt.dialect == "sqlite" {
+				return fmt.Sprintf("%s(%s)", fn, qt.pipelineJSONValue(field)), "value", true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// pipelineOrderBy translates a $sort stage, or returns nil when it has to run in memory
+func (qt *QueryTranslator) pipelineOrderBy(source PipelineSource, group *pipelineGroup, keys []pipelineSortKey) []string {
+	var orderBy []string
+	for _, key := range keys {
+		var expr string
+		if group != nil {
+			var ok bool
+			if expr, ok = group.orderBys[key.field]; !ok {
+				return nil
+			}
+		} else {
+			expr = qt.pipelineValue(source, key.field)
+		}
+
+		dir := "ASC"
+		if key.descending {
+			dir = "DESC"
+		}
+		if qt.dialect == "postgres" {
+			// MongoDB sorts missing values first
+			if key.descending {
+				dir += " NULLS LAST"
+			} else {
+				dir += " NULLS FIRST"
+			}
+		}
+		orderBy = append(orderBy, expr+" "+dir)
+	}
+	return orderBy
+}
+
+// limitClause renders $skip/$limit for the dialect; a negative limit means no limit
+func (qt *QueryTranslator) limitClause(offset, limit int64) string {
+	clause := ""
+	switch qt.dialect {
+	case "postgres":
+		if limit >= 0 {
+			clause += fmt.Sprintf(" LIMIT %d", limit)
+		}
+		if offset > 0 {
+			clause += fmt.Sprintf(" OFFSET %d", offset)
+		}
+	case "mysql":
+		if limit >= 0 || offset > 0 {
+			if limit < 0 {
+				// MySQL has no OFFSET without LIMIT
+				clause = fmt.Sprintf(" LIMIT %d, 18446744073709551615", offset)
+			} else {
+				clause = fmt.Sprintf(" LIMIT %d, %d", offset, limit)
+			}
+		}
+	default:
+		if limit >= 0 || offset > 0 {
+			clause = fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+		}
+	}
+	return clause
+}
+
+// quoteIdentifier quotes a table or column name for the dialect
+func (qt *QueryTranslator) quoteIdentifier(name string) string {
+	if qt.dialect == "mysql" {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// bindPipelineArg appends a raw argument and returns its placeholder
+func (qt *QueryTranslator) bindPipelineArg(args *[]interface{}, value interface{}) string {
+	*args = append(*args, value)
+	return qt.getPlaceholder(len(*args))
+}
+
+// bindPipelineJSON appends a JSON encoded argument and returns a placeholder cast to JSON
+func (qt *QueryTranslator) bindPipelineJSON(args *[]interface{}, value interface{}) string {
+	switch qt.dialect {
+	case "mysql":
+		encoded, _ := json.Marshal(value)
+		return fmt.Sprintf("CAST(%s AS JSON)", qt.bindPipelineArg(args, string(encoded)))
+	case "postgres":
+		encoded, _ := json.Marshal(value)
+		return qt.bindPipelineArg(args, string(encoded)) + "::jsonb"
+	default:
+		return qt.bindPipelineArg(args, value)
+	}
+}
+
+// ScanGroups decodes the rows of a grouped plan into documents shaped like MongoDB $group output
+func (p *PipelinePlan) ScanGroups(rows *sql.Rows) ([]map[string]interface{}, error) {
+	var results []map[string]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(p.Columns))
+		ptrs := make([]interface{}, len(p.Columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("failed to scan aggregation row: %w", err)
+		}
+
+		doc := map[string]interface{}{"_id": nil}
+		empty := false
+		for i, col := range p.Columns {
+			value, err := decodePipelineValue(values[i], col.Kind)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case col.Kind == "size":
+				empty = value == float64(0)
+			case strings.HasPrefix(col.Field, "_id."):
+				id, _ := doc["_id"].(map[string]interface{})
+				if id == nil {
+					id = make(map[string]interface{})
+					doc["_id"] = id
+				}
+				// SQL NULL is a missing field, which is left out of the _id
+				if values[i] != nil || col.Kind != "json" {
+					id[strings.TrimPrefix(col.Field, "_id.")] = value
+				}
+			default:
+				doc[col.Field] = value
+			}
+		}
+		if !empty {
+			results = append(results, doc)
+		}
+	}
+	return results, rows.Err()
+}
+
+// decodePipelineValue converts a scanned SQL value into the type a JSON document would hold
+func decodePipelineValue(value interface{}, kind string) (interface{}, error) {
+	if b, ok := value.([]byte); ok {
+		value = string(b)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	switch kind {
+	case "json":
+		s, ok := value.(string)
+		if !ok {
+			return normalizeNumber(value), nil
+		}
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+			return nil, fmt.Errorf("failed to decode aggregation value: %w", err)
+		}
+		return decoded, nil
+	case "number", "size":
+		if s, ok := value.(string); ok {
+			n, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode aggregation number %q: %w", s, err)
+			}
+			return n, nil
+		}
+		return normalizeNumber(value), nil
+	default:
+		return normalizeNumber(value), nil
+	}
+}
+
+// scanDocumentRows decodes rows holding a single JSON document column
+func scanDocumentRows(rows *sql.Rows) ([]map[string]interface{}, error) {
+	var results []map[string]interface{}
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal document: %w", err)
+		}
+		results = append(results, doc)
+	}
+	return results, rows.Err()
+}
+
+// pipelineFieldRef extracts the field name of a "$field" reference
+func pipelineFieldRef(ref string) (string, bool) {
+	if !strings.HasPrefix(ref, "$") || len(ref) == 1 || strings.HasPrefix(ref, "$$") {
+		return "", false
+	}
+	return ref[1:], true
+}
+
+// pipelineJSONPath converts a dotted field into a SQLite/MySQL JSON path
+func pipelineJSONPath(field string) string {
+	path := "$"
+	for _, part := range strings.Split(field, ".") {
+		if plainJSONKey.MatchString(part) {
+			path += "." + part
+		} else {
+			path += `."` + strings.ReplaceAll(part, `"`, `\"`) + `"`
+		}
+	}
+	return path
+}
+
+// isPipelineScalar reports whether a value can be compared in SQL with MongoDB semantics
+func isPipelineScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, bool:
+		return true
+	}
+	_, isNumber := toFloat(value)
+	return isNumber
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
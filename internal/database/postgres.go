@@ -0,0 +1,883 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// PostgresDatabase implements DatabaseInterface for PostgreSQL
+type PostgresDatabase struct {
+	db            *sql.DB
+	config        *Config
+	schemaManager *SchemaManager
+}
+
+// PostgresStore implements StoreInterface for PostgreSQL using a JSONB data column
+type PostgresStore struct {
+	tableName string
+	db        *sql.DB
+	database  *PostgresDatabase
+}
+
+// PostgresUpdateResult implements UpdateResult interface
+type PostgresUpdateResult struct {
+	modifiedCount int64
+	upsertedCount int64
+	upsertedID    interface{}
+}
+
+func (r *PostgresUpdateResult) ModifiedCount() int64    { return r.modifiedCount }
+func (r *PostgresUpdateResult) UpsertedCount() int64    { return r.upsertedCount }
+func (r *PostgresUpdateResult) UpsertedID() interface{} { return r.upsertedID }
+
+// PostgresDeleteResult implements DeleteResult interface
+type PostgresDeleteResult struct {
+	deletedCount int64
+}
+
+func (r *PostgresDeleteResult) DeletedCount() int64 { return r.deletedCount }
+
+// NewPostgresDatabase creates a new PostgreSQL database instance
+func NewPostgresDatabase(config *Config) (DatabaseInterface, error) {
+	db, err := sql.Open("postgres", buildPostgresDSN(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PostgreSQL database: %w", err)
+	}
+
+	// Configure connection pool
+	db.SetMaxOpenConns(50)
+	db.SetMaxIdleConns(10)
+	db.SetConnMaxLifetime(30 * time.Minute)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	// Test connection
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping PostgreSQL database: %w", err)
+	}
+
+	pgDB := &PostgresDatabase{
+		db:     db,
+		config: config,
+	}
+
+	// Initialize schema manager
+	pgDB.schemaManager = NewSchemaManager(db, DatabaseTypePostgres, "")
+
+	return pgDB, nil
+}
+
+// buildPostgresDSN builds a postgres:// connection URL from the config
+func buildPostgresDSN(config *Config) string {
+	host := config.Host
+	if host == "" {
+		host = "localhost"
+	}
+	port := config.Port
+	if port == 0 {
+		port = 5432
+	}
+
+	dsn := &url.URL{
+		Scheme: "postgres",
+		Host:   fmt.Sprintf("%s:%d", host, port),
+		Path:   "/" + config.Name,
+	}
+	if config.Username != "" && config.Password != "" {
+		dsn.User = url.UserPassword(config.Username, config.Password)
+	} else if config.Username != "" {
+		dsn.User = url.User(config.Username)
+	}
+
+	params := url.Values{}
+	if config.SSL {
+		params.Set("sslmode", "require")
+	} else {
+		params.Set("sslmode", "disable")
+	}
+	dsn.RawQuery = params.Encode()
+
+	return dsn.String()
+}
+
+func (d *PostgresDatabase) CreateStore(namespace string) StoreInterface {
+	// Check if this collection should use column-based storage
+	schema, err := d.schemaManager.GetSchema(namespace)
+	if err != nil {
+		// Log error but fall back to JSON store
+		fmt.Printf("Warning: failed to get schema for %s, using JSON storage: %v\n", namespace, err)
+		return d.newJSONStore(namespace)
+	}
+
+	if schema.UseColumns {
+		// Use column-based storage
+		columnStore, err := NewColumnStore(namespace, d.db, d, d.schemaManager)
+		if err != nil {
+			// Log error but fall back to JSON store
+			fmt.Printf("Warning: failed to create column store for %s, using JSON storage: %v\n", namespace, err)
+			return d.newJSONStore(namespace)
+		}
+		return columnStore
+	}
+
+	// Use traditional JSON-based storage
+	return d.newJSONStore(namespace)
+}
+
+// newJSONStore creates a JSONB-backed store and ensures its table exists
+func (d *PostgresDatabase) newJSONStore(namespace string) *PostgresStore {
+	store := &PostgresStore{
+		tableName: namespace,
+		db:        d.db,
+		database:  d,
+	}
+	if err := store.ensureTable(); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	return store
+}
+
+func (d *PostgresDatabase) Close() error {
+	return d.db.Close()
+}
+
+func (d *PostgresDatabase) Drop() error {
+	// Get all table names in the current schema
+	rows, err := d.db.Query("SELECT tablename FROM pg_tables WHERE schemaname = current_schema()")
+	if err != nil {
+		return fmt.Errorf("failed to get table names: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			return fmt.Errorf("failed to scan table name: %w", err)
+		}
+		tables = append(tables, tableName)
+	}
+
+	// Drop all tables
+	for _, table := range tables {
+		if _, err := d.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s CASCADE", quotePostgresIdentifier(table))); err != nil {
+			return fmt.Errorf("failed to drop table %s: %w", table, err)
+		}
+	}
+
+	return nil
+}
+
+func (d *PostgresDatabase) GetType() DatabaseType {
+	return DatabaseTypePostgres
+}
+
+// Begin starts a transaction, or opens a savepoint in the one ctx carries
+func (d *PostgresDatabase) Begin(ctx context.Context) (context.Context, error) {
+	return beginSQLTx(ctx, d, d.db)
+}
+
+// Commit commits the transaction (or releases the savepoint) ctx carries
+func (d *PostgresDatabase) Commit(ctx context.Context) error {
+	return commitTx(ctx, d)
+}
+
+// Rollback rolls back the transaction (or savepoint) ctx carries
+func (d *PostgresDatabase) Rollback(ctx context.Context) error {
+	return rollbackTx(ctx, d)
+}
+
+// ensureTable creates the table if it doesn't exist
+func (s *PostgresStore) ensureTable() error {
+	quotedTable := s.quotedTableName()
+	createSQL := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			id TEXT PRIMARY KEY,
+			data JSONB NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		)
+	`, quotedTable)
+
+	if _, err := s.db.Exec(createSQL); err != nil {
+		return fmt.Errorf("failed to create table %s: %w", s.tableName, err)
+	}
+
+	// Create indexes for common queries; the GIN index serves containment lookups
+	indexSQL := fmt.Sprintf(`
+		CREATE INDEX IF NOT EXISTS %s ON %s(created_at);
+		CREATE INDEX IF NOT EXISTS %s ON %s(updated_at);
+		CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (data jsonb_path_ops);
+	`,
+		quotePostgresIdentifier("idx_"+s.tableName+"_created_at"), quotedTable,
+		quotePostgresIdentifier("idx_"+s.tableName+"_updated_at"), quotedTable,
+		quotePostgresIdentifier("idx_"+s.tableName+"_data"), quotedTable)
+
+	if _, err := s.db.Exec(indexSQL); err != nil {
+		return fmt.Errorf("failed to create indexes for table %s: %w", s.tableName, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) CreateUniqueIdentifier() string {
+	return generateUniqueID()
+}
+
+func (s *PostgresStore) Insert(ctx context.Context, document interface{}) (interface{}, error) {
+	doc, ok := document.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("document must be a map[string]interface{}")
+	}
+
+	// Ensure the document has an ID
+	if _, exists := doc["id"]; !exists {
+		doc["id"] = s.CreateUniqueIdentifier()
+	}
+
+	// Add timestamps
+	now := time.Now()
+	if _, exists := doc["createdAt"]; !exists {
+		doc["createdAt"] = now
+	}
+	if _, exists := doc["updatedAt"]; !exists {
+		doc["updatedAt"] = now
+	}
+
+	// Serialize to JSON
+	jsonData, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal document: %w", err)
+	}
+
+	insertSQL := fmt.Sprintf("INSERT INTO %s (id, data, created_at, updated_at) VALUES ($1, $2, $3, $4)", s.quotedTableName())
+	_, err = sqlConn(ctx, s.db).ExecContext(ctx, insertSQL, fmt.Sprintf("%v", doc["id"]), string(jsonData), now, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert document: %w", err)
+	}
+
+	return doc, nil
+}
+
+// InsertMany inserts documents with multi-row INSERT statements in one
+// transaction, so either all of them are inserted or none is
+func (s *PostgresStore) InsertMany(ctx context.Context, documents []map[string]interface{}) ([]map[string]interface{}, error) {
+	rows := make([]jsonRow, 0, len(documents))
+	for _, doc := range documents {
+		row, err := prepareJSONRow(doc, s.CreateUniqueIdentifier)
+		if err != nil {
+			return nil, err
+		}
+		row.id = fmt.Sprintf("%v", row.id)
+		rows = append(rows, row)
+	}
+
+	err := inTx(ctx, s.database, func(ctx context.Context) error {
+		return insertJSONRows(ctx, sqlConn(ctx, s.db), s.quotedTableName(), rows, func(n int) string { return fmt.Sprintf("$%d", n) })
+	})
+	if err != nil {
+		return nil, err
+	}
+	return documents, nil
+}
+
+func (s *PostgresStore) Find(ctx context.Context, query QueryBuilder, opts QueryOptions) ([]map[string]interface{}, error) {
+	return s.findByMap(ctx, query.ToMap(), opts)
+}
+
+// findByMap runs a SELECT for a MongoDB-style query map
+func (s *PostgresStore) findByMap(ctx context.Context, queryMap map[string]interface{}, opts QueryOptions) ([]map[string]interface{}, error) {
+	baseSQL := fmt.Sprintf("SELECT data FROM %s", s.quotedTableName())
+
+	// Build WHERE clause
+	whereClause, args, err := newPostgresWhereBuilder(s.hasColumn).Build(queryMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to translate query: %w", err)
+	}
+	if whereClause != "" {
+		baseSQL += " WHERE " + whereClause
+	}
+
+	// Add ORDER BY
+	if len(opts.Sort) > 0 {
+		baseSQL += " ORDER BY " + postgresOrderClause(opts, s.hasColumn)
+	}
+
+	// Add LIMIT and OFFSET
+	if opts.Limit != nil {
+		baseSQL += fmt.Sprintf(" LIMIT %d", *opts.Limit)
+	}
+	if opts.Skip != nil {
+		baseSQL += fmt.Sprintf(" OFFSET %d", *opts.Skip)
+	}
+
+	rows, err := sqlConn(ctx, s.db).QueryContext(ctx, baseSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", err)
+	}
+	defer rows.Close()
+
+	var results []map[string]interface{}
+	for rows.Next() {
+		var jsonData []byte
+		if err := rows.Scan(&jsonData); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		var doc map[string]interface{}
+		if err := json.Unmarshal(jsonData, &doc); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal document: %w", err)
+		}
+
+		// Apply field projection if specified
+		if len(opts.Fields) > 0 {
+			doc = s.applyFieldProjection(doc, opts.Fields)
+		}
+
+		results = append(results, doc)
+	}
+
+	return results, rows.Err()
+}
+
+func (s *PostgresStore) FindOne(ctx context.Context, query QueryBuilder) (map[string]interface{}, error) {
+	opts := QueryOptions{Limit: &[]int64{1}[0]}
+	results, err := s.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	return results[0], nil
+}
+
+func (s *PostgresStore) Update(ctx context.Context, query QueryBuilder, update UpdateBuilder) (UpdateResult, error) {
+	return s.performUpdate(ctx, query.ToMap(), update.ToMap(), false)
+}
+
+func (s *PostgresStore) UpdateOne(ctx context.Context, query QueryBuilder, update UpdateBuilder) (UpdateResult, error) {
+	return s.performUpdate(ctx, query.ToMap(), update.ToMap(), true)
+}
+
+func (s *PostgresStore) performUpdate(ctx context.Context, queryMap map[string]interface{}, updateMap map[string]interface{}, updateOne bool) (UpdateResult, error) {
+	opts := QueryOptions{}
+	if updateOne {
+		opts.Limit = &[]int64{1}[0]
+	}
+
+	// First, find the documents to update
+	existingDocs, err := s.findByMap(ctx, queryMap, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find documents to update: %w", err)
+	}
+
+	modifiedCount := int64(0)
+	for _, doc := range existingDocs {
+		originalDoc := make(map[string]interface{})
+		for k, v := range doc {
+			originalDoc[k] = v
+		}
+
+		// Apply update operations
+		s.applyUpdateOperations(doc, updateMap)
+
+		// Check if document actually changed
+		if s.documentsEqual(originalDoc, doc) {
+			continue
+		}
+
+		// The row is only written if it still matches the query, so
+		// concurrent updates conditioned on a document's state (such as
+		// claiming a job) cannot both apply
+		changed, err := s.writeDocument(ctx, doc, queryMap)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			modifiedCount++
+		}
+	}
+
+	return &PostgresUpdateResult{modifiedCount: modifiedCount}, nil
+}
+
+// writeDocument stores a modified document back into its row if the row
+// still matches condition, which may be nil
+func (s *PostgresStore) writeDocument(ctx context.Context, doc map[string]interface{}, condition map[string]interface{}) (bool, error) {
+	// Update timestamp
+	doc["updatedAt"] = time.Now()
+
+	jsonData, err := json.Marshal(doc)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal updated document: %w", err)
+	}
+
+	// Build the condition first so its placeholders come before the
+	// document's
+	builder := newPostgresWhereBuilder(s.hasColumn)
+	whereClause, _, err := builder.Build(condition)
+	if err != nil {
+		return false, fmt.Errorf("failed to build update condition: %w", err)
+	}
+	if whereClause != "" {
+		whereClause = " AND (" + whereClause + ")"
+	}
+	updateSQL := fmt.Sprintf("UPDATE %s SET data = %s, updated_at = %s WHERE id = %s%s",
+		s.quotedTableName(),
+		builder.bind(string(jsonData)),
+		builder.bind(doc["updatedAt"]),
+		builder.bind(fmt.Sprintf("%v", doc["id"])),
+		whereClause)
+	result, err := sqlConn(ctx, s.db).ExecContext(ctx, updateSQL, builder.args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to update document: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+func (s *PostgresStore) Remove(ctx context.Context, query QueryBuilder) (DeleteResult, error) {
+	return s.removeByMap(ctx, query.ToMap())
+}
+
+// removeByMap deletes every row matching a MongoDB-style query map
+func (s *PostgresStore) removeByMap(ctx context.Context, queryMap map[string]interface{}) (DeleteResult, error) {
+	deleteSQL := fmt.Sprintf("DELETE FROM %s", s.quotedTableName())
+
+	whereClause, args, err := newPostgresWhereBuilder(s.hasColumn).Build(queryMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to translate query: %w", err)
+	}
+	if whereClause != "" {
+		deleteSQL += " WHERE " + whereClause
+	}
+
+	result, err := sqlConn(ctx, s.db).ExecContext(ctx, deleteSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete documents: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return &PostgresDeleteResult{deletedCount: rowsAffected}, nil
+}
+
+func (s *PostgresStore) Count(ctx context.Context, query QueryBuilder) (int64, error) {
+	return s.countByMap(ctx, query.ToMap())
+}
+
+// countByMap counts rows matching a MongoDB-style query map
+func (s *PostgresStore) countByMap(ctx context.Context, queryMap map[string]interface{}) (int64, error) {
+	countSQL := fmt.Sprintf("SELECT COUNT(*) FROM %s", s.quotedTableName())
+
+	whereClause, args, err := newPostgresWhereBuilder(s.hasColumn).Build(queryMap)
+	if err != nil {
+		return 0, fmt.Errorf("failed to translate query: %w", err)
+	}
+	if whereClause != "" {
+		countSQL += " WHERE " + whereClause
+	}
+
+	var count int64
+	if err := sqlConn(ctx, s.db).QueryRowContext(ctx, countSQL, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count documents: %w", err)
+	}
+
+	return count, nil
+}
+
+// Specialized MongoDB-style operations
+func (s *PostgresStore) Increment(ctx context.Context, query QueryBuilder, increments map[string]interface{}) (UpdateResult, error) {
+	update := NewUpdateBuilder()
+	for field, value := range increments {
+		update.Inc(field, value)
+	}
+	return s.Update(ctx, query, update)
+}
+
+func (s *PostgresStore) Push(ctx context.Context, query QueryBuilder, pushOps map[string]interface{}) (UpdateResult, error) {
+	update := NewUpdateBuilder()
+	for field, value := range pushOps {
+		update.Push(field, value)
+	}
+	return s.Update(ctx, query, update)
+}
+
+func (s *PostgresStore) Pull(ctx context.Context, query QueryBuilder, pullOps map[string]interface{}) (UpdateResult, error) {
+	update := NewUpdateBuilder()
+	for field, value := range pullOps {
+		update.Pull(field, value)
+	}
+	return s.Update(ctx, query, update)
+}
+
+func (s *PostgresStore) AddToSet(ctx context.Context, query QueryBuilder, addOps map[string]interface{}) (UpdateResult, error) {
+	update := NewUpdateBuilder()
+	for field, value := range addOps {
+		update.AddToSet(field, value)
+	}
+	return s.Update(ctx, query, update)
+}
+
+func (s *PostgresStore) PopFirst(ctx context.Context, query QueryBuilder, fields []string) (UpdateResult, error) {
+	return s.popArrayElements(ctx, query, fields, true)
+}
+
+func (s *PostgresStore) PopLast(ctx context.Context, query QueryBuilder, fields []string) (UpdateResult, error) {
+	return s.popArrayElements(ctx, query, fields, false)
+}
+
+// popArrayElements removes the first or last element of the given array fields
+func (s *PostgresStore) popArrayElements(ctx context.Context, query QueryBuilder, fields []string, first bool) (UpdateResult, error) {
+	docs, err := s.Find(ctx, query, QueryOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find documents to update: %w", err)
+	}
+
+	modifiedCount := int64(0)
+	for _, doc := range docs {
+		changed := false
+		for _, field := range fields {
+			array, ok := doc[field].([]interface{})
+			if !ok || len(array) == 0 {
+				continue
+			}
+			if first {
+				doc[field] = array[1:]
+			} else {
+				doc[field] = array[:len(array)-1]
+			}
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+
+		written, err := s.writeDocument(ctx, doc, nil)
+		if err != nil {
+			return nil, err
+		}
+		if written {
+			modifiedCount++
+		}
+	}
+
+	return &PostgresUpdateResult{modifiedCount: modifiedCount}, nil
+}
+
+func (s *PostgresStore) Upsert(ctx context.Context, query QueryBuilder, update UpdateBuilder) (UpdateResult, error) {
+	// Try update first
+	result, err := s.Update(ctx, query, update)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.ModifiedCount() > 0 {
+		return result, nil
+	}
+
+	// If no documents were updated, create a new one
+	updateMap := update.ToMap()
+	queryMap := query.ToMap()
+
+	// Merge query and update into a new document
+	newDoc := make(map[string]interface{})
+
+	// Add query fields
+	for field, value := range queryMap {
+		if !strings.HasPrefix(field, "$") {
+			newDoc[field] = value
+		}
+	}
+
+	// Apply update operations to create the document
+	s.applyUpdateOperations(newDoc, updateMap)
+
+	// Insert the new document
+	_, err = s.Insert(ctx, newDoc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert document: %w", err)
+	}
+
+	return &PostgresUpdateResult{
+		modifiedCount: 0,
+		upsertedCount: 1,
+		upsertedID:    newDoc["id"],
+	}, nil
+}
+
+func (s *PostgresStore) Aggregate(ctx context.Context, pipeline []map[string]interface{}) ([]map[string]interface{}, error) {
+	// Push the leading stages into SQL and evaluate whatever is left in memory
+	plan, err := NewQueryTranslator("postgres").TranslatePipeline(PipelineSource{
+		Table:     s.tableName,
+		Select:    "data",
+		HasColumn: s.hasColumn,
+	}, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to translate aggregation pipeline: %w", err)
+	}
+
+	rows, err := sqlConn(ctx, s.db).QueryContext(ctx, plan.SQL, plan.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run aggregation: %w", err)
+	}
+	defer rows.Close()
+
+	var docs []map[string]interface{}
+	if plan.Grouped {
+		docs, err = plan.ScanGroups(rows)
+	} else {
+		docs, err = scanDocumentRows(rows)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return evaluatePipeline(docs, plan.Remaining)
+}
+
+// Helper methods
+
+func (s *PostgresStore) quotedTableName() string {
+	// Quote table names to handle special characters like hyphens
+	return quotePostgresIdentifier(s.tableName)
+}
+
+// hasColumn reports fields that live in a real column instead of the JSONB data.
+// Only the primary key does, which lets id lookups use the primary key index.
+func (s *PostgresStore) hasColumn(field string) bool {
+	return field == "id"
+}
+
+func (s *PostgresStore) applyFieldProjection(doc map[string]interface{}, fields map[string]int) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	// Check if this is inclusion or exclusion
+	hasInclusions := false
+	for _, include := range fields {
+		if include == 1 {
+			hasInclusions = true
+			break
+		}
+	}
+
+	if hasInclusions {
+		// Inclusion mode: only include specified fields
+		for field, include := range fields {
+			if include == 1 {
+				if value, exists := doc[field]; exists {
+					result[field] = value
+				}
+			}
+		}
+		// Always include id
+		if _, hasID := fields["id"]; !hasID {
+			if id, exists := doc["id"]; exists {
+				result["id"] = id
+			}
+		}
+	} else {
+		// Exclusion mode: include all except specified fields
+		for field, value := range doc {
+			if exclude, exists := fields[field]; !exists || exclude != 0 {
+				result[field] = value
+			}
+		}
+	}
+
+	return result
+}
+
+func (s *PostgresStore) applyUpdateOperations(doc map[string]interface{}, updateMap map[string]interface{}) {
+	for operation, fields := range updateMap {
+		fieldMap, ok := fields.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch operation {
+		case "$set":
+			for field, value := range fieldMap {
+				doc[field] = value
+			}
+		case "$unset":
+			for field := range fieldMap {
+				delete(doc, field)
+			}
+		case "$inc":
+			for field, value := range fieldMap {
+				if existing, exists := doc[field]; exists {
+					if existingNum, ok := toFloat(existing); ok {
+						if incNum, ok := toFloat(value); ok {
+							doc[field] = existingNum + incNum
+						}
+					}
+				} else {
+					doc[field] = value
+				}
+			}
+		case "$push":
+			for field, value := range fieldMap {
+				if existing, exists := doc[field]; exists {
+					if existingArray, ok := existing.([]interface{}); ok {
+						doc[field] = append(existingArray, value)
+					}
+				} else {
+					doc[field] = []interface{}{value}
+				}
+			}
+		case "$pull":
+			for field, value := range fieldMap {
+				if existing, exists := doc[field]; exists {
+					if existingArray, ok := existing.([]interface{}); ok {
+						newArray := make([]interface{}, 0, len(existingArray))
+						for _, item := range existingArray {
+							if !s.valuesEqual(item, value) {
+								newArray = append(newArray, item)
+							}
+						}
+						doc[field] = newArray
+					}
+				}
+			}
+		case "$addToSet":
+			for field, value := range fieldMap {
+				if existing, exists := doc[field]; exists {
+					if existingArray, ok := existing.([]interface{}); ok {
+						found := false
+						for _, item := range existingArray {
+							if s.valuesEqual(item, value) {
+								found = true
+								break
+							}
+						}
+						if !found {
+							doc[field] = append(existingArray, value)
+						}
+					}
+				} else {
+					doc[field] = []interface{}{value}
+				}
+			}
+		}
+	}
+}
+
+func (s *PostgresStore) valuesEqual(a, b interface{}) bool {
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+	return string(aJSON) == string(bJSON)
+}
+
+func (s *PostgresStore) documentsEqual(a, b map[string]interface{}) bool {
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+	return string(aJSON) == string(bJSON)
+}
+
+// parseRawOptions converts raw query options ($sort, $limit, $skip, $fields) to QueryOptions
+func (s *PostgresStore) parseRawOptions(options map[string]interface{}) QueryOptions {
+	opts := QueryOptions{}
+
+	switch sortSpec := options["$sort"].(type) {
+	case map[string]int:
+		opts.Sort = sortSpec
+	case map[string]interface{}:
+		opts.Sort = make(map[string]int)
+		for field, direction := range sortSpec {
+			if dir, ok := toFloat(direction); ok && dir < 0 {
+				opts.Sort[field] = -1
+			} else {
+				opts.Sort[field] = 1
+			}
+		}
+	}
+
+	if limit, ok := toInt64(options["$limit"]); ok {
+		opts.Limit = &limit
+	}
+	if skip, ok := toInt64(options["$skip"]); ok {
+		opts.Skip = &skip
+	}
+
+	switch fields := options["$fields"].(type) {
+	case map[string]int:
+		opts.Fields = fields
+	case map[string]interface{}:
+		opts.Fields = make(map[string]int)
+		for field, include := range fields {
+			switch v := include.(type) {
+			case bool:
+				if v {
+					opts.Fields[field] = 1
+				} else {
+					opts.Fields[field] = 0
+				}
+			default:
+				if n, ok := toFloat(v); ok {
+					opts.Fields[field] = int(n)
+				}
+			}
+		}
+	}
+
+	return opts
+}
+
+// Enhanced MongoDB-style query methods
+func (s *PostgresStore) FindWithRawQuery(ctx context.Context, mongoQuery interface{}, options map[string]interface{}) ([]map[string]interface{}, error) {
+	parsedQuery, err := ParseMongoQuery(mongoQuery)
+	if err != nil {
+		return nil, err
+	}
+	return s.findByMap(ctx, parsedQuery, s.parseRawOptions(options))
+}
+
+func (s *PostgresStore) CountWithRawQuery(ctx context.Context, mongoQuery interface{}) (int64, error) {
+	parsedQuery, err := ParseMongoQuery(mongoQuery)
+	if err != nil {
+		return 0, err
+	}
+	return s.countByMap(ctx, parsedQuery)
+}
+
+func (s *PostgresStore) UpdateWithRawQuery(ctx context.Context, mongoQuery interface{}, mongoUpdate interface{}) (UpdateResult, error) {
+	parsedQuery, err := ParseMongoQuery(mongoQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedUpdate, err := ParseMongoQuery(mongoUpdate)
+	if err != nil {
+		return nil, err
+	}
+
+	// A plain document (no operators) is treated as a $set
+	if !isOperatorMap(parsedUpdate) {
+		parsedUpdate = map[string]interface{}{"$set": parsedUpdate}
+	}
+
+	return s.performUpdate(ctx, parsedQuery, parsedUpdate, false)
+}
+
+func (s *PostgresStore) RemoveWithRawQuery(ctx context.Context, mongoQuery interface{}) (DeleteResult, error) {
+	parsedQuery, err := ParseMongoQuery(mongoQuery)
+	if err != nil {
+		return nil, err
+	}
+	return s.removeByMap(ctx, parsedQuery)
+}
+
+// Register PostgreSQL database factory
+func init() {
+	RegisterDatabaseFactory(DatabaseTypePostgres, NewPostgresDatabase)
+}
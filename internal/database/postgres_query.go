@@ -0,0 +1,443 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// postgresWhereBuilder converts MongoDB-style query maps into PostgreSQL WHERE
+// clauses. Fields stored in the JSONB data column are compared as jsonb so that
+// numbers, strings and booleans keep their JSON ordering semantics; fields that
+// have a dedicated column (see ColumnStore) are compared directly.
+type postgresWhereBuilder struct {
+	args []interface{}
+	// columnChecker determines if a field has a dedicated column.
+	// If nil, every field is read from the JSONB data column.
+	columnChecker func(field string) bool
+}
+
+// newPostgresWhereBuilder creates a builder whose placeholders start at $1
+func newPostgresWhereBuilder(columnChecker func(field string) bool) *postgresWhereBuilder {
+	return &postgresWhereBuilder{
+		args:          make([]interface{}, 0),
+		columnChecker: columnChecker,
+	}
+}
+
+// Build returns the WHERE clause (without the WHERE keyword) and its arguments
+func (b *postgresWhereBuilder) Build(query map[string]interface{}) (string, []interface{}, error) {
+	clause, err := b.buildGroup(query)
+	if err != nil {
+		return "", nil, err
+	}
+	return clause, b.args, nil
+}
+
+// buildGroup ANDs together all conditions of a query object
+func (b *postgresWhereBuilder) buildGroup(query map[string]interface{}) (string, error) {
+	// Iterate in a stable order so generated SQL is deterministic
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, field := range keys {
+		value := query[field]
+
+		var clause string
+		var err error
+		if strings.HasPrefix(field, "$") {
+			clause, err = b.buildLogical(field, value)
+		} else if ops, ok := value.(map[string]interface{}); ok && isOperatorMap(ops) {
+			clause, err = b.buildFieldOperators(field, ops)
+		} else {
+			clause, err = b.buildComparison(field, "$eq", value)
+		}
+		if err != nil {
+			return "", err
+		}
+		if clause != "" {
+			parts = append(parts, clause)
+		}
+	}
+
+	return strings.Join(parts, " AND "), nil
+}
+
+// buildLogical handles root-level $or, $and, $nor and $not
+func (b *postgresWhereBuilder) buildLogical(operator string, value interface{}) (string, error) {
+	switch operator {
+	case "$or", "$and", "$nor":
+		conditions, err := toConditionList(value)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", operator, err)
+		}
+
+		var clauses []string
+		for _, cond := range conditions {
+			clause, err := b.buildGroup(cond)
+			if err != nil {
+				return "", err
+			}
+			if clause != "" {
+				clauses = append(clauses, "("+clause+")")
+			}
+		}
+		if len(clauses) == 0 {
+			return "", nil
+		}
+
+		switch operator {
+		case "$or":
+			return "(" + strings.Join(clauses, " OR ") + ")", nil
+		case "$nor":
+			return "NOT (" + strings.Join(clauses, " OR ") + ")", nil
+		default:
+			return strings.Join(clauses, " AND "), nil
+		}
+
+	case "$not":
+		notMap, ok := value.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("$not must be an object")
+		}
+		clause, err := b.buildGroup(notMap)
+		if err != nil || clause == "" {
+			return "", err
+		}
+		return "NOT (" + clause + ")", nil
+	}
+
+	// Unknown root operators are ignored, like the other SQL stores do
+	return "", nil
+}
+
+// buildFieldOperators ANDs together every operator applied to a single field
+func (b *postgresWhereBuilder) buildFieldOperators(field string, ops map[string]interface{}) (string, error) {
+	keys := make([]string, 0, len(ops))
+	for op := range ops {
+		keys = append(keys, op)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, op := range keys {
+		if op == "$options" {
+			continue // consumed by $regex
+		}
+
+		value := ops[op]
+		if op == "$regex" {
+			if options, ok := ops["$options"].(string); ok && strings.Contains(options, "i") {
+				op = "$iregex"
+			}
+		}
+
+		clause, err := b.buildComparison(field, op, value)
+		if err != nil {
+			return "", err
+		}
+		if clause != "" {
+			parts = append(parts, clause)
+		}
+	}
+
+	if len(parts) == 0 {
+		return "", nil
+	}
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	return "(" + strings.Join(parts, " AND ") + ")", nil
+}
+
+// buildComparison renders a single field/operator/value condition
+func (b *postgresWhereBuilder) buildComparison(field, op string, value interface{}) (string, error) {
+	isColumn := b.columnChecker != nil && b.columnChecker(field)
+	ref := b.jsonRef(field)
+	textRef := b.jsonTextRef(field)
+	if isColumn {
+		ref = quotePostgresIdentifier(field)
+		textRef = ref + "::text"
+	}
+
+	switch op {
+	case "$eq", "=":
+		if value == nil {
+			if isColumn {
+				return fmt.Sprintf("%s IS NULL", ref), nil
+			}
+			return fmt.Sprintf("(%s IS NULL OR %s = 'null'::jsonb)", ref, ref), nil
+		}
+		return fmt.Sprintf("%s = %s", ref, b.bindValue(value, isColumn)), nil
+
+	case "$ne", "!=":
+		if value == nil {
+			if isColumn {
+				return fmt.Sprintf("%s IS NOT NULL", ref), nil
+			}
+			return fmt.Sprintf("(%s IS NOT NULL AND %s <> 'null'::jsonb)", ref, ref), nil
+		}
+		// MongoDB's $ne also matches documents where the field is missing
+		return fmt.Sprintf("(%s IS NULL OR %s <> %s)", ref, ref, b.bindValue(value, isColumn)), nil
+
+	case "$gt", ">":
+		return fmt.Sprintf("%s > %s", ref, b.bindValue(value, isColumn)), nil
+	case "$gte", ">=":
+		return fmt.Sprintf("%s >= %s", ref, b.bindValue(value, isColumn)), nil
+	case "$lt", "<":
+		return fmt.Sprintf("%s < %s", ref, b.bindValue(value, isColumn)), nil
+	case "$lte", "<=":
+		return fmt.Sprintf("%s <= %s", ref, b.bindValue(value, isColumn)), nil
+
+	case "$in", "$nin":
+		values, ok := toInterfaceSlice(value)
+		if !ok {
+			return "", fmt.Errorf("%s must be an array", op)
+		}
+		if len(values) == 0 {
+			if op == "$in" {
+				return "1=0", nil // Always false
+			}
+			return "1=1", nil // Always true
+		}
+		placeholders := make([]string, len(values))
+		for i, v := range values {
+			placeholders[i] = b.bindValue(v, isColumn)
+		}
+		if op == "$in" {
+			return fmt.Sprintf("%s IN (%s)", ref, strings.Join(placeholders, ", ")), nil
+		}
+		return fmt.Sprintf("(%s IS NULL OR %s NOT IN (%s))", ref, ref, strings.Join(placeholders, ", ")), nil
+
+	case "$regex", "$iregex":
+		pattern, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("$regex must be a string")
+		}
+		operator := "~"
+		if op == "$iregex" {
+			operator = "~*"
+		}
+		return fmt.Sprintf("%s %s %s", textRef, operator, b.bind(pattern)), nil
+
+	case "$exists":
+		exists, ok := value.(bool)
+		if !ok {
+			return "", fmt.Errorf("$exists must be a boolean")
+		}
+		if exists {
+			return fmt.Sprintf("%s IS NOT NULL", ref), nil
+		}
+		return fmt.Sprintf("%s IS NULL", ref), nil
+
+	case "$size":
+		size, ok := toFloat(value)
+		if !ok {
+			return "", fmt.Errorf("$size must be a number")
+		}
+		if isColumn {
+			ref = ref + "::jsonb"
+		}
+		return fmt.Sprintf("(jsonb_typeof(%s) = 'array' AND jsonb_array_length(%s) = %s)", ref, ref, b.bind(int(size))), nil
+
+	case "$all":
+		values, ok := toInterfaceSlice(value)
+		if !ok {
+			return "", fmt.Errorf("$all must be an array")
+		}
+		if isColumn {
+			ref = ref + "::jsonb"
+		}
+		jsonBytes, err := json.Marshal(values)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal $all values: %w", err)
+		}
+		return fmt.Sprintf("%s @> %s::jsonb", ref, b.bind(string(jsonBytes))), nil
+	}
+
+	// Unsupported operators ($type, $elemMatch, ...) are skipped, matching the
+	// behaviour of the other SQL translators
+	return "", nil
+}
+
+// bind appends an argument and returns its positional placeholder
+func (b *postgresWhereBuilder) bind(value interface{}) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+// bindValue binds a comparison value, encoding it as jsonb for JSON fields
+func (b *postgresWhereBuilder) bindValue(value interface{}, isColumn bool) string {
+	if isColumn {
+		return b.bind(value)
+	}
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		jsonBytes, _ = json.Marshal(fmt.Sprintf("%v", value))
+	}
+	return b.bind(string(jsonBytes)) + "::jsonb"
+}
+
+// jsonRef returns the jsonb accessor for a (possibly dotted) field
+func (b *postgresWhereBuilder) jsonRef(field string) string {
+	return postgresJSONPath(field, false)
+}
+
+// jsonTextRef returns the text accessor for a (possibly dotted) field
+func (b *postgresWhereBuilder) jsonTextRef(field string) string {
+	return postgresJSONPath(field, true)
+}
+
+// postgresJSONPath builds data->'field' style accessors, using #> for nested paths
+func postgresJSONPath(field string, asText bool) string {
+	parts := strings.Split(field, ".")
+	if len(parts) == 1 {
+		if asText {
+			return fmt.Sprintf("data->>%s", quotePostgresLiteral(field))
+		}
+		return fmt.Sprintf("data->%s", quotePostgresLiteral(field))
+	}
+
+	path := "{" + strings.Join(parts, ",") + "}"
+	if asText {
+		return fmt.Sprintf("data#>>%s", quotePostgresLiteral(path))
+	}
+	return fmt.Sprintf("data#>%s", quotePostgresLiteral(path))
+}
+
+// postgresOrderClause builds an ORDER BY clause for the given sort specification
+func postgresOrderClause(opts QueryOptions, columnChecker func(field string) bool) string {
+	var orderParts []string
+	for _, field := range opts.SortFields() {
+		dir := "ASC"
+		if opts.Sort[field] == -1 {
+			dir = "DESC"
+		}
+		ref := postgresJSONPath(field, false)
+		if columnChecker != nil && columnChecker(field) {
+			ref = quotePostgresIdentifier(field)
+		}
+		orderParts = append(orderParts, fmt.Sprintf("%s %s", ref, dir))
+	}
+	return strings.Join(orderParts, ", ")
+}
+
+// rebindPostgres rewrites ? placeholders into PostgreSQL's positional $n form
+func rebindPostgres(query string) string {
+	var result strings.Builder
+	index := 1
+	inString := false
+	for _, ch := range query {
+		switch {
+		case ch == '\'':
+			inString = !inString
+			result.WriteRune(ch)
+		case ch == '?' && !inString:
+			result.WriteString(fmt.Sprintf("$%d", index))
+			index++
+		default:
+			result.WriteRune(ch)
+		}
+	}
+	return result.String()
+}
+
+// quotePostgresIdentifier quotes a table or column name
+func quotePostgresIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quotePostgresLiteral quotes a string literal
+func quotePostgresLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// isOperatorMap reports whether every key of a field value is a MongoDB operator
+func isOperatorMap(m map[string]interface{}) bool {
+	if len(m) == 0 {
+		return false
+	}
+	for key := range m {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// toConditionList normalizes $or/$and arrays into a list of query objects
+func toConditionList(value interface{}) ([]map[string]interface{}, error) {
+	switch v := value.(type) {
+	case []map[string]interface{}:
+		return v, nil
+	case []interface{}:
+		conditions := make([]map[string]interface{}, 0, len(v))
+		for _, item := range v {
+			if condMap, ok := item.(map[string]interface{}); ok {
+				conditions = append(conditions, condMap)
+			}
+		}
+		return conditions, nil
+	default:
+		return nil, fmt.Errorf("must be an array")
+	}
+}
+
+// toInterfaceSlice converts typed slices into []interface{}
+func toInterfaceSlice(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case []string:
+		result := make([]interface{}, len(v))
+		for i, s := range v {
+			result[i] = s
+		}
+		return result, true
+	case []int:
+		result := make([]interface{}, len(v))
+		for i, n := range v {
+			result[i] = n
+		}
+		return result, true
+	case []float64:
+		result := make([]interface{}, len(v))
+		for i, n := range v {
+			result[i] = n
+		}
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
+// toFloat converts numeric values to float64
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// toInt64 converts numeric option values (limit, skip) to int64
+func toInt64(value interface{}) (int64, bool) {
+	if f, ok := toFloat(value); ok {
+		return int64(f), true
+	}
+	return 0, false
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// createTestPostgresDB connects to the PostgreSQL server described by the
+// POSTGRES_HOST, POSTGRES_PORT, POSTGRES_USER, POSTGRES_PASS and POSTGRES_DB
+// environment variables. Tests are skipped when POSTGRES_HOST is not set.
+func createTestPostgresDB(t *testing.T) DatabaseInterface {
+	host := os.Getenv("POSTGRES_HOST")
+	if host == "" {
+		t.Skip("POSTGRES_HOST not set, skipping PostgreSQL store tests")
+	}
+
+	port := 5432
+	if envPort := os.Getenv("POSTGRES_PORT"); envPort != "" {
+		if p, err := strconv.Atoi(envPort); err == nil {
+			port = p
+		}
+	}
+
+	name := os.Getenv("POSTGRES_DB")
+	if name == "" {
+		name = "deployd_test"
+	}
+
+	db, err := NewDatabase(DatabaseTypePostgres, &Config{
+		Host:     host,
+		Port:     port,
+		Name:     name,
+		Username: os.Getenv("POSTGRES_USER"),
+		Password: os.Getenv("POSTGRES_PASS"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+
+	// Start every test from an empty database
+	if err := db.Drop(); err != nil {
+		t.Fatalf("Failed to reset test database: %v", err)
+	}
+
+	return db
+}
+
+func TestPostgresStore_CreateStore(t *testing.T) {
+	testStoreCreateStore(t, createTestPostgresDB)
+}
+
+func TestPostgresStore_Insert(t *testing.T) {
+	testStoreInsert(t, createTestPostgresDB)
+}
+
+func TestPostgresStore_Count(t *testing.T) {
+	testStoreCount(t, createTestPostgresDB)
+}
+
+func TestPostgresStore_ComprehensiveOperations(t *testing.T) {
+	testStoreComprehensiveOperations(t, createTestPostgresDB)
+}
+
+func TestPostgresFactoryRegistered(t *testing.T) {
+	if _, exists := databaseFactories[DatabaseTypePostgres]; !exists {
+		t.Fatal("Expected a factory to be registered for postgres")
+	}
+}
+
+func TestPostgresWhereBuilder(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    map[string]interface{}
+		columns  []string
+		expected string
+		args     []interface{}
+	}{
+		{
+			name:     "Simple equality on JSON field",
+			query:    map[string]interface{}{"name": "John"},
+			expected: `data->'name' = $1::jsonb`,
+			args:     []interface{}{`"John"`},
+		},
+		{
+			name:     "Numeric comparison keeps JSON types",
+			query:    map[string]interface{}{"age": map[string]interface{}{"$gte": 18, "$lt": 65}},
+			expected: `(data->'age' >= $1::jsonb AND data->'age' < $2::jsonb)`,
+			args:     []interface{}{"18", "65"},
+		},
+		{
+			name:     "Column field is compared directly",
+			query:    map[string]interface{}{"age": map[string]interface{}{"$gt": 21}},
+			columns:  []string{"age"},
+			expected: `"age" > $1`,
+			args:     []interface{}{21},
+		},
+		{
+			name:     "Nested field uses path accessor",
+			query:    map[string]interface{}{"address.city": "Vienna"},
+			expected: `data#>'{address,city}' = $1::jsonb`,
+			args:     []interface{}{`"Vienna"`},
+		},
+		{
+			name: "$or of conditions",
+			query: map[string]interface{}{
+				"$or": []interface{}{
+					map[string]interface{}{"role": "admin"},
+					map[string]interface{}{"role": "owner"},
+				},
+			},
+			expected: `((data->'role' = $1::jsonb) OR (data->'role' = $2::jsonb))`,
+			args:     []interface{}{`"admin"`, `"owner"`},
+		},
+		{
+			name:     "$in list",
+			query:    map[string]interface{}{"status": map[string]interface{}{"$in": []interface{}{"a", "b"}}},
+			expected: `data->'status' IN ($1::jsonb, $2::jsonb)`,
+			args:     []interface{}{`"a"`, `"b"`},
+		},
+		{
+			name:     "Case-insensitive regex",
+			query:    map[string]interface{}{"title": map[string]interface{}{"$regex": "^hello", "$options": "i"}},
+			expected: `data->>'title' ~* $1`,
+			args:     []interface{}{"^hello"},
+		},
+		{
+			name:     "$exists false",
+			query:    map[string]interface{}{"deletedAt": map[string]interface{}{"$exists": false}},
+			expected: `data->'deletedAt' IS NULL`,
+			args:     []interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var checker func(string) bool
+			if len(tt.columns) > 0 {
+				checker = func(field string) bool {
+					for _, col := range tt.columns {
+						if col == field {
+							return true
+						}
+					}
+					return false
+				}
+			}
+
+			whereClause, args, err := newPostgresWhereBuilder(checker).Build(tt.query)
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			if whereClause != tt.expected {
+				t.Errorf("Build() whereClause = %v, want %v", whereClause, tt.expected)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("Build() args = %v, want %v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestRebindPostgres(t *testing.T) {
+	got := rebindPostgres(`UPDATE "t" SET "a" = ?, data = ? WHERE "id" = ? AND note = 'why?'`)
+	want := `UPDATE "t" SET "a" = $1, data = $2 WHERE "id" = $3 AND note = 'why?'`
+	if got != want {
+		t.Errorf("rebindPostgres() = %v, want %v", got, want)
+	}
+}
+
+func TestPostgresStore_ConcurrentClaims(t *testing.T) {
+	db := createTestPostgresDB(t)
+	defer cleanupTestDB(db)
+	testConcurrentClaims(t, db.CreateStore("claims"))
+}
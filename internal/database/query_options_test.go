@@ -0,0 +1,17 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryOptions_SortFields(t *testing.T) {
+	opts := QueryOptions{Sort: map[string]int{"title": 1, "id": 1, "rank": -1, "age": 1}}
+	assert.Equal(t, []string{"age", "id", "rank", "title"}, opts.SortFields(), "unlisted fields sort by name")
+
+	opts.SortOrder = []string{"rank", "missing", "id"}
+	assert.Equal(t, []string{"rank", "id", "age", "title"}, opts.SortFields())
+
+	assert.Empty(t, QueryOptions{}.SortFields())
+}
@@ -272,6 +272,9 @@ func (sm *SchemaManager) tableExists(tableName string) (bool, error) {
 	case DatabaseTypeMySQL:
 		query = "SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?"
 		args = []interface{}{tableName}
+	case DatabaseTypePostgres:
+		query = "SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1"
+		args = []interface{}{tableName}
 	default:
 		return false, fmt.Errorf("unsupported database type: %v", sm.dbType)
 	}
@@ -312,7 +315,7 @@ func (sm *SchemaManager) createTable(schema *CollectionSchema) error {
 	// Create indexes
 	for _, column := range schema.Columns {
 		if column.Index && !column.IsPrimary {
-			if err := sm.createIndex(schema.Name, column.Name); err != nil {
+			if err := sm.createIndex(schema.Name, column.Name, false); err != nil {
 				// Log warning but don't fail
 				fmt.Printf("Warning: failed to create index for %s.%s: %v\n", schema.Name, column.Name, err)
 			}
@@ -347,7 +350,9 @@ func (sm *SchemaManager) buildColumnDefinition(column ColumnDefinition) string {
 				def.WriteString(fmt.Sprintf(" DEFAULT '%s'", v))
 			}
 		case bool:
-			if v {
+			if sm.dbType == DatabaseTypePostgres {
+				def.WriteString(fmt.Sprintf(" DEFAULT %t", v))
+			} else if v {
 				def.WriteString(" DEFAULT 1")
 			} else {
 				def.WriteString(" DEFAULT 0")
@@ -382,6 +387,23 @@ func (sm *SchemaManager) getColumnTypeSQL(colType ColumnType) string {
 		default:
 			return "VARCHAR(255)"
 		}
+	case DatabaseTypePostgres:
+		switch colType {
+		case ColumnTypeText:
+			return "TEXT"
+		case ColumnTypeInteger:
+			return "BIGINT"
+		case ColumnTypeReal:
+			return "DOUBLE PRECISION"
+		case ColumnTypeBoolean:
+			return "BOOLEAN"
+		case ColumnTypeDate:
+			return "TIMESTAMPTZ"
+		case ColumnTypeJSON:
+			return "JSONB"
+		default:
+			return "TEXT"
+		}
 	case DatabaseTypeSQLite:
 		// SQLite is flexible with types, use the generic names
 		return string(colType)
@@ -397,27 +419,56 @@ func (sm *SchemaManager) quoteIdentifier(name string) string {
 		return fmt.Sprintf(`"%s"`, name)
 	case DatabaseTypeMySQL:
 		return fmt.Sprintf("`%s`", name)
+	case DatabaseTypePostgres:
+		return quotePostgresIdentifier(name)
 	default:
 		return name
 	}
 }
 
-// createIndex creates an index for a column
-func (sm *SchemaManager) createIndex(tableName, columnName string) error {
-	indexName := fmt.Sprintf("idx_%s_%s", tableName, columnName)
+// indexName names the index on a column; unique indexes use the name that
+// IsDuplicateKey recognizes
+func (sm *SchemaManager) indexName(tableName, columnName string, unique bool) string {
+	if unique {
+		return uniqueIndexName(tableName, columnName)
+	}
+	return fmt.Sprintf("idx_%s_%s", tableName, columnName)
+}
+
+// createIndex creates an index for a column. Unique indexes are created only
+// if they don't exist yet, so they can be ensured on every startup.
+func (sm *SchemaManager) createIndex(tableName, columnName string, unique bool) error {
+	indexName := sm.indexName(tableName, columnName, unique)
 	quotedTable := sm.quoteIdentifier(tableName)
 	quotedColumn := sm.quoteIdentifier(columnName)
 	quotedIndex := sm.quoteIdentifier(indexName)
 
 	query := fmt.Sprintf("CREATE INDEX %s ON %s (%s)", quotedIndex, quotedTable, quotedColumn)
+	if unique {
+		switch sm.dbType {
+		case DatabaseTypeMySQL:
+			// MySQL has no CREATE INDEX IF NOT EXISTS
+			var count int
+			if err := sm.db.QueryRow("SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?",
+				tableName, indexName).Scan(&count); err != nil {
+				return err
+			}
+			if count > 0 {
+				return nil
+			}
+			query = fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (%s)", quotedIndex, quotedTable, quotedColumn)
+		default:
+			query = fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s)", quotedIndex, quotedTable, quotedColumn)
+		}
+	}
 
 	_, err := sm.db.Exec(query)
 	return err
 }
 
 // dropIndex drops an index for a column
-func (sm *SchemaManager) dropIndex(tableName, columnName string) error {
-	indexName := fmt.Sprintf("idx_%s_%s", tableName, columnName)
+func (sm *SchemaManager) dropIndex(tableName, columnName string, unique bool) error {
+	indexName := sm.indexName(tableName, columnName, unique)
 	quotedIndex := sm.quoteIdentifier(indexName)
 
 	var query string
@@ -534,6 +585,41 @@ func (sm *SchemaManager) getTableColumns(tableName string) ([]ColumnDefinition,
 				column.Default = *columnDefault
 			}
 
+			columns = append(columns, column)
+		}
+
+	case DatabaseTypePostgres:
+		query := `
+			SELECT column_name, data_type, is_nullable, column_default
+			FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = $1
+			ORDER BY ordinal_position
+		`
+		rows, err := sm.db.Query(query, tableName)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var name, dataType, isNullable string
+			var columnDefault *string
+
+			if err := rows.Scan(&name, &dataType, &isNullable, &columnDefault); err != nil {
+				return nil, err
+			}
+
+			column := ColumnDefinition{
+				Name:      name,
+				Type:      sm.mapPostgresTypeToColumn(dataType),
+				Required:  isNullable == "NO",
+				IsPrimary: name == "id",
+			}
+
+			if columnDefault != nil {
+				column.Default = *columnDefault
+			}
+
 			columns = append(columns, column)
 		}
 	}
@@ -541,6 +627,26 @@ func (sm *SchemaManager) getTableColumns(tableName string) ([]ColumnDefinition,
 	return columns, nil
 }
 
+// mapPostgresTypeToColumn maps PostgreSQL data types to our column types
+func (sm *SchemaManager) mapPostgresTypeToColumn(pgType string) ColumnType {
+	switch strings.ToLower(pgType) {
+	case "text", "character varying", "character":
+		return ColumnTypeText
+	case "bigint", "integer", "smallint":
+		return ColumnTypeInteger
+	case "double precision", "real", "numeric":
+		return ColumnTypeReal
+	case "boolean":
+		return ColumnTypeBoolean
+	case "timestamp with time zone", "timestamp without time zone", "date":
+		return ColumnTypeDate
+	case "jsonb", "json":
+		return ColumnTypeJSON
+	default:
+		return ColumnTypeText
+	}
+}
+
 // mapMySQLTypeToColumn maps MySQL data types to our column types
 func (sm *SchemaManager) mapMySQLTypeToColumn(mysqlType string) ColumnType {
 	switch strings.ToLower(mysqlType) {
@@ -674,7 +780,7 @@ func (sm *SchemaManager) executeMigration(tableName string, migration Migration)
 
 		// Create index if requested
 		if migration.Column.Index && !migration.Column.IsPrimary {
-			if err := sm.createIndex(tableName, migration.Column.Name); err != nil {
+			if err := sm.createIndex(tableName, migration.Column.Name, false); err != nil {
 				// Log warning but don't fail the migration
 				fmt.Printf("Warning: failed to create index for %s.%s: %v\n", tableName, migration.Column.Name, err)
 			}
@@ -694,23 +800,28 @@ func (sm *SchemaManager) executeMigration(tableName string, migration Migration)
 		if sm.dbType == DatabaseTypeSQLite {
 			return fmt.Errorf("MODIFY COLUMN not supported for SQLite (would require table recreation)")
 		}
-		// MySQL
-		query := fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s", quotedTable, sm.buildColumnDefinition(migration.Column))
-		_, err := sm.db.Exec(query)
-		if err != nil {
-			return err
+		if sm.dbType == DatabaseTypePostgres {
+			if err := sm.alterPostgresColumn(tableName, migration); err != nil {
+				return err
+			}
+		} else {
+			// MySQL
+			query := fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s", quotedTable, sm.buildColumnDefinition(migration.Column))
+			if _, err := sm.db.Exec(query); err != nil {
+				return err
+			}
 		}
 
 		// Handle index changes for modified columns
 		if migration.Column.Index && !migration.Column.IsPrimary {
 			// Check if index exists, if not create it
-			if err := sm.createIndex(tableName, migration.Column.Name); err != nil {
+			if err := sm.createIndex(tableName, migration.Column.Name, false); err != nil {
 				// Index might already exist, that's ok
 				fmt.Printf("Info: index creation for %s.%s: %v\n", tableName, migration.Column.Name, err)
 			}
 		} else if migration.OldColumn != nil && migration.OldColumn.Index && !migration.Column.Index {
 			// Remove index if it was removed from the schema
-			if err := sm.dropIndex(tableName, migration.Column.Name); err != nil {
+			if err := sm.dropIndex(tableName, migration.Column.Name, false); err != nil {
 				fmt.Printf("Warning: failed to drop index for %s.%s: %v\n", tableName, migration.Column.Name, err)
 			}
 		}
@@ -720,3 +831,30 @@ func (sm *SchemaManager) executeMigration(tableName string, migration Migration)
 		return fmt.Errorf("unknown migration type: %s", migration.Type)
 	}
 }
+
+// alterPostgresColumn applies a column modification on PostgreSQL, which has no
+// MODIFY COLUMN and instead alters type, nullability and default separately
+func (sm *SchemaManager) alterPostgresColumn(tableName string, migration Migration) error {
+	quotedTable := sm.quoteIdentifier(tableName)
+	quotedColumn := sm.quoteIdentifier(migration.Column.Name)
+	columnType := sm.getColumnTypeSQL(migration.Column.Type)
+
+	statements := []string{
+		fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s",
+			quotedTable, quotedColumn, columnType, quotedColumn, columnType),
+	}
+
+	if migration.Column.Required && !migration.Column.IsPrimary {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", quotedTable, quotedColumn))
+	} else if !migration.Column.IsPrimary {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL", quotedTable, quotedColumn))
+	}
+
+	for _, statement := range statements {
+		if _, err := sm.db.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -9,7 +9,11 @@ import (
 )
 
 func TestSQLiteStore_CreateStore(t *testing.T) {
-	db := createTestSQLiteDB(t)
+	testStoreCreateStore(t, createTestSQLiteDB)
+}
+
+func testStoreCreateStore(t *testing.T, newDB func(t *testing.T) DatabaseInterface) {
+	db := newDB(t)
 	defer cleanupTestDB(db)
 
 	store := db.CreateStore("test_collection")
@@ -29,7 +33,11 @@ func TestSQLiteStore_CreateStore(t *testing.T) {
 }
 
 func TestSQLiteStore_Insert(t *testing.T) {
-	db := createTestSQLiteDB(t)
+	testStoreInsert(t, createTestSQLiteDB)
+}
+
+func testStoreInsert(t *testing.T, newDB func(t *testing.T) DatabaseInterface) {
+	db := newDB(t)
 	defer cleanupTestDB(db)
 
 	store := db.CreateStore("test_collection")
@@ -55,7 +63,11 @@ func TestSQLiteStore_Insert(t *testing.T) {
 }
 
 func TestSQLiteStore_Count(t *testing.T) {
-	db := createTestSQLiteDB(t)
+	testStoreCount(t, createTestSQLiteDB)
+}
+
+func testStoreCount(t *testing.T, newDB func(t *testing.T) DatabaseInterface) {
+	db := newDB(t)
 	defer cleanupTestDB(db)
 
 	// Use a unique collection name for this test
@@ -191,8 +203,12 @@ func (u *SimpleUpdateBuilder) ToMap() map[string]interface{} {
 }
 
 func TestSQLiteStore_ComprehensiveOperations(t *testing.T) {
+	testStoreComprehensiveOperations(t, createTestSQLiteDB)
+}
+
+func testStoreComprehensiveOperations(t *testing.T, newDB func(t *testing.T) DatabaseInterface) {
 	t.Run("Find operations", func(t *testing.T) {
-		db := createTestSQLiteDB(t)
+		db := newDB(t)
 		defer cleanupTestDB(db)
 
 		store := db.CreateStore("find_test")
@@ -227,7 +243,7 @@ func TestSQLiteStore_ComprehensiveOperations(t *testing.T) {
 	})
 
 	t.Run("FindOne operations", func(t *testing.T) {
-		db := createTestSQLiteDB(t)
+		db := newDB(t)
 		defer cleanupTestDB(db)
 
 		store := db.CreateStore("findone_test")
@@ -256,7 +272,7 @@ func TestSQLiteStore_ComprehensiveOperations(t *testing.T) {
 	})
 
 	t.Run("Update operations", func(t *testing.T) {
-		db := createTestSQLiteDB(t)
+		db := newDB(t)
 		defer cleanupTestDB(db)
 
 		store := db.CreateStore("update_test")
@@ -288,7 +304,7 @@ func TestSQLiteStore_ComprehensiveOperations(t *testing.T) {
 	})
 
 	t.Run("Remove operations", func(t *testing.T) {
-		db := createTestSQLiteDB(t)
+		db := newDB(t)
 		defer cleanupTestDB(db)
 
 		store := db.CreateStore("remove_test")
@@ -317,7 +333,7 @@ func TestSQLiteStore_ComprehensiveOperations(t *testing.T) {
 	})
 
 	t.Run("Database info operations", func(t *testing.T) {
-		db := createTestSQLiteDB(t)
+		db := newDB(t)
 		defer cleanupTestDB(db)
 
 		_ = db.CreateStore("info_test")
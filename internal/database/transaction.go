@@ -0,0 +1,237 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrNoTransaction is returned by Commit and Rollback when the context holds
+// no transaction of the database
+var ErrNoTransaction = errors.New("no transaction in context")
+
+// Transactional is implemented by databases that can group writes to several
+// stores into one transaction. Begin returns a context carrying the
+// transaction; store operations given that context (or a context derived
+// from it) run inside the transaction until it is committed or rolled back.
+//
+// Calling Begin with a context that already carries a transaction of the same
+// database joins it: SQL databases open a savepoint, so Rollback only undoes
+// the writes made since the nested Begin, and Commit keeps them for the outer
+// transaction to commit. Rollback after Commit is a no-op, so it can always be
+// deferred.
+type Transactional interface {
+	DatabaseInterface
+	Begin(ctx context.Context) (context.Context, error)
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+type txKey struct{}
+
+// transaction is the state of an open transaction, shared by all frames
+// joined to it
+type transaction struct {
+	owner   DatabaseInterface
+	sqlDB   *sql.DB
+	sqlTx   *sql.Tx
+	session mongo.Session // Nil for MongoDB deployments without transactions
+	hooks   []func()
+	depth   int
+	done    bool
+}
+
+// txFrame is one Begin call on a transaction
+type txFrame struct {
+	tx        *transaction
+	joined    bool
+	savepoint string // The SQL savepoint of a joined frame
+	hooks     int    // Callbacks registered before the frame began
+	done      bool
+}
+
+func currentFrame(ctx context.Context) *txFrame {
+	if ctx == nil {
+		return nil
+	}
+	frame, _ := ctx.Value(txKey{}).(*txFrame)
+	return frame
+}
+
+// AfterCommit runs fn once the transaction carried by ctx has committed, or
+// right away if ctx carries no open transaction. Callbacks registered in a
+// transaction (or savepoint) that is rolled back are dropped.
+func AfterCommit(ctx context.Context, fn func()) {
+	if frame := currentFrame(ctx); frame != nil && !frame.tx.done {
+		frame.tx.hooks = append(frame.tx.hooks, fn)
+		return
+	}
+	fn()
+}
+
+// InTransaction reports whether ctx carries an open transaction
+func InTransaction(ctx context.Context) bool {
+	frame := currentFrame(ctx)
+	return frame != nil && !frame.tx.done
+}
+
+// sqlExecutor is the part of *sql.DB and *sql.Tx used by the SQL stores
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// sqlConn returns the transaction ctx carries on db, or db itself
+func sqlConn(ctx context.Context, db *sql.DB) sqlExecutor {
+	if frame := currentFrame(ctx); frame != nil && !frame.tx.done && frame.tx.sqlDB == db {
+		return frame.tx.sqlTx
+	}
+	return db
+}
+
+// joinTx returns a context with a frame joined to the open transaction of
+// owner in ctx, or nil if there is none
+func joinTx(ctx context.Context, owner DatabaseInterface) (context.Context, error) {
+	outer := currentFrame(ctx)
+	if outer == nil || outer.tx.done || outer.tx.owner != owner {
+		return nil, nil
+	}
+
+	tx := outer.tx
+	tx.depth++
+	frame := &txFrame{tx: tx, joined: true, hooks: len(tx.hooks)}
+	if tx.sqlTx != nil {
+		frame.savepoint = fmt.Sprintf("deployd_sp_%d", tx.depth)
+		if _, err := tx.sqlTx.ExecContext(ctx, "SAVEPOINT "+frame.savepoint); err != nil {
+			return nil, fmt.Errorf("failed to create savepoint: %w", err)
+		}
+	}
+	return context.WithValue(ctx, txKey{}, frame), nil
+}
+
+// beginSQLTx implements Transactional.Begin for the SQL databases
+func beginSQLTx(ctx context.Context, owner DatabaseInterface, db *sql.DB) (context.Context, error) {
+	joined, err := joinTx(ctx, owner)
+	if err != nil || joined != nil {
+		return joined, err
+	}
+
+	sqlTx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	frame := &txFrame{tx: &transaction{owner: owner, sqlDB: db, sqlTx: sqlTx}}
+	return context.WithValue(ctx, txKey{}, frame), nil
+}
+
+// ownFrame returns the frame of owner's transaction in ctx
+func ownFrame(ctx context.Context, owner DatabaseInterface) (*txFrame, error) {
+	frame := currentFrame(ctx)
+	if frame == nil || frame.tx.owner != owner {
+		return nil, ErrNoTransaction
+	}
+	return frame, nil
+}
+
+// commitTx implements Transactional.Commit. Committing the outermost frame
+// commits the transaction and then runs the AfterCommit callbacks.
+func commitTx(ctx context.Context, owner DatabaseInterface) error {
+	frame, err := ownFrame(ctx, owner)
+	if err != nil {
+		return err
+	}
+	if frame.done {
+		return fmt.Errorf("transaction already finished")
+	}
+	frame.done = true
+
+	tx := frame.tx
+	if frame.joined {
+		if frame.savepoint == "" {
+			return nil
+		}
+		if _, err := tx.sqlTx.ExecContext(ctx, "RELEASE SAVEPOINT "+frame.savepoint); err != nil {
+			return fmt.Errorf("failed to release savepoint: %w", err)
+		}
+		return nil
+	}
+
+	tx.done = true
+	switch {
+	case tx.sqlTx != nil:
+		err = tx.sqlTx.Commit()
+	case tx.session != nil:
+		err = tx.session.CommitTransaction(ctx)
+		// The session stays usable for the callbacks, outside a transaction
+		defer tx.session.EndSession(context.Background())
+	}
+	hooks := tx.hooks
+	tx.hooks = nil
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	for _, hook := range hooks {
+		hook()
+	}
+	return nil
+}
+
+// rollbackTx implements Transactional.Rollback
+func rollbackTx(ctx context.Context, owner DatabaseInterface) error {
+	frame, err := ownFrame(ctx, owner)
+	if err != nil {
+		return err
+	}
+	if frame.done {
+		return nil
+	}
+	frame.done = true
+
+	tx := frame.tx
+	if frame.joined {
+		if frame.hooks < len(tx.hooks) {
+			tx.hooks = tx.hooks[:frame.hooks]
+		}
+		if frame.savepoint == "" {
+			return nil
+		}
+		if _, err := tx.sqlTx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+frame.savepoint); err != nil {
+			return fmt.Errorf("failed to roll back to savepoint: %w", err)
+		}
+		return nil
+	}
+
+	tx.done = true
+	tx.hooks = nil
+	switch {
+	case tx.sqlTx != nil:
+		err = tx.sqlTx.Rollback()
+	case tx.session != nil:
+		err = tx.session.AbortTransaction(ctx)
+		tx.session.EndSession(context.Background())
+	}
+	if err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return fmt.Errorf("failed to roll back transaction: %w", err)
+	}
+	return nil
+}
+
+// inTx runs fn in a transaction of db, or in a savepoint of the transaction
+// ctx carries, so its writes take effect together or not at all
+func inTx(ctx context.Context, db Transactional, fn func(ctx context.Context) error) error {
+	txCtx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Rollback(txCtx)
+
+	if err := fn(txCtx); err != nil {
+		return err
+	}
+	return db.Commit(txCtx)
+}
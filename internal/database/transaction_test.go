@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteDatabase_Transactions(t *testing.T) {
+	testTransactions(t, func(t *testing.T) DatabaseInterface {
+		// Transactions hold a connection of their own, so an in-memory
+		// database would not be shared with the reads outside of them
+		db, err := NewDatabase(DatabaseTypeSQLite, &Config{Name: filepath.Join(t.TempDir(), "tx.db")})
+		require.NoError(t, err)
+		return db
+	})
+}
+
+func TestPostgresDatabase_Transactions(t *testing.T) {
+	testTransactions(t, createTestPostgresDB)
+}
+
+func testTransactions(t *testing.T, newDB func(t *testing.T) DatabaseInterface) {
+	db := newDB(t)
+	defer cleanupTestDB(db)
+	require.NoError(t, db.Drop())
+
+	tdb, ok := db.(Transactional)
+	require.True(t, ok, "database %T does not implement Transactional", db)
+	orders := db.CreateStore("tx_orders")
+	audit := db.CreateStore("tx_audit")
+	ctx := context.Background()
+
+	count := func(store StoreInterface) int64 {
+		n, err := store.Count(ctx, NewQueryBuilder())
+		require.NoError(t, err)
+		return n
+	}
+
+	t.Run("commits writes to several stores together", func(t *testing.T) {
+		txCtx, err := tdb.Begin(ctx)
+		require.NoError(t, err)
+		assert.True(t, InTransaction(txCtx))
+
+		_, err = orders.Insert(txCtx, map[string]interface{}{"item": "book"})
+		require.NoError(t, err)
+		_, err = audit.Insert(txCtx, map[string]interface{}{"action": "order"})
+		require.NoError(t, err)
+
+		committed := false
+		AfterCommit(txCtx, func() {
+			committed = true
+			assert.Equal(t, int64(1), count(orders), "callbacks see the committed writes")
+		})
+
+		inTx, err := orders.Count(txCtx, NewQueryBuilder())
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), inTx, "the transaction sees its own writes")
+		assert.Equal(t, int64(0), count(orders), "uncommitted writes are not visible outside")
+		assert.False(t, committed)
+
+		require.NoError(t, tdb.Commit(txCtx))
+		assert.True(t, committed)
+		assert.False(t, InTransaction(txCtx))
+		assert.Equal(t, int64(1), count(audit))
+		assert.NoError(t, tdb.Rollback(txCtx), "rolling back after a commit is a no-op")
+	})
+
+	t.Run("rolls back every write and drops callbacks", func(t *testing.T) {
+		txCtx, err := tdb.Begin(ctx)
+		require.NoError(t, err)
+		_, err = orders.Insert(txCtx, map[string]interface{}{"item": "pen"})
+		require.NoError(t, err)
+		_, err = audit.Remove(txCtx, NewQueryBuilder())
+		require.NoError(t, err)
+		AfterCommit(txCtx, func() { t.Error("callback of a rolled back transaction ran") })
+
+		require.NoError(t, tdb.Rollback(txCtx))
+		assert.Equal(t, int64(1), count(orders))
+		assert.Equal(t, int64(1), count(audit))
+	})
+
+	t.Run("nested transactions are savepoints", func(t *testing.T) {
+		txCtx, err := tdb.Begin(ctx)
+		require.NoError(t, err)
+		_, err = orders.Insert(txCtx, map[string]interface{}{"item": "outer"})
+		require.NoError(t, err)
+
+		kept, err := tdb.Begin(txCtx)
+		require.NoError(t, err)
+		_, err = orders.Insert(kept, map[string]interface{}{"item": "kept"})
+		require.NoError(t, err)
+		keptRan := false
+		AfterCommit(kept, func() { keptRan = true })
+		require.NoError(t, tdb.Commit(kept))
+		assert.False(t, keptRan, "callbacks wait for the outermost commit")
+
+		undone, err := tdb.Begin(txCtx)
+		require.NoError(t, err)
+		_, err = orders.Insert(undone, map[string]interface{}{"item": "undone"})
+		require.NoError(t, err)
+		AfterCommit(undone, func() { t.Error("callback of a rolled back savepoint ran") })
+		require.NoError(t, tdb.Rollback(undone))
+
+		require.NoError(t, tdb.Commit(txCtx))
+		assert.True(t, keptRan)
+		for item, want := range map[string]int64{"outer": 1, "kept": 1, "undone": 0} {
+			n, err := orders.Count(ctx, NewQueryBuilder().Where("item", "$eq", item))
+			require.NoError(t, err)
+			assert.Equal(t, want, n, item)
+		}
+	})
+
+	t.Run("runs callbacks right away outside transactions", func(t *testing.T) {
+		ran := false
+		AfterCommit(ctx, func() { ran = true })
+		assert.True(t, ran)
+		assert.ErrorIs(t, tdb.Commit(ctx), ErrNoTransaction)
+	})
+}
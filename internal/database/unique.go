@@ -0,0 +1,206 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UniqueIndexer is implemented by stores that can enforce unique fields with
+// a database-level unique index. Documents without the field, or with a null
+// value, never conflict.
+type UniqueIndexer interface {
+	// EnsureUniqueIndex creates the unique index for field if it does not
+	// exist yet. It fails when the stored documents already hold duplicates.
+	EnsureUniqueIndex(ctx context.Context, field string) error
+	// DropUniqueIndex removes the unique index for field if it exists
+	DropUniqueIndex(ctx context.Context, field string) error
+}
+
+// DuplicateKeyError is returned by IsDuplicateKey when a write violates a
+// unique index
+type DuplicateKeyError struct {
+	Field string // The unique field, empty when it could not be determined
+	Err   error
+}
+
+func (e *DuplicateKeyError) Error() string {
+	if e.Field == "" {
+		return "duplicate value for a unique field"
+	}
+	return fmt.Sprintf("duplicate value for unique field %s", e.Field)
+}
+
+func (e *DuplicateKeyError) Unwrap() error {
+	return e.Err
+}
+
+// uniqueIndexName names the index that enforces a unique field
+func uniqueIndexName(table, field string) string {
+	return "uniq_" + table + "_" + field
+}
+
+// IsDuplicateKey reports whether err is a unique index violation from any
+// backend and, if so, which field of the namespace's collection caused it
+func IsDuplicateKey(err error, namespace string) (*DuplicateKeyError, bool) {
+	if err == nil || !isDuplicateKeyError(err) {
+		return nil, false
+	}
+
+	message := err.Error()
+	patterns := []string{
+		// Our unique indexes, as named by every backend's error message
+		regexp.QuoteMeta(uniqueIndexName(namespace, "")) + `([A-Za-z0-9_]+)`,
+		// SQLite names the columns of a plain column index instead
+		`constraint failed: ` + regexp.QuoteMeta(namespace) + `\.([A-Za-z0-9_]+)`,
+	}
+	for _, pattern := range patterns {
+		if match := regexp.MustCompile(pattern).FindStringSubmatch(message); match != nil {
+			return &DuplicateKeyError{Field: match[1], Err: err}, true
+		}
+	}
+	return &DuplicateKeyError{Err: err}, true
+}
+
+func isDuplicateKeyError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505" // unique_violation
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062 // ER_DUP_ENTRY
+	}
+	return mongo.IsDuplicateKeyError(err)
+}
+
+// SQLite JSON store: index the extracted value directly, which SQLite
+// supports without a generated column
+
+func (s *SQLiteStore) EnsureUniqueIndex(ctx context.Context, field string) error {
+	query := fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS "%s" ON %s (json_extract(data, '%s'))`,
+		uniqueIndexName(s.tableName, field), s.quotedTableName(), strings.ReplaceAll(pipelineJSONPath(field), "'", "''"))
+	_, err := s.db.ExecContext(ctx, query)
+	return err
+}
+
+func (s *SQLiteStore) DropUniqueIndex(ctx context.Context, field string) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`DROP INDEX IF EXISTS "%s"`, uniqueIndexName(s.tableName, field)))
+	return err
+}
+
+// PostgreSQL JSON store: an expression index on the text value; ->> yields
+// NULL for missing fields and JSON null alike
+
+func (s *PostgresStore) EnsureUniqueIndex(ctx context.Context, field string) error {
+	query := fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s ((%s))",
+		quotePostgresIdentifier(uniqueIndexName(s.tableName, field)), s.quotedTableName(), postgresJSONPath(field, true))
+	_, err := s.db.ExecContext(ctx, query)
+	return err
+}
+
+func (s *PostgresStore) DropUniqueIndex(ctx context.Context, field string) error {
+	query := fmt.Sprintf("DROP INDEX IF EXISTS %s", quotePostgresIdentifier(uniqueIndexName(s.tableName, field)))
+	_, err := s.db.ExecContext(ctx, query)
+	return err
+}
+
+// MySQL JSON store: MySQL cannot index JSON values directly, so the value is
+// exposed through a virtual generated column that carries the unique index.
+// The column holds the SHA-256 of the value, which compares bytes rather than
+// following the table's case-insensitive collation and fits values of any
+// length into the index.
+
+// uniqueColumnName names the generated column holding a unique field's value
+func uniqueColumnName(field string) string {
+	return "_unique_" + field
+}
+
+func (s *MySQLStore) EnsureUniqueIndex(ctx context.Context, field string) error {
+	exists, err := s.hasIndex(ctx, uniqueIndexName(s.tableName, field))
+	if err != nil || exists {
+		return err
+	}
+
+	path := strings.ReplaceAll(pipelineJSONPath(field), "'", "''")
+	query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN `%s` BINARY(32) GENERATED ALWAYS AS (UNHEX(SHA2(NULLIF(JSON_UNQUOTE(JSON_EXTRACT(data, '%s')), 'null'), 256))) VIRTUAL, ADD UNIQUE INDEX `%s` (`%s`)",
+		s.quotedTableName(), uniqueColumnName(field), path, uniqueIndexName(s.tableName, field), uniqueColumnName(field))
+	_, err = s.db.ExecContext(ctx, query)
+	return err
+}
+
+func (s *MySQLStore) DropUniqueIndex(ctx context.Context, field string) error {
+	exists, err := s.hasIndex(ctx, uniqueIndexName(s.tableName, field))
+	if err != nil || !exists {
+		return err
+	}
+
+	query := fmt.Sprintf("ALTER TABLE %s DROP INDEX `%s`, DROP COLUMN `%s`",
+		s.quotedTableName(), uniqueIndexName(s.tableName, field), uniqueColumnName(field))
+	_, err = s.db.ExecContext(ctx, query)
+	return err
+}
+
+// hasIndex reports whether the table has an index with the given name
+func (s *MySQLStore) hasIndex(ctx context.Context, name string) (bool, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?",
+		s.tableName, name).Scan(&count)
+	return count > 0, err
+}
+
+// Column store: unique fields are real columns with a unique index
+
+func (s *ColumnStore) EnsureUniqueIndex(ctx context.Context, field string) error {
+	if !s.hasColumn(field) {
+		return fmt.Errorf("unique field %s is not a column of %s", field, s.tableName)
+	}
+	return s.schemaManager.createIndex(s.tableName, field, true)
+}
+
+func (s *ColumnStore) DropUniqueIndex(ctx context.Context, field string) error {
+	if !s.hasColumn(field) {
+		return nil
+	}
+	return s.schemaManager.dropIndex(s.tableName, field, true)
+}
+
+// MongoDB: a partial unique index over the documents whose field holds a
+// value other than null. A sparse index would still index explicit nulls,
+// and partial filters can't use $ne, so the value types are listed instead.
+
+// uniqueValueTypes are the BSON types a partial unique index covers
+var uniqueValueTypes = bson.A{"string", "number", "bool", "date", "objectId", "object", "array", "binData", "timestamp"}
+
+func (s *MongoStore) EnsureUniqueIndex(ctx context.Context, field string) error {
+	filter := bson.D{{Key: field, Value: bson.D{{Key: "$type", Value: uniqueValueTypes}}}}
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetName(uniqueIndexName(s.namespace, field)).SetUnique(true).SetPartialFilterExpression(filter),
+	})
+	return err
+}
+
+func (s *MongoStore) DropUniqueIndex(ctx context.Context, field string) error {
+	_, err := s.collection.Indexes().DropOne(ctx, uniqueIndexName(s.namespace, field))
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Name == "IndexNotFound" {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteStore_UniqueIndex(t *testing.T) {
+	testStoreUniqueIndex(t, createTestSQLiteDB)
+}
+
+func TestPostgresStore_UniqueIndex(t *testing.T) {
+	testStoreUniqueIndex(t, createTestPostgresDB)
+}
+
+func TestMySQLStore_UniqueIndex(t *testing.T) {
+	testStoreUniqueIndex(t, createTestMySQLDB)
+}
+
+func testStoreUniqueIndex(t *testing.T, newDB func(t *testing.T) DatabaseInterface) {
+	db := newDB(t)
+	defer cleanupTestDB(db)
+	require.NoError(t, db.Drop())
+
+	store := db.CreateStore("unique_accounts")
+	indexer, ok := store.(UniqueIndexer)
+	require.True(t, ok, "store %T does not implement UniqueIndexer", store)
+	ctx := context.Background()
+
+	_, err := store.Insert(ctx, map[string]interface{}{"email": "a@example.com"})
+	require.NoError(t, err)
+	_, err = store.Insert(ctx, map[string]interface{}{"email": "a@example.com"})
+	require.NoError(t, err)
+
+	// Existing duplicates keep the index from being created
+	require.Error(t, indexer.EnsureUniqueIndex(ctx, "email"))
+
+	_, err = store.Remove(ctx, NewQueryBuilder().Where("email", "$eq", "a@example.com"))
+	require.NoError(t, err)
+	require.NoError(t, indexer.EnsureUniqueIndex(ctx, "email"))
+	require.NoError(t, indexer.EnsureUniqueIndex(ctx, "email"), "ensuring twice must be a no-op")
+
+	_, err = store.Insert(ctx, map[string]interface{}{"email": "a@example.com"})
+	require.NoError(t, err)
+	second, err := store.Insert(ctx, map[string]interface{}{"email": "b@example.com"})
+	require.NoError(t, err)
+
+	// Documents without the field never conflict
+	_, err = store.Insert(ctx, map[string]interface{}{"name": "no email"})
+	require.NoError(t, err)
+	_, err = store.Insert(ctx, map[string]interface{}{"name": "still no email"})
+	require.NoError(t, err)
+	_, err = store.Insert(ctx, map[string]interface{}{"email": nil})
+	require.NoError(t, err)
+	_, err = store.Insert(ctx, map[string]interface{}{"email": nil})
+	require.NoError(t, err, "null values never conflict")
+
+	// Values are compared exactly, whatever their length
+	_, err = store.Insert(ctx, map[string]interface{}{"email": "A@example.com"})
+	require.NoError(t, err)
+	long := strings.Repeat("x", 400) + "@example.com"
+	_, err = store.Insert(ctx, map[string]interface{}{"email": long})
+	require.NoError(t, err)
+	_, err = store.Insert(ctx, map[string]interface{}{"email": long})
+	_, ok = IsDuplicateKey(err, "unique_accounts")
+	require.True(t, ok, "expected a duplicate key error, got %v", err)
+
+	_, err = store.Insert(ctx, map[string]interface{}{"email": "a@example.com"})
+	dupErr, ok := IsDuplicateKey(err, "unique_accounts")
+	require.True(t, ok, "expected a duplicate key error, got %v", err)
+	assert.Equal(t, "email", dupErr.Field)
+
+	secondID := second.(map[string]interface{})["id"]
+	_, err = store.Update(ctx, NewQueryBuilder().Where("id", "$eq", secondID), NewUpdateBuilder().Set("email", "a@example.com"))
+	dupErr, ok = IsDuplicateKey(err, "unique_accounts")
+	require.True(t, ok, "expected a duplicate key error, got %v", err)
+	assert.Equal(t, "email", dupErr.Field)
+
+	require.NoError(t, indexer.DropUniqueIndex(ctx, "email"))
+	require.NoError(t, indexer.DropUniqueIndex(ctx, "email"), "dropping twice must be a no-op")
+	_, err = store.Insert(ctx, map[string]interface{}{"email": "a@example.com"})
+	assert.NoError(t, err)
+}
+
+func TestIsDuplicateKey(t *testing.T) {
+	_, ok := IsDuplicateKey(nil, "users")
+	assert.False(t, ok)
+
+	_, ok = IsDuplicateKey(errors.New("UNIQUE constraint failed: index 'uniq_users_email'"), "users")
+	assert.False(t, ok, "only driver errors are recognized")
+
+	_, ok = IsDuplicateKey(fmt.Errorf("failed to insert document: %w", errors.New("boom")), "users")
+	assert.False(t, ok)
+}
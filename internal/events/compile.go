@@ -29,6 +29,10 @@ type EventContext struct {
 	Cancel     func(message string, statusCode int)
 	Log        func(message string, data ...map[string]interface{})
 	Emit       func(event string, data interface{}, room ...string) // Real-time event emission
+	Dpd        Dpd                                                  // Access to other collections
+	// Enqueue queues a background job and returns its ID. Options are
+	// "delay" in seconds and "maxAttempts".
+	Enqueue    func(queue string, payload map[string]interface{}, opts ...map[string]interface{}) (string, error)
 	Resource   interface{ GetName() string }
 	hideFields []string
 }
@@ -207,6 +211,12 @@ func RunGoPlugin(pluginPath string, ctx *context.Context, data map[string]interf
 
 // RunGoPluginWithEmitter loads and executes a Go plugin with real-time emit capability
 func RunGoPluginWithEmitter(pluginPath string, ctx *context.Context, data map[string]interface{}, emitter RealtimeEmitter) error {
+	return runGoPluginWithDpd(pluginPath, ctx, data, emitter, nil, nil)
+}
+
+// runGoPluginWithDpd loads and executes a Go plugin with real-time emit, dpd
+// access and job queueing
+func runGoPluginWithDpd(pluginPath string, ctx *context.Context, data map[string]interface{}, emitter RealtimeEmitter, dpd Dpd, enqueuer Enqueuer) error {
 	startTime := time.Now()
 
 	// Load the plugin
@@ -229,8 +239,10 @@ func RunGoPluginWithEmitter(pluginPath string, ctx *context.Context, data map[st
 		Data:       data,
 		Errors:     make(map[string]string),
 		Query:      ctx.Query,
-		Internal:   false,
+		Internal:   ctx.Internal,
 		IsRoot:     ctx.IsRoot,
+		Dpd:        dpd,
+		Enqueue:    enqueueFunc(enqueuer, ctx),
 		Resource:   ctx.Resource,
 		hideFields: make([]string, 0),
 	}
@@ -241,6 +253,7 @@ func RunGoPluginWithEmitter(pluginPath string, ctx *context.Context, data map[st
 			"id":       ctx.UserID,
 			"username": ctx.Username,
 			"isRoot":   ctx.IsRoot,
+			"roles":    append([]string{}, ctx.Roles...),
 		}
 		eventCtx.Me = userData
 		// Add compatibility fields for all possible variations
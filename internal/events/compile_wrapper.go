@@ -147,10 +147,66 @@ type EventContext struct {
 	//        Emit("event-name", data, "room-name") - sends to specific room
 	Emit func(event string, data interface{}, room ...string)
 	
+	// Dpd reads and writes other collections through their own events and validation
+	// Usage: Dpd.Get("todos", map[string]interface{}{"done": false})
+	//        Dpd.Post("todos", map[string]interface{}{"title": "New"})
+	Dpd DpdClient
+	
+	// Enqueue queues a background job on a queue resource and returns its ID
+	// Usage: Enqueue("thumbnails", map[string]interface{}{"imageId": id})
+	//        Enqueue("emails", payload, map[string]interface{}{"delay": 60, "maxAttempts": 3})
+	Enqueue func(queue string, payload map[string]interface{}, opts ...map[string]interface{}) (string, error)
+	
 	// Hide removes a field from the response
 	hideFields []string
 }
 
+// DpdClient provides access to other collections from event handlers
+type DpdClient interface {
+	Get(collection string, query map[string]interface{}) ([]map[string]interface{}, error)
+	GetOne(collection, id string) (map[string]interface{}, error)
+	Post(collection string, data map[string]interface{}) (map[string]interface{}, error)
+	Put(collection, id string, data map[string]interface{}) (map[string]interface{}, error)
+	Del(collection, id string) error
+}
+
+// unavailableDpd is used when the runtime does not provide a dpd client
+type unavailableDpd struct{}
+
+func (unavailableDpd) Get(string, map[string]interface{}) ([]map[string]interface{}, error) {
+	return nil, errDpdUnavailable
+}
+
+func (unavailableDpd) GetOne(string, string) (map[string]interface{}, error) {
+	return nil, errDpdUnavailable
+}
+
+func (unavailableDpd) Post(string, map[string]interface{}) (map[string]interface{}, error) {
+	return nil, errDpdUnavailable
+}
+
+func (unavailableDpd) Put(string, string, map[string]interface{}) (map[string]interface{}, error) {
+	return nil, errDpdUnavailable
+}
+
+func (unavailableDpd) Del(string, string) error {
+	return errDpdUnavailable
+}
+
+type dpdUnavailableError struct{}
+
+func (dpdUnavailableError) Error() string {
+	return "dpd is not available in this context"
+}
+
+var errDpdUnavailable error = dpdUnavailableError{}
+
+type enqueueUnavailableError struct{}
+
+func (enqueueUnavailableError) Error() string {
+	return "enqueue is not available in this context"
+}
+
 // Error adds a validation error
 func (ctx *EventContext) Error(field, message string) {
 	if ctx.Errors == nil {
@@ -201,6 +257,8 @@ func (h eventHandler) Run(ctx interface{}) error {
 		Cancel:   safeGetCancelField(v, "Cancel"),
 		Log:      safeGetLogField(v, "Log"),
 		Emit:     safeGetEmitField(v, "Emit"),
+		Dpd:      safeGetDpdField(v, "Dpd"),
+		Enqueue:  safeGetEnqueueField(v, "Enqueue"),
 	}
 	
 	// Run the user's event handler
@@ -314,6 +372,31 @@ func safeGetEmitField(v reflect.Value, fieldName string) func(string, interface{
 	}
 	return func(string, interface{}, ...string) {} // no-op function
 }
+
+func safeGetDpdField(v reflect.Value, fieldName string) DpdClient {
+	val := getFieldValue(v, fieldName)
+	if val == nil {
+		return unavailableDpd{}
+	}
+	if dpd, ok := val.(DpdClient); ok {
+		return dpd
+	}
+	return unavailableDpd{}
+}
+
+func safeGetEnqueueField(v reflect.Value, fieldName string) func(string, map[string]interface{}, ...map[string]interface{}) (string, error) {
+	unavailable := func(string, map[string]interface{}, ...map[string]interface{}) (string, error) {
+		return "", enqueueUnavailableError{}
+	}
+	val := getFieldValue(v, fieldName)
+	if val == nil {
+		return unavailable
+	}
+	if enqueueFunc, ok := val.(func(string, map[string]interface{}, ...map[string]interface{}) (string, error)); ok && enqueueFunc != nil {
+		return enqueueFunc
+	}
+	return unavailable
+}
 `
 
 	return fmt.Sprintf(template, userFunctions)
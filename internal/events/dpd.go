@@ -0,0 +1,184 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hjanuschka/go-deployd/internal/context"
+	v8 "rogchap.com/v8go"
+)
+
+// Dpd gives event scripts access to other collections. Requests are routed
+// through the target collection's handler, so its events and validation run
+// exactly as they would for an HTTP request.
+type Dpd interface {
+	Get(collection string, query map[string]interface{}) ([]map[string]interface{}, error)
+	GetOne(collection, id string) (map[string]interface{}, error)
+	Post(collection string, data map[string]interface{}) (map[string]interface{}, error)
+	Put(collection, id string, data map[string]interface{}) (map[string]interface{}, error)
+	Del(collection, id string) error
+}
+
+// DpdProvider creates Dpd clients bound to the request an event runs for
+type DpdProvider interface {
+	Dpd(ctx *context.Context) Dpd
+}
+
+// dpdBootstrap builds the JavaScript `dpd` object on top of a single native
+// request function. Each collection proxy accepts an optional deployd-style
+// callback(result, error); without one, results are returned and errors thrown.
+const dpdBootstrap = `(function(request) {
+	function call(method, collection, id, query, body, fn) {
+		var response = JSON.parse(request(method, collection, id || '', JSON.stringify(query || {}), JSON.stringify(body || {})));
+		if (typeof fn === 'function') {
+			fn(response.error ? null : response.result, response.error || null);
+			return response.error ? undefined : response.result;
+		}
+		if (response.error) {
+			var err = new Error(response.error.message);
+			err.statusCode = response.error.statusCode;
+			throw err;
+		}
+		return response.result;
+	}
+	function split(idOrData, data) {
+		if (typeof idOrData === 'string') {
+			return [idOrData, data];
+		}
+		return [idOrData && idOrData.id, idOrData];
+	}
+	function collection(name) {
+		return {
+			get: function(query, fn) {
+				if (typeof query === 'function') {
+					fn = query;
+					query = {};
+				}
+				if (typeof query === 'string') {
+					return call('get', name, query, null, null, fn);
+				}
+				if (query && typeof query.id === 'string') {
+					return call('get', name, query.id, null, null, fn);
+				}
+				return call('get', name, '', query, null, fn);
+			},
+			post: function(data, fn) {
+				return call('post', name, '', null, data, fn);
+			},
+			put: function(idOrData, data, fn) {
+				if (typeof data === 'function') {
+					fn = data;
+					data = undefined;
+				}
+				var args = split(idOrData, data);
+				return call('put', name, args[0], null, args[1], fn);
+			},
+			del: function(idOrData, fn) {
+				return call('del', name, split(idOrData)[0], null, null, fn);
+			}
+		};
+	}
+	return new Proxy({}, {
+		get: function(target, name) {
+			if (typeof name !== 'string') {
+				return undefined;
+			}
+			return collection(name);
+		}
+	});
+})`
+
+// setupDpdObject exposes the dpd client as the global `dpd` and `context.dpd`
+func setupDpdObject(v8ctx *v8.Context, contextInstance *v8.Object, dpd Dpd) error {
+	isolate := v8ctx.Isolate()
+
+	requestFunc := v8.NewFunctionTemplate(isolate, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		args := info.Args()
+		if len(args) < 5 {
+			return dpdResponse(isolate, nil, fmt.Errorf("dpd request requires 5 arguments"))
+		}
+
+		method := args[0].String()
+		collection := args[1].String()
+		id := args[2].String()
+
+		var query, body map[string]interface{}
+		if err := json.Unmarshal([]byte(args[3].String()), &query); err != nil {
+			return dpdResponse(isolate, nil, fmt.Errorf("invalid dpd query: %w", err))
+		}
+		if err := json.Unmarshal([]byte(args[4].String()), &body); err != nil {
+			return dpdResponse(isolate, nil, fmt.Errorf("invalid dpd data: %w", err))
+		}
+
+		result, err := callDpd(dpd, method, collection, id, query, body)
+		return dpdResponse(isolate, result, err)
+	})
+
+	bootstrap, err := v8ctx.RunScript(dpdBootstrap, "dpd.js")
+	if err != nil {
+		return fmt.Errorf("failed to set up dpd: %w", err)
+	}
+	bootstrapFunc, err := bootstrap.AsFunction()
+	if err != nil {
+		return err
+	}
+	dpdValue, err := bootstrapFunc.Call(v8ctx.Global(), requestFunc.GetFunction(v8ctx))
+	if err != nil {
+		return fmt.Errorf("failed to set up dpd: %w", err)
+	}
+
+	contextInstance.Set("dpd", dpdValue)
+	return v8ctx.Global().Set("dpd", dpdValue)
+}
+
+// callDpd dispatches a JavaScript dpd call to the matching Dpd method
+func callDpd(dpd Dpd, method, collection, id string, query, body map[string]interface{}) (interface{}, error) {
+	switch method {
+	case "get":
+		if id != "" {
+			return dpd.GetOne(collection, id)
+		}
+		return dpd.Get(collection, query)
+	case "post":
+		return dpd.Post(collection, body)
+	case "put":
+		if id == "" {
+			return nil, &ScriptError{Message: "id is required for put", StatusCode: 400}
+		}
+		return dpd.Put(collection, id, body)
+	case "del":
+		if id == "" {
+			return nil, &ScriptError{Message: "id is required for del", StatusCode: 400}
+		}
+		return nil, dpd.Del(collection, id)
+	default:
+		return nil, fmt.Errorf("unknown dpd method: %s", method)
+	}
+}
+
+// dpdResponse encodes a dpd result as the JSON envelope expected by dpdBootstrap
+func dpdResponse(isolate *v8.Isolate, result interface{}, err error) *v8.Value {
+	response := map[string]interface{}{"result": result}
+	if err != nil {
+		statusCode := 500
+		if scriptErr, ok := err.(*ScriptError); ok {
+			statusCode = scriptErr.StatusCode
+		}
+		response = map[string]interface{}{
+			"error": map[string]interface{}{
+				"message":    err.Error(),
+				"statusCode": statusCode,
+			},
+		}
+	}
+
+	responseJSON, marshalErr := json.Marshal(response)
+	if marshalErr != nil {
+		responseJSON, _ = json.Marshal(map[string]interface{}{
+			"error": map[string]interface{}{"message": marshalErr.Error(), "statusCode": 500},
+		})
+	}
+
+	value, _ := v8.NewValue(isolate, string(responseJSON))
+	return value
+}
@@ -0,0 +1,131 @@
+package events_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDpd records calls and serves documents from memory
+type fakeDpd struct {
+	calls []string
+	docs  map[string]map[string]interface{}
+}
+
+func (f *fakeDpd) Get(collection string, query map[string]interface{}) ([]map[string]interface{}, error) {
+	f.calls = append(f.calls, "get "+collection)
+	var docs []map[string]interface{}
+	for _, doc := range f.docs {
+		if done, ok := query["done"]; ok && doc["done"] != done {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func (f *fakeDpd) GetOne(collection, id string) (map[string]interface{}, error) {
+	f.calls = append(f.calls, "getOne "+collection+" "+id)
+	if doc, ok := f.docs[id]; ok {
+		return doc, nil
+	}
+	return nil, &events.ScriptError{Message: "Document not found", StatusCode: 404}
+}
+
+func (f *fakeDpd) Post(collection string, data map[string]interface{}) (map[string]interface{}, error) {
+	f.calls = append(f.calls, "post "+collection)
+	data["id"] = "new"
+	f.docs["new"] = data
+	return data, nil
+}
+
+func (f *fakeDpd) Put(collection, id string, data map[string]interface{}) (map[string]interface{}, error) {
+	f.calls = append(f.calls, "put "+collection+" "+id)
+	for k, v := range data {
+		f.docs[id][k] = v
+	}
+	return f.docs[id], nil
+}
+
+func (f *fakeDpd) Del(collection, id string) error {
+	f.calls = append(f.calls, "del "+collection+" "+id)
+	delete(f.docs, id)
+	return nil
+}
+
+type fakeDpdProvider struct {
+	dpd *fakeDpd
+}
+
+func (p *fakeDpdProvider) Dpd(ctx *context.Context) events.Dpd {
+	return p.dpd
+}
+
+func TestDpdInJavaScriptEvents(t *testing.T) {
+	tempDir := t.TempDir()
+
+	jsContent := `
+function Run(context) {
+	var open = dpd.todos.get({done: false});
+	context.data.openCount = open.length;
+
+	var first = context.dpd.todos.get('a');
+	context.data.firstTitle = first.title;
+
+	var created = dpd.todos.post({title: 'From event', done: false});
+	context.data.createdId = created.id;
+
+	dpd.todos.put({id: 'a', done: true});
+
+	try {
+		dpd.todos.get('missing');
+	} catch (e) {
+		context.data.missingStatus = e.statusCode;
+	}
+
+	dpd.todos.get('missing', function(result, err) {
+		context.data.callbackError = err.message;
+	});
+
+	dpd.todos.del('new');
+}
+`
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "post.js"), []byte(jsContent), 0644))
+
+	manager := events.NewUniversalScriptManager()
+	require.NoError(t, manager.LoadScriptsWithConfig(tempDir, map[string]events.EventConfiguration{
+		"post": {Runtime: "js"},
+	}))
+
+	dpd := &fakeDpd{docs: map[string]map[string]interface{}{
+		"a": {"id": "a", "title": "First", "done": false},
+		"b": {"id": "b", "title": "Second", "done": true},
+	}}
+	manager.SetDpdProvider(&fakeDpdProvider{dpd: dpd})
+
+	data := map[string]interface{}{"title": "Trigger"}
+	err := manager.RunEvent(events.EventPost, &context.Context{Method: "POST"}, data)
+	require.NoError(t, err)
+
+	assert.Equal(t, float64(1), data["openCount"])
+	assert.Equal(t, "First", data["firstTitle"])
+	assert.Equal(t, "new", data["createdId"])
+	assert.Equal(t, float64(404), data["missingStatus"])
+	assert.Equal(t, "Document not found", data["callbackError"])
+	assert.Equal(t, true, dpd.docs["a"]["done"])
+	assert.NotContains(t, dpd.docs, "new")
+	assert.Equal(t, []string{
+		"get todos",
+		"getOne todos a",
+		"post todos",
+		"put todos a",
+		"getOne todos missing",
+		"getOne todos missing",
+		"del todos new",
+	}, dpd.calls)
+}
@@ -0,0 +1,134 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/context"
+	v8 "rogchap.com/v8go"
+)
+
+// Enqueuer queues background jobs for the queue resources of a server, so
+// event scripts can hand slow work off instead of running it in the request.
+// Jobs are enqueued in the transaction of the request an event runs for, so
+// they only run once it commits.
+type Enqueuer interface {
+	Enqueue(ctx *context.Context, queue string, payload map[string]interface{}, opts EnqueueOptions) (string, error)
+}
+
+// EnqueueOptions override the queue's defaults for one job
+type EnqueueOptions struct {
+	Delay       time.Duration // Wait before the first attempt
+	MaxAttempts int           // Attempts before the job fails; the queue's if 0
+}
+
+// ParseEnqueueOptions reads the options scripts pass to enqueue: delay in
+// seconds and maxAttempts
+func ParseEnqueueOptions(raw map[string]interface{}) (EnqueueOptions, error) {
+	var opts EnqueueOptions
+	for key, value := range raw {
+		number, ok := optionNumber(value)
+		switch key {
+		case "delay":
+			if !ok || number < 0 {
+				return opts, fmt.Errorf("delay must be a number of seconds")
+			}
+			opts.Delay = time.Duration(number * float64(time.Second))
+		case "maxAttempts":
+			if !ok || number < 1 || number != float64(int(number)) {
+				return opts, fmt.Errorf("maxAttempts must be a positive whole number")
+			}
+			opts.MaxAttempts = int(number)
+		default:
+			return opts, fmt.Errorf("unknown enqueue option %q", key)
+		}
+	}
+	return opts, nil
+}
+
+func optionNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// enqueueFunc adapts an Enqueuer to the Enqueue function of Go event
+// contexts, whose options are a plain map so they cross the plugin boundary
+func enqueueFunc(enqueuer Enqueuer, ctx *context.Context) func(string, map[string]interface{}, ...map[string]interface{}) (string, error) {
+	return func(queue string, payload map[string]interface{}, opts ...map[string]interface{}) (string, error) {
+		if enqueuer == nil {
+			return "", fmt.Errorf("enqueue is not available in this context")
+		}
+		var raw map[string]interface{}
+		if len(opts) > 0 {
+			raw = opts[0]
+		}
+		parsed, err := ParseEnqueueOptions(raw)
+		if err != nil {
+			return "", err
+		}
+		return enqueuer.Enqueue(ctx, queue, payload, parsed)
+	}
+}
+
+// enqueueBootstrap builds context.enqueue(queue, payload, opts) on top of a
+// native function, returning the job ID or throwing the error
+const enqueueBootstrap = `(function(enqueue) {
+	return function(queue, payload, opts) {
+		var response = JSON.parse(enqueue(String(queue), JSON.stringify(payload || {}), JSON.stringify(opts || {})));
+		if (response.error) {
+			var err = new Error(response.error.message);
+			err.statusCode = response.error.statusCode;
+			throw err;
+		}
+		return response.result;
+	};
+})`
+
+// setupEnqueueFunction exposes the enqueuer as context.enqueue
+func setupEnqueueFunction(v8ctx *v8.Context, contextInstance *v8.Object, enqueuer Enqueuer, ctx *context.Context) error {
+	isolate := v8ctx.Isolate()
+
+	nativeFunc := v8.NewFunctionTemplate(isolate, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		args := info.Args()
+		if len(args) < 3 {
+			return dpdResponse(isolate, nil, fmt.Errorf("enqueue requires 3 arguments"))
+		}
+
+		var payload, raw map[string]interface{}
+		if err := json.Unmarshal([]byte(args[1].String()), &payload); err != nil {
+			return dpdResponse(isolate, nil, &ScriptError{Message: "payload must be an object", StatusCode: 400})
+		}
+		if err := json.Unmarshal([]byte(args[2].String()), &raw); err != nil {
+			return dpdResponse(isolate, nil, &ScriptError{Message: "options must be an object", StatusCode: 400})
+		}
+		opts, err := ParseEnqueueOptions(raw)
+		if err != nil {
+			return dpdResponse(isolate, nil, &ScriptError{Message: err.Error(), StatusCode: 400})
+		}
+
+		id, err := enqueuer.Enqueue(ctx, args[0].String(), payload, opts)
+		return dpdResponse(isolate, id, err)
+	})
+
+	bootstrap, err := v8ctx.RunScript(enqueueBootstrap, "enqueue.js")
+	if err != nil {
+		return fmt.Errorf("failed to set up enqueue: %w", err)
+	}
+	bootstrapFunc, err := bootstrap.AsFunction()
+	if err != nil {
+		return err
+	}
+	enqueueValue, err := bootstrapFunc.Call(v8ctx.Global(), nativeFunc.GetFunction(v8ctx))
+	if err != nil {
+		return fmt.Errorf("failed to set up enqueue: %w", err)
+	}
+	return contextInstance.Set("enqueue", enqueueValue)
+}
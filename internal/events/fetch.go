@@ -0,0 +1,448 @@
+package events
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/logging"
+	"github.com/hjanuschka/go-deployd/internal/metrics"
+	v8 "rogchap.com/v8go"
+)
+
+const (
+	defaultFetchTimeout         = 10 * time.Second
+	defaultFetchMaxResponseSize = 5 << 20
+	fetchMaxRedirects           = 5
+)
+
+// FetchConfig limits what fetch in the JavaScript events of a resource may
+// request. Without allowed hosts, every request is rejected.
+type FetchConfig struct {
+	// AllowedHosts are the hosts scripts may request, e.g. "api.stripe.com",
+	// "*.example.com" for its subdomains, or "localhost:8080" for one port
+	AllowedHosts []string `json:"allowedHosts"`
+	// Timeout is how many seconds a request may take, redirects and reading
+	// the body included (default 10)
+	Timeout int `json:"timeout,omitempty"`
+	// MaxResponseSize is how many bytes a response body may have (default 5 MB)
+	MaxResponseSize int64 `json:"maxResponseSize,omitempty"`
+}
+
+// Validate checks the allowed hosts and limits
+func (c *FetchConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	for _, host := range c.AllowedHosts {
+		name := strings.TrimPrefix(host, "*.")
+		if h, _, err := net.SplitHostPort(name); err == nil {
+			name = h
+		}
+		if name == "" || strings.ContainsAny(name, "/*@ ") || strings.Contains(host, "/") {
+			return fmt.Errorf("invalid allowed host %q: use a host name like api.example.com or *.example.com", host)
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	if c.MaxResponseSize < 0 {
+		return fmt.Errorf("maxResponseSize must not be negative")
+	}
+	return nil
+}
+
+// allows reports whether fetch may request u. Hosts allowed with a port only
+// match that port; "*.example.com" matches the subdomains of example.com.
+func (c *FetchConfig) allows(u *url.URL) bool {
+	if c == nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	for _, allowed := range c.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		name := host
+		if h, p, err := net.SplitHostPort(allowed); err == nil {
+			if p != port {
+				continue
+			}
+			allowed = h
+		}
+		if strings.HasPrefix(allowed, "*.") {
+			if strings.HasSuffix(name, allowed[1:]) {
+				return true
+			}
+		} else if name == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *FetchConfig) timeout() time.Duration {
+	if c == nil || c.Timeout == 0 {
+		return defaultFetchTimeout
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+func (c *FetchConfig) maxResponseSize() int64 {
+	if c == nil || c.MaxResponseSize == 0 {
+		return defaultFetchMaxResponseSize
+	}
+	return c.MaxResponseSize
+}
+
+// fetchRequest is what the fetch bootstrap passes to the native function
+type fetchRequest struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	Body    *string           `json:"body"`
+}
+
+// fetchResponse is what a fetch promise is resolved with, which the
+// bootstrap wraps in a Response
+type fetchResponse struct {
+	URL        string            `json:"url"`
+	Status     int               `json:"status"`
+	StatusText string            `json:"statusText"`
+	Redirected bool              `json:"redirected"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+}
+
+// fetchResult is a finished request, handed from its goroutine to the
+// script's thread
+type fetchResult struct {
+	resolver *v8.PromiseResolver
+	call     metrics.FetchCall
+	response *fetchResponse
+	err      error
+}
+
+// fetchLoop runs the requests of one script execution. Requests run in their
+// own goroutines, but their promises are only settled on the script's thread,
+// in settle, since V8 values must not be touched from other goroutines.
+type fetchLoop struct {
+	config  *FetchConfig
+	ctx     gocontext.Context
+	cancel  gocontext.CancelFunc
+	client  *http.Client
+	results chan fetchResult
+	closed  chan struct{}
+	expired <-chan struct{} // Closed when the script exceeds its limits
+	pending int
+	calls   []metrics.FetchCall
+}
+
+func newFetchLoop(ctx *context.Context, config *FetchConfig) *fetchLoop {
+	parent := gocontext.Background()
+	if ctx != nil && ctx.Context() != nil {
+		parent = ctx.Context()
+	}
+	loop := &fetchLoop{
+		config:  config,
+		results: make(chan fetchResult),
+		closed:  make(chan struct{}),
+	}
+	loop.ctx, loop.cancel = gocontext.WithCancel(parent)
+	loop.client = &http.Client{
+		Timeout: config.timeout(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= fetchMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", fetchMaxRedirects)
+			}
+			if !config.allows(req.URL) {
+				return fmt.Errorf("redirect to %s is not allowed", req.URL.Host)
+			}
+			return nil
+		},
+	}
+	return loop
+}
+
+// close abandons the requests still running, e.g. of a script that failed
+func (l *fetchLoop) close() {
+	l.cancel()
+	close(l.closed)
+}
+
+// start checks a request against the config and runs it, returning the
+// promise of its response
+func (l *fetchLoop) start(v8ctx *v8.Context, requestJSON string) (*v8.Value, error) {
+	resolver, err := v8.NewPromiseResolver(v8ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var request fetchRequest
+	if err := json.Unmarshal([]byte(requestJSON), &request); err != nil {
+		return nil, err
+	}
+	call := metrics.FetchCall{Method: request.Method}
+	u, err := url.Parse(request.URL)
+	if err == nil && !u.IsAbs() {
+		err = fmt.Errorf("URL must be absolute")
+	}
+	if err != nil {
+		l.reject(v8ctx, resolver, call, fmt.Errorf("invalid URL %q: %w", request.URL, err))
+		return resolver.GetPromise().Value, nil
+	}
+	call.Host = u.Host
+	if !l.config.allows(u) {
+		logging.Warn("Blocked fetch to a host that is not allowed", "js-fetch", map[string]interface{}{
+			"method": request.Method,
+			"host":   u.Host,
+		})
+		l.reject(v8ctx, resolver, call, fmt.Errorf("fetch to %s is not allowed: add the host to fetch.allowedHosts", u.Host))
+		return resolver.GetPromise().Value, nil
+	}
+
+	var body io.Reader
+	if request.Body != nil {
+		body = strings.NewReader(*request.Body)
+	}
+	req, err := http.NewRequestWithContext(l.ctx, request.Method, u.String(), body)
+	if err != nil {
+		l.reject(v8ctx, resolver, call, err)
+		return resolver.GetPromise().Value, nil
+	}
+	for name, value := range request.Headers {
+		req.Header.Set(name, value)
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", "go-deployd")
+	}
+
+	l.pending++
+	go func() {
+		start := time.Now()
+		response, err := l.do(req)
+		call.DurationMs = time.Since(start).Milliseconds()
+		if response != nil {
+			call.Status = response.Status
+		}
+		select {
+		case l.results <- fetchResult{resolver: resolver, call: call, response: response, err: err}:
+		case <-l.closed:
+		}
+	}()
+	return resolver.GetPromise().Value, nil
+}
+
+// do sends a request and reads its response, up to the size limit
+func (l *fetchLoop) do(req *http.Request) (*fetchResponse, error) {
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	limit := l.config.maxResponseSize()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("response of %s is larger than %d bytes", req.URL.Host, limit)
+	}
+
+	headers := make(map[string]string, len(resp.Header))
+	for name, values := range resp.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ", ")
+	}
+	return &fetchResponse{
+		URL:        resp.Request.URL.String(),
+		Status:     resp.StatusCode,
+		StatusText: strings.TrimSpace(strings.TrimPrefix(resp.Status, fmt.Sprint(resp.StatusCode))),
+		Redirected: resp.Request.URL.String() != req.URL.String(),
+		Headers:    headers,
+		Body:       string(body),
+	}, nil
+}
+
+// reject rejects a fetch promise with the message of err, which the
+// bootstrap turns into a TypeError as fetch does for network errors
+func (l *fetchLoop) reject(v8ctx *v8.Context, resolver *v8.PromiseResolver, call metrics.FetchCall, err error) {
+	call.Error = err.Error()
+	l.calls = append(l.calls, call)
+	message, _ := v8.NewValue(v8ctx.Isolate(), err.Error())
+	resolver.Reject(message)
+}
+
+// settle runs the script's event loop: it settles the promises of fetches as
+// their requests finish, until none are pending. If result, the return value
+// of Run(context), is a promise, it then returns the error it was rejected
+// with.
+func (l *fetchLoop) settle(v8ctx *v8.Context, result *v8.Value) error {
+	v8ctx.PerformMicrotaskCheckpoint()
+	for l.pending > 0 {
+		var finished fetchResult
+		select {
+		case finished = <-l.results:
+		case <-l.expired:
+			return fmt.Errorf("script was terminated while waiting for fetch")
+		}
+		l.pending--
+		if finished.err != nil {
+			l.reject(v8ctx, finished.resolver, finished.call, finished.err)
+		} else {
+			l.calls = append(l.calls, finished.call)
+			responseJSON, _ := json.Marshal(finished.response)
+			value, err := v8.JSONParse(v8ctx, string(responseJSON))
+			if err != nil {
+				return err
+			}
+			finished.resolver.Resolve(value)
+		}
+		logging.Debug("Fetch finished", "js-fetch", map[string]interface{}{
+			"method":     finished.call.Method,
+			"host":       finished.call.Host,
+			"status":     finished.call.Status,
+			"durationMs": finished.call.DurationMs,
+			"error":      finished.call.Error,
+		})
+		v8ctx.PerformMicrotaskCheckpoint()
+	}
+
+	if result == nil || !result.IsPromise() {
+		return nil
+	}
+	promise, err := result.AsPromise()
+	if err != nil {
+		return err
+	}
+	switch promise.State() {
+	case v8.Rejected:
+		return fmt.Errorf("%s", promise.Result().String())
+	case v8.Pending:
+		return fmt.Errorf("the promise returned by Run(context) never settled")
+	}
+	return nil
+}
+
+// fetchBootstrap builds fetch(resource, options) on top of a native function
+// that takes the request as JSON and returns a promise of the response
+const fetchBootstrap = `(function(nativeFetch) {
+	function Headers(init) {
+		this._map = {};
+		if (init instanceof Headers) {
+			init = init._map;
+		}
+		for (var name in init || {}) {
+			this._map[name.toLowerCase()] = String(init[name]);
+		}
+	}
+	Headers.prototype.get = function(name) {
+		var value = this._map[String(name).toLowerCase()];
+		return value === undefined ? null : value;
+	};
+	Headers.prototype.has = function(name) {
+		return String(name).toLowerCase() in this._map;
+	};
+	Headers.prototype.set = function(name, value) {
+		this._map[String(name).toLowerCase()] = String(value);
+	};
+	Headers.prototype.forEach = function(callback, thisArg) {
+		for (var name in this._map) {
+			callback.call(thisArg, this._map[name], name, this);
+		}
+	};
+	Headers.prototype.entries = function() {
+		var map = this._map;
+		return Object.keys(map).map(function(name) { return [name, map[name]]; })[Symbol.iterator]();
+	};
+	Headers.prototype[Symbol.iterator] = Headers.prototype.entries;
+
+	function Response(raw) {
+		this.url = raw.url;
+		this.status = raw.status;
+		this.statusText = raw.statusText;
+		this.ok = raw.status >= 200 && raw.status < 300;
+		this.redirected = raw.redirected;
+		this.headers = new Headers(raw.headers);
+		this._body = raw.body;
+		this.bodyUsed = false;
+	}
+	Response.prototype.text = function() {
+		if (this.bodyUsed) {
+			return Promise.reject(new TypeError('body has already been read'));
+		}
+		this.bodyUsed = true;
+		return Promise.resolve(this._body);
+	};
+	Response.prototype.json = function() {
+		return this.text().then(JSON.parse);
+	};
+
+	return function fetch(resource, options) {
+		options = options || {};
+		var method = String(options.method || 'GET').toUpperCase();
+		var body = options.body;
+		if (body !== undefined && body !== null && typeof body !== 'string') {
+			return Promise.reject(new TypeError('fetch body must be a string, e.g. JSON.stringify(data)'));
+		}
+		if ((method === 'GET' || method === 'HEAD') && body !== undefined && body !== null) {
+			return Promise.reject(new TypeError('fetch cannot send a body with ' + method));
+		}
+		var request = {
+			url: String(resource && resource.url !== undefined ? resource.url : resource),
+			method: method,
+			headers: new Headers(options.headers)._map,
+			body: body === undefined ? null : body
+		};
+		return nativeFetch(JSON.stringify(request)).then(function(raw) {
+			return new Response(raw);
+		}, function(message) {
+			throw new TypeError(message);
+		});
+	};
+})`
+
+// setupFetchFunction exposes the script's fetch loop as the global fetch
+func setupFetchFunction(v8ctx *v8.Context, sc *ScriptContext) error {
+	isolate := v8ctx.Isolate()
+	nativeFunc := v8.NewFunctionTemplate(isolate, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		args := info.Args()
+		if len(args) < 1 {
+			return nil
+		}
+		promise, err := sc.fetch.start(info.Context(), args[0].String())
+		if err != nil {
+			message, _ := v8.NewValue(isolate, "fetch failed: "+err.Error())
+			return isolate.ThrowException(message)
+		}
+		return promise
+	})
+
+	bootstrap, err := v8ctx.RunScript(fetchBootstrap, "fetch.js")
+	if err != nil {
+		return fmt.Errorf("failed to set up fetch: %w", err)
+	}
+	bootstrapFunc, err := bootstrap.AsFunction()
+	if err != nil {
+		return err
+	}
+	fetchValue, err := bootstrapFunc.Call(v8ctx.Global(), nativeFunc.GetFunction(v8ctx))
+	if err != nil {
+		return fmt.Errorf("failed to set up fetch: %w", err)
+	}
+	return v8ctx.Global().Set("fetch", fetchValue)
+}
+
+// FetchCalls returns the requests the script made with fetch
+func (sc *ScriptContext) FetchCalls() []metrics.FetchCall {
+	return sc.fetch.calls
+}
@@ -0,0 +1,195 @@
+package events_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/events"
+	"github.com/hjanuschka/go-deployd/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// namedResource names the collection events are recorded for
+type namedResource string
+
+func (r namedResource) GetName() string { return string(r) }
+func (r namedResource) GetPath() string { return "/" + string(r) }
+
+func TestFetchInJavaScriptEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rates":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"base": %q, "key": %q, "usd": 1.1}`, r.URL.Query().Get("base"), r.Header.Get("X-Key"))
+		case "/echo":
+			body, _ := io.ReadAll(r.Body)
+			fmt.Fprintf(w, "%s %s", r.Method, body)
+		case "/missing":
+			http.Error(w, "no such rate", http.StatusNotFound)
+		case "/big":
+			w.Write([]byte(strings.Repeat("x", 2048)))
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		case "/redirect":
+			http.Redirect(w, r, "http://blocked.example.com/", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	tempDir := t.TempDir()
+	scripts := map[string]string{
+		"post.js": `
+async function Run(context) {
+	var url = context.data.url;
+	var response = await fetch(url + '/rates?base=EUR', {headers: {'X-Key': 'secret'}});
+	context.data.status = response.status;
+	context.data.ok = response.ok;
+	context.data.contentType = response.headers.get('content-type');
+	context.data.rates = await response.json();
+
+	var both = await Promise.all([
+		fetch(url + '/echo', {method: 'POST', body: JSON.stringify({a: 1})}).then(function(r) { return r.text(); }),
+		fetch(url + '/missing').then(function(r) { return r.status; })
+	]);
+	context.data.echo = both[0];
+	context.data.missingStatus = both[1];
+
+	var failures = {};
+	var attempts = {
+		blocked: 'http://blocked.example.com/',
+		big: url + '/big',
+		slow: url + '/slow',
+		redirect: url + '/redirect',
+		relative: '/rates'
+	};
+	for (var name in attempts) {
+		try {
+			await fetch(attempts[name]);
+			failures[name] = 'none';
+		} catch (e) {
+			failures[name] = e.name + ': ' + e.message;
+		}
+	}
+	context.data.failures = failures;
+}
+`,
+		"put.js": `
+fetch(context.data.url + '/rates?base=CHF').then(function(response) {
+	return response.json();
+}).then(function(rates) {
+	context.data.base = rates.base;
+});
+`,
+		"delete.js": `
+async function Run(context) {
+	var response = await fetch(context.data.url + '/missing');
+	if (!response.ok) {
+		context.cancel(await response.text(), response.status);
+	}
+}
+`,
+		"get.js": `
+async function Run(context) {
+	await fetch(context.data.url + '/missing');
+	throw new Error('rates are down');
+}
+`,
+	}
+	for name, script := range scripts {
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, name), []byte(script), 0644))
+	}
+
+	manager := events.NewUniversalScriptManager()
+	require.NoError(t, manager.LoadScriptsWithConfig(tempDir, map[string]events.EventConfiguration{
+		"post": {Runtime: "js"}, "put": {Runtime: "js"}, "delete": {Runtime: "js"}, "get": {Runtime: "js"},
+	}))
+	manager.SetFetchConfig(&events.FetchConfig{AllowedHosts: []string{"127.0.0.1"}, Timeout: 1, MaxResponseSize: 1024})
+
+	ctx := &context.Context{Method: "POST", Resource: namedResource("fetchtest")}
+
+	t.Run("awaits responses in async Run", func(t *testing.T) {
+		data := map[string]interface{}{"url": server.URL}
+		require.NoError(t, manager.RunEvent(events.EventPost, ctx, data))
+
+		assert.Equal(t, float64(200), data["status"])
+		assert.Equal(t, true, data["ok"])
+		assert.Equal(t, "application/json", data["contentType"])
+		assert.Equal(t, map[string]interface{}{"base": "EUR", "key": "secret", "usd": 1.1}, data["rates"])
+		assert.Equal(t, `POST {"a":1}`, data["echo"])
+		assert.Equal(t, float64(404), data["missingStatus"])
+
+		failures := data["failures"].(map[string]interface{})
+		assert.Contains(t, failures["blocked"], "TypeError: fetch to blocked.example.com is not allowed")
+		assert.Contains(t, failures["big"], "larger than 1024 bytes")
+		assert.Contains(t, failures["slow"], "Timeout")
+		assert.Contains(t, failures["redirect"], "redirect to blocked.example.com is not allowed")
+		assert.Contains(t, failures["relative"], "URL must be absolute")
+	})
+
+	t.Run("settles promises of scripts without Run", func(t *testing.T) {
+		data := map[string]interface{}{"url": server.URL}
+		require.NoError(t, manager.RunEvent(events.EventPut, ctx, data))
+		assert.Equal(t, "CHF", data["base"])
+	})
+
+	t.Run("fails events whose async Run cancels or throws", func(t *testing.T) {
+		err := manager.RunEvent(events.EventDelete, ctx, map[string]interface{}{"url": server.URL})
+		var scriptErr *events.ScriptError
+		require.True(t, errors.As(err, &scriptErr), "got %v", err)
+		assert.Equal(t, 404, scriptErr.StatusCode)
+		assert.Equal(t, "no such rate\n", scriptErr.Message)
+
+		err = manager.RunEvent(events.EventGet, ctx, map[string]interface{}{"url": server.URL})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "rates are down")
+	})
+
+	t.Run("records calls in the event metrics", func(t *testing.T) {
+		recorded := metrics.GetGlobalCollector().GetEventMetrics("fetchtest")["fetchtest.Post"]
+		require.NotEmpty(t, recorded)
+		calls := recorded[len(recorded)-1].Metadata["fetches"].([]metrics.FetchCall)
+		require.Len(t, calls, 8)
+		assert.Equal(t, "GET", calls[0].Method)
+		assert.Equal(t, strings.TrimPrefix(server.URL, "http://"), calls[0].Host)
+		assert.Equal(t, 200, calls[0].Status)
+
+		var blocked []string
+		for _, call := range calls {
+			if call.Error != "" {
+				blocked = append(blocked, call.Host)
+			}
+		}
+		assert.Len(t, blocked, 5)
+	})
+
+	t.Run("rejects every request without a config", func(t *testing.T) {
+		unconfigured := events.NewUniversalScriptManager()
+		require.NoError(t, unconfigured.LoadScriptsWithConfig(tempDir, map[string]events.EventConfiguration{
+			"get": {Runtime: "js"},
+		}))
+		err := unconfigured.RunEvent(events.EventGet, ctx, map[string]interface{}{"url": server.URL})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is not allowed")
+	})
+}
+
+func TestFetchConfigValidate(t *testing.T) {
+	assert.NoError(t, (*events.FetchConfig)(nil).Validate())
+	assert.NoError(t, (&events.FetchConfig{AllowedHosts: []string{"api.example.com", "*.example.com", "localhost:8080"}}).Validate())
+	assert.Error(t, (&events.FetchConfig{AllowedHosts: []string{"https://api.example.com"}}).Validate())
+	assert.Error(t, (&events.FetchConfig{AllowedHosts: []string{"*"}}).Validate())
+	assert.Error(t, (&events.FetchConfig{Timeout: -1}).Validate())
+}
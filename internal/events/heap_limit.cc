@@ -0,0 +1,69 @@
+#include <stddef.h>
+
+#include "_cgo_export.h"
+#include "heap_limit.h"
+
+// v8go does not expose V8's heap callbacks, so the few declarations needed
+// are repeated here. They must match the V8 headers bundled with
+// rogchap.com/v8go, and be checked again whenever v8go is upgraded.
+namespace v8 {
+
+enum GCType : int;
+enum GCCallbackFlags : int;
+
+using NearHeapLimitCallback = size_t (*)(void* data,
+                                         size_t current_heap_limit,
+                                         size_t initial_heap_limit);
+
+class Isolate {
+ public:
+  using GCCallbackWithData = void (*)(Isolate* isolate,
+                                      GCType type,
+                                      GCCallbackFlags flags,
+                                      void* data);
+
+  void AddGCEpilogueCallback(GCCallbackWithData callback,
+                             void* data,
+                             GCType gc_type_filter);
+  void RemoveGCEpilogueCallback(GCCallbackWithData callback, void* data);
+  void AddNearHeapLimitCallback(NearHeapLimitCallback callback, void* data);
+  void RemoveNearHeapLimitCallback(NearHeapLimitCallback callback,
+                                   size_t heap_limit);
+};
+
+}  // namespace v8
+
+namespace {
+
+// kGCTypeAll: scavenges, mark-compacts, incremental marking and weak
+// callback processing
+const v8::GCType kAllGCTypes = static_cast<v8::GCType>(0x1f);
+
+// heapCollected runs on the isolate's thread after every garbage collection
+void heapCollected(v8::Isolate*, v8::GCType, v8::GCCallbackFlags, void* data) {
+  heapCollectedCallback(reinterpret_cast<uintptr_t>(data));
+}
+
+// nearHeapLimit runs when V8 is about to run out of heap, which would abort
+// the process. The run is terminated and V8 given room to unwind it.
+size_t nearHeapLimit(void* data, size_t current_heap_limit, size_t) {
+  nearHeapLimitCallback(reinterpret_cast<uintptr_t>(data));
+  return current_heap_limit + current_heap_limit / 2;
+}
+
+}  // namespace
+
+void addHeapCallbacks(void* isolate, uintptr_t handle) {
+  v8::Isolate* iso = static_cast<v8::Isolate*>(isolate);
+  void* data = reinterpret_cast<void*>(handle);
+  iso->AddGCEpilogueCallback(heapCollected, data, kAllGCTypes);
+  iso->AddNearHeapLimitCallback(nearHeapLimit, data);
+}
+
+void removeHeapCallbacks(void* isolate, uintptr_t handle) {
+  v8::Isolate* iso = static_cast<v8::Isolate*>(isolate);
+  iso->RemoveGCEpilogueCallback(heapCollected,
+                                reinterpret_cast<void*>(handle));
+  // Keep the heap limit, which is only raised for runs that get terminated
+  iso->RemoveNearHeapLimitCallback(nearHeapLimit, 0);
+}
@@ -0,0 +1,52 @@
+package events
+
+// #include "heap_limit.h"
+import "C"
+
+import (
+	"reflect"
+	"runtime/cgo"
+	"unsafe"
+
+	v8 "rogchap.com/v8go"
+)
+
+// heapCallbacks are the V8 callbacks enforcing the memory limit of one run.
+// V8 calls them on the isolate's own thread: after every garbage collection,
+// to check the heap against the limit, and when it is about to run out of
+// heap, to terminate the run instead of aborting the process.
+type heapCallbacks struct {
+	isolate unsafe.Pointer // The v8::Isolate
+	handle  cgo.Handle
+}
+
+// addHeapCallbacks registers the callbacks of a watchdog on isolate, which
+// must not be running
+func addHeapCallbacks(isolate *v8.Isolate, w *scriptWatchdog) *heapCallbacks {
+	h := &heapCallbacks{isolate: isolatePointer(isolate), handle: cgo.NewHandle(w)}
+	C.addHeapCallbacks(h.isolate, C.uintptr_t(h.handle))
+	return h
+}
+
+// remove removes the callbacks once the run has finished
+func (h *heapCallbacks) remove() {
+	C.removeHeapCallbacks(h.isolate, C.uintptr_t(h.handle))
+	h.handle.Delete()
+}
+
+// isolatePointer returns the v8::Isolate of isolate, which v8go keeps in an
+// unexported field
+func isolatePointer(isolate *v8.Isolate) unsafe.Pointer {
+	field := reflect.ValueOf(isolate).Elem().FieldByName("ptr")
+	return *(*unsafe.Pointer)(unsafe.Pointer(field.UnsafeAddr()))
+}
+
+//export heapCollectedCallback
+func heapCollectedCallback(handle C.uintptr_t) {
+	cgo.Handle(handle).Value().(*scriptWatchdog).checkHeap()
+}
+
+//export nearHeapLimitCallback
+func nearHeapLimitCallback(handle C.uintptr_t) {
+	cgo.Handle(handle).Value().(*scriptWatchdog).terminate("memory")
+}
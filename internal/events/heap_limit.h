@@ -0,0 +1,21 @@
+#ifndef DEPLOYD_HEAP_LIMIT_H
+#define DEPLOYD_HEAP_LIMIT_H
+
+#include <stdint.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+// addHeapCallbacks registers the callbacks that enforce the memory limit of
+// a script run on isolate (a v8::Isolate*); handle identifies the run
+void addHeapCallbacks(void* isolate, uintptr_t handle);
+
+// removeHeapCallbacks removes the callbacks registered for handle
+void removeHeapCallbacks(void* isolate, uintptr_t handle);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
@@ -0,0 +1,172 @@
+package events
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/logging"
+	"github.com/hjanuschka/go-deployd/internal/metrics"
+	v8 "rogchap.com/v8go"
+)
+
+const (
+	defaultScriptTimeout   = 30 * time.Second
+	defaultScriptMaxHeapMB = 256
+)
+
+// ScriptLimits bound the time and memory one run of a JavaScript event may
+// use. Scripts that exceed them are terminated.
+type ScriptLimits struct {
+	// Timeout is how many seconds a run may take, waiting for fetch
+	// included (default 30)
+	Timeout float64 `json:"timeout,omitempty"`
+	// MaxHeapMB is how many megabytes of heap a run may allocate (default 256)
+	MaxHeapMB int `json:"maxHeapMB,omitempty"`
+}
+
+// Validate checks the limits
+func (l *ScriptLimits) Validate() error {
+	if l == nil {
+		return nil
+	}
+	if l.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	if l.MaxHeapMB < 0 {
+		return fmt.Errorf("maxHeapMB must not be negative")
+	}
+	return nil
+}
+
+func (l *ScriptLimits) timeout() time.Duration {
+	if l == nil || l.Timeout == 0 {
+		return defaultScriptTimeout
+	}
+	return time.Duration(l.Timeout * float64(time.Second))
+}
+
+func (l *ScriptLimits) maxHeap() uint64 {
+	if l == nil || l.MaxHeapMB == 0 {
+		return defaultScriptMaxHeapMB << 20
+	}
+	return uint64(l.MaxHeapMB) << 20
+}
+
+// scriptWatchdog enforces the limits of one run. The time limit is watched
+// from its own goroutine; the memory limit is checked by V8 callbacks on the
+// isolate's thread (see heapCallbacks). Either terminates the isolate's
+// execution once exceeded.
+type scriptWatchdog struct {
+	isolate  *v8.Isolate
+	limits   *ScriptLimits
+	baseHeap uint64
+	maxHeap  uint64
+	heap     *heapCallbacks
+	stop     chan struct{}
+	done     chan struct{}
+	// expired is closed once a limit is exceeded, so the script's event loop
+	// stops waiting for fetches
+	expired  chan struct{}
+	mu       sync.Mutex
+	exceeded string // "time" or "memory"
+}
+
+// watchScript starts watching a run on isolate, which must not be running
+// yet
+func watchScript(isolate *v8.Isolate, limits *ScriptLimits) *scriptWatchdog {
+	heap := isolate.GetHeapStatistics()
+	w := &scriptWatchdog{
+		isolate:  isolate,
+		limits:   limits,
+		baseHeap: heap.UsedHeapSize,
+		maxHeap:  limits.maxHeap(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		expired:  make(chan struct{}),
+	}
+	// V8 can't grow the heap past its own limit, which is the limit then
+	if heap.HeapSizeLimit > heap.UsedHeapSize && heap.HeapSizeLimit-heap.UsedHeapSize < w.maxHeap {
+		w.maxHeap = heap.HeapSizeLimit - heap.UsedHeapSize
+	}
+	w.heap = addHeapCallbacks(isolate, w)
+	go w.run()
+	return w
+}
+
+func (w *scriptWatchdog) run() {
+	defer close(w.done)
+	timeout := time.NewTimer(w.limits.timeout())
+	defer timeout.Stop()
+
+	select {
+	case <-w.stop:
+	case <-timeout.C:
+		w.terminate("time")
+	}
+}
+
+// checkHeap terminates the run once its heap outgrew the limit. It is called
+// on the isolate's thread after every garbage collection.
+func (w *scriptWatchdog) checkHeap() {
+	used := w.isolate.GetHeapStatistics().UsedHeapSize
+	if used > w.baseHeap && used-w.baseHeap > w.maxHeap {
+		w.terminate("memory")
+	}
+}
+
+// terminate stops the run for exceeding limit, unless it was already
+// stopped for exceeding the other
+func (w *scriptWatchdog) terminate(limit string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.exceeded != "" {
+		return
+	}
+	w.exceeded = limit
+	w.isolate.TerminateExecution()
+	close(w.expired)
+}
+
+// Stop stops watching and returns the error to answer with if the run
+// exceeded a limit: 503 for the time limit, 500 for the memory limit. The
+// isolate must not be used again after a limit was exceeded.
+func (w *scriptWatchdog) Stop() *ScriptError {
+	close(w.stop)
+	<-w.done
+	w.heap.remove()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch w.exceeded {
+	case "time":
+		return &ScriptError{
+			Message:    fmt.Sprintf("script exceeded its time limit of %s", w.limits.timeout()),
+			StatusCode: http.StatusServiceUnavailable,
+		}
+	case "memory":
+		return &ScriptError{
+			Message:    fmt.Sprintf("script exceeded its memory limit of %d MB", w.maxHeap>>20),
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+	return nil
+}
+
+// report logs and records a run stopped for exceeding a limit
+func (w *scriptWatchdog) report(sc *ScriptContext, scriptPath string, err *ScriptError) {
+	var collection string
+	if sc.ctx != nil && sc.ctx.Resource != nil {
+		collection = sc.ctx.Resource.GetName()
+	}
+	script := filepath.Base(scriptPath)
+	logging.Error("JavaScript event terminated", "js-execution", map[string]interface{}{
+		"collection": collection,
+		"script":     script,
+		"limit":      w.exceeded,
+		"error":      err.Message,
+	})
+	metrics.RecordScriptLimit(collection, script, w.exceeded, err.Message)
+}
@@ -0,0 +1,124 @@
+package events_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/events"
+	"github.com/hjanuschka/go-deployd/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScriptLimits(t *testing.T) {
+	started := time.Now()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	tempDir := t.TempDir()
+	scripts := map[string]string{
+		"get.js":  `while (true) {}`,
+		"post.js": `var chunks = []; while (true) { chunks.push(new Array(100000).fill(chunks.length)); }`,
+		"put.js": `
+async function Run(context) {
+	await fetch(context.data.url);
+}
+`,
+		"delete.js": `context.data.ok = true;`,
+	}
+	for name, script := range scripts {
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, name), []byte(script), 0644))
+	}
+
+	manager := events.NewUniversalScriptManager()
+	require.NoError(t, manager.LoadScriptsWithConfig(tempDir, map[string]events.EventConfiguration{
+		"get": {Runtime: "js"}, "post": {Runtime: "js"}, "put": {Runtime: "js"}, "delete": {Runtime: "js"},
+	}))
+	manager.SetScriptLimits(&events.ScriptLimits{Timeout: 0.3, MaxHeapMB: 16})
+	manager.SetFetchConfig(&events.FetchConfig{AllowedHosts: []string{"127.0.0.1"}})
+
+	ctx := &context.Context{Method: "GET", Resource: namedResource("limitstest")}
+	run := func(event events.EventType, data map[string]interface{}) *events.ScriptError {
+		start := time.Now()
+		err := manager.RunEvent(event, ctx, data)
+		assert.Less(t, time.Since(start), 3*time.Second)
+		var scriptErr *events.ScriptError
+		require.True(t, errors.As(err, &scriptErr), "got %v", err)
+		return scriptErr
+	}
+
+	t.Run("terminates scripts that run too long", func(t *testing.T) {
+		scriptErr := run(events.EventGet, map[string]interface{}{})
+		assert.Equal(t, http.StatusServiceUnavailable, scriptErr.StatusCode)
+		assert.Contains(t, scriptErr.Message, "time limit")
+	})
+
+	t.Run("terminates scripts that wait too long for fetch", func(t *testing.T) {
+		scriptErr := run(events.EventPut, map[string]interface{}{"url": server.URL})
+		assert.Equal(t, http.StatusServiceUnavailable, scriptErr.StatusCode)
+	})
+
+	t.Run("terminates scripts that allocate too much", func(t *testing.T) {
+		scriptErr := run(events.EventPost, map[string]interface{}{})
+		assert.Equal(t, http.StatusInternalServerError, scriptErr.StatusCode)
+		assert.Contains(t, scriptErr.Message, "memory limit of 16 MB")
+	})
+
+	t.Run("runs other scripts after terminating", func(t *testing.T) {
+		for i := 0; i < 8; i++ {
+			data := map[string]interface{}{}
+			require.NoError(t, manager.RunEvent(events.EventDelete, ctx, data))
+			assert.Equal(t, true, data["ok"])
+		}
+	})
+
+	t.Run("records violations in the metrics", func(t *testing.T) {
+		limits := map[string]int{}
+		for _, metric := range metrics.GetGlobalCollector().GetDetailedMetricsByCollection("limitstest", started) {
+			if metric.Metadata["error_type"] == "script_limit" {
+				limits[metric.Metadata["limit"].(string)]++
+			}
+		}
+		assert.Equal(t, map[string]int{"time": 2, "memory": 1}, limits)
+	})
+}
+
+func TestScriptMemoryLimitAboveV8HeapLimit(t *testing.T) {
+	// The limit is higher than V8's own heap limit, so the script runs V8 out
+	// of heap, which aborts the process unless the run is terminated first
+	tempDir := t.TempDir()
+	script := `var chunks = []; while (true) { chunks.push(new Array(1 << 20).fill(chunks.length)); }`
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "post.js"), []byte(script), 0644))
+
+	manager := events.NewUniversalScriptManager()
+	require.NoError(t, manager.LoadScriptsWithConfig(tempDir, map[string]events.EventConfiguration{
+		"post": {Runtime: "js"},
+	}))
+	manager.SetScriptLimits(&events.ScriptLimits{Timeout: 60, MaxHeapMB: 64 << 10})
+
+	ctx := &context.Context{Method: "POST", Resource: namedResource("heaplimittest")}
+	err := manager.RunEvent(events.EventPost, ctx, map[string]interface{}{})
+	var scriptErr *events.ScriptError
+	require.True(t, errors.As(err, &scriptErr), "got %v", err)
+	assert.Equal(t, http.StatusInternalServerError, scriptErr.StatusCode)
+	assert.Contains(t, scriptErr.Message, "memory limit")
+
+	data := map[string]interface{}{}
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "put.js"), []byte(`context.data.ok = true;`), 0644))
+	require.NoError(t, manager.LoadScriptsWithConfig(tempDir, map[string]events.EventConfiguration{
+		"put": {Runtime: "js"},
+	}))
+	require.NoError(t, manager.RunEvent(events.EventPut, ctx, data))
+	assert.Equal(t, true, data["ok"])
+}
@@ -22,6 +22,10 @@ type UniversalScriptManager struct {
 	configPath       string
 	v8Pool           *V8Pool
 	realtimeEmitter  RealtimeEmitter
+	dpdProvider      DpdProvider
+	enqueuer         Enqueuer
+	fetchConfig      *FetchConfig
+	limits           *ScriptLimits
 	mu               sync.RWMutex
 }
 
@@ -87,83 +91,101 @@ func (usm *UniversalScriptManager) LoadScriptsWithConfig(configPath string, even
 		if config, exists := eventConfig[baseName]; exists && config.Runtime != "" {
 			preferredRuntime = config.Runtime
 		}
+		usm.loadEventScript(eventType, baseName, preferredRuntime)
+	}
 
-		logger := logging.GetLogger().WithComponent("events")
-		logger.Debug("Loading event script", logging.Fields{
-			"collection":    filepath.Base(configPath),
-			"event":         baseName,
-			"runtime":       preferredRuntime,
-			"config_exists": eventConfig[baseName],
-		})
+	return nil
+}
 
-		// Load only the configured runtime - no fallback
-		if preferredRuntime == "go" {
-			// Only try Go script - compile to plugin on startup
-			goPath := filepath.Join(configPath, baseName+".go")
-			if _, err := os.ReadFile(goPath); err == nil {
-				logger.Info("Compiling Go event script", logging.Fields{
-					"collection":  filepath.Base(configPath),
-					"script":      baseName + ".go",
+// LoadScript loads the script of a single event from baseName.js or
+// baseName.go in configPath, as resources other than collections have their
+// own scripts. It reports whether the script was loaded.
+func (usm *UniversalScriptManager) LoadScript(configPath string, eventType EventType, baseName, runtime string) bool {
+	usm.mu.Lock()
+	defer usm.mu.Unlock()
+
+	usm.configPath = configPath
+	os.MkdirAll(filepath.Join(configPath, ".plugins"), 0755)
+	usm.loadEventScript(eventType, baseName, runtime)
+	_, loaded := usm.scriptTypes[eventType]
+	return loaded
+}
+
+// loadEventScript loads the script of an event in the given runtime from
+// the config path. Callers hold usm.mu.
+func (usm *UniversalScriptManager) loadEventScript(eventType EventType, baseName, runtime string) {
+	logger := logging.GetLogger().WithComponent("events")
+	logger.Debug("Loading event script", logging.Fields{
+		"collection": filepath.Base(usm.configPath),
+		"event":      baseName,
+		"runtime":    runtime,
+	})
+
+	// Load only the configured runtime - no fallback
+	if runtime == "go" {
+		// Only try Go script - compile to plugin on startup
+		goPath := filepath.Join(usm.configPath, baseName+".go")
+		if _, err := os.ReadFile(goPath); err == nil {
+			logger.Info("Compiling Go event script", logging.Fields{
+				"collection":  filepath.Base(usm.configPath),
+				"script":      baseName + ".go",
+				"source_path": goPath,
+			})
+			// Compile Go script to plugin
+			pluginPath := filepath.Join(usm.configPath, ".plugins", baseName+".so")
+			if err := CompileGoPlugin(goPath, pluginPath); err != nil {
+				logger.Error("Failed to compile Go script", logging.Fields{
 					"source_path": goPath,
+					"error":       err.Error(),
+					"collection":  filepath.Base(usm.configPath),
+					"event":       baseName,
+				})
+				// Don't load this event script at all if Go compilation fails
+			} else {
+				logger.Info("Successfully compiled Go event script", logging.Fields{
+					"collection":  filepath.Base(usm.configPath),
+					"script":      baseName + ".go",
+					"plugin_path": pluginPath,
 				})
-				// Compile Go script to plugin
-				pluginPath := filepath.Join(configPath, ".plugins", baseName+".so")
-				if err := CompileGoPlugin(goPath, pluginPath); err != nil {
-					logger.Error("Failed to compile Go script", logging.Fields{
-						"source_path": goPath,
-						"error":       err.Error(),
-						"collection":  filepath.Base(configPath),
+				usm.goPlugins[eventType] = &CompiledGoScript{
+					SourcePath:   goPath,
+					PluginPath:   pluginPath,
+					LastModified: 0, // Not used for startup compilation
+				}
+				usm.scriptTypes[eventType] = ScriptTypeGo
+			}
+		}
+		// If no .go file exists, that's fine - just don't load any script for this event
+	} else {
+		// Only try JavaScript
+		jsPath := filepath.Join(usm.configPath, baseName+".js")
+		if content, err := os.ReadFile(jsPath); err == nil {
+			script := &Script{
+				source: string(content),
+				path:   jsPath,
+			}
+
+			// Pre-compile the script in V8 pool for better performance
+			if usm.v8Pool != nil {
+				if precompileErr := usm.v8Pool.PrecompileScript(jsPath, string(content)); precompileErr != nil {
+					// Log error but continue - fallback to runtime compilation
+					logger.Warn("Failed to precompile JavaScript", logging.Fields{
+						"script_path": jsPath,
+						"error":       precompileErr.Error(),
+						"collection":  filepath.Base(usm.configPath),
 						"event":       baseName,
 					})
-					// Don't load this event script at all if Go compilation fails
 				} else {
-					logger.Info("Successfully compiled Go event script", logging.Fields{
-						"collection":  filepath.Base(configPath),
-						"script":      baseName + ".go",
-						"plugin_path": pluginPath,
-					})
-					usm.goPlugins[eventType] = &CompiledGoScript{
-						SourcePath:   goPath,
-						PluginPath:   pluginPath,
-						LastModified: 0, // Not used for startup compilation
-					}
-					usm.scriptTypes[eventType] = ScriptTypeGo
+					// Mark script as compiled for optimized execution
+					script.isPrecompiled = true
 				}
 			}
-			// If no .go file exists, that's fine - just don't load any script for this event
-		} else {
-			// Only try JavaScript
-			jsPath := filepath.Join(configPath, baseName+".js")
-			if content, err := os.ReadFile(jsPath); err == nil {
-				script := &Script{
-					source: string(content),
-					path:   jsPath,
-				}
 
-				// Pre-compile the script in V8 pool for better performance
-				if usm.v8Pool != nil {
-					if precompileErr := usm.v8Pool.PrecompileScript(jsPath, string(content)); precompileErr != nil {
-						// Log error but continue - fallback to runtime compilation
-						logger.Warn("Failed to precompile JavaScript", logging.Fields{
-							"script_path": jsPath,
-							"error":       precompileErr.Error(),
-							"collection":  filepath.Base(configPath),
-							"event":       baseName,
-						})
-					} else {
-						// Mark script as compiled for optimized execution
-						script.isPrecompiled = true
-					}
-				}
-
-				usm.jsScripts[eventType] = script
-				usm.scriptTypes[eventType] = ScriptTypeJS
-			}
-			// If no .js file exists, that's fine - just don't load any script for this event
+			usm.jsScripts[eventType] = script
+			usm.scriptTypes[eventType] = ScriptTypeJS
 		}
+		// If no .js file exists, that's fine - just don't load any script for this event
 	}
-
-	return nil
 }
 
 // loadGoScript compiles and loads a Go script
@@ -244,8 +266,17 @@ func (usm *UniversalScriptManager) RunEvent(eventType EventType, ctx *context.Co
 		return nil // No script for this event
 	}
 
+	var dpd Dpd
+	if usm.dpdProvider != nil {
+		dpd = usm.dpdProvider.Dpd(ctx)
+	}
+	enqueuer := usm.enqueuer
+	fetchConfig := usm.fetchConfig
+	limits := usm.limits
+
 	var err error
 	var runtime string
+	var fetches []metrics.FetchCall
 
 	switch scriptType {
 	case ScriptTypeGo:
@@ -268,7 +299,7 @@ func (usm *UniversalScriptManager) RunEvent(eventType EventType, ctx *context.Co
 
 		// Use compiled plugin for Go scripts
 		if goScript != nil {
-			err = RunGoPluginWithEmitter(goScript.PluginPath, ctx, data, usm.realtimeEmitter)
+			err = runGoPluginWithDpd(goScript.PluginPath, ctx, data, usm.realtimeEmitter, dpd, enqueuer)
 			logging.Debug("🔧 GO PLUGIN EXECUTION RESULT", "event", map[string]interface{}{
 				"eventType": string(eventType),
 				"error":     err,
@@ -291,7 +322,7 @@ func (usm *UniversalScriptManager) RunEvent(eventType EventType, ctx *context.Co
 			"hasScript":  jsScript != nil,
 		})
 
-		err = usm.runJSScript(jsScript, ctx, data)
+		fetches, err = usm.runJSScript(jsScript, ctx, data, dpd, enqueuer, fetchConfig, limits)
 
 	default:
 		usm.mu.RUnlock()
@@ -306,7 +337,7 @@ func (usm *UniversalScriptManager) RunEvent(eventType EventType, ctx *context.Co
 	duration := time.Since(startTime)
 
 	// Record hook execution metrics
-	metrics.RecordHookExecution(collectionName, string(eventType), duration, err)
+	metrics.RecordHookExecution(collectionName, string(eventType), duration, err, fetches...)
 
 	// Log event completion with timing
 	if err != nil {
@@ -337,11 +368,14 @@ func (usm *UniversalScriptManager) runGoPlugin(script *CompiledGoScript, ctx *co
 	return RunGoPluginWithEmitter(script.PluginPath, ctx, data, usm.realtimeEmitter)
 }
 
-// runJSScript executes a JavaScript script
-func (usm *UniversalScriptManager) runJSScript(script *Script, ctx *context.Context, data map[string]interface{}) error {
-	scriptCtx, err := script.Run(ctx, data)
+// runJSScript executes a JavaScript script, returning the fetch calls it made
+func (usm *UniversalScriptManager) runJSScript(script *Script, ctx *context.Context, data map[string]interface{}, dpd Dpd, enqueuer Enqueuer, fetchConfig *FetchConfig, limits *ScriptLimits) ([]metrics.FetchCall, error) {
+	scriptCtx, err := script.RunWithLimits(ctx, data, dpd, enqueuer, fetchConfig, limits)
+	if scriptCtx == nil {
+		return nil, err
+	}
 	if err != nil {
-		return err
+		return scriptCtx.FetchCalls(), err
 	}
 
 	// Copy modified data back to original data parameter
@@ -349,7 +383,7 @@ func (usm *UniversalScriptManager) runJSScript(script *Script, ctx *context.Cont
 		data[key] = value
 	}
 
-	return scriptCtx.GetError()
+	return scriptCtx.FetchCalls(), scriptCtx.GetError()
 }
 
 // GetScriptInfo returns information about loaded scripts
@@ -455,3 +489,33 @@ func (usm *UniversalScriptManager) SetRealtimeEmitter(emitter RealtimeEmitter) {
 	defer usm.mu.Unlock()
 	usm.realtimeEmitter = emitter
 }
+
+// SetDpdProvider sets the provider for the dpd client available to event scripts
+func (usm *UniversalScriptManager) SetDpdProvider(provider DpdProvider) {
+	usm.mu.Lock()
+	defer usm.mu.Unlock()
+	usm.dpdProvider = provider
+}
+
+// SetEnqueuer sets the enqueuer behind context.enqueue in event scripts
+func (usm *UniversalScriptManager) SetEnqueuer(enqueuer Enqueuer) {
+	usm.mu.Lock()
+	defer usm.mu.Unlock()
+	usm.enqueuer = enqueuer
+}
+
+// SetFetchConfig sets the hosts and limits of fetch in JavaScript event
+// scripts; without a config, fetch rejects every request
+func (usm *UniversalScriptManager) SetFetchConfig(config *FetchConfig) {
+	usm.mu.Lock()
+	defer usm.mu.Unlock()
+	usm.fetchConfig = config
+}
+
+// SetScriptLimits sets the time and memory limits of JavaScript event
+// scripts; without limits, the defaults apply
+func (usm *UniversalScriptManager) SetScriptLimits(limits *ScriptLimits) {
+	usm.mu.Lock()
+	defer usm.mu.Unlock()
+	usm.limits = limits
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -35,6 +36,8 @@ const (
 	EventDelete        EventType = "Delete"
 	EventAfterCommit   EventType = "AfterCommit"
 	EventBeforeRequest EventType = "BeforeRequest"
+	// EventRun is the script of a cron job
+	EventRun EventType = "Run"
 )
 
 // ScriptManager manages event scripts for a collection using V8 (compatible with goja interface)
@@ -96,18 +99,51 @@ type ScriptContext struct {
 	cancelled  bool
 	cancelMsg  string
 	statusCode int
+	dpd        Dpd
+	enqueuer   Enqueuer
+	fetch      *fetchLoop
+	limits     *ScriptLimits
 }
 
 // Run executes the script in the given context using V8 (compatible with goja interface)
 func (s *Script) Run(ctx *context.Context, data bson.M) (*ScriptContext, error) {
+	return s.RunWithDpd(ctx, data, nil)
+}
+
+// RunWithDpd executes the script with a dpd client for accessing other collections
+func (s *Script) RunWithDpd(ctx *context.Context, data bson.M, dpd Dpd) (*ScriptContext, error) {
+	return s.RunWithEnqueuer(ctx, data, dpd, nil)
+}
+
+// RunWithEnqueuer executes the script with a dpd client and context.enqueue
+// for queueing background jobs
+func (s *Script) RunWithEnqueuer(ctx *context.Context, data bson.M, dpd Dpd, enqueuer Enqueuer) (*ScriptContext, error) {
+	return s.RunWithFetch(ctx, data, dpd, enqueuer, nil)
+}
+
+// RunWithFetch executes the script with a dpd client, context.enqueue and a
+// fetch limited to what fetchConfig allows
+func (s *Script) RunWithFetch(ctx *context.Context, data bson.M, dpd Dpd, enqueuer Enqueuer, fetchConfig *FetchConfig) (*ScriptContext, error) {
+	return s.RunWithLimits(ctx, data, dpd, enqueuer, fetchConfig, nil)
+}
+
+// RunWithLimits executes the script like RunWithFetch, terminating it once it
+// exceeds limits, or the default limits if nil. A script that exceeds them
+// fails with a *ScriptError.
+func (s *Script) RunWithLimits(ctx *context.Context, data bson.M, dpd Dpd, enqueuer Enqueuer, fetchConfig *FetchConfig, limits *ScriptLimits) (*ScriptContext, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	scriptCtx := &ScriptContext{
-		ctx:    ctx,
-		data:   data,
-		errors: make(map[string]string),
+		ctx:      ctx,
+		data:     data,
+		errors:   make(map[string]string),
+		dpd:      dpd,
+		enqueuer: enqueuer,
+		fetch:    newFetchLoop(ctx, fetchConfig),
+		limits:   limits,
 	}
+	defer scriptCtx.fetch.close()
 
 	// Use V8 pool if script is precompiled for better performance
 	if s.isPrecompiled {
@@ -128,7 +164,14 @@ func (s *Script) runWithPool(scriptCtx *ScriptContext) (*ScriptContext, error) {
 
 	// Acquire a context from the pool with timeout
 	acquireStart := time.Now()
-	eventCtx, err := pool.AcquireContext(5 * time.Second)
+	var eventCtx *V8EventContext
+	var err error
+	if scriptCtx.ctx.Internal {
+		// Scripts nested through dpd must not wait for contexts held by their callers
+		eventCtx, err = pool.TryAcquireContext()
+	} else {
+		eventCtx, err = pool.AcquireContext(5 * time.Second)
+	}
 	acquireTime := time.Since(acquireStart)
 	if err != nil {
 		logging.Debug("Failed to acquire V8 context from pool, falling back", "js-execution", map[string]interface{}{
@@ -144,8 +187,16 @@ func (s *Script) runWithPool(scriptCtx *ScriptContext) (*ScriptContext, error) {
 	})
 
 	executeStart := time.Now()
+	watchdog := watchScript(eventCtx.isolate, scriptCtx.limits)
+	scriptCtx.fetch.expired = watchdog.expired
 	poolErr := pool.ExecuteScript(eventCtx, s.path, scriptCtx)
 	executeTime := time.Since(executeStart)
+	if limitErr := watchdog.Stop(); limitErr != nil {
+		// The isolate was terminated and may hold the script's garbage
+		pool.replaceIsolate(eventCtx)
+		watchdog.report(scriptCtx, s.path, limitErr)
+		return scriptCtx, limitErr
+	}
 
 	// Log detailed timing
 	logging.Info("JavaScript execution timing", "js-timing", map[string]interface{}{
@@ -206,6 +257,8 @@ func (s *Script) runTraditional(scriptCtx *ScriptContext) (*ScriptContext, error
 	})
 
 	executeStart := time.Now()
+	watchdog := watchScript(isolate, scriptCtx.limits)
+	scriptCtx.fetch.expired = watchdog.expired
 	var err error
 	if s.compiled != nil {
 		_, err = s.compiled.Run(v8ctx)
@@ -215,24 +268,14 @@ func (s *Script) runTraditional(scriptCtx *ScriptContext) (*ScriptContext, error
 
 	// After initial script execution, check for Run() function and call it
 	if err == nil {
-		runFunc, runErr := v8ctx.Global().Get("Run")
-		if runErr == nil && runFunc != nil && runFunc.IsFunction() {
-			// Call Run(context) function with context object
-			contextObj, contextErr := v8ctx.Global().Get("context")
-			if contextErr == nil && contextObj != nil {
-				logging.Debug("Calling JavaScript Run(context) function", "js-execution", map[string]interface{}{
-					"hasRun":     true,
-					"hasContext": true,
-				})
-				runFuncObj, err := runFunc.AsFunction()
-				if err == nil {
-					_, err = runFuncObj.Call(v8ctx.Global(), contextObj)
-				}
-			}
-		}
+		err = callRun(v8ctx, scriptCtx)
 	}
 
 	executeTime := time.Since(executeStart)
+	if limitErr := watchdog.Stop(); limitErr != nil {
+		watchdog.report(scriptCtx, s.path, limitErr)
+		return scriptCtx, limitErr
+	}
 
 	if err != nil {
 		// Check if it's a cancellation (our custom exception)
@@ -276,6 +319,31 @@ func (s *Script) runTraditional(scriptCtx *ScriptContext) (*ScriptContext, error
 	return scriptCtx, nil
 }
 
+// callRun calls the Run(context) function of a script that has one, then
+// waits for the fetches the script started and the promise of an async Run
+func callRun(v8ctx *v8.Context, sc *ScriptContext) error {
+	var result *v8.Value
+	runFunc, err := v8ctx.Global().Get("Run")
+	if err == nil && runFunc != nil && runFunc.IsFunction() {
+		contextObj, err := v8ctx.Global().Get("context")
+		if err != nil {
+			return err
+		}
+		logging.Debug("Calling JavaScript Run(context) function", "js-execution", map[string]interface{}{
+			"hasRun":     true,
+			"hasContext": true,
+		})
+		runFuncObj, err := runFunc.AsFunction()
+		if err != nil {
+			return err
+		}
+		if result, err = runFuncObj.Call(v8ctx.Global(), contextObj); err != nil {
+			return err
+		}
+	}
+	return sc.fetch.settle(v8ctx, result)
+}
+
 // extractModifiedData extracts the modified data object from V8 back to Go
 func extractModifiedData(v8ctx *v8.Context, sc *ScriptContext) error {
 	// Start with original data
@@ -324,13 +392,17 @@ func clearV8Context(v8ctx *v8.Context) error {
 	// Keep core V8 globals but clear application data
 	propertiesToClear := []string{
 		// Data objects
-		"data", "context",
+		"data", "context", "dpd",
+		// Run(context) of the previous script, which scripts without one would call
+		"Run",
 		// Common data fields that might leak
 		"id", "title", "description", "completed", "priority", "createdAt", "updatedAt",
 		"status", "formattedDate", "processedBy", "processedAt", "priorityLabel",
 		// NoStore collection fields  
 		"parts", "url", "operation", "operands", "result", "error", "usage",
 		"test", "timestamp", "cancelled", "event", "test_run_pattern",
+		// Endpoint handler fields
+		"method", "path", "params", "query", "headers", "body", "rawBody", "response",
 	}
 	
 	// Delete each property from global context
@@ -366,6 +438,10 @@ func setupV8Environment(v8ctx *v8.Context, sc *ScriptContext) error {
 		return err
 	}
 
+	if err := setupFetchFunction(v8ctx, sc); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -588,6 +664,7 @@ func setupContextObject(v8ctx *v8.Context, sc *ScriptContext) error {
 			"id":       sc.ctx.UserID,
 			"username": sc.ctx.Username,
 			"isRoot":   sc.ctx.IsRoot,
+			"roles":    append([]string{}, sc.ctx.Roles...),
 		}
 		userJSON, _ := json.Marshal(userData)
 		meValue, _ = v8.JSONParse(v8ctx, string(userJSON))
@@ -605,6 +682,23 @@ func setupContextObject(v8ctx *v8.Context, sc *ScriptContext) error {
 			contextInstance.Set("query", queryValue)
 		}
 	}
+
+	internalValue, _ := v8.NewValue(isolate, sc.ctx.Internal)
+	contextInstance.Set("internal", internalValue)
+
+	// Add dpd client for accessing other collections
+	if sc.dpd != nil {
+		if err := setupDpdObject(v8ctx, contextInstance, sc.dpd); err != nil {
+			return err
+		}
+	}
+
+	// Add enqueue for handing work off to background job queues
+	if sc.enqueuer != nil {
+		if err := setupEnqueueFunction(v8ctx, contextInstance, sc.enqueuer, sc.ctx); err != nil {
+			return err
+		}
+	}
 	
 	// Set the context object as global
 	v8ctx.Global().Set("context", contextInstance)
@@ -925,9 +1019,15 @@ type ValidationError struct {
 }
 
 func (e *ValidationError) Error() string {
-	parts := make([]string, 0, len(e.Errors))
-	for field, msg := range e.Errors {
-		parts = append(parts, fmt.Sprintf("%s: %s", field, msg))
+	fields := make([]string, 0, len(e.Errors))
+	for field := range e.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		parts = append(parts, fmt.Sprintf("%s: %s", field, e.Errors[field]))
 	}
 	return "validation errors: " + strings.Join(parts, ", ")
 }
@@ -173,6 +173,22 @@ func (pool *V8Pool) AcquireContext(timeout time.Duration) (*V8EventContext, erro
 	}
 }
 
+// TryAcquireContext gets an available V8 context without waiting
+func (pool *V8Pool) TryAcquireContext() (*V8EventContext, error) {
+	if pool.isShutdown {
+		return nil, fmt.Errorf("V8 pool is shut down")
+	}
+
+	select {
+	case eventCtx := <-pool.available:
+		eventCtx.inUse = true
+		eventCtx.lastUsed = time.Now()
+		return eventCtx, nil
+	default:
+		return nil, fmt.Errorf("no V8 context available")
+	}
+}
+
 // ReleaseContext returns a V8 context to the pool for reuse
 func (pool *V8Pool) ReleaseContext(eventCtx *V8EventContext) {
 	if pool.isShutdown {
@@ -220,6 +236,31 @@ func (pool *V8Pool) recreateContext(eventCtx *V8EventContext) {
 	})
 }
 
+// replaceIsolate gives a context a fresh isolate in place of one whose script
+// was terminated for exceeding its limits
+func (pool *V8Pool) replaceIsolate(eventCtx *V8EventContext) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	old := eventCtx.isolate
+	eventCtx.context.Close()
+	for _, byIsolate := range pool.compiled {
+		delete(byIsolate, old)
+	}
+	old.Dispose()
+
+	eventCtx.isolate = v8.NewIsolate()
+	eventCtx.context = v8.NewContext(eventCtx.isolate)
+	eventCtx.execCount = 0
+	for i, isolate := range pool.isolates {
+		if isolate == old {
+			pool.isolates[i] = eventCtx.isolate
+		}
+	}
+
+	logging.Info("Replaced V8 isolate of a terminated script", "v8-pool", nil)
+}
+
 // resetContext clears the context state for reuse
 func (pool *V8Pool) resetContext(eventCtx *V8EventContext) {
 	// Get all global property names to clear user-defined variables
@@ -228,7 +269,7 @@ func (pool *V8Pool) resetContext(eventCtx *V8EventContext) {
 	// Clear known deployd globals (only context and data, no global functions)
 	deployGlobals := []string{
 		"data", "context", "previous", "internal", 
-		"cancelled", "hide", "protect",
+		"cancelled", "hide", "protect", "dpd",
 	}
 	for _, global := range deployGlobals {
 		globalObj.Delete(global)
@@ -304,25 +345,8 @@ func (pool *V8Pool) ExecuteScript(eventCtx *V8EventContext, filePath string, scr
 	}
 
 	// After initial script execution, check for Run() function and call it
-	runFunc, runErr := eventCtx.context.Global().Get("Run")
-	if runErr == nil && runFunc != nil && runFunc.IsFunction() {
-		// Call Run(context) function with context object
-		contextObj, contextErr := eventCtx.context.Global().Get("context")
-		if contextErr == nil && contextObj != nil {
-			logging.Debug("Calling JavaScript Run(context) function (V8 pool)", "js-execution", map[string]interface{}{
-				"hasRun":     true,
-				"hasContext": true,
-			})
-			runFuncObj, err := runFunc.AsFunction()
-			if err == nil {
-				_, err = runFuncObj.Call(eventCtx.context.Global(), contextObj)
-				if err != nil {
-					logging.Debug("Run(context) function execution failed", "js-execution", map[string]interface{}{
-						"error": err.Error(),
-					})
-				}
-			}
-		}
+	if err := callRun(eventCtx.context, scriptCtx); err != nil {
+		return err
 	}
 
 	// Extract modified data back from JavaScript
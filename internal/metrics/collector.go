@@ -65,6 +65,7 @@ type Collector struct {
 	dataPath        string
 	lastFlush       time.Time
 	flushInterval   time.Duration
+	statsSources    map[string]func() interface{} // Extra system stats, by key
 }
 
 func NewCollector() *Collector {
@@ -78,6 +79,7 @@ func NewCollector() *Collector {
 		dataPath:        "resources/metrics.json",
 		flushInterval:   5 * time.Minute, // Flush every 5 minutes
 		lastFlush:       time.Now(),
+		statsSources:    make(map[string]func() interface{}),
 	}
 
 	// Load existing data on startup
@@ -539,7 +541,7 @@ func (c *Collector) GetSystemStats() map[string]interface{} {
 		}
 	}
 
-	return map[string]interface{}{
+	stats := map[string]interface{}{
 		"uptime_seconds":  uptime.Seconds(),
 		"total_metrics":   len(c.detailedMetrics),
 		"hourly_requests": hourlyCount,
@@ -553,6 +555,19 @@ func (c *Collector) GetSystemStats() map[string]interface{} {
 		"collections":        len(c.GetCollections()),
 		"event_types":        len(c.eventMetrics),
 	}
+	for key, source := range c.statsSources {
+		stats[key] = source()
+	}
+	return stats
+}
+
+// SetStatsSource adds the value returned by source to the system stats
+// under key. Sources are called with the collector locked, so they must not
+// record metrics.
+func (c *Collector) SetStatsSource(key string, source func() interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statsSources[key] = source
 }
 
 func generateID() string {
@@ -51,6 +51,11 @@ func (rw *responseWriter) Flush() {
 	}
 }
 
+// Unwrap returns the wrapped ResponseWriter, for http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // CloseNotify implements the http.CloseNotifier interface (deprecated but sometimes still needed)
 func (rw *responseWriter) CloseNotify() <-chan bool {
 	if notifier, ok := rw.ResponseWriter.(http.CloseNotifier); ok {
@@ -109,7 +114,18 @@ func RecordDatabaseOperation(operation string, duration time.Duration, err error
 	globalCollector.RecordMetric(metric)
 }
 
-func RecordHookExecution(collection, event string, duration time.Duration, err error) {
+// FetchCall is an HTTP request an event script made with fetch
+type FetchCall struct {
+	Method     string `json:"method"`
+	Host       string `json:"host"`
+	Status     int    `json:"status,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// RecordHookExecution records a run of an event script, with the fetch
+// calls it made
+func RecordHookExecution(collection, event string, duration time.Duration, err error, fetches ...FetchCall) {
 	metric := Metric{
 		Type:     HookMetric,
 		Duration: duration,
@@ -121,6 +137,11 @@ func RecordHookExecution(collection, event string, duration time.Duration, err e
 		},
 	}
 
+	if len(fetches) > 0 {
+		metric.Metadata["fetches"] = fetches
+		metric.Metadata["fetch_count"] = len(fetches)
+	}
+
 	if err != nil {
 		metric.Error = err.Error()
 	}
@@ -128,6 +149,24 @@ func RecordHookExecution(collection, event string, duration time.Duration, err e
 	globalCollector.RecordMetric(metric)
 }
 
+// RecordScriptLimit records an event script that was terminated for
+// exceeding its time or memory limit
+func RecordScriptLimit(collection, script, limit, message string) {
+	metric := Metric{
+		Type:  ErrorMetric,
+		Path:  fmt.Sprintf("/%s", collection),
+		Error: message,
+		Metadata: map[string]interface{}{
+			"error_type": "script_limit",
+			"collection": collection,
+			"script":     script,
+			"limit":      limit,
+		},
+	}
+
+	globalCollector.RecordMetric(metric)
+}
+
 func RecordError(errorType string, message string) {
 	metric := Metric{
 		Type:  ErrorMetric,
@@ -168,56 +168,6 @@ func (rb *RedisBroker) IsConnected() bool {
 	return false
 }
 
-// RabbitMQBroker implements a RabbitMQ-based message broker
-type RabbitMQBroker struct {
-	config *config.RabbitConfig
-	conn   interface{} // RabbitMQ connection (would be *amqp.Connection in real implementation)
-	mu     sync.RWMutex
-}
-
-// NewRabbitMQBroker creates a new RabbitMQ message broker
-func NewRabbitMQBroker(config *config.RabbitConfig) *RabbitMQBroker {
-	return &RabbitMQBroker{
-		config: config,
-	}
-}
-
-func (rmq *RabbitMQBroker) Connect(ctx context.Context) error {
-	// TODO: Implement RabbitMQ connection
-	// This would require adding RabbitMQ dependency (github.com/streadway/amqp)
-	logging.Info("RabbitMQ broker would connect here", "realtime", map[string]interface{}{
-		"host":     rmq.config.Host,
-		"port":     rmq.config.Port,
-		"exchange": rmq.config.Exchange,
-	})
-	return fmt.Errorf("RabbitMQ broker not implemented yet - add amqp dependency first")
-}
-
-func (rmq *RabbitMQBroker) Disconnect() error {
-	// TODO: Implement RabbitMQ disconnection
-	return nil
-}
-
-func (rmq *RabbitMQBroker) Publish(topic string, message *BrokerMessage) error {
-	// TODO: Implement RabbitMQ publish
-	return nil
-}
-
-func (rmq *RabbitMQBroker) Subscribe(topic string, handler MessageHandler) error {
-	// TODO: Implement RabbitMQ subscribe
-	return nil
-}
-
-func (rmq *RabbitMQBroker) Unsubscribe(topic string) error {
-	// TODO: Implement RabbitMQ unsubscribe
-	return nil
-}
-
-func (rmq *RabbitMQBroker) IsConnected() bool {
-	// TODO: Check RabbitMQ connection status
-	return false
-}
-
 // BrokerFactory creates message brokers based on configuration
 func NewMessageBroker(config *config.RealtimeConfig) (MessageBroker, error) {
 	if !config.Broker.Enabled {
@@ -231,6 +181,8 @@ func NewMessageBroker(config *config.RealtimeConfig) (MessageBroker, error) {
 		return NewRedisBroker(&config.Broker.Redis), nil
 	case "rabbitmq":
 		return NewRabbitMQBroker(&config.Broker.RabbitMQ), nil
+	case "nats":
+		return NewNATSBroker(&config.Broker.NATS), nil
 	default:
 		return nil, fmt.Errorf("unsupported broker type: %s", config.Broker.Type)
 	}
@@ -242,4 +194,5 @@ const (
 	TopicUserEvents        = "user_events"
 	TopicSystemEvents      = "system_events"
 	TopicCustomEvents      = "custom_events"
+	TopicPresence          = "presence"
 )
\ No newline at end of file
@@ -0,0 +1,202 @@
+//go:build integration
+
+package realtime
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// These tests run against real brokers with `go test -tags integration`.
+// The RabbitMQ tests use the RABBITMQ_HOST, RABBITMQ_PORT, RABBITMQ_USER,
+// RABBITMQ_PASS and RABBITMQ_VHOST environment variables and the NATS tests
+// NATS_HOST and NATS_PORT. Tests are skipped when the host is not set.
+
+// brokerProxy forwards connections to a broker, so tests can drop them as a
+// broker restart would
+type brokerProxy struct {
+	listener net.Listener
+	target   string
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newBrokerProxy(t *testing.T, target string) *brokerProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	proxy := &brokerProxy{listener: listener, target: target}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go proxy.forward(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		proxy.dropClients()
+	})
+	return proxy
+}
+
+func (p *brokerProxy) port() int {
+	return p.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (p *brokerProxy) forward(client net.Conn) {
+	server, err := net.Dial("tcp", p.target)
+	if err != nil {
+		client.Close()
+		return
+	}
+	p.mu.Lock()
+	p.conns = append(p.conns, client, server)
+	p.mu.Unlock()
+	go func() {
+		io.Copy(server, client)
+		server.Close()
+	}()
+	io.Copy(client, server)
+	client.Close()
+}
+
+// dropClients closes every forwarded connection
+func (p *brokerProxy) dropClients() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+}
+
+func brokerEnv(t *testing.T, prefix string, defaultPort int) (string, int) {
+	host := os.Getenv(prefix + "_HOST")
+	if host == "" {
+		t.Skip(prefix + "_HOST not set, skipping broker tests")
+	}
+	port := defaultPort
+	if envPort := os.Getenv(prefix + "_PORT"); envPort != "" {
+		if p, err := strconv.Atoi(envPort); err == nil {
+			port = p
+		}
+	}
+	return host, port
+}
+
+func TestRabbitMQBrokerIntegration(t *testing.T) {
+	host, port := brokerEnv(t, "RABBITMQ", 5672)
+	proxy := newBrokerProxy(t, net.JoinHostPort(host, strconv.Itoa(port)))
+	cfg := &config.RabbitConfig{
+		Host:     "127.0.0.1",
+		Port:     proxy.port(),
+		Username: os.Getenv("RABBITMQ_USER"),
+		Password: os.Getenv("RABBITMQ_PASS"),
+		VHost:    os.Getenv("RABBITMQ_VHOST"),
+		Exchange: "deployd_test",
+	}
+	if cfg.Username == "" {
+		cfg.Username, cfg.Password = "guest", "guest"
+	}
+
+	testNetworkBroker(t, proxy, func() networkBroker { return NewRabbitMQBroker(cfg) })
+
+	t.Run("refuses wrong credentials", func(t *testing.T) {
+		wrong := *cfg
+		wrong.Password = "wrong"
+		broker := NewRabbitMQBroker(&wrong)
+		err := broker.Connect(context.Background())
+		defer broker.Disconnect()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "ACCESS_REFUSED")
+		assert.False(t, broker.IsConnected())
+	})
+}
+
+func TestNATSBrokerIntegration(t *testing.T) {
+	host, port := brokerEnv(t, "NATS", 4222)
+	proxy := newBrokerProxy(t, net.JoinHostPort(host, strconv.Itoa(port)))
+	cfg := &config.NATSConfig{Host: "127.0.0.1", Port: proxy.port(), Subject: "deployd_test"}
+
+	testNetworkBroker(t, proxy, func() networkBroker { return NewNATSBroker(cfg) })
+}
+
+type networkBroker interface {
+	MessageBroker
+	healthReporter
+}
+
+// testNetworkBroker checks that messages published by one broker reach
+// another, also after the broker connections are dropped
+func testNetworkBroker(t *testing.T, proxy *brokerProxy, newBroker func() networkBroker) {
+	publisher := newBroker()
+	require.NoError(t, publisher.Connect(context.Background()))
+	defer publisher.Disconnect()
+
+	subscriber := newBroker()
+	handler, messages := receiver()
+	require.NoError(t, subscriber.Subscribe(TopicCustomEvents, handler), "subscribing before connecting")
+	require.NoError(t, subscriber.Connect(context.Background()))
+	defer subscriber.Disconnect()
+	assert.True(t, subscriber.IsConnected())
+
+	t.Run("delivers published messages", func(t *testing.T) {
+		require.NoError(t, publisher.Publish(TopicCustomEvents, &BrokerMessage{Event: "hello", Room: "chat", ServerID: "a"}))
+		message := nextBrokerMessage(t, messages)
+		assert.Equal(t, "hello", message.Event)
+		assert.Equal(t, "chat", message.Room)
+		assert.Equal(t, "a", message.ServerID)
+
+		require.NoError(t, publisher.Publish(TopicCollectionChanges, &BrokerMessage{Event: "other topic"}))
+		require.NoError(t, publisher.Publish(TopicCustomEvents, &BrokerMessage{Event: "second"}))
+		assert.Equal(t, "second", nextBrokerMessage(t, messages).Event, "only subscribed topics are delivered")
+	})
+
+	t.Run("delivers large messages", func(t *testing.T) {
+		text := strings.Repeat("x", 512*1024)
+		require.NoError(t, publisher.Publish(TopicCustomEvents, &BrokerMessage{Event: "large", Data: text}))
+		message := nextBrokerMessage(t, messages)
+		assert.Equal(t, "large", message.Event)
+		assert.Equal(t, text, message.Data)
+	})
+
+	t.Run("reconnects and subscribes again", func(t *testing.T) {
+		proxy.dropClients()
+		require.Eventually(t, func() bool {
+			return subscriber.Health().Reconnects == 1 && subscriber.IsConnected() && publisher.IsConnected()
+		}, 10*time.Second, 10*time.Millisecond)
+		assert.NotEmpty(t, subscriber.Health().LastError)
+
+		require.NoError(t, publisher.Publish(TopicCustomEvents, &BrokerMessage{Event: "after reconnect"}))
+		assert.Equal(t, "after reconnect", nextBrokerMessage(t, messages).Event)
+	})
+
+	t.Run("unsubscribes", func(t *testing.T) {
+		require.NoError(t, subscriber.Unsubscribe(TopicCustomEvents))
+		require.NoError(t, subscriber.Subscribe(TopicCollectionChanges, handler))
+
+		require.NoError(t, publisher.Publish(TopicCustomEvents, &BrokerMessage{Event: "dropped"}))
+		require.NoError(t, publisher.Publish(TopicCollectionChanges, &BrokerMessage{Event: "last"}))
+		assert.Equal(t, "last", nextBrokerMessage(t, messages).Event)
+	})
+
+	t.Run("fails to publish once disconnected", func(t *testing.T) {
+		require.NoError(t, publisher.Disconnect())
+		assert.False(t, publisher.IsConnected())
+		assert.ErrorIs(t, publisher.Publish(TopicCustomEvents, &BrokerMessage{}), errBrokerDisconnected)
+	})
+}
@@ -0,0 +1,94 @@
+package realtime
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/auth"
+	"github.com/hjanuschka/go-deployd/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHubsShareMessagesThroughBroker(t *testing.T) {
+	server := newFakeNATS(t)
+	newHub := func() *Hub {
+		cfg := config.DefaultRealtimeConfig()
+		cfg.Broker.Enabled = true
+		cfg.Broker.Type = "nats"
+		cfg.Broker.NATS = *server.config()
+		hub := NewHub(auth.NewJWTManager("test-secret", time.Hour), cfg)
+		go hub.Run()
+		t.Cleanup(func() { hub.Close() })
+		return hub
+	}
+	hubA, hubB := newHub(), newHub()
+	hubB.RegisterCollection("todos", openReader{})
+	require.True(t, hubA.broker.IsConnected())
+	require.True(t, hubB.broker.IsConnected())
+	// Both asked for presence when they started
+	publishedA, publishedB := hubA.stats.published.Load(), hubB.stats.published.Load()
+
+	// An event stream on hub B
+	streamServer := httptest.NewServer(http.HandlerFunc(hubB.HandleSSE))
+	defer streamServer.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", streamServer.URL+"?room=chat&room=collection:todos", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	events := readEvents(bufio.NewScanner(resp.Body))
+	assert.Equal(t, MessageTypeConnect, nextEvent(t, events).Message.Type)
+	require.Eventually(t, func() bool { return hubB.GetRooms()["chat"] == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	t.Run("delivers room events from other servers once", func(t *testing.T) {
+		hubA.EmitToRoom("chat", "message", map[string]interface{}{"text": "hi"})
+		event := nextEvent(t, events)
+		assert.Equal(t, "message", event.Message.Event)
+		assert.Equal(t, "chat", event.Message.Room)
+		assert.Equal(t, "hi", event.Message.Data.(map[string]interface{})["text"])
+
+		hubA.EmitToRoom("chat", "marker", nil)
+		assert.Equal(t, "marker", nextEvent(t, events).Message.Event, "no duplicate delivery")
+	})
+
+	t.Run("delivers collection changes from other servers", func(t *testing.T) {
+		hubA.EmitCollectionChange("todos", EventTypeCreate, map[string]interface{}{"id": "t1"})
+		event := nextEvent(t, events)
+		assert.Equal(t, EventTypeCreate, event.Message.Event)
+		assert.Equal(t, "collection:todos", event.Message.Room)
+		assert.Equal(t, "t1", event.Message.Data.(map[string]interface{})["id"])
+	})
+
+	t.Run("suppresses its own messages", func(t *testing.T) {
+		// Each server receives its own messages back and ignores them, and
+		// does not publish what it received
+		assert.Equal(t, publishedA+3, hubA.stats.published.Load())
+		assert.Equal(t, publishedB, hubB.stats.published.Load())
+		require.Eventually(t, func() bool {
+			return hubA.stats.ignored.Load() == hubA.stats.published.Load() &&
+				hubB.stats.ignored.Load() == hubB.stats.published.Load() &&
+				hubA.stats.received.Load() == hubB.stats.published.Load() &&
+				hubB.stats.received.Load() == 3
+		}, 5*time.Second, 10*time.Millisecond)
+
+		hubB.EmitToRoom("chat", "local", nil)
+		assert.Equal(t, "local", nextEvent(t, events).Message.Event, "delivered locally only once")
+	})
+
+	t.Run("reports broker stats", func(t *testing.T) {
+		stats := hubA.BrokerStats()
+		assert.Equal(t, "nats", stats["type"])
+		assert.Equal(t, true, stats["multi_server"])
+		assert.Equal(t, true, stats["connected"])
+		assert.Equal(t, hubA.serverID, stats["server_id"])
+		require.IsType(t, BrokerHealth{}, stats["health"])
+		assert.True(t, stats["health"].(BrokerHealth).Connected)
+	})
+}
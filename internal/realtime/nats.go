@@ -0,0 +1,278 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/config"
+	"github.com/hjanuschka/go-deployd/internal/logging"
+	"github.com/nats-io/nats.go"
+)
+
+// NATSBroker implements a NATS message broker for multi-server deployments.
+// The messages of a topic are published on the subject <subject>.<topic>, to
+// which every server subscribes. The NATS client reconnects and subscribes
+// again by itself when the connection is lost.
+type NATSBroker struct {
+	config  *config.NATSConfig
+	address string
+
+	mu          sync.Mutex
+	conn        *nats.Conn
+	handlers    map[string]MessageHandler     // By topic
+	subs        map[string]*nats.Subscription // By topic
+	reconnects  int64
+	lastError   string
+	connectedAt time.Time
+}
+
+// NewNATSBroker creates a new NATS message broker
+func NewNATSBroker(config *config.NATSConfig) *NATSBroker {
+	return &NATSBroker{
+		config:   config,
+		address:  net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		handlers: make(map[string]MessageHandler),
+		subs:     make(map[string]*nats.Subscription),
+	}
+}
+
+// Connect connects to the NATS server and keeps reconnecting whenever the
+// connection is lost. It returns the error of the first attempt; later
+// attempts are retried in the background.
+func (nb *NATSBroker) Connect(ctx context.Context) error {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	if nb.conn != nil {
+		return nil
+	}
+
+	serverURL := (&url.URL{Scheme: "nats", Host: nb.address}).String()
+	conn, err := nats.Connect(serverURL, nb.options()...)
+	if err != nil {
+		nb.lastError = err.Error()
+		// Keep trying in the background, so the server starts without the
+		// broker and joins the others once it is available
+		retrying, retryErr := nats.Connect(serverURL, append(nb.options(), nats.RetryOnFailedConnect(true))...)
+		if retryErr != nil {
+			return retryErr
+		}
+		conn = retrying
+	}
+	nb.conn = conn
+
+	for topic, handler := range nb.handlers {
+		if err := nb.subscribeLocked(topic, handler); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (nb *NATSBroker) Disconnect() error {
+	nb.mu.Lock()
+	conn := nb.conn
+	nb.conn = nil
+	nb.subs = make(map[string]*nats.Subscription)
+	nb.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+
+	conn.Close()
+	logging.Info("NATS broker disconnected", "realtime", nil)
+	return nil
+}
+
+func (nb *NATSBroker) Publish(topic string, message *BrokerMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	nb.mu.Lock()
+	conn := nb.conn
+	nb.mu.Unlock()
+	// Messages are not buffered while reconnecting, they reach local
+	// clients only
+	if conn == nil || !conn.IsConnected() {
+		return errBrokerDisconnected
+	}
+	return conn.Publish(nb.subject(topic), data)
+}
+
+func (nb *NATSBroker) Subscribe(topic string, handler MessageHandler) error {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	nb.handlers[topic] = handler
+	if nb.conn == nil {
+		return nil // Subscribed once connected
+	}
+	return nb.subscribeLocked(topic, handler)
+}
+
+func (nb *NATSBroker) Unsubscribe(topic string) error {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	delete(nb.handlers, topic)
+	sub, subscribed := nb.subs[topic]
+	if !subscribed {
+		return nil
+	}
+	delete(nb.subs, topic)
+	return sub.Unsubscribe()
+}
+
+func (nb *NATSBroker) IsConnected() bool {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	return nb.conn != nil && nb.conn.IsConnected()
+}
+
+// Health reports the connection to the NATS server
+func (nb *NATSBroker) Health() BrokerHealth {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	health := BrokerHealth{
+		Address:    nb.address,
+		Connected:  nb.conn != nil && nb.conn.IsConnected(),
+		Reconnects: nb.reconnects,
+		LastError:  nb.lastError,
+	}
+	if !nb.connectedAt.IsZero() {
+		connectedAt := nb.connectedAt
+		health.ConnectedAt = &connectedAt
+	}
+	return health
+}
+
+func (nb *NATSBroker) subject(topic string) string {
+	prefix := nb.config.Subject
+	if prefix == "" {
+		prefix = "deployd"
+	}
+	return prefix + "." + topic
+}
+
+// options configures the NATS client to reconnect forever, with the same
+// backoff as the other brokers, and to report its connection to Health
+func (nb *NATSBroker) options() []nats.Option {
+	options := []nats.Option{
+		nats.Name("go-deployd"),
+		nats.Timeout(brokerDialTimeout),
+		nats.PingInterval(brokerPingInterval),
+		nats.MaxReconnects(-1),
+		nats.ReconnectBufSize(-1),
+		nats.CustomReconnectDelay(func(attempts int) time.Duration {
+			backoff := brokerMinBackoff
+			for i := 1; i < attempts && backoff < brokerMaxBackoff; i++ {
+				backoff *= 2
+			}
+			return min(backoff, brokerMaxBackoff)
+		}),
+		nats.ConnectHandler(func(*nats.Conn) { nb.connected(false) }),
+		nats.ReconnectHandler(func(*nats.Conn) { nb.connected(true) }),
+		nats.ReconnectErrHandler(func(_ *nats.Conn, err error) { nb.failed(err) }),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) { nb.lost(err) }),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			logging.Warn("NATS server error", "realtime", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}),
+	}
+	if nb.config.Username != "" {
+		options = append(options, nats.UserInfo(nb.config.Username, nb.config.Password))
+	}
+	if nb.config.TLS {
+		options = append(options, nats.Secure())
+	}
+	return options
+}
+
+// subscribeLocked subscribes to the subject of a topic; nb.mu must be held
+func (nb *NATSBroker) subscribeLocked(topic string, handler MessageHandler) error {
+	if _, subscribed := nb.subs[topic]; subscribed {
+		return nil
+	}
+	sub, err := nb.conn.Subscribe(nb.subject(topic), func(msg *nats.Msg) {
+		nb.deliver(topic, msg)
+	})
+	if err != nil {
+		return err
+	}
+	nb.subs[topic] = sub
+	// Wait until the server has the subscription, so messages published
+	// from now on are delivered
+	if nb.conn.IsConnected() {
+		return nb.conn.FlushTimeout(brokerDialTimeout)
+	}
+	return nil
+}
+
+// deliver passes a received message to the handler of its topic
+func (nb *NATSBroker) deliver(topic string, msg *nats.Msg) {
+	nb.mu.Lock()
+	handler := nb.handlers[topic]
+	nb.mu.Unlock()
+	if handler == nil {
+		return
+	}
+
+	var message BrokerMessage
+	if err := json.Unmarshal(msg.Data, &message); err != nil {
+		logging.Warn("Invalid NATS broker message", "realtime", map[string]interface{}{
+			"subject": msg.Subject,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := handler(&message); err != nil {
+		logging.Error("NATS broker handler error", "realtime", map[string]interface{}{
+			"subject": msg.Subject,
+			"error":   err.Error(),
+		})
+	}
+}
+
+func (nb *NATSBroker) connected(reconnect bool) {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	nb.connectedAt = time.Now()
+	if reconnect {
+		nb.reconnects++
+	}
+	logging.Info("Message broker connected", "realtime", map[string]interface{}{
+		"broker":     "nats",
+		"address":    nb.address,
+		"reconnects": nb.reconnects,
+	})
+}
+
+func (nb *NATSBroker) failed(err error) {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	nb.lastError = err.Error()
+	logging.Warn("Message broker connection failed", "realtime", map[string]interface{}{
+		"broker":  "nats",
+		"address": nb.address,
+		"error":   err.Error(),
+	})
+}
+
+func (nb *NATSBroker) lost(err error) {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	if err == nil {
+		return // Closed by Disconnect
+	}
+	nb.lastError = err.Error()
+	logging.Warn("Message broker connection lost, reconnecting", "realtime", map[string]interface{}{
+		"broker":  "nats",
+		"address": nb.address,
+		"error":   err.Error(),
+	})
+}
@@ -0,0 +1,260 @@
+package realtime
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/config"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNATS is a NATS server that supports what the NATS client of
+// NATSBroker uses: CONNECT, PING, SUB, UNSUB and PUB on exact subjects
+type fakeNATS struct {
+	listener net.Listener
+	password string // Required password, if any
+
+	mu      sync.Mutex
+	clients map[*fakeNATSClient]bool
+}
+
+type fakeNATSClient struct {
+	conn net.Conn
+	mu   sync.Mutex
+	subs map[string]string // Subject by subscription ID
+}
+
+func newFakeNATS(t *testing.T) *fakeNATS {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &fakeNATS{listener: listener, clients: make(map[*fakeNATSClient]bool)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		server.dropClients()
+	})
+	return server
+}
+
+func (s *fakeNATS) config() *config.NATSConfig {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return &config.NATSConfig{Host: "127.0.0.1", Port: addr.Port, Subject: "test"}
+}
+
+// dropClients closes every client connection, as a server restart would
+func (s *fakeNATS) dropClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		client.conn.Close()
+		delete(s.clients, client)
+	}
+}
+
+func (s *fakeNATS) serve(conn net.Conn) {
+	client := &fakeNATSClient{conn: conn, subs: make(map[string]string)}
+	s.mu.Lock()
+	s.clients[client] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, client)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	client.write(`INFO {"server_id":"fake","max_payload":1048576}` + "\r\n")
+	for {
+		line, err := readNATSLine(reader)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "CONNECT":
+			var options map[string]interface{}
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &options)
+			if s.password != "" && options["pass"] != s.password {
+				client.write("-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "PING":
+			client.write("PONG\r\n")
+		case "SUB":
+			client.mu.Lock()
+			client.subs[fields[2]] = fields[1]
+			client.mu.Unlock()
+		case "UNSUB":
+			client.mu.Lock()
+			delete(client.subs, fields[1])
+			client.mu.Unlock()
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			s.publish(fields[1], payload[:size])
+		}
+	}
+}
+
+func (s *fakeNATS) publish(subject string, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		client.mu.Lock()
+		for sid, subscribed := range client.subs {
+			if subscribed == subject {
+				client.conn.Write([]byte(fmt.Sprintf("MSG %s %s %d\r\n%s\r\n", subject, sid, len(payload), payload)))
+			}
+		}
+		client.mu.Unlock()
+	}
+}
+
+func readNATSLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *fakeNATSClient) write(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.Write([]byte(line))
+}
+
+// receiver collects the messages passed to a broker handler
+func receiver() (MessageHandler, <-chan *BrokerMessage) {
+	messages := make(chan *BrokerMessage, 16)
+	return func(message *BrokerMessage) error {
+		messages <- message
+		return nil
+	}, messages
+}
+
+func nextBrokerMessage(t *testing.T, messages <-chan *BrokerMessage) *BrokerMessage {
+	select {
+	case message := <-messages:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("no broker message received")
+	}
+	return nil
+}
+
+func TestNATSBroker(t *testing.T) {
+	server := newFakeNATS(t)
+
+	publisher := NewNATSBroker(server.config())
+	require.NoError(t, publisher.Connect(context.Background()))
+	defer publisher.Disconnect()
+
+	subscriber := NewNATSBroker(server.config())
+	handler, messages := receiver()
+	require.NoError(t, subscriber.Subscribe(TopicCustomEvents, handler), "subscribing before connecting")
+	require.NoError(t, subscriber.Connect(context.Background()))
+	defer subscriber.Disconnect()
+	assert.True(t, subscriber.IsConnected())
+
+	t.Run("delivers published messages", func(t *testing.T) {
+		require.NoError(t, publisher.Publish(TopicCustomEvents, &BrokerMessage{Event: "hello", Room: "chat", ServerID: "a"}))
+		message := nextBrokerMessage(t, messages)
+		assert.Equal(t, "hello", message.Event)
+		assert.Equal(t, "chat", message.Room)
+		assert.Equal(t, "a", message.ServerID)
+
+		require.NoError(t, publisher.Publish(TopicCollectionChanges, &BrokerMessage{Event: "other topic"}))
+		require.NoError(t, publisher.Publish(TopicCustomEvents, &BrokerMessage{Event: "second"}))
+		assert.Equal(t, "second", nextBrokerMessage(t, messages).Event, "only subscribed topics are delivered")
+	})
+
+	t.Run("reconnects and subscribes again", func(t *testing.T) {
+		server.dropClients()
+		require.Eventually(t, func() bool {
+			return subscriber.Health().Reconnects == 1 && subscriber.IsConnected() && publisher.IsConnected()
+		}, 5*time.Second, 10*time.Millisecond)
+		health := subscriber.Health()
+		assert.True(t, health.Connected)
+		assert.NotEmpty(t, health.LastError)
+		assert.NotNil(t, health.ConnectedAt)
+
+		require.NoError(t, publisher.Publish(TopicCustomEvents, &BrokerMessage{Event: "after reconnect"}))
+		assert.Equal(t, "after reconnect", nextBrokerMessage(t, messages).Event)
+	})
+
+	t.Run("unsubscribes", func(t *testing.T) {
+		require.NoError(t, subscriber.Unsubscribe(TopicCustomEvents))
+		require.NoError(t, subscriber.Subscribe(TopicCollectionChanges, handler))
+		// The server handles the subscriber's UNSUB before its SUB, so once
+		// changes arrive custom events no longer do
+		require.Eventually(t, func() bool {
+			publisher.Publish(TopicCollectionChanges, &BrokerMessage{Event: "change"})
+			select {
+			case message := <-messages:
+				return message.Event == "change"
+			case <-time.After(50 * time.Millisecond):
+				return false
+			}
+		}, 5*time.Second, 10*time.Millisecond)
+		for len(messages) > 0 {
+			<-messages
+		}
+
+		require.NoError(t, publisher.Publish(TopicCustomEvents, &BrokerMessage{Event: "dropped"}))
+		require.NoError(t, publisher.Publish(TopicCollectionChanges, &BrokerMessage{Event: "last"}))
+		assert.Equal(t, "last", nextBrokerMessage(t, messages).Event)
+	})
+
+	t.Run("fails to publish once disconnected", func(t *testing.T) {
+		require.NoError(t, publisher.Disconnect())
+		assert.False(t, publisher.IsConnected())
+		assert.ErrorIs(t, publisher.Publish(TopicCustomEvents, &BrokerMessage{}), errBrokerDisconnected)
+	})
+}
+
+func TestNATSBrokerRefusedConnection(t *testing.T) {
+	server := newFakeNATS(t)
+	server.password = "secret"
+
+	cfg := server.config()
+	cfg.Username, cfg.Password = "deployd", "wrong"
+	broker := NewNATSBroker(cfg)
+	err := broker.Connect(context.Background())
+	defer broker.Disconnect()
+	require.ErrorIs(t, err, nats.ErrAuthorization)
+	assert.False(t, broker.IsConnected())
+	assert.Contains(t, broker.Health().LastError, "Authorization Violation")
+
+	cfg.Password = "secret"
+	broker = NewNATSBroker(cfg)
+	require.NoError(t, broker.Connect(context.Background()))
+	defer broker.Disconnect()
+	assert.True(t, broker.IsConnected())
+}
@@ -0,0 +1,477 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/auth"
+	"github.com/hjanuschka/go-deployd/internal/logging"
+)
+
+// MessageTypePresence is the type of presence events, and of the messages
+// clients query and update their presence with
+const MessageTypePresence = "presence"
+
+// Presence events
+const (
+	PresenceJoin   = "join"
+	PresenceLeave  = "leave"
+	PresenceUpdate = "update"
+	PresenceState  = "state" // The members of a room
+	presenceSync   = "sync"  // Asks the other servers for their members
+)
+
+// Servers publish all their members on this interval, and the members of a
+// server that has not been heard from for presenceTTL are dropped
+const (
+	presenceStateInterval = 15 * time.Second
+	presenceTTL           = 3 * presenceStateInterval
+)
+
+// PresenceMember is an authenticated client in a room
+type PresenceMember struct {
+	ClientID string                 `json:"client_id"`
+	UserID   string                 `json:"user_id"`
+	Username string                 `json:"username"`
+	ServerID string                 `json:"server_id"`
+	JoinedAt time.Time              `json:"joined_at"`
+	Meta     map[string]interface{} `json:"meta,omitempty"` // Set by the client, e.g. typing or cursor state
+}
+
+func (m PresenceMember) key() string {
+	return m.ServerID + ":" + m.ClientID
+}
+
+// copy returns the member with its own copy of the metadata
+func (m PresenceMember) copy() PresenceMember {
+	if m.Meta != nil {
+		meta := make(map[string]interface{}, len(m.Meta))
+		for k, v := range m.Meta {
+			meta[k] = v
+		}
+		m.Meta = meta
+	}
+	return m
+}
+
+// presenceChange is a presence event for the clients in a room
+type presenceChange struct {
+	event  string
+	room   string
+	member PresenceMember
+}
+
+// presenceTracker keeps the members of rooms, on this server and, from the
+// message broker, on the others
+type presenceTracker struct {
+	mu      sync.Mutex
+	rooms   map[string]map[string]*PresenceMember // Members by room and key
+	servers map[string]time.Time                  // When other servers were last heard from
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{
+		rooms:   make(map[string]map[string]*PresenceMember),
+		servers: make(map[string]time.Time),
+	}
+}
+
+// join adds a member to a room and reports whether it was not there yet
+func (p *presenceTracker) join(room string, member PresenceMember) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.joinLocked(room, member)
+}
+
+func (p *presenceTracker) joinLocked(room string, member PresenceMember) bool {
+	if p.rooms[room] == nil {
+		p.rooms[room] = make(map[string]*PresenceMember)
+	}
+	if _, ok := p.rooms[room][member.key()]; ok {
+		return false
+	}
+	member = member.copy()
+	p.rooms[room][member.key()] = &member
+	return true
+}
+
+// leave removes a member from a room and returns it
+func (p *presenceTracker) leave(room, key string) (PresenceMember, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.leaveLocked(room, key)
+}
+
+func (p *presenceTracker) leaveLocked(room, key string) (PresenceMember, bool) {
+	member, ok := p.rooms[room][key]
+	if !ok {
+		return PresenceMember{}, false
+	}
+	delete(p.rooms[room], key)
+	if len(p.rooms[room]) == 0 {
+		delete(p.rooms, room)
+	}
+	return *member, true
+}
+
+// update merges meta into a member's metadata; null values remove keys
+func (p *presenceTracker) update(room, key string, meta map[string]interface{}) (PresenceMember, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	member, ok := p.rooms[room][key]
+	if !ok {
+		return PresenceMember{}, false
+	}
+	if member.Meta == nil {
+		member.Meta = make(map[string]interface{})
+	}
+	for k, v := range meta {
+		if v == nil {
+			delete(member.Meta, k)
+		} else {
+			member.Meta[k] = v
+		}
+	}
+	return member.copy(), true
+}
+
+// set adds or replaces a member of another server and returns the event
+// clients are told about, if any
+func (p *presenceTracker) set(room string, member PresenceMember) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.setLocked(room, member)
+}
+
+func (p *presenceTracker) setLocked(room string, member PresenceMember) (string, bool) {
+	existing, ok := p.rooms[room][member.key()]
+	if !ok {
+		return PresenceJoin, p.joinLocked(room, member)
+	}
+	if reflect.DeepEqual(existing.Meta, member.Meta) {
+		return "", false
+	}
+	existing.Meta = member.copy().Meta
+	return PresenceUpdate, true
+}
+
+// members returns the members of a room in the order they joined
+func (p *presenceTracker) members(room string) []PresenceMember {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	members := make([]PresenceMember, 0, len(p.rooms[room]))
+	for _, member := range p.rooms[room] {
+		members = append(members, member.copy())
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if !members[i].JoinedAt.Equal(members[j].JoinedAt) {
+			return members[i].JoinedAt.Before(members[j].JoinedAt)
+		}
+		return members[i].key() < members[j].key()
+	})
+	return members
+}
+
+// serverMembers returns the members on a server by room
+func (p *presenceTracker) serverMembers(serverID string) map[string][]PresenceMember {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rooms := make(map[string][]PresenceMember)
+	for room, members := range p.rooms {
+		for _, member := range members {
+			if member.ServerID == serverID {
+				rooms[room] = append(rooms[room], member.copy())
+			}
+		}
+	}
+	return rooms
+}
+
+// drop removes a client from every room
+func (p *presenceTracker) drop(key string) []presenceChange {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var changes []presenceChange
+	for room := range p.rooms {
+		if member, ok := p.leaveLocked(room, key); ok {
+			changes = append(changes, presenceChange{PresenceLeave, room, member})
+		}
+	}
+	return changes
+}
+
+// replace makes rooms the members of another server
+func (p *presenceTracker) replace(serverID string, rooms map[string][]PresenceMember) []presenceChange {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var changes []presenceChange
+	current := make(map[string]bool)
+	for room, members := range rooms {
+		for _, member := range members {
+			member.ServerID = serverID
+			current[room+"\x00"+member.key()] = true
+			if event, changed := p.setLocked(room, member); changed {
+				changes = append(changes, presenceChange{event, room, member})
+			}
+		}
+	}
+	for room, members := range p.rooms {
+		for key, member := range members {
+			if member.ServerID == serverID && !current[room+"\x00"+key] {
+				left, _ := p.leaveLocked(room, key)
+				changes = append(changes, presenceChange{PresenceLeave, room, left})
+			}
+		}
+	}
+	return changes
+}
+
+// seen records that another server was heard from
+func (p *presenceTracker) seen(serverID string, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.servers[serverID] = now
+}
+
+// expire drops the members of servers not heard from since ttl before now
+func (p *presenceTracker) expire(now time.Time, ttl time.Duration) []presenceChange {
+	p.mu.Lock()
+	var expired []string
+	for serverID, seen := range p.servers {
+		if now.Sub(seen) > ttl {
+			expired = append(expired, serverID)
+			delete(p.servers, serverID)
+		}
+	}
+	p.mu.Unlock()
+
+	var changes []presenceChange
+	for _, serverID := range expired {
+		changes = append(changes, p.replace(serverID, nil)...)
+	}
+	return changes
+}
+
+// GetPresence returns the authenticated clients in a room, on every server,
+// in the order they joined
+func (h *Hub) GetPresence(room string) []PresenceMember {
+	return h.presence.members(room)
+}
+
+// presenceMember describes the client as a member of rooms, if it is
+// authenticated
+func (h *Hub) presenceMember(client *Client) (PresenceMember, bool) {
+	client.mu.RLock()
+	claims, ok := client.User.(*auth.JWTClaims)
+	client.mu.RUnlock()
+	if !ok || claims == nil {
+		return PresenceMember{}, false
+	}
+	return PresenceMember{
+		ClientID: client.ID,
+		UserID:   claims.UserID,
+		Username: claims.Username,
+		ServerID: h.serverID,
+		JoinedAt: time.Now(),
+	}, true
+}
+
+// presenceKey identifies a client of this server among the members of rooms
+func (h *Hub) presenceKey(client *Client) string {
+	return h.serverID + ":" + client.ID
+}
+
+// trackPresence adds an authenticated client to the members of a room it
+// joined. Collection rooms only carry changes and have no presence.
+func (h *Hub) trackPresence(client *Client, room string) {
+	if strings.HasPrefix(room, "collection:") {
+		return
+	}
+	member, ok := h.presenceMember(client)
+	if !ok {
+		return
+	}
+	if h.presence.join(room, member) {
+		h.sendPresence(PresenceJoin, room, member)
+	}
+}
+
+// trackRooms adds a client that authenticated to the members of the rooms it
+// already joined
+func (h *Hub) trackRooms(client *Client) {
+	h.mu.RLock()
+	rooms := make([]string, 0, len(client.Rooms))
+	for room := range client.Rooms {
+		rooms = append(rooms, room)
+	}
+	h.mu.RUnlock()
+	for _, room := range rooms {
+		h.trackPresence(client, room)
+	}
+}
+
+// untrackPresence removes a client from the members of a room it left
+func (h *Hub) untrackPresence(client *Client, room string) {
+	if member, ok := h.presence.leave(room, h.presenceKey(client)); ok {
+		h.sendPresence(PresenceLeave, room, member)
+	}
+}
+
+// dropPresence removes a disconnected client from the members of all rooms
+func (h *Hub) dropPresence(client *Client) {
+	for _, change := range h.presence.drop(h.presenceKey(client)) {
+		h.sendPresence(change.event, change.room, change.member)
+	}
+}
+
+// inRoom reports whether the client joined a room
+func (c *Client) inRoom(room string) bool {
+	c.Hub.mu.RLock()
+	defer c.Hub.mu.RUnlock()
+	return c.Rooms[room]
+}
+
+// handlePresence answers a presence query for a room the client joined, or
+// updates the client's presence metadata in it
+func (c *Client) handlePresence(event, room string, data interface{}) {
+	if room == "" {
+		c.sendError("Room name required")
+		return
+	}
+	if !c.inRoom(room) {
+		c.sendError(fmt.Sprintf("Not in room: %s", room))
+		return
+	}
+
+	switch event {
+	case "", PresenceState:
+		c.Send <- c.Hub.createMessage(MessageTypePresence, PresenceState, c.Hub.GetPresence(room), room)
+	case PresenceUpdate:
+		meta, ok := data.(map[string]interface{})
+		if !ok {
+			c.sendError("Presence metadata must be an object")
+			return
+		}
+		member, ok := c.Hub.presence.update(room, c.Hub.presenceKey(c), meta)
+		if !ok {
+			c.sendError("Authentication required for presence")
+			return
+		}
+		c.Hub.sendPresence(PresenceUpdate, room, member)
+	default:
+		c.sendError(fmt.Sprintf("Unknown presence event: %s", event))
+	}
+}
+
+// sendPresence tells the clients in a room, on every server, about a change
+// of its members
+func (h *Hub) sendPresence(event, room string, member PresenceMember) {
+	h.deliverPresence(event, room, member)
+	h.publishToBroker(TopicPresence, &BrokerMessage{
+		Type:  MessageTypePresence,
+		Event: event,
+		Data:  member,
+		Room:  room,
+	})
+}
+
+// deliverPresence tells the clients in a room on this server about a change
+// of its members
+func (h *Hub) deliverPresence(event, room string, member PresenceMember) {
+	message := h.createFanoutMessage(room, MessageTypePresence, event, member, room)
+	h.sendToRoom(room, message, event)
+}
+
+// handlePresenceMessage applies the presence changes of other servers
+func (h *Hub) handlePresenceMessage(message *BrokerMessage) error {
+	if message.ServerID == h.serverID {
+		h.stats.ignored.Add(1)
+		return nil
+	}
+	h.stats.received.Add(1)
+	h.presence.seen(message.ServerID, time.Now())
+
+	switch message.Event {
+	case PresenceJoin, PresenceUpdate:
+		var member PresenceMember
+		if err := decodeBrokerData(message.Data, &member); err != nil {
+			return err
+		}
+		member.ServerID = message.ServerID
+		if event, changed := h.presence.set(message.Room, member); changed {
+			h.deliverPresence(event, message.Room, member)
+		}
+	case PresenceLeave:
+		var member PresenceMember
+		if err := decodeBrokerData(message.Data, &member); err != nil {
+			return err
+		}
+		member.ServerID = message.ServerID
+		if left, ok := h.presence.leave(message.Room, member.key()); ok {
+			h.deliverPresence(PresenceLeave, message.Room, left)
+		}
+	case PresenceState:
+		var rooms map[string][]PresenceMember
+		if err := decodeBrokerData(message.Data, &rooms); err != nil {
+			return err
+		}
+		for _, change := range h.presence.replace(message.ServerID, rooms) {
+			h.deliverPresence(change.event, change.room, change.member)
+		}
+	case presenceSync:
+		if len(h.presence.serverMembers(h.serverID)) > 0 {
+			h.publishPresenceState()
+		}
+	}
+	return nil
+}
+
+// publishPresenceState publishes all members on this server, replacing what
+// the other servers know about them
+func (h *Hub) publishPresenceState() {
+	h.publishToBroker(TopicPresence, &BrokerMessage{
+		Type:  MessageTypePresence,
+		Event: PresenceState,
+		Data:  h.presence.serverMembers(h.serverID),
+	})
+}
+
+// runPresence keeps presence consistent across servers: it publishes the
+// members on this server periodically and drops the members of servers that
+// stopped publishing
+func (h *Hub) runPresence() {
+	ticker := time.NewTicker(presenceStateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case now := <-ticker.C:
+			h.publishPresenceState()
+			changes := h.presence.expire(now, presenceTTL)
+			for _, change := range changes {
+				h.deliverPresence(change.event, change.room, change.member)
+			}
+			if len(changes) > 0 {
+				logging.Info("Dropped presence of unresponsive servers", "realtime", map[string]interface{}{
+					"changes": len(changes),
+				})
+			}
+		}
+	}
+}
+
+// decodeBrokerData converts the data of a broker message, decoded as generic
+// JSON, into v
+func decodeBrokerData(data interface{}, v interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, v)
+}
@@ -0,0 +1,296 @@
+package realtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hjanuschka/go-deployd/internal/auth"
+	"github.com/hjanuschka/go-deployd/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresenceTracker(t *testing.T) {
+	tracker := newPresenceTracker()
+	alice := PresenceMember{ClientID: "c1", UserID: "u1", Username: "alice", ServerID: "s1", JoinedAt: time.Unix(1, 0)}
+	bob := PresenceMember{ClientID: "c2", UserID: "u2", Username: "bob", ServerID: "s2", JoinedAt: time.Unix(2, 0)}
+
+	assert.True(t, tracker.join("doc", bob))
+	assert.True(t, tracker.join("doc", alice))
+	assert.False(t, tracker.join("doc", alice), "already present")
+	members := tracker.members("doc")
+	require.Len(t, members, 2)
+	assert.Equal(t, "alice", members[0].Username, "in the order they joined")
+
+	member, ok := tracker.update("doc", alice.key(), map[string]interface{}{"typing": true, "cursor": 3.0})
+	require.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"typing": true, "cursor": 3.0}, member.Meta)
+	member, _ = tracker.update("doc", alice.key(), map[string]interface{}{"typing": nil})
+	assert.Equal(t, map[string]interface{}{"cursor": 3.0}, member.Meta, "null removes metadata")
+	_, ok = tracker.update("other", alice.key(), map[string]interface{}{})
+	assert.False(t, ok)
+
+	t.Run("replaces the members of another server", func(t *testing.T) {
+		carol := PresenceMember{ClientID: "c3", UserID: "u3", Username: "carol"}
+		movedBob := bob
+		movedBob.Meta = map[string]interface{}{"away": true}
+		changes := tracker.replace("s2", map[string][]PresenceMember{
+			"doc":   {movedBob},
+			"lobby": {carol},
+		})
+		assert.ElementsMatch(t, []presenceChange{
+			{PresenceUpdate, "doc", movedBob},
+			{PresenceJoin, "lobby", PresenceMember{ClientID: "c3", UserID: "u3", Username: "carol", ServerID: "s2"}},
+		}, changes)
+
+		changes = tracker.replace("s2", map[string][]PresenceMember{"lobby": {carol}})
+		require.Len(t, changes, 1)
+		assert.Equal(t, PresenceLeave, changes[0].event)
+		assert.Equal(t, "bob", changes[0].member.Username)
+		assert.Len(t, tracker.members("doc"), 1)
+	})
+
+	t.Run("expires servers not heard from", func(t *testing.T) {
+		now := time.Now()
+		tracker.seen("s2", now.Add(-presenceTTL-time.Second))
+		tracker.seen("s3", now)
+		changes := tracker.expire(now, presenceTTL)
+		require.Len(t, changes, 1)
+		assert.Equal(t, "carol", changes[0].member.Username)
+		assert.Empty(t, tracker.members("lobby"))
+	})
+
+	t.Run("drops disconnected clients", func(t *testing.T) {
+		tracker.join("lobby", alice)
+		changes := tracker.drop(alice.key())
+		assert.Len(t, changes, 2)
+		assert.Empty(t, tracker.serverMembers("s1"))
+	})
+}
+
+// wsTestClient is a WebSocket connection to a hub
+type wsTestClient struct {
+	conn     *websocket.Conn
+	messages chan WebSocketMessage
+}
+
+// dialHub connects to a hub served at url, authenticated with token unless
+// it is empty
+func dialHub(t *testing.T, url, token string) *wsTestClient {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	client := &wsTestClient{conn: conn, messages: make(chan WebSocketMessage, 64)}
+	go func() {
+		defer close(client.messages)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			// Queued messages are sent together, separated by newlines
+			for _, line := range bytes.Split(data, []byte{'\n'}) {
+				var msg WebSocketMessage
+				json.Unmarshal(line, &msg)
+				client.messages <- msg
+			}
+		}
+	}()
+
+	assert.Equal(t, MessageTypeConnect, client.next(t).Type)
+	if token != "" {
+		client.send(t, WebSocketMessage{Type: MessageTypeAuth, Token: token})
+		assert.Equal(t, MessageTypeAuth, client.next(t).Type)
+	}
+	return client
+}
+
+func (c *wsTestClient) send(t *testing.T, msg WebSocketMessage) {
+	require.NoError(t, c.conn.WriteJSON(msg))
+}
+
+func (c *wsTestClient) next(t *testing.T) WebSocketMessage {
+	select {
+	case msg, ok := <-c.messages:
+		require.True(t, ok, "connection closed")
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	return WebSocketMessage{}
+}
+
+// member decodes the member of a presence event
+func (msg WebSocketMessage) member(t *testing.T) PresenceMember {
+	var member PresenceMember
+	require.NoError(t, decodeBrokerData(msg.Data, &member))
+	return member
+}
+
+// members decodes the members of a presence state
+func (msg WebSocketMessage) members(t *testing.T) []PresenceMember {
+	var members []PresenceMember
+	require.NoError(t, decodeBrokerData(msg.Data, &members))
+	return members
+}
+
+func usernames(members []PresenceMember) []string {
+	names := make([]string, len(members))
+	for i, member := range members {
+		names[i] = member.Username
+	}
+	return names
+}
+
+func TestPresence(t *testing.T) {
+	jwtManager := auth.NewJWTManager("test-secret", time.Hour)
+	hub := NewHub(jwtManager, config.DefaultRealtimeConfig())
+	go hub.Run()
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleWebSocket))
+	defer server.Close()
+	token := func(userID, username string) string {
+		token, err := jwtManager.GenerateToken(userID, username, false)
+		require.NoError(t, err)
+		return token
+	}
+
+	alice := dialHub(t, server.URL, token("u1", "alice"))
+	alice.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "doc"})
+	msg := alice.next(t)
+	assert.Equal(t, MessageTypePresence, msg.Type)
+	assert.Equal(t, PresenceJoin, msg.Event)
+	assert.Equal(t, "doc", msg.Room)
+	assert.Equal(t, "u1", msg.member(t).UserID)
+	assert.Equal(t, "alice", msg.member(t).Username)
+
+	bob := dialHub(t, server.URL, token("u2", "bob"))
+	bob.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "doc"})
+	assert.Equal(t, "bob", alice.next(t).member(t).Username)
+	assert.Equal(t, PresenceJoin, bob.next(t).Event)
+
+	t.Run("answers presence queries", func(t *testing.T) {
+		bob.send(t, WebSocketMessage{Type: MessageTypePresence, Room: "doc"})
+		msg := bob.next(t)
+		assert.Equal(t, MessageTypePresence, msg.Type)
+		assert.Equal(t, PresenceState, msg.Event)
+		assert.Equal(t, []string{"alice", "bob"}, usernames(msg.members(t)))
+		assert.Equal(t, []string{"alice", "bob"}, usernames(hub.GetPresence("doc")))
+
+		bob.send(t, WebSocketMessage{Type: MessageTypePresence, Room: "elsewhere"})
+		assert.Contains(t, bob.next(t).Error, "Not in room")
+	})
+
+	t.Run("shares metadata updates", func(t *testing.T) {
+		alice.send(t, WebSocketMessage{Type: MessageTypePresence, Event: PresenceUpdate, Room: "doc", Data: map[string]interface{}{"typing": true}})
+		for _, client := range []*wsTestClient{alice, bob} {
+			msg := client.next(t)
+			assert.Equal(t, PresenceUpdate, msg.Event)
+			assert.Equal(t, map[string]interface{}{"typing": true}, msg.member(t).Meta)
+		}
+	})
+
+	t.Run("leaves anonymous clients out", func(t *testing.T) {
+		anonymous := dialHub(t, server.URL, "")
+		anonymous.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "doc"})
+		anonymous.send(t, WebSocketMessage{Type: MessageTypePresence, Room: "doc"})
+		msg := anonymous.next(t)
+		assert.Equal(t, PresenceState, msg.Event, "no join event for anonymous clients")
+		assert.Len(t, msg.members(t), 2)
+
+		anonymous.send(t, WebSocketMessage{Type: MessageTypePresence, Event: PresenceUpdate, Room: "doc", Data: map[string]interface{}{}})
+		assert.Contains(t, anonymous.next(t).Error, "Authentication required")
+	})
+
+	t.Run("sends leave events", func(t *testing.T) {
+		alice.send(t, WebSocketMessage{Type: MessageTypeLeave, Room: "doc"})
+		msg := bob.next(t)
+		assert.Equal(t, PresenceLeave, msg.Event)
+		assert.Equal(t, "alice", msg.member(t).Username)
+
+		alice.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "doc"})
+		assert.Equal(t, PresenceJoin, bob.next(t).Event)
+		alice.conn.Close()
+		msg = bob.next(t)
+		assert.Equal(t, PresenceLeave, msg.Event, "disconnecting leaves every room")
+		assert.Equal(t, "alice", msg.member(t).Username)
+		assert.Equal(t, []string{"bob"}, usernames(hub.GetPresence("doc")))
+	})
+}
+
+func TestPresenceAcrossServers(t *testing.T) {
+	broker := newFakeNATS(t)
+	jwtManager := auth.NewJWTManager("test-secret", time.Hour)
+	newHub := func() (*Hub, string) {
+		cfg := config.DefaultRealtimeConfig()
+		cfg.Broker.Enabled = true
+		cfg.Broker.Type = "nats"
+		cfg.Broker.NATS = *broker.config()
+		hub := NewHub(jwtManager, cfg)
+		go hub.Run()
+		server := httptest.NewServer(http.HandlerFunc(hub.HandleWebSocket))
+		t.Cleanup(func() {
+			server.Close()
+			hub.Close()
+		})
+		return hub, server.URL
+	}
+	token := func(userID, username string) string {
+		token, err := jwtManager.GenerateToken(userID, username, false)
+		require.NoError(t, err)
+		return token
+	}
+	presentIn := func(hub *Hub, room string, names ...string) func() bool {
+		return func() bool {
+			return assert.ObjectsAreEqual(names, usernames(hub.GetPresence(room)))
+		}
+	}
+
+	hubA, urlA := newHub()
+	hubB, urlB := newHub()
+
+	alice := dialHub(t, urlA, token("u1", "alice"))
+	alice.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "doc"})
+	assert.Equal(t, PresenceJoin, alice.next(t).Event)
+	require.Eventually(t, presentIn(hubB, "doc", "alice"), 5*time.Second, 10*time.Millisecond)
+
+	bob := dialHub(t, urlB, token("u2", "bob"))
+	bob.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "doc"})
+	assert.Equal(t, "bob", bob.next(t).member(t).Username)
+	msg := alice.next(t)
+	assert.Equal(t, PresenceJoin, msg.Event, "joins on other servers are sent")
+	assert.Equal(t, "bob", msg.member(t).Username)
+	assert.Equal(t, hubB.serverID, msg.member(t).ServerID)
+
+	bob.send(t, WebSocketMessage{Type: MessageTypePresence, Room: "doc"})
+	assert.Equal(t, []string{"alice", "bob"}, usernames(bob.next(t).members(t)))
+
+	t.Run("shares metadata updates", func(t *testing.T) {
+		alice.send(t, WebSocketMessage{Type: MessageTypePresence, Event: PresenceUpdate, Room: "doc", Data: map[string]interface{}{"cursor": 7.0}})
+		assert.Equal(t, PresenceUpdate, alice.next(t).Event)
+		msg := bob.next(t)
+		assert.Equal(t, PresenceUpdate, msg.Event)
+		assert.Equal(t, "alice", msg.member(t).Username)
+		assert.Equal(t, map[string]interface{}{"cursor": 7.0}, msg.member(t).Meta)
+	})
+
+	t.Run("new servers ask for presence", func(t *testing.T) {
+		hubC, _ := newHub()
+		require.Eventually(t, presentIn(hubC, "doc", "alice", "bob"), 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, map[string]interface{}{"cursor": 7.0}, hubC.GetPresence("doc")[0].Meta)
+	})
+
+	t.Run("servers that stop take their clients along", func(t *testing.T) {
+		require.NoError(t, hubA.Close())
+		msg := bob.next(t)
+		assert.Equal(t, PresenceLeave, msg.Event)
+		assert.Equal(t, "alice", msg.member(t).Username)
+		assert.Equal(t, []string{"bob"}, usernames(hubB.GetPresence("doc")))
+	})
+}
@@ -0,0 +1,232 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/hjanuschka/go-deployd/internal/config"
+	"github.com/hjanuschka/go-deployd/internal/logging"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// RabbitMQBroker implements a RabbitMQ message broker for multi-server
+// deployments. Every server declares the topic exchange of the config and a
+// private queue bound to it with the topics it subscribes to, so each
+// published message reaches every server.
+type RabbitMQBroker struct {
+	config    *config.RabbitConfig
+	reconnect *reconnector
+
+	mu       sync.Mutex
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	queue    string
+	handlers map[string]MessageHandler // By topic
+	bound    map[string]bool           // Topics the queue is bound to on the current connection
+}
+
+// NewRabbitMQBroker creates a new RabbitMQ message broker
+func NewRabbitMQBroker(config *config.RabbitConfig) *RabbitMQBroker {
+	rb := &RabbitMQBroker{
+		config:   config,
+		handlers: make(map[string]MessageHandler),
+		bound:    make(map[string]bool),
+	}
+	address := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	rb.reconnect = newReconnector("rabbitmq", address, rb.session)
+	return rb
+}
+
+// Connect connects to RabbitMQ and keeps reconnecting whenever the
+// connection is lost
+func (rb *RabbitMQBroker) Connect(ctx context.Context) error {
+	return rb.reconnect.start(ctx)
+}
+
+func (rb *RabbitMQBroker) Disconnect() error {
+	rb.reconnect.stop(func() {
+		rb.mu.Lock()
+		defer rb.mu.Unlock()
+		if rb.conn != nil {
+			rb.conn.Close()
+		}
+	})
+	logging.Info("RabbitMQ broker disconnected", "realtime", nil)
+	return nil
+}
+
+func (rb *RabbitMQBroker) Publish(topic string, message *BrokerMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	rb.mu.Lock()
+	channel := rb.channel
+	rb.mu.Unlock()
+	if channel == nil {
+		return errBrokerDisconnected
+	}
+	return channel.PublishWithContext(context.Background(), rb.exchange(), topic, false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        data,
+	})
+}
+
+func (rb *RabbitMQBroker) Subscribe(topic string, handler MessageHandler) error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.handlers[topic] = handler
+	if rb.channel == nil || rb.bound[topic] {
+		return nil // Bound once connected
+	}
+	if err := rb.channel.QueueBind(rb.queue, topic, rb.exchange(), false, nil); err != nil {
+		return err
+	}
+	rb.bound[topic] = true
+	return nil
+}
+
+func (rb *RabbitMQBroker) Unsubscribe(topic string) error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	delete(rb.handlers, topic)
+	if rb.channel == nil || !rb.bound[topic] {
+		return nil
+	}
+	delete(rb.bound, topic)
+	return rb.channel.QueueUnbind(rb.queue, topic, rb.exchange(), nil)
+}
+
+func (rb *RabbitMQBroker) IsConnected() bool {
+	return rb.reconnect.isConnected()
+}
+
+// Health reports the connection to RabbitMQ
+func (rb *RabbitMQBroker) Health() BrokerHealth {
+	return rb.reconnect.health()
+}
+
+func (rb *RabbitMQBroker) exchange() string {
+	if rb.config.Exchange == "" {
+		return "deployd"
+	}
+	return rb.config.Exchange
+}
+
+// session connects, opens a channel, declares the exchange and queue, binds
+// the topics of the registered handlers and starts consuming
+func (rb *RabbitMQBroker) session(ctx context.Context) (func() error, error) {
+	vhost := rb.config.VHost
+	if vhost == "" {
+		vhost = "/"
+	}
+	scheme := "amqp"
+	if rb.config.TLS {
+		scheme = "amqps"
+	}
+	conn, err := amqp.DialConfig(scheme+"://"+rb.reconnect.address+"/", amqp.Config{
+		SASL:       []amqp.Authentication{&amqp.PlainAuth{Username: rb.config.Username, Password: rb.config.Password}},
+		Vhost:      vhost,
+		Dial:       amqp.DefaultDial(brokerDialTimeout),
+		Properties: amqp.Table{"connection_name": "go-deployd"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Handlers may change while the queue is set up, so the topics are bound
+	// while holding the lock
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	channel, queue, deliveries, err := rb.consume(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	closed := channel.NotifyClose(make(chan *amqp.Error, 1))
+	rb.conn, rb.channel, rb.queue = conn, channel, queue
+	rb.bound = make(map[string]bool)
+	for topic := range rb.handlers {
+		if err := channel.QueueBind(queue, topic, rb.exchange(), false, nil); err != nil {
+			rb.conn, rb.channel = nil, nil
+			conn.Close()
+			return nil, err
+		}
+		rb.bound[topic] = true
+	}
+	return func() error { return rb.serve(conn, deliveries, closed) }, nil
+}
+
+// consume opens a channel, declares the exchange and a private queue and
+// starts consuming from the queue
+func (rb *RabbitMQBroker) consume(conn *amqp.Connection) (*amqp.Channel, string, <-chan amqp.Delivery, error) {
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	// Durable, not auto-deleted, not internal, wait for the reply
+	if err := channel.ExchangeDeclare(rb.exchange(), "topic", true, false, false, false, nil); err != nil {
+		return nil, "", nil, err
+	}
+	// Named by the server, not durable, auto-deleted, exclusive
+	queue, err := channel.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	// Consumer tag chosen by the client, acknowledged on delivery, exclusive
+	deliveries, err := channel.Consume(queue.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return channel, queue.Name, deliveries, nil
+}
+
+// serve delivers received messages until the channel or connection is
+// closed. The client sends heartbeats, so a dead connection is noticed.
+func (rb *RabbitMQBroker) serve(conn *amqp.Connection, deliveries <-chan amqp.Delivery, closed <-chan *amqp.Error) error {
+	for delivery := range deliveries {
+		rb.deliver(delivery.RoutingKey, delivery.Body)
+	}
+
+	// The channel is gone, so start over with a new connection
+	rb.mu.Lock()
+	if rb.conn == conn {
+		rb.conn, rb.channel = nil, nil
+	}
+	rb.mu.Unlock()
+	conn.Close()
+	if err := <-closed; err != nil {
+		return err
+	}
+	return errors.New("RabbitMQ stopped delivering messages")
+}
+
+// deliver passes a received message to the handler of its topic
+func (rb *RabbitMQBroker) deliver(topic string, payload []byte) {
+	rb.mu.Lock()
+	handler := rb.handlers[topic]
+	rb.mu.Unlock()
+	if handler == nil {
+		return
+	}
+
+	var message BrokerMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		logging.Warn("Invalid RabbitMQ broker message", "realtime", map[string]interface{}{
+			"topic": topic,
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := handler(&message); err != nil {
+		logging.Error("RabbitMQ broker handler error", "realtime", map[string]interface{}{
+			"topic": topic,
+			"error": err.Error(),
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package realtime
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/logging"
+)
+
+// Timeouts and reconnection backoff of network brokers
+const (
+	brokerDialTimeout  = 5 * time.Second
+	brokerPingInterval = 30 * time.Second
+	brokerMinBackoff   = 500 * time.Millisecond
+	brokerMaxBackoff   = 30 * time.Second
+)
+
+// errBrokerDisconnected is returned when publishing while the broker is
+// reconnecting
+var errBrokerDisconnected = errors.New("message broker is not connected")
+
+// BrokerHealth reports the connection of a network broker
+type BrokerHealth struct {
+	Address     string     `json:"address"`
+	Connected   bool       `json:"connected"`
+	Reconnects  int64      `json:"reconnects"`           // Connections made after the first one
+	LastError   string     `json:"last_error,omitempty"` // Why the connection was last lost or could not be made
+	ConnectedAt *time.Time `json:"connected_at,omitempty"`
+}
+
+// healthReporter is implemented by brokers that keep a network connection
+type healthReporter interface {
+	Health() BrokerHealth
+}
+
+// brokerSession connects to a broker, sets up its subscriptions and returns
+// a function that serves the connection until it fails or is closed
+type brokerSession func(ctx context.Context) (serve func() error, err error)
+
+// reconnector keeps a network broker connected: whenever the connection is
+// lost it connects again, with exponential backoff, until it is stopped
+type reconnector struct {
+	name    string
+	address string
+	connect brokerSession
+
+	mu          sync.Mutex
+	connected   bool
+	reconnects  int64
+	lastError   string
+	connectedAt time.Time
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+func newReconnector(name, address string, connect brokerSession) *reconnector {
+	return &reconnector{name: name, address: address, connect: connect}
+}
+
+// start connects and keeps the broker connected in the background. It
+// returns the error of the first attempt; later attempts are retried.
+func (r *reconnector) start(ctx context.Context) error {
+	r.mu.Lock()
+	if r.cancel != nil {
+		r.mu.Unlock()
+		return nil
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	r.mu.Unlock()
+
+	serve, err := r.connect(ctx)
+	r.attempted(err, false)
+	go r.run(runCtx, serve)
+	return err
+}
+
+func (r *reconnector) run(ctx context.Context, serve func() error) {
+	defer close(r.done)
+	backoff := brokerMinBackoff
+	for {
+		if serve != nil {
+			err := serve()
+			if ctx.Err() != nil {
+				return
+			}
+			r.lost(err)
+			backoff = brokerMinBackoff
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > brokerMaxBackoff {
+			backoff = brokerMaxBackoff
+		}
+
+		var err error
+		serve, err = r.connect(ctx)
+		r.attempted(err, true)
+	}
+}
+
+// stop ends reconnecting. The broker closes its connection, which ends the
+// current serve function, and stop waits for that.
+func (r *reconnector) stop(closeConn func()) {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel = nil
+	r.connected = false
+	r.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	closeConn()
+	<-done
+}
+
+func (r *reconnector) attempted(err error, reconnect bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.lastError = err.Error()
+		logging.Warn("Message broker connection failed", "realtime", map[string]interface{}{
+			"broker":  r.name,
+			"address": r.address,
+			"error":   err.Error(),
+		})
+		return
+	}
+	r.connected = true
+	r.connectedAt = time.Now()
+	if reconnect {
+		r.reconnects++
+	}
+	logging.Info("Message broker connected", "realtime", map[string]interface{}{
+		"broker":     r.name,
+		"address":    r.address,
+		"reconnects": r.reconnects,
+	})
+}
+
+func (r *reconnector) lost(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.connected = false
+	if err != nil {
+		r.lastError = err.Error()
+	}
+	logging.Warn("Message broker connection lost, reconnecting", "realtime", map[string]interface{}{
+		"broker":  r.name,
+		"address": r.address,
+		"error":   r.lastError,
+	})
+}
+
+func (r *reconnector) isConnected() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.connected
+}
+
+func (r *reconnector) health() BrokerHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	health := BrokerHealth{
+		Address:    r.address,
+		Connected:  r.connected,
+		Reconnects: r.reconnects,
+		LastError:  r.lastError,
+	}
+	if !r.connectedAt.IsZero() {
+		connectedAt := r.connectedAt
+		health.ConnectedAt = &connectedAt
+	}
+	return health
+}
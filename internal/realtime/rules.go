@@ -0,0 +1,190 @@
+package realtime
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hjanuschka/go-deployd/internal/config"
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/logging"
+)
+
+// ruleSet holds the realtime rules of security.json and of collection
+// configs, by collection name
+type ruleSet struct {
+	mu          sync.RWMutex
+	security    *config.RealtimeRules
+	collections map[string]*config.RealtimeRules
+}
+
+// SetSecurityRules sets the realtime rules of security.json, which apply to
+// every room and event and are checked before those of collections
+func (h *Hub) SetSecurityRules(rules *config.RealtimeRules) {
+	h.rules.mu.Lock()
+	h.rules.security = rules
+	h.rules.mu.Unlock()
+}
+
+// SetCollectionRules sets the realtime rules a collection config declares,
+// which apply to the collection's rooms only; nil removes them
+func (h *Hub) SetCollectionRules(collection string, rules *config.RealtimeRules) {
+	h.rules.mu.Lock()
+	defer h.rules.mu.Unlock()
+	if rules == nil {
+		delete(h.rules.collections, collection)
+		return
+	}
+	if h.rules.collections == nil {
+		h.rules.collections = make(map[string]*config.RealtimeRules)
+	}
+	h.rules.collections[collection] = rules
+}
+
+// forRoom returns the rules that apply to room in the order they are
+// checked: those of security.json, then those of the collection the room
+// belongs to. Only security.json's apply to events emitted to every client,
+// whose room is empty.
+func (s *ruleSet) forRoom(room string) []*config.RealtimeRules {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var rules []*config.RealtimeRules
+	if s.security != nil {
+		rules = append(rules, s.security)
+	}
+	if collection := config.RoomCollection(room); collection != "" && s.collections[collection] != nil {
+		rules = append(rules, s.collections[collection])
+	}
+	return rules
+}
+
+// joinRefusal returns why user may not join room, or "" if they may
+func (h *Hub) joinRefusal(user *appcontext.AuthData, room string) string {
+	for _, rules := range h.rules.forRoom(room) {
+		for _, rule := range rules.Rooms {
+			if rule.Join == nil || !matchPattern(rule.Pattern, room) {
+				continue
+			}
+			if rule.Join.Allows(permissionContext(user)) {
+				return ""
+			}
+			return refusal(user, "join room "+room)
+		}
+	}
+	return ""
+}
+
+// authorizeJoin checks that the client may join room
+func (h *Hub) authorizeJoin(client *Client, room string) error {
+	user := client.authData()
+	if message := h.joinRefusal(user, room); message != "" {
+		return h.deny(client, user, "join", room, "", message)
+	}
+	return nil
+}
+
+// authorizeEmit checks that the client may emit event to room, or to every
+// client when room is empty. Server-only events are refused to every client,
+// root included.
+func (h *Hub) authorizeEmit(client *Client, event, room string) error {
+	user := client.authData()
+	rules := h.rules.forRoom(room)
+	for _, set := range rules {
+		for _, pattern := range set.ServerOnly {
+			if matchPattern(pattern, event) {
+				return h.deny(client, user, "emit", room, event, fmt.Sprintf("Event %s can only be emitted by the server", event))
+			}
+		}
+	}
+
+	if permission := firstEventRule(rules, event); permission != nil && !permission.Allows(permissionContext(user)) {
+		return h.deny(client, user, "emit", room, event, refusal(user, "emit "+event))
+	}
+	if room == "" {
+		return nil
+	}
+	if permission := firstRoomEmitRule(rules, room); permission != nil && !permission.Allows(permissionContext(user)) {
+		return h.deny(client, user, "emit", room, event, refusal(user, "emit to room "+room))
+	}
+	return nil
+}
+
+// firstEventRule returns who may emit event, or nil if no rule says
+func firstEventRule(rules []*config.RealtimeRules, event string) *config.Permission {
+	for _, set := range rules {
+		for _, rule := range set.Events {
+			if matchPattern(rule.Pattern, event) {
+				return rule.Emit
+			}
+		}
+	}
+	return nil
+}
+
+// firstRoomEmitRule returns who may emit to room, or nil if no rule says
+func firstRoomEmitRule(rules []*config.RealtimeRules, room string) *config.Permission {
+	for _, set := range rules {
+		for _, rule := range set.Rooms {
+			if rule.Emit != nil && matchPattern(rule.Pattern, room) {
+				return rule.Emit
+			}
+		}
+	}
+	return nil
+}
+
+// permissionContext returns the context user's permissions are checked in,
+// the same as for their HTTP requests
+func permissionContext(user *appcontext.AuthData) *appcontext.Context {
+	return &appcontext.Context{
+		UserID:          user.UserID,
+		Username:        user.Username,
+		IsRoot:          user.IsRoot,
+		IsAuthenticated: user.IsAuthenticated,
+		Roles:           user.Roles,
+	}
+}
+
+// refusal is the message refusing user an action
+func refusal(user *appcontext.AuthData, action string) string {
+	if user.IsAuthenticated {
+		return "Not allowed to " + action
+	}
+	return "Authentication required to " + action
+}
+
+// deny logs a refused client request and returns the error to send back
+func (h *Hub) deny(client *Client, user *appcontext.AuthData, kind, room, event, message string) error {
+	logging.Warn("Realtime request denied", "realtime", map[string]interface{}{
+		"client_id":     client.ID,
+		"user_id":       user.UserID,
+		"authenticated": user.IsAuthenticated,
+		"action":        kind,
+		"room":          room,
+		"event":         event,
+		"reason":        message,
+	})
+	return errors.New(message)
+}
+
+// matchPattern reports whether name matches pattern, where "*" matches any
+// run of characters
+func matchPattern(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return strings.HasSuffix(name, parts[len(parts)-1])
+}
@@ -0,0 +1,176 @@
+package realtime
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/auth"
+	"github.com/hjanuschka/go-deployd/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		matches       bool
+	}{
+		{"chat", "chat", true},
+		{"chat", "chat:1", false},
+		{"chat:*", "chat:1", true},
+		{"chat:*", "chat:", true},
+		{"chat:*", "chats:1", false},
+		{"*", "anything", true},
+		{"*:admin", "team:admin", true},
+		{"*:admin", "team:admins", false},
+		{"a*b*c", "a-b-b-c", true},
+		{"a*b*c", "a-c", false},
+		{"a*a", "a", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.matches, matchPattern(c.pattern, c.name), "%q matching %q", c.pattern, c.name)
+	}
+}
+
+func TestRealtimeRules(t *testing.T) {
+	var rules config.RealtimeRules
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"rooms": [
+			{"pattern": "admin:*", "join": "root"},
+			{"pattern": "chat:*", "join": "authenticated", "emit": ["moderator"]}
+		],
+		"events": [{"pattern": "typing", "emit": "authenticated"}],
+		"serverOnly": ["system:*"]
+	}`), &rules))
+	require.NoError(t, rules.Validate())
+
+	jwtManager := auth.NewJWTManager("test-secret", time.Hour)
+	hub := NewHub(jwtManager, config.DefaultRealtimeConfig())
+	hub.SetSecurityRules(&rules)
+	hub.SetCollectionRules("boards", &config.RealtimeRules{Rooms: []config.RoomRule{
+		{Pattern: "boards:*", Join: &config.Permission{Roles: []string{"editor"}}},
+	}})
+	hub.SetCollectionRules("chat", &config.RealtimeRules{Rooms: []config.RoomRule{
+		{Pattern: "chat:*", Join: &config.Permission{Level: config.PermissionPublic}},
+	}})
+	// Collection rules only apply to the collection's rooms, whatever their
+	// patterns match
+	hub.SetCollectionRules("lobby", &config.RealtimeRules{
+		Rooms:      []config.RoomRule{{Pattern: "*", Join: &config.Permission{Level: config.PermissionRoot}}},
+		ServerOnly: []string{"*"},
+	})
+	hub.RegisterCollection("todos", openReader{})
+	go hub.Run()
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleWebSocket))
+	defer server.Close()
+	token := func(userID string, isRoot bool, roles ...string) string {
+		token, err := jwtManager.GenerateToken(userID, userID, isRoot, roles...)
+		require.NoError(t, err)
+		return token
+	}
+
+	anonymous := dialHub(t, server.URL, "")
+	user := dialHub(t, server.URL, token("u1", false))
+	moderator := dialHub(t, server.URL, token("u2", false, "moderator", "editor"))
+	root := dialHub(t, server.URL, token("root", true))
+
+	t.Run("limits who may join rooms", func(t *testing.T) {
+		anonymous.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "chat:1"})
+		assert.Equal(t, "Authentication required to join room chat:1", anonymous.next(t).Error,
+			"security.json rules are checked before those of collections")
+
+		user.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "admin:logs"})
+		assert.Equal(t, "Not allowed to join room admin:logs", user.next(t).Error)
+		user.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "boards:1"})
+		assert.Equal(t, "Not allowed to join room boards:1", user.next(t).Error)
+		user.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "chat:1"})
+		assert.Equal(t, PresenceJoin, user.next(t).Event)
+
+		moderator.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "boards:1"})
+		assert.Equal(t, PresenceJoin, moderator.next(t).Event)
+		root.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "admin:logs"})
+		assert.Equal(t, PresenceJoin, root.next(t).Event)
+
+		anonymous.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "lobby:1"})
+		assert.Equal(t, "Authentication required to join room lobby:1", anonymous.next(t).Error)
+		anonymous.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "lobby"})
+		require.Eventually(t, func() bool { return hub.GetRooms()["lobby"] == 1 }, 5*time.Second, 10*time.Millisecond,
+			"rooms without rules are open, and lobby belongs to no collection")
+	})
+
+	t.Run("limits who may emit", func(t *testing.T) {
+		user.send(t, WebSocketMessage{Type: MessageTypeEmit, Event: "message", Room: "chat:1"})
+		assert.Equal(t, "Not allowed to emit to room chat:1", user.next(t).Error)
+
+		anonymous.send(t, WebSocketMessage{Type: MessageTypeEmit, Event: "typing", Room: "lobby"})
+		assert.Equal(t, "Authentication required to emit typing", anonymous.next(t).Error)
+
+		moderator.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "chat:1"})
+		assert.Equal(t, PresenceJoin, user.next(t).Event)
+		assert.Equal(t, PresenceJoin, moderator.next(t).Event)
+		moderator.send(t, WebSocketMessage{Type: MessageTypeEmit, Event: "message", Room: "chat:1"})
+		msg := user.next(t)
+		assert.Equal(t, MessageTypeEmit, msg.Type)
+		assert.Equal(t, "message", msg.Event)
+	})
+
+	t.Run("keeps server-only events from every client", func(t *testing.T) {
+		root.send(t, WebSocketMessage{Type: MessageTypeEmit, Event: "system:restart"})
+		assert.Equal(t, "Event system:restart can only be emitted by the server", root.next(t).Error)
+
+		hub.EmitToRoom("chat:1", "system:restart", nil)
+		assert.Equal(t, "system:restart", user.next(t).Event)
+	})
+
+	t.Run("applies collection rules to collection changes", func(t *testing.T) {
+		todos := &config.RealtimeRules{Rooms: []config.RoomRule{
+			{Pattern: "collection:todos", Join: &config.Permission{Level: config.PermissionAuthenticated}},
+		}}
+		hub.SetCollectionRules("todos", todos)
+
+		anonymous.send(t, WebSocketMessage{Type: MessageTypeSubscribe, ID: "all", Collection: "todos"})
+		assert.Equal(t, "Authentication required to join room collection:todos", anonymous.next(t).Error)
+		anonymous.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "collection:todos"})
+		assert.Equal(t, "Authentication required to join room collection:todos", anonymous.next(t).Error)
+
+		user.send(t, WebSocketMessage{Type: MessageTypeSubscribe, ID: "all", Collection: "todos"})
+		assert.Equal(t, MessageTypeSubscribed, user.next(t).Type)
+		hub.EmitCollectionChange("todos", EventTypeCreate, map[string]interface{}{"id": "t1"})
+		assert.Equal(t, "t1", user.next(t).Data.(map[string]interface{})["id"])
+
+		// Rules are checked when each change is delivered
+		todos.Rooms[0].Join = &config.Permission{Level: config.PermissionRoot}
+		hub.SetCollectionRules("todos", todos)
+		hub.EmitCollectionChange("todos", EventTypeCreate, map[string]interface{}{"id": "t2"})
+		hub.EmitToRoom("chat:1", "marker", nil)
+		assert.Equal(t, "marker", user.next(t).Event, "no change after the rules stopped admitting the client")
+		hub.SetCollectionRules("todos", nil)
+	})
+
+	t.Run("removes collection rules", func(t *testing.T) {
+		hub.SetCollectionRules("boards", nil)
+		user.send(t, WebSocketMessage{Type: MessageTypeJoin, Room: "boards:1"})
+		assert.Equal(t, PresenceJoin, user.next(t).Event)
+	})
+
+	t.Run("refuses event streams rooms they may not join", func(t *testing.T) {
+		streamServer := httptest.NewServer(http.HandlerFunc(hub.HandleSSE))
+		defer streamServer.Close()
+
+		resp, err := http.Get(streamServer.URL + "?room=lobby&room=admin:logs")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		req, err := http.NewRequest("GET", streamServer.URL+"?room=admin:logs", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token("u1", false))
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
@@ -0,0 +1,280 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/auth"
+	"github.com/hjanuschka/go-deployd/internal/logging"
+)
+
+// MessageTypeResync tells an event source that it missed messages that can no
+// longer be replayed, so it should reload its data
+const MessageTypeResync = "resync"
+
+const (
+	// sseHistorySize is the number of fan-out messages kept for event sources
+	// resuming with Last-Event-ID
+	sseHistorySize = 1000
+	sseHeartbeat   = 30 * time.Second
+)
+
+// historyEntry is a fan-out message and who it was sent to
+type historyEntry struct {
+	seq    uint64
+	target string // The room it was sent to, or empty for all clients
+	data   []byte
+}
+
+// messageHistory numbers fan-out messages and keeps the most recent ones
+type messageHistory struct {
+	mu      sync.Mutex
+	seq     uint64
+	entries []historyEntry // Ring buffer of the last len(entries) messages
+}
+
+func newMessageHistory(size int) *messageHistory {
+	return &messageHistory{entries: make([]historyEntry, size)}
+}
+
+// add gives a message the next sequence number, creates it with create and
+// keeps it
+func (mh *messageHistory) add(target string, create func(seq uint64) []byte) []byte {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	mh.seq++
+	data := create(mh.seq)
+	mh.entries[mh.seq%uint64(len(mh.entries))] = historyEntry{seq: mh.seq, target: target, data: data}
+	return data
+}
+
+// since returns the kept messages after seq, oldest first, and the sequence
+// number of the last message. complete is false if some of the messages
+// after seq are no longer kept.
+func (mh *messageHistory) since(seq uint64) (entries []historyEntry, last uint64, complete bool) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	size := uint64(len(mh.entries))
+	if seq > mh.seq || mh.seq-seq > size {
+		return nil, mh.seq, false
+	}
+	for s := seq + 1; s <= mh.seq; s++ {
+		entries = append(entries, mh.entries[s%size])
+	}
+	return entries, mh.seq, true
+}
+
+// sseStream writes messages to an event source
+type sseStream struct {
+	w        http.ResponseWriter
+	flusher  http.Flusher
+	serverID string
+}
+
+// streamedMessage holds the fields of a message the stream looks at
+type streamedMessage struct {
+	Meta struct {
+		Seq uint64 `json:"seq"`
+	} `json:"meta"`
+}
+
+// write sends a message as an event. Fan-out messages get an id, which an
+// event source sends back as Last-Event-ID when it reconnects.
+func (s *sseStream) write(data []byte, seq uint64) error {
+	var err error
+	if seq > 0 {
+		_, err = fmt.Fprintf(s.w, "id: %s-%d\ndata: %s\n\n", s.serverID, seq, data)
+	} else {
+		_, err = fmt.Fprintf(s.w, "data: %s\n\n", data)
+	}
+	if err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// parseEventID splits an event id into the server that sent it and its
+// sequence number
+func parseEventID(id string) (string, uint64, bool) {
+	i := strings.LastIndex(id, "-")
+	if i < 0 {
+		return "", 0, false
+	}
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	return id[:i], seq, err == nil
+}
+
+// HandleSSE streams realtime messages as server-sent events, for clients that
+// cannot use WebSockets or only listen. Clients authenticate with the JWT of
+// an Authorization header or a token query parameter, and join the rooms of
+// the room query parameters. Collection changes are only sent for the
+// collection:<name> rooms joined, as the client may read them. A stream
+// asking for a room the realtime rules do not let it join, or for the
+// changes of a collection it may not read, is refused. An event source that
+// reconnects with Last-Event-ID gets the room and broadcast messages it
+// missed, or a resync message if they are no longer kept; collection changes
+// are not replayed.
+func (h *Hub) HandleSSE(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	token := query.Get("token")
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+	var claims *auth.JWTClaims
+	if token != "" {
+		var err error
+		if claims, err = h.authenticate(token); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client := &Client{
+		ID:       generateClientID(),
+		Send:     make(chan []byte, 256),
+		Hub:      h,
+		Rooms:    make(map[string]bool),
+		LastSeen: time.Now(),
+	}
+	if claims != nil {
+		client.setUser(claims)
+	}
+	for _, room := range query["room"] {
+		if room == "" {
+			continue
+		}
+		err := h.authorizeJoin(client, room)
+		if err == nil {
+			err = client.authorizeCollectionRoom(room)
+		}
+		if err != nil {
+			status := http.StatusForbidden
+			if claims == nil {
+				status = http.StatusUnauthorized
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	// The stream outlives the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.WriteHeader(http.StatusOK)
+
+	stream := &sseStream{w: w, flusher: flusher, serverID: h.serverID}
+
+	h.register <- client
+	defer func() {
+		h.unregister <- client
+	}()
+
+	// The connect message is sent once the hub has registered the client
+	if err := stream.write(<-client.Send, 0); err != nil {
+		return
+	}
+	if claims != nil {
+		if err := stream.write(h.createAuthMessage(claims), 0); err != nil {
+			return
+		}
+	}
+	for _, room := range query["room"] {
+		if room == "" {
+			continue
+		}
+		if err := client.joinCollectionRoom(room); err != nil {
+			client.sendError(err.Error())
+			continue
+		}
+		h.addToRoom(client, room)
+		h.trackPresence(client, room)
+	}
+
+	logging.Info("SSE client connected", "realtime", map[string]interface{}{
+		"client_id": client.ID,
+		"rooms":     query["room"],
+	})
+
+	// Messages up to the last one replayed may also arrive live
+	var replayed uint64
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		var err error
+		if replayed, err = h.replay(client, stream, lastID); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case data, ok := <-client.Send:
+			if !ok {
+				return
+			}
+			var msg streamedMessage
+			json.Unmarshal(data, &msg)
+			if msg.Meta.Seq > 0 && msg.Meta.Seq <= replayed {
+				continue
+			}
+			if err := stream.write(data, msg.Meta.Seq); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			logging.Info("SSE client disconnected", "realtime", map[string]interface{}{
+				"client_id": client.ID,
+			})
+			return
+		}
+	}
+}
+
+// replay sends a reconnecting client the fan-out messages it missed since the
+// event id lastID, and returns the sequence number of the last one. If they
+// are not all kept, e.g. after a restart or when the client was connected to
+// another server, it sends a resync message instead.
+func (h *Hub) replay(client *Client, stream *sseStream, lastID string) (uint64, error) {
+	serverID, seq, ok := parseEventID(lastID)
+	entries, last, complete := h.history.since(seq)
+	if !ok || serverID != h.serverID || !complete {
+		return last, stream.write(h.createMessage(MessageTypeResync, "", nil, ""), last)
+	}
+
+	h.mu.RLock()
+	rooms := make(map[string]bool, len(client.Rooms))
+	for room := range client.Rooms {
+		rooms[room] = true
+	}
+	h.mu.RUnlock()
+
+	for _, entry := range entries {
+		if entry.target != "" && !rooms[entry.target] {
+			continue
+		}
+		if err := stream.write(entry.data, entry.seq); err != nil {
+			return last, err
+		}
+	}
+	return last, nil
+}
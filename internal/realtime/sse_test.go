@@ -0,0 +1,202 @@
+package realtime
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/auth"
+	"github.com/hjanuschka/go-deployd/internal/config"
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageHistory(t *testing.T) {
+	history := newMessageHistory(3)
+	for i := 0; i < 5; i++ {
+		history.add("", func(seq uint64) []byte { return []byte{byte('0' + seq)} })
+	}
+
+	entries, last, complete := history.since(2)
+	assert.True(t, complete)
+	assert.Equal(t, uint64(5), last)
+	require.Len(t, entries, 3)
+	assert.Equal(t, []byte("3"), entries[0].data)
+	assert.Equal(t, uint64(5), entries[2].seq)
+
+	_, _, complete = history.since(1)
+	assert.False(t, complete, "message 2 is no longer kept")
+	entries, _, complete = history.since(5)
+	assert.True(t, complete)
+	assert.Empty(t, entries)
+	_, _, complete = history.since(9)
+	assert.False(t, complete)
+}
+
+type sseEvent struct {
+	ID      string
+	Message WebSocketMessage
+}
+
+// readEvents reads the events of a stream until it ends
+func readEvents(body *bufio.Scanner) <-chan sseEvent {
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		var event sseEvent
+		for body.Scan() {
+			line := body.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				event.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.Message)
+			case line == "" && event.Message.Type != "":
+				events <- event
+				event = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "stream ended")
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+	return sseEvent{}
+}
+
+// openReader lets every client read every document of a collection
+type openReader struct{}
+
+func (openReader) AuthorizeSubscription(*appcontext.AuthData) error { return nil }
+
+func (openReader) ReadDocument(_ *appcontext.AuthData, doc map[string]interface{}) (map[string]interface{}, bool) {
+	return doc, true
+}
+
+func TestHandleSSE(t *testing.T) {
+	jwtManager := auth.NewJWTManager("test-secret", time.Hour)
+	hub := NewHub(jwtManager, config.DefaultRealtimeConfig())
+	hub.RegisterCollection("todos", openReader{})
+	hub.RegisterCollection("posts", openReader{})
+	go hub.Run()
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleSSE))
+	defer server.Close()
+
+	token, err := jwtManager.GenerateToken("u1", "alice", false)
+	require.NoError(t, err)
+
+	connect := func(t *testing.T, query, lastEventID string) (<-chan sseEvent, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"?"+query, nil)
+		require.NoError(t, err)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		return readEvents(bufio.NewScanner(resp.Body)), func() {
+			cancel()
+			resp.Body.Close()
+		}
+	}
+	waitForRoom := func(room string, size int) {
+		require.Eventually(t, func() bool { return hub.GetRooms()[room] == size }, 5*time.Second, 10*time.Millisecond)
+	}
+
+	var lastID string
+	t.Run("streams the messages of joined rooms", func(t *testing.T) {
+		events, disconnect := connect(t, "token="+token+"&room=chat&room=collection:todos", "")
+		defer disconnect()
+
+		assert.Equal(t, MessageTypeConnect, nextEvent(t, events).Message.Type)
+		authEvent := nextEvent(t, events)
+		assert.Equal(t, MessageTypeAuth, authEvent.Message.Type)
+		assert.Equal(t, "u1", authEvent.Message.Data.(map[string]interface{})["user_id"])
+		waitForRoom("chat", 1)
+		presence := nextEvent(t, events)
+		assert.Equal(t, MessageTypePresence, presence.Message.Type)
+		assert.Equal(t, PresenceJoin, presence.Message.Event)
+		assert.Equal(t, "chat", presence.Message.Room)
+
+		hub.EmitToRoom("chat", "message", map[string]interface{}{"text": "hi"})
+		event := nextEvent(t, events)
+		assert.Equal(t, "message", event.Message.Event)
+		assert.Equal(t, "chat", event.Message.Room)
+		assert.True(t, strings.HasPrefix(event.ID, hub.serverID+"-"), event.ID)
+
+		hub.EmitToRoom("elsewhere", "message", nil)
+		hub.EmitCollectionChange("posts", EventTypeCreate, map[string]interface{}{"id": "p1"})
+		hub.EmitCollectionChange("todos", EventTypeCreate, map[string]interface{}{"id": "t1"})
+		event = nextEvent(t, events)
+		assert.Equal(t, "collection:todos", event.Message.Room, "only joined collections are streamed")
+		assert.Equal(t, EventTypeCreate, event.Message.Event)
+		assert.Empty(t, event.ID, "collection changes are read for each client and not replayed")
+
+		hub.EmitToRoom("chat", "marker", nil)
+		event = nextEvent(t, events)
+		assert.Equal(t, "marker", event.Message.Event)
+		lastID = event.ID
+	})
+
+	t.Run("replays missed messages after Last-Event-ID", func(t *testing.T) {
+		// Leaving is sent to the room once the client is gone
+		waitForHistory := func(size uint64) {
+			require.Eventually(t, func() bool {
+				_, last, _ := hub.history.since(0)
+				return last >= size
+			}, 5*time.Second, 10*time.Millisecond)
+		}
+		waitForHistory(5)
+		hub.EmitToRoom("chat", "missed", nil)
+		waitForHistory(6)
+
+		events, disconnect := connect(t, "room=chat&room=collection:todos", lastID)
+		defer disconnect()
+		assert.Equal(t, MessageTypeConnect, nextEvent(t, events).Message.Type)
+		event := nextEvent(t, events)
+		assert.Equal(t, PresenceLeave, event.Message.Event)
+		event = nextEvent(t, events)
+		assert.Equal(t, "missed", event.Message.Event)
+
+		hub.EmitToRoom("chat", "live", nil)
+		assert.Equal(t, "live", nextEvent(t, events).Message.Event, "replayed messages are not sent twice")
+	})
+
+	t.Run("asks to resync when messages are lost", func(t *testing.T) {
+		events, disconnect := connect(t, "room=chat", "server_1-3")
+		defer disconnect()
+		assert.Equal(t, MessageTypeConnect, nextEvent(t, events).Message.Type)
+		event := nextEvent(t, events)
+		assert.Equal(t, MessageTypeResync, event.Message.Type)
+		assert.NotEmpty(t, event.ID)
+	})
+
+	t.Run("refuses collections it does not know", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "?room=collection:missing")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("rejects invalid tokens", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "?token=invalid")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
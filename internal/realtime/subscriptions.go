@@ -0,0 +1,347 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/auth"
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/database"
+	"github.com/hjanuschka/go-deployd/internal/logging"
+)
+
+// Message types for query subscriptions
+const (
+	MessageTypeSubscribe   = "subscribe"
+	MessageTypeUnsubscribe = "unsubscribe"
+	MessageTypeSubscribed  = "subscribed"
+)
+
+// maxSubscriptionsPerClient limits the query subscriptions of a client
+const maxSubscriptionsPerClient = 100
+
+// CollectionReader gives realtime subscribers access to the changed documents
+// of a collection the way a GET request would: with the collection's
+// permissions, Get event and hidden fields
+type CollectionReader interface {
+	// AuthorizeSubscription returns an error if user may not read the
+	// collection
+	AuthorizeSubscription(user *appcontext.AuthData) error
+	// ReadDocument returns a copy of doc as user may read it, or false if
+	// user may not read it
+	ReadDocument(user *appcontext.AuthData, doc map[string]interface{}) (map[string]interface{}, bool)
+}
+
+// subscription delivers the changed documents of a collection that match a
+// query
+type subscription struct {
+	id         string
+	collection string
+	query      map[string]interface{}
+}
+
+// collectionRoomPrefix starts the names of collection rooms. Joining the
+// room collection:<name> subscribes to every change of the collection.
+const collectionRoomPrefix = "collection:"
+
+// RegisterCollection lets clients subscribe to the changes of a collection,
+// read through reader. Registering a name again replaces its reader.
+func (h *Hub) RegisterCollection(name string, reader CollectionReader) {
+	h.mu.Lock()
+	h.readers[name] = reader
+	h.mu.Unlock()
+}
+
+// authData returns who the client is authenticated as
+func (c *Client) authData() *appcontext.AuthData {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	claims, ok := c.User.(*auth.JWTClaims)
+	if !ok || claims == nil {
+		return &appcontext.AuthData{}
+	}
+	return &appcontext.AuthData{
+		UserID:          claims.UserID,
+		Username:        claims.Username,
+		IsRoot:          claims.IsRoot,
+		IsAuthenticated: true,
+		Roles:           claims.Roles,
+	}
+}
+
+// subscribesTo reports whether the client has query subscriptions on a
+// collection
+func (c *Client) subscribesTo(collection string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, sub := range c.subscriptions {
+		if sub.collection == collection {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriptionsTo returns the client's query subscriptions on a collection
+func (c *Client) subscriptionsTo(collection string) []*subscription {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var subs []*subscription
+	for _, sub := range c.subscriptions {
+		if sub.collection == collection {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// handleSubscribe subscribes the client to the changes of a collection that
+// match query. An empty query matches every document.
+func (c *Client) handleSubscribe(id, collection string, query map[string]interface{}) {
+	if collection == "" {
+		c.sendError("Collection is required")
+		return
+	}
+	if query == nil {
+		query = map[string]interface{}{}
+	}
+	// Catch unsupported operators before any change arrives
+	if _, err := database.MatchDocument(map[string]interface{}{}, query); err != nil {
+		c.sendError(fmt.Sprintf("Invalid query: %s", err.Error()))
+		return
+	}
+	if err := c.authorizeSubscription(collection); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	if id == "" {
+		id = fmt.Sprintf("sub_%d", time.Now().UnixNano())
+	}
+	if err := c.subscribe(id, collection, query); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+
+	c.Send <- c.Hub.createMessage(MessageTypeSubscribed, "", map[string]interface{}{
+		"id":         id,
+		"collection": collection,
+	}, "")
+}
+
+// handleUnsubscribe ends a query subscription of the client
+func (c *Client) handleUnsubscribe(id string) {
+	if !c.unsubscribe(id) {
+		c.sendError(fmt.Sprintf("Unknown subscription: %s", id))
+	}
+}
+
+// authorizeSubscription checks that the client may read the changes of a
+// collection: the realtime rules must let it join the collection room, which
+// query subscriptions are delivered in, and the collection must let it read
+func (c *Client) authorizeSubscription(collection string) error {
+	c.Hub.mu.RLock()
+	reader, ok := c.Hub.readers[collection]
+	c.Hub.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("Unknown collection: %s", collection)
+	}
+	if err := c.Hub.authorizeJoin(c, collectionRoomPrefix+collection); err != nil {
+		return err
+	}
+	return reader.AuthorizeSubscription(c.authData())
+}
+
+// subscribe adds an authorized subscription to the changes of a collection
+// that match query, replacing the client's subscription with the same id
+func (c *Client) subscribe(id, collection string, query map[string]interface{}) error {
+	c.mu.Lock()
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[string]*subscription)
+	}
+	if _, exists := c.subscriptions[id]; !exists && len(c.subscriptions) >= maxSubscriptionsPerClient {
+		c.mu.Unlock()
+		return fmt.Errorf("Too many subscriptions (maximum %d)", maxSubscriptionsPerClient)
+	}
+	c.subscriptions[id] = &subscription{id: id, collection: collection, query: query}
+	c.mu.Unlock()
+
+	c.Hub.mu.Lock()
+	if c.Hub.subscribers[collection] == nil {
+		c.Hub.subscribers[collection] = make(map[*Client]bool)
+	}
+	c.Hub.subscribers[collection][c] = true
+	c.Hub.mu.Unlock()
+
+	logging.Info("Client subscribed to collection", "realtime", map[string]interface{}{
+		"client_id":    c.ID,
+		"subscription": id,
+		"collection":   collection,
+	})
+	return nil
+}
+
+// unsubscribe ends a subscription of the client and reports whether it had
+// one with that id
+func (c *Client) unsubscribe(id string) bool {
+	c.mu.Lock()
+	sub, ok := c.subscriptions[id]
+	if ok {
+		delete(c.subscriptions, id)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	if !c.subscribesTo(sub.collection) {
+		c.Hub.mu.Lock()
+		delete(c.Hub.subscribers[sub.collection], c)
+		if len(c.Hub.subscribers[sub.collection]) == 0 {
+			delete(c.Hub.subscribers, sub.collection)
+		}
+		c.Hub.mu.Unlock()
+	}
+	return true
+}
+
+// authorizeCollectionRoom checks that the client may read the changes of
+// the collection, if room is a collection room
+func (c *Client) authorizeCollectionRoom(room string) error {
+	if collection, ok := strings.CutPrefix(room, collectionRoomPrefix); ok {
+		return c.authorizeSubscription(collection)
+	}
+	return nil
+}
+
+// joinCollectionRoom subscribes the client to every change of the collection,
+// if room is a collection room. The subscription is named after the room.
+func (c *Client) joinCollectionRoom(room string) error {
+	if collection, ok := strings.CutPrefix(room, collectionRoomPrefix); ok {
+		return c.subscribe(room, collection, map[string]interface{}{})
+	}
+	return nil
+}
+
+// leaveCollectionRoom ends the subscription of a collection room
+func (c *Client) leaveCollectionRoom(room string) {
+	if strings.HasPrefix(room, collectionRoomPrefix) {
+		c.unsubscribe(room)
+	}
+}
+
+// dropSubscriptions removes a disconnected client from the subscribers. h.mu
+// must be held.
+func (h *Hub) dropSubscriptions(client *Client) {
+	for collection, clients := range h.subscribers {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(h.subscribers, collection)
+		}
+	}
+}
+
+// notifySubscribers sends a changed document to the clients with matching
+// subscriptions on its collection, the only clients collection changes are
+// sent to. The realtime rules are checked again for each change, so clients
+// the rules of the collection room no longer admit get nothing. Each client
+// gets the document as it may read it, which the queries are matched
+// against, so they can't test hidden fields.
+func (h *Hub) notifySubscribers(collection, eventType string, data interface{}) {
+	doc, ok := data.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	h.mu.RLock()
+	reader := h.readers[collection]
+	clients := make([]*Client, 0, len(h.subscribers[collection]))
+	for client := range h.subscribers[collection] {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+	if reader == nil {
+		return
+	}
+
+	room := collectionRoomPrefix + collection
+	for _, client := range clients {
+		subs := client.subscriptionsTo(collection)
+		if len(subs) == 0 {
+			continue
+		}
+		user := client.authData()
+		if message := h.joinRefusal(user, room); message != "" {
+			logging.Debug("Collection change withheld by realtime rules", "realtime", map[string]interface{}{
+				"client_id": client.ID,
+				"user_id":   user.UserID,
+				"room":      room,
+				"reason":    message,
+			})
+			continue
+		}
+		readable, ok := reader.ReadDocument(user, doc)
+		if !ok {
+			continue
+		}
+
+		var matched []string
+		for _, sub := range subs {
+			match, err := database.MatchDocument(readable, sub.query)
+			if err != nil {
+				logging.Debug("Subscription query failed", "realtime", map[string]interface{}{
+					"client_id":    client.ID,
+					"subscription": sub.id,
+					"error":        err.Error(),
+				})
+				continue
+			}
+			if match {
+				matched = append(matched, sub.id)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+
+		h.sendToClient(client, h.marshalSubscriptionMessage(eventType, readable, room, matched))
+	}
+}
+
+// sendToClient sends a message to a client unless it disconnected or its
+// buffer is full
+func (h *Hub) sendToClient(client *Client, message []byte) {
+	// Send is closed when the client is unregistered, which holds h.mu
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if !h.clients[client] {
+		return
+	}
+	select {
+	case client.Send <- message:
+	default:
+		logging.Debug("Client send buffer full, message dropped", "realtime", map[string]interface{}{
+			"client_id": client.ID,
+		})
+	}
+}
+
+// marshalSubscriptionMessage encodes a changed document for the subscriptions
+// it matched, listed in meta.subscriptions
+func (h *Hub) marshalSubscriptionMessage(eventType string, doc map[string]interface{}, room string, subscriptions []string) []byte {
+	data, err := json.Marshal(WebSocketMessage{
+		Type:  MessageTypeEmit,
+		Event: eventType,
+		Data:  doc,
+		Room:  room,
+		Meta: map[string]interface{}{
+			"timestamp":     time.Now().Unix(),
+			"subscriptions": subscriptions,
+		},
+	})
+	if err != nil {
+		return []byte(`{"type":"error","error":"Failed to marshal message"}`)
+	}
+	return data
+}
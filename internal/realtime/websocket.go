@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -35,13 +36,16 @@ const (
 
 // WebSocketMessage represents a message sent over WebSocket
 type WebSocketMessage struct {
-	Type    string                 `json:"type"`
-	Event   string                 `json:"event,omitempty"`
-	Data    interface{}            `json:"data,omitempty"`
-	Room    string                 `json:"room,omitempty"`
-	Token   string                 `json:"token,omitempty"`
-	Error   string                 `json:"error,omitempty"`
-	Meta    map[string]interface{} `json:"meta,omitempty"`
+	Type       string                 `json:"type"`
+	Event      string                 `json:"event,omitempty"`
+	Data       interface{}            `json:"data,omitempty"`
+	Room       string                 `json:"room,omitempty"`
+	Token      string                 `json:"token,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Meta       map[string]interface{} `json:"meta,omitempty"`
+	ID         string                 `json:"id,omitempty"`         // Subscription ID
+	Collection string                 `json:"collection,omitempty"` // Collection to subscribe to
+	Query      map[string]interface{} `json:"query,omitempty"`      // Query changed documents must match
 }
 
 // Client represents a connected WebSocket client
@@ -55,6 +59,8 @@ type Client struct {
 	IsRoot   bool        // Admin privileges
 	LastSeen time.Time
 	mu       sync.RWMutex
+	// subscriptions holds the client's query subscriptions by ID
+	subscriptions map[string]*subscription
 }
 
 // Hub maintains the set of active clients and broadcasts messages to them
@@ -69,7 +75,17 @@ type Hub struct {
 	config     *config.RealtimeConfig
 	broker     MessageBroker
 	serverID   string
-	mu         sync.RWMutex
+	stats      brokerStats
+	history    *messageHistory // Recent fan-out messages, for SSE resume
+	// subscribers holds the clients with query subscriptions by collection,
+	// and readers the collections they read changed documents through
+	subscribers map[string]map[*Client]bool
+	readers     map[string]CollectionReader
+	presence    *presenceTracker
+	rules       ruleSet // Who may join rooms and emit events
+	mu          sync.RWMutex
+	done        chan struct{} // Closed by Close
+	closeOnce   sync.Once
 }
 
 // NewHub creates a new WebSocket hub
@@ -84,15 +100,20 @@ func NewHub(jwtManager *auth.JWTManager, realtimeConfig *config.RealtimeConfig)
 	}
 
 	hub := &Hub{
-		clients:    make(map[*Client]bool),
-		rooms:      make(map[string]map[*Client]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		jwtManager: jwtManager,
-		config:     realtimeConfig,
-		broker:     broker,
-		serverID:   generateServerID(),
+		clients:     make(map[*Client]bool),
+		rooms:       make(map[string]map[*Client]bool),
+		broadcast:   make(chan []byte),
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
+		jwtManager:  jwtManager,
+		config:      realtimeConfig,
+		broker:      broker,
+		serverID:    generateServerID(),
+		history:     newMessageHistory(sseHistorySize),
+		subscribers: make(map[string]map[*Client]bool),
+		readers:     make(map[string]CollectionReader),
+		presence:    newPresenceTracker(),
+		done:        make(chan struct{}),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				// Allow all origins in development, restrict in production
@@ -110,6 +131,12 @@ func NewHub(jwtManager *auth.JWTManager, realtimeConfig *config.RealtimeConfig)
 		})
 	}
 
+	// Ask the other servers who is in which room, and keep them informed
+	if realtimeConfig.IsMultiServerMode() {
+		hub.publishToBroker(TopicPresence, &BrokerMessage{Type: MessageTypePresence, Event: presenceSync})
+		go hub.runPresence()
+	}
+
 	// Start periodic cleanup of dead clients
 	go hub.startCleanupRoutine()
 
@@ -144,10 +171,13 @@ func (h *Hub) Run() {
 				
 				// Remove client from all rooms
 				for room := range client.Rooms {
-					h.removeFromRoom(client, room)
+					h.leaveRoom(client, room)
 				}
+				h.dropSubscriptions(client)
 			}
 			h.mu.Unlock()
+			// Dead clients may have been removed already, but not from presence
+			go h.dropPresence(client)
 			
 			logging.Info("WebSocket client disconnected", "realtime", map[string]interface{}{
 				"client_id": client.ID,
@@ -202,7 +232,7 @@ func (c *Client) readPump() {
 		c.Conn.Close()
 	}()
 
-	c.Conn.SetReadLimit(512)
+	c.Conn.SetReadLimit(4096) // Subscribe messages carry a query
 	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -286,6 +316,12 @@ func (c *Client) handleMessage(data []byte) {
 		c.handleLeave(msg.Room)
 	case MessageTypeEmit:
 		c.handleEmit(msg.Event, msg.Data, msg.Room)
+	case MessageTypeSubscribe:
+		c.handleSubscribe(msg.ID, msg.Collection, msg.Query)
+	case MessageTypeUnsubscribe:
+		c.handleUnsubscribe(msg.ID)
+	case MessageTypePresence:
+		c.handlePresence(msg.Event, msg.Room, msg.Data)
 	default:
 		c.sendError(fmt.Sprintf("Unknown message type: %s", msg.Type))
 	}
@@ -293,21 +329,17 @@ func (c *Client) handleMessage(data []byte) {
 
 // handleAuth authenticates the client using JWT token
 func (c *Client) handleAuth(token string) {
-	if c.Hub.jwtManager == nil {
-		c.sendError("Authentication not available")
-		return
-	}
-
-	claims, err := c.Hub.jwtManager.ValidateToken(token)
+	claims, err := c.Hub.authenticate(token)
 	if err != nil {
-		c.sendError("Invalid authentication token")
+		c.sendError(err.Error())
 		return
 	}
-
-	c.mu.Lock()
-	c.User = claims
-	c.IsRoot = claims.IsRoot
-	c.mu.Unlock()
+	previous := c.authData().UserID
+	c.setUser(claims)
+	if previous != claims.UserID {
+		c.Hub.dropPresence(c)
+		c.Hub.trackRooms(c)
+	}
 
 	logging.Info("WebSocket client authenticated", "realtime", map[string]interface{}{
 		"client_id": c.ID,
@@ -316,7 +348,31 @@ func (c *Client) handleAuth(token string) {
 	})
 
 	// Send auth success
-	c.Send <- c.Hub.createMessage(MessageTypeAuth, "", map[string]interface{}{
+	c.Send <- c.Hub.createAuthMessage(claims)
+}
+
+// authenticate validates the JWT token a client authenticates with
+func (h *Hub) authenticate(token string) (*auth.JWTClaims, error) {
+	if h.jwtManager == nil {
+		return nil, fmt.Errorf("Authentication not available")
+	}
+	claims, err := h.jwtManager.ValidateToken(token)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid authentication token")
+	}
+	return claims, nil
+}
+
+// setUser marks the client as authenticated as the user of claims
+func (c *Client) setUser(claims *auth.JWTClaims) {
+	c.mu.Lock()
+	c.User = claims
+	c.IsRoot = claims.IsRoot
+	c.mu.Unlock()
+}
+
+func (h *Hub) createAuthMessage(claims *auth.JWTClaims) []byte {
+	return h.createMessage(MessageTypeAuth, "", map[string]interface{}{
 		"authenticated": true,
 		"user_id":       claims.UserID,
 		"is_root":       claims.IsRoot,
@@ -329,8 +385,21 @@ func (c *Client) handleJoin(room string) {
 		c.sendError("Room name required")
 		return
 	}
+	if err := c.Hub.authorizeJoin(c, room); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	if err := c.authorizeCollectionRoom(room); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	if err := c.joinCollectionRoom(room); err != nil {
+		c.sendError(err.Error())
+		return
+	}
 
 	c.Hub.addToRoom(c, room)
+	c.Hub.trackPresence(c, room)
 	
 	logging.Debug("Client joined room", "realtime", map[string]interface{}{
 		"client_id": c.ID,
@@ -346,6 +415,8 @@ func (c *Client) handleLeave(room string) {
 	}
 
 	c.Hub.removeFromRoom(c, room)
+	c.Hub.untrackPresence(c, room)
+	c.leaveCollectionRoom(room)
 	
 	logging.Debug("Client left room", "realtime", map[string]interface{}{
 		"client_id": c.ID,
@@ -360,13 +431,15 @@ func (c *Client) handleEmit(event string, data interface{}, room string) {
 		return
 	}
 
-	// TODO: Implement permission checking for events
-	message := c.Hub.createMessage(MessageTypeEmit, event, data, room)
-	
+	if err := c.Hub.authorizeEmit(c, event, room); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+
 	if room != "" {
 		c.Hub.EmitToRoom(room, event, data)
 	} else {
-		c.Hub.broadcast <- message
+		c.Hub.EmitToAll(event, data)
 	}
 }
 
@@ -404,7 +477,11 @@ func (h *Hub) addToRoom(client *Client, room string) {
 func (h *Hub) removeFromRoom(client *Client, room string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	
+	h.leaveRoom(client, room)
+}
+
+// leaveRoom removes a client from a room; h.mu must be held
+func (h *Hub) leaveRoom(client *Client, room string) {
 	if clients, ok := h.rooms[room]; ok {
 		delete(clients, client)
 		if len(clients) == 0 {
@@ -414,10 +491,26 @@ func (h *Hub) removeFromRoom(client *Client, room string) {
 	delete(client.Rooms, room)
 }
 
-// EmitToRoom sends a message to all clients in a specific room
+// EmitToRoom sends a message to all clients in a specific room, on this
+// server and through the message broker on the others
 func (h *Hub) EmitToRoom(room, event string, data interface{}) {
-	message := h.createMessage(MessageTypeEmit, event, data, room)
-	
+	h.emitToRoom(room, event, data)
+	h.publishToBroker(TopicCustomEvents, &BrokerMessage{
+		Type:  MessageTypeEmit,
+		Event: event,
+		Data:  data,
+		Room:  room,
+	})
+}
+
+// emitToRoom sends a message to the clients in a room on this server
+func (h *Hub) emitToRoom(room, event string, data interface{}) {
+	h.sendToRoom(room, h.createFanoutMessage(room, MessageTypeEmit, event, data, room), event)
+}
+
+// sendToRoom sends an encoded message to the clients in a room on this server
+func (h *Hub) sendToRoom(room string, message []byte, event string) {
+
 	h.mu.RLock()
 	clients, ok := h.rooms[room]
 	clientCount := 0
@@ -496,14 +589,33 @@ func (h *Hub) EmitToRoom(room, event string, data interface{}) {
 
 // EmitToAll sends a message to all connected clients
 func (h *Hub) EmitToAll(event string, data interface{}) {
-	message := h.createMessage(MessageTypeEmit, event, data, "")
+	message := h.createFanoutMessage("", MessageTypeEmit, event, data, "")
 	h.broadcast <- message
+	h.publishToBroker(TopicCustomEvents, &BrokerMessage{
+		Type:  MessageTypeEmit,
+		Event: event,
+		Data:  data,
+	})
 }
 
-// EmitCollectionChange sends collection change notifications
+// EmitCollectionChange sends a changed document to the clients subscribed to
+// its collection, on this server and through the message broker on the
+// others
 func (h *Hub) EmitCollectionChange(collection, eventType string, data interface{}) {
-	// Create message and send to broadcast channel asynchronously
-	// This completely avoids any mutex operations or room lookups
+	h.emitCollectionChange(collection, eventType, data)
+	h.publishToBroker(TopicCollectionChanges, &BrokerMessage{
+		Type:  MessageTypeEmit,
+		Event: eventType,
+		Data:  data,
+		Room:  fmt.Sprintf("collection:%s", collection),
+		Meta:  map[string]interface{}{"collection": collection},
+	})
+}
+
+// emitCollectionChange notifies the subscribed clients on this server of a
+// collection change. Documents are read for each client asynchronously, so
+// the write that changed them does not wait for events.
+func (h *Hub) emitCollectionChange(collection, eventType string, data interface{}) {
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
@@ -512,23 +624,7 @@ func (h *Hub) EmitCollectionChange(collection, eventType string, data interface{
 				})
 			}
 		}()
-		
-		room := fmt.Sprintf("collection:%s", collection)
-		message := h.createMessage(MessageTypeEmit, eventType, data, room)
-		
-		// Use select to prevent blocking if broadcast channel is full
-		select {
-		case h.broadcast <- message:
-			logging.Debug("EmitCollectionChange: Message broadcasted", "realtime", map[string]interface{}{
-				"collection": collection,
-				"event":      eventType,
-			})
-		case <-time.After(50 * time.Millisecond):
-			logging.Debug("EmitCollectionChange: Broadcast timeout, skipped", "realtime", map[string]interface{}{
-				"collection": collection,
-				"event":      eventType,
-			})
-		}
+		h.notifySubscribers(collection, eventType, data)
 	}()
 }
 
@@ -546,6 +642,21 @@ func getDataKeys(data interface{}) []string {
 
 // createMessage creates a WebSocket message
 func (h *Hub) createMessage(msgType, event string, data interface{}, room string) []byte {
+	return h.marshalMessage(msgType, event, data, room, 0)
+}
+
+// createFanoutMessage creates a message sent to the clients in the target
+// room, or to all clients if target is empty, and keeps it in the history
+// that event sources resume from
+func (h *Hub) createFanoutMessage(target, msgType, event string, data interface{}, room string) []byte {
+	return h.history.add(target, func(seq uint64) []byte {
+		return h.marshalMessage(msgType, event, data, room, seq)
+	})
+}
+
+// marshalMessage encodes a message; fan-out messages carry their sequence
+// number in meta.seq
+func (h *Hub) marshalMessage(msgType, event string, data interface{}, room string, seq uint64) []byte {
 	msg := WebSocketMessage{
 		Type:  msgType,
 		Event: event,
@@ -555,6 +666,9 @@ func (h *Hub) createMessage(msgType, event string, data interface{}, room string
 			"timestamp": time.Now().Unix(),
 		},
 	}
+	if seq > 0 {
+		msg.Meta["seq"] = seq
+	}
 	
 	if bytes, err := json.Marshal(msg); err == nil {
 		return bytes
@@ -568,13 +682,8 @@ func (h *Hub) initializeBroker() error {
 		return fmt.Errorf("no message broker configured")
 	}
 
-	// Connect to broker
-	ctx := context.Background()
-	if err := h.broker.Connect(ctx); err != nil {
-		return fmt.Errorf("failed to connect to message broker: %w", err)
-	}
-
-	// Subscribe to collection changes from other servers
+	// Subscribe to collection changes from other servers. Network brokers
+	// subscribe once connected, so they also do after reconnecting.
 	if err := h.broker.Subscribe(TopicCollectionChanges, h.handleBrokerMessage); err != nil {
 		return fmt.Errorf("failed to subscribe to collection changes: %w", err)
 	}
@@ -584,6 +693,17 @@ func (h *Hub) initializeBroker() error {
 		return fmt.Errorf("failed to subscribe to custom events: %w", err)
 	}
 
+	// Subscribe to room presence on other servers
+	if err := h.broker.Subscribe(TopicPresence, h.handlePresenceMessage); err != nil {
+		return fmt.Errorf("failed to subscribe to presence: %w", err)
+	}
+
+	// Connect to broker; network brokers keep retrying in the background
+	ctx := context.Background()
+	if err := h.broker.Connect(ctx); err != nil {
+		return fmt.Errorf("failed to connect to message broker: %w", err)
+	}
+
 	logging.Info("Message broker initialized", "realtime", map[string]interface{}{
 		"server_id":      h.serverID,
 		"broker_type":    h.config.Broker.Type,
@@ -593,12 +713,16 @@ func (h *Hub) initializeBroker() error {
 	return nil
 }
 
-// handleBrokerMessage handles messages received from the message broker
+// handleBrokerMessage handles messages received from the message broker.
+// They are delivered to the clients on this server only, never published
+// again, so messages do not loop between servers.
 func (h *Hub) handleBrokerMessage(message *BrokerMessage) error {
 	// Don't process messages from our own server
 	if message.ServerID == h.serverID {
+		h.stats.ignored.Add(1)
 		return nil
 	}
+	h.stats.received.Add(1)
 
 	logging.Debug("Received broker message", "realtime", map[string]interface{}{
 		"type":      message.Type,
@@ -608,41 +732,85 @@ func (h *Hub) handleBrokerMessage(message *BrokerMessage) error {
 	})
 
 	// Convert broker message to WebSocket message and broadcast locally
-	wsMessage := h.createMessage(message.Type, message.Event, message.Data, message.Room)
-	
-	if message.Room != "" {
-		h.EmitToRoom(message.Room, message.Event, message.Data)
+	if collection, ok := message.Meta["collection"].(string); ok && collection != "" {
+		h.emitCollectionChange(collection, message.Event, message.Data)
+	} else if message.Room != "" {
+		h.emitToRoom(message.Room, message.Event, message.Data)
 	} else {
-		h.broadcast <- wsMessage
+		h.broadcast <- h.createFanoutMessage("", message.Type, message.Event, message.Data, message.Room)
 	}
 
 	return nil
 }
 
 // publishToBroker publishes a message to the broker for multi-server distribution
-func (h *Hub) publishToBroker(topic string, msgType, event string, data interface{}, room string) {
-	if !h.config.IsMultiServerMode() {
+func (h *Hub) publishToBroker(topic string, message *BrokerMessage) {
+	if !h.config.IsMultiServerMode() || h.broker == nil {
 		return
 	}
 
-	brokerMessage := &BrokerMessage{
-		Type:      msgType,
-		Event:     event,
-		Data:      data,
-		Room:      room,
-		ServerID:  h.serverID,
-		Timestamp: time.Now().Unix(),
-		Meta: map[string]interface{}{
-			"source": "websocket_hub",
-		},
+	message.ServerID = h.serverID
+	message.Timestamp = time.Now().Unix()
+	if message.Meta == nil {
+		message.Meta = make(map[string]interface{})
 	}
+	message.Meta["source"] = "websocket_hub"
 
-	if err := h.broker.Publish(topic, brokerMessage); err != nil {
+	if err := h.broker.Publish(topic, message); err != nil {
+		h.stats.publishErrors.Add(1)
 		logging.Error("Failed to publish to broker", "realtime", map[string]interface{}{
 			"topic": topic,
 			"error": err.Error(),
 		})
+		return
+	}
+	h.stats.published.Add(1)
+}
+
+// brokerStats counts the messages exchanged through the message broker
+type brokerStats struct {
+	published     atomic.Int64
+	publishErrors atomic.Int64
+	received      atomic.Int64 // From other servers
+	ignored       atomic.Int64 // Published by this server and received back
+}
+
+// BrokerStats reports the message broker, its connection and the messages
+// exchanged through it
+func (h *Hub) BrokerStats() map[string]interface{} {
+	brokerType := "memory"
+	if h.config.Broker.Enabled {
+		brokerType = h.config.Broker.Type
+	}
+	stats := map[string]interface{}{
+		"type":           brokerType,
+		"multi_server":   h.config.IsMultiServerMode(),
+		"server_id":      h.serverID,
+		"connected":      h.broker != nil && h.broker.IsConnected(),
+		"published":      h.stats.published.Load(),
+		"publish_errors": h.stats.publishErrors.Load(),
+		"received":       h.stats.received.Load(),
+		"ignored":        h.stats.ignored.Load(),
+	}
+	if reporter, ok := h.broker.(healthReporter); ok {
+		stats["health"] = reporter.Health()
 	}
+	return stats
+}
+
+// Close disconnects the hub from the message broker, telling the other
+// servers its clients left
+func (h *Hub) Close() error {
+	h.closeOnce.Do(func() { close(h.done) })
+	if h.broker == nil {
+		return nil
+	}
+	h.publishToBroker(TopicPresence, &BrokerMessage{
+		Type:  MessageTypePresence,
+		Event: PresenceState,
+		Data:  map[string][]PresenceMember{},
+	})
+	return h.broker.Disconnect()
 }
 
 // generateClientID generates a unique client ID
@@ -0,0 +1,589 @@
+package resources
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/database"
+	"github.com/hjanuschka/go-deployd/internal/events"
+	"github.com/hjanuschka/go-deployd/internal/logging"
+)
+
+// Limits of POST /<collection>/_bulk
+const (
+	maxBulkOperations = 10000
+	bulkInsertBatch   = 500 // Inserts written with one InsertMany call
+)
+
+// Operations of a bulk request
+const (
+	BulkInsert = "insert"
+	BulkUpdate = "update"
+	BulkDelete = "delete"
+
+	// bulkUpsert updates the document with the operation's id, or inserts it
+	// with that id. Only imports use it.
+	bulkUpsert = "upsert"
+)
+
+// bulkOperation is one entry of a bulk request's operations
+type bulkOperation struct {
+	Op   string
+	ID   string
+	Data map[string]interface{}
+}
+
+// bulkResult is the outcome of one operation, reported at its index
+type bulkResult struct {
+	Index   int               `json:"index"`
+	Op      string            `json:"op"`
+	Status  int               `json:"status"`
+	ID      interface{}       `json:"id,omitempty"`
+	Message string            `json:"message,omitempty"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// bulkError is why a single operation failed
+type bulkError struct {
+	status  int
+	message string
+	errors  map[string]string
+}
+
+func (e *bulkError) Error() string {
+	return e.message
+}
+
+// toBulkError maps the errors of validation, events and stores to the status
+// the single-document endpoints respond with
+func (c *Collection) toBulkError(err error) *bulkError {
+	switch e := err.(type) {
+	case *bulkError:
+		return e
+	case *events.ValidationError:
+		return &bulkError{status: 400, message: "validation errors", errors: e.Errors}
+	case *events.ScriptError:
+		return &bulkError{status: e.StatusCode, message: e.Message}
+	}
+	if dupErr, ok := database.IsDuplicateKey(err, c.name); ok {
+		if dupErr.Field == "" {
+			return &bulkError{status: 409, message: dupErr.Error()}
+		}
+		return &bulkError{status: 409, message: dupErr.Error(), errors: map[string]string{dupErr.Field: "must be unique"}}
+	}
+	return &bulkError{status: 500, message: err.Error()}
+}
+
+// parseBulkOperations reads the operations of a bulk request body
+func parseBulkOperations(body map[string]interface{}) ([]bulkOperation, error) {
+	list, ok := body["operations"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("operations must be an array")
+	}
+	if len(list) > maxBulkOperations {
+		return nil, fmt.Errorf("a bulk request takes at most %d operations", maxBulkOperations)
+	}
+
+	operations := make([]bulkOperation, len(list))
+	for i, item := range list {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("operations[%d] must be an object", i)
+		}
+		op := bulkOperation{}
+		op.Op, _ = entry["op"].(string)
+		if id, exists := entry["id"]; exists && id != nil {
+			op.ID = fmt.Sprint(id)
+		}
+		op.Data, _ = entry["data"].(map[string]interface{})
+
+		switch op.Op {
+		case BulkInsert:
+			if op.Data == nil {
+				return nil, fmt.Errorf("operations[%d]: insert needs data", i)
+			}
+		case BulkUpdate:
+			if op.ID == "" || op.Data == nil {
+				return nil, fmt.Errorf("operations[%d]: update needs an id and data", i)
+			}
+		case BulkDelete:
+			if op.ID == "" {
+				return nil, fmt.Errorf("operations[%d]: delete needs an id", i)
+			}
+		default:
+			return nil, fmt.Errorf("operations[%d]: op must be insert, update or delete", i)
+		}
+		operations[i] = op
+	}
+	return operations, nil
+}
+
+// pendingInsert is an insert whose events have run, waiting for its batch
+type pendingInsert struct {
+	index int
+	data  map[string]interface{} // As sent, to run the events again
+	doc   map[string]interface{}
+}
+
+// bulkRequestEvents are the requests whose BeforeRequest event a bulk
+// request runs for each kind of operation it holds
+var bulkRequestEvents = map[string]string{
+	BulkInsert: "POST",
+	BulkUpdate: "PUT",
+	BulkDelete: "DELETE",
+}
+
+// bulkWrite is the state of a bulk request
+type bulkWrite struct {
+	c          *Collection
+	ctx        *appcontext.Context
+	ordered    bool
+	skipEvents bool
+	// beforeRequest runs the BeforeRequest event before the operations
+	beforeRequest bool
+	// preserve keeps the ids and timestamps of the documents written, for
+	// imports
+	preserve bool
+	results  []bulkResult
+	failed   bool
+
+	// Inserts are collected into batches. The batch scope holds the writes of
+	// their events, so a failed batch can be rolled back and replayed one by
+	// one to find the documents that caused it.
+	batch   *writeScope
+	pending []pendingInsert
+}
+
+// handleBulk runs POST /<collection>/_bulk: a list of inserts, updates by id
+// and deletes by id, each checked, validated and run through the
+// collection's events like the single-document requests. BeforeRequest runs
+// once for each kind of operation, and fails the whole request if it
+// cancels. Consecutive inserts are written to the store in batches. Ordered
+// requests (the default) stop at the first failed operation; unordered
+// requests attempt all of them.
+func (c *Collection) handleBulk(ctx *appcontext.Context) error {
+	operations, err := parseBulkOperations(ctx.Body)
+	if err != nil {
+		return ctx.WriteError(400, err.Error())
+	}
+	b := &bulkWrite{
+		c:             c,
+		ctx:           ctx,
+		ordered:       true,
+		skipEvents:    skipEventsAllowed(ctx, ctx.Body["$skipEvents"]),
+		beforeRequest: true,
+	}
+	if ordered, exists := ctx.Body["ordered"].(bool); exists {
+		b.ordered = ordered
+	}
+
+	summary, err := b.runAll(operations)
+	if err != nil {
+		e := c.toBulkError(err)
+		return ctx.WriteError(e.status, e.message)
+	}
+
+	logging.Info("Bulk write completed", fmt.Sprintf("collection:%s", c.name), map[string]interface{}{
+		"operations": len(operations),
+		"inserted":   summary.Inserted,
+		"updated":    summary.Updated,
+		"deleted":    summary.Deleted,
+		"failed":     summary.Failed,
+	})
+
+	return ctx.WriteJSON(map[string]interface{}{
+		"ordered":  b.ordered,
+		"inserted": summary.Inserted,
+		"updated":  summary.Updated,
+		"deleted":  summary.Deleted,
+		"failed":   summary.Failed,
+		"results":  summary.Results,
+	})
+}
+
+// bulkSummary counts the outcomes of a bulk write
+type bulkSummary struct {
+	Inserted int
+	Updated  int
+	Deleted  int
+	Failed   int
+	Results  []bulkResult // Ordered by index
+}
+
+// runAll runs operations in one write and commits it. The returned error is
+// not an operation's but one that fails the whole write.
+func (b *bulkWrite) runAll(operations []bulkOperation) (*bulkSummary, error) {
+	c, ctx := b.c, b.ctx
+	rollback, err := c.beginWrite(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rollback()
+
+	// Like for single documents, BeforeRequest runs even when the other
+	// events are skipped, so its checks apply to every operation
+	if b.beforeRequest {
+		ran := map[string]bool{}
+		for _, op := range operations {
+			event := bulkRequestEvents[op.Op]
+			if event == "" || ran[event] {
+				continue
+			}
+			ran[event] = true
+			if err := c.runBeforeRequestEvent(ctx, event); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	body := ctx.Body
+	defer func() {
+		ctx.Body = body
+	}()
+	for i, op := range operations {
+		if b.failed && b.ordered {
+			break
+		}
+		// Events and field rules see the operation's data as the request body
+		ctx.Body = op.Data
+
+		if op.Op == BulkInsert {
+			if err := b.queueInsert(i, op.Data); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// Earlier inserts are written first, keeping the operations in order
+		if err := b.flush(); err != nil {
+			return nil, err
+		}
+		if !b.failed || !b.ordered {
+			b.run(i, op)
+		}
+	}
+	if err := b.flush(); err != nil {
+		return nil, err
+	}
+	ctx.Body = body
+
+	if err := c.commitWrite(ctx); err != nil {
+		return nil, err
+	}
+
+	summary := &bulkSummary{Results: b.results}
+	for _, result := range b.results {
+		switch {
+		case result.Status != 200:
+			summary.Failed++
+		case result.Op == BulkInsert:
+			summary.Inserted++
+		case result.Op == BulkUpdate:
+			summary.Updated++
+		case result.Op == BulkDelete:
+			summary.Deleted++
+		}
+	}
+	// Inserts failing in their events are reported before their batch
+	sort.Slice(summary.Results, func(i, j int) bool { return summary.Results[i].Index < summary.Results[j].Index })
+	return summary, nil
+}
+
+// record adds the result of an operation
+func (b *bulkWrite) record(index int, op string, id interface{}, err error) {
+	if err == nil {
+		b.results = append(b.results, bulkResult{Index: index, Op: op, Status: 200, ID: id})
+		return
+	}
+	e := b.c.toBulkError(err)
+	b.results = append(b.results, bulkResult{Index: index, Op: op, Status: e.status, Message: e.message, Errors: e.errors})
+	b.failed = true
+}
+
+// queueInsert runs the events of an insert and adds it to the current batch.
+// The returned error is not the operation's but one that fails the request.
+func (b *bulkWrite) queueInsert(index int, data map[string]interface{}) error {
+	if b.batch == nil {
+		scope, err := b.c.beginScope(b.ctx)
+		if err != nil {
+			return err
+		}
+		b.batch = scope
+	}
+
+	var doc map[string]interface{}
+	err := b.c.inScope(b.ctx, func() error {
+		var err error
+		doc, err = b.prepareInsert(data)
+		return err
+	})
+	if err != nil {
+		b.record(index, BulkInsert, nil, err)
+		if b.ordered {
+			return b.flush()
+		}
+		return nil
+	}
+
+	b.pending = append(b.pending, pendingInsert{index: index, data: data, doc: doc})
+	if len(b.pending) >= bulkInsertBatch {
+		return b.flush()
+	}
+	return nil
+}
+
+// flush writes the current batch of inserts. If the batch fails, it is rolled
+// back and its inserts are run again one by one, events included, so only
+// the documents that cannot be inserted fail.
+func (b *bulkWrite) flush() error {
+	if b.batch == nil {
+		return nil
+	}
+	batch, pending := b.batch, b.pending
+	b.batch, b.pending = nil, nil
+
+	if len(pending) == 0 {
+		return b.c.endScope(batch, true)
+	}
+
+	docs := make([]map[string]interface{}, len(pending))
+	changes := make([]*Change, len(pending))
+	for i, p := range pending {
+		docs[i] = p.doc
+		changes[i] = newChange("created", nil, p.doc)
+	}
+	_, err := b.c.store.InsertMany(b.ctx.Context(), docs)
+	if err == nil {
+		err = b.c.recordChanges(b.ctx, changes)
+	}
+	if err == nil {
+		for _, p := range pending {
+			b.c.afterWrite(b.ctx, "created", p.doc, "POST")
+			b.record(p.index, BulkInsert, p.doc["id"], nil)
+		}
+		return b.c.endScope(batch, true)
+	}
+
+	if err := b.c.endScope(batch, false); err != nil {
+		return err
+	}
+	for _, p := range pending {
+		b.ctx.Body = p.data
+		var doc map[string]interface{}
+		err := b.c.inScope(b.ctx, func() error {
+			var err error
+			if doc, err = b.prepareInsert(p.data); err != nil {
+				return err
+			}
+			if _, err := b.c.store.Insert(b.ctx.Context(), doc); err != nil {
+				return err
+			}
+			if err := b.c.recordChange(b.ctx, "created", nil, doc); err != nil {
+				return err
+			}
+			b.c.afterWrite(b.ctx, "created", doc, "POST")
+			return nil
+		})
+		if err != nil {
+			b.record(p.index, BulkInsert, nil, err)
+			if b.ordered {
+				return nil
+			}
+			continue
+		}
+		b.record(p.index, BulkInsert, doc["id"], nil)
+	}
+	return nil
+}
+
+// prepareInsert applies the checks and events of POST to a document
+func (b *bulkWrite) prepareInsert(data map[string]interface{}) (map[string]interface{}, error) {
+	c, ctx := b.c, b.ctx
+	if status, message := c.checkMethodPermission(ctx, "post"); status != 0 {
+		return nil, &bulkError{status: status, message: message}
+	}
+	if status, message := c.checkWritable(ctx, data, nil); status != 0 {
+		return nil, &bulkError{status: status, message: message}
+	}
+	if err := c.validate(data, true); err != nil {
+		return nil, err
+	}
+
+	doc := c.sanitize(data)
+	if id, exists := data["id"]; exists && id != nil && b.preserve {
+		doc["id"] = fmt.Sprint(id)
+	}
+	c.setDefaults(doc)
+	if !b.skipEvents {
+		if err := c.runValidateEvent(ctx, doc); err != nil {
+			return nil, err
+		}
+		if err := c.runPostEvent(ctx, doc); err != nil {
+			return nil, err
+		}
+	}
+	b.setTimestamps(doc, data, true)
+	return doc, nil
+}
+
+// setTimestamps sets the timestamps of a document about to be written. When
+// preserving, those the operation's data holds are kept.
+func (b *bulkWrite) setTimestamps(doc, data map[string]interface{}, isCreate bool) {
+	if !b.preserve {
+		b.c.setTimestamps(doc, isCreate)
+		return
+	}
+	now := time.Now()
+	for _, field := range []string{"createdAt", "updatedAt"} {
+		if value, exists := data[field]; exists && value != nil {
+			doc[field] = b.c.coerceType(value, "date")
+		} else if isCreate || field == "updatedAt" {
+			doc[field] = now
+		}
+	}
+}
+
+// run runs an update or delete in a scope of its own, so the writes of its
+// events are rolled back if it fails
+func (b *bulkWrite) run(index int, op bulkOperation) {
+	done := op.Op
+	err := b.c.inScope(b.ctx, func() error {
+		switch op.Op {
+		case BulkUpdate:
+			return b.update(op.ID, op.Data)
+		case bulkUpsert:
+			var err error
+			done, err = b.upsert(op.ID, op.Data)
+			return err
+		}
+		return b.delete(op.ID)
+	})
+	b.record(index, done, op.ID, err)
+}
+
+// upsert updates the document with the given id, or inserts data with that id
+// if there is none. It returns the operation it ran.
+func (b *bulkWrite) upsert(id string, data map[string]interface{}) (string, error) {
+	c, ctx := b.c, b.ctx
+	existing, err := c.store.FindOne(ctx.Context(), database.NewQueryBuilder().Where("id", "$eq", id))
+	if err != nil {
+		return BulkUpdate, err
+	}
+	if existing != nil {
+		return BulkUpdate, b.update(id, data)
+	}
+
+	doc, err := b.prepareInsert(data)
+	if err != nil {
+		return BulkInsert, err
+	}
+	doc["id"] = id
+	if _, err := c.store.Insert(ctx.Context(), doc); err != nil {
+		return BulkInsert, err
+	}
+	if err := c.recordChange(ctx, "created", nil, doc); err != nil {
+		return BulkInsert, err
+	}
+	c.afterWrite(ctx, "created", doc, "POST")
+	return BulkInsert, nil
+}
+
+// update applies the checks and events of PUT and updates a document
+func (b *bulkWrite) update(id string, data map[string]interface{}) error {
+	c, ctx := b.c, b.ctx
+	if status, message := c.checkMethodPermission(ctx, "put"); status != 0 {
+		return &bulkError{status: status, message: message}
+	}
+
+	query := database.NewQueryBuilder().Where("id", "$eq", id)
+	previous, err := c.store.FindOne(ctx.Context(), query)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		return &bulkError{status: 404, message: "Document not found"}
+	}
+	if status, message := c.checkWritable(ctx, data, previous); status != 0 {
+		return &bulkError{status: status, message: message}
+	}
+	if err := c.validate(data, false); err != nil {
+		return err
+	}
+
+	sanitized := c.sanitize(data)
+	delete(sanitized, "id")
+	if len(sanitized) == 0 {
+		return &bulkError{status: 400, message: "No fields to update"}
+	}
+
+	merged := make(map[string]interface{})
+	for k, v := range previous {
+		merged[k] = v
+	}
+	for k, v := range sanitized {
+		merged[k] = v
+	}
+	if !b.skipEvents {
+		if err := c.runValidateEvent(ctx, merged); err != nil {
+			return err
+		}
+		if err := c.runPutEvent(ctx, merged); err != nil {
+			return err
+		}
+	}
+	b.setTimestamps(sanitized, data, false)
+
+	update := database.NewUpdateBuilder()
+	for key, value := range sanitized {
+		update.Set(key, value)
+	}
+	if _, err := c.store.Update(ctx.Context(), query, update); err != nil {
+		return err
+	}
+
+	doc, err := c.store.FindOne(ctx.Context(), query)
+	if err != nil {
+		return err
+	}
+	if err := c.recordChange(ctx, "updated", previous, doc); err != nil {
+		return err
+	}
+	c.afterWrite(ctx, "updated", doc, "PUT")
+	return nil
+}
+
+// delete applies the checks and events of DELETE and removes a document
+func (b *bulkWrite) delete(id string) error {
+	c, ctx := b.c, b.ctx
+	if status, message := c.checkMethodPermission(ctx, "delete"); status != 0 {
+		return &bulkError{status: status, message: message}
+	}
+
+	query := database.NewQueryBuilder().Where("id", "$eq", id)
+	doc, err := c.store.FindOne(ctx.Context(), query)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		return &bulkError{status: 404, message: "Document not found"}
+	}
+	if !b.skipEvents {
+		if err := c.runDeleteEvent(ctx, doc); err != nil {
+			return err
+		}
+	}
+
+	result, err := c.store.Remove(ctx.Context(), query)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount() == 0 {
+		return &bulkError{status: 404, message: "Document not found"}
+	}
+	if err := c.recordChange(ctx, "deleted", doc, nil); err != nil {
+		return err
+	}
+	c.afterWrite(ctx, "deleted", doc, "DELETE")
+	return nil
+}
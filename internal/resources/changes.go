@@ -0,0 +1,583 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/database"
+	"github.com/hjanuschka/go-deployd/internal/logging"
+)
+
+// changeStore holds the change log entries of every collection
+const changeStore = "_changes"
+
+// Limits of the change log and GET /<collection>/_changes
+const (
+	defaultChangeLogEntries = 10000
+	changePruneEvery        = 100 // Changes recorded between two prunes
+	changeSeqAttempts       = 5   // Tries to take the next sequence numbers
+	defaultChangesLimit     = 100
+	maxChangesLimit         = 1000
+	defaultChangesWait      = 25 * time.Second
+	maxChangesWait          = 60 * time.Second
+	// changesPollInterval is how often waiting feeds look for changes
+	// recorded by other servers
+	changesPollInterval = time.Second
+	changesHeartbeat    = 15 * time.Second
+)
+
+// Change feed modes
+const (
+	FeedNormal   = "normal"
+	FeedLongPoll = "longpoll"
+	FeedSSE      = "sse"
+)
+
+// ChangeLogConfig turns on the change log of a collection
+type ChangeLogConfig struct {
+	Enabled bool `json:"enabled"`
+	// MaxEntries is the number of most recent changes kept; older ones are
+	// pruned. Defaults to 10000.
+	MaxEntries int `json:"maxEntries,omitempty"`
+}
+
+// Change is an entry of a collection's change log. Created documents are in
+// After and deleted ones in Before; updates hold the fields that changed,
+// with their previous values in Before and their new ones in After.
+type Change struct {
+	Seq       int64                  `json:"seq"`
+	Operation string                 `json:"operation"` // created, updated or deleted
+	ID        string                 `json:"id"`
+	Before    map[string]interface{} `json:"before,omitempty"`
+	After     map[string]interface{} `json:"after,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+
+	// previous and current are the whole document before and after an
+	// update, which the change feed reads like a GET request does before
+	// it diffs them
+	previous map[string]interface{}
+	current  map[string]interface{}
+}
+
+// changeSignal wakes the feeds waiting for the next change of a collection
+type changeSignal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// wait returns a channel closed on the next notify
+func (s *changeSignal) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+	}
+	return s.ch
+}
+
+func (s *changeSignal) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch != nil {
+		close(s.ch)
+		s.ch = nil
+	}
+}
+
+func (c *Collection) changeLogEnabled() bool {
+	return c.changes != nil
+}
+
+// newChange describes a write. before is the document before it and after
+// the document after it; either is nil for creates and deletes.
+func newChange(operation string, before, after map[string]interface{}) *Change {
+	change := &Change{Operation: operation, Timestamp: time.Now().UTC()}
+	switch {
+	case before == nil:
+		change.After = after
+	case after == nil:
+		change.Before = before
+	default:
+		change.Before, change.After = diffDocuments(before, after)
+		change.previous, change.current = before, after
+	}
+	doc := after
+	if doc == nil {
+		doc = before
+	}
+	if doc != nil {
+		change.ID = fmt.Sprint(doc["id"])
+	}
+	return change
+}
+
+// diffDocuments returns the fields that differ between two versions of a
+// document, with their values in each. Fields missing from a version are
+// missing from its side of the diff.
+func diffDocuments(before, after map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	oldValues := make(map[string]interface{})
+	newValues := make(map[string]interface{})
+	for field, value := range before {
+		if current, exists := after[field]; !exists || !reflect.DeepEqual(value, current) {
+			oldValues[field] = value
+		}
+	}
+	for field, value := range after {
+		if previous, exists := before[field]; !exists || !reflect.DeepEqual(previous, value) {
+			newValues[field] = value
+		}
+	}
+	return oldValues, newValues
+}
+
+// recordChange adds a write to the change log and queues its webhooks, in the
+// transaction of the write
+func (c *Collection) recordChange(ctx *appcontext.Context, operation string, before, after map[string]interface{}) error {
+	if err := c.queueWebhooks(ctx, operation, before, after); err != nil {
+		return err
+	}
+	if !c.changeLogEnabled() {
+		return nil
+	}
+	return c.recordChanges(ctx, []*Change{newChange(operation, before, after)})
+}
+
+// recordChanges adds writes to the change log with the next sequence numbers.
+// An entry's id is made of the collection and its sequence number, so when
+// another write takes the same numbers first, the insert fails on the
+// duplicate id (on SQL databases once that write commits) and is retried with
+// the following ones. Sequence numbers therefore follow the order the writes
+// commit in on SQL databases.
+func (c *Collection) recordChanges(ctx *appcontext.Context, changes []*Change) error {
+	if !c.changeLogEnabled() || len(changes) == 0 {
+		return nil
+	}
+
+	var err error
+	for attempt := 0; attempt < changeSeqAttempts; attempt++ {
+		err = c.inScope(ctx, func() error {
+			last, err := c.lastChangeSeq(ctx.Context())
+			if err != nil {
+				return err
+			}
+			docs := make([]map[string]interface{}, len(changes))
+			for i, change := range changes {
+				change.Seq = last + int64(i) + 1
+				docs[i] = c.changeDocument(change)
+			}
+			_, err = c.changes.InsertMany(ctx.Context(), docs)
+			return err
+		})
+		if _, isDuplicate := database.IsDuplicateKey(err, changeStore); !isDuplicate {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+
+	last := changes[len(changes)-1].Seq
+	database.AfterCommit(ctx.Context(), func() {
+		c.changed.notify()
+		if last/changePruneEvery != (last-int64(len(changes)))/changePruneEvery {
+			c.pruneChanges(last)
+		}
+	})
+	return nil
+}
+
+func (c *Collection) changeDocument(change *Change) map[string]interface{} {
+	doc := map[string]interface{}{
+		"id":         c.name + ":" + strconv.FormatInt(change.Seq, 10),
+		"collection": c.name,
+		"seq":        change.Seq,
+		"operation":  change.Operation,
+		"documentId": change.ID,
+		"before":     change.Before,
+		"after":      change.After,
+		"timestamp":  change.Timestamp,
+	}
+	if change.previous != nil {
+		doc["previous"] = change.previous
+		doc["current"] = change.current
+	}
+	return doc
+}
+
+func changeFromDocument(doc map[string]interface{}) *Change {
+	change := &Change{}
+	change.Seq, _ = toInt64(doc["seq"])
+	change.Operation, _ = doc["operation"].(string)
+	change.ID, _ = doc["documentId"].(string)
+	change.Before, _ = doc["before"].(map[string]interface{})
+	change.After, _ = doc["after"].(map[string]interface{})
+	change.previous, _ = doc["previous"].(map[string]interface{})
+	change.current, _ = doc["current"].(map[string]interface{})
+	switch t := doc["timestamp"].(type) {
+	case time.Time:
+		change.Timestamp = t
+	case string:
+		change.Timestamp, _ = time.Parse(time.RFC3339Nano, t)
+	}
+	return change
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// lastChangeSeq returns the sequence number of the collection's latest change,
+// or 0 if it has none
+func (c *Collection) lastChangeSeq(ctx context.Context) (int64, error) {
+	limit := int64(1)
+	docs, err := c.changes.Find(ctx, database.NewQueryBuilder().Where("collection", "$eq", c.name),
+		database.QueryOptions{Sort: map[string]int{"seq": -1}, Limit: &limit})
+	if err != nil || len(docs) == 0 {
+		return 0, err
+	}
+	seq, _ := toInt64(docs[0]["seq"])
+	return seq, nil
+}
+
+// changesSince returns up to limit changes after the sequence number since,
+// oldest first
+func (c *Collection) changesSince(ctx context.Context, since int64, limit int64) ([]*Change, error) {
+	query := database.NewQueryBuilder().
+		Where("collection", "$eq", c.name).
+		Where("seq", "$gt", since)
+	docs, err := c.changes.Find(ctx, query, database.QueryOptions{Sort: map[string]int{"seq": 1}, Limit: &limit})
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]*Change, len(docs))
+	for i, doc := range docs {
+		changes[i] = changeFromDocument(doc)
+	}
+	return changes, nil
+}
+
+// pruneChanges removes the changes older than the MaxEntries most recent ones
+func (c *Collection) pruneChanges(last int64) {
+	keep := int64(c.config.ChangeLog.MaxEntries)
+	if keep <= 0 {
+		keep = defaultChangeLogEntries
+	}
+	if last <= keep {
+		return
+	}
+	query := database.NewQueryBuilder().
+		Where("collection", "$eq", c.name).
+		Where("seq", "$lte", last-keep)
+	if _, err := c.changes.Remove(context.Background(), query); err != nil {
+		logging.Error("Failed to prune change log", fmt.Sprintf("collection:%s", c.name), map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// changesRequest holds the options of GET /<collection>/_changes
+type changesRequest struct {
+	Since int64
+	Limit int64
+	Feed  string
+	Wait  time.Duration
+	// SkipEvents reads the changes without the Get event
+	SkipEvents bool
+}
+
+func (c *Collection) parseChangesRequest(ctx *appcontext.Context) (*changesRequest, error) {
+	req := &changesRequest{
+		Limit:      defaultChangesLimit,
+		Feed:       FeedNormal,
+		Wait:       defaultChangesWait,
+		SkipEvents: skipEventsAllowed(ctx, ctx.Query["$skipEvents"]),
+	}
+
+	if feed, ok := ctx.Query["feed"].(string); ok {
+		req.Feed = feed
+	} else if ctx.Request != nil && strings.Contains(ctx.Request.Header.Get("Accept"), "text/event-stream") {
+		req.Feed = FeedSSE
+	}
+	if req.Feed != FeedNormal && req.Feed != FeedLongPoll && req.Feed != FeedSSE {
+		return nil, fmt.Errorf("feed must be normal, longpoll or sse")
+	}
+
+	since := ctx.Query["since"]
+	if req.Feed == FeedSSE && ctx.Request != nil {
+		// Reconnecting event sources resume after the last change they got
+		if lastID := ctx.Request.Header.Get("Last-Event-ID"); lastID != "" {
+			since = lastID
+		}
+	}
+	switch v := since.(type) {
+	case nil:
+	case float64:
+		req.Since = int64(v)
+	case string:
+		if v == "now" {
+			last, err := c.lastChangeSeq(ctx.Context())
+			if err != nil {
+				return nil, err
+			}
+			req.Since = last
+		} else if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			req.Since = n
+		} else {
+			return nil, fmt.Errorf("since must be a sequence number or now")
+		}
+	default:
+		return nil, fmt.Errorf("since must be a sequence number or now")
+	}
+	if req.Since < 0 {
+		return nil, fmt.Errorf("since must be a sequence number or now")
+	}
+
+	if value, exists := ctx.Query["limit"]; exists {
+		limit, ok := value.(float64)
+		if !ok || limit < 1 {
+			return nil, fmt.Errorf("limit must be a positive number")
+		}
+		req.Limit = int64(limit)
+		if req.Limit > maxChangesLimit {
+			req.Limit = maxChangesLimit
+		}
+	}
+	if value, exists := ctx.Query["timeout"]; exists {
+		seconds, ok := value.(float64)
+		if !ok || seconds < 0 {
+			return nil, fmt.Errorf("timeout must be a number of seconds")
+		}
+		req.Wait = time.Duration(seconds * float64(time.Second))
+		if req.Wait > maxChangesWait {
+			req.Wait = maxChangesWait
+		}
+	}
+	return req, nil
+}
+
+// handleChanges runs GET /<collection>/_changes: the changes recorded after
+// the sequence number since. The normal feed responds right away, the
+// longpoll feed waits up to timeout seconds for a change if there is none,
+// and the sse feed streams changes as server-sent events until the client
+// disconnects.
+func (c *Collection) handleChanges(ctx *appcontext.Context) error {
+	if !c.changeLogEnabled() {
+		return ctx.WriteError(404, fmt.Sprintf("The change log of %s is not enabled", c.name))
+	}
+	req, err := c.parseChangesRequest(ctx)
+	if err != nil {
+		return ctx.WriteError(400, err.Error())
+	}
+
+	// Feeds outlive the server's write timeout
+	if req.Feed != FeedNormal {
+		http.NewResponseController(ctx.Response).SetWriteDeadline(time.Time{})
+	}
+	if req.Feed == FeedSSE {
+		return c.streamChanges(ctx, req)
+	}
+
+	var timeout <-chan time.Time
+	if req.Feed == FeedLongPoll {
+		timer := time.NewTimer(req.Wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	ticker := time.NewTicker(changesPollInterval)
+	defer ticker.Stop()
+
+	for {
+		signal := c.changed.wait()
+		changes, more, err := c.readChanges(ctx, req)
+		if err != nil {
+			return c.writeChangesError(ctx, err)
+		}
+		if len(changes) > 0 || timeout == nil {
+			return c.writeChanges(ctx, req, changes)
+		}
+		if more {
+			continue
+		}
+		select {
+		case <-signal:
+		case <-ticker.C:
+		case <-timeout:
+			return c.writeChanges(ctx, req, changes)
+		case <-ctx.Context().Done():
+			return nil
+		}
+	}
+}
+
+// errChangesPruned is returned when changes after since have been pruned
+type errChangesPruned struct {
+	since int64
+}
+
+func (e *errChangesPruned) Error() string {
+	return fmt.Sprintf("Changes after %d are no longer in the change log; read the collection again and follow the changes since now", e.since)
+}
+
+// readChanges returns the next changes of a feed as the caller may read
+// them, and advances req.Since past every change it looked at, including
+// those the caller may not see. more reports whether the change log may hold
+// further changes right away.
+func (c *Collection) readChanges(ctx *appcontext.Context, req *changesRequest) (visible []*Change, more bool, err error) {
+	changes, err := c.changesSince(ctx.Context(), req.Since, req.Limit)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(changes) > 0 && changes[0].Seq > req.Since+1 {
+		return nil, false, &errChangesPruned{since: req.Since}
+	}
+	visible = make([]*Change, 0, len(changes))
+	for _, change := range changes {
+		if c.readChange(ctx, change, req.SkipEvents) {
+			visible = append(visible, change)
+		}
+		req.Since = change.Seq
+	}
+	return visible, int64(len(changes)) == req.Limit, nil
+}
+
+// readChange filters a change the way a GET request reads documents: the
+// Get event runs on the documents, unless skipEvents is set, and the fields
+// the caller may not read are removed. Updates are read in full before and
+// after the write and then diffed again. It returns false if the Get event
+// rejects the document the change leaves behind (the deleted one for
+// deletes), like a GET request would not return it.
+func (c *Collection) readChange(ctx *appcontext.Context, change *Change, skipEvents bool) bool {
+	switch {
+	case change.Before == nil:
+		after, ok := c.readDocument(ctx, change.After, skipEvents)
+		change.After = after
+		return ok
+	case change.After == nil:
+		before, ok := c.readDocument(ctx, change.Before, skipEvents)
+		change.Before = before
+		return ok
+	}
+
+	// Updates recorded without their whole documents only have the diff
+	previous, current := change.previous, change.current
+	if previous == nil || current == nil {
+		previous, current = change.Before, change.After
+	}
+	after, ok := c.readDocument(ctx, current, skipEvents)
+	if !ok {
+		return false
+	}
+	// A document the caller could not read before the update shows up whole
+	before, ok := c.readDocument(ctx, previous, skipEvents)
+	if !ok {
+		before = map[string]interface{}{}
+	}
+	change.Before, change.After = diffDocuments(before, after)
+	return true
+}
+
+// writeChanges responds with the changes read by a feed. lastSeq is the last
+// change the feed looked at, which may be one the caller could not see.
+func (c *Collection) writeChanges(ctx *appcontext.Context, req *changesRequest, changes []*Change) error {
+	return ctx.WriteJSON(map[string]interface{}{
+		"results": changes,
+		"lastSeq": req.Since,
+	})
+}
+
+func (c *Collection) writeChangesError(ctx *appcontext.Context, err error) error {
+	if _, ok := err.(*errChangesPruned); ok {
+		return ctx.WriteError(410, err.Error())
+	}
+	return ctx.WriteError(500, err.Error())
+}
+
+// streamChanges sends the changes of a feed as server-sent events, each with
+// its sequence number as event id, so reconnecting clients resume with
+// Last-Event-ID
+func (c *Collection) streamChanges(ctx *appcontext.Context, req *changesRequest) error {
+	// Errors before the stream starts get a regular response
+	changes, more, err := c.readChanges(ctx, req)
+	if err != nil {
+		return c.writeChangesError(ctx, err)
+	}
+
+	w := ctx.Response
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	fmt.Fprintf(w, "retry: %d\n\n", changesPollInterval.Milliseconds())
+	flush()
+
+	ticker := time.NewTicker(changesPollInterval)
+	defer ticker.Stop()
+	heartbeat := time.NewTicker(changesHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		for _, change := range changes {
+			data, err := json.Marshal(change)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", change.Seq, data); err != nil {
+				return nil
+			}
+		}
+		if len(changes) > 0 {
+			flush()
+		}
+
+		signal := c.changed.wait()
+		if !more {
+			select {
+			case <-signal:
+			case <-ticker.C:
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return nil
+				}
+				flush()
+			case <-ctx.Context().Done():
+				return nil
+			}
+		}
+
+		if changes, more, err = c.readChanges(ctx, req); err != nil {
+			// The stream has started, so the error is sent as an event
+			data, _ := json.Marshal(map[string]string{"message": err.Error()})
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			flush()
+			return nil
+		}
+	}
+}
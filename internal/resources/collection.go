@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/hjanuschka/go-deployd/internal/config"
 	appcontext "github.com/hjanuschka/go-deployd/internal/context"
 	"github.com/hjanuschka/go-deployd/internal/database"
 	"github.com/hjanuschka/go-deployd/internal/events"
@@ -23,6 +24,14 @@ type CollectionConfig struct {
 	AllowAdditionalProperties bool                                 `json:"allowAdditionalProperties,omitempty"`
 	IsBuiltin                 bool                                 `json:"isBuiltin,omitempty"`
 	NoStore                   bool                                 `json:"noStore,omitempty"`
+	Permissions               map[string]Permission                `json:"permissions,omitempty"`
+	OwnerField                string                               `json:"ownerField,omitempty"`
+	Relations                 map[string]Relation                  `json:"relations,omitempty"`
+	ChangeLog                 *ChangeLogConfig                     `json:"changeLog,omitempty"`
+	Realtime                  *config.RealtimeRules                `json:"realtime,omitempty"`
+	Webhooks                  []WebhookConfig                      `json:"webhooks,omitempty"`
+	Fetch                     *events.FetchConfig                  `json:"fetch,omitempty"`
+	Limits                    *events.ScriptLimits                 `json:"limits,omitempty"`
 }
 
 type Collection struct {
@@ -34,6 +43,11 @@ type Collection struct {
 	hotReloadManager *events.HotReloadGoManager
 	configPath       string
 	realtimeEmitter  events.RealtimeEmitter
+	collections      CollectionResolver
+	changes          database.StoreInterface // The change log, if enabled
+	changed          changeSignal
+	webhooks         database.StoreInterface // The webhook deliveries, if it has webhooks
+	dispatcher       *WebhookDispatcher
 }
 
 func NewCollection(name string, config *CollectionConfig, db database.DatabaseInterface) *Collection {
@@ -63,7 +77,7 @@ func NewCollection(name string, config *CollectionConfig, db database.DatabaseIn
 		store = db.CreateStore(name)
 	}
 
-	return &Collection{
+	collection := &Collection{
 		BaseResource:     NewBaseResource(name),
 		config:           config,
 		store:            store,
@@ -72,6 +86,18 @@ func NewCollection(name string, config *CollectionConfig, db database.DatabaseIn
 		hotReloadManager: nil, // Will be initialized when needed
 		realtimeEmitter:  nil, // Will be set when available
 	}
+	collection.scriptManager.SetFetchConfig(config.Fetch)
+	collection.scriptManager.SetScriptLimits(config.Limits)
+	if store != nil {
+		collection.ensureUniqueIndexes()
+		if config.ChangeLog != nil && config.ChangeLog.Enabled {
+			collection.changes = db.CreateStore(changeStore)
+		}
+		if len(config.Webhooks) > 0 {
+			collection.webhooks = db.CreateStore(webhookStore)
+		}
+	}
+	return collection
 }
 
 func LoadCollectionFromConfig(name, configPath string, db database.DatabaseInterface) (Resource, error) {
@@ -89,6 +115,26 @@ func LoadCollectionFromConfigWithEmitter(name, configPath string, db database.Da
 	if err := json.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
+	if err := ValidateProperties(config.Properties); err != nil {
+		return nil, fmt.Errorf("invalid properties: %w", err)
+	}
+	if err := ValidateRelations(config.Relations); err != nil {
+		return nil, fmt.Errorf("invalid relations: %w", err)
+	}
+	if err := ValidateWebhooks(config.Webhooks); err != nil {
+		return nil, fmt.Errorf("invalid webhooks: %w", err)
+	}
+	if config.Realtime != nil {
+		if err := config.Realtime.ValidateCollection(name); err != nil {
+			return nil, fmt.Errorf("invalid realtime rules: %w", err)
+		}
+	}
+	if err := config.Fetch.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid fetch config: %w", err)
+	}
+	if err := config.Limits.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid limits: %w", err)
+	}
 
 	// Check if this is a user collection (special case)
 	if name == "users" || name == "user" {
@@ -97,8 +143,7 @@ func LoadCollectionFromConfigWithEmitter(name, configPath string, db database.Da
 
 		// Set the realtime emitter if provided
 		if emitter != nil {
-			userCollection.scriptManager.SetRealtimeEmitter(emitter)
-			userCollection.realtimeEmitter = emitter
+			userCollection.SetRealtimeEmitter(emitter)
 		}
 
 		// Load event scripts with configuration
@@ -116,8 +161,7 @@ func LoadCollectionFromConfigWithEmitter(name, configPath string, db database.Da
 
 	// Set the realtime emitter if provided
 	if emitter != nil {
-		collection.scriptManager.SetRealtimeEmitter(emitter)
-		collection.realtimeEmitter = emitter
+		collection.SetRealtimeEmitter(emitter)
 	}
 
 	// Load event scripts with configuration
@@ -131,11 +175,24 @@ func LoadCollectionFromConfigWithEmitter(name, configPath string, db database.Da
 
 func (c *Collection) Handle(ctx *appcontext.Context) error {
 	id := ctx.GetID()
+
+	// Bulk requests check the permission of each operation
+	if !c.config.NoStore && ctx.Method == "POST" && id == "_bulk" {
+		return c.handleBulk(ctx)
+	}
+
+	// Enforce method permissions before any event runs
+	if status, message := c.checkPermission(ctx); status != 0 {
+		return ctx.WriteError(status, message)
+	}
 	
 	// Handle special endpoints for POST requests (only for regular collections)
 	if !c.config.NoStore && ctx.Method == "POST" && id == "query" {
 		return c.handleQuery(ctx)
 	}
+	if !c.config.NoStore && ctx.Method == "GET" && id == "_changes" {
+		return c.handleChanges(ctx)
+	}
 	
 	switch ctx.Method {
 	case "GET":
@@ -299,6 +356,11 @@ func (c *Collection) handleGet(ctx *appcontext.Context) error {
 		return ctx.WriteJSON(data)
 	}
 
+	includes, err := c.queryIncludes(ctx.Query["$include"])
+	if err != nil {
+		return ctx.WriteError(400, err.Error())
+	}
+
 	if id != "" {
 		logging.Info("🔍 SINGLE DOCUMENT GET REQUEST", fmt.Sprintf("collection:%s", c.name), map[string]interface{}{
 			"documentId": id,
@@ -321,13 +383,16 @@ func (c *Collection) handleGet(ctx *appcontext.Context) error {
 		})
 
 		// Check for $skipEvents parameter in query to bypass events
-		skipEvents := ctx.Query["$skipEvents"] == "true"
+		skipEvents := skipEventsAllowed(ctx, ctx.Query["$skipEvents"])
 
 		logging.Info("🎯 EVENT DECISION", fmt.Sprintf("collection:%s", c.name), map[string]interface{}{
 			"skipEvents":   skipEvents,
 			"willRunEvent": !skipEvents,
 		})
 
+		// Ownership is decided on the stored document, before events can change it
+		isOwner := c.isOwner(ctx, doc)
+
 		// Run Get event for single document (skip if $skipEvents is true)
 		if !skipEvents {
 			if err := c.runGetEvent(ctx, doc); err != nil {
@@ -338,6 +403,12 @@ func (c *Collection) handleGet(ctx *appcontext.Context) error {
 			}
 		}
 
+		c.filterReadable(ctx, doc, isOwner)
+
+		if err := c.populateIncludes(ctx, []map[string]interface{}{doc}, includes, skipEvents); err != nil {
+			return ctx.WriteError(500, err.Error())
+		}
+
 		logging.Info("📤 RETURNING DOCUMENT", fmt.Sprintf("collection:%s", c.name), map[string]interface{}{
 			"documentId": id,
 			"finalData":  doc,
@@ -348,10 +419,14 @@ func (c *Collection) handleGet(ctx *appcontext.Context) error {
 
 	// Get multiple documents
 	// Check for $skipEvents parameter in query to bypass events
-	skipEvents := ctx.Query["$skipEvents"] == "true"
+	skipEvents := skipEventsAllowed(ctx, ctx.Query["$skipEvents"])
 
 	// First extract query options like $sort, $limit, $skip
 	opts, cleanQuery := c.extractQueryOptions(ctx.Query)
+	page, err := parsePageParams(ctx.Query)
+	if err != nil {
+		return ctx.WriteError(400, err.Error())
+	}
 
 	// Debug logging
 	fmt.Printf("DEBUG: Collection.handleGet - Original query: %+v\n", ctx.Query)
@@ -366,9 +441,9 @@ func (c *Collection) handleGet(ctx *appcontext.Context) error {
 	fmt.Printf("DEBUG: Collection.handleGet - QueryBuilder created, calling store.Find\n")
 	fmt.Printf("DEBUG: Collection.handleGet - Store type: %T\n", c.store)
 
-	docs, err := c.store.Find(ctx.Context(), query, opts)
+	docs, paged, err := c.findDocs(ctx, query, opts, page)
 	if err != nil {
-		return ctx.WriteError(500, err.Error())
+		return writeFindError(ctx, err)
 	}
 
 	// Run Get event for each document (skip if $skipEvents is true)
@@ -388,14 +463,20 @@ func (c *Collection) handleGet(ctx *appcontext.Context) error {
 			}
 
 			// Use the event-processed document as the result
+			c.filterReadable(ctx, eventDoc, c.isOwner(ctx, doc))
 			filteredDocs = append(filteredDocs, eventDoc)
 		} else {
 			// No events, use original document
+			c.filterReadable(ctx, doc, c.isOwner(ctx, doc))
 			filteredDocs = append(filteredDocs, doc)
 		}
 	}
 
-	return ctx.WriteJSON(filteredDocs)
+	if err := c.populateIncludes(ctx, filteredDocs, includes, skipEvents); err != nil {
+		return ctx.WriteError(500, err.Error())
+	}
+
+	return c.writePage(ctx, filteredDocs, query, page, paged)
 }
 
 func (c *Collection) handlePost(ctx *appcontext.Context) error {
@@ -404,6 +485,13 @@ func (c *Collection) handlePost(ctx *appcontext.Context) error {
 		"requestBody":     ctx.Body,
 	})
 
+	// Writes of the request and its events are committed together
+	rollback, err := c.beginWrite(ctx)
+	if err != nil {
+		return ctx.WriteError(500, err.Error())
+	}
+	defer rollback()
+
 	// Run BeforeRequest event
 	if err := c.runBeforeRequestEvent(ctx, "POST"); err != nil {
 		if scriptErr, ok := err.(*events.ScriptError); ok {
@@ -437,6 +525,11 @@ func (c *Collection) handlePost(ctx *appcontext.Context) error {
 		return ctx.WriteJSON(data)
 	}
 
+	// Enforce field write rules on what the client sent; events may still set any field
+	if status, message := c.checkWritable(ctx, ctx.Body, nil); status != 0 {
+		return ctx.WriteError(status, message)
+	}
+
 	logging.Debug("Starting Go validation", fmt.Sprintf("collection:%s", c.name), map[string]interface{}{
 		"bodyKeys": getDataKeys(ctx.Body),
 		"body":     ctx.Body,
@@ -447,7 +540,7 @@ func (c *Collection) handlePost(ctx *appcontext.Context) error {
 		logging.Debug("Go validation failed", fmt.Sprintf("collection:%s", c.name), map[string]interface{}{
 			"error": err.Error(),
 		})
-		return ctx.WriteError(400, err.Error())
+		return c.writeValidationError(ctx, err)
 	}
 
 	logging.Debug("Go validation passed, starting sanitization", fmt.Sprintf("collection:%s", c.name), map[string]interface{}{
@@ -459,7 +552,7 @@ func (c *Collection) handlePost(ctx *appcontext.Context) error {
 	skipEvents := false
 	if val, exists := ctx.Body["$skipEvents"]; exists {
 		if skip, ok := val.(bool); ok && skip {
-			skipEvents = true
+			skipEvents = skipEventsAllowed(ctx, skip)
 		}
 		// Remove $skipEvents from body so it doesn't interfere with validation/sanitization
 		delete(ctx.Body, "$skipEvents")
@@ -482,7 +575,7 @@ func (c *Collection) handlePost(ctx *appcontext.Context) error {
 				return ctx.WriteError(scriptErr.StatusCode, scriptErr.Message)
 			}
 			if validationErr, ok := err.(*events.ValidationError); ok {
-				return ctx.WriteError(400, validationErr.Error())
+				return ctx.WriteValidationErrors(validationErr.Errors)
 			}
 			return ctx.WriteError(500, err.Error())
 		}
@@ -508,6 +601,15 @@ func (c *Collection) handlePost(ctx *appcontext.Context) error {
 			"error": err.Error(),
 			"data":  sanitized,
 		})
+		return c.writeStoreError(ctx, err)
+	}
+	if resultDoc, ok := result.(map[string]interface{}); ok {
+		if err := c.recordChange(ctx, "created", nil, resultDoc); err != nil {
+			return ctx.WriteError(500, err.Error())
+		}
+	}
+
+	if err := c.commitWrite(ctx); err != nil {
 		return ctx.WriteError(500, err.Error())
 	}
 
@@ -517,25 +619,22 @@ func (c *Collection) handlePost(ctx *appcontext.Context) error {
 		"fields":     len(sanitized),
 	})
 
-	// Emit collection change event for real-time updates
-	if c.realtimeEmitter != nil {
-		logging.Debug("Emitting collection change event", fmt.Sprintf("collection:%s", c.name), map[string]interface{}{
-			"event": "created",
-			"hasEmitter": c.realtimeEmitter != nil,
-		})
-		c.realtimeEmitter.EmitCollectionChange(c.name, "created", result)
-		logging.Debug("Realtime emission completed", fmt.Sprintf("collection:%s", c.name), nil)
-	} else {
-		logging.Debug("No realtime emitter available", fmt.Sprintf("collection:%s", c.name), nil)
-	}
-
-	// Run AfterCommit event synchronously (can modify the response document)
+	// Emit the change and run the AfterCommit event once committed. The event
+	// runs before the response is written (and can modify it) unless this
+	// request joined the transaction of an event's caller.
 	if resultDoc, ok := result.(map[string]interface{}); ok {
-		c.runAfterCommitEvent(ctx, resultDoc, "POST")
-		// Use the potentially modified resultDoc for the response
-		return ctx.WriteJSON(resultDoc)
+		isOwner := c.isOwner(ctx, resultDoc)
+		c.afterWrite(ctx, "created", resultDoc, "POST")
+		response := copyDocument(resultDoc)
+		c.filterReadable(ctx, response, isOwner)
+		return ctx.WriteJSON(response)
 	}
 
+	if c.realtimeEmitter != nil {
+		database.AfterCommit(ctx.Context(), func() {
+			c.realtimeEmitter.EmitCollectionChange(c.name, "created", result)
+		})
+	}
 	return ctx.WriteJSON(result)
 }
 
@@ -545,6 +644,13 @@ func (c *Collection) handlePut(ctx *appcontext.Context) error {
 		return ctx.WriteError(400, "ID is required for PUT requests")
 	}
 
+	// Writes of the request and its events are committed together
+	rollback, err := c.beginWrite(ctx)
+	if err != nil {
+		return ctx.WriteError(500, err.Error())
+	}
+	defer rollback()
+
 	// Run BeforeRequest event
 	if err := c.runBeforeRequestEvent(ctx, "PUT"); err != nil {
 		if scriptErr, ok := err.(*events.ScriptError); ok {
@@ -572,15 +678,20 @@ func (c *Collection) handlePut(ctx *appcontext.Context) error {
 	skipEvents := false
 	if val, exists := ctx.Body["$skipEvents"]; exists {
 		if skip, ok := val.(bool); ok && skip {
-			skipEvents = true
+			skipEvents = skipEventsAllowed(ctx, skip)
 		}
 		// Remove $skipEvents from body so it doesn't interfere with validation/sanitization
 		delete(ctx.Body, "$skipEvents")
 	}
 
+	// Enforce field write rules against the stored document's owner
+	if status, message := c.checkWritable(ctx, ctx.Body, previous); status != 0 {
+		return ctx.WriteError(status, message)
+	}
+
 	// Validate and sanitize body
 	if err := c.validate(ctx.Body, false); err != nil {
-		return ctx.WriteError(400, err.Error())
+		return c.writeValidationError(ctx, err)
 	}
 
 	sanitized := c.sanitize(ctx.Body)
@@ -610,7 +721,7 @@ func (c *Collection) handlePut(ctx *appcontext.Context) error {
 				return ctx.WriteError(scriptErr.StatusCode, scriptErr.Message)
 			}
 			if validationErr, ok := err.(*events.ValidationError); ok {
-				return ctx.WriteError(400, validationErr.Error())
+				return ctx.WriteValidationErrors(validationErr.Errors)
 			}
 			return ctx.WriteError(500, err.Error())
 		}
@@ -648,7 +759,7 @@ func (c *Collection) handlePut(ctx *appcontext.Context) error {
 
 	_, err = c.store.Update(ctx.Context(), updateQuery, updateBuilder)
 	if err != nil {
-		return ctx.WriteError(500, err.Error())
+		return c.writeStoreError(ctx, err)
 	}
 
 	// Note: We don't check ModifiedCount() because it can be 0 if the document
@@ -660,16 +771,22 @@ func (c *Collection) handlePut(ctx *appcontext.Context) error {
 	if err != nil {
 		return ctx.WriteError(500, err.Error())
 	}
+	if err := c.recordChange(ctx, "updated", previous, doc); err != nil {
+		return ctx.WriteError(500, err.Error())
+	}
 
-	// Emit collection change event for real-time updates
-	if c.realtimeEmitter != nil {
-		c.realtimeEmitter.EmitCollectionChange(c.name, "updated", doc)
+	if err := c.commitWrite(ctx); err != nil {
+		return ctx.WriteError(500, err.Error())
 	}
 
-	// Run AfterCommit event synchronously (can modify the response document)
-	c.runAfterCommitEvent(ctx, doc, "PUT")
+	// Emit the change and run the AfterCommit event once committed (can
+	// modify the response document)
+	isOwner := c.isOwner(ctx, doc)
+	c.afterWrite(ctx, "updated", doc, "PUT")
 
-	return ctx.WriteJSON(doc)
+	response := copyDocument(doc)
+	c.filterReadable(ctx, response, isOwner)
+	return ctx.WriteJSON(response)
 }
 
 func (c *Collection) handleDelete(ctx *appcontext.Context) error {
@@ -678,6 +795,13 @@ func (c *Collection) handleDelete(ctx *appcontext.Context) error {
 		return ctx.WriteError(400, "ID is required for DELETE requests")
 	}
 
+	// Writes of the request and its events are committed together
+	rollback, err := c.beginWrite(ctx)
+	if err != nil {
+		return ctx.WriteError(500, err.Error())
+	}
+	defer rollback()
+
 	// Run BeforeRequest event
 	if err := c.runBeforeRequestEvent(ctx, "DELETE"); err != nil {
 		if scriptErr, ok := err.(*events.ScriptError); ok {
@@ -714,14 +838,16 @@ func (c *Collection) handleDelete(ctx *appcontext.Context) error {
 	if result.DeletedCount() == 0 {
 		return ctx.WriteError(404, "Document not found")
 	}
+	if err := c.recordChange(ctx, "deleted", doc, nil); err != nil {
+		return ctx.WriteError(500, err.Error())
+	}
 
-	// Emit collection change event for real-time updates
-	if c.realtimeEmitter != nil {
-		c.realtimeEmitter.EmitCollectionChange(c.name, "deleted", doc)
+	if err := c.commitWrite(ctx); err != nil {
+		return ctx.WriteError(500, err.Error())
 	}
 
-	// Run AfterCommit event synchronously (blocks HTTP response until complete)
-	c.runAfterCommitEvent(ctx, doc, "DELETE")
+	// Emit the change and run the AfterCommit event once committed
+	c.afterWrite(ctx, "deleted", doc, "DELETE")
 
 	return ctx.WriteJSON(map[string]interface{}{
 		"deleted": result.DeletedCount(),
@@ -769,9 +895,19 @@ func (c *Collection) handleQuery(ctx *appcontext.Context) error {
 
 	// Extract query options from body (if provided)
 	var opts database.QueryOptions
+	var includes []includeSpec
+	var page pageParams
 	if optsData, exists := ctx.Body["options"]; exists {
 		if optsMap, ok := optsData.(map[string]interface{}); ok {
 			opts = c.parseQueryOptions(optsMap)
+
+			var err error
+			if includes, err = c.queryIncludes(optsMap["$include"]); err != nil {
+				return ctx.WriteError(400, err.Error())
+			}
+			if page, err = parsePageParams(optsMap); err != nil {
+				return ctx.WriteError(400, err.Error())
+			}
 		}
 	} else {
 		// Set default options
@@ -784,18 +920,11 @@ func (c *Collection) handleQuery(ctx *appcontext.Context) error {
 	}
 
 	// Check for $skipEvents parameter to bypass events
-	skipEvents := false
-	if val, exists := ctx.Body["$skipEvents"]; exists {
-		if skip, ok := val.(bool); ok && skip {
-			skipEvents = true
-		}
-	}
+	skipEvents := skipEventsAllowed(ctx, ctx.Body["$skipEvents"])
 	if optsData, exists := ctx.Body["options"]; exists {
 		if optsMap, ok := optsData.(map[string]interface{}); ok {
-			if val, exists := optsMap["$skipEvents"]; exists {
-				if skip, ok := val.(bool); ok && skip {
-					skipEvents = true
-				}
+			if skipEventsAllowed(ctx, optsMap["$skipEvents"]) {
+				skipEvents = true
 			}
 		}
 	}
@@ -823,9 +952,15 @@ func (c *Collection) handleQuery(ctx *appcontext.Context) error {
 	fmt.Printf("DEBUG: Collection.handleQuery - forceMongo: %v\n", forceMongo)
 
 	var docs []map[string]interface{}
+	var paged *pageResult
+	var query database.QueryBuilder
 	var err error
 
 	if forceMongo {
+		if page.Envelope {
+			return ctx.WriteError(400, "Cursor pagination is not supported with $forceMongo")
+		}
+
 		// Use direct MongoDB-style query execution (bypassing SQL translation)
 		fmt.Printf("DEBUG: Collection.handleQuery - Using direct MongoDB query\n")
 		
@@ -860,14 +995,14 @@ func (c *Collection) handleQuery(ctx *appcontext.Context) error {
 		sanitizedQuery := c.sanitizeQuery(queryMap)
 		fmt.Printf("DEBUG: Collection.handleQuery - Sanitized query: %+v\n", sanitizedQuery)
 		
-		query := c.mapToQueryBuilder(sanitizedQuery)
+		query = c.mapToQueryBuilder(sanitizedQuery)
 		fmt.Printf("DEBUG: Collection.handleQuery - QueryBuilder created, calling store.Find\n")
 
 		// Execute the query
-		docs, err = c.store.Find(ctx.Context(), query, opts)
+		docs, paged, err = c.findDocs(ctx, query, opts, page)
 	}
 	if err != nil {
-		return ctx.WriteError(500, err.Error())
+		return writeFindError(ctx, err)
 	}
 
 	// Run Get event for each document (skip if $skipEvents is true)
@@ -883,13 +1018,19 @@ func (c *Collection) handleQuery(ctx *appcontext.Context) error {
 				continue // Skip documents that fail the Get event
 			}
 
+			c.filterReadable(ctx, eventDoc, c.isOwner(ctx, doc))
 			filteredDocs = append(filteredDocs, eventDoc)
 		} else {
+			c.filterReadable(ctx, doc, c.isOwner(ctx, doc))
 			filteredDocs = append(filteredDocs, doc)
 		}
 	}
 
-	return ctx.WriteJSON(filteredDocs)
+	if err := c.populateIncludes(ctx, filteredDocs, includes, skipEvents); err != nil {
+		return ctx.WriteError(500, err.Error())
+	}
+
+	return c.writePage(ctx, filteredDocs, query, page, paged)
 }
 
 // Helper method to parse query options from request body
@@ -959,24 +1100,26 @@ func (c *Collection) validate(data map[string]interface{}, isCreate bool) error
 			continue
 		}
 
-		if !c.validateType(value, prop.Type) {
-			errors[name] = fmt.Sprintf("must be a %s", prop.Type)
-		}
+		validateValue(name, prop, value, errors)
 	}
 
 	if len(errors) > 0 {
-		// Format errors as field: message pairs
-		var errorStrings []string
-		for field, message := range errors {
-			errorStrings = append(errorStrings, fmt.Sprintf("%s: %s", field, message))
-		}
-		return fmt.Errorf("validation errors: %s", strings.Join(errorStrings, ", "))
+		// Same shape as errors reported by events through ctx.Error
+		return &events.ValidationError{Errors: errors}
 	}
 
 	return nil
 }
 
-func (c *Collection) validateType(value interface{}, expectedType string) bool {
+// writeValidationError responds with the per-field errors of a failed validate
+func (c *Collection) writeValidationError(ctx *appcontext.Context, err error) error {
+	if validationErr, ok := err.(*events.ValidationError); ok {
+		return ctx.WriteValidationErrors(validationErr.Errors)
+	}
+	return ctx.WriteError(400, err.Error())
+}
+
+func validateType(value interface{}, expectedType string) bool {
 	switch expectedType {
 	case "string":
 		_, ok := value.(string)
@@ -1004,6 +1147,9 @@ func (c *Collection) validateType(value interface{}, expectedType string) bool {
 			_, ok = value.(map[string]interface{})
 		}
 		return ok
+	case "reference":
+		_, ok := value.(string)
+		return ok
 	}
 	return false
 }
@@ -1180,6 +1326,10 @@ func (c *Collection) extractQueryOptions(query map[string]interface{}) (database
 					opts.Skip = &skip
 				}
 			}
+		case "$include":
+			// Populated after the query by populateIncludes
+		case "$after", "$before", "$envelope", "$totalCount":
+			// Pagination options, read by parsePageParams
 		case "$fields":
 			// Handle field projection - support both object and string formats
 			if fieldsMap, ok := value.(map[string]interface{}); ok {
@@ -1443,6 +1593,22 @@ func (c *Collection) SetRealtimeEmitter(emitter events.RealtimeEmitter) {
 	if c.scriptManager != nil {
 		c.scriptManager.SetRealtimeEmitter(emitter)
 	}
+	c.registerSubscriptions(emitter)
+	c.registerRealtimeRules(emitter)
+}
+
+// SetDpdProvider sets the provider for the dpd client available to event scripts
+func (c *Collection) SetDpdProvider(provider events.DpdProvider) {
+	if c.scriptManager != nil {
+		c.scriptManager.SetDpdProvider(provider)
+	}
+}
+
+// SetEnqueuer sets the enqueuer behind context.enqueue in event scripts
+func (c *Collection) SetEnqueuer(enqueuer events.Enqueuer) {
+	if c.scriptManager != nil {
+		c.scriptManager.SetEnqueuer(enqueuer)
+	}
 }
 
 // isMongoCommand checks if the request body contains MongoDB operators
@@ -1468,6 +1634,19 @@ func (c *Collection) handleMongoCommand(ctx *appcontext.Context, id string) erro
 		return ctx.WriteError(404, "Document not found")
 	}
 
+	// Enforce field write rules on every field the operations touch
+	var fields []string
+	for _, value := range ctx.Body {
+		if valueMap, ok := value.(map[string]interface{}); ok {
+			for field := range valueMap {
+				fields = append(fields, field)
+			}
+		}
+	}
+	if status, message := c.writeDenied(c.unwritableFields(ctx, fields, c.isOwner(ctx, previous))); status != 0 {
+		return ctx.WriteError(status, message)
+	}
+
 	// Create a copy for the Put event (with anticipated changes)
 	merged := make(map[string]interface{})
 	for k, v := range previous {
@@ -1477,13 +1656,23 @@ func (c *Collection) handleMongoCommand(ctx *appcontext.Context, id string) erro
 	// Apply command operations for validation (simulate the changes)
 	c.simulateMongoOperations(merged, ctx.Body)
 
+	// Validate every field the operations touch as it will be stored, so
+	// $set and $inc are held to the same rules as a plain PUT
+	touched := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		touched[field] = merged[field]
+	}
+	if err := c.validate(touched, false); err != nil {
+		return c.writeValidationError(ctx, err)
+	}
+
 	// Run Validate event with simulated changes
 	if err := c.runValidateEvent(ctx, merged); err != nil {
 		if scriptErr, ok := err.(*events.ScriptError); ok {
 			return ctx.WriteError(scriptErr.StatusCode, scriptErr.Message)
 		}
 		if validationErr, ok := err.(*events.ValidationError); ok {
-			return ctx.WriteError(400, validationErr.Error())
+			return ctx.WriteValidationErrors(validationErr.Errors)
 		}
 		return ctx.WriteError(500, err.Error())
 	}
@@ -1514,7 +1703,7 @@ func (c *Collection) handleMongoCommand(ctx *appcontext.Context, id string) erro
 	}
 	result, err := c.store.Update(ctx.Context(), query, updateBuilder)
 	if err != nil {
-		return ctx.WriteError(500, err.Error())
+		return c.writeStoreError(ctx, err)
 	}
 
 	if result.ModifiedCount() == 0 {
@@ -1527,11 +1716,21 @@ func (c *Collection) handleMongoCommand(ctx *appcontext.Context, id string) erro
 	if err != nil {
 		return ctx.WriteError(500, err.Error())
 	}
+	if err := c.recordChange(ctx, "updated", previous, doc); err != nil {
+		return ctx.WriteError(500, err.Error())
+	}
+
+	if err := c.commitWrite(ctx); err != nil {
+		return ctx.WriteError(500, err.Error())
+	}
 
-	// Run AfterCommit event synchronously (can modify the response document)
-	c.runAfterCommitEvent(ctx, doc, "PUT")
+	// Run the AfterCommit event once committed (can modify the response document)
+	isOwner := c.isOwner(ctx, doc)
+	c.afterWrite(ctx, "", doc, "PUT")
 
-	return ctx.WriteJSON(doc)
+	response := copyDocument(doc)
+	c.filterReadable(ctx, response, isOwner)
+	return ctx.WriteJSON(response)
 }
 
 // simulateMongoOperations applies MongoDB operations to a document for validation
@@ -24,6 +24,11 @@ func (m *MockStore) Insert(ctx context.Context, document interface{}) (interface
 	return args.Get(0), args.Error(1)
 }
 
+func (m *MockStore) InsertMany(ctx context.Context, documents []map[string]interface{}) ([]map[string]interface{}, error) {
+	args := m.Called(ctx, documents)
+	return args.Get(0).([]map[string]interface{}), args.Error(1)
+}
+
 func (m *MockStore) Find(ctx context.Context, query database.QueryBuilder, opts database.QueryOptions) ([]map[string]interface{}, error) {
 	args := m.Called(ctx, query, opts)
 	return args.Get(0).([]map[string]interface{}), args.Error(1)
@@ -0,0 +1,625 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/database"
+	"github.com/hjanuschka/go-deployd/internal/events"
+	"github.com/hjanuschka/go-deployd/internal/logging"
+	"github.com/hjanuschka/go-deployd/internal/schedule"
+)
+
+// Stores of cron jobs, shared by all jobs
+const (
+	cronRunStore  = "_cron_runs"
+	cronLockStore = "_cron_locks"
+)
+
+// States of a cron run
+const (
+	CronRunRunning   = "running"
+	CronRunSucceeded = "succeeded"
+	CronRunFailed    = "failed"
+	// CronRunSkipped runs were due while the job was still running
+	CronRunSkipped = "skipped"
+)
+
+// What started a cron run
+const (
+	CronTriggerSchedule = "schedule"
+	CronTriggerManual   = "manual"
+)
+
+const (
+	defaultCronHistory = 100
+	// cronLockLease is how long a server holds a job's lock without
+	// renewing it. A server that dies mid-run frees the job after it.
+	cronLockLease       = time.Minute
+	defaultCronRunLimit = 50
+	maxCronRunLimit     = 500
+)
+
+// ErrCronRunning is returned when starting a job that is already running,
+// on this server or another
+var ErrCronRunning = errors.New("job is already running")
+
+// CronConfig is the config.json of a cron resource, which runs its run.js or
+// run.go script on a schedule
+type CronConfig struct {
+	Type     string `json:"type"`               // "cron"
+	Schedule string `json:"schedule"`           // Cron expression, e.g. "0 3 * * *" or "@every 10m"
+	Timezone string `json:"timezone,omitempty"` // IANA name; the server's local time if empty
+	Runtime  string `json:"runtime,omitempty"`  // js (default) or go
+	Disabled bool   `json:"disabled,omitempty"` // Only runs when started from the admin API
+	History  int    `json:"history,omitempty"`  // Runs kept, 100 by default
+	// Fetch is what the script may request with fetch
+	Fetch *events.FetchConfig `json:"fetch,omitempty"`
+	// Limits bound the time and memory of JavaScript runs
+	Limits *events.ScriptLimits `json:"limits,omitempty"`
+}
+
+// CronRun is one run of a cron job
+type CronRun struct {
+	ID          string     `json:"id"`
+	Job         string     `json:"job"`
+	Trigger     string     `json:"trigger"`
+	Status      string     `json:"status"`
+	ScheduledAt *time.Time `json:"scheduledAt,omitempty"` // Fire time of scheduled runs
+	StartedAt   time.Time  `json:"startedAt"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	Duration    int64      `json:"durationMs"`
+	Error       string     `json:"error,omitempty"`
+	Node        string     `json:"node"` // Server that ran it
+}
+
+// cronRunDocument is how a run is stored, with times as Unix milliseconds
+func cronRunDocument(run *CronRun) map[string]interface{} {
+	doc := map[string]interface{}{
+		"id":          run.ID,
+		"job":         run.Job,
+		"trigger":     run.Trigger,
+		"status":      run.Status,
+		"scheduledAt": int64(0),
+		"startedAt":   run.StartedAt.UnixMilli(),
+		"finishedAt":  int64(0),
+		"durationMs":  run.Duration,
+		"error":       run.Error,
+		"node":        run.Node,
+	}
+	if run.ScheduledAt != nil {
+		doc["scheduledAt"] = run.ScheduledAt.UnixMilli()
+	}
+	if run.FinishedAt != nil {
+		doc["finishedAt"] = run.FinishedAt.UnixMilli()
+	}
+	return doc
+}
+
+func cronRunFromDocument(doc map[string]interface{}) *CronRun {
+	run := &CronRun{}
+	run.ID, _ = doc["id"].(string)
+	run.Job, _ = doc["job"].(string)
+	run.Trigger, _ = doc["trigger"].(string)
+	run.Status, _ = doc["status"].(string)
+	run.Error, _ = doc["error"].(string)
+	run.Node, _ = doc["node"].(string)
+	run.Duration, _ = toInt64(doc["durationMs"])
+	if started, ok := toInt64(doc["startedAt"]); ok {
+		run.StartedAt = time.UnixMilli(started).UTC()
+	}
+	if scheduled, ok := toInt64(doc["scheduledAt"]); ok && scheduled > 0 {
+		t := time.UnixMilli(scheduled).UTC()
+		run.ScheduledAt = &t
+	}
+	if finished, ok := toInt64(doc["finishedAt"]); ok && finished > 0 {
+		t := time.UnixMilli(finished).UTC()
+		run.FinishedAt = &t
+	}
+	return run
+}
+
+// CronJob is a resource that runs a script on a schedule instead of on
+// requests. Each run holds the job's lock in the database, so a job runs on
+// one server at a time and runs that are due while it still runs are
+// skipped. Servers sharing a database run each scheduled run once.
+type CronJob struct {
+	*BaseResource
+	config        *CronConfig
+	schedule      schedule.Schedule
+	location      *time.Location
+	scriptManager *events.UniversalScriptManager
+	runs          database.StoreInterface
+	locks         database.StoreInterface
+	node          string
+
+	mu   sync.Mutex
+	next time.Time
+
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+}
+
+// CronJobInfo describes a cron job and its state
+type CronJobInfo struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`
+	Timezone string     `json:"timezone"`
+	Runtime  string     `json:"runtime"`
+	Disabled bool       `json:"disabled"`
+	Running  bool       `json:"running"`           // On any server
+	NextRun  *time.Time `json:"nextRun,omitempty"` // On this server
+	LastRun  *CronRun   `json:"lastRun,omitempty"`
+}
+
+// ValidateCronConfig checks a cron config and returns its schedule and the
+// location it runs in
+func ValidateCronConfig(config *CronConfig) (schedule.Schedule, *time.Location, error) {
+	s, err := schedule.Parse(config.Schedule)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid schedule: %w", err)
+	}
+	location := time.Local
+	if config.Timezone != "" {
+		if location, err = time.LoadLocation(config.Timezone); err != nil {
+			return nil, nil, fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+	switch config.Runtime {
+	case "", string(events.ScriptTypeJS), string(events.ScriptTypeGo):
+	default:
+		return nil, nil, fmt.Errorf("runtime must be js or go, not %q", config.Runtime)
+	}
+	if config.History < 0 {
+		return nil, nil, fmt.Errorf("history must not be negative")
+	}
+	if err := config.Fetch.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid fetch config: %w", err)
+	}
+	if err := config.Limits.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid limits: %w", err)
+	}
+	return s, location, nil
+}
+
+// LoadCronJob loads the cron job configured in configPath
+func LoadCronJob(name, configPath string, db database.DatabaseInterface, emitter events.RealtimeEmitter) (*CronJob, error) {
+	data, err := os.ReadFile(filepath.Join(configPath, "config.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var config CronConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	s, location, err := ValidateCronConfig(&config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Runtime == "" {
+		config.Runtime = string(events.ScriptTypeJS)
+	}
+
+	scriptManager := events.NewUniversalScriptManager()
+	if !scriptManager.LoadScript(configPath, events.EventRun, "run", config.Runtime) {
+		return nil, fmt.Errorf("no script to run: add run.%s", config.Runtime)
+	}
+	scriptManager.SetFetchConfig(config.Fetch)
+	scriptManager.SetScriptLimits(config.Limits)
+	if emitter != nil {
+		scriptManager.SetRealtimeEmitter(emitter)
+	}
+
+	locks := db.CreateStore(cronLockStore)
+	host, _ := os.Hostname()
+	return &CronJob{
+		BaseResource:  NewBaseResource(name),
+		config:        &config,
+		schedule:      s,
+		location:      location,
+		scriptManager: scriptManager,
+		runs:          db.CreateStore(cronRunStore),
+		locks:         locks,
+		node:          host + "-" + locks.CreateUniqueIdentifier(),
+		done:          make(chan struct{}),
+	}, nil
+}
+
+// Handle refuses requests; cron jobs are started by their schedule or the
+// admin API
+func (j *CronJob) Handle(ctx *appcontext.Context) error {
+	return ctx.WriteError(http.StatusNotFound, "Not found")
+}
+
+// GetConfig returns the job's config
+func (j *CronJob) GetConfig() *CronConfig {
+	return j.config
+}
+
+// SetDpdProvider lets the job's script call collections through dpd
+func (j *CronJob) SetDpdProvider(provider events.DpdProvider) {
+	j.scriptManager.SetDpdProvider(provider)
+}
+
+// SetEnqueuer lets the job's script queue background jobs
+func (j *CronJob) SetEnqueuer(enqueuer events.Enqueuer) {
+	j.scriptManager.SetEnqueuer(enqueuer)
+}
+
+// Start runs the job on its schedule until Stop, unless it is disabled
+func (j *CronJob) Start() {
+	if j.config.Disabled {
+		return
+	}
+	j.startOnce.Do(func() {
+		j.wg.Add(1)
+		go j.loop()
+	})
+}
+
+// Stop stops scheduling the job and waits for the run in progress
+func (j *CronJob) Stop() {
+	j.stopOnce.Do(func() {
+		close(j.done)
+	})
+	j.wg.Wait()
+}
+
+func (j *CronJob) loop() {
+	defer j.wg.Done()
+	for {
+		next := j.schedule.Next(time.Now().In(j.location))
+		if next.IsZero() {
+			logging.Warn("Cron job never runs", "cron", map[string]interface{}{
+				"job":      j.name,
+				"schedule": j.config.Schedule,
+			})
+			return
+		}
+		j.mu.Lock()
+		j.next = next
+		j.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-j.done:
+			timer.Stop()
+			return
+		}
+		// Runs that take longer than the interval must not hold up the
+		// schedule, so the runs due meanwhile are recorded as skipped
+		j.wg.Add(1)
+		go func() {
+			defer j.wg.Done()
+			if run, _ := j.begin(CronTriggerSchedule, &next); run != nil {
+				j.execute(run)
+			}
+		}()
+	}
+}
+
+// RunNow starts a run outside the schedule and returns it while it runs.
+// It returns ErrCronRunning if the job is running.
+func (j *CronJob) RunNow() (*CronRun, error) {
+	run, err := j.begin(CronTriggerManual, nil)
+	if err != nil {
+		return nil, err
+	}
+	started := *run
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		j.execute(run)
+	}()
+	return &started, nil
+}
+
+// begin takes the job's lock and records the start of a run. A scheduled
+// run that is due while the job runs is recorded as skipped, by one server,
+// and begin returns nil.
+func (j *CronJob) begin(trigger string, scheduledAt *time.Time) (*CronRun, error) {
+	ctx := context.Background()
+	now := time.Now()
+	locked, err := j.lock(ctx, now, scheduledAt)
+	if err != nil {
+		logging.Error("Failed to lock cron job", "cron", map[string]interface{}{
+			"job":   j.name,
+			"error": err.Error(),
+		})
+		return nil, err
+	}
+
+	run := &CronRun{
+		ID:          j.runs.CreateUniqueIdentifier(),
+		Job:         j.name,
+		Trigger:     trigger,
+		Status:      CronRunRunning,
+		ScheduledAt: scheduledAt,
+		StartedAt:   now.UTC(),
+		Node:        j.node,
+	}
+	if !locked {
+		if scheduledAt == nil {
+			return nil, ErrCronRunning
+		}
+		if !j.claimSkipped(ctx, *scheduledAt) {
+			// Another server ran it
+			return nil, nil
+		}
+		run.Status = CronRunSkipped
+		run.FinishedAt = &run.StartedAt
+		logging.Warn("Cron job skipped, still running", "cron", map[string]interface{}{
+			"job":          j.name,
+			"scheduled_at": scheduledAt.UTC(),
+		})
+	}
+
+	if _, err := j.runs.Insert(ctx, cronRunDocument(run)); err != nil {
+		if locked {
+			j.unlock(ctx)
+		}
+		return nil, fmt.Errorf("failed to record cron run: %w", err)
+	}
+	if !locked {
+		return nil, nil
+	}
+	return run, nil
+}
+
+// lock takes the job's lock for cronLockLease if no server holds it. A
+// scheduled run also takes its fire time, so each one runs once however many
+// servers are due to run it.
+func (j *CronJob) lock(ctx context.Context, now time.Time, scheduledAt *time.Time) (bool, error) {
+	query := database.NewQueryBuilder().
+		Where("id", "$eq", j.name).
+		Where("lockedUntil", "$lte", now.UnixMilli())
+	update := database.NewUpdateBuilder().
+		Set("owner", j.node).
+		Set("lockedUntil", now.Add(cronLockLease).UnixMilli())
+	if scheduledAt != nil {
+		query = query.Where("lastScheduled", "$lt", scheduledAt.UnixMilli())
+		update = update.Set("lastScheduled", scheduledAt.UnixMilli())
+	}
+	result, err := j.locks.UpdateOne(ctx, query, update)
+	if err != nil {
+		return false, err
+	}
+	if result.ModifiedCount() > 0 {
+		return true, nil
+	}
+
+	// The first run of a job creates its lock
+	existing, err := j.locks.FindOne(ctx, database.NewQueryBuilder().Where("id", "$eq", j.name))
+	if err != nil || existing != nil {
+		return false, err
+	}
+	doc := map[string]interface{}{
+		"id":            j.name,
+		"owner":         j.node,
+		"lockedUntil":   now.Add(cronLockLease).UnixMilli(),
+		"lastScheduled": int64(0),
+	}
+	if scheduledAt != nil {
+		doc["lastScheduled"] = scheduledAt.UnixMilli()
+	}
+	if _, err := j.locks.Insert(ctx, doc); err != nil {
+		// Another server created it first
+		return false, nil
+	}
+	return true, nil
+}
+
+// claimSkipped takes a fire time of the job while it runs, so that one
+// server records the skipped run
+func (j *CronJob) claimSkipped(ctx context.Context, scheduledAt time.Time) bool {
+	query := database.NewQueryBuilder().
+		Where("id", "$eq", j.name).
+		Where("lastScheduled", "$lt", scheduledAt.UnixMilli())
+	result, err := j.locks.UpdateOne(ctx, query, database.NewUpdateBuilder().Set("lastScheduled", scheduledAt.UnixMilli()))
+	return err == nil && result.ModifiedCount() > 0
+}
+
+// renew extends the job's lock while this server runs it
+func (j *CronJob) renew(ctx context.Context) bool {
+	query := database.NewQueryBuilder().
+		Where("id", "$eq", j.name).
+		Where("owner", "$eq", j.node)
+	result, err := j.locks.UpdateOne(ctx, query, database.NewUpdateBuilder().Set("lockedUntil", time.Now().Add(cronLockLease).UnixMilli()))
+	return err == nil && result.ModifiedCount() > 0
+}
+
+func (j *CronJob) unlock(ctx context.Context) {
+	query := database.NewQueryBuilder().
+		Where("id", "$eq", j.name).
+		Where("owner", "$eq", j.node)
+	if _, err := j.locks.UpdateOne(ctx, query, database.NewUpdateBuilder().Set("lockedUntil", int64(0))); err != nil {
+		logging.Error("Failed to unlock cron job", "cron", map[string]interface{}{
+			"job":   j.name,
+			"error": err.Error(),
+		})
+	}
+}
+
+// execute runs the job's script for a run it began, records the result and
+// releases the lock
+func (j *CronJob) execute(run *CronRun) {
+	ctx := context.Background()
+	renewing := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(cronLockLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !j.renew(ctx) {
+					logging.Warn("Cron job lost its lock while running", "cron", map[string]interface{}{
+						"job":    j.name,
+						"run_id": run.ID,
+					})
+				}
+			case <-renewing:
+				return
+			}
+		}
+	}()
+
+	err := j.runScript(run)
+	close(renewing)
+	<-renewed
+
+	finished := time.Now().UTC()
+	run.FinishedAt = &finished
+	run.Duration = finished.Sub(run.StartedAt).Milliseconds()
+	run.Status = CronRunSucceeded
+	logFields := map[string]interface{}{
+		"job":        j.name,
+		"run_id":     run.ID,
+		"trigger":    run.Trigger,
+		"durationMs": run.Duration,
+	}
+	if err != nil {
+		run.Status = CronRunFailed
+		run.Error = err.Error()
+		logFields["error"] = run.Error
+		logging.Error("Cron job failed", "cron", logFields)
+	} else {
+		logging.Info("Cron job finished", "cron", logFields)
+	}
+
+	doc := cronRunDocument(run)
+	update := database.NewUpdateBuilder().
+		Set("status", doc["status"]).
+		Set("finishedAt", doc["finishedAt"]).
+		Set("durationMs", doc["durationMs"]).
+		Set("error", doc["error"])
+	if _, err := j.runs.UpdateOne(ctx, database.NewQueryBuilder().Where("id", "$eq", run.ID), update); err != nil {
+		logging.Error("Failed to record cron run", "cron", map[string]interface{}{
+			"job":    j.name,
+			"run_id": run.ID,
+			"error":  err.Error(),
+		})
+	}
+	j.prune(ctx)
+	j.unlock(ctx)
+}
+
+// runScript runs the job's script as root. Its context.data holds the job,
+// run ID, trigger and fire time.
+func (j *CronJob) runScript(run *CronRun) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("script panicked: %v", r)
+		}
+	}()
+
+	req := httptest.NewRequest(http.MethodPost, j.GetPath(), nil)
+	ctx := appcontext.New(req, httptest.NewRecorder(), j, &appcontext.AuthData{
+		IsRoot:          true,
+		IsAuthenticated: true,
+	}, false)
+	data := map[string]interface{}{
+		"job":     j.name,
+		"runId":   run.ID,
+		"trigger": run.Trigger,
+	}
+	if run.ScheduledAt != nil {
+		data["scheduledAt"] = run.ScheduledAt.UTC().Format(time.RFC3339)
+	}
+	return j.scriptManager.RunEvent(events.EventRun, ctx, data)
+}
+
+// prune removes the job's runs beyond its history
+func (j *CronJob) prune(ctx context.Context) {
+	keep := int64(j.config.History)
+	if keep == 0 {
+		keep = defaultCronHistory
+	}
+	limit := int64(1000)
+	docs, err := j.runs.Find(ctx, database.NewQueryBuilder().Where("job", "$eq", j.name),
+		database.QueryOptions{Sort: map[string]int{"startedAt": -1}, Skip: &keep, Limit: &limit})
+	if err != nil || len(docs) == 0 {
+		return
+	}
+	ids := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc["id"]
+	}
+	if _, err := j.runs.Remove(ctx, database.NewQueryBuilder().WhereIn("id", ids)); err != nil {
+		logging.Error("Failed to prune cron runs", "cron", map[string]interface{}{
+			"job":   j.name,
+			"error": err.Error(),
+		})
+	}
+}
+
+// Runs returns the job's most recent runs, newest first, optionally only
+// those in a state
+func (j *CronJob) Runs(ctx context.Context, status string, limit int64) ([]*CronRun, error) {
+	if limit <= 0 {
+		limit = defaultCronRunLimit
+	}
+	if limit > maxCronRunLimit {
+		limit = maxCronRunLimit
+	}
+	query := database.NewQueryBuilder().Where("job", "$eq", j.name)
+	if status != "" {
+		query = query.Where("status", "$eq", status)
+	}
+	docs, err := j.runs.Find(ctx, query, database.QueryOptions{Sort: map[string]int{"startedAt": -1}, Limit: &limit})
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]*CronRun, len(docs))
+	for i, doc := range docs {
+		runs[i] = cronRunFromDocument(doc)
+	}
+	return runs, nil
+}
+
+// Info returns the job's config and state
+func (j *CronJob) Info(ctx context.Context) (*CronJobInfo, error) {
+	info := &CronJobInfo{
+		Name:     j.name,
+		Schedule: j.config.Schedule,
+		Timezone: j.location.String(),
+		Runtime:  j.config.Runtime,
+		Disabled: j.config.Disabled,
+	}
+	j.mu.Lock()
+	if !j.next.IsZero() {
+		next := j.next
+		info.NextRun = &next
+	}
+	j.mu.Unlock()
+
+	lock, err := j.locks.FindOne(ctx, database.NewQueryBuilder().Where("id", "$eq", j.name))
+	if err != nil {
+		return nil, err
+	}
+	if lock != nil {
+		lockedUntil, _ := toInt64(lock["lockedUntil"])
+		info.Running = lockedUntil > time.Now().UnixMilli()
+	}
+	runs, err := j.Runs(ctx, "", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) > 0 {
+		info.LastRun = runs[0]
+	}
+	return info, nil
+}
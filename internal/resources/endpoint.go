@@ -0,0 +1,476 @@
+package resources
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/database"
+	"github.com/hjanuschka/go-deployd/internal/events"
+	"github.com/hjanuschka/go-deployd/internal/logging"
+)
+
+// endpointRawBodyLimit caps the request bodies handlers get unparsed, those
+// that are neither JSON nor a form
+const endpointRawBodyLimit = 1 << 20
+
+// Methods endpoint routes can handle
+var endpointMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+}
+
+// handlerName matches the names of handler scripts, which are file names in
+// the endpoint's directory without their extension
+var handlerName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// EndpointConfig is the config.json of an endpoint resource, which routes
+// requests below its path to handler scripts instead of storing documents
+type EndpointConfig struct {
+	Type    string          `json:"type"`              // "endpoint"
+	Runtime string          `json:"runtime,omitempty"` // js (default) or go, for routes without their own
+	Routes  []EndpointRoute `json:"routes"`
+	// Fetch is what the handlers may request with fetch
+	Fetch *events.FetchConfig `json:"fetch,omitempty"`
+	// Limits bound the time and memory of JavaScript handlers
+	Limits *events.ScriptLimits `json:"limits,omitempty"`
+}
+
+// EndpointRoute maps a method and path to the handler script that answers
+// it, e.g. POST /:invoiceId/pay to pay.js
+type EndpointRoute struct {
+	Method string `json:"method"` // GET, POST, PUT, PATCH or DELETE
+	// Path is below the endpoint's path. Segments starting with a colon
+	// are parameters, e.g. "/:invoiceId/pay".
+	Path    string `json:"path"`
+	Handler string `json:"handler"`           // Script run for requests: <handler>.js or <handler>.go
+	Runtime string `json:"runtime,omitempty"` // The endpoint's if empty
+	Status  int    `json:"status,omitempty"`  // Status of successful responses, 200 by default
+
+	// Documentation for the API docs. The request schema, an object, is
+	// also checked against request bodies like a collection's properties.
+	Summary     string    `json:"summary,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Request     *Property `json:"request,omitempty"`
+	Response    *Property `json:"response,omitempty"`
+}
+
+// Endpoint is a resource whose routes run handler scripts, for logic that
+// doesn't fit reading and writing the documents of a collection. Handlers
+// set the status, headers and body of the response.
+type Endpoint struct {
+	*BaseResource
+	config        *EndpointConfig
+	scriptManager *events.UniversalScriptManager
+	db            database.DatabaseInterface
+}
+
+// pathSegments splits a path into its non-empty segments
+func pathSegments(path string) []string {
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// Params returns the names of the route's path parameters, in order
+func (r *EndpointRoute) Params() []string {
+	var params []string
+	for _, segment := range pathSegments(r.Path) {
+		if strings.HasPrefix(segment, ":") {
+			params = append(params, segment[1:])
+		}
+	}
+	return params
+}
+
+// match reports whether the route's path matches the segments of a request
+// path and returns the values of its parameters
+func (r *EndpointRoute) match(segments []string) (map[string]interface{}, bool) {
+	pattern := pathSegments(r.Path)
+	if len(pattern) != len(segments) {
+		return nil, false
+	}
+	params := make(map[string]interface{})
+	for i, segment := range pattern {
+		if strings.HasPrefix(segment, ":") {
+			params[segment[1:]] = segments[i]
+		} else if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// ValidateEndpointConfig checks an endpoint config
+func ValidateEndpointConfig(config *EndpointConfig) error {
+	if err := validateRuntime(config.Runtime); err != nil {
+		return err
+	}
+	if len(config.Routes) == 0 {
+		return fmt.Errorf("an endpoint needs routes")
+	}
+	if err := config.Fetch.Validate(); err != nil {
+		return fmt.Errorf("invalid fetch config: %w", err)
+	}
+	if err := config.Limits.Validate(); err != nil {
+		return fmt.Errorf("invalid limits: %w", err)
+	}
+
+	runtimes := make(map[string]string)
+	seen := make(map[string]bool)
+	for i, route := range config.Routes {
+		label := fmt.Sprintf("route %s %s", route.Method, route.Path)
+		method := strings.ToUpper(route.Method)
+		if !containsString(endpointMethods, method) {
+			return fmt.Errorf("route %d: method must be one of %s", i, strings.Join(endpointMethods, ", "))
+		}
+		if !strings.HasPrefix(route.Path, "/") {
+			return fmt.Errorf("%s: path must start with /", label)
+		}
+		params := make(map[string]bool)
+		for _, param := range route.Params() {
+			if param == "" || params[param] {
+				return fmt.Errorf("%s: parameters need distinct names", label)
+			}
+			params[param] = true
+		}
+		key := method + " " + strings.Join(pathSegments(route.Path), "/")
+		if seen[key] {
+			return fmt.Errorf("%s: declared twice", label)
+		}
+		seen[key] = true
+
+		if !handlerName.MatchString(route.Handler) || route.Handler == "config" {
+			return fmt.Errorf("%s: handler must be the name of a script, not %q", label, route.Handler)
+		}
+		if err := validateRuntime(route.Runtime); err != nil {
+			return fmt.Errorf("%s: %w", label, err)
+		}
+		runtime := route.Runtime
+		if runtime == "" {
+			runtime = config.Runtime
+		}
+		if runtime == "" {
+			runtime = string(events.ScriptTypeJS)
+		}
+		if previous, ok := runtimes[route.Handler]; ok && previous != runtime {
+			return fmt.Errorf("%s: handler %s is used with different runtimes", label, route.Handler)
+		}
+		runtimes[route.Handler] = runtime
+
+		if route.Status != 0 && (route.Status < 100 || route.Status > 599) {
+			return fmt.Errorf("%s: invalid status %d", label, route.Status)
+		}
+		if route.Request != nil {
+			if route.Request.Type != "" && route.Request.Type != "object" {
+				return fmt.Errorf("%s: the request schema must be an object", label)
+			}
+			if err := validatePropertyRules("request", *route.Request); err != nil {
+				return fmt.Errorf("%s: %w", label, err)
+			}
+		}
+		if route.Response != nil {
+			if err := validatePropertyRules("response", *route.Response); err != nil {
+				return fmt.Errorf("%s: %w", label, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateRuntime(runtime string) error {
+	switch runtime {
+	case "", string(events.ScriptTypeJS), string(events.ScriptTypeGo):
+		return nil
+	}
+	return fmt.Errorf("runtime must be js or go, not %q", runtime)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadEndpoint loads the endpoint configured in configPath and the handler
+// scripts of its routes
+func LoadEndpoint(name, configPath string, db database.DatabaseInterface, emitter events.RealtimeEmitter) (*Endpoint, error) {
+	data, err := os.ReadFile(filepath.Join(configPath, "config.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var config EndpointConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if err := ValidateEndpointConfig(&config); err != nil {
+		return nil, err
+	}
+	if config.Runtime == "" {
+		config.Runtime = string(events.ScriptTypeJS)
+	}
+
+	scriptManager := events.NewUniversalScriptManager()
+	loaded := make(map[string]bool)
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		route.Method = strings.ToUpper(route.Method)
+		if route.Runtime == "" {
+			route.Runtime = config.Runtime
+		}
+		if loaded[route.Handler] {
+			continue
+		}
+		if !scriptManager.LoadScript(configPath, events.EventType(route.Handler), route.Handler, route.Runtime) {
+			return nil, fmt.Errorf("no script for route %s %s: add %s.%s", route.Method, route.Path, route.Handler, route.Runtime)
+		}
+		loaded[route.Handler] = true
+	}
+	scriptManager.SetFetchConfig(config.Fetch)
+	scriptManager.SetScriptLimits(config.Limits)
+	if emitter != nil {
+		scriptManager.SetRealtimeEmitter(emitter)
+	}
+
+	return &Endpoint{
+		BaseResource:  NewBaseResource(name),
+		config:        &config,
+		scriptManager: scriptManager,
+		db:            db,
+	}, nil
+}
+
+// GetConfig returns the endpoint's config
+func (e *Endpoint) GetConfig() *EndpointConfig {
+	return e.config
+}
+
+// SetDpdProvider lets the handlers call collections through dpd
+func (e *Endpoint) SetDpdProvider(provider events.DpdProvider) {
+	e.scriptManager.SetDpdProvider(provider)
+}
+
+// SetEnqueuer lets the handlers queue background jobs
+func (e *Endpoint) SetEnqueuer(enqueuer events.Enqueuer) {
+	e.scriptManager.SetEnqueuer(enqueuer)
+}
+
+// route returns the first route matching a request and its path parameters.
+// If only routes for other methods match the path, it returns those methods.
+func (e *Endpoint) route(method, path string) (*EndpointRoute, map[string]interface{}, []string) {
+	segments := pathSegments(path)
+	var allowed []string
+	for i := range e.config.Routes {
+		route := &e.config.Routes[i]
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		if route.Method == method {
+			return route, params, nil
+		}
+		if !containsString(allowed, route.Method) {
+			allowed = append(allowed, route.Method)
+		}
+	}
+	sort.Strings(allowed)
+	return nil, nil, allowed
+}
+
+// Handle runs the handler of the route matching the request. Handlers of
+// requests other than GET run in a transaction, so their writes through dpd
+// are committed together once they succeed.
+func (e *Endpoint) Handle(ctx *appcontext.Context) error {
+	route, params, allowed := e.route(ctx.Method, ctx.URL)
+	if route == nil {
+		if len(allowed) > 0 {
+			ctx.Response.Header().Set("Allow", strings.Join(allowed, ", "))
+			return ctx.WriteError(http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		return ctx.WriteError(http.StatusNotFound, "Not found")
+	}
+
+	if route.Request != nil {
+		if fieldErrors := validateRequest(route.Request, ctx.Body); len(fieldErrors) > 0 {
+			return ctx.WriteValidationErrors(fieldErrors)
+		}
+	}
+	data, err := e.requestData(ctx, route, params)
+	if err != nil {
+		return ctx.WriteError(http.StatusRequestEntityTooLarge, err.Error())
+	}
+
+	rollback, err := e.begin(ctx)
+	if err != nil {
+		return ctx.WriteError(http.StatusInternalServerError, err.Error())
+	}
+	defer rollback()
+
+	if err := e.scriptManager.RunEvent(events.EventType(route.Handler), ctx, data); err != nil {
+		var scriptErr *events.ScriptError
+		var validationErr *events.ValidationError
+		switch {
+		case errors.As(err, &scriptErr):
+			return ctx.WriteError(scriptErr.StatusCode, scriptErr.Message)
+		case errors.As(err, &validationErr):
+			return ctx.WriteValidationErrors(validationErr.Errors)
+		}
+		return ctx.WriteError(http.StatusInternalServerError, err.Error())
+	}
+	if err := e.commit(ctx); err != nil {
+		return ctx.WriteError(http.StatusInternalServerError, err.Error())
+	}
+	return e.writeResponse(ctx, route, data)
+}
+
+// validateRequest checks a request body against the request schema of a
+// route, with errors keyed by field as collections report them
+func validateRequest(schema *Property, body map[string]interface{}) map[string]string {
+	errors := make(map[string]string)
+	for name, prop := range schema.Properties {
+		value, exists := body[name]
+		if !exists || value == nil {
+			if prop.Required {
+				errors[name] = "is required"
+			}
+			continue
+		}
+		validateValue(name, prop, value, errors)
+	}
+	return errors
+}
+
+// requestData is the context.data of a handler: the request, and the
+// response it fills in
+func (e *Endpoint) requestData(ctx *appcontext.Context, route *EndpointRoute, params map[string]interface{}) (map[string]interface{}, error) {
+	headers := make(map[string]interface{})
+	for key, values := range ctx.Request.Header {
+		if len(values) > 0 {
+			headers[strings.ToLower(key)] = values[0]
+		}
+	}
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	data := map[string]interface{}{
+		"method":  ctx.Method,
+		"path":    ctx.URL,
+		"params":  params,
+		"query":   ctx.Query,
+		"headers": headers,
+		"body":    ctx.Body,
+		"response": map[string]interface{}{
+			"status":  status,
+			"headers": map[string]interface{}{},
+		},
+	}
+
+	// Bodies that are neither JSON nor a form are passed on as they are
+	contentType := ctx.Request.Header.Get("Content-Type")
+	if ctx.Request.Body != nil && !strings.Contains(contentType, "application/json") &&
+		!strings.Contains(contentType, "application/x-www-form-urlencoded") {
+		raw, err := io.ReadAll(io.LimitReader(ctx.Request.Body, endpointRawBodyLimit+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) > endpointRawBodyLimit {
+			return nil, fmt.Errorf("request body is larger than %d bytes", endpointRawBodyLimit)
+		}
+		if len(raw) > 0 {
+			data["rawBody"] = string(raw)
+		}
+	}
+	return data, nil
+}
+
+// begin opens a transaction for requests other than GET if the database
+// supports them. The returned function rolls back whatever was not
+// committed and must be deferred.
+func (e *Endpoint) begin(ctx *appcontext.Context) (func(), error) {
+	db, ok := e.db.(database.Transactional)
+	if !ok || ctx.Method == http.MethodGet {
+		return func() {}, nil
+	}
+
+	return beginTx(ctx, db)
+}
+
+// commit commits the transaction opened by begin
+func (e *Endpoint) commit(ctx *appcontext.Context) error {
+	db, ok := e.db.(database.Transactional)
+	if !ok || ctx.Method == http.MethodGet {
+		return nil
+	}
+	return commitTx(ctx, db)
+}
+
+// writeResponse writes the response a handler filled in. String and byte
+// bodies are written as they are, other bodies as JSON.
+func (e *Endpoint) writeResponse(ctx *appcontext.Context, route *EndpointRoute, data map[string]interface{}) error {
+	response, _ := data["response"].(map[string]interface{})
+	status := http.StatusOK
+	if value, ok := toInt64(response["status"]); ok {
+		status = int(value)
+	}
+	if status < 100 || status > 599 {
+		logging.Error("Endpoint handler set an invalid status", "endpoint:"+e.name, map[string]interface{}{
+			"handler": route.Handler,
+			"status":  response["status"],
+		})
+		return ctx.WriteError(http.StatusInternalServerError, "invalid response status")
+	}
+
+	header := ctx.Response.Header()
+	if headers, ok := response["headers"].(map[string]interface{}); ok {
+		for name, value := range headers {
+			header.Set(name, fmt.Sprint(value))
+		}
+	}
+
+	var body []byte
+	switch value := response["body"].(type) {
+	case nil:
+	case string:
+		body = []byte(value)
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "text/plain; charset=utf-8")
+		}
+	case []byte:
+		body = value
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "application/octet-stream")
+		}
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return ctx.WriteError(http.StatusInternalServerError, fmt.Sprintf("failed to encode response: %v", err))
+		}
+		body = encoded
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "application/json")
+		}
+	}
+
+	ctx.Response.WriteHeader(status)
+	if len(body) > 0 && status != http.StatusNoContent && status != http.StatusNotModified {
+		_, err := ctx.Response.Write(body)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package resources
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/database"
+)
+
+// pageParams are the keyset pagination options of a request: $after and
+// $before take a cursor from a previous response, $envelope and $totalCount
+// ask for the {data, nextCursor, prevCursor, totalCount} response envelope
+type pageParams struct {
+	After      string
+	Before     string
+	Envelope   bool
+	TotalCount bool
+}
+
+// parsePageParams reads the pagination options from a query or options map
+func parsePageParams(values map[string]interface{}) (pageParams, error) {
+	var page pageParams
+	var ok bool
+	if value, exists := values["$after"]; exists {
+		if page.After, ok = value.(string); !ok || page.After == "" {
+			return page, fmt.Errorf("$after must be a cursor")
+		}
+	}
+	if value, exists := values["$before"]; exists {
+		if page.Before, ok = value.(string); !ok || page.Before == "" {
+			return page, fmt.Errorf("$before must be a cursor")
+		}
+	}
+	if page.After != "" && page.Before != "" {
+		return page, fmt.Errorf("$after and $before cannot be combined")
+	}
+	page.TotalCount = isTrue(values["$totalCount"])
+	// Cursors are only useful with the cursors of the next response
+	page.Envelope = isTrue(values["$envelope"]) || page.TotalCount || page.After != "" || page.Before != ""
+	return page, nil
+}
+
+func isTrue(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// sortKey is one field of the order a cursor is positioned in
+type sortKey struct {
+	Field     string `json:"f"`
+	Direction int    `json:"d"`
+}
+
+// pageCursor marks a position after (or before) a document. It is handed to
+// clients base64-encoded and treated as opaque by them.
+type pageCursor struct {
+	Keys   []sortKey     `json:"k"`
+	Values []interface{} `json:"v"` // The document's value for each key
+}
+
+// cursorDate marks a time value in a cursor, so stores that return dates as
+// time.Time (e.g. MongoDB) are queried with a time again
+type cursorDate struct {
+	Date time.Time `json:"$date"`
+}
+
+func encodeCursor(keys []sortKey, doc map[string]interface{}) string {
+	cursor := pageCursor{Keys: keys}
+	for _, key := range keys {
+		value := doc[key.Field]
+		if t, ok := value.(time.Time); ok {
+			value = cursorDate{Date: t}
+		}
+		cursor.Values = append(cursor.Values, value)
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(encoded string, keys []sortKey) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || len(cursor.Values) != len(cursor.Keys) {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	if len(cursor.Keys) != len(keys) {
+		return nil, fmt.Errorf("cursor does not match $sort")
+	}
+	for i, key := range keys {
+		if cursor.Keys[i] != key {
+			return nil, fmt.Errorf("cursor does not match $sort")
+		}
+	}
+	for i, value := range cursor.Values {
+		if m, ok := value.(map[string]interface{}); ok && len(m) == 1 {
+			if date, ok := m["$date"].(string); ok {
+				if t, err := time.Parse(time.RFC3339Nano, date); err == nil {
+					cursor.Values[i] = t
+				}
+			}
+		}
+	}
+	return &cursor, nil
+}
+
+// pageKeys makes the sort of opts total by moving id to, or adding it as,
+// the last key, and returns the keys in order. Inclusive field projections
+// are extended so every document carries the values its cursor is built from.
+func pageKeys(opts *database.QueryOptions) []sortKey {
+	sortSpec := map[string]int{"id": 1}
+	for field, direction := range opts.Sort {
+		sortSpec[field] = direction
+	}
+	idDirection := sortSpec["id"]
+	delete(sortSpec, "id")
+	opts.Sort = sortSpec
+	fields := opts.SortFields()
+	opts.Sort["id"] = idDirection
+	opts.SortOrder = append(fields, "id")
+
+	keys := make([]sortKey, 0, len(opts.SortOrder))
+	for _, field := range opts.SortOrder {
+		direction := 1
+		if opts.Sort[field] == -1 {
+			direction = -1
+		}
+		keys = append(keys, sortKey{Field: field, Direction: direction})
+	}
+
+	for _, include := range opts.Fields {
+		if include == 1 {
+			fields := map[string]int{}
+			for field, include := range opts.Fields {
+				fields[field] = include
+			}
+			for _, key := range keys {
+				fields[key.Field] = 1
+			}
+			opts.Fields = fields
+			break
+		}
+	}
+	return keys
+}
+
+// pageResult is a page of raw documents and the cursors around it
+type pageResult struct {
+	Docs       []map[string]interface{}
+	NextCursor string
+	PrevCursor string
+}
+
+// findPage runs query as a keyset-paginated read. Instead of skipping
+// documents, it seeks past the cursor with one AND-only query per sort key
+// (e.g. "a = x AND id > y", then "a > x"), which every store can run on its
+// indexes. Documents must hold a value for every sort field to be paged
+// through correctly.
+func (c *Collection) findPage(ctx *appcontext.Context, query database.QueryBuilder, opts database.QueryOptions, page pageParams) (*pageResult, error) {
+	keys := pageKeys(&opts)
+
+	limit := int64(50)
+	if opts.Limit != nil {
+		limit = *opts.Limit
+	}
+	// One extra document tells whether there is a further page
+	fetch := limit + 1
+	opts.Limit = &fetch
+
+	backward := page.Before != ""
+	if backward {
+		// Read towards the start, then restore the order below
+		reversed := make(map[string]int, len(opts.Sort))
+		for field, direction := range opts.Sort {
+			reversed[field] = -direction
+		}
+		opts.Sort = reversed
+	}
+
+	encoded := page.After
+	if backward {
+		encoded = page.Before
+	}
+
+	var docs []map[string]interface{}
+	if encoded == "" {
+		found, err := c.store.Find(ctx.Context(), query, opts)
+		if err != nil {
+			return nil, err
+		}
+		docs = found
+	} else {
+		cursor, err := decodeCursor(encoded, keys)
+		if err != nil {
+			return nil, &pageError{err}
+		}
+		// A cursor replaces skipping
+		opts.Skip = nil
+
+		// Documents equal on every key but the last come first, then those
+		// equal on one key fewer, and so on
+		for i := len(keys) - 1; i >= 0 && int64(len(docs)) < fetch; i-- {
+			seek := query.Clone()
+			for j := 0; j < i; j++ {
+				seek.Where(keys[j].Field, "$eq", cursor.Values[j])
+			}
+			operator := "$gt"
+			if opts.Sort[keys[i].Field] == -1 {
+				operator = "$lt"
+			}
+			seek.Where(keys[i].Field, operator, cursor.Values[i])
+
+			remaining := fetch - int64(len(docs))
+			seekOpts := opts
+			seekOpts.Limit = &remaining
+			found, err := c.store.Find(ctx.Context(), seek, seekOpts)
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, found...)
+		}
+	}
+
+	hasMore := int64(len(docs)) > limit
+	if hasMore {
+		docs = docs[:limit]
+	}
+	if backward {
+		for i, j := 0, len(docs)-1; i < j; i, j = i+1, j-1 {
+			docs[i], docs[j] = docs[j], docs[i]
+		}
+	}
+
+	result := &pageResult{Docs: docs}
+	if len(docs) == 0 {
+		return result, nil
+	}
+	if hasMore || backward {
+		result.NextCursor = encodeCursor(keys, docs[len(docs)-1])
+	}
+	if (backward && hasMore) || page.After != "" {
+		result.PrevCursor = encodeCursor(keys, docs[0])
+	}
+	return result, nil
+}
+
+// pageError is a findPage error caused by the request rather than the store
+type pageError struct {
+	err error
+}
+
+func (e *pageError) Error() string {
+	return e.err.Error()
+}
+
+// writePage writes docs as a plain array or, if the client asked for it, in
+// the pagination envelope
+func (c *Collection) writePage(ctx *appcontext.Context, docs []map[string]interface{}, query database.QueryBuilder, page pageParams, result *pageResult) error {
+	if !page.Envelope {
+		return ctx.WriteJSON(docs)
+	}
+
+	envelope := map[string]interface{}{
+		"data":       docs,
+		"nextCursor": nil,
+		"prevCursor": nil,
+	}
+	if result != nil && result.NextCursor != "" {
+		envelope["nextCursor"] = result.NextCursor
+	}
+	if result != nil && result.PrevCursor != "" {
+		envelope["prevCursor"] = result.PrevCursor
+	}
+	if page.TotalCount {
+		count, err := c.store.Count(ctx.Context(), query)
+		if err != nil {
+			return ctx.WriteError(500, err.Error())
+		}
+		envelope["totalCount"] = count
+	}
+	return ctx.WriteJSON(envelope)
+}
+
+// findDocs reads the documents of a list request, keyset-paginated if the
+// client opted into the pagination envelope. Cursor errors are pageErrors.
+func (c *Collection) findDocs(ctx *appcontext.Context, query database.QueryBuilder, opts database.QueryOptions, page pageParams) ([]map[string]interface{}, *pageResult, error) {
+	if !page.Envelope {
+		docs, err := c.store.Find(ctx.Context(), query, opts)
+		return docs, nil, err
+	}
+	result, err := c.findPage(ctx, query, opts, page)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.Docs, result, nil
+}
+
+// writeFindError responds to a failed findDocs
+func writeFindError(ctx *appcontext.Context, err error) error {
+	if _, ok := err.(*pageError); ok {
+		return ctx.WriteError(400, err.Error())
+	}
+	return ctx.WriteError(500, err.Error())
+}
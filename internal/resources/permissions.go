@@ -0,0 +1,252 @@
+package resources
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hjanuschka/go-deployd/internal/config"
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+)
+
+// Permission levels that can be assigned to a collection method
+const (
+	PermissionPublic        = config.PermissionPublic
+	PermissionAuthenticated = config.PermissionAuthenticated
+	PermissionRoot          = config.PermissionRoot
+)
+
+// permissionMethods are the keys accepted in CollectionConfig.Permissions
+var permissionMethods = map[string]bool{
+	"get":    true,
+	"post":   true,
+	"put":    true,
+	"delete": true,
+}
+
+// Permission controls who may use an HTTP method on a collection. In
+// config.json it is either a level ("public", "authenticated", "root") or a
+// list of roles, any of which grants access. Realtime rules use the same
+// type.
+type Permission = config.Permission
+
+// ValidatePermissions checks that permissions only configure known methods
+func ValidatePermissions(permissions map[string]Permission) error {
+	for method := range permissions {
+		if !permissionMethods[method] {
+			return fmt.Errorf("unknown permission method %q", method)
+		}
+	}
+	return nil
+}
+
+// permissionMethod maps a request to its key in CollectionConfig.Permissions
+func permissionMethod(ctx *appcontext.Context) string {
+	if ctx.Method == "POST" && ctx.GetID() == "query" {
+		// POST /collection/query is a read
+		return "get"
+	}
+	return strings.ToLower(ctx.Method)
+}
+
+// checkPermission enforces the collection's permissions for the request. It
+// returns the status and message to reject it with, or 0 when it is allowed.
+// Methods without a configured permission are public.
+func (c *Collection) checkPermission(ctx *appcontext.Context) (int, string) {
+	return c.checkMethodPermission(ctx, permissionMethod(ctx))
+}
+
+// checkMethodPermission enforces the permission of one method, e.g. for each
+// operation of a bulk request
+func (c *Collection) checkMethodPermission(ctx *appcontext.Context, method string) (int, string) {
+	permission, exists := c.config.Permissions[method]
+	if !exists || permission.Allows(ctx) {
+		return 0, ""
+	}
+
+	if !ctx.IsAuthenticated {
+		return 401, "Authentication required"
+	}
+	return 403, fmt.Sprintf("Not allowed to %s %s", method, c.name)
+}
+
+// skipEventsAllowed reports whether a request's $skipEvents value bypasses
+// events. Only root (the master key or a root token) may bypass them; for
+// everyone else the value is ignored.
+func skipEventsAllowed(ctx *appcontext.Context, value interface{}) bool {
+	return ctx.IsRoot && isTrue(value)
+}
+
+// Field access levels for Property.Readable and Property.Writable
+const (
+	FieldAccessAlways = "always"
+	FieldAccessNever  = "never"
+	FieldAccessOwner  = "owner"
+)
+
+// DefaultOwnerField is the document field compared against the caller's user
+// ID for owner-only field rules when CollectionConfig.OwnerField is not set
+const DefaultOwnerField = "userId"
+
+// FieldAccess controls who may read or write a single property. In
+// config.json it is either a level ("always", "never", "owner") or a list of
+// roles, any of which grants access.
+type FieldAccess struct {
+	Level string
+	Roles []string
+}
+
+func (a FieldAccess) MarshalJSON() ([]byte, error) {
+	if a.Roles != nil {
+		return json.Marshal(a.Roles)
+	}
+	return json.Marshal(a.Level)
+}
+
+func (a *FieldAccess) UnmarshalJSON(data []byte) error {
+	var level string
+	if err := json.Unmarshal(data, &level); err == nil {
+		switch level {
+		case FieldAccessAlways, FieldAccessNever, FieldAccessOwner:
+			*a = FieldAccess{Level: level}
+			return nil
+		}
+		return fmt.Errorf("unknown field access level %q", level)
+	}
+
+	var roles []string
+	if err := json.Unmarshal(data, &roles); err != nil {
+		return fmt.Errorf("field access must be a level or a list of roles")
+	}
+	*a = FieldAccess{Roles: roles}
+	return nil
+}
+
+// Allows reports whether the caller in ctx may access the field. isOwner
+// tells whether the caller owns the document the field belongs to. A nil
+// FieldAccess always allows access.
+func (a *FieldAccess) Allows(ctx *appcontext.Context, isOwner bool) bool {
+	if a == nil || ctx.IsRoot {
+		return true
+	}
+	if a.Roles != nil {
+		for _, role := range a.Roles {
+			if ctx.HasRole(role) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch a.Level {
+	case FieldAccessNever:
+		return false
+	case FieldAccessOwner:
+		return isOwner
+	default:
+		return true
+	}
+}
+
+// ownerField returns the document field that identifies its owner
+func (c *Collection) ownerField() string {
+	if c.config.OwnerField != "" {
+		return c.config.OwnerField
+	}
+	return DefaultOwnerField
+}
+
+// isOwner reports whether the caller owns doc
+func (c *Collection) isOwner(ctx *appcontext.Context, doc map[string]interface{}) bool {
+	if !ctx.IsAuthenticated || ctx.UserID == "" {
+		return false
+	}
+	owner, ok := doc[c.ownerField()].(string)
+	return ok && owner == ctx.UserID
+}
+
+// isCreator reports whether the caller owns a document it is creating: the
+// owner field is either left for events to fill in or set to the caller
+func (c *Collection) isCreator(ctx *appcontext.Context, data map[string]interface{}) bool {
+	if !ctx.IsAuthenticated || ctx.UserID == "" {
+		return false
+	}
+	if owner, exists := data[c.ownerField()]; exists && owner != nil {
+		return owner == ctx.UserID
+	}
+	return true
+}
+
+// filterReadable removes the fields the caller may not read from doc
+func (c *Collection) filterReadable(ctx *appcontext.Context, doc map[string]interface{}, isOwner bool) {
+	if doc == nil {
+		return
+	}
+	for name, prop := range c.config.Properties {
+		if _, exists := doc[name]; exists && !prop.Readable.Allows(ctx, isOwner) {
+			delete(doc, name)
+		}
+	}
+}
+
+// readDocument returns a copy of doc as the caller reads it with a GET
+// request: the Get event runs on the copy, unless skipEvents is set, and the
+// fields the caller may not read are removed. It returns false if the Get
+// event rejects the document.
+func (c *Collection) readDocument(ctx *appcontext.Context, doc map[string]interface{}, skipEvents bool) (map[string]interface{}, bool) {
+	// Ownership is decided on the stored document, before events can change it
+	isOwner := c.isOwner(ctx, doc)
+	readable := copyDocument(doc)
+	if !skipEvents {
+		if err := c.runGetEvent(ctx, readable); err != nil {
+			return nil, false
+		}
+	}
+	c.filterReadable(ctx, readable, isOwner)
+	return readable, true
+}
+
+// unwritableFields returns the fields in names the caller may not write, sorted
+func (c *Collection) unwritableFields(ctx *appcontext.Context, names []string, isOwner bool) []string {
+	var denied []string
+	for _, name := range names {
+		if prop, exists := c.config.Properties[name]; exists && !prop.Writable.Allows(ctx, isOwner) {
+			denied = append(denied, name)
+		}
+	}
+	sort.Strings(denied)
+	return denied
+}
+
+// checkWritable enforces field write rules on a request body. previous is the
+// stored document for updates and nil for creates; fields sent back unchanged
+// are allowed so clients can PUT a document they read. It returns the status
+// and message to reject the request with, or 0 when it is allowed.
+func (c *Collection) checkWritable(ctx *appcontext.Context, body, previous map[string]interface{}) (int, string) {
+	var names []string
+	for name, value := range body {
+		if previous != nil {
+			if current, exists := previous[name]; exists && c.valuesEqual(current, value) {
+				continue
+			}
+		}
+		names = append(names, name)
+	}
+
+	var isOwner bool
+	if previous != nil {
+		isOwner = c.isOwner(ctx, previous)
+	} else {
+		isOwner = c.isCreator(ctx, body)
+	}
+	return c.writeDenied(c.unwritableFields(ctx, names, isOwner))
+}
+
+// writeDenied builds the rejection for fields the caller may not write
+func (c *Collection) writeDenied(denied []string) (int, string) {
+	if len(denied) == 0 {
+		return 0, ""
+	}
+	return 403, fmt.Sprintf("Not allowed to write %s", strings.Join(denied, ", "))
+}
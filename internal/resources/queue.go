@@ -0,0 +1,706 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/database"
+	"github.com/hjanuschka/go-deployd/internal/events"
+	"github.com/hjanuschka/go-deployd/internal/logging"
+)
+
+// jobStore holds the background jobs of every queue
+const jobStore = "_jobs"
+
+// States of a background job
+const (
+	// JobPending jobs wait for their first attempt or a retry
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	// JobFailed jobs used up their attempts. They are kept until they are
+	// requeued.
+	JobFailed = "failed"
+)
+
+// Limits of background jobs
+const (
+	defaultQueueConcurrency = 1
+	defaultJobAttempts      = 5
+	defaultJobRetryDelay    = 10 * time.Second
+	defaultJobMaxRetryDelay = time.Hour
+	defaultJobsLimit        = 50
+	maxJobsLimit            = 500
+	// queuePollInterval is how often a queue looks for jobs that are due,
+	// including those enqueued on other servers
+	queuePollInterval = time.Second
+	// jobLease is how long a server holds a job it runs without renewing
+	// it. Jobs of a server that dies mid-run are tried again after it.
+	jobLease = time.Minute
+	// Succeeded jobs are kept for jobRetention and pruned every
+	// jobPruneInterval
+	jobRetention     = 7 * 24 * time.Hour
+	jobPruneInterval = time.Hour
+)
+
+// ErrJobNotFailed is returned when requeueing a job that has not failed
+var ErrJobNotFailed = errors.New("only failed jobs can be requeued")
+
+// QueueConfig is the config.json of a queue resource, whose run.js or run.go
+// script works off the jobs enqueued by event scripts
+type QueueConfig struct {
+	Type        string `json:"type"`                  // "queue"
+	Runtime     string `json:"runtime,omitempty"`     // js (default) or go
+	Concurrency int    `json:"concurrency,omitempty"` // Jobs run at once on each server, 1 by default
+	// MaxAttempts is how often a job is tried before it fails, unless it is
+	// enqueued with its own. Defaults to 5.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// RetryDelay is the number of seconds before the first retry, doubled
+	// for each further one up to MaxRetryDelay. They default to 10 and 3600.
+	RetryDelay    float64 `json:"retryDelay,omitempty"`
+	MaxRetryDelay float64 `json:"maxRetryDelay,omitempty"`
+	// Fetch is what the script may request with fetch
+	Fetch *events.FetchConfig `json:"fetch,omitempty"`
+	// Limits bound the time and memory of JavaScript runs
+	Limits *events.ScriptLimits `json:"limits,omitempty"`
+}
+
+// Job is a unit of background work on a queue
+type Job struct {
+	ID          string                 `json:"id"`
+	Queue       string                 `json:"queue"`
+	Payload     map[string]interface{} `json:"payload"`
+	Status      string                 `json:"status"`
+	Attempts    int                    `json:"attempts"`
+	MaxAttempts int                    `json:"maxAttempts"`
+	Error       string                 `json:"error,omitempty"` // Of the last failed attempt
+	RunAt       *time.Time             `json:"runAt,omitempty"` // When a pending job is tried next
+	EnqueuedAt  time.Time              `json:"enqueuedAt"`
+	StartedAt   *time.Time             `json:"startedAt,omitempty"` // Of the last attempt
+	FinishedAt  *time.Time             `json:"finishedAt,omitempty"`
+	Node        string                 `json:"node,omitempty"` // Server of the last attempt
+}
+
+// jobDocument is how a job is stored, with times as Unix milliseconds. While
+// a job runs, runAt holds the end of its lease.
+func jobDocument(job *Job) map[string]interface{} {
+	doc := map[string]interface{}{
+		"id":          job.ID,
+		"queue":       job.Queue,
+		"payload":     job.Payload,
+		"status":      job.Status,
+		"attempts":    job.Attempts,
+		"maxAttempts": job.MaxAttempts,
+		"error":       job.Error,
+		"runAt":       int64(0),
+		"enqueuedAt":  job.EnqueuedAt.UnixMilli(),
+		"startedAt":   int64(0),
+		"finishedAt":  int64(0),
+		"node":        job.Node,
+	}
+	if job.RunAt != nil {
+		doc["runAt"] = job.RunAt.UnixMilli()
+	}
+	if job.StartedAt != nil {
+		doc["startedAt"] = job.StartedAt.UnixMilli()
+	}
+	if job.FinishedAt != nil {
+		doc["finishedAt"] = job.FinishedAt.UnixMilli()
+	}
+	return doc
+}
+
+func jobFromDocument(doc map[string]interface{}) *Job {
+	job := &Job{}
+	job.ID, _ = doc["id"].(string)
+	job.Queue, _ = doc["queue"].(string)
+	job.Payload, _ = doc["payload"].(map[string]interface{})
+	job.Status, _ = doc["status"].(string)
+	job.Error, _ = doc["error"].(string)
+	job.Node, _ = doc["node"].(string)
+	attempts, _ := toInt64(doc["attempts"])
+	maxAttempts, _ := toInt64(doc["maxAttempts"])
+	job.Attempts, job.MaxAttempts = int(attempts), int(maxAttempts)
+	if enqueued, ok := toInt64(doc["enqueuedAt"]); ok {
+		job.EnqueuedAt = time.UnixMilli(enqueued).UTC()
+	}
+	if runAt, ok := toInt64(doc["runAt"]); ok && runAt > 0 {
+		t := time.UnixMilli(runAt).UTC()
+		job.RunAt = &t
+	}
+	if started, ok := toInt64(doc["startedAt"]); ok && started > 0 {
+		t := time.UnixMilli(started).UTC()
+		job.StartedAt = &t
+	}
+	if finished, ok := toInt64(doc["finishedAt"]); ok && finished > 0 {
+		t := time.UnixMilli(finished).UTC()
+		job.FinishedAt = &t
+	}
+	return job
+}
+
+// public hides the lease of running jobs, which is not when they run
+func (job *Job) public() *Job {
+	if job.Status != JobPending {
+		job.RunAt = nil
+	}
+	return job
+}
+
+// Queue is a resource that runs background jobs with its script. Jobs are
+// stored in the database, so servers sharing it share the queue; each takes
+// due jobs for a lease, renewed while it runs them. Jobs run at least once:
+// a job whose server dies mid-run is tried again.
+type Queue struct {
+	*BaseResource
+	config        *QueueConfig
+	scriptManager *events.UniversalScriptManager
+	store         database.StoreInterface
+	node          string
+
+	wakeup    chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+
+	mu       sync.Mutex
+	inFlight map[string]bool // Jobs being run by this server
+}
+
+// QueueInfo describes a queue and the number of its jobs in each state
+type QueueInfo struct {
+	Name        string           `json:"name"`
+	Runtime     string           `json:"runtime"`
+	Concurrency int              `json:"concurrency"`
+	MaxAttempts int              `json:"maxAttempts"`
+	Jobs        map[string]int64 `json:"jobs"`
+}
+
+// ValidateQueueConfig checks a queue config
+func ValidateQueueConfig(config *QueueConfig) error {
+	switch config.Runtime {
+	case "", string(events.ScriptTypeJS), string(events.ScriptTypeGo):
+	default:
+		return fmt.Errorf("runtime must be js or go, not %q", config.Runtime)
+	}
+	if config.Concurrency < 0 || config.MaxAttempts < 0 || config.RetryDelay < 0 || config.MaxRetryDelay < 0 {
+		return fmt.Errorf("concurrency, attempts and delays must not be negative")
+	}
+	if err := config.Fetch.Validate(); err != nil {
+		return fmt.Errorf("invalid fetch config: %w", err)
+	}
+	if err := config.Limits.Validate(); err != nil {
+		return fmt.Errorf("invalid limits: %w", err)
+	}
+	return nil
+}
+
+// LoadQueue loads the queue configured in configPath
+func LoadQueue(name, configPath string, db database.DatabaseInterface, emitter events.RealtimeEmitter) (*Queue, error) {
+	data, err := os.ReadFile(filepath.Join(configPath, "config.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var config QueueConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if err := ValidateQueueConfig(&config); err != nil {
+		return nil, err
+	}
+	if config.Runtime == "" {
+		config.Runtime = string(events.ScriptTypeJS)
+	}
+	if config.Concurrency == 0 {
+		config.Concurrency = defaultQueueConcurrency
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = defaultJobAttempts
+	}
+
+	scriptManager := events.NewUniversalScriptManager()
+	if !scriptManager.LoadScript(configPath, events.EventRun, "run", config.Runtime) {
+		return nil, fmt.Errorf("no script to run jobs: add run.%s", config.Runtime)
+	}
+	scriptManager.SetFetchConfig(config.Fetch)
+	scriptManager.SetScriptLimits(config.Limits)
+	if emitter != nil {
+		scriptManager.SetRealtimeEmitter(emitter)
+	}
+
+	store := db.CreateStore(jobStore)
+	host, _ := os.Hostname()
+	return &Queue{
+		BaseResource:  NewBaseResource(name),
+		config:        &config,
+		scriptManager: scriptManager,
+		store:         store,
+		node:          host + "-" + store.CreateUniqueIdentifier(),
+		wakeup:        make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		inFlight:      make(map[string]bool),
+	}, nil
+}
+
+// Handle refuses requests; jobs are enqueued by event scripts
+func (q *Queue) Handle(ctx *appcontext.Context) error {
+	return ctx.WriteError(http.StatusNotFound, "Not found")
+}
+
+// GetConfig returns the queue's config
+func (q *Queue) GetConfig() *QueueConfig {
+	return q.config
+}
+
+// SetDpdProvider lets the queue's script call collections through dpd
+func (q *Queue) SetDpdProvider(provider events.DpdProvider) {
+	q.scriptManager.SetDpdProvider(provider)
+}
+
+// SetEnqueuer lets the queue's script enqueue further jobs
+func (q *Queue) SetEnqueuer(enqueuer events.Enqueuer) {
+	q.scriptManager.SetEnqueuer(enqueuer)
+}
+
+// Start runs the queue's jobs until Stop
+func (q *Queue) Start() {
+	q.startOnce.Do(func() {
+		q.wg.Add(1)
+		go q.run()
+	})
+}
+
+// Stop stops taking jobs and waits for those running. Jobs left in the queue
+// run when a server runs the queue again.
+func (q *Queue) Stop() {
+	q.stopOnce.Do(func() {
+		close(q.done)
+	})
+	q.wg.Wait()
+}
+
+// wake makes the queue look for due jobs now
+func (q *Queue) wake() {
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue adds a job to the queue, in the transaction ctx carries if any. It
+// runs once the transaction has committed and the delay of opts has passed.
+func (q *Queue) Enqueue(ctx context.Context, payload map[string]interface{}, opts events.EnqueueOptions) (*Job, error) {
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = q.config.MaxAttempts
+	}
+	now := time.Now().UTC()
+	runAt := now.Add(opts.Delay)
+	job := &Job{
+		ID:          q.store.CreateUniqueIdentifier(),
+		Queue:       q.name,
+		Payload:     payload,
+		Status:      JobPending,
+		MaxAttempts: maxAttempts,
+		RunAt:       &runAt,
+		EnqueuedAt:  now,
+	}
+	if _, err := q.store.Insert(ctx, jobDocument(job)); err != nil {
+		return nil, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	logging.Debug("Job enqueued", "queue", map[string]interface{}{
+		"queue":  q.name,
+		"job_id": job.ID,
+		"delay":  opts.Delay.String(),
+	})
+	database.AfterCommit(ctx, func() {
+		if opts.Delay > 0 {
+			time.AfterFunc(opts.Delay, q.wake)
+		} else {
+			q.wake()
+		}
+	})
+	return job, nil
+}
+
+func (q *Queue) run() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+	workers := make(chan struct{}, q.config.Concurrency)
+	var lastPrune time.Time
+
+	for {
+		q.runDue(workers)
+		if time.Since(lastPrune) > jobPruneInterval {
+			q.prune()
+			lastPrune = time.Now()
+		}
+		select {
+		case <-q.wakeup:
+		case <-ticker.C:
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// runDue takes as many due jobs as there are free workers and runs them.
+// Running jobs whose lease expired are due too: their server stopped.
+func (q *Queue) runDue(workers chan struct{}) {
+	free := int64(cap(workers) - len(workers))
+	if free == 0 {
+		return
+	}
+	ctx := context.Background()
+	now := time.Now()
+	query := database.NewQueryBuilder().
+		Where("queue", "$eq", q.name).
+		WhereIn("status", []interface{}{JobPending, JobRunning}).
+		Where("runAt", "$lte", now.UnixMilli())
+	docs, err := q.store.Find(ctx, query, database.QueryOptions{Sort: map[string]int{"runAt": 1}, Limit: &free})
+	if err != nil {
+		logging.Error("Failed to read job queue", "queue", map[string]interface{}{
+			"queue": q.name,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	for _, doc := range docs {
+		job := jobFromDocument(doc)
+		if !q.claim(ctx, job, now) {
+			continue
+		}
+		workers <- struct{}{}
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			defer q.wake()
+			defer func() { <-workers }()
+			defer q.release(job.ID)
+			q.execute(job)
+		}()
+	}
+}
+
+// claim takes a job for jobLease and counts the attempt, unless this server
+// is already running it or another server took it first
+func (q *Queue) claim(ctx context.Context, job *Job, now time.Time) bool {
+	q.mu.Lock()
+	if q.inFlight[job.ID] {
+		q.mu.Unlock()
+		return false
+	}
+	q.inFlight[job.ID] = true
+	q.mu.Unlock()
+
+	query := database.NewQueryBuilder().
+		Where("id", "$eq", job.ID).
+		Where("status", "$eq", job.Status).
+		Where("runAt", "$lte", now.UnixMilli())
+	started := now.UTC()
+	lease := now.Add(jobLease).UTC()
+	job.Status = JobRunning
+	job.Attempts++
+	job.StartedAt = &started
+	job.RunAt = &lease
+	job.Node = q.node
+	doc := jobDocument(job)
+	update := database.NewUpdateBuilder().
+		Set("status", doc["status"]).
+		Set("attempts", doc["attempts"]).
+		Set("startedAt", doc["startedAt"]).
+		Set("runAt", doc["runAt"]).
+		Set("node", doc["node"])
+	result, err := q.store.UpdateOne(ctx, query, update)
+	if err != nil || result.ModifiedCount() == 0 {
+		q.release(job.ID)
+		return false
+	}
+	return true
+}
+
+func (q *Queue) release(id string) {
+	q.mu.Lock()
+	delete(q.inFlight, id)
+	q.mu.Unlock()
+}
+
+// renew extends the lease of a job while this server runs it
+func (q *Queue) renew(ctx context.Context, id string) bool {
+	query := database.NewQueryBuilder().
+		Where("id", "$eq", id).
+		Where("status", "$eq", JobRunning).
+		Where("node", "$eq", q.node)
+	result, err := q.store.UpdateOne(ctx, query, database.NewUpdateBuilder().Set("runAt", time.Now().Add(jobLease).UnixMilli()))
+	return err == nil && result.ModifiedCount() > 0
+}
+
+// execute runs an attempt of a claimed job and records its result. Failed
+// jobs are retried after a backoff until they use up their attempts.
+func (q *Queue) execute(job *Job) {
+	ctx := context.Background()
+	var err error
+	if job.Attempts > job.MaxAttempts {
+		// The server of the last attempt stopped while running it
+		err = fmt.Errorf("server stopped during attempt %d", job.MaxAttempts)
+		job.Attempts = job.MaxAttempts
+	} else {
+		renewing := make(chan struct{})
+		renewed := make(chan struct{})
+		go func() {
+			defer close(renewed)
+			ticker := time.NewTicker(jobLease / 3)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if !q.renew(ctx, job.ID) {
+						logging.Warn("Job lost its lease while running", "queue", map[string]interface{}{
+							"queue":  q.name,
+							"job_id": job.ID,
+						})
+					}
+				case <-renewing:
+					return
+				}
+			}
+		}()
+		err = q.runScript(job)
+		close(renewing)
+		<-renewed
+	}
+
+	now := time.Now().UTC()
+	logFields := map[string]interface{}{
+		"queue":   q.name,
+		"job_id":  job.ID,
+		"attempt": job.Attempts,
+	}
+	if job.StartedAt != nil {
+		logFields["durationMs"] = now.Sub(*job.StartedAt).Milliseconds()
+	}
+	var retryIn time.Duration
+	switch {
+	case err == nil:
+		job.Status = JobSucceeded
+		job.Error = ""
+		job.RunAt = nil
+		job.FinishedAt = &now
+		logging.Debug("Job succeeded", "queue", logFields)
+	case job.Attempts >= job.MaxAttempts:
+		job.Status = JobFailed
+		job.Error = err.Error()
+		job.RunAt = nil
+		job.FinishedAt = &now
+		logFields["error"] = job.Error
+		logging.Error("Job failed permanently", "queue", logFields)
+	default:
+		retryIn = q.retryDelay(job.Attempts)
+		next := now.Add(retryIn)
+		job.Status = JobPending
+		job.Error = err.Error()
+		job.RunAt = &next
+		logFields["error"] = job.Error
+		logFields["retry_in"] = retryIn.String()
+		logging.Warn("Job failed, retrying", "queue", logFields)
+	}
+
+	doc := jobDocument(job)
+	update := database.NewUpdateBuilder().
+		Set("status", doc["status"]).
+		Set("attempts", doc["attempts"]).
+		Set("error", doc["error"]).
+		Set("runAt", doc["runAt"]).
+		Set("finishedAt", doc["finishedAt"])
+	if _, err := q.store.UpdateOne(ctx, database.NewQueryBuilder().Where("id", "$eq", job.ID), update); err != nil {
+		logging.Error("Failed to record job", "queue", map[string]interface{}{
+			"queue":  q.name,
+			"job_id": job.ID,
+			"error":  err.Error(),
+		})
+		return
+	}
+	if retryIn > 0 {
+		time.AfterFunc(retryIn, q.wake)
+	}
+}
+
+// retryDelay returns how long to wait after the given number of failed
+// attempts
+func (q *Queue) retryDelay(failures int) time.Duration {
+	delay, maxDelay := defaultJobRetryDelay, defaultJobMaxRetryDelay
+	if q.config.RetryDelay > 0 {
+		delay = time.Duration(q.config.RetryDelay * float64(time.Second))
+	}
+	if q.config.MaxRetryDelay > 0 {
+		maxDelay = time.Duration(q.config.MaxRetryDelay * float64(time.Second))
+	}
+	return exponentialBackoff(delay, maxDelay, failures)
+}
+
+// runScript runs the queue's script for a job as root. Its context.data
+// holds the job ID, queue, attempt and payload.
+func (q *Queue) runScript(job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("script panicked: %v", r)
+		}
+	}()
+
+	req := httptest.NewRequest(http.MethodPost, q.GetPath(), nil)
+	ctx := appcontext.New(req, httptest.NewRecorder(), q, &appcontext.AuthData{
+		IsRoot:          true,
+		IsAuthenticated: true,
+	}, false)
+	data := map[string]interface{}{
+		"jobId":       job.ID,
+		"queue":       q.name,
+		"attempt":     job.Attempts,
+		"maxAttempts": job.MaxAttempts,
+		"payload":     job.Payload,
+	}
+	return q.scriptManager.RunEvent(events.EventRun, ctx, data)
+}
+
+// prune removes the succeeded jobs older than jobRetention. Failed jobs are
+// kept.
+func (q *Queue) prune() {
+	query := database.NewQueryBuilder().
+		Where("queue", "$eq", q.name).
+		Where("status", "$eq", JobSucceeded).
+		Where("finishedAt", "$lt", time.Now().Add(-jobRetention).UnixMilli())
+	if _, err := q.store.Remove(context.Background(), query); err != nil {
+		logging.Error("Failed to prune jobs", "queue", map[string]interface{}{
+			"queue": q.name,
+			"error": err.Error(),
+		})
+	}
+}
+
+// Jobs returns the queue's most recently enqueued jobs, newest first,
+// optionally only those in a state
+func (q *Queue) Jobs(ctx context.Context, status string, limit int64) ([]*Job, error) {
+	if limit <= 0 {
+		limit = defaultJobsLimit
+	}
+	if limit > maxJobsLimit {
+		limit = maxJobsLimit
+	}
+	query := database.NewQueryBuilder().Where("queue", "$eq", q.name)
+	if status != "" {
+		query = query.Where("status", "$eq", status)
+	}
+	docs, err := q.store.Find(ctx, query, database.QueryOptions{Sort: map[string]int{"enqueuedAt": -1}, Limit: &limit})
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*Job, len(docs))
+	for i, doc := range docs {
+		jobs[i] = jobFromDocument(doc).public()
+	}
+	return jobs, nil
+}
+
+// Job returns one of the queue's jobs, or nil if it does not exist
+func (q *Queue) Job(ctx context.Context, id string) (*Job, error) {
+	doc, err := q.store.FindOne(ctx, database.NewQueryBuilder().
+		Where("id", "$eq", id).
+		Where("queue", "$eq", q.name))
+	if err != nil || doc == nil {
+		return nil, err
+	}
+	return jobFromDocument(doc).public(), nil
+}
+
+// Requeue runs a failed job again, with its attempts reset. It returns nil
+// if the job does not exist and ErrJobNotFailed if it has not failed.
+func (q *Queue) Requeue(ctx context.Context, id string) (*Job, error) {
+	job, err := q.Job(ctx, id)
+	if err != nil || job == nil {
+		return nil, err
+	}
+	if job.Status != JobFailed {
+		return nil, ErrJobNotFailed
+	}
+
+	now := time.Now().UTC()
+	query := database.NewQueryBuilder().
+		Where("id", "$eq", id).
+		Where("status", "$eq", JobFailed)
+	result, err := q.store.UpdateOne(ctx, query, requeueUpdate(now))
+	if err != nil {
+		return nil, fmt.Errorf("failed to requeue job: %w", err)
+	}
+	if result.ModifiedCount() == 0 {
+		// Requeued meanwhile
+		return nil, ErrJobNotFailed
+	}
+	q.wake()
+	job.Status = JobPending
+	job.Attempts = 0
+	job.Error = ""
+	job.RunAt = &now
+	job.FinishedAt = nil
+	return job, nil
+}
+
+// RequeueFailed runs all failed jobs of the queue again and returns how many
+// there were
+func (q *Queue) RequeueFailed(ctx context.Context) (int64, error) {
+	query := database.NewQueryBuilder().
+		Where("queue", "$eq", q.name).
+		Where("status", "$eq", JobFailed)
+	result, err := q.store.Update(ctx, query, requeueUpdate(time.Now().UTC()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue jobs: %w", err)
+	}
+	if result.ModifiedCount() > 0 {
+		q.wake()
+	}
+	return result.ModifiedCount(), nil
+}
+
+func requeueUpdate(now time.Time) database.UpdateBuilder {
+	return database.NewUpdateBuilder().
+		Set("status", JobPending).
+		Set("attempts", 0).
+		Set("error", "").
+		Set("runAt", now.UnixMilli()).
+		Set("finishedAt", int64(0))
+}
+
+// Info returns the queue's config and how many jobs it has in each state
+func (q *Queue) Info(ctx context.Context) (*QueueInfo, error) {
+	info := &QueueInfo{
+		Name:        q.name,
+		Runtime:     q.config.Runtime,
+		Concurrency: q.config.Concurrency,
+		MaxAttempts: q.config.MaxAttempts,
+		Jobs:        make(map[string]int64),
+	}
+	for _, status := range []string{JobPending, JobRunning, JobSucceeded, JobFailed} {
+		count, err := q.store.Count(ctx, database.NewQueryBuilder().
+			Where("queue", "$eq", q.name).
+			Where("status", "$eq", status))
+		if err != nil {
+			return nil, err
+		}
+		info.Jobs[status] = count
+	}
+	return info, nil
+}
@@ -0,0 +1,321 @@
+package resources
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/database"
+)
+
+// Limits on the related documents embedded per parent by a has-many include
+const (
+	defaultIncludeLimit = 10
+	maxIncludeLimit     = 100
+)
+
+// Relation declares a has-many relation: the documents of Collection whose
+// ForeignKey property holds the id of a document in this collection
+type Relation struct {
+	Collection string         `json:"collection"`
+	ForeignKey string         `json:"foreignKey"`
+	Limit      int            `json:"limit,omitempty"` // Related documents embedded per parent by default
+	Sort       map[string]int `json:"sort,omitempty"`
+}
+
+// CollectionResolver looks up another loaded collection by name, or returns nil
+type CollectionResolver func(name string) *Collection
+
+// SetCollectionResolver sets how references to other collections are resolved
+func (c *Collection) SetCollectionResolver(resolver CollectionResolver) {
+	c.collections = resolver
+}
+
+// ValidateRelations checks that every has-many relation names its target
+func ValidateRelations(relations map[string]Relation) error {
+	for name, relation := range relations {
+		if relation.Collection == "" || relation.ForeignKey == "" {
+			return fmt.Errorf("%s: a relation needs a collection and a foreignKey", name)
+		}
+		if relation.Limit < 0 || relation.Limit > maxIncludeLimit {
+			return fmt.Errorf("%s: limit must be between 0 and %d", name, maxIncludeLimit)
+		}
+	}
+	return nil
+}
+
+// includeSpec is one entry of the $include query option
+type includeSpec struct {
+	Name  string
+	Limit int            // Only used by has-many includes; 0 means the relation's default
+	Sort  map[string]int // Only used by has-many includes
+}
+
+// parseIncludes reads the $include option, which is a list of names (an
+// array, a JSON array string or a comma-separated string) or an object
+// mapping names to true or to {"$limit": n, "$sort": {...}}
+func parseIncludes(value interface{}) ([]includeSpec, error) {
+	if str, ok := value.(string); ok && strings.HasPrefix(str, "[") {
+		var list []interface{}
+		if err := json.Unmarshal([]byte(str), &list); err != nil {
+			return nil, fmt.Errorf("invalid $include: %w", err)
+		}
+		value = list
+	}
+
+	var includes []includeSpec
+	switch v := value.(type) {
+	case nil:
+	case string:
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				includes = append(includes, includeSpec{Name: name})
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid $include: names must be strings")
+			}
+			includes = append(includes, includeSpec{Name: name})
+		}
+	case map[string]interface{}:
+		for name, options := range v {
+			include := includeSpec{Name: name}
+			switch o := options.(type) {
+			case bool:
+				if !o {
+					continue
+				}
+			case map[string]interface{}:
+				if limit, exists := o["$limit"]; exists {
+					n, err := strconv.Atoi(fmt.Sprint(limit))
+					if err != nil || n < 1 || n > maxIncludeLimit {
+						return nil, fmt.Errorf("invalid $include: %s.$limit must be between 1 and %d", name, maxIncludeLimit)
+					}
+					include.Limit = n
+				}
+				if sortSpec, ok := o["$sort"].(map[string]interface{}); ok {
+					include.Sort = make(map[string]int)
+					for field, direction := range sortSpec {
+						if d, ok := toNumber(direction); ok {
+							include.Sort[field] = int(d)
+						}
+					}
+				}
+			default:
+				return nil, fmt.Errorf("invalid $include: %s must be true or an options object", name)
+			}
+			includes = append(includes, include)
+		}
+		// Map order is random; keep the output stable
+		sort.Slice(includes, func(i, j int) bool { return includes[i].Name < includes[j].Name })
+	default:
+		return nil, fmt.Errorf("invalid $include")
+	}
+	return includes, nil
+}
+
+// embedName is the field a reference property's document is embedded as:
+// "as" if set, otherwise the property name without an "Id" suffix, so
+// ownerId is populated into owner. Properties not ending in "Id" are
+// replaced by the document they reference.
+func embedName(field string, prop Property) string {
+	if prop.As != "" {
+		return prop.As
+	}
+	if trimmed := strings.TrimSuffix(field, "Id"); trimmed != "" && trimmed != field {
+		return trimmed
+	}
+	return field
+}
+
+// reference returns the reference property an include name refers to
+func (c *Collection) reference(name string) (string, Property, bool) {
+	for field, prop := range c.config.Properties {
+		if prop.Type == "reference" && (field == name || embedName(field, prop) == name) {
+			return field, prop, true
+		}
+	}
+	return "", Property{}, false
+}
+
+// queryIncludes parses the $include option of a request and rejects names
+// that are neither a reference property nor a relation
+func (c *Collection) queryIncludes(value interface{}) ([]includeSpec, error) {
+	includes, err := parseIncludes(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, include := range includes {
+		if _, _, ok := c.reference(include.Name); ok {
+			continue
+		}
+		if _, ok := c.config.Relations[include.Name]; ok {
+			continue
+		}
+		return nil, fmt.Errorf("unknown include %q", include.Name)
+	}
+	return includes, nil
+}
+
+// populateIncludes embeds the documents requested by includes into docs.
+// Each include loads all its documents with one query against the target
+// collection's store, so the cost does not grow with the number of docs.
+func (c *Collection) populateIncludes(ctx *appcontext.Context, docs []map[string]interface{}, includes []includeSpec, skipEvents bool) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	for _, include := range includes {
+		if field, prop, ok := c.reference(include.Name); ok {
+			if err := c.populateReference(ctx, docs, field, prop, skipEvents); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.populateRelation(ctx, docs, include, skipEvents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// populateReference embeds the document each doc's reference field points at
+func (c *Collection) populateReference(ctx *appcontext.Context, docs []map[string]interface{}, field string, prop Property, skipEvents bool) error {
+	target, err := c.includeTarget(ctx, prop.Collection)
+	if err != nil || target == nil {
+		return err
+	}
+
+	ids := distinctValues(docs, field)
+	if len(ids) == 0 {
+		return nil
+	}
+	related, err := target.findIncluded(ctx, database.NewQueryBuilder().WhereIn("id", ids), database.QueryOptions{}, skipEvents)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]map[string]interface{}, len(related))
+	for _, doc := range related {
+		byID[fmt.Sprint(doc["id"])] = doc
+	}
+
+	embed := embedName(field, prop)
+	for _, doc := range docs {
+		id, exists := doc[field]
+		if !exists || id == nil {
+			continue
+		}
+		if found, ok := byID[fmt.Sprint(id)]; ok {
+			doc[embed] = found
+		} else if embed != field {
+			// Dangling or hidden reference
+			doc[embed] = nil
+		}
+	}
+	return nil
+}
+
+// populateRelation embeds the documents that reference each doc through a
+// has-many relation, up to the include's limit per doc
+func (c *Collection) populateRelation(ctx *appcontext.Context, docs []map[string]interface{}, include includeSpec, skipEvents bool) error {
+	relation := c.config.Relations[include.Name]
+	target, err := c.includeTarget(ctx, relation.Collection)
+	if err != nil || target == nil {
+		return err
+	}
+
+	limit := include.Limit
+	if limit == 0 {
+		limit = relation.Limit
+	}
+	if limit == 0 {
+		limit = defaultIncludeLimit
+	}
+	sortSpec := include.Sort
+	if sortSpec == nil {
+		sortSpec = relation.Sort
+	}
+
+	// The per-parent limit cannot be expressed in one portable query, so
+	// all related documents are loaded in order and cut off per parent
+	ids := distinctValues(docs, "id")
+	if len(ids) == 0 {
+		return nil
+	}
+	related, err := target.findIncluded(ctx, database.NewQueryBuilder().WhereIn(relation.ForeignKey, ids),
+		database.QueryOptions{Sort: sortSpec}, skipEvents)
+	if err != nil {
+		return err
+	}
+	byParent := make(map[string][]map[string]interface{})
+	for _, doc := range related {
+		parent := fmt.Sprint(doc[relation.ForeignKey])
+		if len(byParent[parent]) < limit {
+			byParent[parent] = append(byParent[parent], doc)
+		}
+	}
+
+	for _, doc := range docs {
+		children := byParent[fmt.Sprint(doc["id"])]
+		if children == nil {
+			children = []map[string]interface{}{}
+		}
+		doc[include.Name] = children
+	}
+	return nil
+}
+
+// includeTarget returns the collection an include loads from. It returns nil
+// without an error when the caller may not read that collection, which
+// leaves the include unpopulated.
+func (c *Collection) includeTarget(ctx *appcontext.Context, name string) (*Collection, error) {
+	var target *Collection
+	if c.collections != nil {
+		target = c.collections(name)
+	}
+	if target == nil || target.store == nil {
+		return nil, fmt.Errorf("included collection %s not found", name)
+	}
+	if permission, exists := target.config.Permissions["get"]; exists && !permission.Allows(ctx) {
+		return nil, nil
+	}
+	return target, nil
+}
+
+// findIncluded loads documents for an include, applying this collection's
+// Get event and readable rules exactly as a GET request would
+func (c *Collection) findIncluded(ctx *appcontext.Context, query database.QueryBuilder, opts database.QueryOptions, skipEvents bool) ([]map[string]interface{}, error) {
+	docs, err := c.store.Find(ctx.Context(), query, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load included %s: %w", c.name, err)
+	}
+
+	result := make([]map[string]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		// Documents hidden by the Get event are not included
+		if readable, ok := c.readDocument(ctx, doc, skipEvents); ok {
+			result = append(result, readable)
+		}
+	}
+	return result, nil
+}
+
+// distinctValues collects the distinct non-nil values of field across docs
+func distinctValues(docs []map[string]interface{}, field string) []interface{} {
+	seen := make(map[string]bool)
+	var values []interface{}
+	for _, doc := range docs {
+		value, exists := doc[field]
+		if !exists || value == nil || seen[fmt.Sprint(value)] {
+			continue
+		}
+		seen[fmt.Sprint(value)] = true
+		values = append(values, value)
+	}
+	return values
+}
@@ -1,9 +1,49 @@
 package resources
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
 	"github.com/hjanuschka/go-deployd/internal/context"
 )
 
+// Types of resources, as the "type" of their config.json declares them
+const (
+	ResourceTypeCollection = "collection"
+	ResourceTypeCron       = "cron"
+	ResourceTypeQueue      = "queue"
+	ResourceTypeEndpoint   = "endpoint"
+)
+
+// ReadResourceType returns the type the config.json in configPath declares.
+// Configs without a type, or with one of deployd's such as "Collection",
+// are collections.
+func ReadResourceType(configPath string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(configPath, "config.json"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read config file: %w", err)
+	}
+	var config struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", fmt.Errorf("failed to parse config: %w", err)
+	}
+	if strings.EqualFold(config.Type, ResourceTypeCron) {
+		return ResourceTypeCron, nil
+	}
+	if strings.EqualFold(config.Type, ResourceTypeQueue) {
+		return ResourceTypeQueue, nil
+	}
+	if strings.EqualFold(config.Type, ResourceTypeEndpoint) {
+		return ResourceTypeEndpoint, nil
+	}
+	return ResourceTypeCollection, nil
+}
+
 // Resource represents a deployable resource that can handle HTTP requests
 type Resource interface {
 	GetName() string
@@ -13,12 +53,30 @@ type Resource interface {
 
 // Property defines a field in a collection schema
 type Property struct {
-	Type     string      `json:"type"`
-	Required bool        `json:"required,omitempty"`
-	Default  interface{} `json:"default,omitempty"`
-	Order    int         `json:"order,omitempty"`
-	Unique   bool        `json:"unique,omitempty"`
-	System   bool        `json:"system,omitempty"` // Indicates if this is a system-managed field
+	Type     string       `json:"type"`
+	Required bool         `json:"required,omitempty"`
+	Default  interface{}  `json:"default,omitempty"`
+	Order    int          `json:"order,omitempty"`
+	Unique   bool         `json:"unique,omitempty"`
+	System   bool         `json:"system,omitempty"`   // Indicates if this is a system-managed field
+	Readable *FieldAccess `json:"readable,omitempty"` // Who may read the field; nil means everyone
+	Writable *FieldAccess `json:"writable,omitempty"` // Who may write the field; nil means everyone
+
+	// Reference properties hold the id of a document in Collection, which
+	// $include embeds under As (by default the name without its "Id" suffix)
+	Collection string `json:"collection,omitempty"`
+	As         string `json:"as,omitempty"`
+
+	// Validation rules; MinLength/MaxLength apply to strings and arrays
+	MinLength  *int                `json:"minLength,omitempty"`
+	MaxLength  *int                `json:"maxLength,omitempty"`
+	Min        *float64            `json:"min,omitempty"`
+	Max        *float64            `json:"max,omitempty"`
+	Pattern    string              `json:"pattern,omitempty"`
+	Enum       []interface{}       `json:"enum,omitempty"`
+	Format     string              `json:"format,omitempty"`     // email, url, uuid or date-time
+	Items      *Property           `json:"items,omitempty"`      // Schema for array items
+	Properties map[string]Property `json:"properties,omitempty"` // Schema for object fields
 }
 
 // BaseResource provides common functionality for all resources
@@ -0,0 +1,75 @@
+package resources
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/hjanuschka/go-deployd/internal/config"
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/events"
+	"github.com/hjanuschka/go-deployd/internal/realtime"
+)
+
+// subscriptionRegistry is implemented by realtime emitters that deliver query
+// subscriptions on collection changes
+type subscriptionRegistry interface {
+	RegisterCollection(name string, reader realtime.CollectionReader)
+}
+
+// registerSubscriptions lets realtime clients subscribe to the collection's
+// changes through emitter
+func (c *Collection) registerSubscriptions(emitter events.RealtimeEmitter) {
+	if registry, ok := emitter.(subscriptionRegistry); ok && !c.config.NoStore {
+		registry.RegisterCollection(c.name, c)
+	}
+}
+
+// ruleRegistry is implemented by realtime emitters that enforce the realtime
+// rules of collection configs
+type ruleRegistry interface {
+	SetCollectionRules(collection string, rules *config.RealtimeRules)
+}
+
+// registerRealtimeRules hands the realtime rules of the collection's config
+// to emitter, replacing those of an earlier load
+func (c *Collection) registerRealtimeRules(emitter events.RealtimeEmitter) {
+	if registry, ok := emitter.(ruleRegistry); ok {
+		registry.SetCollectionRules(c.name, c.config.Realtime)
+	}
+}
+
+// subscriberContext returns the context a realtime subscriber reads the
+// collection in, like a GET request made by user
+func (c *Collection) subscriberContext(user *appcontext.AuthData) *appcontext.Context {
+	req := httptest.NewRequest(http.MethodGet, c.GetPath(), nil)
+	return appcontext.New(req, httptest.NewRecorder(), c, user, false)
+}
+
+// AuthorizeSubscription checks that user may read the collection, with its
+// get permission and BeforeRequest event, before it subscribes to changes
+func (c *Collection) AuthorizeSubscription(user *appcontext.AuthData) error {
+	ctx := c.subscriberContext(user)
+	if status, message := c.checkMethodPermission(ctx, "get"); status != 0 {
+		return errors.New(message)
+	}
+	if err := c.runBeforeRequestEvent(ctx, "GET"); err != nil {
+		if scriptErr, ok := err.(*events.ScriptError); ok {
+			return errors.New(scriptErr.Message)
+		}
+		return err
+	}
+	return nil
+}
+
+// ReadDocument returns a changed document as a GET request by user would: the
+// Get event runs on a copy and the fields user may not read are removed. It
+// returns false if user may not read the collection or the Get event rejects
+// the document.
+func (c *Collection) ReadDocument(user *appcontext.AuthData, doc map[string]interface{}) (map[string]interface{}, bool) {
+	ctx := c.subscriberContext(user)
+	if status, _ := c.checkMethodPermission(ctx, "get"); status != 0 {
+		return nil, false
+	}
+	return c.readDocument(ctx, doc, false)
+}
@@ -0,0 +1,178 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/database"
+)
+
+// WriteTimeout bounds how long the transaction of a write request may stay
+// open, the events it runs included. A transaction still open when it
+// expires is rolled back, so one slow event cannot hold the database's write
+// lock and block every other writer.
+var WriteTimeout = 10 * time.Second
+
+// beginWrite opens a transaction for a write request if the database supports
+// them. Reads and writes of the request, including those its events make
+// through dpd, run in the transaction, so they are committed or rolled back
+// together. The returned function rolls back whatever was not committed by
+// commitWrite and must be deferred.
+func (c *Collection) beginWrite(ctx *appcontext.Context) (func(), error) {
+	db, ok := c.db.(database.Transactional)
+	if !ok || c.config.NoStore {
+		return func() {}, nil
+	}
+	return beginTx(ctx, db)
+}
+
+// commitWrite commits the transaction of a write request. It runs the
+// callbacks registered with afterWrite, unless the request joined the
+// transaction of an outer request, which runs them when it commits.
+func (c *Collection) commitWrite(ctx *appcontext.Context) error {
+	db, ok := c.db.(database.Transactional)
+	if !ok || c.config.NoStore {
+		return nil
+	}
+	return commitTx(ctx, db)
+}
+
+// beginTx opens a transaction of db for a request and makes it the request's
+// context. A new transaction is rolled back once WriteTimeout passes; one
+// that joins the transaction of an outer request keeps the outer deadline.
+func beginTx(ctx *appcontext.Context, db database.Transactional) (func(), error) {
+	parent := ctx.Context()
+	if parent == nil {
+		parent = context.Background()
+	}
+	cancel := context.CancelFunc(func() {})
+	if !database.InTransaction(parent) {
+		parent, cancel = context.WithTimeout(parent, WriteTimeout)
+	}
+
+	txCtx, err := db.Begin(parent)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	ctx.SetContext(txCtx)
+	return func() {
+		db.Rollback(txCtx)
+		cancel()
+	}, nil
+}
+
+// commitTx commits the transaction opened by beginTx
+func commitTx(ctx *appcontext.Context, db database.Transactional) error {
+	err := db.Commit(ctx.Context())
+	if err != nil && errors.Is(ctx.Context().Err(), context.DeadlineExceeded) {
+		return errWriteTimeout()
+	}
+	return err
+}
+
+// errWriteTimeout reports a write rolled back for exceeding WriteTimeout
+func errWriteTimeout() error {
+	return fmt.Errorf("write took longer than %s and was rolled back", WriteTimeout)
+}
+
+// afterWrite emits the realtime change of a written document and runs the
+// AfterCommit event once the write is committed. An empty change only runs
+// the event. The change is read for subscribers in the background while the
+// event may modify doc, so it is sent a copy.
+func (c *Collection) afterWrite(ctx *appcontext.Context, change string, doc map[string]interface{}, event string) {
+	database.AfterCommit(ctx.Context(), func() {
+		if change != "" && c.realtimeEmitter != nil {
+			c.realtimeEmitter.EmitCollectionChange(c.name, change, cloneDocument(doc))
+		}
+		c.runAfterCommitEvent(ctx, doc, event)
+	})
+}
+
+// copyDocument returns a shallow copy of doc, so a response can be filtered
+// without changing the document AfterCommit callbacks still hold
+func copyDocument(doc map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		copied[k] = v
+	}
+	return copied
+}
+
+// cloneDocument returns a deep copy of doc, copying nested objects and
+// arrays as well
+func cloneDocument(doc map[string]interface{}) map[string]interface{} {
+	cloned := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		cloned[k] = cloneValue(v)
+	}
+	return cloned
+}
+
+func cloneValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return cloneDocument(v)
+	case []interface{}:
+		cloned := make([]interface{}, len(v))
+		for i, item := range v {
+			cloned[i] = cloneValue(item)
+		}
+		return cloned
+	case []map[string]interface{}:
+		cloned := make([]map[string]interface{}, len(v))
+		for i, item := range v {
+			cloned[i] = cloneDocument(item)
+		}
+		return cloned
+	default:
+		return v
+	}
+}
+
+// writeScope is a nested transaction within the transaction of a write
+// request (a savepoint on SQL databases), so part of a request can be rolled
+// back on its own
+type writeScope struct {
+	ctx      *appcontext.Context
+	parent   context.Context
+	rollback func()
+}
+
+// beginScope opens a nested transaction for the next writes of the request
+func (c *Collection) beginScope(ctx *appcontext.Context) (*writeScope, error) {
+	parent := ctx.Context()
+	rollback, err := c.beginWrite(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &writeScope{ctx: ctx, parent: parent, rollback: rollback}, nil
+}
+
+// endScope keeps or rolls back the writes of a scope and returns the request
+// to the enclosing transaction
+func (c *Collection) endScope(scope *writeScope, keep bool) error {
+	var err error
+	if keep {
+		err = c.commitWrite(scope.ctx)
+	}
+	scope.rollback()
+	scope.ctx.SetContext(scope.parent)
+	return err
+}
+
+// inScope runs fn in a nested transaction that is rolled back if fn fails
+func (c *Collection) inScope(ctx *appcontext.Context, fn func() error) error {
+	scope, err := c.beginScope(ctx)
+	if err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		c.endScope(scope, false)
+		return err
+	}
+	return c.endScope(scope, true)
+}
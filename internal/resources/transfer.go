@@ -0,0 +1,117 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/database"
+)
+
+// exportBatch is the number of documents Export reads per query
+const exportBatch = 1000
+
+// Export calls fn with each document matching filter, a query in the format
+// of GET requests, in id order. Documents are read in batches, so collections
+// of any size can be streamed. Field read rules and events don't apply.
+func (c *Collection) Export(ctx context.Context, filter map[string]interface{}, fn func(doc map[string]interface{}) error) error {
+	if c.store == nil {
+		return fmt.Errorf("collection %s has no store", c.name)
+	}
+
+	query := c.mapToQueryBuilder(c.sanitizeQuery(filter))
+	limit := int64(exportBatch)
+	opts := database.QueryOptions{Sort: map[string]int{"id": 1}, Limit: &limit}
+
+	var last interface{}
+	for {
+		seek := query.Clone()
+		if last != nil {
+			seek.Where("id", "$gt", last)
+		}
+		docs, err := c.store.Find(ctx, seek, opts)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			if err := fn(doc); err != nil {
+				return err
+			}
+		}
+		if len(docs) < exportBatch {
+			return nil
+		}
+		last = docs[len(docs)-1]["id"]
+	}
+}
+
+// ImportOptions controls how Import writes documents
+type ImportOptions struct {
+	// RunEvents runs the validate, post and put events of each document
+	RunEvents bool
+	// Upsert updates documents whose id exists instead of failing them
+	Upsert bool
+	// Ordered stops at the first document that fails
+	Ordered bool
+}
+
+// ImportFailure is a document Import could not write
+type ImportFailure struct {
+	Index   int               `json:"index"`
+	ID      interface{}       `json:"id,omitempty"`
+	Status  int               `json:"status"`
+	Message string            `json:"message"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// ImportResult counts the documents written by Import
+type ImportResult struct {
+	Inserted int             `json:"inserted"`
+	Updated  int             `json:"updated"`
+	Failed   int             `json:"failed"`
+	Failures []ImportFailure `json:"failures,omitempty"`
+}
+
+// Import writes documents in one transaction, the way a bulk request of
+// inserts does, but keeps their ids and timestamps. Documents that fail
+// (validation, a cancelling event, a duplicate id without Upsert) are
+// reported by their index in docs. ctx must act as the master key.
+func (c *Collection) Import(ctx *appcontext.Context, docs []map[string]interface{}, opts ImportOptions) (*ImportResult, error) {
+	if c.store == nil {
+		return nil, fmt.Errorf("collection %s has no store", c.name)
+	}
+
+	operations := make([]bulkOperation, len(docs))
+	for i, doc := range docs {
+		operations[i] = bulkOperation{Op: BulkInsert, Data: doc}
+		if id, exists := doc["id"]; exists && id != nil && opts.Upsert {
+			operations[i] = bulkOperation{Op: bulkUpsert, ID: fmt.Sprint(id), Data: doc}
+		}
+	}
+
+	b := &bulkWrite{
+		c:          c,
+		ctx:        ctx,
+		ordered:    opts.Ordered,
+		skipEvents: !opts.RunEvents,
+		preserve:   true,
+	}
+	summary, err := b.runAll(operations)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{Inserted: summary.Inserted, Updated: summary.Updated, Failed: summary.Failed}
+	for _, r := range summary.Results {
+		if r.Status != 200 {
+			result.Failures = append(result.Failures, ImportFailure{
+				Index:   r.Index,
+				ID:      docs[r.Index]["id"],
+				Status:  r.Status,
+				Message: r.Message,
+				Errors:  r.Errors,
+			})
+		}
+	}
+	return result, nil
+}
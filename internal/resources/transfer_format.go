@@ -0,0 +1,386 @@
+package resources
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Formats of collection exports and imports
+const (
+	FormatNDJSON  = "ndjson"  // One JSON document per line
+	FormatCSV     = "csv"     // One column per property, with a header row
+	FormatExtJSON = "extjson" // One relaxed MongoDB Extended JSON document per line, as mongoexport writes
+)
+
+var formatContentTypes = map[string]string{
+	FormatNDJSON:  "application/x-ndjson",
+	FormatCSV:     "text/csv",
+	FormatExtJSON: "application/x-ndjson",
+}
+
+// FormatContentType returns the content type of a transfer format, or "" if
+// the format is unknown
+func FormatContentType(format string) string {
+	return formatContentTypes[format]
+}
+
+// FormatExtension returns the file extension of a transfer format
+func FormatExtension(format string) string {
+	if format == FormatExtJSON {
+		return "json"
+	}
+	return format
+}
+
+// DocumentEncoder writes documents in a transfer format
+type DocumentEncoder interface {
+	Encode(doc map[string]interface{}) error
+	// Close writes out buffered output; it does not close the writer
+	Close() error
+}
+
+// DocumentDecoder reads documents in a transfer format. Decode returns io.EOF
+// after the last document.
+type DocumentDecoder interface {
+	Decode() (map[string]interface{}, error)
+}
+
+// NewDocumentEncoder returns an encoder writing documents of a collection with
+// the given properties to w
+func NewDocumentEncoder(w io.Writer, format string, properties map[string]Property) (DocumentEncoder, error) {
+	buffered := bufio.NewWriter(w)
+	switch format {
+	case FormatNDJSON:
+		encoder := json.NewEncoder(buffered)
+		encoder.SetEscapeHTML(false)
+		return &ndjsonEncoder{w: buffered, encoder: encoder}, nil
+	case FormatCSV:
+		return &csvEncoder{w: buffered, csv: csv.NewWriter(buffered), columns: CSVColumns(properties), properties: properties}, nil
+	case FormatExtJSON:
+		return &extJSONEncoder{w: buffered, properties: properties}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q: use %s, %s or %s", format, FormatNDJSON, FormatCSV, FormatExtJSON)
+}
+
+// NewDocumentDecoder returns a decoder reading documents of a collection with
+// the given properties from r
+func NewDocumentDecoder(r io.Reader, format string, properties map[string]Property) (DocumentDecoder, error) {
+	switch format {
+	case FormatNDJSON:
+		return &ndjsonDecoder{decoder: json.NewDecoder(r)}, nil
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		reader.ReuseRecord = true
+		return &csvDecoder{csv: reader, properties: properties}, nil
+	case FormatExtJSON:
+		return &extJSONDecoder{r: bufio.NewReader(r)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q: use %s, %s or %s", format, FormatNDJSON, FormatCSV, FormatExtJSON)
+}
+
+// CSVColumns returns the columns of a collection's CSV export: the id, the
+// properties by their order and name, then the timestamps
+func CSVColumns(properties map[string]Property) []string {
+	var names []string
+	for name := range properties {
+		if name != "id" && name != "createdAt" && name != "updatedAt" {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := properties[names[i]], properties[names[j]]
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		return names[i] < names[j]
+	})
+
+	columns := append([]string{"id"}, names...)
+	for _, name := range []string{"createdAt", "updatedAt"} {
+		if _, exists := properties[name]; exists {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+type ndjsonEncoder struct {
+	w       *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (e *ndjsonEncoder) Encode(doc map[string]interface{}) error {
+	return e.encoder.Encode(doc)
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return e.w.Flush()
+}
+
+type ndjsonDecoder struct {
+	decoder *json.Decoder
+	count   int
+}
+
+func (d *ndjsonDecoder) Decode() (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if err := d.decoder.Decode(&doc); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("document %d: %w", d.count+1, err)
+	}
+	d.count++
+	if doc == nil {
+		return nil, fmt.Errorf("document %d: not an object", d.count)
+	}
+	return doc, nil
+}
+
+type csvEncoder struct {
+	w          *bufio.Writer
+	csv        *csv.Writer
+	columns    []string
+	properties map[string]Property
+	started    bool
+}
+
+func (e *csvEncoder) Encode(doc map[string]interface{}) error {
+	if !e.started {
+		e.started = true
+		if err := e.csv.Write(e.columns); err != nil {
+			return err
+		}
+	}
+	record := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		value, err := csvValue(doc[column])
+		if err != nil {
+			return fmt.Errorf("%s: %w", column, err)
+		}
+		record[i] = value
+	}
+	return e.csv.Write(record)
+}
+
+func (e *csvEncoder) Close() error {
+	// An export without documents still has its header
+	if !e.started {
+		e.started = true
+		if err := e.csv.Write(e.columns); err != nil {
+			return err
+		}
+	}
+	e.csv.Flush()
+	if err := e.csv.Error(); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// csvValue formats a field for a CSV cell. Objects and arrays are written as
+// JSON; missing fields are empty.
+func csvValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int, int32, int64:
+		return fmt.Sprint(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+type csvDecoder struct {
+	csv        *csv.Reader
+	properties map[string]Property
+	header     []string
+}
+
+func (d *csvDecoder) Decode() (map[string]interface{}, error) {
+	if d.header == nil {
+		header, err := d.csv.Read()
+		if err != nil {
+			return nil, err
+		}
+		d.header = append([]string(nil), header...)
+	}
+
+	record, err := d.csv.Read()
+	if err != nil {
+		return nil, err
+	}
+	line, _ := d.csv.FieldPos(0)
+	doc := make(map[string]interface{}, len(record))
+	for i, cell := range record {
+		// Empty cells are missing fields
+		if i >= len(d.header) || cell == "" {
+			continue
+		}
+		column := d.header[i]
+		value, err := csvField(cell, d.properties[column].Type)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", line, column, err)
+		}
+		doc[column] = value
+	}
+	return doc, nil
+}
+
+// csvField parses a CSV cell into a value of the property type. Cells of
+// other columns are kept as strings.
+func csvField(cell, propertyType string) (interface{}, error) {
+	switch propertyType {
+	case "number":
+		if number, err := strconv.ParseFloat(cell, 64); err == nil {
+			return number, nil
+		}
+	case "boolean":
+		if boolean, err := strconv.ParseBool(cell); err == nil {
+			return boolean, nil
+		}
+	case "object", "array":
+		var value interface{}
+		if err := json.Unmarshal([]byte(cell), &value); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return value, nil
+	}
+	// Cells that don't parse are left for validation to reject
+	return cell, nil
+}
+
+type extJSONEncoder struct {
+	w          *bufio.Writer
+	properties map[string]Property
+}
+
+// Encode writes doc with its id as _id and its dates as $date, like
+// mongoexport, so mongoimport can read the output
+func (e *extJSONEncoder) Encode(doc map[string]interface{}) error {
+	names := make([]string, 0, len(doc))
+	for name := range doc {
+		if name != "id" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	ordered := make(bson.D, 0, len(doc))
+	if id, exists := doc["id"]; exists {
+		ordered = append(ordered, bson.E{Key: "_id", Value: id})
+	}
+	for _, name := range names {
+		value := doc[name]
+		// SQL stores return dates as the strings they were stored as
+		if text, ok := value.(string); ok && e.properties[name].Type == "date" {
+			if t, err := time.Parse(time.RFC3339Nano, text); err == nil {
+				value = t
+			}
+		}
+		ordered = append(ordered, bson.E{Key: name, Value: value})
+	}
+
+	data, err := bson.MarshalExtJSON(ordered, false, false)
+	if err != nil {
+		return err
+	}
+	if _, err := e.w.Write(data); err != nil {
+		return err
+	}
+	return e.w.WriteByte('\n')
+}
+
+func (e *extJSONEncoder) Close() error {
+	return e.w.Flush()
+}
+
+type extJSONDecoder struct {
+	r     *bufio.Reader
+	count int
+}
+
+func (d *extJSONDecoder) Decode() (map[string]interface{}, error) {
+	for {
+		line, err := d.r.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		d.count++
+
+		var raw bson.D
+		if err := bson.UnmarshalExtJSON(line, false, &raw); err != nil {
+			return nil, fmt.Errorf("document %d: %w", d.count, err)
+		}
+		doc := fromBSON(raw).(map[string]interface{})
+		if id, exists := doc["_id"]; exists {
+			delete(doc, "_id")
+			doc["id"] = id
+		}
+		return doc, nil
+	}
+}
+
+// fromBSON converts decoded BSON values to the plain values stores and
+// validation work with
+func fromBSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		doc := make(map[string]interface{}, len(v))
+		for _, elem := range v {
+			doc[elem.Key] = fromBSON(elem.Value)
+		}
+		return doc
+	case bson.M:
+		doc := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			doc[key] = fromBSON(elem)
+		}
+		return doc
+	case bson.A:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = fromBSON(item)
+		}
+		return items
+	case primitive.DateTime:
+		return v.Time().UTC()
+	case primitive.ObjectID:
+		return v.Hex()
+	case primitive.Decimal128:
+		if number, err := strconv.ParseFloat(v.String(), 64); err == nil {
+			return number
+		}
+		return v.String()
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return value
+}
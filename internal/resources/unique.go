@@ -0,0 +1,92 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/database"
+	"github.com/hjanuschka/go-deployd/internal/logging"
+)
+
+// uniqueFields returns the names of the collection's unique properties, sorted
+func (c *Collection) uniqueFields() []string {
+	var fields []string
+	for name, prop := range c.config.Properties {
+		if prop.Unique {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// ensureUniqueIndexes creates the database indexes that enforce unique
+// properties. A failure, usually caused by documents that already hold
+// duplicates, is logged and leaves the field unenforced; UniqueConflicts
+// reports the offending values.
+func (c *Collection) ensureUniqueIndexes() {
+	indexer, ok := c.store.(database.UniqueIndexer)
+	if !ok {
+		return
+	}
+	for _, field := range c.uniqueFields() {
+		if err := indexer.EnsureUniqueIndex(context.Background(), field); err != nil {
+			logging.Warn("Failed to create unique index", fmt.Sprintf("collection:%s", c.name), map[string]interface{}{
+				"field": field,
+				"error": err.Error(),
+			})
+		}
+	}
+}
+
+// DropUniqueIndex removes the index of a property that is no longer unique
+func (c *Collection) DropUniqueIndex(ctx context.Context, field string) error {
+	if indexer, ok := c.store.(database.UniqueIndexer); ok {
+		return indexer.DropUniqueIndex(ctx, field)
+	}
+	return nil
+}
+
+// UniqueConflicts returns, for each unique property, the values that more
+// than one document holds. Only such fields are included.
+func (c *Collection) UniqueConflicts(ctx context.Context) (map[string][]interface{}, error) {
+	conflicts := make(map[string][]interface{})
+	if c.store == nil {
+		return conflicts, nil
+	}
+
+	for _, field := range c.uniqueFields() {
+		groups, err := c.store.Aggregate(ctx, []map[string]interface{}{
+			{"$match": map[string]interface{}{field: map[string]interface{}{"$ne": nil}}},
+			{"$group": map[string]interface{}{"_id": "$" + field, "count": map[string]interface{}{"$sum": 1}}},
+			{"$match": map[string]interface{}{"count": map[string]interface{}{"$gt": 1}}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s for duplicates: %w", field, err)
+		}
+		for _, group := range groups {
+			conflicts[field] = append(conflicts[field], group["_id"])
+		}
+	}
+	return conflicts, nil
+}
+
+// writeStoreError responds to a failed insert or update, reporting unique
+// index violations as a 409 on the offending field and writes rolled back
+// for taking too long as such
+func (c *Collection) writeStoreError(ctx *appcontext.Context, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ctx.WriteError(500, errWriteTimeout().Error())
+	}
+	dupErr, ok := database.IsDuplicateKey(err, c.name)
+	if !ok {
+		return ctx.WriteError(500, err.Error())
+	}
+	if dupErr.Field == "" {
+		return ctx.WriteError(409, dupErr.Error())
+	}
+	return ctx.WriteFieldErrors(409, map[string]string{dupErr.Field: "must be unique"})
+}
@@ -69,6 +69,9 @@ func (uc *UserCollection) Handle(ctx *appcontext.Context) error {
 			return uc.handleLogout(ctx)
 		case "generate-token":
 			return uc.handleGenerateToken(ctx)
+		case "_bulk":
+			// Users are created through registration, which hashes passwords
+			return ctx.WriteError(405, "Bulk writes are not supported for users")
 		}
 	}
 
@@ -77,8 +80,12 @@ func (uc *UserCollection) Handle(ctx *appcontext.Context) error {
 		return uc.handleMe(ctx)
 	}
 
-	// Handle user registration (POST to collection without ID)
+	// Handle user registration (POST to collection without ID), which is
+	// subject to the collection's create permission like any other POST
 	if ctx.Method == "POST" && ctx.GetID() == "" {
+		if status, message := uc.checkPermission(ctx); status != 0 {
+			return ctx.WriteError(status, message)
+		}
 		return uc.handleRegister(ctx)
 	}
 
@@ -181,9 +188,10 @@ func (uc *UserCollection) handleRegister(ctx *appcontext.Context) error {
 
 	userData["password"] = string(hashedPassword)
 
-	// Set default role if not provided
-	if _, hasRole := userData["role"]; !hasRole {
-		userData["role"] = "user"
+	// Only callers allowed to write roles (admins and root) may register
+	// users with them; everyone else gets the default role
+	for _, field := range uc.unwritableFields(ctx, []string{"role", "roles"}, false) {
+		delete(userData, field)
 	}
 
 	// If email verification is required, set user as inactive and generate verification token
@@ -0,0 +1,220 @@
+package resources
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Formats supported by Property.Format
+const (
+	FormatEmail    = "email"
+	FormatURL      = "url"
+	FormatUUID     = "uuid"
+	FormatDateTime = "date-time"
+)
+
+var (
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+	// patterns caches compiled Property.Pattern expressions
+	patterns sync.Map
+)
+
+// compilePattern returns the compiled form of a Property.Pattern
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}
+
+// ValidateProperties checks that the validation rules in a schema are usable,
+// so a bad pattern or format is reported when the collection is loaded rather
+// than on every request
+func ValidateProperties(properties map[string]Property) error {
+	for name, prop := range properties {
+		if err := validatePropertyRules(name, prop); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validatePropertyRules(path string, prop Property) error {
+	if prop.Type == "reference" && prop.Collection == "" {
+		return fmt.Errorf("%s: a reference needs a collection", path)
+	}
+	if prop.Pattern != "" {
+		if _, err := compilePattern(prop.Pattern); err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", path, err)
+		}
+	}
+	switch prop.Format {
+	case "", FormatEmail, FormatURL, FormatUUID, FormatDateTime:
+	default:
+		return fmt.Errorf("%s: unknown format %q", path, prop.Format)
+	}
+	if prop.Items != nil {
+		if err := validatePropertyRules(path+"[]", *prop.Items); err != nil {
+			return err
+		}
+	}
+	for name, child := range prop.Properties {
+		if err := validatePropertyRules(path+"."+name, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateValue checks value against prop and records violations in errors,
+// keyed by the field path (e.g. "tags[1]" or "address.zip")
+func validateValue(path string, prop Property, value interface{}, errors map[string]string) {
+	if prop.Type != "" && !validateType(value, prop.Type) {
+		errors[path] = fmt.Sprintf("must be a %s", prop.Type)
+		return
+	}
+
+	if len(prop.Enum) > 0 && !enumContains(prop.Enum, value) {
+		errors[path] = fmt.Sprintf("must be one of %v", prop.Enum)
+		return
+	}
+
+	switch v := value.(type) {
+	case string:
+		if message := validateString(prop, v); message != "" {
+			errors[path] = message
+			return
+		}
+	case map[string]interface{}:
+		for name, child := range prop.Properties {
+			childValue, exists := v[name]
+			if !exists || childValue == nil {
+				if child.Required {
+					errors[path+"."+name] = "is required"
+				}
+				continue
+			}
+			validateValue(path+"."+name, child, childValue, errors)
+		}
+		return
+	}
+
+	if number, ok := toNumber(value); ok {
+		if prop.Min != nil && number < *prop.Min {
+			errors[path] = fmt.Sprintf("must be at least %v", *prop.Min)
+		} else if prop.Max != nil && number > *prop.Max {
+			errors[path] = fmt.Sprintf("must be at most %v", *prop.Max)
+		}
+		return
+	}
+
+	if items := reflect.ValueOf(value); items.Kind() == reflect.Slice {
+		if message := validateLength(prop, items.Len()); message != "" {
+			errors[path] = message
+			return
+		}
+		if prop.Items != nil {
+			for i := 0; i < items.Len(); i++ {
+				item := items.Index(i).Interface()
+				if item == nil {
+					continue
+				}
+				validateValue(fmt.Sprintf("%s[%d]", path, i), *prop.Items, item, errors)
+			}
+		}
+	}
+}
+
+// validateString applies the string rules of prop and returns the violation
+func validateString(prop Property, value string) string {
+	if message := validateLength(prop, utf8.RuneCountInString(value)); message != "" {
+		return message
+	}
+
+	if prop.Pattern != "" {
+		re, err := compilePattern(prop.Pattern)
+		if err != nil {
+			return "has an invalid pattern"
+		}
+		if !re.MatchString(value) {
+			return fmt.Sprintf("must match pattern %s", prop.Pattern)
+		}
+	}
+
+	switch prop.Format {
+	case FormatEmail:
+		if !emailPattern.MatchString(value) {
+			return "must be a valid email address"
+		}
+	case FormatURL:
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			return "must be a valid URL"
+		}
+	case FormatUUID:
+		if !uuidPattern.MatchString(value) {
+			return "must be a valid UUID"
+		}
+	case FormatDateTime:
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return "must be an RFC 3339 date-time"
+		}
+	}
+	return ""
+}
+
+// validateLength applies minLength/maxLength to a string or array length
+func validateLength(prop Property, length int) string {
+	if prop.MinLength != nil && length < *prop.MinLength {
+		return fmt.Sprintf("must have a length of at least %d", *prop.MinLength)
+	}
+	if prop.MaxLength != nil && length > *prop.MaxLength {
+		return fmt.Sprintf("must have a length of at most %d", *prop.MaxLength)
+	}
+	return ""
+}
+
+// enumContains reports whether value is one of the allowed values; numbers
+// are compared by value so 1 and 1.0 match
+func enumContains(enum []interface{}, value interface{}) bool {
+	number, isNumber := toNumber(value)
+	for _, allowed := range enum {
+		if isNumber {
+			if n, ok := toNumber(allowed); ok && n == number {
+				return true
+			}
+			continue
+		}
+		if reflect.DeepEqual(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
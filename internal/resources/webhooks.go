@@ -0,0 +1,732 @@
+package resources
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	appcontext "github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/database"
+	"github.com/hjanuschka/go-deployd/internal/logging"
+)
+
+// webhookStore holds the webhook deliveries of every collection
+const webhookStore = "_webhook_deliveries"
+
+// States of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	// DeliveryDead deliveries used up their attempts. They are kept as dead
+	// letters until they are redelivered.
+	DeliveryDead = "dead"
+)
+
+// Headers sent with webhook deliveries
+const (
+	WebhookEventHeader      = "X-Deployd-Event"
+	WebhookDeliveryHeader   = "X-Deployd-Delivery"
+	WebhookSignatureHeader  = "X-Deployd-Signature"
+	WebhookCollectionHeader = "X-Deployd-Collection"
+)
+
+// Limits of webhook deliveries
+const (
+	defaultWebhookAttempts      = 8
+	defaultWebhookRetryDelay    = 10 * time.Second
+	defaultWebhookMaxRetryDelay = time.Hour
+	webhookTimeout              = 10 * time.Second
+	webhookWorkers              = 4
+	webhookBatchSize            = 20
+	webhookResponseLimit        = 1024 // Bytes of response body kept per attempt
+	defaultDeliveriesLimit      = 50
+	maxDeliveriesLimit          = 500
+	// webhookPollInterval is how often the dispatcher looks for deliveries
+	// that are due, including those queued by other servers
+	webhookPollInterval = time.Second
+	// webhookLease is how long a server has to deliver what it took from the
+	// queue before other servers may take it
+	webhookLease = time.Minute
+	// Delivered deliveries are kept for webhookRetention and pruned every
+	// webhookPruneInterval
+	webhookRetention     = 7 * 24 * time.Hour
+	webhookPruneInterval = time.Hour
+)
+
+// ErrWebhookRemoved is returned when redelivering to a webhook that is no
+// longer configured
+var ErrWebhookRemoved = errors.New("webhook is no longer configured")
+
+// webhookEvents are the events a webhook can subscribe to
+var webhookEvents = map[string]bool{
+	"created": true,
+	"updated": true,
+	"deleted": true,
+}
+
+// WebhookConfig sends the changes of a collection to an HTTP endpoint. The URL
+// identifies the webhook, so a collection has one webhook per URL.
+type WebhookConfig struct {
+	URL    string                 `json:"url"`
+	Events []string               `json:"events,omitempty"` // created, updated, deleted; all if empty
+	Filter map[string]interface{} `json:"filter,omitempty"` // Query the document must match
+	Secret string                 `json:"secret,omitempty"` // Signs deliveries with HMAC-SHA256
+	// MaxAttempts is how often a delivery is tried before it becomes a dead
+	// letter. Defaults to 8.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// RetryDelay is the number of seconds before the first retry, doubled
+	// for each further one up to MaxRetryDelay. They default to 10 and 3600.
+	RetryDelay    float64 `json:"retryDelay,omitempty"`
+	MaxRetryDelay float64 `json:"maxRetryDelay,omitempty"`
+}
+
+// ValidateWebhooks checks the webhooks of a collection config
+func ValidateWebhooks(webhooks []WebhookConfig) error {
+	urls := make(map[string]bool)
+	for _, hook := range webhooks {
+		u, err := url.Parse(hook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook url %q must be an http or https URL", hook.URL)
+		}
+		if urls[hook.URL] {
+			return fmt.Errorf("webhook url %q is configured twice", hook.URL)
+		}
+		urls[hook.URL] = true
+		for _, event := range hook.Events {
+			if !webhookEvents[event] {
+				return fmt.Errorf("webhook %s: unknown event %q", hook.URL, event)
+			}
+		}
+		if _, err := database.MatchDocument(map[string]interface{}{}, hook.Filter); err != nil {
+			return fmt.Errorf("webhook %s: invalid filter: %w", hook.URL, err)
+		}
+		if hook.MaxAttempts < 0 || hook.RetryDelay < 0 || hook.MaxRetryDelay < 0 {
+			return fmt.Errorf("webhook %s: attempts and delays must not be negative", hook.URL)
+		}
+	}
+	return nil
+}
+
+// wants reports whether the webhook is sent for an event on doc
+func (hook *WebhookConfig) wants(event string, doc map[string]interface{}) bool {
+	if len(hook.Events) > 0 && !slices.Contains(hook.Events, event) {
+		return false
+	}
+	if len(hook.Filter) == 0 {
+		return true
+	}
+	matches, err := database.MatchDocument(doc, hook.Filter)
+	return err == nil && matches
+}
+
+func (hook *WebhookConfig) maxAttempts() int {
+	if hook.MaxAttempts > 0 {
+		return hook.MaxAttempts
+	}
+	return defaultWebhookAttempts
+}
+
+// retryDelay returns how long to wait after the given number of failed
+// attempts
+func (hook *WebhookConfig) retryDelay(failures int) time.Duration {
+	delay, maxDelay := defaultWebhookRetryDelay, defaultWebhookMaxRetryDelay
+	if hook.RetryDelay > 0 {
+		delay = time.Duration(hook.RetryDelay * float64(time.Second))
+	}
+	if hook.MaxRetryDelay > 0 {
+		maxDelay = time.Duration(hook.MaxRetryDelay * float64(time.Second))
+	}
+	return exponentialBackoff(delay, maxDelay, failures)
+}
+
+// exponentialBackoff doubles delay for each failure after the first, up to
+// maxDelay
+func exponentialBackoff(delay, maxDelay time.Duration, failures int) time.Duration {
+	backoff := float64(delay) * math.Pow(2, float64(failures-1))
+	if backoff > float64(maxDelay) {
+		return maxDelay
+	}
+	return time.Duration(backoff)
+}
+
+// WebhookAttempt is one try to deliver a webhook
+type WebhookAttempt struct {
+	At       time.Time `json:"at"`
+	Status   int       `json:"status,omitempty"` // HTTP status, 0 without a response
+	Error    string    `json:"error,omitempty"`
+	Response string    `json:"response,omitempty"` // Start of the response body
+	Duration int64     `json:"durationMs"`
+}
+
+// WebhookDelivery is a change sent, or to be sent, to a webhook
+type WebhookDelivery struct {
+	ID           string                 `json:"id"`
+	Collection   string                 `json:"collection"`
+	URL          string                 `json:"url"`
+	Event        string                 `json:"event"`
+	DocumentID   string                 `json:"documentId"`
+	Payload      map[string]interface{} `json:"payload"`
+	Status       string                 `json:"status"`
+	Attempts     []WebhookAttempt       `json:"attempts"`
+	NextAttempt  *time.Time             `json:"nextAttempt,omitempty"` // When a pending delivery is tried next
+	RedeliveryOf string                 `json:"redeliveryOf,omitempty"`
+	QueuedAt     time.Time              `json:"queuedAt"`
+}
+
+// deliveryDocument is how a delivery is stored. Times are stored as Unix
+// milliseconds, so every database compares and sorts them the same way.
+func deliveryDocument(d *WebhookDelivery) map[string]interface{} {
+	attempts := make([]interface{}, len(d.Attempts))
+	for i, attempt := range d.Attempts {
+		attempts[i] = map[string]interface{}{
+			"at":         attempt.At.UnixMilli(),
+			"status":     attempt.Status,
+			"error":      attempt.Error,
+			"response":   attempt.Response,
+			"durationMs": attempt.Duration,
+		}
+	}
+	doc := map[string]interface{}{
+		"id":           d.ID,
+		"collection":   d.Collection,
+		"url":          d.URL,
+		"event":        d.Event,
+		"documentId":   d.DocumentID,
+		"payload":      d.Payload,
+		"status":       d.Status,
+		"attempts":     attempts,
+		"nextAttempt":  int64(0),
+		"redeliveryOf": d.RedeliveryOf,
+		"queuedAt":     d.QueuedAt.UnixMilli(),
+	}
+	if d.NextAttempt != nil {
+		doc["nextAttempt"] = d.NextAttempt.UnixMilli()
+	}
+	return doc
+}
+
+func deliveryFromDocument(doc map[string]interface{}) *WebhookDelivery {
+	d := &WebhookDelivery{}
+	d.ID, _ = doc["id"].(string)
+	d.Collection, _ = doc["collection"].(string)
+	d.URL, _ = doc["url"].(string)
+	d.Event, _ = doc["event"].(string)
+	d.DocumentID, _ = doc["documentId"].(string)
+	d.Payload, _ = doc["payload"].(map[string]interface{})
+	d.Status, _ = doc["status"].(string)
+	d.RedeliveryOf, _ = doc["redeliveryOf"].(string)
+	if queued, ok := toInt64(doc["queuedAt"]); ok {
+		d.QueuedAt = time.UnixMilli(queued).UTC()
+	}
+	if next, ok := toInt64(doc["nextAttempt"]); ok && next > 0 && d.Status == DeliveryPending {
+		t := time.UnixMilli(next).UTC()
+		d.NextAttempt = &t
+	}
+	d.Attempts = []WebhookAttempt{}
+	attempts, _ := doc["attempts"].([]interface{})
+	for _, a := range attempts {
+		attempt, ok := a.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		at, _ := toInt64(attempt["at"])
+		status, _ := toInt64(attempt["status"])
+		duration, _ := toInt64(attempt["durationMs"])
+		errText, _ := attempt["error"].(string)
+		response, _ := attempt["response"].(string)
+		d.Attempts = append(d.Attempts, WebhookAttempt{
+			At:       time.UnixMilli(at).UTC(),
+			Status:   int(status),
+			Error:    errText,
+			Response: response,
+			Duration: duration,
+		})
+	}
+	return d
+}
+
+// webhook returns the configured webhook with the given URL, or nil
+func (c *Collection) webhook(webhookURL string) *WebhookConfig {
+	for i := range c.config.Webhooks {
+		if c.config.Webhooks[i].URL == webhookURL {
+			return &c.config.Webhooks[i]
+		}
+	}
+	return nil
+}
+
+// webhookContext is the caller webhook payloads are read as. Webhooks send
+// documents outside the app, so they get what an anonymous GET request gets.
+func (c *Collection) webhookContext() *appcontext.Context {
+	return c.subscriberContext(&appcontext.AuthData{})
+}
+
+// webhookDocument returns doc as it is sent to webhooks, or false if it is
+// not sent. Webhooks get what anyone may read: the Get event runs on a copy
+// for an anonymous caller and may reject the document, and only the fields
+// everyone may read are kept, so fields readable by their owner, by roles or
+// never are left out. The collection's get permission is not checked, since
+// webhooks are configured by the app.
+func (c *Collection) webhookDocument(doc map[string]interface{}) (map[string]interface{}, bool) {
+	if doc == nil {
+		return nil, false
+	}
+	return c.readDocument(c.webhookContext(), doc, false)
+}
+
+// newDelivery builds the delivery of an event to a webhook. Its payload has
+// the document, and for updates the previous version as well, as
+// webhookDocument returned them.
+func (c *Collection) newDelivery(hook *WebhookConfig, event, documentID string, data, previous map[string]interface{}) *WebhookDelivery {
+	now := time.Now().UTC()
+	d := &WebhookDelivery{
+		ID:          c.webhooks.CreateUniqueIdentifier(),
+		Collection:  c.name,
+		URL:         hook.URL,
+		Event:       event,
+		DocumentID:  documentID,
+		Status:      DeliveryPending,
+		Attempts:    []WebhookAttempt{},
+		NextAttempt: &now,
+		QueuedAt:    now,
+	}
+	d.Payload = map[string]interface{}{
+		"event":      event,
+		"collection": c.name,
+		"timestamp":  now,
+		"data":       data,
+	}
+	if previous != nil {
+		d.Payload["previous"] = previous
+	}
+	return d
+}
+
+// queueWebhooks queues the deliveries of a write in its transaction, so
+// only committed writes are sent. The dispatcher is woken once the write
+// commits.
+func (c *Collection) queueWebhooks(ctx *appcontext.Context, event string, before, after map[string]interface{}) error {
+	if c.webhooks == nil {
+		return nil
+	}
+	doc := after
+	if doc == nil {
+		doc = before
+	}
+
+	var hooks []*WebhookConfig
+	for i := range c.config.Webhooks {
+		hook := &c.config.Webhooks[i]
+		if hook.wants(event, doc) {
+			hooks = append(hooks, hook)
+		}
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	// The payload is read once for every webhook
+	data, ok := c.webhookDocument(doc)
+	if !ok {
+		logging.Debug("Webhook payload rejected by the Get event, not delivered", fmt.Sprintf("collection:%s", c.name), map[string]interface{}{
+			"event":      event,
+			"documentId": doc["id"],
+		})
+		return nil
+	}
+	var previous map[string]interface{}
+	if before != nil && after != nil {
+		previous, _ = c.webhookDocument(before)
+	}
+
+	docs := make([]map[string]interface{}, len(hooks))
+	for i, hook := range hooks {
+		docs[i] = deliveryDocument(c.newDelivery(hook, event, fmt.Sprint(doc["id"]), data, previous))
+	}
+	if _, err := c.webhooks.InsertMany(ctx.Context(), docs); err != nil {
+		return fmt.Errorf("failed to queue webhook: %w", err)
+	}
+
+	database.AfterCommit(ctx.Context(), func() {
+		if c.dispatcher != nil {
+			c.dispatcher.wake()
+		}
+	})
+	return nil
+}
+
+// SetWebhookDispatcher sets the dispatcher that sends the collection's
+// webhooks, and starts it if the collection has any
+func (c *Collection) SetWebhookDispatcher(dispatcher *WebhookDispatcher) {
+	c.dispatcher = dispatcher
+	if dispatcher != nil && c.webhooks != nil {
+		dispatcher.start()
+	}
+}
+
+// WebhookDeliveries returns the most recent webhook deliveries of the
+// collection, newest first, optionally only those in a state
+func (c *Collection) WebhookDeliveries(ctx context.Context, status string, limit int64) ([]*WebhookDelivery, error) {
+	if c.webhooks == nil {
+		return nil, fmt.Errorf("%s has no webhooks", c.name)
+	}
+	if limit <= 0 {
+		limit = defaultDeliveriesLimit
+	}
+	if limit > maxDeliveriesLimit {
+		limit = maxDeliveriesLimit
+	}
+	query := database.NewQueryBuilder().Where("collection", "$eq", c.name)
+	if status != "" {
+		query = query.Where("status", "$eq", status)
+	}
+	docs, err := c.webhooks.Find(ctx, query, database.QueryOptions{Sort: map[string]int{"queuedAt": -1}, Limit: &limit})
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]*WebhookDelivery, len(docs))
+	for i, doc := range docs {
+		deliveries[i] = deliveryFromDocument(doc)
+	}
+	return deliveries, nil
+}
+
+// WebhookDelivery returns one of the collection's webhook deliveries, or nil
+// if it does not exist
+func (c *Collection) WebhookDelivery(ctx context.Context, id string) (*WebhookDelivery, error) {
+	if c.webhooks == nil {
+		return nil, fmt.Errorf("%s has no webhooks", c.name)
+	}
+	doc, err := c.webhooks.FindOne(ctx, database.NewQueryBuilder().
+		Where("id", "$eq", id).
+		Where("collection", "$eq", c.name))
+	if err != nil || doc == nil {
+		return nil, err
+	}
+	return deliveryFromDocument(doc), nil
+}
+
+// RedeliverWebhook queues a delivery again, as a new delivery with the same
+// payload, so the history of the original is kept. It returns nil if the
+// delivery does not exist.
+func (c *Collection) RedeliverWebhook(ctx context.Context, id string) (*WebhookDelivery, error) {
+	original, err := c.WebhookDelivery(ctx, id)
+	if err != nil || original == nil {
+		return nil, err
+	}
+	if c.webhook(original.URL) == nil {
+		return nil, fmt.Errorf("%w: %s", ErrWebhookRemoved, original.URL)
+	}
+
+	now := time.Now().UTC()
+	d := &WebhookDelivery{
+		ID:           c.webhooks.CreateUniqueIdentifier(),
+		Collection:   c.name,
+		URL:          original.URL,
+		Event:        original.Event,
+		DocumentID:   original.DocumentID,
+		Payload:      original.Payload,
+		Status:       DeliveryPending,
+		Attempts:     []WebhookAttempt{},
+		NextAttempt:  &now,
+		RedeliveryOf: original.ID,
+		QueuedAt:     now,
+	}
+	if _, err := c.webhooks.Insert(ctx, deliveryDocument(d)); err != nil {
+		return nil, fmt.Errorf("failed to queue webhook: %w", err)
+	}
+	if c.dispatcher != nil {
+		c.dispatcher.wake()
+	}
+	return d, nil
+}
+
+// WebhookDispatcher sends the queued webhook deliveries of all collections
+// and retries the failed ones with exponential backoff. Servers sharing a
+// database share the queue; each takes due deliveries for a lease, so a
+// delivery is normally sent by one server. Deliveries are at least once:
+// receivers should ignore a repeated delivery ID.
+type WebhookDispatcher struct {
+	db          database.DatabaseInterface
+	collections CollectionResolver
+	client      *http.Client
+	store       database.StoreInterface
+
+	wakeup    chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+
+	mu       sync.Mutex
+	inFlight map[string]bool // Deliveries being sent by this server
+}
+
+// NewWebhookDispatcher creates a dispatcher for the deliveries in db.
+// collections resolves the collection of a delivery, for its webhook config.
+func NewWebhookDispatcher(db database.DatabaseInterface, collections CollectionResolver) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		db:          db,
+		collections: collections,
+		client:      &http.Client{Timeout: webhookTimeout},
+		wakeup:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		inFlight:    make(map[string]bool),
+	}
+}
+
+// start runs the dispatcher once a collection with webhooks uses it
+func (d *WebhookDispatcher) start() {
+	d.startOnce.Do(func() {
+		d.store = d.db.CreateStore(webhookStore)
+		d.wg.Add(1)
+		go d.run()
+	})
+}
+
+// Stop stops the dispatcher and waits for the deliveries being sent.
+// Deliveries left in the queue are sent when a dispatcher runs again.
+func (d *WebhookDispatcher) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.done)
+	})
+	d.wg.Wait()
+}
+
+// wake makes the dispatcher look for due deliveries now
+func (d *WebhookDispatcher) wake() {
+	select {
+	case d.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (d *WebhookDispatcher) run() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	workers := make(chan struct{}, webhookWorkers)
+	var lastPrune time.Time
+
+	for {
+		d.dispatchDue(workers)
+		if time.Since(lastPrune) > webhookPruneInterval {
+			d.prune()
+			lastPrune = time.Now()
+		}
+		select {
+		case <-d.wakeup:
+		case <-ticker.C:
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// dispatchDue takes the deliveries that are due and sends them, with at most
+// webhookWorkers at a time
+func (d *WebhookDispatcher) dispatchDue(workers chan struct{}) {
+	ctx := context.Background()
+	now := time.Now()
+	limit := int64(webhookBatchSize)
+	query := database.NewQueryBuilder().
+		Where("status", "$eq", DeliveryPending).
+		Where("nextAttempt", "$lte", now.UnixMilli())
+	docs, err := d.store.Find(ctx, query, database.QueryOptions{Sort: map[string]int{"nextAttempt": 1}, Limit: &limit})
+	if err != nil {
+		logging.Error("Failed to read webhook queue", "webhooks", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	for _, doc := range docs {
+		delivery := deliveryFromDocument(doc)
+		if !d.claim(ctx, delivery, now) {
+			continue
+		}
+		select {
+		case workers <- struct{}{}:
+		case <-d.done:
+			d.release(delivery.ID)
+			return
+		}
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			defer func() { <-workers }()
+			defer d.release(delivery.ID)
+			d.deliver(delivery)
+		}()
+	}
+}
+
+// claim takes a delivery for webhookLease, unless this server is already
+// sending it or another server took it first
+func (d *WebhookDispatcher) claim(ctx context.Context, delivery *WebhookDelivery, now time.Time) bool {
+	d.mu.Lock()
+	if d.inFlight[delivery.ID] {
+		d.mu.Unlock()
+		return false
+	}
+	d.inFlight[delivery.ID] = true
+	d.mu.Unlock()
+
+	query := database.NewQueryBuilder().
+		Where("id", "$eq", delivery.ID).
+		Where("status", "$eq", DeliveryPending).
+		Where("nextAttempt", "$lte", now.UnixMilli())
+	update := database.NewUpdateBuilder().Set("nextAttempt", now.Add(webhookLease).UnixMilli())
+	result, err := d.store.UpdateOne(ctx, query, update)
+	if err != nil || result.ModifiedCount() == 0 {
+		d.release(delivery.ID)
+		return false
+	}
+	return true
+}
+
+func (d *WebhookDispatcher) release(id string) {
+	d.mu.Lock()
+	delete(d.inFlight, id)
+	d.mu.Unlock()
+}
+
+// deliver sends a delivery once and records the attempt. Failed deliveries
+// are retried after a backoff until they use up their attempts and become
+// dead letters.
+func (d *WebhookDispatcher) deliver(delivery *WebhookDelivery) {
+	var hook *WebhookConfig
+	if collection := d.collections(delivery.Collection); collection != nil {
+		hook = collection.webhook(delivery.URL)
+	}
+
+	attempt := WebhookAttempt{At: time.Now().UTC()}
+	if hook == nil {
+		attempt.Error = ErrWebhookRemoved.Error()
+	} else {
+		d.send(hook, delivery, &attempt)
+	}
+	delivery.Attempts = append(delivery.Attempts, attempt)
+
+	logFields := map[string]interface{}{
+		"delivery_id": delivery.ID,
+		"collection":  delivery.Collection,
+		"url":         delivery.URL,
+		"event":       delivery.Event,
+		"attempt":     len(delivery.Attempts),
+		"status":      attempt.Status,
+	}
+	var retryIn time.Duration
+	switch {
+	case attempt.Error == "":
+		delivery.Status = DeliveryDelivered
+		delivery.NextAttempt = nil
+		logging.Debug("Webhook delivered", "webhooks", logFields)
+	case hook == nil || len(delivery.Attempts) >= hook.maxAttempts():
+		delivery.Status = DeliveryDead
+		delivery.NextAttempt = nil
+		logFields["error"] = attempt.Error
+		logging.Error("Webhook delivery failed permanently", "webhooks", logFields)
+	default:
+		retryIn = hook.retryDelay(len(delivery.Attempts))
+		next := time.Now().Add(retryIn).UTC()
+		delivery.NextAttempt = &next
+		logFields["error"] = attempt.Error
+		logFields["retry_in"] = retryIn.String()
+		logging.Warn("Webhook delivery failed, retrying", "webhooks", logFields)
+	}
+
+	doc := deliveryDocument(delivery)
+	update := database.NewUpdateBuilder().
+		Set("status", doc["status"]).
+		Set("attempts", doc["attempts"]).
+		Set("nextAttempt", doc["nextAttempt"])
+	if _, err := d.store.UpdateOne(context.Background(), database.NewQueryBuilder().Where("id", "$eq", delivery.ID), update); err != nil {
+		logging.Error("Failed to record webhook delivery", "webhooks", map[string]interface{}{
+			"delivery_id": delivery.ID,
+			"error":       err.Error(),
+		})
+		return
+	}
+	if retryIn > 0 {
+		time.AfterFunc(retryIn, d.wake)
+	}
+}
+
+// send posts the delivery's payload to its webhook. The delivery ID is sent
+// in the payload and a header; with a secret, the body is signed with
+// HMAC-SHA256 in the signature header as "sha256=<hex digest>".
+func (d *WebhookDispatcher) send(hook *WebhookConfig, delivery *WebhookDelivery, attempt *WebhookAttempt) {
+	payload := make(map[string]interface{}, len(delivery.Payload)+1)
+	for k, v := range delivery.Payload {
+		payload[k] = v
+	}
+	payload["id"] = delivery.ID
+	body, err := json.Marshal(payload)
+	if err != nil {
+		attempt.Error = err.Error()
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-deployd-webhooks")
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	req.Header.Set(WebhookCollectionHeader, delivery.Collection)
+	if hook.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(hook.Secret, body))
+	}
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	attempt.Duration = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	attempt.Status = resp.StatusCode
+	attempt.Response = string(response)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("webhook responded %s", resp.Status)
+	}
+}
+
+// SignWebhook returns the signature header value of a webhook body
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// prune removes the delivered deliveries older than webhookRetention. Dead
+// letters are kept.
+func (d *WebhookDispatcher) prune() {
+	query := database.NewQueryBuilder().
+		Where("status", "$eq", DeliveryDelivered).
+		Where("queuedAt", "$lt", time.Now().Add(-webhookRetention).UnixMilli())
+	if _, err := d.store.Remove(context.Background(), query); err != nil {
+		logging.Error("Failed to prune webhook deliveries", "webhooks", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
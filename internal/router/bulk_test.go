@@ -0,0 +1,223 @@
+package router_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterBulkWrites(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "products", `{
+		"properties": {
+			"name":  {"type": "string", "required": true},
+			"sku":   {"type": "string", "unique": true},
+			"price": {"type": "number"}
+		},
+		"permissions": {"delete": "root"},
+		"eventConfig": {"post": {"runtime": "js"}}
+	}`, `function Run(context) {
+		dpd.audit.post({sku: context.data.sku});
+		if (context.data.name === 'reject') {
+			context.cancel('rejected by event', 422);
+		}
+	}`)
+	writeDpdTestCollection(t, configDir, "audit", `{"properties": {"sku": {"type": "string"}}}`, "")
+
+	r := router.New(db, true, configDir)
+
+	type result struct {
+		Index   int               `json:"index"`
+		Op      string            `json:"op"`
+		Status  int               `json:"status"`
+		ID      string            `json:"id"`
+		Message string            `json:"message"`
+		Errors  map[string]string `json:"errors"`
+	}
+	type response struct {
+		Inserted int      `json:"inserted"`
+		Updated  int      `json:"updated"`
+		Deleted  int      `json:"deleted"`
+		Failed   int      `json:"failed"`
+		Results  []result `json:"results"`
+	}
+	bulk := func(body map[string]interface{}) response {
+		status, raw := postJSON(t, r, "/products/_bulk", body)
+		require.Equal(t, http.StatusOK, status, raw)
+		data, err := json.Marshal(raw)
+		require.NoError(t, err)
+		var resp response
+		require.NoError(t, json.Unmarshal(data, &resp))
+		return resp
+	}
+	skus := func(path string) []string {
+		req := httptest.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var docs []map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &docs))
+		values := []string{}
+		for _, doc := range docs {
+			values = append(values, doc["sku"].(string))
+		}
+		sort.Strings(values)
+		return values
+	}
+
+	status, existing := postJSON(t, r, "/products", map[string]interface{}{"name": "Existing", "sku": "e-1"})
+	require.Equal(t, http.StatusOK, status, existing)
+
+	t.Run("reports each operation of an unordered request", func(t *testing.T) {
+		resp := bulk(map[string]interface{}{
+			"ordered": false,
+			"operations": []interface{}{
+				map[string]interface{}{"op": "insert", "data": map[string]interface{}{"name": "A", "sku": "a-1"}},
+				map[string]interface{}{"op": "insert", "data": map[string]interface{}{"name": "B", "sku": "b-1"}},
+				map[string]interface{}{"op": "insert", "data": map[string]interface{}{"name": "Dup", "sku": "a-1"}},
+				map[string]interface{}{"op": "insert", "data": map[string]interface{}{"sku": "no-name"}},
+				map[string]interface{}{"op": "insert", "data": map[string]interface{}{"name": "reject", "sku": "r-1"}},
+				map[string]interface{}{"op": "update", "id": existing["id"], "data": map[string]interface{}{"price": 10}},
+				map[string]interface{}{"op": "update", "id": "missing", "data": map[string]interface{}{"price": 1}},
+				map[string]interface{}{"op": "delete", "id": existing["id"]},
+			},
+		})
+
+		assert.Equal(t, 2, resp.Inserted)
+		assert.Equal(t, 1, resp.Updated)
+		assert.Equal(t, 0, resp.Deleted)
+		assert.Equal(t, 5, resp.Failed)
+		require.Len(t, resp.Results, 8)
+		for i, res := range resp.Results {
+			assert.Equal(t, i, res.Index)
+		}
+
+		assert.Equal(t, http.StatusOK, resp.Results[0].Status)
+		assert.NotEmpty(t, resp.Results[0].ID)
+		assert.Equal(t, http.StatusConflict, resp.Results[2].Status, "a duplicate in the batch only fails itself")
+		assert.Equal(t, map[string]string{"sku": "must be unique"}, resp.Results[2].Errors)
+		assert.Equal(t, http.StatusBadRequest, resp.Results[3].Status)
+		assert.Equal(t, map[string]string{"name": "is required"}, resp.Results[3].Errors)
+		assert.Equal(t, 422, resp.Results[4].Status)
+		assert.Equal(t, "rejected by event", resp.Results[4].Message)
+		assert.Equal(t, http.StatusOK, resp.Results[5].Status)
+		assert.Equal(t, http.StatusNotFound, resp.Results[6].Status)
+		assert.Equal(t, http.StatusUnauthorized, resp.Results[7].Status, "each operation checks its own permission")
+
+		assert.Equal(t, []string{"a-1", "b-1", "e-1"}, skus("/products"))
+		assert.Equal(t, []string{"a-1", "b-1", "e-1"}, skus("/audit"), "writes of failed operations' events are rolled back")
+
+		req := httptest.NewRequest("GET", "/products/"+existing["id"].(string), nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		var updated map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &updated))
+		assert.Equal(t, float64(10), updated["price"])
+	})
+
+	t.Run("stops an ordered request at the first failure", func(t *testing.T) {
+		resp := bulk(map[string]interface{}{
+			"operations": []interface{}{
+				map[string]interface{}{"op": "insert", "data": map[string]interface{}{"name": "C", "sku": "c-1"}},
+				map[string]interface{}{"op": "insert", "data": map[string]interface{}{"name": "C again", "sku": "c-1"}},
+				map[string]interface{}{"op": "insert", "data": map[string]interface{}{"name": "D", "sku": "d-1"}},
+			},
+		})
+
+		assert.Equal(t, 1, resp.Inserted)
+		assert.Equal(t, 1, resp.Failed)
+		require.Len(t, resp.Results, 2, "operations after the failure are not attempted")
+		assert.Equal(t, http.StatusConflict, resp.Results[1].Status)
+		assert.Equal(t, []string{"a-1", "b-1", "c-1", "e-1"}, skus("/products"))
+		assert.Equal(t, []string{"a-1", "b-1", "c-1", "e-1"}, skus("/audit"))
+	})
+
+	t.Run("rejects malformed requests", func(t *testing.T) {
+		status, _ := postJSON(t, r, "/products/_bulk", map[string]interface{}{
+			"operations": []interface{}{map[string]interface{}{"op": "upsert", "data": map[string]interface{}{}}},
+		})
+		assert.Equal(t, http.StatusBadRequest, status)
+
+		status, _ = postJSON(t, r, "/products/_bulk", map[string]interface{}{"operations": "all"})
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+}
+
+func TestRouterBulkRequestEvents(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "entries", `{
+		"properties": {"name": {"type": "string"}},
+		"eventConfig": {"beforerequest": {"runtime": "js"}, "post": {"runtime": "js"}}
+	}`, `function Run(context) { context.data.tagged = true; }`)
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "entries", "beforerequest.js"), []byte(`function Run(context) {
+		if (context.data.event === 'DELETE') {
+			context.cancel('deletes are closed', 403);
+		}
+	}`), 0644))
+
+	r := router.New(db, true, configDir)
+
+	names := func() []string {
+		req := httptest.NewRequest("GET", "/entries", nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var docs []map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &docs))
+		values := []string{}
+		for _, doc := range docs {
+			values = append(values, doc["name"].(string))
+		}
+		sort.Strings(values)
+		return values
+	}
+
+	t.Run("fails the whole request when BeforeRequest cancels", func(t *testing.T) {
+		status, body := postJSON(t, r, "/entries/_bulk", map[string]interface{}{
+			"operations": []interface{}{
+				map[string]interface{}{"op": "insert", "data": map[string]interface{}{"name": "first"}},
+				map[string]interface{}{"op": "delete", "id": "anything"},
+			},
+		})
+		assert.Equal(t, http.StatusForbidden, status, body)
+		assert.Empty(t, names(), "no operation runs")
+	})
+
+	t.Run("ignores $skipEvents from clients without the master key", func(t *testing.T) {
+		status, body := postJSON(t, r, "/entries/_bulk", map[string]interface{}{
+			"$skipEvents": true,
+			"operations": []interface{}{
+				map[string]interface{}{"op": "insert", "data": map[string]interface{}{"name": "second"}},
+			},
+		})
+		require.Equal(t, http.StatusOK, status, body)
+		status, single := postJSON(t, r, "/entries", map[string]interface{}{"name": "third", "$skipEvents": true})
+		require.Equal(t, http.StatusOK, status, single)
+		assert.Equal(t, true, single["tagged"], "the POST event still ran")
+
+		req := httptest.NewRequest("GET", "/entries", nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		var docs []map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &docs))
+		require.Len(t, docs, 2)
+		for _, doc := range docs {
+			assert.Equal(t, true, doc["tagged"], "the POST event of %v still ran", doc["name"])
+		}
+	})
+}
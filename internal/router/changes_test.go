@@ -0,0 +1,306 @@
+package router_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type changeEntry struct {
+	Seq       int64                  `json:"seq"`
+	Operation string                 `json:"operation"`
+	ID        string                 `json:"id"`
+	Before    map[string]interface{} `json:"before"`
+	After     map[string]interface{} `json:"after"`
+}
+
+type changesResponse struct {
+	Results []changeEntry `json:"results"`
+	LastSeq int64         `json:"lastSeq"`
+}
+
+func TestRouterChangeFeed(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "todos", `{
+		"properties": {
+			"title": {"type": "string", "required": true},
+			"done":  {"type": "boolean"},
+			"notes": {"type": "string", "readable": "never"}
+		},
+		"changeLog": {"enabled": true, "maxEntries": 10}
+	}`, "")
+	writeDpdTestCollection(t, configDir, "plain", `{"properties": {"title": {"type": "string"}}}`, "")
+
+	r := router.New(db, true, configDir)
+
+	send := func(method, path string, body map[string]interface{}) map[string]interface{} {
+		payload, err := json.Marshal(body)
+		require.NoError(t, err)
+		req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var result map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		return result
+	}
+	changes := func(query string) changesResponse {
+		req := httptest.NewRequest("GET", "/todos/_changes?"+query, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var resp changesResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+
+	first := send("POST", "/todos", map[string]interface{}{"title": "Write docs", "notes": "private"})
+	second := send("POST", "/todos", map[string]interface{}{"title": "Ship"})
+	id := first["id"].(string)
+	send("PUT", "/todos/"+id, map[string]interface{}{"done": true})
+	send("DELETE", "/todos/"+second["id"].(string), nil)
+	send("POST", "/todos/_bulk", map[string]interface{}{"operations": []interface{}{
+		map[string]interface{}{"op": "insert", "data": map[string]interface{}{"title": "Bulk 1"}},
+		map[string]interface{}{"op": "insert", "data": map[string]interface{}{"title": "Bulk 2"}},
+		map[string]interface{}{"op": "update", "id": id, "data": map[string]interface{}{"title": "Write more docs"}},
+	}})
+
+	t.Run("records every write in order", func(t *testing.T) {
+		resp := changes("")
+		require.Len(t, resp.Results, 7)
+		assert.Equal(t, int64(7), resp.LastSeq)
+
+		var operations []string
+		for i, change := range resp.Results {
+			assert.Equal(t, int64(i+1), change.Seq)
+			operations = append(operations, change.Operation)
+		}
+		assert.Equal(t, []string{"created", "created", "updated", "deleted", "created", "created", "updated"}, operations)
+
+		created := resp.Results[0]
+		assert.Equal(t, id, created.ID)
+		assert.Nil(t, created.Before)
+		assert.Equal(t, "Write docs", created.After["title"])
+		assert.NotContains(t, created.After, "notes", "unreadable fields are hidden")
+
+		updated := resp.Results[2]
+		assert.Equal(t, map[string]interface{}{"done": true}, withoutUpdatedAt(updated.After))
+		assert.Empty(t, withoutUpdatedAt(updated.Before), "done was not set before")
+		assert.Contains(t, updated.After, "updatedAt")
+
+		deleted := resp.Results[3]
+		assert.Equal(t, second["id"], deleted.ID)
+		assert.Equal(t, "Ship", deleted.Before["title"])
+		assert.Nil(t, deleted.After)
+
+		renamed := resp.Results[6]
+		assert.Equal(t, "Write docs", renamed.Before["title"])
+		assert.Equal(t, "Write more docs", renamed.After["title"])
+	})
+
+	t.Run("pages with since and limit", func(t *testing.T) {
+		resp := changes("since=5&limit=1")
+		require.Len(t, resp.Results, 1)
+		assert.Equal(t, int64(6), resp.Results[0].Seq)
+		assert.Equal(t, int64(6), resp.LastSeq)
+
+		resp = changes("since=now")
+		assert.Empty(t, resp.Results)
+		assert.Equal(t, int64(7), resp.LastSeq)
+	})
+
+	t.Run("long-polls for the next change", func(t *testing.T) {
+		start := time.Now()
+		resp := changes("feed=longpoll&since=7&timeout=0.2")
+		assert.Empty(t, resp.Results)
+		assert.Equal(t, int64(7), resp.LastSeq)
+		assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+		done := make(chan changesResponse)
+		go func() {
+			req := httptest.NewRequest("GET", "/todos/_changes?feed=longpoll&since=7&timeout=10", nil)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			var resp changesResponse
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			done <- resp
+		}()
+		time.Sleep(100 * time.Millisecond)
+		send("POST", "/todos", map[string]interface{}{"title": "Wake up"})
+
+		select {
+		case resp := <-done:
+			require.Len(t, resp.Results, 1)
+			assert.Equal(t, int64(8), resp.Results[0].Seq)
+			assert.Equal(t, "Wake up", resp.Results[0].After["title"])
+		case <-time.After(5 * time.Second):
+			t.Fatal("long poll did not return the new change")
+		}
+	})
+
+	t.Run("streams server-sent events from Last-Event-ID", func(t *testing.T) {
+		server := httptest.NewServer(r)
+		defer server.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/todos/_changes", nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set("Last-Event-ID", "7")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		events := make(chan string, 10)
+		go func() {
+			scanner := bufio.NewScanner(resp.Body)
+			var event []string
+			for scanner.Scan() {
+				if scanner.Text() != "" {
+					event = append(event, scanner.Text())
+					continue
+				}
+				if len(event) > 0 && strings.HasPrefix(event[0], "id: ") {
+					events <- strings.Join(event, "\n")
+				}
+				event = nil
+			}
+			close(events)
+		}()
+
+		assert.Contains(t, <-events, `"title":"Wake up"`, "resumes after Last-Event-ID")
+		send("POST", "/todos", map[string]interface{}{"title": "Streamed"})
+		event := <-events
+		assert.True(t, strings.HasPrefix(event, "id: 9\n"), event)
+		assert.Contains(t, event, `"title":"Streamed"`)
+	})
+
+	t.Run("reports pruned changes as gone", func(t *testing.T) {
+		var operations []interface{}
+		for i := 0; i < 100; i++ {
+			operations = append(operations, map[string]interface{}{"op": "insert", "data": map[string]interface{}{"title": fmt.Sprintf("Item %d", i)}})
+		}
+		send("POST", "/todos/_bulk", map[string]interface{}{"operations": operations})
+
+		resp := changes("since=99")
+		require.Len(t, resp.Results, 10, "only maxEntries changes are kept")
+		assert.Equal(t, int64(100), resp.Results[0].Seq)
+
+		req := httptest.NewRequest("GET", "/todos/_changes?since=5", nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusGone, rr.Code)
+	})
+
+	t.Run("rejects bad requests", func(t *testing.T) {
+		for path, status := range map[string]int{
+			"/todos/_changes?since=soon": http.StatusBadRequest,
+			"/todos/_changes?feed=push":  http.StatusBadRequest,
+			"/plain/_changes":            http.StatusNotFound,
+		} {
+			req := httptest.NewRequest("GET", path, nil)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			assert.Equal(t, status, rr.Code, path)
+		}
+	})
+}
+
+func TestRouterChangeFeedGetEvent(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "tasks", `{
+		"properties": {
+			"title":  {"type": "string"},
+			"secret": {"type": "boolean"}
+		},
+		"changeLog": {"enabled": true},
+		"eventConfig": {"get": {"runtime": "js"}}
+	}`, "")
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "tasks", "get.js"), []byte(`function Run(context) {
+		if (context.data.secret) {
+			context.cancel('hidden', 404);
+		}
+		context.data.label = 'seen ' + context.data.title;
+	}`), 0644))
+
+	r := router.New(db, true, configDir)
+
+	changes := func(query string) changesResponse {
+		req := httptest.NewRequest("GET", "/tasks/_changes?"+query, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var resp changesResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+
+	status, visible := postJSON(t, r, "/tasks", map[string]interface{}{"title": "Open"})
+	require.Equal(t, http.StatusOK, status, visible)
+	status, hidden := postJSON(t, r, "/tasks", map[string]interface{}{"title": "Quiet", "secret": true})
+	require.Equal(t, http.StatusOK, status, hidden)
+	status, body := putJSON(t, r, "/tasks/"+visible["id"].(string), map[string]interface{}{"title": "Reopened"})
+	require.Equal(t, http.StatusOK, status, body)
+	status, body = putJSON(t, r, "/tasks/"+hidden["id"].(string), map[string]interface{}{"secret": false})
+	require.Equal(t, http.StatusOK, status, body)
+
+	t.Run("runs the Get event like a GET request", func(t *testing.T) {
+		resp := changes("")
+		assert.Equal(t, int64(4), resp.LastSeq)
+		require.Len(t, resp.Results, 3, "the change the Get event rejects is left out")
+
+		created := resp.Results[0]
+		assert.Equal(t, int64(1), created.Seq)
+		assert.Equal(t, "seen Open", created.After["label"])
+
+		renamed := resp.Results[1]
+		assert.Equal(t, int64(3), renamed.Seq)
+		assert.Equal(t, map[string]interface{}{"title": "Open", "label": "seen Open"}, withoutUpdatedAt(renamed.Before))
+		assert.Equal(t, map[string]interface{}{"title": "Reopened", "label": "seen Reopened"}, withoutUpdatedAt(renamed.After))
+
+		revealed := resp.Results[2]
+		assert.Equal(t, int64(4), revealed.Seq)
+		assert.Empty(t, revealed.Before, "the document could not be read before")
+		assert.Equal(t, "Quiet", revealed.After["title"])
+		assert.Equal(t, "seen Quiet", revealed.After["label"])
+	})
+
+	t.Run("moves past changes the caller may not see", func(t *testing.T) {
+		resp := changes("since=1&limit=1")
+		assert.Empty(t, resp.Results)
+		assert.Equal(t, int64(2), resp.LastSeq)
+	})
+}
+
+func withoutUpdatedAt(doc map[string]interface{}) map[string]interface{} {
+	filtered := make(map[string]interface{})
+	for k, v := range doc {
+		if k != "updatedAt" {
+			filtered[k] = v
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,177 @@
+package router_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/resources"
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeCronJob(t *testing.T, configDir, name, config, script string) {
+	dir := filepath.Join(configDir, name)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0644))
+	if script != "" {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "run.js"), []byte(script), 0644))
+	}
+}
+
+// waitForRuns waits until job has n runs with status and returns them
+func waitForRuns(t *testing.T, job *resources.CronJob, status string, n int) []*resources.CronRun {
+	var runs []*resources.CronRun
+	require.Eventually(t, func() bool {
+		var err error
+		runs, err = job.Runs(context.Background(), status, 100)
+		require.NoError(t, err)
+		return len(runs) >= n
+	}, 10*time.Second, 20*time.Millisecond, "%d %s runs of %s", n, status, job.GetName())
+	return runs
+}
+
+func TestCronJobs(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "audit", `{
+		"properties": {"job": {"type": "string"}, "trigger": {"type": "string"}}
+	}`, "")
+	writeCronJob(t, configDir, "tick", `{"type": "cron", "schedule": "@every 1s", "timezone": "UTC"}`, `
+		dpd.audit.post({job: context.data.job, trigger: context.data.trigger});
+	`)
+	writeCronJob(t, configDir, "broken", `{"type": "cron", "schedule": "@daily", "disabled": true}`, `
+		context.cancel('out of paper');
+	`)
+	writeCronJob(t, configDir, "slow", `{"type": "cron", "schedule": "@yearly", "history": 2}`, `
+		var end = Date.now() + 300;
+		while (Date.now() < end) {}
+	`)
+	writeCronJob(t, configDir, "bad_schedule", `{"type": "cron", "schedule": "61 * * * *"}`, `dpd.audit.post({});`)
+	writeCronJob(t, configDir, "no_script", `{"type": "cron", "schedule": "@daily"}`, "")
+
+	r := router.New(db, true, configDir)
+	defer r.Close()
+
+	var names []string
+	for _, job := range r.GetCronJobs() {
+		names = append(names, job.GetName())
+	}
+	assert.ElementsMatch(t, []string{"tick", "broken", "slow"}, names, "invalid jobs are not loaded")
+	assert.Nil(t, r.GetCollection("tick"))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/tick", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "cron jobs are not served over HTTP")
+
+	r.StartCronJobs()
+	audit := r.GetCollection("audit")
+	require.NotNil(t, audit)
+
+	t.Run("runs on schedule", func(t *testing.T) {
+		runs := waitForRuns(t, r.GetCronJob("tick"), resources.CronRunSucceeded, 1)
+		run := runs[0]
+		assert.Equal(t, resources.CronTriggerSchedule, run.Trigger)
+		require.NotNil(t, run.ScheduledAt)
+		require.NotNil(t, run.FinishedAt)
+		assert.False(t, run.StartedAt.Before(run.ScheduledAt.Add(-time.Second)))
+		assert.NotEmpty(t, run.Node)
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/audit?job=tick", nil))
+		assert.Contains(t, rr.Body.String(), `"trigger":"schedule"`, "the script calls collections through dpd")
+	})
+
+	t.Run("records failures", func(t *testing.T) {
+		job := r.GetCronJob("broken")
+		info, err := job.Info(context.Background())
+		require.NoError(t, err)
+		assert.True(t, info.Disabled)
+		assert.Nil(t, info.NextRun, "disabled jobs are not scheduled")
+
+		run, err := job.RunNow()
+		require.NoError(t, err)
+		assert.Equal(t, resources.CronTriggerManual, run.Trigger)
+		assert.Equal(t, resources.CronRunRunning, run.Status)
+
+		runs := waitForRuns(t, job, resources.CronRunFailed, 1)
+		assert.Equal(t, run.ID, runs[0].ID)
+		assert.Equal(t, "out of paper", runs[0].Error)
+	})
+
+	t.Run("does not overlap", func(t *testing.T) {
+		job := r.GetCronJob("slow")
+		_, err := job.RunNow()
+		require.NoError(t, err)
+		info, err := job.Info(context.Background())
+		require.NoError(t, err)
+		assert.True(t, info.Running)
+		_, err = job.RunNow()
+		assert.ErrorIs(t, err, resources.ErrCronRunning)
+
+		waitForRuns(t, job, resources.CronRunSucceeded, 1)
+		for i := 0; i < 2; i++ {
+			require.Eventually(t, func() bool {
+				_, err := job.RunNow()
+				return err == nil
+			}, 5*time.Second, 20*time.Millisecond)
+		}
+		waitForRuns(t, job, resources.CronRunSucceeded, 2)
+		require.Eventually(t, func() bool {
+			info, err := job.Info(context.Background())
+			require.NoError(t, err)
+			return !info.Running
+		}, 5*time.Second, 20*time.Millisecond)
+		runs, err := job.Runs(context.Background(), "", 100)
+		require.NoError(t, err)
+		assert.Len(t, runs, 2, "runs beyond the history are pruned")
+	})
+}
+
+func TestCronJobLeaderLock(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	// Each run outlasts the next fire time, so every other one is skipped
+	configDir := t.TempDir()
+	writeCronJob(t, configDir, "report", `{"type": "cron", "schedule": "@every 1s"}`, `
+		var end = Date.now() + 1300;
+		while (Date.now() < end) {}
+	`)
+
+	// Two servers sharing a database
+	first := router.New(db, true, configDir)
+	second := router.New(db, true, configDir)
+	defer first.Close()
+	defer second.Close()
+	first.StartCronJobs()
+	second.StartCronJobs()
+
+	job := first.GetCronJob("report")
+	waitForRuns(t, job, resources.CronRunSkipped, 1)
+	waitForRuns(t, job, resources.CronRunSucceeded, 2)
+	// Closing waits for the runs in progress
+	first.Close()
+	second.Close()
+
+	runs, err := job.Runs(context.Background(), "", 100)
+	require.NoError(t, err)
+	scheduled := make(map[time.Time]string)
+	for _, run := range runs {
+		require.NotNil(t, run.ScheduledAt)
+		assert.Empty(t, scheduled[*run.ScheduledAt], "%s was handled twice", run.ScheduledAt)
+		scheduled[*run.ScheduledAt] = run.Status
+	}
+	for i, run := range runs[1:] {
+		if runs[i].Status != resources.CronRunSkipped && run.Status != resources.CronRunSkipped {
+			assert.False(t, run.FinishedAt.After(runs[i].StartedAt), "runs overlap")
+		}
+	}
+}
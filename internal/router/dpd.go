@@ -0,0 +1,153 @@
+package router
+
+import (
+	stdcontext "context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/hjanuschka/go-deployd/internal/context"
+	"github.com/hjanuschka/go-deployd/internal/events"
+)
+
+// MaxDpdDepth limits how deeply dpd calls from event scripts may nest, so an
+// event that writes to its own collection cannot recurse forever.
+const MaxDpdDepth = 10
+
+type dpdDepthKey struct{}
+
+// dpdClient implements events.Dpd by dispatching internal requests to the
+// target collection's handler on behalf of the request that ran the event.
+type dpdClient struct {
+	router *Router
+	caller *context.Context
+	depth  int
+}
+
+// Dpd returns a dpd client bound to the given request context
+func (r *Router) Dpd(ctx *context.Context) events.Dpd {
+	depth := 0
+	if ctx.Context() != nil {
+		if d, ok := ctx.Context().Value(dpdDepthKey{}).(int); ok {
+			depth = d
+		}
+	}
+	return &dpdClient{router: r, caller: ctx, depth: depth}
+}
+
+func (c *dpdClient) Get(collection string, query map[string]interface{}) ([]map[string]interface{}, error) {
+	var docs []map[string]interface{}
+	if err := c.do("GET", collection, "", query, nil, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+func (c *dpdClient) GetOne(collection, id string) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if err := c.do("GET", collection, id, nil, nil, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (c *dpdClient) Post(collection string, data map[string]interface{}) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if err := c.do("POST", collection, "", nil, data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (c *dpdClient) Put(collection, id string, data map[string]interface{}) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if err := c.do("PUT", collection, id, nil, data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (c *dpdClient) Del(collection, id string) error {
+	return c.do("DELETE", collection, id, nil, nil, nil)
+}
+
+// do runs an internal request against a collection and decodes its JSON response
+func (c *dpdClient) do(method, name, id string, query, body map[string]interface{}, result interface{}) error {
+	if c.depth >= MaxDpdDepth {
+		return &events.ScriptError{
+			Message:    fmt.Sprintf("dpd call depth limit of %d exceeded", MaxDpdDepth),
+			StatusCode: http.StatusLoopDetected,
+		}
+	}
+
+	collection := c.router.getCollectionResource(name)
+	if collection == nil {
+		return &events.ScriptError{
+			Message:    fmt.Sprintf("collection not found: %s", name),
+			StatusCode: http.StatusNotFound,
+		}
+	}
+
+	parent := c.caller.Context()
+	if parent == nil {
+		parent = stdcontext.Background()
+	}
+	reqCtx := stdcontext.WithValue(parent, dpdDepthKey{}, c.depth+1)
+
+	path := collection.GetPath()
+	if id != "" {
+		path += "/" + url.PathEscape(id)
+	}
+	req, err := http.NewRequestWithContext(reqCtx, method, path, nil)
+	if err != nil {
+		return err
+	}
+
+	// Internal requests act on behalf of the user that triggered the event
+	recorder := httptest.NewRecorder()
+	ctx := context.New(req, recorder, collection, &context.AuthData{
+		UserID:          c.caller.UserID,
+		Username:        c.caller.Username,
+		IsRoot:          c.caller.IsRoot,
+		IsAuthenticated: c.caller.IsAuthenticated,
+		Roles:           c.caller.Roles,
+	}, c.caller.Development)
+	ctx.Internal = true
+
+	// Query and body are passed through as-is so values keep their types
+	ctx.Query = copyMap(query)
+	ctx.Body = copyMap(body)
+
+	if err := collection.Handle(ctx); err != nil {
+		return err
+	}
+
+	if recorder.Code >= 400 {
+		var errorResponse struct {
+			Message string `json:"message"`
+		}
+		message := http.StatusText(recorder.Code)
+		if json.Unmarshal(recorder.Body.Bytes(), &errorResponse) == nil && errorResponse.Message != "" {
+			message = errorResponse.Message
+		}
+		return &events.ScriptError{Message: message, StatusCode: recorder.Code}
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", name, err)
+	}
+	return nil
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
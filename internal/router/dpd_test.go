@@ -0,0 +1,145 @@
+package router_test
+
+import (
+	"bytes"
+	stdcontext "context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hjanuschka/go-deployd/internal/database"
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeDpdTestCollection(t *testing.T, configDir, name, config, postScript string) {
+	dir := filepath.Join(configDir, name)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0644))
+	if postScript != "" {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "post.js"), []byte(postScript), 0644))
+	}
+}
+
+func postJSON(t *testing.T, r http.Handler, path string, body map[string]interface{}) (int, map[string]interface{}) {
+	return requestJSON(t, r, "POST", path, body)
+}
+
+func putJSON(t *testing.T, r http.Handler, path string, body map[string]interface{}) (int, map[string]interface{}) {
+	return requestJSON(t, r, "PUT", path, body)
+}
+
+func requestJSON(t *testing.T, r http.Handler, method, path string, body map[string]interface{}) (int, map[string]interface{}) {
+	payload, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	var result map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	return rr.Code, result
+}
+
+func TestRouterDpd(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "orders",
+		`{"properties": {"item": {"type": "string"}, "auditId": {"type": "string"}, "auditError": {"type": "number"}},
+		  "eventConfig": {"post": {"runtime": "js"}}}`,
+		`function Run(context) {
+			var entry = dpd.audit.post({action: 'order', item: context.data.item});
+			context.data.auditId = entry.id;
+			try {
+				dpd.audit.post({item: 'missing action'});
+			} catch (e) {
+				context.data.auditError = e.statusCode;
+			}
+		}`)
+	writeDpdTestCollection(t, configDir, "audit",
+		`{"properties": {"action": {"type": "string", "required": true}, "item": {"type": "string"}, "stamped": {"type": "boolean"}},
+		  "eventConfig": {"post": {"runtime": "js"}}}`,
+		`function Run(context) {
+			context.data.stamped = context.internal;
+		}`)
+	writeDpdTestCollection(t, configDir, "loops",
+		`{"properties": {"depth": {"type": "number"}, "limit": {"type": "string"}},
+		  "eventConfig": {"post": {"runtime": "js"}}}`,
+		`function Run(context) {
+			try {
+				context.data.depth = dpd.loops.post({}).depth + 1;
+			} catch (e) {
+				context.data.depth = 0;
+				context.data.limit = e.message;
+			}
+		}`)
+
+	writeDpdTestCollection(t, configDir, "signups",
+		`{"properties": {"name": {"type": "string"}, "userId": {"type": "string"}, "username": {"type": "string"}},
+		  "eventConfig": {"post": {"runtime": "js"}}}`,
+		`function Run(context) {
+			var user = dpd.users.post({username: context.data.name, email: context.data.name + '@example.com', password: 'secret123'});
+			context.data.userId = user.id;
+			context.data.username = dpd.users.get(user.id).username;
+		}`)
+
+	r := router.New(db, true, configDir)
+
+	t.Run("runs the target collection's events and validation", func(t *testing.T) {
+		status, order := postJSON(t, r, "/orders", map[string]interface{}{"item": "book"})
+		require.Equal(t, http.StatusOK, status, order)
+		assert.Equal(t, float64(http.StatusBadRequest), order["auditError"])
+
+		auditID, ok := order["auditId"].(string)
+		require.True(t, ok, order)
+
+		req := httptest.NewRequest("GET", "/audit/"+auditID, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &entry))
+		assert.Equal(t, "order", entry["action"])
+		assert.Equal(t, "book", entry["item"])
+		assert.Equal(t, true, entry["stamped"])
+	})
+
+	t.Run("stops recursion at the depth limit", func(t *testing.T) {
+		status, doc := postJSON(t, r, "/loops", map[string]interface{}{})
+		require.Equal(t, http.StatusOK, status, doc)
+		assert.Equal(t, float64(router.MaxDpdDepth), doc["depth"])
+
+		req := httptest.NewRequest("GET", "/loops", nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var docs []map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &docs))
+		assert.Len(t, docs, router.MaxDpdDepth+1)
+	})
+	t.Run("reaches the built-in users collection", func(t *testing.T) {
+		require.NotNil(t, r.GetCollection("users"))
+
+		status, signup := postJSON(t, r, "/signups", map[string]interface{}{"name": "ada"})
+		require.Equal(t, http.StatusOK, status, signup)
+		assert.NotEmpty(t, signup["userId"])
+		assert.Equal(t, "ada", signup["username"])
+
+		users, err := db.CreateStore("users").Find(stdcontext.Background(),
+			database.NewQueryBuilder().Where("username", "$eq", "ada"), database.QueryOptions{})
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		assert.NotEqual(t, "secret123", users[0]["password"], "passwords are hashed on registration")
+	})
+}
@@ -0,0 +1,213 @@
+package router_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hjanuschka/go-deployd/internal/resources"
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/swagger"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeEndpoint writes an endpoint's config.json and its handler scripts,
+// keyed by file name
+func writeEndpoint(t *testing.T, configDir, name, config string, scripts map[string]string) {
+	dir := filepath.Join(configDir, name)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0644))
+	for file, script := range scripts {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, file), []byte(script), 0644))
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "invoices", `{
+		"properties": {"amount": {"type": "number"}, "paid": {"type": "boolean"}, "method": {"type": "string"}}
+	}`, "")
+	writeEndpoint(t, configDir, "billing", `{
+		"type": "endpoint",
+		"routes": [
+			{
+				"method": "POST", "path": "/:invoiceId/pay", "handler": "pay", "status": 201,
+				"summary": "Pay an invoice",
+				"request": {"properties": {"method": {"type": "string", "required": true, "enum": ["card", "wire"]}}},
+				"response": {"type": "object", "properties": {"id": {"type": "string"}, "paid": {"type": "boolean"}}}
+			},
+			{"method": "get", "path": "/:invoiceId/receipt", "handler": "receipt"},
+			{"method": "DELETE", "path": "/:invoiceId", "handler": "void"},
+			{"method": "POST", "path": "/hooks/psp", "handler": "hook"}
+		]
+	}`, map[string]string{
+		"pay.js": `
+			var invoice;
+			try {
+				invoice = dpd.invoices.get(context.data.params.invoiceId);
+			} catch (e) {
+				context.cancel('invoice not found', 404);
+			}
+			if (invoice.paid) {
+				context.cancel('already paid', 409);
+			}
+			dpd.invoices.put(invoice.id, {paid: true, method: context.data.body.method});
+			context.data.response.headers['Location'] = '/invoices/' + invoice.id;
+			context.data.response.body = {id: invoice.id, paid: true};
+		`,
+		"receipt.js": `
+			var invoice = dpd.invoices.get(context.data.params.invoiceId);
+			context.data.response.headers['Content-Type'] = 'text/csv';
+			context.data.response.headers['X-Format'] = context.data.query.format || 'csv';
+			context.data.response.body = 'id,amount\n' + invoice.id + ',' + invoice.amount + '\n';
+		`,
+		"void.js": `
+			dpd.invoices.del(context.data.params.invoiceId);
+			throw new Error('voiding is not supported');
+		`,
+		"hook.js": `
+			if (context.data.headers['x-signature'] !== 'sig') {
+				context.cancel('bad signature', 401);
+			}
+			dpd.invoices.post({amount: JSON.parse(context.data.rawBody).amount});
+			context.data.response.status = 204;
+		`,
+	})
+	writeEndpoint(t, configDir, "no_routes", `{"type": "endpoint", "routes": []}`, nil)
+	writeEndpoint(t, configDir, "no_script", `{"type": "endpoint", "routes": [{"method": "GET", "path": "/", "handler": "index"}]}`, nil)
+	writeEndpoint(t, configDir, "bad_method", `{"type": "endpoint", "routes": [{"method": "TRACE", "path": "/", "handler": "index"}]}`,
+		map[string]string{"index.js": ``})
+	writeEndpoint(t, configDir, "bad_handler", `{"type": "endpoint", "routes": [{"method": "GET", "path": "/", "handler": "../index"}]}`, nil)
+
+	r := router.New(db, true, configDir)
+	defer r.Close()
+
+	var names []string
+	for _, resource := range r.GetResources() {
+		if _, ok := resource.(*resources.Endpoint); ok {
+			names = append(names, resource.GetName())
+		}
+	}
+	assert.Equal(t, []string{"billing"}, names, "invalid endpoints are not loaded")
+
+	request := func(method, path, contentType, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	status, invoice := postJSON(t, r, "/invoices", map[string]interface{}{"amount": 42})
+	require.Equal(t, http.StatusOK, status, invoice)
+	id := invoice["id"].(string)
+
+	t.Run("runs the handler of the matching route", func(t *testing.T) {
+		rr := request("POST", "/billing/"+id+"/pay", "application/json", `{"method": "card"}`, nil)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.Equal(t, "/invoices/"+id, rr.Header().Get("Location"))
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"id": "`+id+`", "paid": true}`, rr.Body.String())
+
+		rr = request("GET", "/invoices/"+id, "", "", nil)
+		assert.Contains(t, rr.Body.String(), `"method":"card"`)
+
+		rr = request("POST", "/billing/"+id+"/pay", "application/json", `{"method": "card"}`, nil)
+		assert.Equal(t, http.StatusConflict, rr.Code, rr.Body.String())
+		rr = request("POST", "/billing/missing/pay", "application/json", `{"method": "card"}`, nil)
+		assert.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), "invoice not found")
+	})
+
+	t.Run("writes raw bodies and headers", func(t *testing.T) {
+		rr := request("GET", "/billing/"+id+"/receipt?format=long", "", "", nil)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, "text/csv", rr.Header().Get("Content-Type"))
+		assert.Equal(t, "long", rr.Header().Get("X-Format"))
+		assert.Equal(t, "id,amount\n"+id+",42\n", rr.Body.String())
+	})
+
+	t.Run("passes unparsed bodies on", func(t *testing.T) {
+		rr := request("POST", "/billing/hooks/psp", "text/plain", `{"amount": 7}`, map[string]string{"X-Signature": "sig"})
+		require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+		assert.Empty(t, rr.Body.String())
+
+		rr = request("POST", "/billing/hooks/psp", "text/plain", `{"amount": 8}`, nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+
+		rr = request("GET", "/invoices?amount=7", "", "", nil)
+		var invoices []map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &invoices))
+		assert.Len(t, invoices, 1)
+	})
+
+	t.Run("checks request bodies against the schema", func(t *testing.T) {
+		status, body := postJSON(t, r, "/billing/"+id+"/pay", map[string]interface{}{})
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, map[string]interface{}{"method": "is required"}, body["errors"])
+
+		status, body = postJSON(t, r, "/billing/"+id+"/pay", map[string]interface{}{"method": "cash"})
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Contains(t, body["errors"], "method")
+	})
+
+	t.Run("rolls back writes of failed handlers", func(t *testing.T) {
+		rr := request("DELETE", "/billing/"+id, "", "", nil)
+		assert.Equal(t, http.StatusInternalServerError, rr.Code, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), "voiding is not supported")
+
+		rr = request("GET", "/invoices/"+id, "", "", nil)
+		assert.Equal(t, http.StatusOK, rr.Code, "the invoice was not deleted")
+	})
+
+	t.Run("rejects unknown routes and methods", func(t *testing.T) {
+		rr := request("GET", "/billing/"+id+"/refund", "", "", nil)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+
+		rr = request("PUT", "/billing/"+id, "", "", nil)
+		assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+		assert.Equal(t, "DELETE", rr.Header().Get("Allow"))
+	})
+
+	t.Run("documents routes in the API docs", func(t *testing.T) {
+		spec, err := swagger.NewGenerator("http://localhost", r.GetResources()).GenerateSpec()
+		require.NoError(t, err)
+		data, err := spec.ToJSON()
+		require.NoError(t, err)
+		var doc struct {
+			Paths map[string]map[string]struct {
+				Summary     string `json:"summary"`
+				Parameters  []map[string]interface{}
+				RequestBody map[string]interface{}            `json:"requestBody"`
+				Responses   map[string]map[string]interface{} `json:"responses"`
+			} `json:"paths"`
+		}
+		require.NoError(t, json.NewDecoder(bytes.NewReader(data)).Decode(&doc))
+
+		pay := doc.Paths["/billing/{invoiceId}/pay"]["post"]
+		assert.Equal(t, "Pay an invoice", pay.Summary)
+		require.Len(t, pay.Parameters, 1)
+		assert.Equal(t, "invoiceId", pay.Parameters[0]["name"])
+		assert.Contains(t, pay.RequestBody["content"], "application/json")
+		assert.Contains(t, pay.Responses, "201")
+		assert.Contains(t, string(data), `"enum": [`)
+
+		assert.Contains(t, doc.Paths["/billing/{invoiceId}"], "delete")
+		assert.Contains(t, doc.Paths["/billing/hooks/psp"], "post")
+		assert.Contains(t, doc.Paths, "/invoices", "collections are still documented")
+	})
+}
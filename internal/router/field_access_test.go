@@ -0,0 +1,140 @@
+package router_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/auth"
+	"github.com/hjanuschka/go-deployd/internal/config"
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterFieldAccess(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	dir := filepath.Join(configDir, "notes")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{
+		"ownerField": "owner",
+		"properties": {
+			"title":  {"type": "string"},
+			"owner":  {"type": "string"},
+			"secret": {"type": "string", "readable": "owner", "writable": "owner"},
+			"score":  {"type": "number", "writable": "never"},
+			"hash":   {"type": "string", "readable": "never"},
+			"review": {"type": "string", "readable": ["moderator"], "writable": ["moderator"]}
+		}
+	}`), 0644))
+
+	r := router.New(db, true, configDir)
+
+	securityConfig, err := config.LoadSecurityConfig(config.GetConfigDir())
+	require.NoError(t, err)
+	jwtManager := auth.NewJWTManager(securityConfig.JWTSecret, time.Hour)
+
+	token := func(userID string, isRoot bool, roles ...string) string {
+		signed, err := jwtManager.GenerateToken(userID, userID, isRoot, roles...)
+		require.NoError(t, err)
+		return signed
+	}
+	owner := token("user-1", false)
+	other := token("user-2", false)
+	moderator := token("user-3", false, "moderator")
+	root := token("root", true)
+
+	request := func(method, path, token string, body map[string]interface{}) (int, map[string]interface{}) {
+		var payload []byte
+		if body != nil {
+			payload, err = json.Marshal(body)
+			require.NoError(t, err)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		var result map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &result)
+		return rr.Code, result
+	}
+
+	status, note := request("POST", "/notes", owner, map[string]interface{}{
+		"title": "Groceries", "owner": "user-1", "secret": "s3cret", "hash": "abc",
+	})
+	require.Equal(t, http.StatusOK, status, note)
+	id := note["id"].(string)
+	assert.Equal(t, "s3cret", note["secret"])
+	assert.NotContains(t, note, "hash")
+
+	t.Run("rejects writes to protected fields", func(t *testing.T) {
+		status, _ := request("POST", "/notes", owner, map[string]interface{}{"title": "Cheat", "score": 10})
+		assert.Equal(t, http.StatusForbidden, status)
+
+		status, _ = request("POST", "/notes", owner, map[string]interface{}{"title": "Spoof", "owner": "user-2", "secret": "x"})
+		assert.Equal(t, http.StatusForbidden, status)
+
+		status, _ = request("PUT", "/notes/"+id, other, map[string]interface{}{"secret": "stolen"})
+		assert.Equal(t, http.StatusForbidden, status)
+
+		status, _ = request("PUT", "/notes/"+id, other, map[string]interface{}{"$set": map[string]interface{}{"review": "spam"}})
+		assert.Equal(t, http.StatusForbidden, status)
+	})
+
+	t.Run("allows permitted writes", func(t *testing.T) {
+		status, doc := request("PUT", "/notes/"+id, owner, map[string]interface{}{"secret": "changed"})
+		require.Equal(t, http.StatusOK, status, doc)
+		assert.Equal(t, "changed", doc["secret"])
+
+		status, doc = request("PUT", "/notes/"+id, moderator, map[string]interface{}{"review": "ok"})
+		require.Equal(t, http.StatusOK, status, doc)
+		assert.Equal(t, "ok", doc["review"])
+
+		status, doc = request("PUT", "/notes/"+id, root, map[string]interface{}{"score": 5})
+		require.Equal(t, http.StatusOK, status, doc)
+		assert.Equal(t, float64(5), doc["score"])
+
+		// Unchanged values sent back by the client are not writes
+		status, doc = request("PUT", "/notes/"+id, owner, map[string]interface{}{"title": "Groceries!", "score": 5})
+		require.Equal(t, http.StatusOK, status, doc)
+	})
+
+	t.Run("filters unreadable fields", func(t *testing.T) {
+		_, doc := request("GET", "/notes/"+id, owner, nil)
+		assert.Equal(t, "changed", doc["secret"])
+		assert.NotContains(t, doc, "review")
+		assert.NotContains(t, doc, "hash")
+
+		_, doc = request("GET", "/notes/"+id, other, nil)
+		assert.Equal(t, "Groceries!", doc["title"])
+		assert.NotContains(t, doc, "secret")
+
+		_, doc = request("GET", "/notes/"+id, moderator, nil)
+		assert.Equal(t, "ok", doc["review"])
+		assert.NotContains(t, doc, "secret")
+
+		_, doc = request("GET", "/notes/"+id, root, nil)
+		assert.Equal(t, "abc", doc["hash"])
+
+		req := httptest.NewRequest("GET", "/notes", nil)
+		req.Header.Set("Authorization", "Bearer "+other)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		var docs []map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &docs))
+		require.Len(t, docs, 1)
+		assert.NotContains(t, docs[0], "secret")
+		assert.NotContains(t, docs[0], "hash")
+	})
+}
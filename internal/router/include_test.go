@@ -0,0 +1,145 @@
+package router_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterIncludes(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "people", `{
+		"properties": {
+			"name":   {"type": "string"},
+			"secret": {"type": "string", "readable": "never"},
+			"hidden": {"type": "boolean"}
+		},
+		"relations": {
+			"todos": {"collection": "todos", "foreignKey": "ownerId", "limit": 2, "sort": {"order": 1}}
+		},
+		"eventConfig": {"get": {"runtime": "js"}}
+	}`, "")
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "people", "get.js"), []byte(`function Run(context) {
+		if (context.data.hidden) {
+			context.cancel("hidden", 404);
+		}
+	}`), 0644))
+	writeDpdTestCollection(t, configDir, "todos", `{
+		"properties": {
+			"title":    {"type": "string"},
+			"order":    {"type": "number"},
+			"ownerId":  {"type": "reference", "collection": "people"},
+			"reviewer": {"type": "reference", "collection": "people"}
+		}
+	}`, "")
+
+	r := router.New(db, true, configDir)
+
+	status, ada := postJSON(t, r, "/people", map[string]interface{}{"name": "Ada", "secret": "s3cret"})
+	require.Equal(t, http.StatusOK, status, ada)
+	status, grace := postJSON(t, r, "/people", map[string]interface{}{"name": "Grace"})
+	require.Equal(t, http.StatusOK, status, grace)
+	status, ghost := postJSON(t, r, "/people", map[string]interface{}{"name": "Ghost", "hidden": true})
+	require.Equal(t, http.StatusOK, status, ghost)
+
+	for i, todo := range []map[string]interface{}{
+		{"title": "one", "order": 1, "ownerId": ada["id"], "reviewer": grace["id"]},
+		{"title": "two", "order": 2, "ownerId": ada["id"]},
+		{"title": "three", "order": 3, "ownerId": ada["id"]},
+		{"title": "four", "order": 4, "ownerId": grace["id"]},
+		{"title": "five", "order": 5, "ownerId": ghost["id"]},
+	} {
+		status, result := postJSON(t, r, "/todos", todo)
+		require.Equal(t, http.StatusOK, status, "todo %d: %v", i, result)
+	}
+
+	get := func(path string, query url.Values) (int, interface{}) {
+		req := httptest.NewRequest("GET", path+"?"+query.Encode(), nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		var result interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		return rr.Code, result
+	}
+
+	t.Run("embeds referenced documents", func(t *testing.T) {
+		status, result := get("/todos", url.Values{"$include": {"owner,reviewer"}, "$sort": {`{"order": 1}`}})
+		require.Equal(t, http.StatusOK, status, result)
+		todos := result.([]interface{})
+		require.Len(t, todos, 5)
+
+		first := todos[0].(map[string]interface{})
+		owner := first["owner"].(map[string]interface{})
+		assert.Equal(t, "Ada", owner["name"])
+		assert.NotContains(t, owner, "secret", "readable rules apply to included documents")
+		assert.Equal(t, ada["id"], first["ownerId"])
+		assert.Equal(t, "Grace", first["reviewer"].(map[string]interface{})["name"], "references without an Id suffix are replaced")
+
+		second := todos[1].(map[string]interface{})
+		assert.Nil(t, second["reviewer"])
+
+		fifth := todos[4].(map[string]interface{})
+		assert.Contains(t, fifth, "owner")
+		assert.Nil(t, fifth["owner"], "documents hidden by the Get event are not embedded")
+	})
+
+	t.Run("embeds has-many relations with limits", func(t *testing.T) {
+		status, result := get("/people/"+ada["id"].(string), url.Values{"$include": {"todos"}})
+		require.Equal(t, http.StatusOK, status, result)
+		todos := result.(map[string]interface{})["todos"].([]interface{})
+		require.Len(t, todos, 2, "the relation's default limit applies")
+		assert.Equal(t, "one", todos[0].(map[string]interface{})["title"])
+		assert.Equal(t, "two", todos[1].(map[string]interface{})["title"])
+
+		status, result = get("/people", url.Values{"$include": {`{"todos": {"$limit": 1, "$sort": {"order": -1}}}`}})
+		require.Equal(t, http.StatusOK, status, result)
+		byName := make(map[string][]interface{})
+		for _, person := range result.([]interface{}) {
+			person := person.(map[string]interface{})
+			byName[person["name"].(string)] = person["todos"].([]interface{})
+		}
+		require.Len(t, byName["Ada"], 1)
+		assert.Equal(t, "three", byName["Ada"][0].(map[string]interface{})["title"])
+		require.Len(t, byName["Grace"], 1)
+		assert.Equal(t, "four", byName["Grace"][0].(map[string]interface{})["title"])
+	})
+
+	t.Run("supports includes in POST /query", func(t *testing.T) {
+		payload, err := json.Marshal(map[string]interface{}{
+			"query":   map[string]interface{}{"title": "four"},
+			"options": map[string]interface{}{"$include": []interface{}{"owner"}},
+		})
+		require.NoError(t, err)
+		req := httptest.NewRequest("POST", "/todos/query", bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var todos []map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &todos))
+		require.Len(t, todos, 1)
+		assert.Equal(t, "Grace", todos[0]["owner"].(map[string]interface{})["name"])
+	})
+
+	t.Run("rejects unknown includes", func(t *testing.T) {
+		status, _ := get("/todos", url.Values{"$include": {"assignee"}})
+		assert.Equal(t, http.StatusBadRequest, status)
+
+		status, _ = get("/people", url.Values{"$include": {`{"todos": {"$limit": 1000}}`}})
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+}
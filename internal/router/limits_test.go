@@ -0,0 +1,41 @@
+package router_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScriptLimitsOfCollections(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "spinners", `{
+		"properties": {"n": {"type": "number"}},
+		"eventConfig": {"post": {"runtime": "js"}},
+		"limits": {"timeout": 0.2}
+	}`, `if (context.data.n > 0) { while (true) {} }`)
+	writeDpdTestCollection(t, configDir, "unlimited", `{"properties": {}, "limits": {"timeout": -1}}`, "")
+
+	r := router.New(db, true, configDir)
+	defer r.Close()
+
+	var names []string
+	for _, resource := range r.GetResources() {
+		names = append(names, resource.GetName())
+	}
+	assert.NotContains(t, names, "unlimited", "collections with invalid limits are not loaded")
+
+	status, body := postJSON(t, r, "/spinners", map[string]interface{}{"n": 1})
+	assert.Equal(t, http.StatusServiceUnavailable, status, body)
+	assert.Contains(t, body["message"], "time limit")
+
+	status, body = postJSON(t, r, "/spinners", map[string]interface{}{"n": 0})
+	require.Equal(t, http.StatusOK, status, body)
+	assert.Equal(t, float64(0), body["n"])
+}
@@ -0,0 +1,175 @@
+package router_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterCursorPagination(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "items", `{
+		"properties": {
+			"title": {"type": "string"},
+			"rank":  {"type": "number"}
+		}
+	}`, "")
+
+	r := router.New(db, true, configDir)
+
+	// Equal ranks make id decide the order within a rank
+	for _, item := range []struct {
+		title string
+		rank  int
+	}{{"a", 1}, {"b", 2}, {"c", 2}, {"d", 2}, {"e", 3}, {"f", 4}, {"g", 4}} {
+		status, result := postJSON(t, r, "/items", map[string]interface{}{"title": item.title, "rank": item.rank})
+		require.Equal(t, http.StatusOK, status, result)
+	}
+
+	type envelope struct {
+		Data       []map[string]interface{} `json:"data"`
+		NextCursor *string                  `json:"nextCursor"`
+		PrevCursor *string                  `json:"prevCursor"`
+		TotalCount *int64                   `json:"totalCount"`
+	}
+	get := func(query url.Values) (int, envelope) {
+		req := httptest.NewRequest("GET", "/items?"+query.Encode(), nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		var page envelope
+		if rr.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page), rr.Body.String())
+		}
+		return rr.Code, page
+	}
+	ranks := func(docs []map[string]interface{}) []float64 {
+		var result []float64
+		for _, doc := range docs {
+			result = append(result, doc["rank"].(float64))
+		}
+		return result
+	}
+
+	t.Run("pages forward and backward", func(t *testing.T) {
+		query := url.Values{"$sort": {`{"rank": 1}`}, "$limit": {"3"}, "$envelope": {"true"}}
+		status, first := get(query)
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, []float64{1, 2, 2}, ranks(first.Data))
+		assert.Nil(t, first.PrevCursor)
+		require.NotNil(t, first.NextCursor)
+
+		// Inserting before the cursor does not shift the following pages
+		status, _ = postJSON(t, r, "/items", map[string]interface{}{"title": "early", "rank": 0})
+		require.Equal(t, http.StatusOK, status)
+
+		query.Set("$after", *first.NextCursor)
+		status, second := get(query)
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, []float64{2, 3, 4}, ranks(second.Data))
+		require.NotNil(t, second.NextCursor)
+		require.NotNil(t, second.PrevCursor)
+
+		query.Set("$after", *second.NextCursor)
+		status, third := get(query)
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, []float64{4}, ranks(third.Data))
+		assert.Nil(t, third.NextCursor)
+
+		seen := make(map[string]bool)
+		for _, page := range [][]map[string]interface{}{first.Data, second.Data, third.Data} {
+			for _, doc := range page {
+				assert.False(t, seen[doc["title"].(string)], "%s returned twice", doc["title"])
+				seen[doc["title"].(string)] = true
+			}
+		}
+		assert.Len(t, seen, 7)
+
+		query.Del("$after")
+		query.Set("$before", *second.PrevCursor)
+		status, back := get(query)
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, []float64{1, 2, 2}, ranks(back.Data))
+		assert.Equal(t, first.Data[2]["id"], back.Data[2]["id"])
+		assert.NotNil(t, back.NextCursor)
+		require.NotNil(t, back.PrevCursor, "the inserted item is still before this page")
+
+		query.Set("$before", *back.PrevCursor)
+		status, start := get(query)
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, []float64{0}, ranks(start.Data))
+		assert.Nil(t, start.PrevCursor)
+	})
+
+	t.Run("pages in descending order", func(t *testing.T) {
+		query := url.Values{"$sort": {`{"rank": -1}`}, "$limit": {"4"}, "$envelope": {"true"}}
+		status, first := get(query)
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, []float64{4, 4, 3, 2}, ranks(first.Data))
+
+		query.Set("$after", *first.NextCursor)
+		status, second := get(query)
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, []float64{2, 2, 1, 0}, ranks(second.Data))
+		assert.Nil(t, second.NextCursor)
+	})
+
+	t.Run("includes the total count on request", func(t *testing.T) {
+		status, page := get(url.Values{"rank": {`{"$gte": 2}`}, "$limit": {"2"}, "$totalCount": {"true"}})
+		require.Equal(t, http.StatusOK, status)
+		assert.Len(t, page.Data, 2)
+		require.NotNil(t, page.TotalCount)
+		assert.Equal(t, int64(6), *page.TotalCount)
+	})
+
+	t.Run("rejects invalid cursors", func(t *testing.T) {
+		status, _ := get(url.Values{"$after": {"not-a-cursor"}})
+		assert.Equal(t, http.StatusBadRequest, status)
+
+		_, first := get(url.Values{"$sort": {`{"rank": 1}`}, "$limit": {"2"}, "$envelope": {"true"}})
+		status, _ = get(url.Values{"$sort": {`{"title": 1}`}, "$after": {*first.NextCursor}})
+		assert.Equal(t, http.StatusBadRequest, status, "a cursor only continues the sort it came from")
+	})
+
+	t.Run("keeps plain arrays without the envelope", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/items?$limit=2", nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		var docs []map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &docs))
+		assert.Len(t, docs, 2)
+	})
+
+	t.Run("supports cursors in POST /query", func(t *testing.T) {
+		query := func(options map[string]interface{}) envelope {
+			payload, err := json.Marshal(map[string]interface{}{
+				"query":   map[string]interface{}{},
+				"options": options,
+			})
+			require.NoError(t, err)
+			req := httptest.NewRequest("POST", "/items/query", bytes.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+			var page envelope
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+			return page
+		}
+
+		first := query(map[string]interface{}{"$sort": map[string]interface{}{"rank": 1}, "$limit": 5, "$envelope": true})
+		assert.Equal(t, []float64{0, 1, 2, 2, 2}, ranks(first.Data))
+		second := query(map[string]interface{}{"$sort": map[string]interface{}{"rank": 1}, "$limit": 5, "$after": *first.NextCursor})
+		assert.Equal(t, []float64{3, 4, 4}, ranks(second.Data))
+	})
+}
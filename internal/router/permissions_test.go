@@ -0,0 +1,147 @@
+package router_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/auth"
+	"github.com/hjanuschka/go-deployd/internal/config"
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterPermissions(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	dir := filepath.Join(configDir, "articles")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{
+		"properties": {"title": {"type": "string"}},
+		"permissions": {"get": "public", "post": "authenticated", "put": ["editor", "admin"], "delete": "root"},
+		"eventConfig": {"beforerequest": {"runtime": "js"}}
+	}`), 0644))
+
+	// A BeforeRequest event that would cancel every request proves permissions run first
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "beforerequest.js"), []byte(`function Run(context) { context.cancel("BeforeRequest ran", 418); }`), 0644))
+
+	r := router.New(db, true, configDir)
+
+	securityConfig, err := config.LoadSecurityConfig(config.GetConfigDir())
+	require.NoError(t, err)
+	jwtManager := auth.NewJWTManager(securityConfig.JWTSecret, time.Hour)
+
+	token := func(isRoot bool, roles ...string) string {
+		signed, err := jwtManager.GenerateToken("user-1", "jane", isRoot, roles...)
+		require.NoError(t, err)
+		return signed
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{name: "public get", method: "GET", path: "/articles", status: http.StatusTeapot},
+		{name: "anonymous post", method: "POST", path: "/articles", status: http.StatusUnauthorized},
+		{name: "anonymous query", method: "POST", path: "/articles/query", status: http.StatusTeapot},
+		{name: "authenticated post", method: "POST", path: "/articles", token: token(false), status: http.StatusTeapot},
+		{name: "put without role", method: "PUT", path: "/articles/a1", token: token(false, "user"), status: http.StatusForbidden},
+		{name: "put with role", method: "PUT", path: "/articles/a1", token: token(false, "user", "editor"), status: http.StatusTeapot},
+		{name: "delete without root", method: "DELETE", path: "/articles/a1", token: token(false, "admin"), status: http.StatusForbidden},
+		{name: "delete as root", method: "DELETE", path: "/articles/a1", token: token(true), status: http.StatusTeapot},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewReader([]byte(`{"title": "Hello"}`)))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.status, rr.Code, rr.Body.String())
+		})
+	}
+}
+
+func TestUserRegistrationPermissions(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	securityConfig, err := config.LoadSecurityConfig(config.GetConfigDir())
+	require.NoError(t, err)
+	jwtManager := auth.NewJWTManager(securityConfig.JWTSecret, time.Hour)
+	adminToken, err := jwtManager.GenerateToken("admin-1", "boss", false, "admin")
+	require.NoError(t, err)
+	userToken, err := jwtManager.GenerateToken("user-1", "jane", false, "user")
+	require.NoError(t, err)
+
+	send := func(r http.Handler, method, path, token, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		var doc map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &doc)
+		return rr.Code, doc
+	}
+
+	r := router.New(db, true, t.TempDir())
+
+	t.Run("strips roles from anonymous registrations", func(t *testing.T) {
+		status, user := send(r, "POST", "/users", "",
+			`{"username": "mallory", "email": "mallory@example.com", "password": "secret123", "role": "admin", "roles": ["admin"]}`)
+		require.Equal(t, http.StatusOK, status, user)
+		assert.Equal(t, "user", user["role"])
+		assert.NotContains(t, user, "roles")
+	})
+
+	t.Run("rejects role changes by non-admins", func(t *testing.T) {
+		status, user := send(r, "POST", "/users", "",
+			`{"username": "eve", "email": "eve@example.com", "password": "secret123"}`)
+		require.Equal(t, http.StatusOK, status, user)
+
+		status, body := send(r, "PUT", "/users/"+user["id"].(string), userToken, `{"roles": ["admin"]}`)
+		assert.Equal(t, http.StatusForbidden, status, body)
+	})
+
+	t.Run("lets admins register users with roles", func(t *testing.T) {
+		status, user := send(r, "POST", "/users", adminToken,
+			`{"username": "ed", "email": "ed@example.com", "password": "secret123", "roles": ["editor"]}`)
+		require.Equal(t, http.StatusOK, status, user)
+		assert.Equal(t, []interface{}{"editor"}, user["roles"])
+	})
+
+	t.Run("honours the create permission", func(t *testing.T) {
+		configDir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(configDir, "users"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(configDir, "users", "config.json"),
+			[]byte(`{"permissions": {"post": ["admin"]}}`), 0644))
+		restricted := router.New(db, true, configDir)
+
+		status, body := send(restricted, "POST", "/users", "",
+			`{"username": "anon", "email": "anon@example.com", "password": "secret123"}`)
+		assert.Equal(t, http.StatusUnauthorized, status, body)
+
+		status, body = send(restricted, "POST", "/users", adminToken,
+			`{"username": "invited", "email": "invited@example.com", "password": "secret123"}`)
+		assert.Equal(t, http.StatusOK, status, body)
+	})
+}
@@ -0,0 +1,218 @@
+package router_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/events"
+	"github.com/hjanuschka/go-deployd/internal/resources"
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitForJobs waits until queue has n jobs with status and returns them
+func waitForJobs(t *testing.T, queue *resources.Queue, status string, n int) []*resources.Job {
+	var jobs []*resources.Job
+	require.Eventually(t, func() bool {
+		var err error
+		jobs, err = queue.Jobs(context.Background(), status, 100)
+		require.NoError(t, err)
+		return len(jobs) >= n
+	}, 10*time.Second, 20*time.Millisecond, "%d %s jobs of %s", n, status, queue.GetName())
+	return jobs
+}
+
+func TestQueues(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "photos", `{
+		"properties": {"name": {"type": "string"}, "jobId": {"type": "string"}, "delay": {"type": "number"}},
+		"eventConfig": {"post": {"runtime": "js"}}
+	}`, `function Run(context) {
+		try {
+			context.data.jobId = context.enqueue('thumbnails', {name: context.data.name}, {delay: context.data.delay || 0});
+		} catch (e) {
+			context.cancel(e.message, e.statusCode);
+		}
+	}`)
+	writeDpdTestCollection(t, configDir, "orders", `{
+		"properties": {"item": {"type": "string"}},
+		"eventConfig": {"post": {"runtime": "js"}}
+	}`, `function Run(context) {
+		try {
+			context.enqueue('missing', {});
+		} catch (e) {
+			context.cancel(e.message, e.statusCode);
+		}
+	}`)
+	writeDpdTestCollection(t, configDir, "thumbs", `{
+		"properties": {"name": {"type": "string"}, "jobId": {"type": "string"}, "attempt": {"type": "number"}}
+	}`, "")
+	writeCronJob(t, configDir, "thumbnails", `{"type": "queue", "concurrency": 2}`, `
+		dpd.thumbs.post({name: context.data.payload.name, jobId: context.data.jobId, attempt: context.data.attempt});
+	`)
+	writeCronJob(t, configDir, "flaky", `{"type": "queue", "maxAttempts": 3, "retryDelay": 0.1}`, `
+		if (context.data.attempt < 2) {
+			throw new Error('not yet');
+		}
+	`)
+	writeCronJob(t, configDir, "broken", `{"type": "queue", "maxAttempts": 2, "retryDelay": 0.05}`, `
+		context.cancel('out of ink');
+	`)
+	writeCronJob(t, configDir, "bad_runtime", `{"type": "queue", "runtime": "ruby"}`, `dpd.thumbs.post({});`)
+	writeCronJob(t, configDir, "no_script", `{"type": "queue"}`, "")
+
+	r := router.New(db, true, configDir)
+	defer r.Close()
+
+	var names []string
+	for _, queue := range r.GetQueues() {
+		names = append(names, queue.GetName())
+	}
+	assert.ElementsMatch(t, []string{"thumbnails", "flaky", "broken"}, names, "invalid queues are not loaded")
+	assert.Nil(t, r.GetCollection("thumbnails"))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/thumbnails", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "queues are not served over HTTP")
+
+	r.StartQueues()
+
+	t.Run("runs jobs enqueued by events", func(t *testing.T) {
+		status, photo := postJSON(t, r, "/photos", map[string]interface{}{"name": "cat.png"})
+		require.Equal(t, http.StatusOK, status, photo)
+		jobID, ok := photo["jobId"].(string)
+		require.True(t, ok, photo)
+
+		jobs := waitForJobs(t, r.GetQueue("thumbnails"), resources.JobSucceeded, 1)
+		assert.Equal(t, jobID, jobs[0].ID)
+		assert.Equal(t, 1, jobs[0].Attempts)
+		assert.Equal(t, map[string]interface{}{"name": "cat.png"}, jobs[0].Payload)
+		assert.NotNil(t, jobs[0].FinishedAt)
+		assert.Nil(t, jobs[0].RunAt)
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/thumbs?jobId="+jobID, nil))
+		assert.Contains(t, rr.Body.String(), `"name":"cat.png"`, "the worker calls collections through dpd")
+	})
+
+	t.Run("delays jobs", func(t *testing.T) {
+		status, photo := postJSON(t, r, "/photos", map[string]interface{}{"name": "dog.png", "delay": 0.5})
+		require.Equal(t, http.StatusOK, status, photo)
+		job, err := r.GetQueue("thumbnails").Job(context.Background(), photo["jobId"].(string))
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		assert.Equal(t, resources.JobPending, job.Status)
+		require.NotNil(t, job.RunAt)
+		assert.True(t, job.RunAt.After(job.EnqueuedAt.Add(400*time.Millisecond)))
+
+		require.Eventually(t, func() bool {
+			job, err := r.GetQueue("thumbnails").Job(context.Background(), photo["jobId"].(string))
+			require.NoError(t, err)
+			return job.Status == resources.JobSucceeded
+		}, 5*time.Second, 20*time.Millisecond)
+	})
+
+	t.Run("rejects bad enqueues", func(t *testing.T) {
+		status, body := postJSON(t, r, "/photos", map[string]interface{}{"name": "x", "delay": -1})
+		assert.Equal(t, http.StatusBadRequest, status, body)
+		assert.Contains(t, fmt.Sprint(body), "delay")
+
+		status, body = postJSON(t, r, "/orders", map[string]interface{}{})
+		assert.Equal(t, http.StatusNotFound, status, body)
+		assert.Contains(t, fmt.Sprint(body), "queue not found: missing")
+	})
+
+	t.Run("retries with backoff", func(t *testing.T) {
+		job, err := r.GetQueue("flaky").Enqueue(context.Background(), map[string]interface{}{"n": 1}, events.EnqueueOptions{})
+		require.NoError(t, err)
+		jobs := waitForJobs(t, r.GetQueue("flaky"), resources.JobSucceeded, 1)
+		assert.Equal(t, job.ID, jobs[0].ID)
+		assert.Equal(t, 2, jobs[0].Attempts)
+		assert.Empty(t, jobs[0].Error)
+	})
+
+	t.Run("fails after the last attempt and requeues", func(t *testing.T) {
+		queue := r.GetQueue("broken")
+		enqueued, err := queue.Enqueue(context.Background(), nil, events.EnqueueOptions{MaxAttempts: 3})
+		require.NoError(t, err)
+		id := enqueued.ID
+		jobs := waitForJobs(t, queue, resources.JobFailed, 1)
+		assert.Equal(t, id, jobs[0].ID)
+		assert.Equal(t, 3, jobs[0].Attempts, "the job's own attempts override the queue's")
+		assert.Equal(t, "out of ink", jobs[0].Error)
+
+		missing, err := queue.Requeue(context.Background(), "missing")
+		assert.NoError(t, err)
+		assert.Nil(t, missing)
+		job, err := queue.Requeue(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, resources.JobPending, job.Status)
+		assert.Equal(t, 0, job.Attempts)
+
+		require.Eventually(t, func() bool {
+			job, err := queue.Job(context.Background(), id)
+			require.NoError(t, err)
+			return job.Status == resources.JobFailed && job.Attempts == 3
+		}, 5*time.Second, 20*time.Millisecond)
+		info, err := queue.Info(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), info.Jobs[resources.JobFailed])
+	})
+}
+
+func TestQueueSharedByServers(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "results", `{
+		"properties": {"jobId": {"type": "string", "unique": true}}
+	}`, "")
+	writeCronJob(t, configDir, "work", `{"type": "queue"}`, `
+		var end = Date.now() + 200;
+		while (Date.now() < end) {}
+		dpd.results.post({jobId: context.data.jobId});
+	`)
+
+	// Two servers sharing a database
+	first := router.New(db, true, configDir)
+	second := router.New(db, true, configDir)
+	defer first.Close()
+	defer second.Close()
+	first.StartQueues()
+	second.StartQueues()
+
+	// One job at a time takes the first server longer than the second one
+	// takes to look for jobs
+	ids := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		job, err := first.GetQueue("work").Enqueue(context.Background(), map[string]interface{}{"i": i}, events.EnqueueOptions{})
+		require.NoError(t, err)
+		ids[job.ID] = true
+	}
+
+	queue := first.GetQueue("work")
+	jobs := waitForJobs(t, queue, resources.JobSucceeded, len(ids))
+	nodes := make(map[string]bool)
+	for _, job := range jobs {
+		assert.True(t, ids[job.ID])
+		assert.Equal(t, 1, job.Attempts, "each job runs once")
+		nodes[job.Node] = true
+	}
+	assert.Len(t, nodes, 2, "both servers run jobs")
+
+	rr := httptest.NewRecorder()
+	first.ServeHTTP(rr, httptest.NewRequest("GET", "/results", nil))
+	var results []map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &results))
+	assert.Len(t, results, len(ids))
+}
@@ -1,12 +1,14 @@
 package router
 
 import (
+	stdcontext "context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
 	"time"
@@ -26,6 +28,9 @@ type Router struct {
 	configPath      string
 	jwtManager      *auth.JWTManager
 	realtimeEmitter events.RealtimeEmitter
+	webhooks        *resources.WebhookDispatcher
+	jobs            []*resources.CronJob
+	queues          []*resources.Queue
 }
 
 func New(db database.DatabaseInterface, development bool, configPath string) *Router {
@@ -51,6 +56,7 @@ func NewWithEmitter(db database.DatabaseInterface, development bool, configPath
 		jwtManager:      jwtManager,
 		realtimeEmitter: emitter,
 	}
+	r.webhooks = resources.NewWebhookDispatcher(db, r.includedCollection)
 
 	r.loadResources()
 
@@ -91,6 +97,7 @@ func (r *Router) loadResources() {
 		}
 
 		r.resources = append(r.resources, todosCollection)
+		r.attachDpd()
 		return
 	}
 
@@ -107,6 +114,45 @@ func (r *Router) loadResources() {
 
 			log.Printf("🔍 Found resource directory: %s", resourceName)
 			if _, err := os.Stat(configFile); err == nil {
+				resourceType, err := resources.ReadResourceType(path)
+				if err != nil {
+					log.Printf("❌ Failed to load resource %s: %v", resourceName, err)
+					return nil
+				}
+				if resourceType == resources.ResourceTypeCron {
+					log.Printf("⏰ Loading cron job %s from %s", resourceName, path)
+					job, err := resources.LoadCronJob(resourceName, path, r.db, r.realtimeEmitter)
+					if err != nil {
+						log.Printf("❌ Failed to load cron job %s: %v", resourceName, err)
+						return nil
+					}
+					log.Printf("✅ Successfully loaded cron job %s (%s)", resourceName, job.GetConfig().Schedule)
+					r.jobs = append(r.jobs, job)
+					return nil
+				}
+				if resourceType == resources.ResourceTypeQueue {
+					log.Printf("📬 Loading queue %s from %s", resourceName, path)
+					queue, err := resources.LoadQueue(resourceName, path, r.db, r.realtimeEmitter)
+					if err != nil {
+						log.Printf("❌ Failed to load queue %s: %v", resourceName, err)
+						return nil
+					}
+					log.Printf("✅ Successfully loaded queue %s", resourceName)
+					r.queues = append(r.queues, queue)
+					return nil
+				}
+				if resourceType == resources.ResourceTypeEndpoint {
+					log.Printf("🔀 Loading endpoint %s from %s", resourceName, path)
+					endpoint, err := resources.LoadEndpoint(resourceName, path, r.db, r.realtimeEmitter)
+					if err != nil {
+						log.Printf("❌ Failed to load endpoint %s: %v", resourceName, err)
+						return nil
+					}
+					log.Printf("✅ Successfully loaded endpoint %s (%d routes)", resourceName, len(endpoint.GetConfig().Routes))
+					r.resources = append(r.resources, endpoint)
+					return nil
+				}
+
 				log.Printf("📁 Loading collection %s from %s", resourceName, path)
 				// Load collection resource with emitter
 				collection, err := resources.LoadCollectionFromConfigWithEmitter(resourceName, path, r.db, r.realtimeEmitter)
@@ -129,13 +175,75 @@ func (r *Router) loadResources() {
 		log.Printf("Failed to load resources: %v", err)
 	}
 
+	r.attachDpd()
 	r.sortResources()
 }
 
+// attachDpd lets event scripts of all loaded resources, cron jobs and queues
+// call other collections and enqueue jobs through this router, lets $include
+// resolve references against them, and has their webhooks sent
+func (r *Router) attachDpd() {
+	for _, job := range r.jobs {
+		job.SetDpdProvider(r)
+		job.SetEnqueuer(r)
+	}
+	for _, queue := range r.queues {
+		queue.SetDpdProvider(r)
+		queue.SetEnqueuer(r)
+	}
+	for _, resource := range r.resources {
+		if collection, ok := resource.(interface{ SetDpdProvider(events.DpdProvider) }); ok {
+			collection.SetDpdProvider(r)
+		}
+		if collection, ok := resource.(interface{ SetEnqueuer(events.Enqueuer) }); ok {
+			collection.SetEnqueuer(r)
+		}
+		if collection, ok := resource.(interface {
+			SetCollectionResolver(resources.CollectionResolver)
+		}); ok {
+			collection.SetCollectionResolver(r.includedCollection)
+		}
+		if collection, ok := resource.(interface {
+			SetWebhookDispatcher(*resources.WebhookDispatcher)
+		}); ok {
+			collection.SetWebhookDispatcher(r.webhooks)
+		}
+	}
+}
+
+// StartCronJobs runs the cron jobs on their schedules until Close. Routers
+// that only read the resources, as backups do, don't start them.
+func (r *Router) StartCronJobs() {
+	for _, job := range r.jobs {
+		job.Start()
+	}
+}
+
+// StartQueues runs the jobs of the queues until Close. Like cron jobs, they
+// are not started by routers that only read the resources.
+func (r *Router) StartQueues() {
+	for _, queue := range r.queues {
+		queue.Start()
+	}
+}
+
+// Close stops sending webhooks, running cron jobs and running queued jobs,
+// and waits for the runs in progress. Deliveries and jobs left in their
+// queues are run once a router runs again.
+func (r *Router) Close() {
+	for _, job := range r.jobs {
+		job.Stop()
+	}
+	for _, queue := range r.queues {
+		queue.Stop()
+	}
+	r.webhooks.Stop()
+}
+
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// Enable CORS
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 	if req.Method == "OPTIONS" {
@@ -148,6 +256,7 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	isRoot := false
 	userID := ""
 	username := ""
+	var roles []string
 
 	// 1. Check JWT token authentication
 	authHeader := req.Header.Get("Authorization")
@@ -158,6 +267,7 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			isRoot = claims.IsRoot
 			userID = claims.UserID
 			username = claims.Username
+			roles = claims.Roles
 		}
 	}
 
@@ -189,6 +299,7 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		Username:        username,
 		IsRoot:          isRoot,
 		IsAuthenticated: isAuthenticated,
+		Roles:           roles,
 	}
 	ctx := context.New(req, w, resource, authData, r.development)
 
@@ -225,6 +336,7 @@ func (r *Router) GetResources() []resources.Resource {
 
 func (r *Router) AddResource(resource resources.Resource) {
 	r.resources = append(r.resources, resource)
+	r.attachDpd()
 	r.sortResources()
 }
 
@@ -235,6 +347,7 @@ func (r *Router) UpdateResource(name string, resource resources.Resource) {
 			break
 		}
 	}
+	r.attachDpd()
 	r.sortResources()
 }
 
@@ -247,11 +360,96 @@ func (r *Router) RemoveResource(name string) {
 	}
 }
 
+// GetCollection returns the collection with the given name, including the
+// built-in users collection, or nil
 func (r *Router) GetCollection(name string) *resources.Collection {
+	switch collection := r.getCollectionResource(name).(type) {
+	case *resources.Collection:
+		return collection
+	case *resources.UserCollection:
+		return collection.Collection
+	}
+	return nil
+}
+
+// getCollectionResource returns the resource of the collection with the
+// given name, or nil. Requests handled by it go through the users
+// collection's own handling, such as password hashing.
+func (r *Router) getCollectionResource(name string) resources.Resource {
+	for _, res := range r.resources {
+		if res.GetName() != name {
+			continue
+		}
+		switch res.(type) {
+		case *resources.Collection, *resources.UserCollection:
+			return res
+		}
+	}
+	return nil
+}
+
+// GetCronJobs returns the loaded cron jobs
+func (r *Router) GetCronJobs() []*resources.CronJob {
+	return r.jobs
+}
+
+// GetCronJob returns the cron job with the given name, or nil
+func (r *Router) GetCronJob(name string) *resources.CronJob {
+	for _, job := range r.jobs {
+		if job.GetName() == name {
+			return job
+		}
+	}
+	return nil
+}
+
+// GetQueues returns the loaded queues
+func (r *Router) GetQueues() []*resources.Queue {
+	return r.queues
+}
+
+// GetQueue returns the queue with the given name, or nil
+func (r *Router) GetQueue(name string) *resources.Queue {
+	for _, queue := range r.queues {
+		if queue.GetName() == name {
+			return queue
+		}
+	}
+	return nil
+}
+
+// Enqueue implements events.Enqueuer, adding a job to one of the queues in
+// the transaction of the request ctx
+func (r *Router) Enqueue(ctx *context.Context, name string, payload map[string]interface{}, opts events.EnqueueOptions) (string, error) {
+	queue := r.GetQueue(name)
+	if queue == nil {
+		return "", &events.ScriptError{
+			Message:    fmt.Sprintf("queue not found: %s", name),
+			StatusCode: http.StatusNotFound,
+		}
+	}
+	parent := ctx.Context()
+	if parent == nil {
+		parent = stdcontext.Background()
+	}
+	job, err := queue.Enqueue(parent, payload, opts)
+	if err != nil {
+		return "", err
+	}
+	return job.ID, nil
+}
+
+// includedCollection resolves the target of an $include. Unlike GetCollection
+// it also finds the users collection: includes only read through the
+// collection's store, so the users handler is not needed.
+func (r *Router) includedCollection(name string) *resources.Collection {
 	for _, res := range r.resources {
 		if res.GetName() == name {
-			if collection, ok := res.(*resources.Collection); ok {
+			switch collection := res.(type) {
+			case *resources.Collection:
 				return collection
+			case *resources.UserCollection:
+				return collection.Collection
 			}
 		}
 	}
@@ -295,10 +493,13 @@ func (r *Router) createBuiltinUsersCollection() {
 			Required: true,
 			System:   true, // Mark as system field
 		},
+		// Roles grant permissions, so only admins may write them. The
+		// field access rule is what protects them; the system flag only
+		// marks fields for the dashboard.
 		"role": {
-			Type:    "string",
-			Default: "user",
-			System:  true, // Mark as system field
+			Type:     "string",
+			Default:  "user",
+			Writable: &resources.FieldAccess{Roles: []string{"admin"}},
 		},
 		"active": {
 			Type:    "boolean",
@@ -310,6 +511,10 @@ func (r *Router) createBuiltinUsersCollection() {
 			Default: false,
 			System:  true, // Mark as system field
 		},
+		"roles": {
+			Type:     "array",
+			Writable: &resources.FieldAccess{Roles: []string{"admin"}},
+		},
 		"verificationToken": {
 			Type:   "string",
 			System: true, // Mark as system field
@@ -461,7 +666,8 @@ func (r *Router) propertiesEqual(a, b resources.Property) bool {
 		a.Required == b.Required &&
 		a.Unique == b.Unique &&
 		a.System == b.System &&
-		fmt.Sprintf("%v", a.Default) == fmt.Sprintf("%v", b.Default)
+		fmt.Sprintf("%v", a.Default) == fmt.Sprintf("%v", b.Default) &&
+		(b.Writable == nil || reflect.DeepEqual(a.Writable, b.Writable))
 }
 
 // saveCollectionConfig saves a collection configuration to disk
@@ -0,0 +1,289 @@
+package router_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hjanuschka/go-deployd/internal/auth"
+	"github.com/hjanuschka/go-deployd/internal/config"
+	"github.com/hjanuschka/go-deployd/internal/realtime"
+	"github.com/hjanuschka/go-deployd/internal/resources"
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wsClient reads the messages of a WebSocket connection, which may arrive
+// several to a frame
+type wsClient struct {
+	conn     *websocket.Conn
+	messages chan realtime.WebSocketMessage
+}
+
+func dialHub(t *testing.T, server *httptest.Server) *wsClient {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	client := &wsClient{conn: conn, messages: make(chan realtime.WebSocketMessage, 64)}
+	go func() {
+		defer close(client.messages)
+		for {
+			_, frame, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			for _, line := range bytes.Split(frame, []byte("\n")) {
+				var msg realtime.WebSocketMessage
+				if json.Unmarshal(line, &msg) == nil {
+					client.messages <- msg
+				}
+			}
+		}
+	}()
+	require.Equal(t, realtime.MessageTypeConnect, client.next(t).Type)
+	return client
+}
+
+func (c *wsClient) send(t *testing.T, msg map[string]interface{}) {
+	require.NoError(t, c.conn.WriteJSON(msg))
+}
+
+func (c *wsClient) next(t *testing.T) realtime.WebSocketMessage {
+	select {
+	case msg, ok := <-c.messages:
+		require.True(t, ok, "connection closed")
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	return realtime.WebSocketMessage{}
+}
+
+func TestRealtimeQuerySubscriptions(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "todos", `{
+		"properties": {
+			"title":    {"type": "string"},
+			"ownerId":  {"type": "string"},
+			"archived": {"type": "boolean"},
+			"notes":    {"type": "string", "readable": "never"}
+		},
+		"ownerField": "ownerId",
+		"eventConfig": {"get": {"runtime": "js"}}
+	}`, "")
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "todos", "get.js"), []byte(`function Run(context) {
+		if (context.data.archived) {
+			context.cancel("archived", 404);
+		}
+		context.data.seen = true;
+	}`), 0644))
+	writeDpdTestCollection(t, configDir, "private", `{
+		"properties": {"title": {"type": "string"}},
+		"permissions": {"get": "authenticated"}
+	}`, "")
+
+	jwtManager := auth.NewJWTManager("test-secret", time.Hour)
+	hub := realtime.NewHub(jwtManager, config.DefaultRealtimeConfig())
+	go hub.Run()
+	r := router.NewWithEmitter(db, true, configDir, hub)
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleWebSocket))
+	defer server.Close()
+
+	token, err := jwtManager.GenerateToken("u1", "alice", false)
+	require.NoError(t, err)
+	alice := dialHub(t, server)
+	alice.send(t, map[string]interface{}{"type": "auth", "token": token})
+	require.Equal(t, realtime.MessageTypeAuth, alice.next(t).Type)
+
+	t.Run("rejects subscriptions the user may not make", func(t *testing.T) {
+		anonymous := dialHub(t, server)
+		for _, sub := range []map[string]interface{}{
+			{"collection": "private"},
+			{"collection": "todos", "query": map[string]interface{}{"$where": "true"}},
+			{"collection": "missing"},
+			{},
+		} {
+			sub["type"] = "subscribe"
+			anonymous.send(t, sub)
+			msg := anonymous.next(t)
+			assert.Equal(t, realtime.MessageTypeError, msg.Type, sub)
+			assert.NotEmpty(t, msg.Error)
+		}
+	})
+
+	alice.send(t, map[string]interface{}{"type": "subscribe", "id": "mine", "collection": "todos", "query": map[string]interface{}{"ownerId": "u1"}})
+	subscribed := alice.next(t)
+	require.Equal(t, realtime.MessageTypeSubscribed, subscribed.Type, subscribed.Error)
+	assert.Equal(t, "mine", subscribed.Data.(map[string]interface{})["id"])
+	// Hidden fields can't be tested by queries
+	alice.send(t, map[string]interface{}{"type": "subscribe", "id": "probe", "collection": "todos", "query": map[string]interface{}{"notes": "secret"}})
+	require.Equal(t, realtime.MessageTypeSubscribed, alice.next(t).Type)
+
+	t.Run("delivers readable documents matching the query", func(t *testing.T) {
+		for _, todo := range []map[string]interface{}{
+			{"title": "Theirs", "ownerId": "u2"},
+			{"title": "Archived", "ownerId": "u1", "archived": true},
+			{"title": "Mine", "ownerId": "u1", "notes": "secret"},
+		} {
+			status, result := postJSON(t, r, "/todos", todo)
+			require.Equal(t, http.StatusOK, status, result)
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		msg := alice.next(t)
+		assert.Equal(t, realtime.MessageTypeEmit, msg.Type)
+		assert.Equal(t, "created", msg.Event)
+		assert.Equal(t, "collection:todos", msg.Room)
+		assert.Equal(t, []interface{}{"mine"}, msg.Meta["subscriptions"])
+		doc := msg.Data.(map[string]interface{})
+		assert.Equal(t, "Mine", doc["title"])
+		assert.Equal(t, true, doc["seen"], "the Get event ran")
+		assert.NotContains(t, doc, "notes")
+
+		select {
+		case msg := <-alice.messages:
+			t.Fatalf("unexpected message %+v", msg)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("sends changes to subscribed clients only", func(t *testing.T) {
+		alice.send(t, map[string]interface{}{"type": "unsubscribe", "id": "mine"})
+		alice.send(t, map[string]interface{}{"type": "unsubscribe", "id": "probe"})
+		alice.send(t, map[string]interface{}{"type": "unsubscribe", "id": "probe"})
+		assert.Equal(t, realtime.MessageTypeError, alice.next(t).Type, "probe is already gone")
+
+		bystander := dialHub(t, server)
+		watcher := dialHub(t, server)
+		watcher.send(t, map[string]interface{}{"type": "join", "room": "collection:todos"})
+		watcher.send(t, map[string]interface{}{"type": "join", "room": "collection:private"})
+		assert.Equal(t, realtime.MessageTypeError, watcher.next(t).Type, "the room of a collection it may not read")
+		time.Sleep(20 * time.Millisecond)
+
+		for _, todo := range []map[string]interface{}{
+			{"title": "Archived too", "ownerId": "u2", "archived": true},
+			{"title": "Theirs too", "ownerId": "u2", "notes": "secret"},
+		} {
+			status, result := postJSON(t, r, "/todos", todo)
+			require.Equal(t, http.StatusOK, status, result)
+			time.Sleep(20 * time.Millisecond)
+		}
+		status, result := postJSON(t, r, "/private", map[string]interface{}{"title": "Hidden"})
+		require.Equal(t, http.StatusOK, status, result)
+
+		msg := watcher.next(t)
+		assert.Equal(t, "created", msg.Event)
+		assert.Equal(t, "collection:todos", msg.Room)
+		doc := msg.Data.(map[string]interface{})
+		assert.Equal(t, "Theirs too", doc["title"])
+		assert.Equal(t, true, doc["seen"], "the Get event ran")
+		assert.NotContains(t, doc, "notes")
+
+		for name, client := range map[string]*wsClient{"alice": alice, "bystander": bystander, "watcher": watcher} {
+			select {
+			case msg := <-client.messages:
+				t.Errorf("unexpected message to %s: %+v", name, msg)
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	})
+}
+
+func TestCollectionRealtimeRules(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "rooms", `{
+		"properties": {"name": {"type": "string"}},
+		"realtime": {
+			"rooms": [{"pattern": "rooms:*", "join": "authenticated"}],
+			"serverOnly": ["closed"]
+		}
+	}`, "")
+
+	hub := realtime.NewHub(auth.NewJWTManager("test-secret", time.Hour), config.DefaultRealtimeConfig())
+	go hub.Run()
+	router.NewWithEmitter(db, true, configDir, hub)
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleWebSocket))
+	defer server.Close()
+
+	anonymous := dialHub(t, server)
+	anonymous.send(t, map[string]interface{}{"type": "join", "room": "rooms:1"})
+	assert.Equal(t, "Authentication required to join room rooms:1", anonymous.next(t).Error)
+	anonymous.send(t, map[string]interface{}{"type": "emit", "event": "closed", "room": "rooms:1"})
+	assert.Equal(t, "Event closed can only be emitted by the server", anonymous.next(t).Error)
+
+	t.Run("refuses rules for rooms of other collections", func(t *testing.T) {
+		writeDpdTestCollection(t, configDir, "notes", `{
+			"properties": {"text": {"type": "string"}},
+			"realtime": {"rooms": [{"pattern": "rooms:*", "join": "root"}]}
+		}`, "")
+		_, err := resources.LoadCollectionFromConfig("notes", filepath.Join(configDir, "notes"), db)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `room pattern "rooms:*" is not a room of collection notes`)
+	})
+}
+
+func TestSubscriptionsWithAfterCommitEvent(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	// The AfterCommit event changes the document while it is sent to the
+	// subscribed client, which the race detector reports unless the change is
+	// sent a copy
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "orders", `{
+		"properties": {
+			"item":  {"type": "string"},
+			"lines": {"type": "array"}
+		},
+		"eventConfig": {"aftercommit": {"runtime": "js"}}
+	}`, "")
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "orders", "aftercommit.js"), []byte(`function Run(context) {
+		context.data.item = context.data.item + ' (processed)';
+		context.data.processed = true;
+		context.data.lines = [];
+	}`), 0644))
+
+	hub := realtime.NewHub(auth.NewJWTManager("test-secret", time.Hour), config.DefaultRealtimeConfig())
+	go hub.Run()
+	r := router.NewWithEmitter(db, true, configDir, hub)
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleWebSocket))
+	defer server.Close()
+
+	client := dialHub(t, server)
+	client.send(t, map[string]interface{}{"type": "subscribe", "id": "orders", "collection": "orders"})
+	require.Equal(t, realtime.MessageTypeSubscribed, client.next(t).Type)
+
+	const orders = 20
+	for i := 0; i < orders; i++ {
+		status, result := postJSON(t, r, "/orders", map[string]interface{}{
+			"item":  "order",
+			"lines": []interface{}{map[string]interface{}{"sku": "a", "quantity": i}},
+		})
+		require.Equal(t, http.StatusOK, status, result)
+	}
+
+	for i := 0; i < orders; i++ {
+		msg := client.next(t)
+		assert.Equal(t, "created", msg.Event)
+		doc := msg.Data.(map[string]interface{})
+		assert.Equal(t, "order", doc["item"], "the document as written")
+		assert.NotContains(t, doc, "processed")
+		assert.Len(t, doc["lines"], 1)
+	}
+}
@@ -0,0 +1,134 @@
+package router_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/resources"
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterWriteTransactions(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "orders",
+		`{"properties": {"item": {"type": "string"}, "note": {"type": "string"}},
+		  "eventConfig": {"post": {"runtime": "js"}}}`,
+		`function Run(context) {
+			dpd.audit.post({action: 'order', item: context.data.item});
+			if (context.data.item === 'bad') {
+				context.cancel('rejected', 400);
+			}
+			try {
+				dpd.audit.post({action: 'reject', item: context.data.item});
+			} catch (e) {
+				context.data.note = e.message;
+			}
+		}`)
+	writeDpdTestCollection(t, configDir, "audit",
+		`{"properties": {"action": {"type": "string"}, "item": {"type": "string"}},
+		  "eventConfig": {"post": {"runtime": "js"}, "aftercommit": {"runtime": "js"}}}`,
+		`function Run(context) {
+			if (context.data.action === 'reject') {
+				dpd.notes.post({text: 'partial ' + context.data.item});
+				context.cancel('audit rejected', 409);
+			}
+		}`)
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "audit", "aftercommit.js"), []byte(`function Run(context) {
+		dpd.notes.post({text: 'committed ' + context.data.action + ' ' + context.data.item});
+	}`), 0644))
+	writeDpdTestCollection(t, configDir, "notes", `{"properties": {"text": {"type": "string"}}}`, "")
+
+	r := router.New(db, true, configDir)
+
+	list := func(path, field string) []string {
+		req := httptest.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var docs []map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &docs))
+		values := []string{}
+		for _, doc := range docs {
+			values = append(values, doc[field].(string))
+		}
+		return values
+	}
+
+	t.Run("commits the writes of events with the request", func(t *testing.T) {
+		status, order := postJSON(t, r, "/orders", map[string]interface{}{"item": "book"})
+		require.Equal(t, http.StatusOK, status, order)
+		assert.Equal(t, "audit rejected", order["note"])
+
+		assert.Equal(t, []string{"book"}, list("/orders", "item"))
+		assert.Equal(t, []string{"order"}, list("/audit", "action"))
+		assert.Equal(t, []string{"committed order book"}, list("/notes", "text"),
+			"a cancelled nested write is rolled back with its AfterCommit event")
+	})
+
+	t.Run("rolls back the writes of events when the request fails", func(t *testing.T) {
+		status, result := postJSON(t, r, "/orders", map[string]interface{}{"item": "bad"})
+		require.Equal(t, http.StatusBadRequest, status, result)
+
+		assert.Equal(t, []string{"book"}, list("/orders", "item"))
+		assert.Equal(t, []string{"order"}, list("/audit", "action"))
+		assert.Equal(t, []string{"committed order book"}, list("/notes", "text"))
+	})
+}
+
+func TestRouterWriteTimeout(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	previous := resources.WriteTimeout
+	resources.WriteTimeout = 200 * time.Millisecond
+	defer func() { resources.WriteTimeout = previous }()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "slow",
+		`{"properties": {"item": {"type": "string"}}, "eventConfig": {"post": {"runtime": "js"}}}`,
+		`function Run(context) {
+			var end = Date.now() + 3000;
+			while (Date.now() < end) {}
+		}`)
+	writeDpdTestCollection(t, configDir, "notes", `{"properties": {"text": {"type": "string"}}}`, "")
+
+	r := router.New(db, true, configDir)
+
+	type response struct {
+		status int
+		body   map[string]interface{}
+	}
+	slow := make(chan response, 1)
+	go func() {
+		status, body := postJSON(t, r, "/slow", map[string]interface{}{"item": "book"})
+		slow <- response{status, body}
+	}()
+
+	// Once the slow request's transaction expired, other writers go ahead
+	// while its event is still running
+	time.Sleep(500 * time.Millisecond)
+	started := time.Now()
+	status, note := postJSON(t, r, "/notes", map[string]interface{}{"text": "meanwhile"})
+	require.Equal(t, http.StatusOK, status, note)
+	assert.Less(t, time.Since(started), time.Second)
+
+	result := <-slow
+	assert.Equal(t, http.StatusInternalServerError, result.status, result.body)
+	assert.Contains(t, result.body["message"], "rolled back")
+
+	req := httptest.NewRequest("GET", "/slow", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.JSONEq(t, `[]`, rr.Body.String())
+}
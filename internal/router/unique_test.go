@@ -0,0 +1,88 @@
+package router_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterUniqueProperties(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "accounts", `{
+		"properties": {
+			"email": {"type": "string", "unique": true},
+			"name":  {"type": "string"}
+		}
+	}`, "")
+
+	r := router.New(db, true, configDir)
+
+	status, first := postJSON(t, r, "/accounts", map[string]interface{}{"email": "ada@example.com", "name": "Ada"})
+	require.Equal(t, http.StatusOK, status, first)
+	status, second := postJSON(t, r, "/accounts", map[string]interface{}{"email": "grace@example.com", "name": "Grace"})
+	require.Equal(t, http.StatusOK, status, second)
+
+	t.Run("rejects duplicate inserts", func(t *testing.T) {
+		status, result := postJSON(t, r, "/accounts", map[string]interface{}{"email": "ada@example.com"})
+		require.Equal(t, http.StatusConflict, status, result)
+		assert.Equal(t, map[string]interface{}{"email": "must be unique"}, result["errors"])
+	})
+
+	t.Run("rejects duplicate updates", func(t *testing.T) {
+		payload, err := json.Marshal(map[string]interface{}{"email": "ada@example.com"})
+		require.NoError(t, err)
+		req := httptest.NewRequest("PUT", "/accounts/"+second["id"].(string), bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		var result map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		require.Equal(t, http.StatusConflict, rr.Code, result)
+		assert.Equal(t, map[string]interface{}{"email": "must be unique"}, result["errors"])
+	})
+
+	t.Run("documents without the field don't conflict", func(t *testing.T) {
+		status, _ := postJSON(t, r, "/accounts", map[string]interface{}{"name": "Anonymous"})
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = postJSON(t, r, "/accounts", map[string]interface{}{"name": "Anonymous"})
+		assert.Equal(t, http.StatusOK, status)
+	})
+}
+
+func TestRouterUniqueConflicts(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "members", `{"properties": {"handle": {"type": "string"}}}`, "")
+
+	r := router.New(db, true, configDir)
+	for _, handle := range []string{"ada", "ada", "grace"} {
+		status, result := postJSON(t, r, "/members", map[string]interface{}{"handle": handle})
+		require.Equal(t, http.StatusOK, status, result)
+	}
+
+	// Marking the field unique after duplicates exist leaves it unenforced and
+	// reports the conflicting values instead
+	writeDpdTestCollection(t, configDir, "members", `{"properties": {"handle": {"type": "string", "unique": true}}}`, "")
+	r = router.New(db, true, configDir)
+
+	conflicts, err := r.GetCollection("members").UniqueConflicts(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]interface{}{"handle": {"ada"}}, conflicts)
+
+	status, result := postJSON(t, r, "/members", map[string]interface{}{"handle": "grace"})
+	assert.Equal(t, http.StatusOK, status, result)
+}
@@ -0,0 +1,114 @@
+package router_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterValidationRules(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "signups", `{
+		"properties": {
+			"name":    {"type": "string", "required": true, "minLength": 2, "maxLength": 20},
+			"age":     {"type": "number", "min": 18, "max": 120},
+			"code":    {"type": "string", "pattern": "^[A-Z]{3}$"},
+			"plan":    {"type": "string", "enum": ["free", "pro"]},
+			"email":   {"type": "string", "format": "email"},
+			"website": {"type": "string", "format": "url"},
+			"ref":     {"type": "string", "format": "uuid"},
+			"startAt": {"type": "string", "format": "date-time"},
+			"tags":    {"type": "array", "maxLength": 3, "items": {"type": "string", "minLength": 1}},
+			"address": {"type": "object", "properties": {"zip": {"type": "string", "required": true, "pattern": "^[0-9]{5}$"}}},
+			"nickname": {"type": "string"}
+		},
+		"eventConfig": {"validate": {"runtime": "js"}}
+	}`, "")
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "signups", "validate.js"), []byte(`function Run(context) {
+		if (context.data.nickname === "admin") {
+			context.error("nickname", "is reserved");
+		}
+	}`), 0644))
+
+	r := router.New(db, true, configDir)
+
+	valid := map[string]interface{}{
+		"name":    "Ada",
+		"age":     36,
+		"code":    "ABC",
+		"plan":    "pro",
+		"email":   "ada@example.com",
+		"website": "https://example.com",
+		"ref":     "3f2b8c4e-8d1a-4c59-9b57-2f1d6c0e7a10",
+		"startAt": "2026-01-02T15:04:05Z",
+		"tags":    []interface{}{"math"},
+		"address": map[string]interface{}{"zip": "12345"},
+	}
+	status, doc := postJSON(t, r, "/signups", valid)
+	require.Equal(t, http.StatusOK, status, doc)
+
+	status, result := postJSON(t, r, "/signups", map[string]interface{}{
+		"name":    "A",
+		"age":     12,
+		"code":    "abc",
+		"plan":    "enterprise",
+		"email":   "not-an-email",
+		"website": "example.com",
+		"ref":     "1234",
+		"startAt": "tomorrow",
+		"tags":    []interface{}{"a", "", "c", "d"},
+		"address": map[string]interface{}{"zip": "ABCDE"},
+	})
+	require.Equal(t, http.StatusBadRequest, status, result)
+
+	errors, ok := result["errors"].(map[string]interface{})
+	require.True(t, ok, result)
+	for _, field := range []string{"name", "age", "code", "plan", "email", "website", "ref", "startAt", "tags", "address.zip"} {
+		assert.Contains(t, errors, field)
+	}
+
+	t.Run("validates array items and nested required fields", func(t *testing.T) {
+		status, result := postJSON(t, r, "/signups", map[string]interface{}{
+			"name":    "Grace",
+			"tags":    []interface{}{"ok", ""},
+			"address": map[string]interface{}{},
+		})
+		require.Equal(t, http.StatusBadRequest, status, result)
+		assert.Equal(t, map[string]interface{}{
+			"tags[1]":     "must have a length of at least 1",
+			"address.zip": "is required",
+		}, result["errors"])
+	})
+
+	t.Run("event errors use the same format", func(t *testing.T) {
+		status, result := postJSON(t, r, "/signups", map[string]interface{}{"name": "Eve", "nickname": "admin"})
+		require.Equal(t, http.StatusBadRequest, status, result)
+		assert.Equal(t, map[string]interface{}{"nickname": "is reserved"}, result["errors"])
+		assert.Equal(t, "validation errors: nickname: is reserved", result["message"])
+	})
+	t.Run("validates the fields of update commands", func(t *testing.T) {
+		id := doc["id"].(string)
+		for field, command := range map[string]map[string]interface{}{
+			"age":  {"$inc": map[string]interface{}{"age": 100}},
+			"plan": {"$set": map[string]interface{}{"plan": "enterprise"}},
+			"code": {"$set": map[string]interface{}{"code": "abc"}},
+		} {
+			status, result := putJSON(t, r, "/signups/"+id, command)
+			require.Equal(t, http.StatusBadRequest, status, result)
+			assert.Contains(t, result["errors"], field)
+		}
+
+		status, result := putJSON(t, r, "/signups/"+id, map[string]interface{}{"$inc": map[string]interface{}{"age": 1}})
+		require.Equal(t, http.StatusOK, status, result)
+		assert.Equal(t, float64(37), result["age"])
+	})
+}
@@ -0,0 +1,316 @@
+package router_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hjanuschka/go-deployd/internal/resources"
+	"github.com/hjanuschka/go-deployd/internal/router"
+	"github.com/hjanuschka/go-deployd/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookRequest is a request received by a webhook receiver
+type webhookRequest struct {
+	path    string
+	header  http.Header
+	body    []byte
+	payload map[string]interface{}
+}
+
+// webhookReceiver records webhook requests. Paths listed in failures answer
+// 500 that many times before they succeed.
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []webhookRequest
+	failures map[string]int
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	receiver := &webhookReceiver{failures: make(map[string]int)}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]interface{}
+		json.Unmarshal(body, &payload)
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		receiver.requests = append(receiver.requests, webhookRequest{path: r.URL.Path, header: r.Header, body: body, payload: payload})
+		if receiver.failures[r.URL.Path] > 0 {
+			receiver.failures[r.URL.Path]--
+			http.Error(w, "try again", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+// received returns the requests made to path
+func (r *webhookReceiver) received(path string) []webhookRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var requests []webhookRequest
+	for _, req := range r.requests {
+		if req.path == path {
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
+
+func (r *webhookReceiver) setFailures(path string, n int) {
+	r.mu.Lock()
+	r.failures[path] = n
+	r.mu.Unlock()
+}
+
+func TestRouterWebhooks(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	receiver := newWebhookReceiver(t)
+	receiver.setFailures("/flaky", 1)
+	receiver.setFailures("/down", 1000)
+
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "orders", fmt.Sprintf(`{
+		"properties": {
+			"total":  {"type": "number"},
+			"secret": {"type": "string", "readable": "never"}
+		},
+		"webhooks": [
+			{"url": "%[1]s/all", "secret": "s3cret"},
+			{"url": "%[1]s/large", "events": ["created"], "filter": {"total": {"$gt": 100}}},
+			{"url": "%[1]s/flaky", "events": ["created"], "maxAttempts": 3, "retryDelay": 0.05},
+			{"url": "%[1]s/down", "events": ["deleted"], "maxAttempts": 2, "retryDelay": 0.05}
+		]
+	}`, receiver.URL), "")
+
+	r := router.New(db, true, configDir)
+	defer r.Close()
+	send := func(method, path string, body map[string]interface{}) map[string]interface{} {
+		payload, err := json.Marshal(body)
+		require.NoError(t, err)
+		req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var result map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		return result
+	}
+	waitFor := func(t *testing.T, path string, n int) []webhookRequest {
+		require.Eventually(t, func() bool { return len(receiver.received(path)) >= n }, 5*time.Second, 10*time.Millisecond,
+			"%d requests to %s", n, path)
+		return receiver.received(path)
+	}
+
+	small := send("POST", "/orders", map[string]interface{}{"total": 50, "secret": "hidden"})
+	large := send("POST", "/orders", map[string]interface{}{"total": 150})
+	send("PUT", "/orders/"+small["id"].(string), map[string]interface{}{"total": 60})
+	send("DELETE", "/orders/"+large["id"].(string), nil)
+
+	t.Run("sends signed changes", func(t *testing.T) {
+		requests := waitFor(t, "/all", 4)
+		events := map[string]webhookRequest{}
+		for _, req := range requests {
+			events[req.payload["event"].(string)] = req
+			assert.Equal(t, resources.SignWebhook("s3cret", req.body), req.header.Get(resources.WebhookSignatureHeader))
+			assert.Equal(t, "application/json", req.header.Get("Content-Type"))
+			assert.Equal(t, "orders", req.header.Get(resources.WebhookCollectionHeader))
+			assert.Equal(t, req.payload["id"], req.header.Get(resources.WebhookDeliveryHeader))
+			assert.Equal(t, req.payload["event"], req.header.Get(resources.WebhookEventHeader))
+		}
+		require.Len(t, events, 3)
+
+		created := events["created"].payload
+		assert.Equal(t, "orders", created["collection"])
+		assert.NotContains(t, created["data"], "secret", "fields no one may read are left out")
+
+		updated := events["updated"].payload
+		assert.Equal(t, 60.0, updated["data"].(map[string]interface{})["total"])
+		assert.Equal(t, 50.0, updated["previous"].(map[string]interface{})["total"])
+
+		deleted := events["deleted"].payload
+		assert.Equal(t, large["id"], deleted["data"].(map[string]interface{})["id"])
+	})
+
+	t.Run("filters by event and query", func(t *testing.T) {
+		requests := waitFor(t, "/large", 1)
+		assert.Len(t, requests, 1)
+		assert.Equal(t, large["id"], requests[0].payload["data"].(map[string]interface{})["id"])
+		assert.Empty(t, requests[0].header.Get(resources.WebhookSignatureHeader), "unsigned without a secret")
+	})
+
+	orders := r.GetCollection("orders")
+	require.NotNil(t, orders)
+
+	t.Run("retries failed deliveries", func(t *testing.T) {
+		requests := waitFor(t, "/flaky", 3)
+		assert.Len(t, requests, 3, "the first delivery fails once")
+
+		var deliveries []*resources.WebhookDelivery
+		require.Eventually(t, func() bool {
+			var err error
+			deliveries, err = orders.WebhookDeliveries(context.Background(), resources.DeliveryDelivered, 100)
+			require.NoError(t, err)
+			return countURL(deliveries, receiver.URL+"/flaky") == 2
+		}, 5*time.Second, 10*time.Millisecond)
+		retried := 0
+		for _, delivery := range deliveries {
+			if delivery.URL != receiver.URL+"/flaky" || len(delivery.Attempts) == 1 {
+				continue
+			}
+			retried++
+			require.Len(t, delivery.Attempts, 2)
+			assert.Equal(t, http.StatusInternalServerError, delivery.Attempts[0].Status)
+			assert.Contains(t, delivery.Attempts[0].Error, "500")
+			assert.Equal(t, "try again\n", delivery.Attempts[0].Response)
+			assert.Equal(t, http.StatusOK, delivery.Attempts[1].Status)
+			assert.Empty(t, delivery.Attempts[1].Error)
+		}
+		assert.Equal(t, 1, retried)
+	})
+
+	t.Run("keeps dead letters and redelivers them", func(t *testing.T) {
+		var dead []*resources.WebhookDelivery
+		require.Eventually(t, func() bool {
+			var err error
+			dead, err = orders.WebhookDeliveries(context.Background(), resources.DeliveryDead, 100)
+			require.NoError(t, err)
+			return len(dead) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, receiver.URL+"/down", dead[0].URL)
+		assert.Len(t, dead[0].Attempts, 2)
+		assert.Nil(t, dead[0].NextAttempt)
+		assert.Len(t, receiver.received("/down"), 2)
+
+		receiver.setFailures("/down", 0)
+		redelivery, err := orders.RedeliverWebhook(context.Background(), dead[0].ID)
+		require.NoError(t, err)
+		assert.Equal(t, dead[0].ID, redelivery.RedeliveryOf)
+		requests := waitFor(t, "/down", 3)
+		assert.Equal(t, redelivery.ID, requests[2].payload["id"])
+		assert.Equal(t, "deleted", requests[2].payload["event"])
+
+		original, err := orders.WebhookDelivery(context.Background(), dead[0].ID)
+		require.NoError(t, err)
+		assert.Equal(t, resources.DeliveryDead, original.Status, "the original keeps its history")
+	})
+}
+
+func TestRouterWebhookValidation(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	configDir := t.TempDir()
+	for name, webhooks := range map[string]string{
+		"bad_url":    `[{"url": "ftp://example.com"}]`,
+		"bad_event":  `[{"url": "http://example.com", "events": ["read"]}]`,
+		"bad_filter": `[{"url": "http://example.com", "filter": {"$where": "true"}}]`,
+		"duplicate":  `[{"url": "http://example.com"}, {"url": "http://example.com"}]`,
+	} {
+		writeDpdTestCollection(t, configDir, name, `{"properties": {}, "webhooks": `+webhooks+`}`, "")
+	}
+	writeDpdTestCollection(t, configDir, "valid", `{"properties": {}, "webhooks": [{"url": "https://example.com/hook"}]}`, "")
+
+	r := router.New(db, true, configDir)
+	defer r.Close()
+	var loaded []string
+	for _, resource := range r.GetResources() {
+		if !strings.HasPrefix(resource.GetName(), "user") {
+			loaded = append(loaded, resource.GetName())
+		}
+	}
+	assert.Equal(t, []string{"valid"}, loaded)
+}
+
+func countURL(deliveries []*resources.WebhookDelivery, url string) int {
+	n := 0
+	for _, delivery := range deliveries {
+		if delivery.URL == url {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRouterWebhookPayload(t *testing.T) {
+	db := testutil.CreateTestDB(t)
+	defer db.Close()
+
+	receiver := newWebhookReceiver(t)
+	configDir := t.TempDir()
+	writeDpdTestCollection(t, configDir, "accounts", fmt.Sprintf(`{
+		"properties": {
+			"name":     {"type": "string"},
+			"email":    {"type": "string", "readable": "owner"},
+			"notes":    {"type": "string", "readable": ["staff"]},
+			"internal": {"type": "string", "readable": "never"},
+			"quiet":    {"type": "boolean"}
+		},
+		"eventConfig": {"get": {"runtime": "js"}},
+		"webhooks": [{"url": "%s/accounts"}]
+	}`, receiver.URL), "")
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "accounts", "get.js"), []byte(`function Run(context) {
+		if (context.data.quiet) {
+			context.cancel('hidden', 404);
+		}
+		context.data.label = 'seen ' + context.data.name;
+	}`), 0644))
+
+	r := router.New(db, true, configDir)
+	defer r.Close()
+
+	status, ann := postJSON(t, r, "/accounts", map[string]interface{}{
+		"name": "Ann", "email": "ann@example.com", "notes": "vip", "internal": "x",
+	})
+	require.Equal(t, http.StatusOK, status, ann)
+	status, bob := postJSON(t, r, "/accounts", map[string]interface{}{"name": "Bob", "quiet": true})
+	require.Equal(t, http.StatusOK, status, bob)
+	status, body := putJSON(t, r, "/accounts/"+bob["id"].(string), map[string]interface{}{"quiet": false})
+	require.Equal(t, http.StatusOK, status, body)
+
+	accounts := r.GetCollection("accounts")
+	require.NotNil(t, accounts)
+	deliveries, err := accounts.WebhookDeliveries(context.Background(), "", 100)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2, "documents the Get event rejects are not delivered")
+
+	require.Eventually(t, func() bool { return len(receiver.received("/accounts")) == 2 }, 5*time.Second, 10*time.Millisecond)
+	events := map[string]map[string]interface{}{}
+	for _, req := range receiver.received("/accounts") {
+		events[req.payload["event"].(string)] = req.payload
+	}
+
+	t.Run("sends what anyone may read", func(t *testing.T) {
+		data := events["created"]["data"].(map[string]interface{})
+		assert.Equal(t, ann["id"], data["id"])
+		assert.Equal(t, "Ann", data["name"])
+		assert.Equal(t, "seen Ann", data["label"], "the Get event runs")
+		for _, field := range []string{"email", "notes", "internal"} {
+			assert.NotContains(t, data, field)
+		}
+	})
+
+	t.Run("leaves out previous versions the Get event rejects", func(t *testing.T) {
+		updated := events["updated"]
+		assert.Equal(t, bob["id"], updated["data"].(map[string]interface{})["id"])
+		assert.NotContains(t, updated, "previous")
+	})
+}
@@ -0,0 +1,84 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNext(t *testing.T) {
+	start := time.Date(2024, time.June, 12, 10, 30, 15, 0, time.UTC) // A Wednesday
+	cases := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.June, 12, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.June, 12, 10, 45, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2024, time.June, 12, 10, 45, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, time.June, 13, 3, 0, 0, 0, time.UTC)},
+		{"30 9-17 * * mon-fri", time.Date(2024, time.June, 12, 11, 30, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2024, time.June, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.June, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1,15 * *", time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * fri", time.Date(2024, time.June, 13, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.June, 12, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.June, 13, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, time.June, 16, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2024, time.June, 12, 10, 31, 45, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		s, err := Parse(c.expr)
+		require.NoError(t, err, c.expr)
+		assert.Equal(t, c.next, s.Next(start), c.expr)
+	}
+}
+
+func TestNextInLocation(t *testing.T) {
+	vienna, err := time.LoadLocation("Europe/Vienna")
+	require.NoError(t, err)
+
+	s, err := Parse("30 2 * * *")
+	require.NoError(t, err)
+	// 2:30 does not exist on the night clocks are set forward
+	next := s.Next(time.Date(2024, time.March, 30, 12, 0, 0, 0, vienna))
+	assert.Equal(t, time.Date(2024, time.April, 1, 2, 30, 0, 0, vienna), next)
+
+	s, err = Parse("0 9 * * *")
+	require.NoError(t, err)
+	next = s.Next(time.Date(2024, time.June, 12, 8, 0, 0, 0, time.UTC).In(vienna))
+	assert.Equal(t, time.Date(2024, time.June, 13, 9, 0, 0, 0, vienna), next)
+}
+
+func TestNeverFires(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	require.NoError(t, err)
+	assert.True(t, s.Next(time.Now()).IsZero())
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+		"@every 10ms",
+		"@every soon",
+		"@often",
+	} {
+		_, err := Parse(expr)
+		assert.Error(t, err, expr)
+	}
+}
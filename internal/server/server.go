@@ -169,7 +169,11 @@ func New(config *Config) (*Server, error) {
 	// Initialize realtime hub if WebSocket is enabled
 	if realtimeConfig.Enabled {
 		s.realtimeHub = realtime.NewHub(jwtManager, realtimeConfig)
+		s.realtimeHub.SetSecurityRules(&securityConfig.Realtime)
 		go s.realtimeHub.Run()
+		metrics.GetGlobalCollector().SetStatsSource("realtime_broker", func() interface{} {
+			return s.realtimeHub.BrokerStats()
+		})
 		logging.Info("WebSocket hub initialized and running", "realtime", nil)
 	} else {
 		logging.Info("WebSocket disabled in configuration", "realtime", nil)
@@ -191,6 +195,8 @@ func New(config *Config) (*Server, error) {
 
 	// Start background jobs
 	go s.startUserCleanupJob()
+	s.router.StartCronJobs()
+	s.router.StartQueues()
 
 	return s, nil
 }
@@ -201,6 +207,7 @@ func (s *Server) setupRoutes() {
 
 	// WebSocket endpoint for real-time features
 	s.httpMux.HandleFunc("/socket.io/", s.handleWebSocket)
+	s.httpMux.HandleFunc("/realtime/sse", s.handleSSE).Methods("GET")
 
 	// Admin API routes
 	s.adminHandler.RegisterRoutes(s.httpMux)
@@ -316,6 +323,15 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	s.realtimeHub.HandleWebSocket(w, r)
 }
 
+// handleSSE streams realtime messages as server-sent events
+func (s *Server) handleSSE(w http.ResponseWriter, r *http.Request) {
+	if !s.realtimeConfig.Enabled || s.realtimeHub == nil {
+		http.Error(w, "Realtime not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	s.realtimeHub.HandleSSE(w, r)
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.httpMux.ServeHTTP(w, r)
 }
@@ -325,11 +341,13 @@ func (s *Server) Close() error {
 
 	// Close WebSocket hub if it exists
 	if s.realtimeHub != nil {
-		// Note: We would implement a Close() method on the Hub
-		// For now, we just log the shutdown
 		logging.Info("Closing WebSocket hub", "server", nil)
+		s.realtimeHub.Close()
 	}
 
+	// Stop sending webhooks, running cron jobs and running queued jobs
+	s.router.Close()
+
 	// Shutdown V8 pool for JavaScript events
 	if v8Pool := events.GetV8Pool(); v8Pool != nil {
 		v8Pool.Shutdown()
@@ -682,6 +700,7 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	var userID, username string
 	var isRoot bool
+	var roles []string
 	var userData map[string]interface{}
 
 	// Check for master key authentication
@@ -706,6 +725,7 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		username = getStringFromMap(user, "username")
 		role := getStringFromMap(user, "role")
 		isRoot = (role == "admin")
+		roles = auth.UserRoles(user)
 
 		// Remove password and other sensitive fields from user data
 		userData = make(map[string]interface{})
@@ -721,7 +741,7 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Generate JWT token
-	token, err := s.jwtManager.GenerateToken(userID, username, isRoot)
+	token, err := s.jwtManager.GenerateToken(userID, username, isRoot, roles...)
 	if err != nil {
 		logging.Error("Failed to generate JWT token", "auth", map[string]interface{}{
 			"error": err.Error(),
@@ -784,6 +804,7 @@ func (s *Server) handleTokenValidation(w http.ResponseWriter, r *http.Request) {
 		"userID":   claims.UserID,
 		"username": claims.Username,
 		"isRoot":   claims.IsRoot,
+		"roles":    claims.Roles,
 		"exp":      claims.ExpiresAt.Unix(),
 	})
 }
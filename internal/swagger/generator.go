@@ -3,6 +3,7 @@ package swagger
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/hjanuschka/go-deployd/internal/resources"
@@ -83,9 +84,9 @@ func (g *Generator) GenerateSpec() (*OpenAPISpec, error) {
 	// Add authentication endpoints
 	g.addAuthPaths(spec)
 
-	// Add collection endpoints
+	// Add collection and endpoint paths
 	for _, collection := range g.collections {
-		g.addCollectionPaths(spec, collection)
+		g.addResourcePaths(spec, collection)
 	}
 
 	return spec, nil
@@ -113,10 +114,20 @@ func (g *Generator) GenerateCollectionSpec(collection resources.Resource) (*Open
 		},
 	}
 
-	g.addCollectionPaths(spec, collection)
+	g.addResourcePaths(spec, collection)
 	return spec, nil
 }
 
+// addResourcePaths adds the paths of a collection, or the routes of an
+// endpoint
+func (g *Generator) addResourcePaths(spec *OpenAPISpec, resource resources.Resource) {
+	if endpoint, ok := resource.(*resources.Endpoint); ok {
+		g.addEndpointPaths(spec, endpoint)
+		return
+	}
+	g.addCollectionPaths(spec, resource)
+}
+
 func (g *Generator) generateSecuritySchemes() map[string]interface{} {
 	return map[string]interface{}{
 		"BearerAuth": map[string]interface{}{
@@ -476,6 +487,99 @@ func (g *Generator) addCollectionPaths(spec *OpenAPISpec, collection resources.R
 	}
 }
 
+// addEndpointPaths adds the routes of an endpoint with the request and
+// response schemas they declare
+func (g *Generator) addEndpointPaths(spec *OpenAPISpec, endpoint *resources.Endpoint) {
+	name := endpoint.GetName()
+	base := strings.TrimSuffix(endpoint.GetPath(), "/")
+	security := []map[string][]string{
+		{"BearerAuth": {}},
+		{"MasterKey": {}},
+	}
+
+	for _, route := range endpoint.GetConfig().Routes {
+		// OpenAPI writes path parameters as {name}
+		segments := strings.Split(strings.Trim(route.Path, "/"), "/")
+		for i, segment := range segments {
+			if strings.HasPrefix(segment, ":") {
+				segments[i] = "{" + segment[1:] + "}"
+			}
+		}
+		path := base
+		if joined := strings.Join(segments, "/"); joined != "" {
+			path += "/" + joined
+		}
+
+		parameters := []interface{}{}
+		for _, param := range route.Params() {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     param,
+				"in":       "path",
+				"required": true,
+				"schema": map[string]interface{}{
+					"type": "string",
+				},
+			})
+		}
+
+		status := route.Status
+		if status == 0 {
+			status = 200
+		}
+		response := map[string]interface{}{
+			"description": "Successful response",
+		}
+		if route.Response != nil {
+			response["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": g.generatePropertySchema(*route.Response),
+				},
+			}
+		}
+		responses := map[string]interface{}{
+			fmt.Sprint(status): response,
+		}
+		if route.Request != nil {
+			responses["400"] = map[string]interface{}{
+				"description": "Request body does not match the schema",
+			}
+		}
+
+		operation := OpenAPIPath{
+			Summary:     route.Summary,
+			Description: route.Description,
+			OperationID: fmt.Sprintf("%s%s", strings.ToLower(route.Method), strings.Title(name)+strings.Title(route.Handler)),
+			Tags:        []string{strings.Title(name)},
+			Parameters:  parameters,
+			Security:    security,
+			Responses:   responses,
+		}
+		if operation.Summary == "" {
+			operation.Summary = fmt.Sprintf("%s %s", route.Method, path)
+		}
+		if route.Request != nil {
+			// Request schemas are objects whether or not they say so
+			request := *route.Request
+			request.Type = "object"
+			operation.RequestBody = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": g.generatePropertySchema(request),
+					},
+				},
+			}
+		}
+
+		operations, ok := spec.Paths[path].(map[string]interface{})
+		if !ok {
+			operations = make(map[string]interface{})
+			spec.Paths[path] = operations
+		}
+		operations[strings.ToLower(route.Method)] = operation
+	}
+}
+
 func (g *Generator) generateCollectionSchema(collection resources.Resource) map[string]interface{} {
 	// Try to get collection config if it's a Collection type
 	if coll, ok := collection.(*resources.Collection); ok {
@@ -531,7 +635,8 @@ func (g *Generator) generateSchemaFromProperties(properties map[string]resources
 		propSchema := g.generatePropertySchema(prop)
 		schemaProps[name] = propSchema
 
-		if prop.Required {
+		// Fields clients can never write are filled in by the server
+		if prop.Required && !isNever(prop.Writable) {
 			required = append(required, name)
 		}
 	}
@@ -558,10 +663,32 @@ func (g *Generator) generatePropertySchema(prop resources.Property) map[string]i
 		schema["format"] = "date-time"
 	case "array":
 		schema["type"] = "array"
-		schema["items"] = map[string]interface{}{"type": "string"}
+		if prop.Items != nil {
+			schema["items"] = g.generatePropertySchema(*prop.Items)
+		} else {
+			schema["items"] = map[string]interface{}{"type": "string"}
+		}
 	case "object":
 		schema["type"] = "object"
+		if len(prop.Properties) > 0 {
+			properties := make(map[string]interface{})
+			required := []string{}
+			for name, child := range prop.Properties {
+				properties[name] = g.generatePropertySchema(child)
+				if child.Required {
+					required = append(required, name)
+				}
+			}
+			schema["properties"] = properties
+			if len(required) > 0 {
+				sort.Strings(required)
+				schema["required"] = required
+			}
+		}
 		schema["additionalProperties"] = true
+	case "reference":
+		schema["type"] = "string"
+		schema["x-reference"] = prop.Collection
 	default:
 		schema["type"] = "string"
 	}
@@ -570,9 +697,67 @@ func (g *Generator) generatePropertySchema(prop resources.Property) map[string]i
 		schema["default"] = prop.Default
 	}
 
+	// Validation rules; minLength/maxLength on arrays become minItems/maxItems
+	if prop.MinLength != nil {
+		if prop.Type == "array" {
+			schema["minItems"] = *prop.MinLength
+		} else {
+			schema["minLength"] = *prop.MinLength
+		}
+	}
+	if prop.MaxLength != nil {
+		if prop.Type == "array" {
+			schema["maxItems"] = *prop.MaxLength
+		} else {
+			schema["maxLength"] = *prop.MaxLength
+		}
+	}
+	if prop.Min != nil {
+		schema["minimum"] = *prop.Min
+	}
+	if prop.Max != nil {
+		schema["maximum"] = *prop.Max
+	}
+	if prop.Pattern != "" {
+		schema["pattern"] = prop.Pattern
+	}
+	if len(prop.Enum) > 0 {
+		schema["enum"] = prop.Enum
+	}
+	switch prop.Format {
+	case "":
+	case resources.FormatURL:
+		schema["format"] = "uri"
+	default:
+		schema["format"] = prop.Format
+	}
+
+	// Field access rules are documented with vendor extensions; "never" also
+	// maps onto readOnly/writeOnly so clients drop the field from requests or
+	// responses
+	if prop.Readable != nil {
+		schema["x-readable"] = prop.Readable
+	}
+	if prop.Writable != nil {
+		schema["x-writable"] = prop.Writable
+	}
+	switch {
+	case isNever(prop.Writable) && isNever(prop.Readable):
+		// readOnly and writeOnly cannot both be set
+	case isNever(prop.Writable):
+		schema["readOnly"] = true
+	case isNever(prop.Readable):
+		schema["writeOnly"] = true
+	}
+
 	return schema
 }
 
+// isNever reports whether a field access rule denies everyone but root
+func isNever(access *resources.FieldAccess) bool {
+	return access != nil && access.Level == resources.FieldAccessNever
+}
+
 func (g *Generator) generateQueryParameters() []interface{} {
 	return []interface{}{
 		map[string]interface{}{
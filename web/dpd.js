@@ -31,6 +31,7 @@
             this.eventHandlers = new Map();
             this.messageId = 0;
             this.pendingRequests = new Map();
+            this.subscriptions = new Map();
             
             // Auto-connect WebSocket if not disabled
             if (options.realtime !== false) {
@@ -80,6 +81,7 @@
                         token: this.authToken
                     });
                 }
+                this.resubscribe();
                 
                 this.emit('connect');
             };
@@ -116,7 +118,7 @@
          * Handle incoming WebSocket messages
          */
         handleWebSocketMessage(message) {
-            const { type, event, data, room, error } = message;
+            const { type, event, data, room, error, meta } = message;
 
             if (error) {
                 console.error('WebSocket error:', error);
@@ -135,7 +137,24 @@
                     }
                     break;
                     
+                case 'subscribed':
+                    this.emit('subscribed', data);
+                    break;
+                    
                 case 'emit':
+                    // Changes matching query subscriptions go to their handlers
+                    if (meta && meta.subscriptions) {
+                        meta.subscriptions.forEach(id => {
+                            const subscription = this.subscriptions.get(id);
+                            if (subscription && subscription.handler) {
+                                try {
+                                    subscription.handler(event, data);
+                                } catch (err) {
+                                    console.error('Error in subscription handler:', err);
+                                }
+                            }
+                        });
+                    }
                     // Handle collection events from emit messages
                     if (room && room.startsWith('collection:')) {
                         this.handleCollectionChange(room, event, data);
@@ -148,6 +167,12 @@
                     this.handleCollectionChange(room, event, data);
                     break;
                     
+                case 'presence':
+                    // join, leave and update carry a member, state the members
+                    this.emit(`presence:${event}`, data, room);
+                    this.emit('presence', { event, room, data });
+                    break;
+                    
                 default:
                     console.log('Unknown WebSocket message type:', type);
             }
@@ -205,6 +230,77 @@
             }
         }
 
+        /**
+         * Ask who is in a joined room; the members arrive as a
+         * 'presence:state' event
+         */
+        presence(room) {
+            this.sendWebSocketMessage({
+                type: 'presence',
+                room: room
+            });
+        }
+
+        /**
+         * Update your presence metadata in a joined room, e.g. typing or
+         * cursor state. Keys set to null are removed.
+         */
+        setPresence(room, meta) {
+            this.sendWebSocketMessage({
+                type: 'presence',
+                event: 'update',
+                room: room,
+                data: meta
+            });
+        }
+
+        /**
+         * Subscribe to the changes of a collection that match a query. The
+         * handler is called with the event type and the document, as the
+         * user may read it. Returns the subscription ID.
+         */
+        subscribe(collection, query, handler) {
+            if (typeof query === 'function') {
+                handler = query;
+                query = {};
+            }
+            const id = `sub_${++this.messageId}`;
+            this.subscriptions.set(id, { collection, query: query || {}, handler });
+            if (this.socketReady) {
+                this.sendSubscription(id);
+            }
+            return id;
+        }
+
+        /**
+         * End a query subscription
+         */
+        unsubscribe(id) {
+            if (this.subscriptions.delete(id) && this.socketReady) {
+                this.sendWebSocketMessage({
+                    type: 'unsubscribe',
+                    id: id
+                });
+            }
+        }
+
+        sendSubscription(id) {
+            const { collection, query } = this.subscriptions.get(id);
+            this.sendWebSocketMessage({
+                type: 'subscribe',
+                id: id,
+                collection: collection,
+                query: query
+            });
+        }
+
+        /**
+         * Subscribe again, after reconnecting or authenticating as another user
+         */
+        resubscribe() {
+            this.subscriptions.forEach((subscription, id) => this.sendSubscription(id));
+        }
+
         /**
          * Emit a custom event
          */
@@ -269,6 +365,7 @@
                     type: 'auth',
                     token: token
                 });
+                this.resubscribe();
             }
         }
 
@@ -439,6 +536,17 @@
             this.client.off(mappedEvent, handler);
         }
 
+        /**
+         * Subscribe to the changes of documents matching a query
+         */
+        subscribe(query, handler) {
+            return this.client.subscribe(this.name, query, handler);
+        }
+
+        unsubscribe(id) {
+            this.client.unsubscribe(id);
+        }
+
         /**
          * Flatten query object for URL parameters
          */